@@ -14,6 +14,14 @@ func (m *Manager) CleanupUserCgroup(uid int) error {
 	defer m.mu.Unlock()
 
 	cgroupPath, exists := m.createdCgroups[uid]
+	if m.UsesSystemdSlices() {
+		// La slice appartiene a systemd: si tolgono i limiti senza rimuoverla
+		delete(m.createdCgroups, uid)
+		if !exists {
+			return nil
+		}
+		return m.resetUserSlice(uid, cgroupPath)
+	}
 	if !exists {
 		// Se non è nel nostro tracciamento, prova comunque a trovare il path
 		cgroupPath = m.getUserCgroupPath(uid)
@@ -71,6 +79,11 @@ func (m *Manager) CleanupUserCgroup(uid int) error {
 
 // CleanupAll removes all created cgroups (used during shutdown).
 func (m *Manager) CleanupAll() error {
+	if m.UsesSystemdSlices() {
+		m.wg.Wait()
+		return m.cleanupUserSlices()
+	}
+
 	m.mu.Lock()
 	m.logger.Info("Starting cgroup cleanup", "tracked_count", len(m.createdCgroups))
 
@@ -103,6 +116,19 @@ func (m *Manager) CleanupAll() error {
 		entries, err := os.ReadDir(sharedPath)
 		if err == nil {
 			for _, entry := range entries {
+				// Directory non user_* sono cgroup di gruppo (limited/<group>/user_<uid>)
+				if entry.IsDir() && !strings.HasPrefix(entry.Name(), "user_") {
+					groupPath := filepath.Join(sharedPath, entry.Name())
+					m.logger.Info("Removing group sub-cgroup", "path", groupPath)
+					if err := m.RemoveGroupCgroup(groupPath); err != nil {
+						m.logger.Warn("Failed to remove group sub-cgroup",
+							"path", groupPath,
+							"error", err,
+						)
+						cleanupErrs = append(cleanupErrs, fmt.Sprintf("group cgroup %s: %v", groupPath, err))
+					}
+					continue
+				}
 				if entry.IsDir() && strings.HasPrefix(entry.Name(), "user_") {
 					userPath := filepath.Join(sharedPath, entry.Name())
 					m.logger.Info("Removing user sub-cgroup", "path", userPath)
@@ -22,6 +22,16 @@ func (m *Manager) CreateUserCgroup(uid int) error {
 
 	cgroupPath := m.getUserCgroupPath(uid)
 
+	// La slice systemd esiste gia': va solo tracciata, senza salvarla nel file
+	// di tracciamento (la pulizia all'avvio la rimuoverebbe)
+	if m.UsesSystemdSlices() {
+		if _, err := os.Stat(cgroupPath); err != nil {
+			return fmt.Errorf("systemd slice %s for UID %d not found: %w", cgroupPath, uid, err)
+		}
+		m.createdCgroups[uid] = cgroupPath
+		return nil
+	}
+
 	// Crea la directory del cgroup
 	if err := os.MkdirAll(cgroupPath, 0755); err != nil {
 		return fmt.Errorf("failed to create cgroup directory %s for UID %d: %w", cgroupPath, uid, err)
@@ -105,6 +115,11 @@ func (m *Manager) ApplyCPULimit(uid int, quota string) error {
 		}
 	}
 
+	// Le slice systemd contengono gia' tutti i processi dell'utente
+	if m.UsesSystemdSlices() {
+		return nil
+	}
+
 	// Sposta processi in modo sincrono con timeout configurabile
 	timeout := time.Duration(m.cfg.GetCgroupOperationTimeout()) * time.Second
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
@@ -138,12 +153,48 @@ func (m *Manager) ApplyCPULimit(uid int, quota string) error {
 	return nil
 }
 
+// ApplyPolicyCPULimit imposta cpu.max sul cgroup in cui si trovano i processi
+// dell'utente: il sottocgroup dentro "limited" se l'utente e' limitato,
+// altrimenti il suo cgroup utente. A differenza di ApplyCPULimit non sposta
+// processi, quindi non toglie l'utente dal cgroup condiviso.
+func (m *Manager) ApplyPolicyCPULimit(uid int, quota string) error {
+	if !isValidCPUQuotaFormat(quota) {
+		return fmt.Errorf("invalid CPU quota format: %s", quota)
+	}
+
+	cgroupPath, ok := m.getLimitCgroupPath(uid)
+	if !ok {
+		if err := m.CreateUserCgroup(uid); err != nil {
+			return fmt.Errorf("failed to create cgroup before applying policy CPU limit for UID %d: %w", uid, err)
+		}
+		cgroupPath = m.getUserCgroupPath(uid)
+	}
+
+	cpuMaxFile := filepath.Join(cgroupPath, "cpu.max")
+	if err := os.WriteFile(cpuMaxFile, []byte(quota), 0644); err != nil {
+		return fmt.Errorf("failed to apply policy CPU limit %s to %s for UID %d: %w", quota, cpuMaxFile, uid, err)
+	}
+
+	m.logger.Debug("Policy CPU limit applied",
+		"uid", uid,
+		"path", cgroupPath,
+		"quota", quota,
+	)
+	return nil
+}
+
 // ApplyCPUWeight applica un peso CPU (proporzionale) a un cgroup utente.
 func (m *Manager) ApplyCPUWeight(uid int, weight int) error {
 	cgroupPath := m.getUserCgroupPath(uid)
+	// Se l'utente e' nel cgroup condiviso il peso va sul suo sottocgroup,
+	// dove compete con gli altri utenti limitati
+	subPath, inShared := m.getUserSubCgroupPath(uid)
+	if inShared {
+		cgroupPath = subPath
+	}
 
 	// Verifica che la directory esista
-	if _, err := os.Stat(cgroupPath); os.IsNotExist(err) {
+	if _, err := os.Stat(cgroupPath); os.IsNotExist(err) && !inShared {
 		// Crea il cgroup se non esiste
 		if err := m.CreateUserCgroup(uid); err != nil {
 			return fmt.Errorf("failed to create cgroup before applying weight: %w", err)
@@ -0,0 +1,169 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+// cgroup/cpuset.go
+package cgroup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fdefilippo/resman/config"
+)
+
+// defaultSysCPUPath e' la radice sysfs di CPU (cpu/online) e nodi NUMA
+// (node/node<N>/cpulist).
+const defaultSysCPUPath = "/sys/devices/system"
+
+// getSysCPUPath restituisce la radice sysfs della topologia CPU.
+func (m *Manager) getSysCPUPath() string {
+	if m.sysCPUPath != "" {
+		return m.sysCPUPath
+	}
+	return defaultSysCPUPath
+}
+
+// readCPUListFile legge un file sysfs nel formato lista di CPU.
+func readCPUListFile(path string) ([]int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return config.ParseCPUList(string(data))
+}
+
+// OnlineCPUs restituisce le CPU attualmente online. Cambia con l'hotplug.
+func (m *Manager) OnlineCPUs() ([]int, error) {
+	cpus, err := readCPUListFile(filepath.Join(m.getSysCPUPath(), "cpu", "online"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read online CPUs: %w", err)
+	}
+	if len(cpus) == 0 {
+		return nil, fmt.Errorf("no online CPUs reported in %s", m.getSysCPUPath())
+	}
+	return cpus, nil
+}
+
+// LimitedCPUSet calcola le CPU del sottoalbero limited: tutte quelle online
+// tranne le riservate al sistema. Le riservate sono CPUSET_RESERVED_CPUS se
+// impostato, altrimenti le prime MIN_SYSTEM_CORES CPU online, prese dal nodo
+// CPUSET_RESERVED_NUMA_NODE se indicato. Le CPU riservate offline non
+// contano: dopo un hotplug il calcolo va ripetuto.
+func (m *Manager) LimitedCPUSet() (allowed string, reserved string, err error) {
+	online, err := m.OnlineCPUs()
+	if err != nil {
+		return "", "", err
+	}
+	isOnline := make(map[int]bool, len(online))
+	for _, cpu := range online {
+		isOnline[cpu] = true
+	}
+
+	reservedList, numaNode := m.cfg.GetCPUSetReserved()
+	var candidates []int
+	count := m.cfg.GetMinSystemCores()
+	switch {
+	case reservedList != "":
+		if candidates, err = config.ParseCPUList(reservedList); err != nil {
+			return "", "", err
+		}
+		count = len(candidates)
+	case numaNode >= 0:
+		nodeList := filepath.Join(m.getSysCPUPath(), "node", fmt.Sprintf("node%d", numaNode), "cpulist")
+		if candidates, err = readCPUListFile(nodeList); err != nil {
+			return "", "", fmt.Errorf("failed to read CPUs of NUMA node %d: %w", numaNode, err)
+		}
+	default:
+		candidates = online
+	}
+
+	isReserved := make(map[int]bool)
+	var reservedCPUs []int
+	for _, cpu := range candidates {
+		if len(reservedCPUs) >= count {
+			break
+		}
+		if isOnline[cpu] {
+			isReserved[cpu] = true
+			reservedCPUs = append(reservedCPUs, cpu)
+		}
+	}
+
+	var allowedCPUs []int
+	for _, cpu := range online {
+		if !isReserved[cpu] {
+			allowedCPUs = append(allowedCPUs, cpu)
+		}
+	}
+	if len(allowedCPUs) == 0 {
+		return "", "", fmt.Errorf("no online CPUs left for limited users (online %s, reserved %s)",
+			config.FormatCPUList(online), config.FormatCPUList(reservedCPUs))
+	}
+	return config.FormatCPUList(allowedCPUs), config.FormatCPUList(reservedCPUs), nil
+}
+
+// ApplySharedCPUSet scrive cpuset.cpus del cgroup condiviso; cpus vuoto
+// toglie il confinamento (il cgroup eredita le CPU del padre). Con le slice
+// systemd user.slice contiene anche le sessioni di root e il cpuset va
+// invece sulle slice degli utenti limitati, anche quelle aggiunte dopo.
+func (m *Manager) ApplySharedCPUSet(sharedPath string, cpus string) error {
+	m.mu.Lock()
+	m.limitedCPUs = cpus
+	slices := make(map[int]string, len(m.userSubCgroups))
+	for uid, path := range m.userSubCgroups {
+		slices[uid] = path
+	}
+	m.mu.Unlock()
+
+	if m.UsesSystemdSlices() {
+		var errs []string
+		for uid, slicePath := range slices {
+			if err := writeCPUSetCPUs(slicePath, cpus); err != nil {
+				errs = append(errs, fmt.Sprintf("UID %d: %v", uid, err))
+			}
+		}
+		if len(errs) > 0 {
+			return fmt.Errorf("failed to apply cpuset to user slices: %s", strings.Join(errs, "; "))
+		}
+		return nil
+	}
+
+	if err := writeCPUSetCPUs(sharedPath, cpus); err != nil {
+		return fmt.Errorf("failed to apply cpuset %q to %s: %w", cpus, sharedPath, err)
+	}
+	m.logger.Debug("Shared cpuset applied", "path", sharedPath, "cpus", cpus)
+	return nil
+}
+
+// applySliceCPUSet applica il cpuset corrente a una slice utente appena limitata.
+func (m *Manager) applySliceCPUSet(uid int, slicePath string) {
+	m.mu.RLock()
+	cpus := m.limitedCPUs
+	m.mu.RUnlock()
+	if cpus == "" {
+		return
+	}
+	if err := writeCPUSetCPUs(slicePath, cpus); err != nil {
+		m.logger.Warn("Failed to apply cpuset to user slice", "uid", uid, "cpus", cpus, "error", err)
+	}
+}
+
+// writeCPUSetCPUs scrive cpuset.cpus di un cgroup.
+func writeCPUSetCPUs(cgroupPath, cpus string) error {
+	return os.WriteFile(filepath.Join(cgroupPath, "cpuset.cpus"), []byte(cpus), defaultFilePerm)
+}
@@ -0,0 +1,88 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+package cgroup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fdefilippo/resman/config"
+	"github.com/fdefilippo/resman/logging"
+)
+
+// writeSysCPUFile scrive un file della topologia CPU sotto la radice sysfs di test.
+func writeSysCPUFile(t *testing.T, sysCPU, name, content string) {
+	t.Helper()
+	path := filepath.Join(sysCPU, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLimitedCPUSet(t *testing.T) {
+	sysCPU := t.TempDir()
+	writeSysCPUFile(t, sysCPU, "cpu/online", "0-7\n")
+	writeSysCPUFile(t, sysCPU, "node/node0/cpulist", "0-3\n")
+	writeSysCPUFile(t, sysCPU, "node/node1/cpulist", "4-7\n")
+
+	cfg := config.DefaultConfig()
+	cfg.CPUSetIsolation = true
+	cfg.MinSystemCores = 2
+	manager := &Manager{cfg: cfg, logger: logging.GetLogger(), sysCPUPath: sysCPU}
+
+	tests := []struct {
+		name     string
+		reserved string
+		node     int
+		online   string
+		allowed  string
+		expected string
+	}{
+		{"lowest online cores", "", -1, "0-7", "2-7", "0-1"},
+		{"NUMA node", "", 1, "0-7", "0-3,6-7", "4-5"},
+		{"explicit list", "0,4", -1, "0-7", "1-3,5-7", "0,4"},
+		{"reserved core unplugged", "", -1, "1-7", "3-7", "1-2"},
+		{"CPU hot-added", "", -1, "0-9", "2-9", "0-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg.CPUSetReservedCPUs = tt.reserved
+			cfg.CPUSetReservedNUMANode = tt.node
+			writeSysCPUFile(t, sysCPU, "cpu/online", tt.online+"\n")
+
+			allowed, reserved, err := manager.LimitedCPUSet()
+			if err != nil {
+				t.Fatalf("LimitedCPUSet() error: %v", err)
+			}
+			if allowed != tt.allowed || reserved != tt.expected {
+				t.Errorf("LimitedCPUSet() = %q reserved %q, expected %q reserved %q", allowed, reserved, tt.allowed, tt.expected)
+			}
+		})
+	}
+
+	// Nessuna CPU lasciata agli utenti limitati
+	cfg.CPUSetReservedCPUs = "0-7"
+	cfg.CPUSetReservedNUMANode = -1
+	writeSysCPUFile(t, sysCPU, "cpu/online", "0-7\n")
+	if _, _, err := manager.LimitedCPUSet(); err == nil {
+		t.Error("reserving every online CPU should fail")
+	}
+}
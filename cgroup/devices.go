@@ -0,0 +1,195 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+// cgroup/devices.go
+package cgroup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultSysBlockPath e' la directory da cui vengono scoperti i dispositivi a blocchi.
+const defaultSysBlockPath = "/sys/block"
+
+// blockDeviceRefreshInterval e' ogni quanto viene riletto /sys/block, per
+// seguire dischi aggiunti o rimossi a caldo.
+const blockDeviceRefreshInterval = time.Minute
+
+// BlockDevice e' un disco intero trovato in /sys/block.
+type BlockDevice struct {
+	Name  string // es. sda, nvme0n1
+	Major int
+	Minor int
+	WWID  string // contenuto di wwid (es. naa.5000c500a1b2c3d4), vuoto se assente
+}
+
+// ID restituisce "major:minor", la chiave dei dispositivi in io.max e io.stat.
+func (d BlockDevice) ID() string {
+	return fmt.Sprintf("%d:%d", d.Major, d.Minor)
+}
+
+// Matches indica se il dispositivo corrisponde a un selettore di
+// IO_DEVICE_<device>_*: il nome, il WWID di sysfs o il WWN nella forma di
+// /dev/disk/by-id (wwn-0x<hex>).
+func (d BlockDevice) Matches(selector string) bool {
+	if selector == d.Name {
+		return true
+	}
+	if d.WWID == "" {
+		return false
+	}
+	wwid := strings.ToLower(d.WWID)
+	selector = strings.ToLower(selector)
+	if selector == wwid {
+		return true
+	}
+	if hex, ok := strings.CutPrefix(selector, "wwn-0x"); ok {
+		for _, prefix := range []string{"naa.", "eui.", "wwn-0x", "0x"} {
+			if strings.TrimPrefix(wwid, prefix) == hex {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// blockDeviceCache conserva l'ultima scansione di /sys/block.
+type blockDeviceCache struct {
+	mu        sync.Mutex
+	devices   []BlockDevice
+	scannedAt time.Time
+}
+
+// readWWID legge il WWID di un disco: NVMe lo espone in <dev>/wwid, SCSI/SATA
+// in <dev>/device/wwid.
+func readWWID(devPath string) string {
+	for _, file := range []string{"wwid", filepath.Join("device", "wwid")} {
+		if data, err := os.ReadFile(filepath.Join(devPath, file)); err == nil {
+			if wwid := strings.TrimSpace(string(data)); wwid != "" {
+				return wwid
+			}
+		}
+	}
+	return ""
+}
+
+// discoverBlockDevices elenca i dischi in sysBlockPath. Le partizioni vengono
+// sempre saltate: il controller io dei cgroup accetta solo dischi interi.
+func discoverBlockDevices(sysBlockPath string) ([]BlockDevice, error) {
+	entries, err := os.ReadDir(sysBlockPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", sysBlockPath, err)
+	}
+
+	devices := make([]BlockDevice, 0, len(entries))
+	for _, entry := range entries {
+		devPath := filepath.Join(sysBlockPath, entry.Name())
+		if _, err := os.Stat(filepath.Join(devPath, "partition")); err == nil {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(devPath, "dev"))
+		if err != nil {
+			continue
+		}
+		device := BlockDevice{Name: entry.Name(), WWID: readWWID(devPath)}
+		if _, err := fmt.Sscanf(strings.TrimSpace(string(data)), "%d:%d", &device.Major, &device.Minor); err != nil {
+			continue
+		}
+		devices = append(devices, device)
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].Name < devices[j].Name })
+	return devices, nil
+}
+
+// getSysBlockPath restituisce la directory dei dispositivi a blocchi.
+func (m *Manager) getSysBlockPath() string {
+	if m.sysBlockPath != "" {
+		return m.sysBlockPath
+	}
+	return defaultSysBlockPath
+}
+
+// BlockDevices restituisce tutti i dischi del sistema, riletti da /sys/block
+// al massimo ogni blockDeviceRefreshInterval.
+func (m *Manager) BlockDevices() []BlockDevice {
+	m.blockDevices.mu.Lock()
+	defer m.blockDevices.mu.Unlock()
+
+	if !m.blockDevices.scannedAt.IsZero() && time.Since(m.blockDevices.scannedAt) < blockDeviceRefreshInterval {
+		return m.blockDevices.devices
+	}
+
+	devices, err := discoverBlockDevices(m.getSysBlockPath())
+	if err != nil {
+		m.logger.Warn("Block device discovery failed", "error", err)
+	}
+	if !sameBlockDevices(devices, m.blockDevices.devices) {
+		names := make([]string, 0, len(devices))
+		for _, device := range devices {
+			names = append(names, device.Name)
+		}
+		m.logger.Info("Block devices discovered", "devices", names)
+	}
+	m.blockDevices.devices = devices
+	m.blockDevices.scannedAt = time.Now()
+	return devices
+}
+
+func sameBlockDevices(a, b []BlockDevice) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// limitedBlockDevices restituisce i dischi su cui scrivere io.max: quelli non
+// esclusi da IO_DEVICE_EXCLUDE e, se IO_DEVICE_FILTER indica un dispositivo,
+// solo quello.
+func (m *Manager) limitedBlockDevices(deviceFilter string) []BlockDevice {
+	var devices []BlockDevice
+	for _, device := range m.BlockDevices() {
+		if m.cfg.IsIODeviceExcluded(device.Name) {
+			continue
+		}
+		if deviceFilter != "" && deviceFilter != "all" && deviceFilter != device.ID() {
+			continue
+		}
+		devices = append(devices, device)
+	}
+	return devices
+}
+
+// blockDeviceName restituisce il nome del disco con l'ID "major:minor" dato,
+// o l'ID stesso se il disco non e' in /sys/block.
+func (m *Manager) blockDeviceName(id string) string {
+	for _, device := range m.BlockDevices() {
+		if device.ID() == id {
+			return device.Name
+		}
+	}
+	return id
+}
@@ -0,0 +1,113 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+package cgroup
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/fdefilippo/resman/config"
+	"github.com/fdefilippo/resman/logging"
+)
+
+// writeSysBlockDevice crea <sysBlock>/<name> con dev e, opzionalmente, wwid o partition.
+func writeSysBlockDevice(t *testing.T, sysBlock, name, dev string, files map[string]string) {
+	t.Helper()
+	devPath := filepath.Join(sysBlock, name)
+	if err := os.MkdirAll(filepath.Join(devPath, "device"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files["dev"] = dev + "\n"
+	for file, content := range files {
+		if err := os.WriteFile(filepath.Join(devPath, file), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPerDeviceIOLimits(t *testing.T) {
+	sysBlock := t.TempDir()
+	writeSysBlockDevice(t, sysBlock, "nvme0n1", "259:0", map[string]string{"wwid": "eui.0025388b71b21d4a\n"})
+	writeSysBlockDevice(t, sysBlock, "sda", "8:0", map[string]string{"device/wwid": "naa.5000c500a1b2c3d4\n"})
+	writeSysBlockDevice(t, sysBlock, "sda1", "8:1", map[string]string{"partition": "1\n"})
+	writeSysBlockDevice(t, sysBlock, "loop0", "7:0", map[string]string{})
+
+	cfg := config.DefaultConfig()
+	cfg.IODeviceDiscovery = true
+	cfg.IODeviceLimits = []*config.IODeviceLimit{
+		{Selector: "nvme0n1", ReadBPS: "max", WriteBPS: "max", ReadIOPS: -1, WriteIOPS: -1},
+		{Selector: "wwn-0x5000c500a1b2c3d4", WriteBPS: "20M", WriteIOPS: 100},
+	}
+	manager := &Manager{
+		cfg:          cfg,
+		logger:       logging.GetLogger(),
+		sysBlockPath: sysBlock,
+	}
+
+	devices := manager.BlockDevices()
+	var names []string
+	for _, device := range devices {
+		names = append(names, device.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"loop0", "nvme0n1", "sda"}) {
+		t.Fatalf("discovered devices = %v, expected loop0, nvme0n1 and sda (partitions skipped)", names)
+	}
+	if name := manager.blockDeviceName("8:0"); name != "sda" {
+		t.Errorf("blockDeviceName(8:0) = %s, expected sda", name)
+	}
+
+	// loop0 escluso da IO_DEVICE_EXCLUDE; sda trovato tramite WWN
+	spec := ioLimitSpec{readBPS: "100M", writeBPS: "50M", readIOPS: 1000, writeIOPS: 500}
+	lines := manager.ioMaxLines(spec, "all", 1.0, true)
+	expected := []string{
+		"259:0 rbps=max wbps=max riops=max wiops=max",
+		"8:0 rbps=100M wbps=20M riops=1000 wiops=100",
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("io.max lines = %q, expected %q", lines, expected)
+	}
+
+	// Il boost della remediation IO vale anche per i limiti per dispositivo
+	lines = manager.ioMaxLines(spec, "8:0", 2.0, true)
+	expected = []string{"8:0 rbps=209715200 wbps=41943040 riops=2000 wiops=200"}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("boosted io.max lines = %q, expected %q", lines, expected)
+	}
+
+	// Rimozione: tutti i dischi senza limiti, ignorando IO_DEVICE_<device>_*
+	lines = manager.ioMaxLines(ioLimitSpec{readBPS: "max", writeBPS: "max"}, "all", 1.0, false)
+	expected = []string{
+		"259:0 rbps=max wbps=max riops=max wiops=max",
+		"8:0 rbps=max wbps=max riops=max wiops=max",
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("reset io.max lines = %q, expected %q", lines, expected)
+	}
+}
+
+func TestParseIOStat(t *testing.T) {
+	stats := parseIOStat("8:0 rbytes=1024 wbytes=2048 rios=1 wios=2 dbytes=0 dios=0\n259:0 rbytes=10 wbytes=20 rios=3 wios=4\n")
+	expected := []IODeviceStats{
+		{Device: "8:0", ReadBytes: 1024, WriteBytes: 2048, ReadOps: 1, WriteOps: 2},
+		{Device: "259:0", ReadBytes: 10, WriteBytes: 20, ReadOps: 3, WriteOps: 4},
+	}
+	if !reflect.DeepEqual(stats, expected) {
+		t.Errorf("parseIOStat() = %+v, expected %+v", stats, expected)
+	}
+}
@@ -0,0 +1,198 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+// cgroup/escape.go
+package cgroup
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// I processi avviati dopo l'attivazione da un padre fuori dal cgroup
+// dell'utente (sshd, crond, systemd --user) nascono in user.slice e sfuggono
+// ai limiti. Lo sweep esamina solo i processi piu' recenti dell'ultimo visto,
+// confrontando lo start time di /proc/<pid>/stat. Un figlio di crond o sshd
+// puo' essere visto prima del setuid (ancora root): i processi esaminati che
+// non risultano sfuggiti vengono riletti per escapeRecheckSweeps sweep,
+// identificati da (pid, start time) per non confondere PID riusati.
+
+// escapeRecheckSweeps e' il numero di sweep successivi in cui un processo
+// esaminato e non sfuggito viene riletto.
+const escapeRecheckSweeps = 3
+
+// escapeProcKey identifica un processo anche se il PID viene riusato.
+type escapeProcKey struct {
+	pid       int
+	startTime uint64
+}
+
+// escapeSweepState e' lo stato conservato tra due sweep.
+type escapeSweepState struct {
+	// Start time (tick dal boot) del processo piu' recente visto
+	watermark uint64
+	// Processi da riesaminare -> sweep rimanenti
+	recheck map[escapeProcKey]int
+}
+
+// FindEscapedProcesses restituisce, per ogni UID in targets (UID -> path del
+// suo sottocgroup), i PID avviati di recente che non si trovano nel
+// sottocgroup. I processi esclusi da PROCESS_EXCLUDE_LIST vengono ignorati.
+func (m *Manager) FindEscapedProcesses(targets map[int]string) map[int][]int {
+	m.escapeMu.Lock()
+	defer m.escapeMu.Unlock()
+
+	escaped, next := findEscapedProcesses("/proc", m.cfg.CgroupRoot, targets, m.escapeSweep, m.cfg.IsProcessExcluded)
+	m.escapeSweep = next
+	return escaped
+}
+
+// findEscapedProcesses e' lo sweep su procDir. Restituisce i processi sfuggiti
+// e lo stato per lo sweep successivo. I processi con start time uguale al
+// watermark vengono riesaminati: possono essere nati dopo lo sweep precedente
+// nello stesso tick.
+func findEscapedProcesses(procDir, cgroupRoot string, targets map[int]string, state escapeSweepState, excluded func(string) bool) (map[int][]int, escapeSweepState) {
+	next := escapeSweepState{watermark: state.watermark, recheck: make(map[escapeProcKey]int)}
+	entries, err := os.ReadDir(procDir)
+	if err != nil {
+		return nil, state
+	}
+
+	escaped := make(map[int][]int)
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+		pidDir := filepath.Join(procDir, entry.Name())
+
+		startTime, err := readProcStartTime(pidDir)
+		if err != nil {
+			continue
+		}
+		key := escapeProcKey{pid: pid, startTime: startTime}
+		remaining, pending := state.recheck[key]
+		if startTime < state.watermark && !pending {
+			continue
+		}
+		if startTime > next.watermark {
+			next.watermark = startTime
+		}
+
+		if uid, ok := escapedProcess(pidDir, cgroupRoot, targets, excluded); ok {
+			escaped[uid] = append(escaped[uid], pid)
+			continue
+		}
+		if !pending {
+			remaining = escapeRecheckSweeps + 1
+		}
+		if remaining > 1 {
+			next.recheck[key] = remaining - 1
+		}
+	}
+
+	return escaped, next
+}
+
+// escapedProcess indica se il processo in pidDir appartiene a un UID di
+// targets e si trova fuori dal suo sottocgroup.
+func escapedProcess(pidDir, cgroupRoot string, targets map[int]string, excluded func(string) bool) (int, bool) {
+	if len(targets) == 0 {
+		return 0, false
+	}
+	uid, err := readProcUID(pidDir)
+	if err != nil {
+		return 0, false
+	}
+	target, ok := targets[uid]
+	if !ok {
+		return 0, false
+	}
+
+	current, err := readProcCgroup(pidDir)
+	if err != nil || cgroupContains(cgroupRoot, target, current) {
+		return 0, false
+	}
+	if comm, err := os.ReadFile(filepath.Join(pidDir, "comm")); err == nil && excluded != nil && excluded(strings.TrimSpace(string(comm))) {
+		return 0, false
+	}
+	return uid, true
+}
+
+// readProcStartTime legge lo start time del processo (campo 22 di stat, in
+// tick dal boot). Il nome del comando puo' contenere spazi e parentesi: i
+// campi si contano dall'ultima ')'.
+func readProcStartTime(pidDir string) (uint64, error) {
+	data, err := os.ReadFile(filepath.Join(pidDir, "stat"))
+	if err != nil {
+		return 0, err
+	}
+	stat := string(data)
+	end := strings.LastIndexByte(stat, ')')
+	if end < 0 {
+		return 0, fmt.Errorf("malformed stat in %s", pidDir)
+	}
+	// Dopo ") " il primo campo e' state (campo 3): starttime e' il 20esimo
+	fields := strings.Fields(stat[end+1:])
+	if len(fields) < 20 {
+		return 0, fmt.Errorf("short stat in %s", pidDir)
+	}
+	return strconv.ParseUint(fields[19], 10, 64)
+}
+
+// readProcUID legge l'UID reale del processo da status.
+func readProcUID(pidDir string) (int, error) {
+	file, err := os.Open(filepath.Join(pidDir, "status"))
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "Uid:" {
+			return strconv.Atoi(fields[1])
+		}
+	}
+	return 0, fmt.Errorf("UID not found in %s", pidDir)
+}
+
+// readProcCgroup legge il cgroup v2 del processo ("0::/path"). I processi
+// zombie non hanno cgroup e restituiscono errore.
+func readProcCgroup(pidDir string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(pidDir, "cgroup"))
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if path, ok := strings.CutPrefix(line, "0::"); ok && path != "" {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("no cgroup v2 entry in %s", pidDir)
+}
+
+// cgroupContains indica se il cgroup relativo current (come in
+// /proc/<pid>/cgroup) e' target o un suo discendente.
+func cgroupContains(cgroupRoot, target, current string) bool {
+	rel := "/" + strings.TrimPrefix(strings.TrimPrefix(target, cgroupRoot), "/")
+	return current == rel || strings.HasPrefix(current, rel+"/")
+}
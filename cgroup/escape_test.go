@@ -0,0 +1,114 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+package cgroup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeFakeProc(t *testing.T, procDir string, pid, uid int, comm string, startTime uint64, cgroupPath string) {
+	t.Helper()
+	pidDir := filepath.Join(procDir, fmt.Sprint(pid))
+	if err := os.MkdirAll(pidDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	stat := fmt.Sprintf("%d (%s) S 1 %d %d 0 -1 4194560 100 0 0 0 1 1 0 0 20 0 1 0 %d 1000 100\n", pid, comm, pid, pid, startTime)
+	files := map[string]string{
+		"stat":   stat,
+		"status": fmt.Sprintf("Name:\t%s\nUid:\t%d\t%d\t%d\t%d\n", comm, uid, uid, uid, uid),
+		"cgroup": "0::" + cgroupPath + "\n",
+		"comm":   comm + "\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(pidDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFindEscapedProcesses(t *testing.T) {
+	procDir := t.TempDir()
+	cgroupRoot := "/sys/fs/cgroup"
+	targets := map[int]string{
+		1000: "/sys/fs/cgroup/resman/limited/user_1000",
+		1001: "/sys/fs/cgroup/resman/limited/batch/user_1001",
+	}
+	excluded := func(name string) bool { return name == "sshd" }
+
+	writeFakeProc(t, procDir, 100, 1000, "make", 500, "/resman/limited/user_1000")
+	writeFakeProc(t, procDir, 101, 1000, "bash (login)", 600, "/user.slice/user-1000.slice/session-3.scope")
+	writeFakeProc(t, procDir, 102, 1001, "cc1", 700, "/resman/limited/batch/user_1001/sub")
+	writeFakeProc(t, procDir, 103, 1001, "sshd", 800, "/user.slice/user-1001.slice/session-4.scope")
+	writeFakeProc(t, procDir, 104, 1002, "vim", 900, "/user.slice/user-1002.slice/session-5.scope")
+
+	escaped, state := findEscapedProcesses(procDir, cgroupRoot, targets, escapeSweepState{}, excluded)
+	if want := map[int][]int{1000: {101}}; !reflect.DeepEqual(escaped, want) {
+		t.Errorf("escaped = %v, expected %v", escaped, want)
+	}
+	if state.watermark != 900 {
+		t.Errorf("watermark = %d, expected 900", state.watermark)
+	}
+
+	// Processi gia' esaminati non vengono riletti, solo quelli nuovi
+	writeFakeProc(t, procDir, 105, 1001, "crond-job", 1000, "/system.slice/crond.service")
+	escaped, state = findEscapedProcesses(procDir, cgroupRoot, targets, state, excluded)
+	if want := map[int][]int{1001: {105}}; !reflect.DeepEqual(escaped, want) {
+		t.Errorf("escaped after watermark = %v, expected %v", escaped, want)
+	}
+	if state.watermark != 1000 {
+		t.Errorf("watermark = %d, expected 1000", state.watermark)
+	}
+}
+
+func TestFindEscapedProcessesRecheck(t *testing.T) {
+	procDir := t.TempDir()
+	cgroupRoot := "/sys/fs/cgroup"
+	targets := map[int]string{1000: "/sys/fs/cgroup/resman/limited/user_1000"}
+
+	// Figlio di crond visto prima del setuid: ancora root
+	writeFakeProc(t, procDir, 200, 0, "crond", 500, "/system.slice/crond.service")
+	escaped, state := findEscapedProcesses(procDir, cgroupRoot, targets, escapeSweepState{}, nil)
+	if len(escaped) != 0 {
+		t.Fatalf("escaped = %v before setuid, expected none", escaped)
+	}
+
+	// Dopo il setuid lo stesso processo viene riesaminato; uno nuovo con lo
+	// stesso start time del watermark non viene saltato
+	writeFakeProc(t, procDir, 200, 1000, "backup.sh", 500, "/system.slice/crond.service")
+	writeFakeProc(t, procDir, 201, 1000, "rsync", 500, "/system.slice/crond.service")
+	escaped, state = findEscapedProcesses(procDir, cgroupRoot, targets, state, nil)
+	if want := map[int][]int{1000: {200, 201}}; !reflect.DeepEqual(escaped, want) {
+		t.Errorf("escaped after setuid = %v, expected %v", escaped, want)
+	}
+
+	// Un processo non sfuggito esce dal recheck dopo escapeRecheckSweeps sweep
+	writeFakeProc(t, procDir, 202, 0, "sshd", 600, "/system.slice/sshd.service")
+	for i := 0; i < escapeRecheckSweeps; i++ {
+		_, state = findEscapedProcesses(procDir, cgroupRoot, targets, state, nil)
+	}
+	writeFakeProc(t, procDir, 203, 0, "sshd", 700, "/system.slice/sshd.service")
+	_, state = findEscapedProcesses(procDir, cgroupRoot, targets, state, nil)
+	writeFakeProc(t, procDir, 202, 1000, "bash", 600, "/system.slice/sshd.service")
+	escaped, _ = findEscapedProcesses(procDir, cgroupRoot, targets, state, nil)
+	if len(escaped) != 0 {
+		t.Errorf("escaped = %v after the recheck window, expected none", escaped)
+	}
+}
@@ -0,0 +1,171 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+// cgroup/groups.go
+package cgroup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fdefilippo/resman/config"
+)
+
+// CreateGroupCgroup crea (o aggiorna) il cgroup di un gruppo utenti dentro il
+// cgroup condiviso e applica le quote del gruppo. L'operazione e' idempotente:
+// richiamarla riallinea i limiti alla configurazione corrente.
+func (m *Manager) CreateGroupCgroup(sharedPath string, group config.UserGroup) (string, error) {
+	if sharedPath == "" {
+		return "", fmt.Errorf("shared cgroup not initialized")
+	}
+	if m.UsesSystemdSlices() {
+		return "", fmt.Errorf("group cgroups are not supported with CGROUP_MODE=systemd-slice")
+	}
+	groupPath := filepath.Join(sharedPath, group.Name)
+
+	if err := os.MkdirAll(groupPath, 0755); err != nil {
+		return "", fmt.Errorf("failed to create group cgroup %s: %w", groupPath, err)
+	}
+
+	// I sottocgroup user_<uid> del gruppo devono poter usare gli stessi controller
+	m.enableSubtreeControllers(groupPath, "group cgroup")
+
+	// cpu.max: quota del gruppo, "max" se non definita
+	quota := group.CPUQuota
+	if quota == "" {
+		quota = "max 100000"
+	}
+	if err := os.WriteFile(filepath.Join(groupPath, "cpu.max"), []byte(quota), defaultFilePerm); err != nil {
+		return "", fmt.Errorf("failed to apply CPU quota %s to group %s: %w", quota, group.Name, err)
+	}
+
+	// cpu.weight: peso del gruppo rispetto agli altri gruppi e agli utenti non raggruppati
+	weight := group.CPUWeight
+	if weight <= 0 {
+		weight = 100
+	}
+	if err := os.WriteFile(filepath.Join(groupPath, "cpu.weight"), []byte(strconv.Itoa(weight)), defaultFilePerm); err != nil {
+		m.logger.Warn("Failed to set group CPU weight",
+			"group", group.Name, "weight", weight, "error", err)
+	}
+
+	// memory.max: solo se il controller memory e' disponibile
+	if _, err := os.Stat(filepath.Join(groupPath, "memory.max")); err == nil {
+		memLimit := group.RAMQuota
+		if memLimit == "" {
+			memLimit = "max"
+		}
+		if err := os.WriteFile(filepath.Join(groupPath, "memory.max"), []byte(memLimit), defaultFilePerm); err != nil {
+			m.logger.Warn("Failed to set group memory limit",
+				"group", group.Name, "limit", memLimit, "error", err)
+		}
+	}
+
+	// io.max: solo se il gruppo definisce limiti IO
+	if group.HasIOLimits() {
+		spec := ioLimitSpec{group.IOReadBPS, group.IOWriteBPS, group.IOReadIOPS, group.IOWriteIOPS}
+		if err := writeIOMax(filepath.Join(groupPath, "io.max"), m.ioMaxLines(spec, m.cfg.GetIODeviceFilter(), 1.0, false)); err != nil {
+			m.logger.Warn("Failed to set group IO limit",
+				"group", group.Name, "error", err)
+		}
+	}
+
+	m.logger.Debug("Group cgroup configured",
+		"group", group.Name,
+		"path", groupPath,
+		"cpu_quota", quota,
+		"cpu_weight", weight,
+		"ram_quota", group.RAMQuota,
+	)
+
+	return groupPath, nil
+}
+
+// RemoveGroupCgroup sposta fuori i processi dei sottocgroup utente del gruppo
+// e rimuove il cgroup del gruppo.
+func (m *Manager) RemoveGroupCgroup(groupPath string) error {
+	if _, err := os.Stat(groupPath); os.IsNotExist(err) {
+		return nil
+	}
+
+	rootCgroupProcs := filepath.Join(m.cfg.CgroupRoot, "cgroup.procs")
+	entries, err := os.ReadDir(groupPath)
+	if err != nil {
+		return fmt.Errorf("failed to read group cgroup %s: %w", groupPath, err)
+	}
+
+	var removeErrs []string
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), "user_") {
+			continue
+		}
+		userPath := filepath.Join(groupPath, entry.Name())
+		if pids, err := m.readPidsFromFile(filepath.Join(userPath, "cgroup.procs")); err == nil && len(pids) > 0 {
+			if err := m.writePidsBatch(rootCgroupProcs, pids); err != nil {
+				m.logger.Debug("Failed to move some processes out of group user cgroup",
+					"from", userPath, "error", err)
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		if err := os.Remove(userPath); err != nil {
+			removeErrs = append(removeErrs, fmt.Sprintf("%s: %v", userPath, err))
+			continue
+		}
+		if uid, err := strconv.Atoi(strings.TrimPrefix(entry.Name(), "user_")); err == nil {
+			m.untrackUserSubCgroup(uid)
+		}
+	}
+
+	if err := os.Remove(groupPath); err != nil {
+		removeErrs = append(removeErrs, fmt.Sprintf("%s: %v", groupPath, err))
+	}
+
+	if len(removeErrs) > 0 {
+		return fmt.Errorf("failed to remove group cgroup: %s", strings.Join(removeErrs, "; "))
+	}
+
+	m.logger.Debug("Group cgroup removed", "path", groupPath)
+	return nil
+}
+
+// trackUserSubCgroup registra il sottocgroup user_<uid> dentro "limited".
+func (m *Manager) trackUserSubCgroup(uid int, path string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.userSubCgroups == nil {
+		m.userSubCgroups = make(map[int]string)
+	}
+	m.userSubCgroups[uid] = path
+}
+
+// untrackUserSubCgroup dimentica il sottocgroup dell'utente.
+func (m *Manager) untrackUserSubCgroup(uid int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.userSubCgroups, uid)
+}
+
+// getUserSubCgroupPath restituisce il sottocgroup dell'utente dentro "limited", se presente.
+func (m *Manager) getUserSubCgroupPath(uid int) (string, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	path, ok := m.userSubCgroups[uid]
+	return path, ok
+}
@@ -9,16 +9,121 @@ import (
 )
 
 func (m *Manager) ApplyIOLimit(uid int, readBPS, writeBPS string, readIOPS, writeIOPS int, deviceFilter string) error {
-	cgroupPath, exists := m.getCgroupPath(uid)
+	return m.applyIOMax(uid, ioLimitSpec{readBPS, writeBPS, readIOPS, writeIOPS}, deviceFilter, 1.0)
+}
+
+// ioLimitSpec sono i quattro limiti di una riga io.max.
+type ioLimitSpec struct {
+	readBPS, writeBPS   string
+	readIOPS, writeIOPS int
+}
+
+// boosted restituisce i limiti moltiplicati per il boost della remediation IO.
+func (l ioLimitSpec) boosted(multiplier float64) ioLimitSpec {
+	if multiplier == 1.0 {
+		return l
+	}
+	return ioLimitSpec{
+		readBPS:   applyMultiplierToBPS(l.readBPS, multiplier),
+		writeBPS:  applyMultiplierToBPS(l.writeBPS, multiplier),
+		readIOPS:  int(float64(l.readIOPS) * multiplier),
+		writeIOPS: int(float64(l.writeIOPS) * multiplier),
+	}
+}
+
+// deviceLimits applica ai limiti globali quelli configurati per il disco
+// (IO_DEVICE_<device>_*). Vince il primo selettore dichiarato che corrisponde.
+func (m *Manager) deviceLimits(device BlockDevice, global ioLimitSpec) ioLimitSpec {
+	for _, limit := range m.cfg.GetIODeviceLimits() {
+		if !device.Matches(limit.Selector) {
+			continue
+		}
+		spec := global
+		if limit.ReadBPS != "" {
+			spec.readBPS = limit.ReadBPS
+		}
+		if limit.WriteBPS != "" {
+			spec.writeBPS = limit.WriteBPS
+		}
+		if limit.ReadIOPS != 0 {
+			spec.readIOPS = max(limit.ReadIOPS, 0)
+		}
+		if limit.WriteIOPS != 0 {
+			spec.writeIOPS = max(limit.WriteIOPS, 0)
+		}
+		return spec
+	}
+	return global
+}
+
+// ioMaxLines costruisce le righe io.max da scrivere. Con IO_DEVICE_DISCOVERY
+// c'e' una riga per ogni disco scoperto, con i limiti IO_DEVICE_<device>_* se
+// perDevice; altrimenti (o se non e' stato trovato alcun disco) una sola riga
+// per IO_DEVICE_FILTER.
+func (m *Manager) ioMaxLines(spec ioLimitSpec, deviceFilter string, multiplier float64, perDevice bool) []string {
+	if m.cfg.GetIODeviceDiscovery() {
+		devices := m.limitedBlockDevices(deviceFilter)
+		lines := make([]string, 0, len(devices))
+		for _, device := range devices {
+			limits := spec
+			if perDevice {
+				limits = m.deviceLimits(device, spec)
+			}
+			limits = limits.boosted(multiplier)
+			lines = append(lines, formatIOMaxValue(limits.readBPS, limits.writeBPS, limits.readIOPS, limits.writeIOPS, device.ID()))
+		}
+		if len(lines) > 0 {
+			return lines
+		}
+		m.logger.Debug("No block devices discovered, using a single io.max line", "device_filter", deviceFilter)
+	}
+	limits := spec.boosted(multiplier)
+	return []string{formatIOMaxValue(limits.readBPS, limits.writeBPS, limits.readIOPS, limits.writeIOPS, deviceFilter)}
+}
+
+// writeIOMax scrive le righe in io.max (o io.latency) una alla volta: il
+// kernel accetta un solo dispositivo per scrittura.
+func writeIOMax(ioMaxFile string, lines []string) error {
+	var writeErrs []string
+	for _, line := range lines {
+		if err := os.WriteFile(ioMaxFile, []byte(line), defaultFilePerm); err != nil {
+			writeErrs = append(writeErrs, fmt.Sprintf("%q: %v", line, err))
+		}
+	}
+	if len(writeErrs) > 0 {
+		return fmt.Errorf("failed to write %s: %s", ioMaxFile, strings.Join(writeErrs, "; "))
+	}
+	return nil
+}
+
+// applyIOMax scrive i limiti IO (moltiplicati per multiplier) nel cgroup di limite dell'utente.
+func (m *Manager) applyIOMax(uid int, spec ioLimitSpec, deviceFilter string, multiplier float64) error {
+	cgroupPath, exists := m.getLimitCgroupPath(uid)
 	if !exists {
 		if err := m.CreateUserCgroup(uid); err != nil {
 			return fmt.Errorf("failed to create cgroup before applying IO limit: %w", err)
 		}
-		cgroupPath, _ = m.getCgroupPath(uid)
+		cgroupPath, _ = m.getLimitCgroupPath(uid)
 	}
 
 	ioMaxFile := filepath.Join(cgroupPath, "io.max")
+	lines := m.ioMaxLines(spec, deviceFilter, multiplier, true)
+
+	if err := writeIOMax(ioMaxFile, lines); err != nil {
+		return fmt.Errorf("failed to apply IO limit for UID %d: %w", uid, err)
+	}
+
+	m.logger.Debug("IO limit applied",
+		"uid", uid,
+		"value", strings.Join(lines, ", "),
+		"path", ioMaxFile,
+	)
+
+	return nil
+}
 
+// formatIOMaxValue costruisce una riga io.max: "major:minor rbps=X wbps=Y riops=Z wiops=W".
+func formatIOMaxValue(readBPS, writeBPS string, readIOPS, writeIOPS int, deviceFilter string) string {
 	// Normalizza valori bandwidth
 	if readBPS == "" || readBPS == "0" {
 		readBPS = "max"
@@ -43,44 +148,132 @@ func (m *Manager) ApplyIOLimit(uid int, readBPS, writeBPS string, readIOPS, writ
 		device = deviceFilter
 	}
 
-	// Formato: "major:minor rbps=X wbps=Y riops=Z wiops=W"
-	value := fmt.Sprintf("%s rbps=%s wbps=%s riops=%s wiops=%s",
+	return fmt.Sprintf("%s rbps=%s wbps=%s riops=%s wiops=%s",
 		device, readBPS, writeBPS, readIOPSStr, writeIOPSStr)
+}
 
-	if err := os.WriteFile(ioMaxFile, []byte(value), defaultFilePerm); err != nil {
-		return fmt.Errorf("failed to apply IO limit for UID %d: %w", uid, err)
+// RemoveIOLimit rimuove i limiti di IO (imposta tutti i valori a "max").
+func (m *Manager) RemoveIOLimit(uid int) error {
+	cgroupPath, exists := m.getLimitCgroupPath(uid)
+	if !exists {
+		return fmt.Errorf("cgroup for UID %d not found", uid)
 	}
 
-	m.logger.Debug("IO limit applied",
+	ioMaxFile := filepath.Join(cgroupPath, "io.max")
+	return writeIOMax(ioMaxFile, m.ioMaxLines(ioLimitSpec{readBPS: "max", writeBPS: "max"}, "all", 1.0, false))
+}
+
+// clampIOWeight riporta un peso IO nell'intervallo accettato dal kernel (1-10000).
+func clampIOWeight(weight int) int {
+	return min(max(weight, 1), 10000)
+}
+
+// ApplyIOWeight applica un peso IO (proporzionale) al cgroup di limite
+// dell'utente: dentro il cgroup condiviso compete con gli altri utenti
+// limitati, come cpu.weight.
+func (m *Manager) ApplyIOWeight(uid int, weight int) error {
+	cgroupPath, exists := m.getLimitCgroupPath(uid)
+	if !exists {
+		if err := m.CreateUserCgroup(uid); err != nil {
+			return fmt.Errorf("failed to create cgroup before applying IO weight: %w", err)
+		}
+		cgroupPath, _ = m.getLimitCgroupPath(uid)
+	}
+
+	weight = clampIOWeight(weight)
+	ioWeightFile := filepath.Join(cgroupPath, "io.weight")
+	if err := os.WriteFile(ioWeightFile, []byte(fmt.Sprintf("default %d", weight)), defaultFilePerm); err != nil {
+		return fmt.Errorf("failed to apply IO weight for UID %d: %w", uid, err)
+	}
+
+	m.logger.Debug("IO weight applied",
 		"uid", uid,
-		"readBPS", readBPS,
-		"writeBPS", writeBPS,
-		"readIOPS", readIOPSStr,
-		"writeIOPS", writeIOPSStr,
-		"device", device,
-		"path", ioMaxFile,
+		"weight", weight,
+		"path", ioWeightFile,
 	)
+	return nil
+}
 
+// ApplySharedIOWeight applica il peso IO del cgroup condiviso rispetto ai
+// cgroup fratelli (utenti non limitati e resto del sistema).
+func (m *Manager) ApplySharedIOWeight(sharedPath string, weight int) error {
+	weight = clampIOWeight(weight)
+	ioWeightFile := filepath.Join(sharedPath, "io.weight")
+	if err := os.WriteFile(ioWeightFile, []byte(fmt.Sprintf("default %d", weight)), defaultFilePerm); err != nil {
+		return fmt.Errorf("failed to apply shared IO weight: %w", err)
+	}
+
+	m.logger.Debug("Shared IO weight applied",
+		"path", sharedPath,
+		"weight", weight,
+	)
 	return nil
 }
 
-// RemoveIOLimit rimuove i limiti di IO (imposta tutti i valori a "max").
-func (m *Manager) RemoveIOLimit(uid int) error {
-	cgroupPath, exists := m.getCgroupPath(uid)
+// ioLatencyLines costruisce le righe io.latency: il kernel non accetta
+// "default", serve una riga per disco. Con IO_DEVICE_FILTER="major:minor"
+// si usa quel disco, altrimenti i dischi scoperti in /sys/block.
+func (m *Manager) ioLatencyLines(target string, deviceFilter string) []string {
+	var ids []string
+	for _, device := range m.limitedBlockDevices(deviceFilter) {
+		ids = append(ids, device.ID())
+	}
+	if len(ids) == 0 && deviceFilter != "" && deviceFilter != "all" {
+		ids = append(ids, deviceFilter)
+	}
+
+	lines := make([]string, 0, len(ids))
+	for _, id := range ids {
+		lines = append(lines, fmt.Sprintf("%s target=%s", id, target))
+	}
+	return lines
+}
+
+// ApplyIOLatency imposta il target io.latency (in microsecondi) del cgroup
+// di limite dell'utente. targetUsec <= 0 rimuove il target.
+func (m *Manager) ApplyIOLatency(uid int, targetUsec int, deviceFilter string) error {
+	cgroupPath, exists := m.getLimitCgroupPath(uid)
 	if !exists {
 		return fmt.Errorf("cgroup for UID %d not found", uid)
 	}
 
-	ioMaxFile := filepath.Join(cgroupPath, "io.max")
-	return os.WriteFile(ioMaxFile, []byte("default rbps=max wbps=max riops=max wiops=max"), defaultFilePerm)
+	target := "max"
+	if targetUsec > 0 {
+		target = strconv.Itoa(targetUsec)
+	}
+	lines := m.ioLatencyLines(target, deviceFilter)
+	if len(lines) == 0 {
+		return fmt.Errorf("no block devices found for io.latency of UID %d", uid)
+	}
+
+	ioLatencyFile := filepath.Join(cgroupPath, "io.latency")
+	if err := writeIOMax(ioLatencyFile, lines); err != nil {
+		return fmt.Errorf("failed to apply IO latency target for UID %d: %w", uid, err)
+	}
+
+	m.logger.Debug("IO latency target applied",
+		"uid", uid,
+		"value", strings.Join(lines, ", "),
+		"path", ioLatencyFile,
+	)
+	return nil
 }
 
-// GetIOStats restituisce le statistiche di IO aggregate per tutti i dispositivi.
-// Legge da io.stat e somma rbytes, wbytes, rios, wios.
-func (m *Manager) GetIOStats(uid int) (readBytes, writeBytes uint64, readOps, writeOps uint64, err error) {
+// IODeviceStats sono i contatori io.stat di un utente su un disco.
+type IODeviceStats struct {
+	Device     string // nome del disco (es. sda), o "major:minor" se non e' in /sys/block
+	ReadBytes  uint64
+	WriteBytes uint64
+	ReadOps    uint64
+	WriteOps   uint64
+}
+
+// readIOStat legge io.stat del cgroup dell'utente, una voce per dispositivo
+// con Device uguale a "major:minor". Un file assente (nessun IO) non e' un errore.
+func (m *Manager) readIOStat(uid int) ([]IODeviceStats, error) {
 	cgroupPath, exists := m.getCgroupPath(uid)
 	if !exists {
-		return 0, 0, 0, 0, fmt.Errorf("cgroup for UID %d not found", uid)
+		return nil, fmt.Errorf("cgroup for UID %d not found", uid)
 	}
 
 	ioStatFile := filepath.Join(cgroupPath, "io.stat")
@@ -88,20 +281,23 @@ func (m *Manager) GetIOStats(uid int) (readBytes, writeBytes uint64, readOps, wr
 	if err != nil {
 		// Se il file non esiste (nessun IO), restituisci zero
 		if os.IsNotExist(err) {
-			return 0, 0, 0, 0, nil
+			return nil, nil
 		}
-		return 0, 0, 0, 0, fmt.Errorf("failed to read io.stat for UID %d: %w", uid, err)
+		return nil, fmt.Errorf("failed to read io.stat for UID %d: %w", uid, err)
 	}
+	return parseIOStat(string(data)), nil
+}
 
-	// Parse lines like: "8:0 rios=1234 wios=567 rbytes=104857600 wbytes=52428800"
-	for _, line := range strings.Split(string(data), "\n") {
-		line = strings.TrimSpace(line)
-		if line == "" {
+// parseIOStat interpreta righe come "8:0 rbytes=104857600 wbytes=52428800 rios=1234 wios=567".
+func parseIOStat(data string) []IODeviceStats {
+	var stats []IODeviceStats
+	for _, line := range strings.Split(data, "\n") {
+		parts := strings.Fields(line)
+		if len(parts) == 0 {
 			continue
 		}
-		// Skip device prefix (e.g., "8:0"), parse key=value pairs
-		parts := strings.Fields(line)
-		for _, part := range parts {
+		device := IODeviceStats{Device: parts[0]}
+		for _, part := range parts[1:] {
 			kv := strings.SplitN(part, "=", 2)
 			if len(kv) != 2 {
 				continue
@@ -112,34 +308,58 @@ func (m *Manager) GetIOStats(uid int) (readBytes, writeBytes uint64, readOps, wr
 			}
 			switch kv[0] {
 			case "rios":
-				readOps += val
+				device.ReadOps = val
 			case "wios":
-				writeOps += val
+				device.WriteOps = val
 			case "rbytes":
-				readBytes += val
+				device.ReadBytes = val
 			case "wbytes":
-				writeBytes += val
+				device.WriteBytes = val
 			}
 		}
+		stats = append(stats, device)
 	}
+	return stats
+}
 
+// GetIOStats restituisce le statistiche di IO aggregate per tutti i dispositivi.
+// Legge da io.stat e somma rbytes, wbytes, rios, wios.
+func (m *Manager) GetIOStats(uid int) (readBytes, writeBytes uint64, readOps, writeOps uint64, err error) {
+	stats, err := m.readIOStat(uid)
+	if err != nil {
+		return 0, 0, 0, 0, err
+	}
+	for _, device := range stats {
+		readBytes += device.ReadBytes
+		writeBytes += device.WriteBytes
+		readOps += device.ReadOps
+		writeOps += device.WriteOps
+	}
 	return readBytes, writeBytes, readOps, writeOps, nil
 }
 
-// ApplyTemporaryIOLimit applica limiti IO temporanei con un moltiplicatore.
-// Salva i limiti originali per permettere il revert.
+// GetIODeviceStats restituisce le statistiche di IO dell'utente per disco,
+// con i nomi presi da /sys/block.
+func (m *Manager) GetIODeviceStats(uid int) ([]IODeviceStats, error) {
+	stats, err := m.readIOStat(uid)
+	if err != nil {
+		return nil, err
+	}
+	for i := range stats {
+		stats[i].Device = m.blockDeviceName(stats[i].Device)
+	}
+	return stats, nil
+}
+
+// ApplyTemporaryIOLimit applica limiti IO temporanei con un moltiplicatore,
+// anche sui limiti per dispositivo. Con multiplier 1.0 ripristina i limiti normali.
 func (m *Manager) ApplyTemporaryIOLimit(uid int, readBPS, writeBPS string, readIOPS, writeIOPS int, deviceFilter string, multiplier float64) error {
 	if _, exists := m.getCgroupPath(uid); !exists {
 		return fmt.Errorf("cgroup for UID %d not found", uid)
 	}
 
 	// Applica limiti boostati (moltiplicati)
-	boostedReadBPS := applyMultiplierToBPS(readBPS, multiplier)
-	boostedWriteBPS := applyMultiplierToBPS(writeBPS, multiplier)
-	boostedReadIOPS := int(float64(readIOPS) * multiplier)
-	boostedWriteIOPS := int(float64(writeIOPS) * multiplier)
-
-	return m.ApplyIOLimit(uid, boostedReadBPS, boostedWriteBPS, boostedReadIOPS, boostedWriteIOPS, deviceFilter)
+	return m.applyIOMax(uid, ioLimitSpec{readBPS, writeBPS, readIOPS, writeIOPS}, deviceFilter, multiplier)
 }
 
 // applyMultiplierToBPS applica un moltiplicatore a una stringa BPS.
@@ -27,9 +27,33 @@ type Manager struct {
 	createdCgroups     map[int]string // UID -> cgroup path
 	createdCgroupsFile string
 
+	// Sottocgroup utente dentro "limited" (direttamente o dentro un gruppo)
+	userSubCgroups map[int]string // UID -> path di user_<uid>
+
+	// Stato dello sweep dei processi sfuggiti (watermark e processi da
+	// riesaminare)
+	escapeMu    sync.Mutex
+	escapeSweep escapeSweepState
+
+	// Dischi scoperti in /sys/block (limiti IO per dispositivo)
+	sysBlockPath string // vuoto = /sys/block
+	blockDevices blockDeviceCache
+
+	// Log del kernel per le vittime degli OOM kill
+	kmsgPath string // vuoto = /dev/kmsg
+	kmsg     kernelLogReader
+
+	// Topologia CPU (/sys/devices/system) e cpuset del sottoalbero limited
+	sysCPUPath  string // vuoto = /sys/devices/system
+	limitedCPUs string // ultimo cpuset.cpus applicato, vuoto = tutte le CPU
+
 	// Cache per le verifiche
 	controllersAvailable bool
 	cgroupRootWritable   bool
+
+	// Con DRY_RUN all'avvio la preparazione dell'albero e' rimandata a EnsureSetup
+	setupMu      sync.Mutex
+	setupPending bool
 }
 
 // NewManager crea un nuovo CgroupManager.
@@ -41,6 +65,7 @@ func NewManager(cfg *config.Config) (*Manager, error) {
 		logger:             logger,
 		createdCgroups:     make(map[int]string),
 		createdCgroupsFile: cfg.CreatedCgroupsFile,
+		userSubCgroups:     make(map[int]string),
 	}
 
 	// Verifica che i cgroups v2 siano disponibili e configurati correttamente
@@ -62,7 +87,34 @@ func NewManager(cfg *config.Config) (*Manager, error) {
 }
 
 // verifyCgroupSetup verifica che i cgroups v2 siano configurati correttamente.
+// Con DRY_RUN si limita alle letture: nessuna scrittura sull'host.
 func (m *Manager) verifyCgroupSetup() error {
+	return m.verifyCgroupTree(m.cfg.GetDryRun())
+}
+
+// EnsureSetup completa la preparazione rimandata da DRY_RUN (controller in
+// cgroup.subtree_control, test di scrittura, cgroup base). Va chiamata prima
+// di eseguire davvero le scritture; senza nulla in sospeso non fa niente.
+func (m *Manager) EnsureSetup() error {
+	m.setupMu.Lock()
+	defer m.setupMu.Unlock()
+	if !m.setupPending {
+		return nil
+	}
+	if err := m.verifyCgroupTree(false); err != nil {
+		return err
+	}
+	m.setupPending = false
+	m.logger.Info("Cgroup tree prepared after leaving dry-run mode",
+		"cgroup_root", m.cfg.CgroupRoot,
+		"base_cgroup", m.cfg.CgroupBase,
+	)
+	return nil
+}
+
+// verifyCgroupTree esegue le verifiche e, se readOnly e' false, abilita i
+// controller e crea il cgroup base.
+func (m *Manager) verifyCgroupTree(readOnly bool) error {
 	// 1. Verifica che la root dei cgroups esista
 	if _, err := os.Stat(m.cfg.CgroupRoot); os.IsNotExist(err) {
 		return fmt.Errorf("cgroup root does not exist: %s (enable cgroups v2 and PSI on Enterprise Linux compatible systems: grubby --update-kernel=ALL --args='systemd.unified_cgroup_hierarchy=1 psi=1')", m.cfg.CgroupRoot)
@@ -95,6 +147,17 @@ func (m *Manager) verifyCgroupSetup() error {
 	m.controllersAvailable = strings.Contains(controllers, "cpu") &&
 		strings.Contains(controllers, "cpuset")
 
+	if readOnly {
+		m.setupMu.Lock()
+		m.setupPending = true
+		m.setupMu.Unlock()
+		m.logger.Info("Dry run: cgroup tree setup skipped (controllers, write test, base cgroup)",
+			"subtree_control", strings.TrimSpace(controllers),
+			"base_cgroup", m.getBaseCgroupPath(),
+		)
+		return nil
+	}
+
 	if !m.controllersAvailable {
 		m.logger.Warn("CPU or cpuset controllers not enabled in subtree_control",
 			"subtree_control", strings.TrimSpace(controllers),
@@ -115,6 +178,11 @@ func (m *Manager) verifyCgroupSetup() error {
 	}
 	m.cgroupRootWritable = true
 
+	// Con CGROUP_MODE=systemd-slice non serve un albero proprio
+	if m.UsesSystemdSlices() {
+		return m.verifyUserSliceSetup()
+	}
+
 	// 5. Crea il cgroup base se non esiste
 	baseCgroupPath := m.getBaseCgroupPath()
 	if err := os.MkdirAll(baseCgroupPath, 0755); err != nil {
@@ -224,5 +292,8 @@ func (m *Manager) getBaseCgroupPath() string {
 
 // getUserCgroupPath restituisce il percorso del cgroup per un utente specifico.
 func (m *Manager) getUserCgroupPath(uid int) string {
+	if m.UsesSystemdSlices() {
+		return m.getUserSlicePath(uid)
+	}
 	return filepath.Join(m.getBaseCgroupPath(), fmt.Sprintf("user_%d", uid))
 }
@@ -17,11 +17,14 @@
 package cgroup
 
 import (
+	"fmt"
 	"os"
+	"path/filepath"
 	"sync"
 	"testing"
 
 	"github.com/fdefilippo/resman/config"
+	"github.com/fdefilippo/resman/logging"
 )
 
 func TestNewManager(t *testing.T) {
@@ -309,3 +312,126 @@ func TestGetCreatedCgroups(t *testing.T) {
 		t.Errorf("GetCreatedCgroups(): got %d uids, expected 2", len(uids))
 	}
 }
+
+func TestAdoptSharedCgroup(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.CgroupRoot = t.TempDir()
+	cfg.CgroupBase = "resman"
+
+	sharedPath := filepath.Join(cfg.CgroupRoot, "resman", "limited")
+	for _, dir := range []string{
+		filepath.Join(sharedPath, "user_1000"),
+		filepath.Join(sharedPath, "batch", "user_1001"),
+		filepath.Join(sharedPath, "batch", "not_a_user"),
+	} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("MkdirAll(%s) error: %v", dir, err)
+		}
+	}
+
+	manager := &Manager{
+		cfg:    cfg,
+		logger: logging.GetLogger(),
+	}
+
+	path, members, err := manager.AdoptSharedCgroup()
+	if err != nil {
+		t.Fatalf("AdoptSharedCgroup() error: %v", err)
+	}
+	if path != sharedPath {
+		t.Errorf("AdoptSharedCgroup() path = %s, expected %s", path, sharedPath)
+	}
+	expected := map[int]string{
+		1000: sharedPath,
+		1001: filepath.Join(sharedPath, "batch"),
+	}
+	if len(members) != len(expected) {
+		t.Fatalf("AdoptSharedCgroup() members = %v, expected %v", members, expected)
+	}
+	for uid, parent := range expected {
+		if members[uid] != parent {
+			t.Errorf("uid %d parent = %s, expected %s", uid, members[uid], parent)
+		}
+		if subPath, ok := manager.getUserSubCgroupPath(uid); !ok || subPath != filepath.Join(parent, fmt.Sprintf("user_%d", uid)) {
+			t.Errorf("uid %d sub-cgroup not tracked: %s", uid, subPath)
+		}
+	}
+
+	// Senza gerarchia esistente non c'e' nulla da adottare
+	cfg.CgroupBase = "missing"
+	if _, _, err := manager.AdoptSharedCgroup(); err == nil {
+		t.Error("AdoptSharedCgroup() should fail without an existing shared cgroup")
+	}
+}
+
+func TestApplyPolicyCPULimit(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.CgroupRoot = t.TempDir()
+	cfg.CgroupBase = "resman"
+
+	manager := &Manager{
+		cfg:                cfg,
+		logger:             logging.GetLogger(),
+		createdCgroups:     make(map[int]string),
+		createdCgroupsFile: filepath.Join(t.TempDir(), "cgroups.txt"),
+	}
+
+	// Utente limitato: cpu.max va sul sottocgroup in "limited"
+	subPath := filepath.Join(cfg.CgroupRoot, "resman", "limited", "user_1000")
+	if err := os.MkdirAll(subPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	manager.trackUserSubCgroup(1000, subPath)
+	if err := manager.ApplyPolicyCPULimit(1000, "50000 100000"); err != nil {
+		t.Fatalf("ApplyPolicyCPULimit(1000) error: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(subPath, "cpu.max")); err != nil || string(data) != "50000 100000" {
+		t.Errorf("limited user cpu.max = %q, %v; expected 50000 100000", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.CgroupRoot, "resman", "user_1000")); !os.IsNotExist(err) {
+		t.Errorf("policy for a limited user should not create CGROUP_BASE/user_1000: %v", err)
+	}
+
+	// Utente non limitato: cpu.max va su CGROUP_BASE/user_<uid>
+	if err := manager.ApplyPolicyCPULimit(1001, "150000 100000"); err != nil {
+		t.Fatalf("ApplyPolicyCPULimit(1001) error: %v", err)
+	}
+	userPath := filepath.Join(cfg.CgroupRoot, "resman", "user_1001")
+	if data, err := os.ReadFile(filepath.Join(userPath, "cpu.max")); err != nil || string(data) != "150000 100000" {
+		t.Errorf("unlimited user cpu.max = %q, %v; expected 150000 100000", data, err)
+	}
+}
+
+func TestDryRunSetupDoesNotWrite(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "cgroup.controllers"), []byte("cpuset cpu io memory"), 0644); err != nil {
+		t.Fatalf("Failed to write cgroup.controllers: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "cgroup.subtree_control"), nil, 0644); err != nil {
+		t.Fatalf("Failed to write cgroup.subtree_control: %v", err)
+	}
+
+	cfg := config.DefaultConfig()
+	cfg.CgroupRoot = root
+	cfg.CgroupBase = "resman"
+	cfg.CreatedCgroupsFile = filepath.Join(t.TempDir(), "created_cgroups")
+	cfg.DryRun = true
+
+	manager, err := NewManager(cfg)
+	if err != nil {
+		t.Fatalf("NewManager() in dry run error: %v", err)
+	}
+	if !manager.setupPending {
+		t.Error("setup should be pending after a dry-run start")
+	}
+
+	if data, _ := os.ReadFile(filepath.Join(root, "cgroup.subtree_control")); len(data) != 0 {
+		t.Errorf("cgroup.subtree_control = %q, expected untouched", data)
+	}
+	if _, err := os.Stat(filepath.Join(root, "cgroup.procs")); !os.IsNotExist(err) {
+		t.Error("dry run should not run the cgroup.procs write test")
+	}
+	if _, err := os.Stat(filepath.Join(root, "resman")); !os.IsNotExist(err) {
+		t.Error("dry run should not create the base cgroup")
+	}
+}
@@ -9,12 +9,12 @@ import (
 )
 
 func (m *Manager) ApplyRAMLimit(uid int, limit string) error {
-	cgroupPath, exists := m.getCgroupPath(uid)
+	cgroupPath, exists := m.getLimitCgroupPath(uid)
 	if !exists {
 		if err := m.CreateUserCgroup(uid); err != nil {
 			return fmt.Errorf("failed to create cgroup before applying RAM limit: %w", err)
 		}
-		cgroupPath, _ = m.getCgroupPath(uid)
+		cgroupPath, _ = m.getLimitCgroupPath(uid)
 	}
 
 	memoryMaxFile := filepath.Join(cgroupPath, "memory.max")
@@ -43,7 +43,7 @@ func (m *Manager) ApplyRAMLimitWithSwapDisabled(uid int, limit string) error {
 		return err
 	}
 
-	cgroupPath, _ := m.getCgroupPath(uid)
+	cgroupPath, _ := m.getLimitCgroupPath(uid)
 	swapMaxFile := filepath.Join(cgroupPath, "memory.swap.max")
 
 	if err := os.WriteFile(swapMaxFile, []byte("0"), defaultFilePerm); err != nil {
@@ -89,12 +89,12 @@ func (m *Manager) GetCgroupRAMUsage(uid int) (uint64, error) {
 // ma NON invoca l'OOM killer. Utile per segnalare pressione di memoria senza uccidere processi.
 // limit: bytes (es. "536870912") o suffissi (es. "512M", "1G", "2T")
 func (m *Manager) ApplyRAMHigh(uid int, limit string) error {
-	cgroupPath, exists := m.getCgroupPath(uid)
+	cgroupPath, exists := m.getLimitCgroupPath(uid)
 	if !exists {
 		if err := m.CreateUserCgroup(uid); err != nil {
 			return fmt.Errorf("failed to create cgroup before applying RAM high: %w", err)
 		}
-		cgroupPath, _ = m.getCgroupPath(uid)
+		cgroupPath, _ = m.getLimitCgroupPath(uid)
 	}
 
 	memoryHighFile := filepath.Join(cgroupPath, "memory.high")
@@ -146,7 +146,7 @@ func (m *Manager) ApplyRAMLimitWithHighAndSwapDisabled(uid int, maxLimit string,
 		return err
 	}
 
-	cgroupPath, _ := m.getCgroupPath(uid)
+	cgroupPath, _ := m.getLimitCgroupPath(uid)
 	swapMaxFile := filepath.Join(cgroupPath, "memory.swap.max")
 
 	if err := os.WriteFile(swapMaxFile, []byte("0"), defaultFilePerm); err != nil {
@@ -163,7 +163,7 @@ func (m *Manager) ApplyRAMLimitWithHighAndSwapDisabled(uid int, maxLimit string,
 
 // RemoveRAMHigh rimuove il limite soft di RAM (imposta a "max").
 func (m *Manager) RemoveRAMHigh(uid int) error {
-	cgroupPath, exists := m.getCgroupPath(uid)
+	cgroupPath, exists := m.getLimitCgroupPath(uid)
 	if !exists {
 		return fmt.Errorf("cgroup for UID %d not found", uid)
 	}
@@ -172,32 +172,225 @@ func (m *Manager) RemoveRAMHigh(uid int) error {
 	return os.WriteFile(memoryHighFile, []byte("max"), defaultFilePerm)
 }
 
+// swapLimitFiles sono i file dei limiti di swap nell'ordine in cui vengono scritti.
+var swapLimitFiles = []string{"memory.swap.max", "memory.swap.high", "memory.zswap.max"}
+
+// ApplySwapLimits scrive memory.swap.max, memory.swap.high e memory.zswap.max
+// del cgroup di limite dell'utente. I valori vuoti non vengono scritti;
+// memory.zswap.max viene saltato se il kernel non lo supporta.
+func (m *Manager) ApplySwapLimits(uid int, swapMax, swapHigh, zswapMax string) error {
+	cgroupPath, exists := m.getLimitCgroupPath(uid)
+	if !exists {
+		return fmt.Errorf("cgroup for UID %d not found", uid)
+	}
+
+	for i, value := range []string{swapMax, swapHigh, zswapMax} {
+		if value == "" {
+			continue
+		}
+		file := filepath.Join(cgroupPath, swapLimitFiles[i])
+		if swapLimitFiles[i] == "memory.zswap.max" {
+			if _, err := os.Stat(file); err != nil {
+				m.logger.Debug("memory.zswap.max not supported by the kernel, skipped", "uid", uid)
+				continue
+			}
+		}
+		if err := os.WriteFile(file, []byte(value), defaultFilePerm); err != nil {
+			return fmt.Errorf("failed to write %s for UID %d: %w", swapLimitFiles[i], uid, err)
+		}
+	}
+
+	m.logger.Debug("Swap limits applied",
+		"uid", uid,
+		"swap_max", swapMax,
+		"swap_high", swapHigh,
+		"zswap_max", zswapMax,
+	)
+	return nil
+}
+
+// RemoveSwapLimits riporta a "max" i limiti di swap presenti nel cgroup di
+// limite dell'utente.
+func (m *Manager) RemoveSwapLimits(uid int) error {
+	cgroupPath, exists := m.getLimitCgroupPath(uid)
+	if !exists {
+		return fmt.Errorf("cgroup for UID %d not found", uid)
+	}
+
+	for _, name := range swapLimitFiles {
+		file := filepath.Join(cgroupPath, name)
+		if _, err := os.Stat(file); err != nil {
+			continue
+		}
+		if err := os.WriteFile(file, []byte("max"), defaultFilePerm); err != nil {
+			return fmt.Errorf("failed to reset %s for UID %d: %w", name, uid, err)
+		}
+	}
+	return nil
+}
+
+// GetSwapUsage restituisce lo swap usato dal cgroup utente (memory.swap.current).
+func (m *Manager) GetSwapUsage(uid int) (uint64, error) {
+	cgroupPath, exists := m.getCgroupPath(uid)
+	if !exists {
+		return 0, fmt.Errorf("cgroup for UID %d not found", uid)
+	}
+
+	data, err := os.ReadFile(filepath.Join(cgroupPath, "memory.swap.current"))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read memory.swap.current for UID %d: %w", uid, err)
+	}
+	usage, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse memory.swap.current for UID %d: %w", uid, err)
+	}
+	return usage, nil
+}
+
 // GetMemoryHighEvents restituisce il numero di volte che il cgroup ha superato memory.high.
 // Legge da memory.events il campo "high".
 func (m *Manager) GetMemoryHighEvents(uid int) (uint64, error) {
+	events, err := m.GetMemoryEvents(uid)
+	if err != nil {
+		return 0, err
+	}
+	return events.High, nil
+}
+
+// MemoryEvents sono i contatori cumulativi di memory.events di un cgroup.
+type MemoryEvents struct {
+	Low          uint64 `json:"low"`
+	High         uint64 `json:"high"`
+	Max          uint64 `json:"max"`
+	OOM          uint64 `json:"oom"`
+	OOMKill      uint64 `json:"oom_kill"`
+	OOMGroupKill uint64 `json:"oom_group_kill"`
+}
+
+// Counters restituisce i contatori indicizzati con i nomi di memory.events.
+func (e MemoryEvents) Counters() map[string]uint64 {
+	return map[string]uint64{
+		"low":            e.Low,
+		"high":           e.High,
+		"max":            e.Max,
+		"oom":            e.OOM,
+		"oom_kill":       e.OOMKill,
+		"oom_group_kill": e.OOMGroupKill,
+	}
+}
+
+// MemoryStat sono i campi principali di memory.stat di un cgroup, in byte.
+type MemoryStat struct {
+	Anon  uint64 `json:"anon"`
+	File  uint64 `json:"file"`
+	Shmem uint64 `json:"shmem"`
+	Swap  uint64 `json:"swap"`
+}
+
+// Bytes restituisce i campi indicizzati con i nomi di memory.stat.
+func (s MemoryStat) Bytes() map[string]uint64 {
+	return map[string]uint64{
+		"anon":  s.Anon,
+		"file":  s.File,
+		"shmem": s.Shmem,
+		"swap":  s.Swap,
+	}
+}
+
+// GetMemoryEvents legge tutti i contatori di memory.events del cgroup
+// dell'utente. I campi assenti (kernel piu' vecchi) restano a zero.
+func (m *Manager) GetMemoryEvents(uid int) (MemoryEvents, error) {
+	cgroupPath, exists := m.getCgroupPath(uid)
+	if !exists {
+		return MemoryEvents{}, fmt.Errorf("cgroup for UID %d not found", uid)
+	}
+
+	data, err := os.ReadFile(filepath.Join(cgroupPath, "memory.events"))
+	if err != nil {
+		return MemoryEvents{}, fmt.Errorf("failed to read memory.events for UID %d: %w", uid, err)
+	}
+	return parseMemoryEvents(string(data)), nil
+}
+
+// parseMemoryEvents interpreta memory.events ("low 0\nhigh 12\n...").
+func parseMemoryEvents(data string) MemoryEvents {
+	// memory.events e memory.stat hanno lo stesso formato "chiave valore"
+	return MemoryEvents{
+		Low:          parseMemoryStatField(data, "low"),
+		High:         parseMemoryStatField(data, "high"),
+		Max:          parseMemoryStatField(data, "max"),
+		OOM:          parseMemoryStatField(data, "oom"),
+		OOMKill:      parseMemoryStatField(data, "oom_kill"),
+		OOMGroupKill: parseMemoryStatField(data, "oom_group_kill"),
+	}
+}
+
+// GetMemoryStat legge anon, file e shmem da memory.stat del cgroup
+// dell'utente; lo swap, che memory.stat non riporta, da memory.swap.current.
+func (m *Manager) GetMemoryStat(uid int) (MemoryStat, error) {
 	cgroupPath, exists := m.getCgroupPath(uid)
+	if !exists {
+		return MemoryStat{}, fmt.Errorf("cgroup for UID %d not found", uid)
+	}
+
+	data, err := os.ReadFile(filepath.Join(cgroupPath, "memory.stat"))
+	if err != nil {
+		return MemoryStat{}, fmt.Errorf("failed to read memory.stat for UID %d: %w", uid, err)
+	}
+	stat := string(data)
+	result := MemoryStat{
+		Anon:  parseMemoryStatField(stat, "anon"),
+		File:  parseMemoryStatField(stat, "file"),
+		Shmem: parseMemoryStatField(stat, "shmem"),
+	}
+	// Senza swap (o senza controller swap) memory.swap.current non esiste
+	if swap, err := m.GetSwapUsage(uid); err == nil {
+		result.Swap = swap
+	}
+	return result, nil
+}
+
+// GetMemoryWorkingSet restituisce il working set del cgroup di limite
+// dell'utente: memory.current meno la page cache inattiva (inactive_file di
+// memory.stat), la prima che il kernel recupera.
+func (m *Manager) GetMemoryWorkingSet(uid int) (uint64, error) {
+	cgroupPath, exists := m.getLimitCgroupPath(uid)
 	if !exists {
 		return 0, fmt.Errorf("cgroup for UID %d not found", uid)
 	}
 
-	memoryEventsFile := filepath.Join(cgroupPath, "memory.events")
-	data, err := os.ReadFile(memoryEventsFile)
+	data, err := os.ReadFile(filepath.Join(cgroupPath, "memory.current"))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read memory.current for UID %d: %w", uid, err)
+	}
+	current, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
 	if err != nil {
-		return 0, fmt.Errorf("failed to read memory.events for UID %d: %w", uid, err)
+		return 0, fmt.Errorf("failed to parse memory.current for UID %d: %w", uid, err)
 	}
 
-	// Parse "high 123" da memory.events
-	for _, line := range strings.Split(string(data), "\n") {
-		line = strings.TrimSpace(line)
-		if strings.HasPrefix(line, "high ") {
-			parts := strings.Fields(line)
-			if len(parts) == 2 {
-				return strconv.ParseUint(parts[1], 10, 64)
-			}
-		}
+	// Senza memory.stat il working set e' tutto memory.current
+	stat, err := os.ReadFile(filepath.Join(cgroupPath, "memory.stat"))
+	if err != nil {
+		return current, nil
+	}
+	inactiveFile := parseMemoryStatField(string(stat), "inactive_file")
+	if inactiveFile >= current {
+		return 0, nil
 	}
+	return current - inactiveFile, nil
+}
 
-	return 0, nil
+// parseMemoryStatField restituisce il valore di un campo di memory.stat
+// ("anon 123\nfile 456\n..."), 0 se assente.
+func parseMemoryStatField(data, field string) uint64 {
+	for _, line := range strings.Split(data, "\n") {
+		parts := strings.Fields(line)
+		if len(parts) == 2 && parts[0] == field {
+			value, _ := strconv.ParseUint(parts[1], 10, 64)
+			return value
+		}
+	}
+	return 0
 }
 
 // ApplyIOLimit applica limiti di IO (bandwidth e IOPS) a un cgroup utente.
@@ -0,0 +1,77 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+package cgroup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fdefilippo/resman/config"
+	"github.com/fdefilippo/resman/logging"
+)
+
+func TestSwapLimits(t *testing.T) {
+	cgroupPath := filepath.Join(t.TempDir(), "user_1000")
+	if err := os.MkdirAll(cgroupPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// Kernel senza zswap: memory.zswap.max non esiste
+	files := map[string]string{
+		"memory.swap.max":     "max\n",
+		"memory.swap.high":    "max\n",
+		"memory.swap.current": "4096\n",
+		"memory.stat":         "anon 1024\nfile 2048\nshmem 0\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(cgroupPath, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	manager := &Manager{
+		cfg:            config.DefaultConfig(),
+		logger:         logging.GetLogger(),
+		createdCgroups: map[int]string{1000: cgroupPath},
+	}
+
+	if err := manager.ApplySwapLimits(1000, "1G", "", "256M"); err != nil {
+		t.Fatalf("ApplySwapLimits() error: %v", err)
+	}
+	for name, expected := range map[string]string{"memory.swap.max": "1G", "memory.swap.high": "max\n"} {
+		if data, _ := os.ReadFile(filepath.Join(cgroupPath, name)); string(data) != expected {
+			t.Errorf("%s = %q, expected %q", name, string(data), expected)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(cgroupPath, "memory.zswap.max")); err == nil {
+		t.Error("memory.zswap.max should not be created when the kernel lacks it")
+	}
+
+	stat, err := manager.GetMemoryStat(1000)
+	if err != nil {
+		t.Fatalf("GetMemoryStat() error: %v", err)
+	}
+	if stat.Anon != 1024 || stat.File != 2048 || stat.Swap != 4096 {
+		t.Errorf("GetMemoryStat() = %+v, expected swap from memory.swap.current", stat)
+	}
+
+	if err := manager.RemoveSwapLimits(1000); err != nil {
+		t.Fatalf("RemoveSwapLimits() error: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(cgroupPath, "memory.swap.max")); string(data) != "max" {
+		t.Errorf("memory.swap.max after removal = %q, expected max", string(data))
+	}
+}
@@ -0,0 +1,144 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+// cgroup/oom.go
+package cgroup
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// defaultKmsgPath e' il log del kernel da cui si ricavano le vittime degli OOM kill.
+const defaultKmsgPath = "/dev/kmsg"
+
+// OOMKill e' un processo ucciso dall'OOM killer, dalla riga "oom-kill:" del
+// log del kernel.
+type OOMKill struct {
+	PID     int    `json:"pid"`
+	UID     int    `json:"uid"`
+	Command string `json:"command"`
+	Cgroup  string `json:"cgroup,omitempty"` // task_memcg, relativo alla root dei cgroup
+}
+
+// kernelLogReader legge /dev/kmsg in modo non bloccante a partire dalla fine
+// del buffer al momento dell'apertura.
+type kernelLogReader struct {
+	mu     sync.Mutex
+	fd     int
+	opened bool
+}
+
+// getKmsgPath restituisce il log del kernel da leggere.
+func (m *Manager) getKmsgPath() string {
+	if m.kmsgPath != "" {
+		return m.kmsgPath
+	}
+	return defaultKmsgPath
+}
+
+// ReadOOMKills restituisce gli OOM kill registrati dal kernel dalla chiamata
+// precedente. La prima chiamata apre il log e ne salta il contenuto: va fatta
+// a ogni ciclo, cosi' le righe lette restano quelle recenti.
+func (m *Manager) ReadOOMKills() ([]OOMKill, error) {
+	m.kmsg.mu.Lock()
+	defer m.kmsg.mu.Unlock()
+
+	if !m.kmsg.opened {
+		path := m.getKmsgPath()
+		// Il poller del runtime renderebbe bloccante la lettura: si usa il fd grezzo
+		fd, err := syscall.Open(path, syscall.O_RDONLY|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open kernel log %s: %w", path, err)
+		}
+		if _, err := syscall.Seek(fd, 0, io.SeekEnd); err != nil {
+			syscall.Close(fd)
+			return nil, fmt.Errorf("failed to seek kernel log %s: %w", path, err)
+		}
+		m.kmsg.fd = fd
+		m.kmsg.opened = true
+		return nil, nil
+	}
+
+	var data []byte
+	buf := make([]byte, 8192)
+	for {
+		n, err := syscall.Read(m.kmsg.fd, buf)
+		if errors.Is(err, syscall.EPIPE) {
+			// Record sovrascritti nel ring buffer prima di essere letti
+			continue
+		}
+		if errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EINTR) || n <= 0 {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read kernel log: %w", err)
+		}
+		data = append(data, buf[:n]...)
+	}
+
+	var kills []OOMKill
+	for _, line := range strings.Split(string(data), "\n") {
+		if kill, ok := parseOOMKillRecord(line); ok {
+			kills = append(kills, kill)
+		}
+	}
+	return kills, nil
+}
+
+// parseOOMKillRecord interpreta un record di /dev/kmsg del tipo
+// "6,1234,5678,-;oom-kill:constraint=CONSTRAINT_MEMCG,...,task_memcg=/resman/limited/user_1000,task=stress,pid=4321,uid=1000".
+func parseOOMKillRecord(record string) (OOMKill, bool) {
+	if _, msg, found := strings.Cut(record, ";"); found {
+		record = msg
+	}
+	msg, found := strings.CutPrefix(strings.TrimSpace(record), "oom-kill:")
+	if !found {
+		return OOMKill{}, false
+	}
+
+	kill := OOMKill{PID: -1, UID: -1}
+	// Campi senza "=" (es. mems_allowed=0,2) vengono ignorati
+	for _, field := range strings.Split(msg, ",") {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "task":
+			kill.Command = value
+		case "task_memcg":
+			kill.Cgroup = value
+		case "pid":
+			if pid, err := strconv.Atoi(value); err == nil {
+				kill.PID = pid
+			}
+		case "uid":
+			if uid, err := strconv.Atoi(value); err == nil {
+				kill.UID = uid
+			}
+		}
+	}
+	if kill.PID < 0 {
+		return OOMKill{}, false
+	}
+	return kill, true
+}
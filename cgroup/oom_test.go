@@ -0,0 +1,78 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+package cgroup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fdefilippo/resman/logging"
+)
+
+func TestParseMemoryEvents(t *testing.T) {
+	events := parseMemoryEvents("low 1\nhigh 42\nmax 7\noom 3\noom_kill 2\noom_group_kill 1\n")
+	expected := MemoryEvents{Low: 1, High: 42, Max: 7, OOM: 3, OOMKill: 2, OOMGroupKill: 1}
+	if events != expected {
+		t.Fatalf("parseMemoryEvents() = %+v, expected %+v", events, expected)
+	}
+
+	// Kernel senza oom_group_kill: il campo resta a zero
+	if events := parseMemoryEvents("low 0\nhigh 0\nmax 0\noom 0\noom_kill 5\n"); events.OOMKill != 5 || events.OOMGroupKill != 0 {
+		t.Errorf("parseMemoryEvents() on an older kernel = %+v", events)
+	}
+}
+
+func TestReadOOMKills(t *testing.T) {
+	kmsg := filepath.Join(t.TempDir(), "kmsg")
+	old := "6,100,1000,-;oom-kill:constraint=CONSTRAINT_MEMCG,task_memcg=/resman/limited/user_1000,task=old,pid=1,uid=1000\n"
+	if err := os.WriteFile(kmsg, []byte(old), 0644); err != nil {
+		t.Fatal(err)
+	}
+	manager := &Manager{logger: logging.GetLogger(), kmsgPath: kmsg}
+
+	// La prima lettura salta i record gia' presenti
+	if kills, err := manager.ReadOOMKills(); err != nil || len(kills) != 0 {
+		t.Fatalf("first ReadOOMKills() = %v, %v; expected no kills", kills, err)
+	}
+
+	file, err := os.OpenFile(kmsg, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := "4,101,2000,-;Memory cgroup out of memory: Killed process 4321 (stress)\n" +
+		"6,102,2001,-;oom-kill:constraint=CONSTRAINT_MEMCG,nodemask=(null),cpuset=/,mems_allowed=0,2," +
+		"oom_memcg=/resman/limited/user_1000,task_memcg=/resman/limited/user_1000,task=stress,pid=4321,uid=1000\n" +
+		" SUBSYSTEM=memory\n"
+	if _, err := file.WriteString(records); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	kills, err := manager.ReadOOMKills()
+	if err != nil {
+		t.Fatalf("ReadOOMKills() error: %v", err)
+	}
+	expected := OOMKill{PID: 4321, UID: 1000, Command: "stress", Cgroup: "/resman/limited/user_1000"}
+	if len(kills) != 1 || kills[0] != expected {
+		t.Fatalf("ReadOOMKills() = %+v, expected [%+v]", kills, expected)
+	}
+
+	if kills, _ := manager.ReadOOMKills(); len(kills) != 0 {
+		t.Errorf("records should be returned once, got %+v again", kills)
+	}
+}
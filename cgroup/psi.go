@@ -25,7 +25,7 @@ import (
 	"strings"
 )
 
-// PSIStats contiene le statistiche Pressure Stall Information per IO.
+// PSIStats contiene le statistiche Pressure Stall Information (IO o memoria).
 type PSIStats struct {
 	SomeAvg10  float64 // % di tempo con almeno un task stallato (media 10s)
 	SomeAvg60  float64 // % di tempo con almeno un task stallato (media 60s)
@@ -55,7 +55,23 @@ func (m *Manager) GetPSIStats(uid int) (PSIStats, error) {
 	return parsePSI(string(data))
 }
 
-// parsePSI analizza il contenuto di un file io.pressure.
+// GetMemoryPSIStats legge le statistiche PSI per la memoria (memory.pressure)
+// dal cgroup di limite di un utente.
+func (m *Manager) GetMemoryPSIStats(uid int) (PSIStats, error) {
+	cgroupPath, exists := m.getLimitCgroupPath(uid)
+	if !exists {
+		return PSIStats{}, fmt.Errorf("cgroup for UID %d not found", uid)
+	}
+
+	data, err := os.ReadFile(filepath.Join(cgroupPath, "memory.pressure"))
+	if err != nil {
+		return PSIStats{}, fmt.Errorf("failed to read memory.pressure for UID %d: %w", uid, err)
+	}
+
+	return parsePSI(string(data))
+}
+
+// parsePSI analizza il contenuto di un file io.pressure o memory.pressure.
 // Formato atteso:
 //
 //	some avg10=25.00 avg60=18.50 avg300=12.30 total=1234567
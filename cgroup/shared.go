@@ -12,8 +12,23 @@ import (
 )
 
 func (m *Manager) CreateSharedCgroup() (string, error) {
+	// Con le slice systemd il cgroup condiviso e' user.slice
+	if m.UsesSystemdSlices() {
+		sliceRoot := m.getUserSliceRoot()
+		m.enableSubtreeControllers(sliceRoot, "user.slice")
+		return sliceRoot, nil
+	}
+
 	sharedPath := filepath.Join(m.getBaseCgroupPath(), "limited")
 
+	// Con STATE_PERSIST_ENABLED il cgroup esistente e' di un'istanza precedente:
+	// va riusato senza liberare i processi limitati
+	if _, err := os.Stat(sharedPath); err == nil && m.cfg.GetStatePersistEnabled() {
+		m.enableSubtreeControllers(sharedPath, "shared cgroup")
+		m.logger.Info("Reusing existing shared cgroup", "path", sharedPath)
+		return sharedPath, nil
+	}
+
 	// Se il cgroup condiviso esiste già, RIMUOVLO COMPLETAMENTE e ricreo
 	if _, err := os.Stat(sharedPath); err == nil {
 		m.logger.Info("Shared cgroup already exists, removing and recreating", "path", sharedPath)
@@ -54,27 +69,83 @@ func (m *Manager) CreateSharedCgroup() (string, error) {
 	}
 
 	// Abilita i controller nel cgroup condiviso
-	subtreeControl := filepath.Join(sharedPath, "cgroup.subtree_control")
-	controllersData, controllersErr := os.ReadFile(filepath.Join(sharedPath, "cgroup.controllers"))
+	m.enableSubtreeControllers(sharedPath, "shared cgroup")
+
+	m.logger.Info("Shared cgroup created and initialized", "path", sharedPath)
+	return sharedPath, nil
+}
+
+// AdoptSharedCgroup riprende il cgroup condiviso lasciato da un'istanza
+// precedente senza spostare processi. Restituisce il percorso e, per ogni
+// sottocgroup user_<uid> trovato, il cgroup padre (limited o limited/<group>).
+func (m *Manager) AdoptSharedCgroup() (string, map[int]string, error) {
+	if m.UsesSystemdSlices() {
+		return m.adoptUserSlices()
+	}
+
+	sharedPath := filepath.Join(m.getBaseCgroupPath(), "limited")
+	entries, err := os.ReadDir(sharedPath)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read shared cgroup %s: %w", sharedPath, err)
+	}
+
+	members := make(map[int]string)
+	adopt := func(parentPath string, entries []os.DirEntry) {
+		for _, entry := range entries {
+			if !entry.IsDir() || !strings.HasPrefix(entry.Name(), "user_") {
+				continue
+			}
+			uid, err := strconv.Atoi(strings.TrimPrefix(entry.Name(), "user_"))
+			if err != nil {
+				continue
+			}
+			m.trackUserSubCgroup(uid, filepath.Join(parentPath, entry.Name()))
+			members[uid] = parentPath
+		}
+	}
+
+	adopt(sharedPath, entries)
+	// Le altre directory sono cgroup di gruppo: limited/<group>/user_<uid>
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), "user_") {
+			continue
+		}
+		groupPath := filepath.Join(sharedPath, entry.Name())
+		groupEntries, err := os.ReadDir(groupPath)
+		if err != nil {
+			m.logger.Warn("Failed to read group cgroup", "path", groupPath, "error", err)
+			continue
+		}
+		adopt(groupPath, groupEntries)
+	}
+
+	m.logger.Info("Existing shared cgroup adopted",
+		"path", sharedPath,
+		"users", len(members),
+	)
+	return sharedPath, members, nil
+}
+
+// enableSubtreeControllers abilita cpu, cpuset, io e memory per i figli di un cgroup.
+func (m *Manager) enableSubtreeControllers(cgroupPath, label string) {
+	subtreeControl := filepath.Join(cgroupPath, "cgroup.subtree_control")
+	controllersData, controllersErr := os.ReadFile(filepath.Join(cgroupPath, "cgroup.controllers"))
 	if err := m.writeControllerIfMissing(subtreeControl, "+cpu"); err != nil {
-		m.logger.Warn("Failed to enable cpu controller in shared cgroup", "error", err)
+		m.logger.Warn("Failed to enable cpu controller in "+label, "path", cgroupPath, "error", err)
 	}
 	if err := m.writeControllerIfMissing(subtreeControl, "+cpuset"); err != nil {
-		m.logger.Warn("Failed to enable cpuset controller in shared cgroup", "error", err)
+		m.logger.Warn("Failed to enable cpuset controller in "+label, "path", cgroupPath, "error", err)
 	}
 	if controllersErr == nil && strings.Contains(string(controllersData), "io") {
 		if err := m.writeControllerIfMissing(subtreeControl, "+io"); err != nil {
-			m.logger.Warn("Failed to enable io controller in shared cgroup", "error", err)
+			m.logger.Warn("Failed to enable io controller in "+label, "path", cgroupPath, "error", err)
 		}
 	}
 	if controllersErr == nil && strings.Contains(string(controllersData), "memory") {
 		if err := m.writeControllerIfMissing(subtreeControl, "+memory"); err != nil {
-			m.logger.Warn("Failed to enable memory controller in shared cgroup", "error", err)
+			m.logger.Warn("Failed to enable memory controller in "+label, "path", cgroupPath, "error", err)
 		}
 	}
-
-	m.logger.Info("Shared cgroup created and initialized", "path", sharedPath)
-	return sharedPath, nil
 }
 
 // ApplySharedCPULimit applica un limite di CPU al cgroup condiviso
@@ -101,6 +172,11 @@ func (m *Manager) ApplySharedCPULimit(sharedPath string, quota string) error {
 
 // CreateUserSubCgroup crea un sottocgroup utente dentro il cgroup condiviso
 func (m *Manager) CreateUserSubCgroup(uid int, sharedPath string) (string, error) {
+	// Con le slice systemd il sottocgroup utente e' la sua slice
+	if m.UsesSystemdSlices() {
+		return m.trackUserSlice(uid)
+	}
+
 	userPath := filepath.Join(sharedPath, fmt.Sprintf("user_%d", uid))
 
 	// Crea la directory del sottocgroup
@@ -119,6 +195,8 @@ func (m *Manager) CreateUserSubCgroup(uid int, sharedPath string) (string, error
 		)
 	}
 
+	m.trackUserSubCgroup(uid, userPath)
+
 	m.logger.Debug("User sub-cgroup created",
 		"uid", uid,
 		"path", userPath,
@@ -128,8 +206,37 @@ func (m *Manager) CreateUserSubCgroup(uid int, sharedPath string) (string, error
 	return userPath, nil
 }
 
+// ApplyUserSubCgroupCPULimit imposta cpu.max sul sottocgroup dell'utente dentro
+// il cgroup condiviso, senza spostare processi (a differenza di ApplyCPULimit).
+func (m *Manager) ApplyUserSubCgroupCPULimit(uid int, quota string) error {
+	userPath, ok := m.getUserSubCgroupPath(uid)
+	if !ok {
+		return fmt.Errorf("user sub-cgroup for UID %d not found", uid)
+	}
+
+	if !isValidCPUQuotaFormat(quota) {
+		return fmt.Errorf("invalid CPU quota format: %s", quota)
+	}
+
+	cpuMaxFile := filepath.Join(userPath, "cpu.max")
+	if err := os.WriteFile(cpuMaxFile, []byte(quota), 0644); err != nil {
+		return fmt.Errorf("failed to apply CPU limit %s to user sub-cgroup for UID %d: %w", quota, uid, err)
+	}
+
+	m.logger.Debug("User sub-cgroup CPU limit applied",
+		"uid", uid,
+		"path", userPath,
+		"quota", quota,
+	)
+	return nil
+}
+
 // MoveProcessToSharedCgroup sposta un processo nel cgroup condiviso
 func (m *Manager) MoveProcessToSharedCgroup(pid int, sharedPath string, uid int) error {
+	if m.UsesSystemdSlices() {
+		return nil
+	}
+
 	// Usa il sottocgroup specifico dell'utente
 	userPath := filepath.Join(sharedPath, fmt.Sprintf("user_%d", uid))
 
@@ -154,6 +261,11 @@ func (m *Manager) MoveProcessToSharedCgroup(pid int, sharedPath string, uid int)
 // MoveAllUserProcessesToSharedCgroup sposta tutti i processi di un utente nel cgroup condiviso
 // Uses gopsutil for efficient process discovery.
 func (m *Manager) MoveAllUserProcessesToSharedCgroup(uid int, sharedPath string) error {
+	// I processi sono gia' nella slice dell'utente, anche quelli nati dopo
+	if m.UsesSystemdSlices() {
+		return nil
+	}
+
 	m.logger.Debug("Moving all processes for user to shared cgroup",
 		"uid", uid,
 		"shared_path", sharedPath,
@@ -199,10 +311,24 @@ func (m *Manager) MoveAllUserProcessesToSharedCgroup(uid int, sharedPath string)
 
 // ReleaseUserFromSharedCgroup sposta i processi fuori dal sottocgroup condiviso e lo rimuove.
 func (m *Manager) ReleaseUserFromSharedCgroup(uid int, sharedPath string) error {
+	// La slice resta a systemd: vengono solo tolti i limiti
+	if m.UsesSystemdSlices() {
+		slicePath, ok := m.getUserSubCgroupPath(uid)
+		if !ok {
+			slicePath = m.getUserSlicePath(uid)
+		}
+		m.untrackUserSubCgroup(uid)
+		if _, err := os.Stat(slicePath); os.IsNotExist(err) {
+			return nil
+		}
+		return m.resetUserSlice(uid, slicePath)
+	}
+
 	userPath := filepath.Join(sharedPath, fmt.Sprintf("user_%d", uid))
 	userProcsFile := filepath.Join(userPath, "cgroup.procs")
 
 	if _, err := os.Stat(userPath); os.IsNotExist(err) {
+		m.untrackUserSubCgroup(uid)
 		return nil
 	}
 
@@ -222,6 +348,7 @@ func (m *Manager) ReleaseUserFromSharedCgroup(uid int, sharedPath string) error
 	if err := os.Remove(userPath); err != nil {
 		return fmt.Errorf("failed to remove user shared cgroup for UID %d: %w", uid, err)
 	}
+	m.untrackUserSubCgroup(uid)
 
 	m.logger.Debug("User released from shared cgroup",
 		"uid", uid,
@@ -231,6 +358,18 @@ func (m *Manager) ReleaseUserFromSharedCgroup(uid int, sharedPath string) error
 	return nil
 }
 
+// RemoveSharedCgroup rimuove il cgroup condiviso con i sottocgroup rimasti.
+// Con le slice systemd user.slice resta a systemd e non viene toccata.
+func (m *Manager) RemoveSharedCgroup(sharedPath string) error {
+	if m.UsesSystemdSlices() {
+		return nil
+	}
+	if err := os.RemoveAll(sharedPath); err != nil {
+		return fmt.Errorf("failed to remove shared cgroup %s: %w", sharedPath, err)
+	}
+	return nil
+}
+
 // moveAllUserProcessesToSharedCgroupFallback scans /proc manually if gopsutil fails.
 func (m *Manager) moveAllUserProcessesToSharedCgroupFallback(uid int, sharedPath string) error {
 	procDir := "/proc"
@@ -99,12 +99,30 @@ func (m *Manager) loadExistingCgroups() error {
 // getCgroupPath restituisce il percorso del cgroup per un UID.
 func (m *Manager) getCgroupPath(uid int) (string, bool) {
 	m.mu.RLock()
-	defer m.mu.RUnlock()
-
 	path, exists := m.createdCgroups[uid]
+	m.mu.RUnlock()
+
+	// Le slice systemd esistono anche se resman non le ha mai toccate
+	if !exists && m.UsesSystemdSlices() {
+		path = m.getUserSlicePath(uid)
+		_, err := os.Stat(path)
+		exists = err == nil
+	}
 	return path, exists
 }
 
+// getLimitCgroupPath restituisce il cgroup su cui scrivere i limiti per-utente
+// (memory.*, io.max): il sottocgroup dentro "limited" se l'utente e' nel cgroup
+// condiviso, altrimenti il cgroup utente tracciato.
+func (m *Manager) getLimitCgroupPath(uid int) (string, bool) {
+	if subPath, ok := m.getUserSubCgroupPath(uid); ok {
+		if _, err := os.Stat(subPath); err == nil {
+			return subPath, true
+		}
+	}
+	return m.getCgroupPath(uid)
+}
+
 // readPidsFromFile legge i PIDs da un file cgroup.procs.
 func (m *Manager) readPidsFromFile(filePath string) ([]int, error) {
 	data, err := os.ReadFile(filePath)
@@ -181,6 +199,12 @@ func (m *Manager) GetCgroupInfo(uid int) (map[string]string, error) {
 		info["cpu.weight"] = strings.TrimSpace(string(data))
 	}
 
+	// Leggi il limite di memoria corrente
+	memoryMaxFile := filepath.Join(cgroupPath, "memory.max")
+	if data, err := os.ReadFile(memoryMaxFile); err == nil {
+		info["memory.max"] = strings.TrimSpace(string(data))
+	}
+
 	return info, nil
 }
 
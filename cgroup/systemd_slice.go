@@ -0,0 +1,174 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+// cgroup/systemd_slice.go
+package cgroup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fdefilippo/resman/config"
+)
+
+// Con CGROUP_MODE=systemd-slice i limiti vengono scritti direttamente sulle
+// user-<uid>.slice create da systemd-logind: nessun albero proprio, nessuno
+// spostamento di PID. Il ruolo del cgroup condiviso "limited" lo svolge
+// user.slice, i sottocgroup utente sono le slice stesse.
+
+// userSliceDefaults sono i valori con cui una slice torna senza limiti.
+var userSliceDefaults = []struct {
+	file  string
+	value string
+}{
+	{"cpu.max", "max 100000"},
+	{"cpu.weight", "100"},
+	{"memory.high", "max"},
+	{"memory.max", "max"},
+	{"memory.swap.max", "max"},
+	{"memory.swap.high", "max"},
+	{"memory.zswap.max", "max"},
+	{"io.max", "default rbps=max wbps=max riops=max wiops=max"},
+	{"io.weight", "default 100"},
+	{"cpuset.cpus", ""},
+}
+
+// UserSliceName restituisce il nome della slice systemd di un utente.
+func UserSliceName(uid int) string {
+	return fmt.Sprintf("user-%d.slice", uid)
+}
+
+// UsesSystemdSlices indica se i limiti vanno sulle slice systemd degli utenti
+// invece che su un albero proprio (CGROUP_MODE=systemd-slice).
+func (m *Manager) UsesSystemdSlices() bool {
+	return m.cfg.GetCgroupMode() == config.CgroupModeSystemdSlice
+}
+
+// getUserSliceRoot restituisce user.slice, il parent delle slice utente.
+func (m *Manager) getUserSliceRoot() string {
+	return filepath.Join(m.cfg.CgroupRoot, "user.slice")
+}
+
+// getUserSlicePath restituisce la slice systemd di un utente.
+func (m *Manager) getUserSlicePath(uid int) string {
+	return filepath.Join(m.getUserSliceRoot(), UserSliceName(uid))
+}
+
+// verifyUserSliceSetup verifica che user.slice esista e vi abilita i
+// controller. Le slice utente esistono solo finche' l'utente ha una sessione.
+func (m *Manager) verifyUserSliceSetup() error {
+	sliceRoot := m.getUserSliceRoot()
+	if _, err := os.Stat(filepath.Join(sliceRoot, "cgroup.subtree_control")); err != nil {
+		return fmt.Errorf("systemd user slice %s not available (CGROUP_MODE=systemd-slice requires systemd-logind): %w", sliceRoot, err)
+	}
+	m.enableSubtreeControllers(sliceRoot, "user.slice")
+
+	m.logger.Info("Using systemd user slices for limits",
+		"user_slice", sliceRoot,
+		"process_migration", false,
+	)
+	return nil
+}
+
+// trackUserSlice registra la slice dell'utente come suo cgroup di limite.
+// Fallisce se l'utente non ha una slice (nessuna sessione logind attiva).
+func (m *Manager) trackUserSlice(uid int) (string, error) {
+	slicePath := m.getUserSlicePath(uid)
+	if _, err := os.Stat(slicePath); err != nil {
+		return "", fmt.Errorf("systemd slice for UID %d not found (no logind session?): %w", uid, err)
+	}
+	m.trackUserSubCgroup(uid, slicePath)
+	m.applySliceCPUSet(uid, slicePath)
+	return slicePath, nil
+}
+
+// adoptUserSlices restituisce le slice utente esistenti: in questa modalita'
+// non c'e' modo di distinguere dal filesystem quelle limitate da resman.
+func (m *Manager) adoptUserSlices() (string, map[int]string, error) {
+	sliceRoot := m.getUserSliceRoot()
+	entries, err := os.ReadDir(sliceRoot)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read user slice %s: %w", sliceRoot, err)
+	}
+
+	members := make(map[int]string)
+	for _, entry := range entries {
+		var uid int
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), "user-") {
+			continue
+		}
+		if _, err := fmt.Sscanf(entry.Name(), "user-%d.slice", &uid); err != nil {
+			continue
+		}
+		m.trackUserSubCgroup(uid, filepath.Join(sliceRoot, entry.Name()))
+		members[uid] = sliceRoot
+	}
+
+	m.logger.Info("Existing user slices adopted",
+		"path", sliceRoot,
+		"users", len(members),
+	)
+	return sliceRoot, members, nil
+}
+
+// resetUserSlice riporta senza limiti i file di controllo della slice
+// utente. I file dei controller non abilitati non esistono e vengono saltati.
+func (m *Manager) resetUserSlice(uid int, slicePath string) error {
+	var resetErrs []string
+	for _, def := range userSliceDefaults {
+		path := filepath.Join(slicePath, def.file)
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		if err := os.WriteFile(path, []byte(def.value), defaultFilePerm); err != nil {
+			resetErrs = append(resetErrs, fmt.Sprintf("%s: %v", def.file, err))
+		}
+	}
+
+	if len(resetErrs) > 0 {
+		return fmt.Errorf("failed to reset user slice for UID %d: %s", uid, strings.Join(resetErrs, "; "))
+	}
+	m.logger.Debug("User slice limits reset", "uid", uid, "path", slicePath)
+	return nil
+}
+
+// cleanupUserSlices rimuove i limiti da tutte le slice gestite. Le slice
+// appartengono a systemd e non vengono mai rimosse; user.slice non viene toccata.
+func (m *Manager) cleanupUserSlices() error {
+	m.mu.Lock()
+	slices := make(map[int]string, len(m.userSubCgroups))
+	for uid, path := range m.userSubCgroups {
+		slices[uid] = path
+	}
+	m.userSubCgroups = make(map[int]string)
+	m.createdCgroups = make(map[int]string)
+	m.mu.Unlock()
+
+	var cleanupErrs []string
+	for uid, path := range slices {
+		if err := m.resetUserSlice(uid, path); err != nil {
+			cleanupErrs = append(cleanupErrs, err.Error())
+		}
+	}
+
+	if len(cleanupErrs) > 0 {
+		return fmt.Errorf("errors during user slice cleanup: %s", strings.Join(cleanupErrs, "; "))
+	}
+	m.logger.Info("User slice limits removed", "slices", len(slices))
+	return nil
+}
@@ -0,0 +1,90 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+package cgroup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fdefilippo/resman/config"
+	"github.com/fdefilippo/resman/logging"
+)
+
+func TestSystemdSliceMode(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.CgroupRoot = t.TempDir()
+	cfg.CgroupMode = config.CgroupModeSystemdSlice
+
+	slicePath := filepath.Join(cfg.CgroupRoot, "user.slice", "user-1000.slice")
+	if err := os.MkdirAll(slicePath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{"cpu.max", "memory.high"} {
+		if err := os.WriteFile(filepath.Join(slicePath, file), []byte("max"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	manager := &Manager{
+		cfg:            cfg,
+		logger:         logging.GetLogger(),
+		createdCgroups: make(map[int]string),
+	}
+
+	sharedPath, err := manager.CreateSharedCgroup()
+	if err != nil {
+		t.Fatalf("CreateSharedCgroup() error: %v", err)
+	}
+	if sharedPath != filepath.Join(cfg.CgroupRoot, "user.slice") {
+		t.Errorf("shared cgroup = %s, expected user.slice", sharedPath)
+	}
+
+	// Il sottocgroup utente e' la slice esistente, nessuna directory creata
+	userPath, err := manager.CreateUserSubCgroup(1000, sharedPath)
+	if err != nil {
+		t.Fatalf("CreateUserSubCgroup() error: %v", err)
+	}
+	if userPath != slicePath {
+		t.Errorf("user cgroup = %s, expected %s", userPath, slicePath)
+	}
+	if _, err := manager.CreateUserSubCgroup(1001, sharedPath); err == nil {
+		t.Error("CreateUserSubCgroup() should fail for a user without a slice")
+	}
+
+	if err := os.WriteFile(filepath.Join(slicePath, "cpu.max"), []byte("50000 100000"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.ReleaseUserFromSharedCgroup(1000, sharedPath); err != nil {
+		t.Fatalf("ReleaseUserFromSharedCgroup() error: %v", err)
+	}
+
+	// La slice resta, con i limiti riportati ai default
+	data, err := os.ReadFile(filepath.Join(slicePath, "cpu.max"))
+	if err != nil {
+		t.Fatalf("user slice should not be removed: %v", err)
+	}
+	if string(data) != "max 100000" {
+		t.Errorf("cpu.max after release = %q, expected %q", data, "max 100000")
+	}
+	if _, err := os.Stat(filepath.Join(slicePath, "io.max")); !os.IsNotExist(err) {
+		t.Error("reset should not create control files of disabled controllers")
+	}
+	if _, ok := manager.getUserSubCgroupPath(1000); ok {
+		t.Error("released slice should no longer be tracked")
+	}
+}
@@ -46,6 +46,7 @@ type Config struct {
 	// Paths
 	CgroupRoot         string `config:"CGROUP_ROOT"`
 	CgroupBase         string `config:"CGROUP_BASE"`
+	CgroupMode         string `config:"CGROUP_MODE"` // private (default) or systemd-slice
 	ConfigFile         string `config:"CONFIG_FILE"` // Ricorsivo, usato all'avvio
 	LogFile            string `config:"LOG_FILE"`
 	CreatedCgroupsFile string `config:"CREATED_CGROUPS_FILE"`
@@ -86,6 +87,22 @@ type Config struct {
 	DisableSwap         bool    `config:"DISABLE_SWAP"`
 	RAMHighRatio        float64 `config:"RAM_HIGH_RATIO"` // Ratio for memory.high (0.0-1.0, default 0.8)
 
+	// RAM activation state machine (indipendente da CPU e IO)
+	RAMThresholdDuration int `config:"RAM_THRESHOLD_DURATION"` // Seconds to wait before activating RAM limits (0 = immediate)
+	RAMMinActiveTime     int `config:"RAM_MIN_ACTIVE_TIME"`    // Minimum seconds RAM limits stay active (0 = MIN_ACTIVE_TIME)
+
+	// memory.high dinamico: budget RAM_THRESHOLD diviso per working set, corretto da PSI
+	RAMDynamicHigh  bool    `config:"RAM_DYNAMIC_HIGH"`  // Adjust memory.high each cycle instead of RAM_HIGH_RATIO
+	RAMHighMin      string  `config:"RAM_HIGH_MIN"`      // Lowest memory.high assigned to a user (default 128M)
+	RAMHighStep     float64 `config:"RAM_HIGH_STEP"`     // Fraction memory.high is lowered/raised per cycle (default 0.1)
+	RAMPSIThreshold float64 `config:"RAM_PSI_THRESHOLD"` // memory.pressure some avg10 % considered contention (default 10)
+
+	// Swap per utente: byte, "max" o percentuale di memory.max (es. "50%").
+	// Vuoto = file non scritto; DISABLE_SWAP ha la precedenza su RAM_SWAP_MAX
+	RAMSwapMax  string `config:"RAM_SWAP_MAX"`  // memory.swap.max
+	RAMSwapHigh string `config:"RAM_SWAP_HIGH"` // memory.swap.high
+	RAMZswapMax string `config:"RAM_ZSWAP_MAX"` // memory.zswap.max, only where the kernel supports it
+
 	// RAM User Include List (regex support)
 	RAMUserIncludeList []string `config:"RAM_USER_INCLUDE_LIST"`
 
@@ -102,6 +119,21 @@ type Config struct {
 	IOWriteIOPS         int    `config:"IO_WRITE_IOPS"`         // Write IOPS limit (0 = unlimited)
 	IODeviceFilter      string `config:"IO_DEVICE_FILTER"`      // "all" or "major:minor" (default "all")
 	IOThresholdDuration int    `config:"IO_THRESHOLD_DURATION"` // Seconds to wait before activating IO limits (0 = immediate)
+	IOMinActiveTime     int    `config:"IO_MIN_ACTIVE_TIME"`    // Minimum seconds IO limits stay active (0 = MIN_ACTIVE_TIME)
+
+	// Modalita' IO: limiti rigidi io.max o pesi proporzionali io.weight
+	IOLimitMode         string `config:"IO_LIMIT_MODE"`        // max (default) or weight
+	IOWeightMin         int    `config:"IO_WEIGHT_MIN"`        // Lowest io.weight assigned to heavy users (default 25)
+	IOWeightMax         int    `config:"IO_WEIGHT_MAX"`        // Highest io.weight assigned to light users (default 400)
+	IOSharedWeight      int    `config:"IO_SHARED_WEIGHT"`     // io.weight of the shared "limited" cgroup (default 50)
+	IOLatencyTargetUsec int    `config:"IO_LATENCY_TARGET_US"` // io.latency target of limited users in microseconds (0 = disabled)
+
+	// Limiti IO per dispositivo (dispositivi scoperti da /sys/block)
+	IODeviceDiscovery   bool     `config:"IO_DEVICE_DISCOVERY"` // One io.max line per discovered device
+	IODeviceExcludeList []string `config:"IO_DEVICE_EXCLUDE"`   // Regex on device names skipped by discovery
+	// IO_DEVICE_<device>_READ_BPS=..., ecc. Nessun tag config: le chiavi sono
+	// dinamiche e gestite da setIODeviceField.
+	IODeviceLimits []*IODeviceLimit
 
 	// IO Starvation Auto-Remediation
 	IORemediationEnabled      bool    `config:"IO_REMEDIATION_ENABLED"`
@@ -111,6 +143,9 @@ type Config struct {
 	IOBoostDuration           int     `config:"IO_BOOST_DURATION"`            // Duration of boost in seconds
 	IOBoostMaxPerHour         int     `config:"IO_BOOST_MAX_PER_HOUR"`        // Max boosts per user per hour
 	IOPSIThreshold            float64 `config:"IO_PSI_THRESHOLD"`             // PSI some avg10 % threshold
+	IOBoostMinMultiplier      float64 `config:"IO_BOOST_MIN_MULTIPLIER"`      // Smallest boost the controller steps down to
+	IOBoostMaxMultiplier      float64 `config:"IO_BOOST_MAX_MULTIPLIER"`      // Largest boost the controller steps up to
+	IOBoostStep               float64 `config:"IO_BOOST_STEP"`                // Factor applied to the multiplier at each step
 	IORevertOnNormal          bool    `config:"IO_REVERT_ON_NORMAL"`          // Revert limits when IO returns to normal
 
 	// IO User Include/Exclude Lists (regex support)
@@ -127,6 +162,12 @@ type Config struct {
 	BatchNightRAMQuota  string `config:"BATCH_NIGHT_RAM_QUOTA"` // RAM quota per batch
 	InteractiveCPUQuota int    `config:"INTERACTIVE_CPU_QUOTA"` // CPU quota per interattivo
 	InteractiveRAMQuota string `config:"INTERACTIVE_RAM_QUOTA"` // RAM quota per interattivo
+	BatchDayCPUQuota    int    `config:"BATCH_DAY_CPU_QUOTA"`   // CPU quota per batch in orario lavorativo
+	// Fasce orarie delle policy per pattern (ore locali 0-23)
+	PatternNightStartHour    int `config:"PATTERN_NIGHT_START_HOUR"`
+	PatternNightEndHour      int `config:"PATTERN_NIGHT_END_HOUR"`
+	PatternBusinessStartHour int `config:"PATTERN_BUSINESS_START_HOUR"`
+	PatternBusinessEndHour   int `config:"PATTERN_BUSINESS_END_HOUR"`
 
 	// Hooks
 	LimitHookEnabled bool   `config:"LIMIT_HOOK_ENABLED"`
@@ -165,6 +206,11 @@ type Config struct {
 	SystemUIDMin   int `config:"SYSTEM_UID_MIN"`
 	SystemUIDMax   int `config:"SYSTEM_UID_MAX"`
 
+	// Core isolation: cpuset.cpus of the limited subtree excludes reserved cores
+	CPUSetIsolation        bool   `config:"CPUSET_ISOLATION"`          // Pin limited users away from reserved cores
+	CPUSetReservedCPUs     string `config:"CPUSET_RESERVED_CPUS"`      // Explicit reserved cpulist (e.g. "0-1,8"), empty = MIN_SYSTEM_CORES cores
+	CPUSetReservedNUMANode int    `config:"CPUSET_RESERVED_NUMA_NODE"` // NUMA node reserved cores are taken from (-1 = lowest online cores)
+
 	// User Include List (users to INCLUDE in limiting, regex support)
 	UserIncludeList []string `config:"USER_INCLUDE_LIST"` // Comma-separated regex patterns
 
@@ -201,6 +247,12 @@ type Config struct {
 	MetricsDBPath          string `config:"METRICS_DB_PATH"`
 	MetricsDBRetentionDays int    `config:"METRICS_DB_RETENTION_DAYS"`
 	MetricsDBWriteInterval int    `config:"METRICS_DB_WRITE_INTERVAL"` // seconds
+	MetricsDBQueueSize     int    `config:"METRICS_DB_QUEUE_SIZE"`     // records buffered for the writer goroutine
+
+	// Retention delle tabelle aggregate (giorni, 0 = senza limite)
+	MetricsDB5mRetentionDays int `config:"METRICS_DB_5M_RETENTION_DAYS"`
+	MetricsDB1hRetentionDays int `config:"METRICS_DB_1H_RETENTION_DAYS"`
+	MetricsDB1dRetentionDays int `config:"METRICS_DB_1D_RETENTION_DAYS"`
 
 	// Username Cache TTL (minutes)
 	UsernameCacheTTL int `config:"USERNAME_CACHE_TTL"` // minutes, default 60
@@ -213,6 +265,41 @@ type Config struct {
 	PSIFallbackInterval  int  `config:"PSI_FALLBACK_INTERVAL"`   // Fallback polling interval in seconds when event-driven (default 300 = 5min)
 	PSIBoostWeight       int  `config:"PSI_BOOST_WEIGHT"`        // CPU weight boost on PSI event (default 300, normal weight is 100)
 	PSIBoostDuration     int  `config:"PSI_BOOST_DURATION"`      // Seconds before reverting PSI boost (default 120)
+
+	// Fair-share CPU weights (cpu.weight calcolato dal consumo recente invece del valore fisso 100)
+	FairShareEnabled   bool `config:"FAIR_SHARE_ENABLED"`    // Enable fair-share cpu.weight for limited users
+	FairShareHalfLife  int  `config:"FAIR_SHARE_HALF_LIFE"`  // Seconds after which past CPU usage counts half (default 21600 = 6h)
+	FairShareMinWeight int  `config:"FAIR_SHARE_MIN_WEIGHT"` // Lowest weight assigned to heavy users (default 25)
+	FairShareMaxWeight int  `config:"FAIR_SHARE_MAX_WEIGHT"` // Highest weight assigned to light users (default 400)
+
+	// Targeting: limita solo gli utenti che generano il carico invece di tutti gli eleggibili
+	LimitTargetMode       string `config:"LIMIT_TARGET_MODE"`       // all (default), top_n, above_share
+	LimitTargetTopN       int    `config:"LIMIT_TARGET_TOP_N"`      // Users limited in top_n mode (default 3)
+	LimitTargetMinShare   int    `config:"LIMIT_TARGET_MIN_SHARE"`  // % of limited-user CPU needed in above_share mode (default 20)
+	LimitTargetHysteresis int    `config:"LIMIT_TARGET_HYSTERESIS"` // Share points a selected user may drop below the entry bar (default 5)
+
+	// Dry-run: esegue l'intero ciclo di controllo registrando le scritture cgroup senza eseguirle
+	DryRun bool `config:"DRY_RUN"`
+
+	// Persistenza dello stato dei limiti tra riavvii del demone
+	StatePersistEnabled bool   `config:"STATE_PERSIST_ENABLED"`
+	StateFile           string `config:"STATE_FILE"`
+
+	// Contabilita' CPU da cpu.stat (usage_usec) invece della scansione di /proc
+	CPUAccountingCgroup    bool `config:"CPU_ACCOUNTING_CGROUP"`     // Users with a resman cgroup (default true)
+	CPUAccountingUserSlice bool `config:"CPU_ACCOUNTING_USER_SLICE"` // Other users via systemd user-<uid>.slice (default false)
+
+	// Processi degli utenti limitati nati fuori dal loro cgroup (ssh, cron, ...)
+	ProcessWatcherEnabled    bool `config:"PROCESS_WATCHER_ENABLED"`     // Move escaped processes back (default true)
+	ProcessWatcherIntervalMs int  `config:"PROCESS_WATCHER_INTERVAL_MS"` // /proc sweep interval in milliseconds (default 3000)
+
+	// Gruppi utente (GROUP_<name>=regex, GROUP_<name>_CPU_QUOTA=..., ecc.)
+	// Nessun tag config: le chiavi sono dinamiche e gestite da setGroupField.
+	UserGroups []*UserGroup
+
+	// Override per-utente (cpu.weight, cpu.max, memory.high/max, io.max)
+	UserOverridesFile string `config:"USER_OVERRIDES_FILE"` // Default /etc/resman.d/users.conf (missing file = no overrides)
+	userOverrides     *UserOverrides
 }
 
 // DefaultConfig restituisce la configurazione predefinita (come nel tuo script Bash).
@@ -228,6 +315,7 @@ func DefaultConfig() *Config {
 	return &Config{
 		CgroupRoot:         "/sys/fs/cgroup",
 		CgroupBase:         "resman",
+		CgroupMode:         CgroupModePrivate,
 		ConfigFile:         "/etc/resman.conf",
 		LogFile:            "/var/log/resman.log",
 		CreatedCgroupsFile: "/var/run/resman-cgroups.txt",
@@ -264,6 +352,16 @@ func DefaultConfig() *Config {
 		RAMUserIncludeList:  nil,
 		RAMUserExcludeList:  nil,
 
+		RAMThresholdDuration: 0, // 0 = immediate (no duration check)
+		RAMMinActiveTime:     0, // 0 = MIN_ACTIVE_TIME
+		RAMDynamicHigh:       false,
+		RAMHighMin:           "128M",
+		RAMHighStep:          0.1,
+		RAMPSIThreshold:      10,
+		RAMSwapMax:           "",
+		RAMSwapHigh:          "",
+		RAMZswapMax:          "",
+
 		// IO limits
 		IOEnabled:           false,
 		IOThreshold:         75,
@@ -273,7 +371,15 @@ func DefaultConfig() *Config {
 		IOReadIOPS:          1000,
 		IOWriteIOPS:         500,
 		IODeviceFilter:      "all",
+		IODeviceDiscovery:   false,
+		IOLimitMode:         IOLimitModeMax,
+		IOWeightMin:         25,
+		IOWeightMax:         400,
+		IOSharedWeight:      50,
+		IOLatencyTargetUsec: 0,
+		IODeviceExcludeList: []string{"^loop", "^ram", "^zram", "^dm-", "^sr", "^fd"},
 		IOThresholdDuration: 0, // 0 = immediate (no duration check)
+		IOMinActiveTime:     0, // 0 = MIN_ACTIVE_TIME
 
 		// IO Starvation Auto-Remediation
 		IORemediationEnabled:      false,
@@ -283,6 +389,9 @@ func DefaultConfig() *Config {
 		IOBoostDuration:           600, // 10 minutes
 		IOBoostMaxPerHour:         3,
 		IOPSIThreshold:            50.0, // 50%
+		IOBoostMinMultiplier:      1.25,
+		IOBoostMaxMultiplier:      8.0,
+		IOBoostStep:               1.5,
 		IORevertOnNormal:          true,
 
 		IOUserIncludeList: nil,
@@ -297,6 +406,11 @@ func DefaultConfig() *Config {
 		BatchNightRAMQuota:         "4G",
 		InteractiveCPUQuota:        50000, // 50%
 		InteractiveRAMQuota:        "1G",
+		BatchDayCPUQuota:           25000, // 25%
+		PatternNightStartHour:      22,
+		PatternNightEndHour:        6,
+		PatternBusinessStartHour:   8,
+		PatternBusinessEndHour:     18,
 
 		// Limit hook
 		LimitHookEnabled: false,
@@ -341,6 +455,10 @@ func DefaultConfig() *Config {
 		BlackoutSpec:       "", // Empty = no blackout (always active)
 		BlackoutTimeframes: nil,
 
+		CPUSetIsolation:        false,
+		CPUSetReservedCPUs:     "",
+		CPUSetReservedNUMANode: -1,
+
 		// MCP Server
 		MCPEnabled:       false,
 		MCPTransport:     "stdio",
@@ -355,6 +473,12 @@ func DefaultConfig() *Config {
 		MetricsDBPath:          "/etc/resman/metrics.db",
 		MetricsDBRetentionDays: 30,
 		MetricsDBWriteInterval: 30, // Same as polling interval by default
+		MetricsDBQueueSize:     10000,
+
+		// Rollup: 5 minuti per 3 mesi, ore per 2 anni, giorni per 10 anni
+		MetricsDB5mRetentionDays: 90,
+		MetricsDB1hRetentionDays: 730,
+		MetricsDB1dRetentionDays: 3650,
 
 		// Username Cache TTL (minutes)
 		UsernameCacheTTL: 60, // Default 60 minutes
@@ -367,6 +491,31 @@ func DefaultConfig() *Config {
 		PSIFallbackInterval:  300,
 		PSIBoostWeight:       300,
 		PSIBoostDuration:     120,
+
+		// Fair-share defaults
+		FairShareEnabled:   false,
+		FairShareHalfLife:  21600,
+		FairShareMinWeight: 25,
+		FairShareMaxWeight: 400,
+
+		// Targeting: tutti gli utenti eleggibili (comportamento storico)
+		LimitTargetMode:       LimitTargetAll,
+		LimitTargetTopN:       3,
+		LimitTargetMinShare:   20,
+		LimitTargetHysteresis: 5,
+
+		// Override per-utente
+		UserOverridesFile: "/etc/resman.d/users.conf",
+
+		// Persistenza dello stato: disabilitata (comportamento storico)
+		StateFile: "/var/run/resman-state.json",
+
+		// Contabilita' CPU: cpu.stat per gli utenti con un cgroup resman
+		CPUAccountingCgroup: true,
+
+		// Recupero dei processi sfuggiti ai limiti: sweep di /proc ogni secondo
+		ProcessWatcherEnabled:    true,
+		ProcessWatcherIntervalMs: 3000,
 	}
 }
 
@@ -382,6 +531,8 @@ func LoadAndValidate(configPath string) (*Config, error) {
 
 	// 2. Sovrascrivi con le variabili d'ambiente
 	warnings := loadFromEnvironment(cfg)
+	warnings = append(warnings, loadGroupsFromEnvironment(cfg)...)
+	warnings = append(warnings, loadIODevicesFromEnvironment(cfg)...)
 	for _, w := range warnings {
 		fmt.Fprintf(os.Stderr, "WARNING: %s\n", w)
 	}
@@ -391,7 +542,14 @@ func LoadAndValidate(configPath string) (*Config, error) {
 		return nil, fmt.Errorf("validation failed: %w", err)
 	}
 
-	// 4. Warning se USER_INCLUDE_LIST è vuota (nessun utente sarà limitato)
+	// 4. Carica gli override per-utente (file separato, ricaricato insieme alla config)
+	overrides, err := LoadUserOverrides(cfg.UserOverridesFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading user overrides %s: %w", cfg.UserOverridesFile, err)
+	}
+	cfg.userOverrides = overrides
+
+	// 5. Warning se USER_INCLUDE_LIST è vuota (nessun utente sarà limitato)
 	if cfg.UserIncludeList == nil || len(cfg.UserIncludeList) == 0 {
 		fmt.Fprintf(os.Stderr, "WARNING: USER_INCLUDE_LIST is empty - no users will be CPU limited. "+
 			"Set USER_INCLUDE_LIST=.* to limit all users, or specify patterns (e.g., USER_INCLUDE_LIST=^www.*,^app.*).\n")
@@ -521,6 +679,12 @@ func loadFromEnvironment(cfg *Config) []string {
 func setConfigField(cfg *Config, key, value string) error {
 	handler, ok := configFieldHandlers[key]
 	if !ok {
+		if strings.HasPrefix(key, groupKeyPrefix) {
+			return setGroupField(cfg, key, value)
+		}
+		if strings.HasPrefix(key, ioDeviceKeyPrefix) {
+			return setIODeviceField(cfg, key, value)
+		}
 		return nil
 	}
 	return handler(cfg, value)
@@ -531,6 +695,7 @@ type configFieldHandler func(*Config, string) error
 var configFieldHandlers = map[string]configFieldHandler{
 	"CGROUP_ROOT":          setString(func(cfg *Config, value string) { cfg.CgroupRoot = value }),
 	"CGROUP_BASE":          setCgroupBase,
+	"CGROUP_MODE":          setStringTransform(strings.ToLower, func(cfg *Config, value string) { cfg.CgroupMode = value }),
 	"CONFIG_FILE":          setString(func(cfg *Config, value string) { cfg.ConfigFile = value }),
 	"LOG_FILE":             setString(func(cfg *Config, value string) { cfg.LogFile = value }),
 	"CREATED_CGROUPS_FILE": setString(func(cfg *Config, value string) { cfg.CreatedCgroupsFile = value }),
@@ -589,6 +754,9 @@ var configFieldHandlers = map[string]configFieldHandler{
 	"MIN_SYSTEM_CORES":              setInt(func(cfg *Config, value int) { cfg.MinSystemCores = value }),
 	"SYSTEM_UID_MIN":                setInt(func(cfg *Config, value int) { cfg.SystemUIDMin = value }),
 	"SYSTEM_UID_MAX":                setInt(func(cfg *Config, value int) { cfg.SystemUIDMax = value }),
+	"CPUSET_ISOLATION":              setBool(false, func(cfg *Config, value bool) { cfg.CPUSetIsolation = value }),
+	"CPUSET_RESERVED_CPUS":          setString(func(cfg *Config, value string) { cfg.CPUSetReservedCPUs = value }),
+	"CPUSET_RESERVED_NUMA_NODE":     setInt(func(cfg *Config, value int) { cfg.CPUSetReservedNUMANode = value }),
 	"USER_INCLUDE_LIST":             setRegexList("", func(cfg *Config, value []string) { cfg.UserIncludeList = value }),
 	"USER_EXCLUDE_LIST":             setRegexList("", func(cfg *Config, value []string) { cfg.UserExcludeList = value }),
 	"PROCESS_EXCLUDE_LIST":          setRegexList(" in PROCESS_EXCLUDE_LIST", func(cfg *Config, value []string) { cfg.ProcessExcludeList = value }),
@@ -607,6 +775,10 @@ var configFieldHandlers = map[string]configFieldHandler{
 	"METRICS_DB_PATH":               setString(func(cfg *Config, value string) { cfg.MetricsDBPath = value }),
 	"METRICS_DB_RETENTION_DAYS":     setPositiveInt(func(cfg *Config, value int) { cfg.MetricsDBRetentionDays = value }),
 	"METRICS_DB_WRITE_INTERVAL":     setPositiveInt(func(cfg *Config, value int) { cfg.MetricsDBWriteInterval = value }),
+	"METRICS_DB_QUEUE_SIZE":         setPositiveInt(func(cfg *Config, value int) { cfg.MetricsDBQueueSize = value }),
+	"METRICS_DB_5M_RETENTION_DAYS":  setInt(func(cfg *Config, value int) { cfg.MetricsDB5mRetentionDays = value }),
+	"METRICS_DB_1H_RETENTION_DAYS":  setInt(func(cfg *Config, value int) { cfg.MetricsDB1hRetentionDays = value }),
+	"METRICS_DB_1D_RETENTION_DAYS":  setInt(func(cfg *Config, value int) { cfg.MetricsDB1dRetentionDays = value }),
 	"USERNAME_CACHE_TTL":            setPositiveInt(func(cfg *Config, value int) { cfg.UsernameCacheTTL = value }),
 	"CGROUP_OPERATION_TIMEOUT":      setInt(func(cfg *Config, value int) { cfg.CgroupOperationTimeout = value }),
 	"CGROUP_RETRY_DELAY_MS":         setInt(func(cfg *Config, value int) { cfg.CgroupRetryDelayMs = value }),
@@ -628,7 +800,24 @@ var configFieldHandlers = map[string]configFieldHandler{
 	"IO_READ_IOPS":                  setInt(func(cfg *Config, value int) { cfg.IOReadIOPS = value }),
 	"IO_WRITE_IOPS":                 setInt(func(cfg *Config, value int) { cfg.IOWriteIOPS = value }),
 	"IO_DEVICE_FILTER":              setString(func(cfg *Config, value string) { cfg.IODeviceFilter = value }),
+	"IO_DEVICE_DISCOVERY":           setBool(false, func(cfg *Config, value bool) { cfg.IODeviceDiscovery = value }),
+	"IO_LIMIT_MODE":                 setStringTransform(strings.ToLower, func(cfg *Config, value string) { cfg.IOLimitMode = value }),
+	"IO_WEIGHT_MIN":                 setPositiveInt(func(cfg *Config, value int) { cfg.IOWeightMin = value }),
+	"IO_WEIGHT_MAX":                 setPositiveInt(func(cfg *Config, value int) { cfg.IOWeightMax = value }),
+	"IO_SHARED_WEIGHT":              setPositiveInt(func(cfg *Config, value int) { cfg.IOSharedWeight = value }),
+	"IO_LATENCY_TARGET_US":          setInt(func(cfg *Config, value int) { cfg.IOLatencyTargetUsec = value }),
+	"IO_DEVICE_EXCLUDE":             setRegexList(" in IO_DEVICE_EXCLUDE", func(cfg *Config, value []string) { cfg.IODeviceExcludeList = value }),
 	"IO_THRESHOLD_DURATION":         setInt(func(cfg *Config, value int) { cfg.IOThresholdDuration = value }),
+	"IO_MIN_ACTIVE_TIME":            setInt(func(cfg *Config, value int) { cfg.IOMinActiveTime = value }),
+	"RAM_THRESHOLD_DURATION":        setInt(func(cfg *Config, value int) { cfg.RAMThresholdDuration = value }),
+	"RAM_MIN_ACTIVE_TIME":           setInt(func(cfg *Config, value int) { cfg.RAMMinActiveTime = value }),
+	"RAM_DYNAMIC_HIGH":              setBool(false, func(cfg *Config, value bool) { cfg.RAMDynamicHigh = value }),
+	"RAM_HIGH_MIN":                  setString(func(cfg *Config, value string) { cfg.RAMHighMin = value }),
+	"RAM_HIGH_STEP":                 setFloat(func(cfg *Config, value float64) { cfg.RAMHighStep = value }),
+	"RAM_PSI_THRESHOLD":             setFloat(func(cfg *Config, value float64) { cfg.RAMPSIThreshold = value }),
+	"RAM_SWAP_MAX":                  setString(func(cfg *Config, value string) { cfg.RAMSwapMax = value }),
+	"RAM_SWAP_HIGH":                 setString(func(cfg *Config, value string) { cfg.RAMSwapHigh = value }),
+	"RAM_ZSWAP_MAX":                 setString(func(cfg *Config, value string) { cfg.RAMZswapMax = value }),
 	"IO_USER_INCLUDE_LIST":          setRegexList(" in IO_USER_INCLUDE_LIST", func(cfg *Config, value []string) { cfg.IOUserIncludeList = value }),
 	"IO_USER_EXCLUDE_LIST":          setRegexList(" in IO_USER_EXCLUDE_LIST", func(cfg *Config, value []string) { cfg.IOUserExcludeList = value }),
 	"IO_REMEDIATION_ENABLED":        setBool(false, func(cfg *Config, value bool) { cfg.IORemediationEnabled = value }),
@@ -638,6 +827,9 @@ var configFieldHandlers = map[string]configFieldHandler{
 	"IO_BOOST_DURATION":             setInt(func(cfg *Config, value int) { cfg.IOBoostDuration = value }),
 	"IO_BOOST_MAX_PER_HOUR":         setInt(func(cfg *Config, value int) { cfg.IOBoostMaxPerHour = value }),
 	"IO_PSI_THRESHOLD":              setFloat(func(cfg *Config, value float64) { cfg.IOPSIThreshold = value }),
+	"IO_BOOST_MIN_MULTIPLIER":       setFloat(func(cfg *Config, value float64) { cfg.IOBoostMinMultiplier = value }),
+	"IO_BOOST_MAX_MULTIPLIER":       setFloat(func(cfg *Config, value float64) { cfg.IOBoostMaxMultiplier = value }),
+	"IO_BOOST_STEP":                 setFloat(func(cfg *Config, value float64) { cfg.IOBoostStep = value }),
 	"IO_REVERT_ON_NORMAL":           setBool(true, func(cfg *Config, value bool) { cfg.IORevertOnNormal = value }),
 	"AUTODETECT_PATTERNS":           setBool(false, func(cfg *Config, value bool) { cfg.AutodetectPatterns = value }),
 	"PATTERN_HISTORY_HOURS":         setPositiveInt(func(cfg *Config, value int) { cfg.PatternHistoryHours = value }),
@@ -647,6 +839,11 @@ var configFieldHandlers = map[string]configFieldHandler{
 	"BATCH_NIGHT_RAM_QUOTA":         setString(func(cfg *Config, value string) { cfg.BatchNightRAMQuota = value }),
 	"INTERACTIVE_CPU_QUOTA":         setInt(func(cfg *Config, value int) { cfg.InteractiveCPUQuota = value }),
 	"INTERACTIVE_RAM_QUOTA":         setString(func(cfg *Config, value string) { cfg.InteractiveRAMQuota = value }),
+	"BATCH_DAY_CPU_QUOTA":           setInt(func(cfg *Config, value int) { cfg.BatchDayCPUQuota = value }),
+	"PATTERN_NIGHT_START_HOUR":      setInt(func(cfg *Config, value int) { cfg.PatternNightStartHour = value }),
+	"PATTERN_NIGHT_END_HOUR":        setInt(func(cfg *Config, value int) { cfg.PatternNightEndHour = value }),
+	"PATTERN_BUSINESS_START_HOUR":   setInt(func(cfg *Config, value int) { cfg.PatternBusinessStartHour = value }),
+	"PATTERN_BUSINESS_END_HOUR":     setInt(func(cfg *Config, value int) { cfg.PatternBusinessEndHour = value }),
 	"PSI_EVENT_DRIVEN":              setBool(false, func(cfg *Config, value bool) { cfg.PSIEventDriven = value }),
 	"PSI_CPU_STALL_THRESHOLD":       setPositiveInt(func(cfg *Config, value int) { cfg.PSICPUStallThreshold = value }),
 	"PSI_IO_STALL_THRESHOLD":        setPositiveInt(func(cfg *Config, value int) { cfg.PSIOStallThreshold = value }),
@@ -654,6 +851,32 @@ var configFieldHandlers = map[string]configFieldHandler{
 	"PSI_FALLBACK_INTERVAL":         setPositiveInt(func(cfg *Config, value int) { cfg.PSIFallbackInterval = value }),
 	"PSI_BOOST_WEIGHT":              setPositiveInt(func(cfg *Config, value int) { cfg.PSIBoostWeight = value }),
 	"PSI_BOOST_DURATION":            setPositiveInt(func(cfg *Config, value int) { cfg.PSIBoostDuration = value }),
+	"FAIR_SHARE_ENABLED":            setBool(false, func(cfg *Config, value bool) { cfg.FairShareEnabled = value }),
+	"FAIR_SHARE_HALF_LIFE":          setPositiveInt(func(cfg *Config, value int) { cfg.FairShareHalfLife = value }),
+	"FAIR_SHARE_MIN_WEIGHT":         setPositiveInt(func(cfg *Config, value int) { cfg.FairShareMinWeight = value }),
+	"FAIR_SHARE_MAX_WEIGHT":         setPositiveInt(func(cfg *Config, value int) { cfg.FairShareMaxWeight = value }),
+	"USER_OVERRIDES_FILE":           setString(func(cfg *Config, value string) { cfg.UserOverridesFile = value }),
+	"LIMIT_TARGET_MODE": setStringTransform(strings.ToLower, func(cfg *Config, value string) {
+		cfg.LimitTargetMode = value
+	}),
+	"LIMIT_TARGET_TOP_N":      setInt(func(cfg *Config, value int) { cfg.LimitTargetTopN = value }),
+	"LIMIT_TARGET_MIN_SHARE":  setInt(func(cfg *Config, value int) { cfg.LimitTargetMinShare = value }),
+	"LIMIT_TARGET_HYSTERESIS": setInt(func(cfg *Config, value int) { cfg.LimitTargetHysteresis = value }),
+	"DRY_RUN":                 setBool(false, func(cfg *Config, value bool) { cfg.DryRun = value }),
+	"STATE_PERSIST_ENABLED":   setBool(false, func(cfg *Config, value bool) { cfg.StatePersistEnabled = value }),
+	"STATE_FILE":              setString(func(cfg *Config, value string) { cfg.StateFile = value }),
+	"CPU_ACCOUNTING_CGROUP": setBool(true, func(cfg *Config, value bool) {
+		cfg.CPUAccountingCgroup = value
+	}),
+	"CPU_ACCOUNTING_USER_SLICE": setBool(false, func(cfg *Config, value bool) {
+		cfg.CPUAccountingUserSlice = value
+	}),
+	"PROCESS_WATCHER_ENABLED": setBool(true, func(cfg *Config, value bool) {
+		cfg.ProcessWatcherEnabled = value
+	}),
+	"PROCESS_WATCHER_INTERVAL_MS": setInt(func(cfg *Config, value int) {
+		cfg.ProcessWatcherIntervalMs = value
+	}),
 }
 
 func setString(assign func(*Config, string)) configFieldHandler {
@@ -826,6 +1049,12 @@ func validateConfig(cfg *Config) error {
 	if cfg.CPUThresholdDuration < 0 {
 		errors = append(errors, "CPU_THRESHOLD_DURATION cannot be negative")
 	}
+	if cfg.RAMThresholdDuration < 0 || cfg.IOThresholdDuration < 0 {
+		errors = append(errors, "RAM_THRESHOLD_DURATION and IO_THRESHOLD_DURATION cannot be negative")
+	}
+	if cfg.RAMMinActiveTime < 0 || cfg.IOMinActiveTime < 0 {
+		errors = append(errors, "RAM_MIN_ACTIVE_TIME and IO_MIN_ACTIVE_TIME cannot be negative")
+	}
 
 	// Validate metrics database configuration
 	if cfg.MetricsDBRetentionDays < 1 {
@@ -834,6 +1063,12 @@ func validateConfig(cfg *Config) error {
 	if cfg.MetricsDBWriteInterval < 5 {
 		errors = append(errors, "METRICS_DB_WRITE_INTERVAL must be at least 5 seconds")
 	}
+	if cfg.MetricsDBQueueSize < 1 {
+		errors = append(errors, "METRICS_DB_QUEUE_SIZE must be at least 1")
+	}
+	if cfg.MetricsDB5mRetentionDays < 0 || cfg.MetricsDB1hRetentionDays < 0 || cfg.MetricsDB1dRetentionDays < 0 {
+		errors = append(errors, "METRICS_DB_5M/1H/1D_RETENTION_DAYS cannot be negative (0 keeps rollups forever)")
+	}
 	if cfg.UsernameCacheTTL < 1 {
 		errors = append(errors, "USERNAME_CACHE_TTL must be at least 1 minute")
 	}
@@ -869,6 +1104,92 @@ func validateConfig(cfg *Config) error {
 		errors = append(errors, "PSI_BOOST_DURATION must be greater than 0")
 	}
 
+	// Validate user groups
+	errors = append(errors, validateGroups(cfg)...)
+	errors = append(errors, validateIODevices(cfg)...)
+
+	// Validate fair-share configuration
+	if cfg.FairShareEnabled {
+		if cfg.FairShareHalfLife < 1 {
+			errors = append(errors, "FAIR_SHARE_HALF_LIFE must be at least 1 second")
+		}
+		if cfg.FairShareMinWeight < 1 || cfg.FairShareMinWeight > 10000 {
+			errors = append(errors, "FAIR_SHARE_MIN_WEIGHT must be between 1 and 10000")
+		}
+		if cfg.FairShareMaxWeight < 1 || cfg.FairShareMaxWeight > 10000 {
+			errors = append(errors, "FAIR_SHARE_MAX_WEIGHT must be between 1 and 10000")
+		}
+		if cfg.FairShareMinWeight > cfg.FairShareMaxWeight {
+			errors = append(errors, "FAIR_SHARE_MIN_WEIGHT cannot be greater than FAIR_SHARE_MAX_WEIGHT")
+		}
+	}
+
+	// Validate cgroup mode
+	switch cfg.CgroupMode {
+	case "", CgroupModePrivate, CgroupModeSystemdSlice:
+	default:
+		errors = append(errors, "CGROUP_MODE must be one of: private, systemd-slice")
+	}
+
+	// Validate process watcher
+	if cfg.ProcessWatcherEnabled && (cfg.ProcessWatcherIntervalMs < 100 || cfg.ProcessWatcherIntervalMs > 60000) {
+		errors = append(errors, "PROCESS_WATCHER_INTERVAL_MS must be between 100 and 60000")
+	}
+
+	// Validate adaptive IO boost controller
+	if cfg.IORemediationEnabled {
+		if cfg.IOBoostMinMultiplier < 1 {
+			errors = append(errors, "IO_BOOST_MIN_MULTIPLIER must be at least 1.0")
+		}
+		if cfg.IOBoostMaxMultiplier < cfg.IOBoostMinMultiplier {
+			errors = append(errors, "IO_BOOST_MAX_MULTIPLIER cannot be less than IO_BOOST_MIN_MULTIPLIER")
+		}
+		if cfg.IOBoostStep <= 1 {
+			errors = append(errors, "IO_BOOST_STEP must be greater than 1.0")
+		}
+	}
+
+	// Validate pattern policy windows
+	if cfg.AutodetectPatterns {
+		for _, window := range []struct {
+			key  string
+			hour int
+		}{
+			{"PATTERN_NIGHT_START_HOUR", cfg.PatternNightStartHour},
+			{"PATTERN_NIGHT_END_HOUR", cfg.PatternNightEndHour},
+			{"PATTERN_BUSINESS_START_HOUR", cfg.PatternBusinessStartHour},
+			{"PATTERN_BUSINESS_END_HOUR", cfg.PatternBusinessEndHour},
+		} {
+			if window.hour < 0 || window.hour > 23 {
+				errors = append(errors, window.key+" must be between 0 and 23")
+			}
+		}
+		if cfg.PatternNightStartHour == cfg.PatternNightEndHour {
+			errors = append(errors, "PATTERN_NIGHT_START_HOUR and PATTERN_NIGHT_END_HOUR must differ")
+		}
+		if cfg.PatternBusinessStartHour >= cfg.PatternBusinessEndHour {
+			errors = append(errors, "PATTERN_BUSINESS_START_HOUR must be before PATTERN_BUSINESS_END_HOUR")
+		}
+	}
+
+	// Validate limit targeting
+	switch cfg.LimitTargetMode {
+	case "", LimitTargetAll:
+	case LimitTargetTopN:
+		if cfg.LimitTargetTopN < 1 {
+			errors = append(errors, "LIMIT_TARGET_TOP_N must be at least 1")
+		}
+	case LimitTargetAboveShare:
+		if cfg.LimitTargetMinShare < 1 || cfg.LimitTargetMinShare > 100 {
+			errors = append(errors, "LIMIT_TARGET_MIN_SHARE must be between 1 and 100")
+		}
+	default:
+		errors = append(errors, "LIMIT_TARGET_MODE must be one of: all, top_n, above_share")
+	}
+	if cfg.LimitTargetHysteresis < 0 || cfg.LimitTargetHysteresis > 100 {
+		errors = append(errors, "LIMIT_TARGET_HYSTERESIS must be between 0 and 100")
+	}
+
 	// Validate limit hook configuration
 	if cfg.LimitHookEnabled {
 		if cfg.LimitHookTimeout < 1 {
@@ -910,6 +1231,26 @@ func validateConfig(cfg *Config) error {
 		if cfg.RAMHighRatio < 0 || cfg.RAMHighRatio > 1 {
 			errors = append(errors, "RAM_HIGH_RATIO must be between 0.0 and 1.0 (e.g., 0.8 for 80%, 0 to disable)")
 		}
+		if cfg.RAMDynamicHigh {
+			if !isValidByteQuota(cfg.RAMHighMin) || cfg.RAMHighMin == "max" {
+				errors = append(errors, "RAM_HIGH_MIN must be a valid byte value (e.g., '134217728', '128M')")
+			}
+			if cfg.RAMHighStep <= 0 || cfg.RAMHighStep >= 1 {
+				errors = append(errors, "RAM_HIGH_STEP must be between 0.0 and 1.0 exclusive (e.g., 0.1 for 10% per cycle)")
+			}
+			if cfg.RAMPSIThreshold <= 0 || cfg.RAMPSIThreshold > 100 {
+				errors = append(errors, "RAM_PSI_THRESHOLD must be between 0 and 100")
+			}
+		}
+		for key, value := range map[string]string{
+			"RAM_SWAP_MAX":  cfg.RAMSwapMax,
+			"RAM_SWAP_HIGH": cfg.RAMSwapHigh,
+			"RAM_ZSWAP_MAX": cfg.RAMZswapMax,
+		} {
+			if value != "" && !IsValidSwapLimit(value) {
+				errors = append(errors, fmt.Sprintf("%s must be a byte value, 'max' or a percentage of memory.max (e.g., '1G', '50%%')", key))
+			}
+		}
 	}
 
 	// Validate IO limits
@@ -939,6 +1280,27 @@ func validateConfig(cfg *Config) error {
 		if cfg.IOWriteIOPS < 0 {
 			errors = append(errors, "IO_WRITE_IOPS must be >= 0 (0 = unlimited)")
 		}
+		switch cfg.IOLimitMode {
+		case "", IOLimitModeMax:
+		case IOLimitModeWeight:
+			if cfg.IOWeightMin < 1 || cfg.IOWeightMin > 10000 {
+				errors = append(errors, "IO_WEIGHT_MIN must be between 1 and 10000")
+			}
+			if cfg.IOWeightMax < 1 || cfg.IOWeightMax > 10000 {
+				errors = append(errors, "IO_WEIGHT_MAX must be between 1 and 10000")
+			}
+			if cfg.IOWeightMin > cfg.IOWeightMax {
+				errors = append(errors, "IO_WEIGHT_MIN cannot be greater than IO_WEIGHT_MAX")
+			}
+			if cfg.IOSharedWeight < 1 || cfg.IOSharedWeight > 10000 {
+				errors = append(errors, "IO_SHARED_WEIGHT must be between 1 and 10000")
+			}
+			if cfg.IOLatencyTargetUsec < 0 {
+				errors = append(errors, "IO_LATENCY_TARGET_US must be >= 0 (0 = disabled)")
+			}
+		default:
+			errors = append(errors, "IO_LIMIT_MODE must be one of: max, weight")
+		}
 	}
 
 	// Validate log level
@@ -955,6 +1317,20 @@ func validateConfig(cfg *Config) error {
 		errors = append(errors, "SYSTEM_UID_MAX must be greater than SYSTEM_UID_MIN")
 	}
 
+	// Validate core isolation
+	if cfg.CPUSetIsolation {
+		if cfg.CPUSetReservedCPUs != "" {
+			if cpus, err := ParseCPUList(cfg.CPUSetReservedCPUs); err != nil || len(cpus) == 0 {
+				errors = append(errors, fmt.Sprintf("CPUSET_RESERVED_CPUS must be a CPU list like 0-1,8 (got %q)", cfg.CPUSetReservedCPUs))
+			}
+		} else if cfg.MinSystemCores < 1 {
+			errors = append(errors, "CPUSET_ISOLATION requires MIN_SYSTEM_CORES >= 1 or CPUSET_RESERVED_CPUS")
+		}
+		if cfg.CPUSetReservedNUMANode < -1 {
+			errors = append(errors, "CPUSET_RESERVED_NUMA_NODE must be -1 (any node) or a NUMA node number")
+		}
+	}
+
 	if len(errors) > 0 {
 		return fmt.Errorf("%s", strings.Join(errors, "; "))
 	}
@@ -987,6 +1363,38 @@ func isValidByteQuota(quota string) bool {
 	return err == nil
 }
 
+// IsValidSwapLimit verifica un limite di swap: byte, "max" o percentuale di
+// memory.max (es. "50%").
+func IsValidSwapLimit(value string) bool {
+	if value == "max" {
+		return true
+	}
+	if percent, ok := strings.CutSuffix(value, "%"); ok {
+		ratio, err := strconv.ParseFloat(percent, 64)
+		return err == nil && ratio >= 0
+	}
+	return isValidByteQuota(value)
+}
+
+// ResolveSwapLimit converte un limite di swap nel valore da scrivere nel
+// cgroup. Le percentuali sono calcolate su memoryMax; ok=false se value e'
+// vuoto o e' una percentuale e memory.max non e' un valore finito.
+func ResolveSwapLimit(value, memoryMax string) (string, bool) {
+	percent, isPercent := strings.CutSuffix(value, "%")
+	if !isPercent {
+		return value, value != ""
+	}
+	ratio, err := strconv.ParseFloat(percent, 64)
+	if err != nil || ratio < 0 {
+		return "", false
+	}
+	maxBytes, err := ParseRAMQuota(memoryMax)
+	if err != nil || maxBytes == 0 {
+		return "", false
+	}
+	return strconv.FormatUint(uint64(float64(maxBytes)*ratio/100), 10), true
+}
+
 // ParseRAMQuota converte una stringa di quota RAM in bytes.
 // Formati supportati: bytes, K, M, G, T (es. "1073741824", "512M", "1G")
 func ParseRAMQuota(quota string) (uint64, error) {
@@ -1692,6 +2100,35 @@ func (c *Config) GetIOThresholdDuration() int {
 	return c.IOThresholdDuration
 }
 
+// GetIOMinActiveTime returns the minimum time IO limits stay active, in seconds.
+// Falls back to MIN_ACTIVE_TIME when IO_MIN_ACTIVE_TIME is not set.
+func (c *Config) GetIOMinActiveTime() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.IOMinActiveTime > 0 {
+		return c.IOMinActiveTime
+	}
+	return c.MinActiveTime
+}
+
+// GetRAMThresholdDuration returns the RAM threshold duration in seconds.
+func (c *Config) GetRAMThresholdDuration() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.RAMThresholdDuration
+}
+
+// GetRAMMinActiveTime returns the minimum time RAM limits stay active, in seconds.
+// Falls back to MIN_ACTIVE_TIME when RAM_MIN_ACTIVE_TIME is not set.
+func (c *Config) GetRAMMinActiveTime() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.RAMMinActiveTime > 0 {
+		return c.RAMMinActiveTime
+	}
+	return c.MinActiveTime
+}
+
 // GetIORemediationEnabled returns whether IO starvation remediation is enabled.
 func (c *Config) GetIORemediationEnabled() bool {
 	c.mu.RLock()
@@ -1901,3 +2338,290 @@ func (c *Config) GetPSIBoostDuration() int {
 	defer c.mu.RUnlock()
 	return c.PSIBoostDuration
 }
+
+// GetFairShareEnabled returns whether fair-share CPU weights are enabled.
+func (c *Config) GetFairShareEnabled() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.FairShareEnabled
+}
+
+// GetFairShareHalfLife returns the half-life of the decayed CPU usage in seconds.
+func (c *Config) GetFairShareHalfLife() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.FairShareHalfLife
+}
+
+// GetFairShareMinWeight returns the lowest cpu.weight assigned by fair-share.
+func (c *Config) GetFairShareMinWeight() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.FairShareMinWeight
+}
+
+// GetFairShareMaxWeight returns the highest cpu.weight assigned by fair-share.
+func (c *Config) GetFairShareMaxWeight() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.FairShareMaxWeight
+}
+
+// Modalita' di selezione degli utenti da limitare (LIMIT_TARGET_MODE).
+const (
+	LimitTargetAll        = "all"         // tutti gli utenti eleggibili
+	LimitTargetTopN       = "top_n"       // i LIMIT_TARGET_TOP_N utenti con piu' CPU
+	LimitTargetAboveShare = "above_share" // chi supera LIMIT_TARGET_MIN_SHARE% della CPU aggregata
+)
+
+// GetLimitTargetMode returns how users are selected for limiting.
+func (c *Config) GetLimitTargetMode() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.LimitTargetMode == "" {
+		return LimitTargetAll
+	}
+	return c.LimitTargetMode
+}
+
+// GetLimitTargetTopN returns how many users are limited in top_n mode.
+func (c *Config) GetLimitTargetTopN() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.LimitTargetTopN
+}
+
+// GetLimitTargetMinShare returns the CPU share (%) needed to be limited in above_share mode.
+func (c *Config) GetLimitTargetMinShare() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.LimitTargetMinShare
+}
+
+// GetLimitTargetHysteresis returns how many share points a selected user may
+// drop below the entry bar before being released.
+func (c *Config) GetLimitTargetHysteresis() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.LimitTargetHysteresis
+}
+
+// GetDryRun returns true when cgroup writes are only recorded, not executed.
+func (c *Config) GetDryRun() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.DryRun
+}
+
+// GetStatePersistEnabled returns true when the limiter state survives daemon
+// restarts: it is saved to STATE_FILE and the existing hierarchy is adopted.
+func (c *Config) GetStatePersistEnabled() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.StatePersistEnabled && c.StateFile != ""
+}
+
+// GetStateFile returns the path of the limiter state file.
+func (c *Config) GetStateFile() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.StateFile
+}
+
+// GetCPUAccountingCgroup returns true when users with a resman cgroup are
+// accounted from cpu.stat usage_usec instead of per-PID /proc sampling.
+func (c *Config) GetCPUAccountingCgroup() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.CPUAccountingCgroup
+}
+
+// GetCPUAccountingUserSlice returns true when users without a resman cgroup
+// are accounted from systemd's user-<uid>.slice cpu.stat.
+func (c *Config) GetCPUAccountingUserSlice() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.CPUAccountingUserSlice
+}
+
+// Modalita' di gestione dei cgroup (CGROUP_MODE)
+const (
+	CgroupModePrivate      = "private"       // albero proprio sotto CGROUP_BASE, i processi vengono spostati
+	CgroupModeSystemdSlice = "systemd-slice" // limiti scritti su user.slice/user-<uid>.slice, nessuno spostamento
+)
+
+// GetCgroupMode returns how limits are applied: on resman's own cgroup tree
+// or directly on systemd's user-<uid>.slice directories.
+func (c *Config) GetCgroupMode() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.CgroupMode == "" {
+		return CgroupModePrivate
+	}
+	return c.CgroupMode
+}
+
+// GetProcessWatcherEnabled returns true when processes of limited users
+// started outside their cgroup are moved back into it.
+func (c *Config) GetProcessWatcherEnabled() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.ProcessWatcherEnabled
+}
+
+// GetProcessWatcherIntervalMs returns the interval in milliseconds between
+// /proc sweeps for escaped processes.
+func (c *Config) GetProcessWatcherIntervalMs() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.ProcessWatcherIntervalMs
+}
+
+// GetBatchDayCPUQuota returns the CPU quota for batch night users during
+// business hours.
+func (c *Config) GetBatchDayCPUQuota() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.BatchDayCPUQuota
+}
+
+// GetPatternNightHours returns the local start and end hour of the night
+// policy window. The window wraps around midnight when start > end.
+func (c *Config) GetPatternNightHours() (int, int) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.PatternNightStartHour, c.PatternNightEndHour
+}
+
+// GetPatternBusinessHours returns the local start and end hour of the
+// business hours policy window (Monday to Friday).
+func (c *Config) GetPatternBusinessHours() (int, int) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.PatternBusinessStartHour, c.PatternBusinessEndHour
+}
+
+// GetIOBoostMultiplierRange returns the smallest and largest IO boost
+// multiplier the adaptive controller may apply.
+func (c *Config) GetIOBoostMultiplierRange() (float64, float64) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.IOBoostMinMultiplier, c.IOBoostMaxMultiplier
+}
+
+// GetIOBoostStep returns the factor by which the adaptive controller raises
+// or lowers the IO boost multiplier at each check.
+func (c *Config) GetIOBoostStep() float64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.IOBoostStep
+}
+
+// Modalita' dei limiti IO (IO_LIMIT_MODE)
+const (
+	IOLimitModeMax    = "max"    // limiti rigidi io.max (IO_READ_BPS, IO_WRITE_BPS, ...)
+	IOLimitModeWeight = "weight" // io.weight proporzionale (ed eventualmente io.latency) sui sottocgroup utente
+)
+
+// GetIOLimitMode returns how IO is limited: hard io.max caps or
+// proportional io.weight shares.
+func (c *Config) GetIOLimitMode() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.IOLimitMode == "" {
+		return IOLimitModeMax
+	}
+	return c.IOLimitMode
+}
+
+// GetIOWeightRange returns the lowest and highest io.weight assigned to
+// limited users in IO_LIMIT_MODE=weight.
+func (c *Config) GetIOWeightRange() (int, int) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.IOWeightMin, c.IOWeightMax
+}
+
+// GetIOSharedWeight returns the io.weight of the shared "limited" cgroup
+// against its siblings in IO_LIMIT_MODE=weight.
+func (c *Config) GetIOSharedWeight() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.IOSharedWeight
+}
+
+// GetIOLatencyTargetUsec returns the io.latency target of limited users in
+// microseconds (0 = io.latency not used).
+func (c *Config) GetIOLatencyTargetUsec() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.IOLatencyTargetUsec
+}
+
+// GetRAMDynamicHigh indica se memory.high viene regolato a ogni ciclo
+// (budget RAM_THRESHOLD diviso per working set, corretto da PSI) invece di
+// essere fissato a RAM_HIGH_RATIO * memory.max.
+func (c *Config) GetRAMDynamicHigh() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.RAMDynamicHigh
+}
+
+// GetRAMHighMinBytes returns the lowest memory.high the dynamic controller
+// assigns to a user.
+func (c *Config) GetRAMHighMinBytes() uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	minBytes, err := ParseRAMQuota(c.RAMHighMin)
+	if err != nil || c.RAMHighMin == "max" {
+		return 128 * 1024 * 1024
+	}
+	return minBytes
+}
+
+// GetRAMHighStep returns the fraction memory.high is lowered or raised per cycle.
+func (c *Config) GetRAMHighStep() float64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.RAMHighStep <= 0 || c.RAMHighStep >= 1 {
+		return 0.1
+	}
+	return c.RAMHighStep
+}
+
+// GetRAMPSIThreshold returns the memory.pressure some avg10 percentage above
+// which a user is considered stalled on memory.
+func (c *Config) GetRAMPSIThreshold() float64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.RAMPSIThreshold <= 0 {
+		return 10
+	}
+	return c.RAMPSIThreshold
+}
+
+// GetRAMSwapLimits returns the configured memory.swap.max, memory.swap.high
+// and memory.zswap.max (bytes, "max" or percentage of memory.max; empty when
+// not set).
+func (c *Config) GetRAMSwapLimits() (swapMax, swapHigh, zswapMax string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.RAMSwapMax, c.RAMSwapHigh, c.RAMZswapMax
+}
+
+// GetCPUSetIsolation indica se il sottoalbero limited viene confinato sui
+// core non riservati tramite cpuset.cpus.
+func (c *Config) GetCPUSetIsolation() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.CPUSetIsolation
+}
+
+// GetCPUSetReserved returns the explicit reserved CPU list (empty when the
+// reserved cores are chosen automatically) and the NUMA node they are taken
+// from (-1 = any node).
+func (c *Config) GetCPUSetReserved() (string, int) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.CPUSetReservedCPUs, c.CPUSetReservedNUMANode
+}
@@ -19,6 +19,7 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
 )
 
@@ -79,6 +80,7 @@ func TestValidateConfig(t *testing.T) {
 				SystemUIDMax:           60000,
 				MetricsDBRetentionDays: 30,
 				MetricsDBWriteInterval: 30,
+				MetricsDBQueueSize:     10000,
 				UsernameCacheTTL:       60,
 			},
 			expectError: false,
@@ -443,6 +445,65 @@ func TestSetConfigField(t *testing.T) {
 			expectError: false,
 			checkFunc:   func(c *Config) bool { return c.IgnoreSystemLoad == true },
 		},
+		{
+			name:        "set RAM_MIN_ACTIVE_TIME",
+			key:         "RAM_MIN_ACTIVE_TIME",
+			value:       "300",
+			expectError: false,
+			checkFunc:   func(c *Config) bool { return c.GetRAMMinActiveTime() == 300 },
+		},
+		{
+			name:        "IO_MIN_ACTIVE_TIME falls back to MIN_ACTIVE_TIME",
+			key:         "IO_MIN_ACTIVE_TIME",
+			value:       "0",
+			expectError: false,
+			checkFunc:   func(c *Config) bool { return c.GetIOMinActiveTime() == c.MinActiveTime },
+		},
+		{
+			name:        "set DRY_RUN",
+			key:         "DRY_RUN",
+			value:       "true",
+			expectError: false,
+			checkFunc:   func(c *Config) bool { return c.GetDryRun() },
+		},
+		{
+			name:        "set STATE_PERSIST_ENABLED",
+			key:         "STATE_PERSIST_ENABLED",
+			value:       "true",
+			expectError: false,
+			checkFunc:   func(c *Config) bool { return c.GetStatePersistEnabled() },
+		},
+		{
+			name:        "set CPU_ACCOUNTING_CGROUP",
+			key:         "CPU_ACCOUNTING_CGROUP",
+			value:       "false",
+			expectError: false,
+			checkFunc:   func(c *Config) bool { return !c.GetCPUAccountingCgroup() },
+		},
+		{
+			name:        "set PROCESS_WATCHER_INTERVAL_MS",
+			key:         "PROCESS_WATCHER_INTERVAL_MS",
+			value:       "250",
+			expectError: false,
+			checkFunc:   func(c *Config) bool { return c.GetProcessWatcherIntervalMs() == 250 },
+		},
+		{
+			name:        "set PATTERN_NIGHT_START_HOUR",
+			key:         "PATTERN_NIGHT_START_HOUR",
+			value:       "23",
+			expectError: false,
+			checkFunc: func(c *Config) bool {
+				start, end := c.GetPatternNightHours()
+				return start == 23 && end == 6
+			},
+		},
+		{
+			name:        "set CGROUP_MODE",
+			key:         "CGROUP_MODE",
+			value:       "Systemd-Slice",
+			expectError: false,
+			checkFunc:   func(c *Config) bool { return c.GetCgroupMode() == CgroupModeSystemdSlice },
+		},
 		{
 			name:        "unknown key (should not error)",
 			key:         "UNKNOWN_KEY",
@@ -469,3 +530,344 @@ func TestSetConfigField(t *testing.T) {
 		})
 	}
 }
+
+func TestUserGroups(t *testing.T) {
+	tmpDir := t.TempDir()
+	configFile := filepath.Join(tmpDir, "test.conf")
+
+	content := `GROUP_staff=^prof_.*,^admin$
+GROUP_students=^s[0-9]+$
+GROUP_students_CPU_QUOTA=200000 100000
+GROUP_students_CPU_WEIGHT=50
+GROUP_students_RAM_QUOTA=8G
+GROUP_students_IO_WRITE_BPS=50M
+GROUP_staff_CPU_WEIGHT=400
+`
+	if err := os.WriteFile(configFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write test config: %v", err)
+	}
+
+	cfg := DefaultConfig()
+	if err := loadFromFile(configFile, cfg); err != nil {
+		t.Fatalf("loadFromFile() error: %v", err)
+	}
+	if err := validateConfig(cfg); err != nil {
+		t.Fatalf("validateConfig() error: %v", err)
+	}
+
+	groups := cfg.GetUserGroups()
+	if len(groups) != 2 || groups[0].Name != "staff" || groups[1].Name != "students" {
+		t.Fatalf("unexpected groups: %+v", groups)
+	}
+
+	students, ok := cfg.GetUserGroup("s12345")
+	if !ok || students.Name != "students" {
+		t.Fatalf("s12345 should belong to students, got %+v (ok=%v)", students, ok)
+	}
+	if students.CPUQuota != "200000 100000" || students.CPUWeight != 50 || students.RAMQuota != "8G" || students.IOWriteBPS != "50M" {
+		t.Errorf("unexpected students limits: %+v", students)
+	}
+	if !students.HasIOLimits() {
+		t.Error("students should have IO limits")
+	}
+
+	if staff, ok := cfg.GetUserGroup("prof_rossi"); !ok || staff.CPUWeight != 400 {
+		t.Errorf("prof_rossi should belong to staff with weight 400, got %+v (ok=%v)", staff, ok)
+	}
+	if _, ok := cfg.GetUserGroup("guest"); ok {
+		t.Error("guest should not belong to any group")
+	}
+
+	// Un gruppo con sole impostazioni e senza pattern non e' valido
+	cfg = DefaultConfig()
+	if err := setConfigField(cfg, "GROUP_orphan_CPU_WEIGHT", "10"); err != nil {
+		t.Fatalf("setConfigField() error: %v", err)
+	}
+	if err := validateConfig(cfg); err == nil {
+		t.Error("expected validation error for group without patterns")
+	}
+
+	if err := setConfigField(DefaultConfig(), "GROUP_user_1000", ".*"); err == nil {
+		t.Error("expected error for reserved group name prefix")
+	}
+}
+
+func TestIODeviceLimits(t *testing.T) {
+	tmpDir := t.TempDir()
+	configFile := filepath.Join(tmpDir, "test.conf")
+
+	content := `IO_DEVICE_DISCOVERY=true
+IO_DEVICE_FILTER=all
+IO_DEVICE_EXCLUDE=^loop,^dm-
+IO_DEVICE_nvme0n1_READ_BPS=max
+IO_DEVICE_nvme0n1_WRITE_IOPS=0
+IO_DEVICE_wwn-0x5000c500a1b2c3d4_WRITE_BPS=20M
+IO_DEVICE_wwn-0x5000c500a1b2c3d4_WRITE_IOPS=100
+`
+	if err := os.WriteFile(configFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write test config: %v", err)
+	}
+
+	cfg := DefaultConfig()
+	if err := loadFromFile(configFile, cfg); err != nil {
+		t.Fatalf("loadFromFile() error: %v", err)
+	}
+	if err := validateConfig(cfg); err != nil {
+		t.Fatalf("validateConfig() error: %v", err)
+	}
+
+	limits := cfg.GetIODeviceLimits()
+	expected := []IODeviceLimit{
+		{Selector: "nvme0n1", ReadBPS: "max", WriteIOPS: -1},
+		{Selector: "wwn-0x5000c500a1b2c3d4", WriteBPS: "20M", WriteIOPS: 100},
+	}
+	if !reflect.DeepEqual(limits, expected) {
+		t.Errorf("IO device limits = %+v, expected %+v", limits, expected)
+	}
+	if cfg.GetIODeviceFilter() != "all" {
+		t.Errorf("IO_DEVICE_FILTER should still be a fixed key, got %q", cfg.GetIODeviceFilter())
+	}
+	if !cfg.IsIODeviceExcluded("loop3") || cfg.IsIODeviceExcluded("sda") {
+		t.Error("IO_DEVICE_EXCLUDE should exclude loop3 and keep sda")
+	}
+
+	// I limiti per dispositivo richiedono la discovery
+	cfg.IODeviceDiscovery = false
+	if err := validateConfig(cfg); err == nil {
+		t.Error("expected validation error for per-device limits without IO_DEVICE_DISCOVERY")
+	}
+
+	if err := setConfigField(DefaultConfig(), "IO_DEVICE_sda", "20M"); err == nil {
+		t.Error("expected error for IO device key without a setting suffix")
+	}
+	if err := setConfigField(DefaultConfig(), "IO_DEVICE_sda_READ_IOPS", "fast"); err == nil {
+		t.Error("expected error for invalid per-device IOPS")
+	}
+}
+
+func TestIOLimitMode(t *testing.T) {
+	cfg := DefaultConfig()
+	if cfg.GetIOLimitMode() != IOLimitModeMax {
+		t.Errorf("default IO_LIMIT_MODE = %s, expected %s", cfg.GetIOLimitMode(), IOLimitModeMax)
+	}
+
+	cfg.IOEnabled = true
+	if err := setConfigField(cfg, "IO_LIMIT_MODE", "Weight"); err != nil {
+		t.Fatalf("setConfigField(IO_LIMIT_MODE) error: %v", err)
+	}
+	if cfg.GetIOLimitMode() != IOLimitModeWeight {
+		t.Errorf("IO_LIMIT_MODE = %s, expected %s", cfg.GetIOLimitMode(), IOLimitModeWeight)
+	}
+	if err := validateConfig(cfg); err != nil {
+		t.Errorf("validateConfig() error with default IO weights: %v", err)
+	}
+
+	cfg.IOWeightMin, cfg.IOWeightMax = 500, 100
+	if err := validateConfig(cfg); err == nil {
+		t.Error("expected validation error for IO_WEIGHT_MIN > IO_WEIGHT_MAX")
+	}
+	cfg.IOWeightMin, cfg.IOWeightMax = 25, 20000
+	if err := validateConfig(cfg); err == nil {
+		t.Error("expected validation error for IO_WEIGHT_MAX above 10000")
+	}
+
+	cfg.IOWeightMax = 400
+	cfg.IOLimitMode = "latency"
+	if err := validateConfig(cfg); err == nil {
+		t.Error("expected validation error for unknown IO_LIMIT_MODE")
+	}
+}
+
+func TestRAMDynamicHigh(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RAMEnabled = true
+	for key, value := range map[string]string{
+		"RAM_DYNAMIC_HIGH":  "true",
+		"RAM_HIGH_MIN":      "256M",
+		"RAM_HIGH_STEP":     "0.2",
+		"RAM_PSI_THRESHOLD": "25",
+	} {
+		if err := setConfigField(cfg, key, value); err != nil {
+			t.Fatalf("setConfigField(%s) error: %v", key, err)
+		}
+	}
+	if err := validateConfig(cfg); err != nil {
+		t.Fatalf("validateConfig() error: %v", err)
+	}
+	if !cfg.GetRAMDynamicHigh() || cfg.GetRAMHighMinBytes() != 256*1024*1024 ||
+		cfg.GetRAMHighStep() != 0.2 || cfg.GetRAMPSIThreshold() != 25 {
+		t.Errorf("unexpected dynamic memory.high settings: enabled=%v min=%d step=%v psi=%v",
+			cfg.GetRAMDynamicHigh(), cfg.GetRAMHighMinBytes(), cfg.GetRAMHighStep(), cfg.GetRAMPSIThreshold())
+	}
+
+	cfg.RAMHighStep = 1
+	if err := validateConfig(cfg); err == nil {
+		t.Error("expected validation error for RAM_HIGH_STEP = 1")
+	}
+	cfg.RAMHighStep = 0.1
+	cfg.RAMHighMin = "lots"
+	if err := validateConfig(cfg); err == nil {
+		t.Error("expected validation error for invalid RAM_HIGH_MIN")
+	}
+}
+
+func TestRAMSwapLimits(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RAMEnabled = true
+	for key, value := range map[string]string{
+		"RAM_SWAP_MAX":  "50%",
+		"RAM_SWAP_HIGH": "512M",
+		"RAM_ZSWAP_MAX": "max",
+	} {
+		if err := setConfigField(cfg, key, value); err != nil {
+			t.Fatalf("setConfigField(%s) error: %v", key, err)
+		}
+	}
+	if err := validateConfig(cfg); err != nil {
+		t.Fatalf("validateConfig() error: %v", err)
+	}
+
+	tests := []struct {
+		value, memoryMax string
+		expected         string
+		ok               bool
+	}{
+		{"50%", "2G", "1073741824", true},
+		{"512M", "max", "512M", true},
+		{"50%", "max", "", false}, // percentuale senza memory.max finito
+		{"", "2G", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := ResolveSwapLimit(tt.value, tt.memoryMax)
+		if got != tt.expected || ok != tt.ok {
+			t.Errorf("ResolveSwapLimit(%q, %q) = %q, %v; expected %q, %v", tt.value, tt.memoryMax, got, ok, tt.expected, tt.ok)
+		}
+	}
+
+	cfg.RAMSwapHigh = "half"
+	if err := validateConfig(cfg); err == nil {
+		t.Error("expected validation error for invalid RAM_SWAP_HIGH")
+	}
+}
+
+func TestCPUSetIsolation(t *testing.T) {
+	cpus, err := ParseCPUList("0-2, 8,5,10-11,1\n")
+	if err != nil {
+		t.Fatalf("ParseCPUList() error: %v", err)
+	}
+	if got := FormatCPUList(cpus); got != "0-2,5,8,10-11" {
+		t.Errorf("FormatCPUList(ParseCPUList()) = %q, expected 0-2,5,8,10-11", got)
+	}
+	for _, invalid := range []string{"a", "3-1", "-1", "0-"} {
+		if _, err := ParseCPUList(invalid); err == nil {
+			t.Errorf("ParseCPUList(%q) should fail", invalid)
+		}
+	}
+
+	cfg := DefaultConfig()
+	if err := setConfigField(cfg, "CPUSET_ISOLATION", "true"); err != nil {
+		t.Fatalf("setConfigField(CPUSET_ISOLATION) error: %v", err)
+	}
+	if err := setConfigField(cfg, "CPUSET_RESERVED_CPUS", "0-1"); err != nil {
+		t.Fatalf("setConfigField(CPUSET_RESERVED_CPUS) error: %v", err)
+	}
+	if err := validateConfig(cfg); err != nil {
+		t.Fatalf("validateConfig() error: %v", err)
+	}
+	if list, node := cfg.GetCPUSetReserved(); list != "0-1" || node != -1 {
+		t.Errorf("GetCPUSetReserved() = %q, %d; expected 0-1, -1", list, node)
+	}
+
+	cfg.CPUSetReservedCPUs = "0,x"
+	if err := validateConfig(cfg); err == nil {
+		t.Error("expected validation error for invalid CPUSET_RESERVED_CPUS")
+	}
+}
+
+func TestUserOverrides(t *testing.T) {
+	tmpDir := t.TempDir()
+	overridesFile := filepath.Join(tmpDir, "users.conf")
+
+	content := `# override per-utente
+[regex:^s[0-9]+$]
+IO_WRITE_BPS=20M
+RAM_MAX=4G
+
+[uid:1005]
+CPU_MAX=200000 100000
+
+[alice]
+CPU_WEIGHT=300
+RAM_MAX=16G   # piu' memoria per alice
+SWAP_MAX=25%
+`
+	if err := os.WriteFile(overridesFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write overrides file: %v", err)
+	}
+
+	overrides, err := LoadUserOverrides(overridesFile)
+	if err != nil {
+		t.Fatalf("LoadUserOverrides() error: %v", err)
+	}
+	if overrides.Len() != 3 {
+		t.Fatalf("expected 3 sections, got %d", overrides.Len())
+	}
+
+	alice, ok := overrides.Lookup(1000, "alice")
+	if !ok || alice.CPUWeight != 300 || alice.RAMMax != "16G" || alice.SwapMax != "25%" {
+		t.Errorf("unexpected override for alice: %+v (ok=%v)", alice, ok)
+	}
+
+	// Sezioni combinate: regex + uid
+	student, ok := overrides.Lookup(1005, "s123")
+	if !ok || student.CPUMax != "200000 100000" || student.RAMMax != "4G" || student.IOWriteBPS != "20M" {
+		t.Errorf("unexpected merged override for s123: %+v (ok=%v)", student, ok)
+	}
+	if !student.HasIOLimits() {
+		t.Error("s123 should have IO limits")
+	}
+
+	if _, ok := overrides.Lookup(2000, "bob"); ok {
+		t.Error("bob should not have overrides")
+	}
+
+	// File mancante: nessun override, nessun errore
+	missing, err := LoadUserOverrides(filepath.Join(tmpDir, "missing.conf"))
+	if err != nil || missing.Len() != 0 {
+		t.Errorf("missing file should yield empty overrides, got len=%d err=%v", missing.Len(), err)
+	}
+
+	invalid := map[string]string{
+		"key outside section": "CPU_WEIGHT=100\n",
+		"unknown key":         "[alice]\nCPU_SHARES=100\n",
+		"invalid weight":      "[alice]\nCPU_WEIGHT=0\n",
+		"invalid regex":       "[regex:(]\nCPU_WEIGHT=100\n",
+		"invalid uid":         "[uid:abc]\nCPU_WEIGHT=100\n",
+		"invalid size":        "[alice]\nRAM_MAX=lots\n",
+		"invalid swap":        "[alice]\nSWAP_HIGH=-5%\n",
+	}
+	for name, body := range invalid {
+		if err := os.WriteFile(overridesFile, []byte(body), 0644); err != nil {
+			t.Fatalf("Failed to write overrides file: %v", err)
+		}
+		if _, err := LoadUserOverrides(overridesFile); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+
+	// Caricato insieme alla configurazione principale
+	configFile := filepath.Join(tmpDir, "test.conf")
+	if err := os.WriteFile(overridesFile, []byte("[alice]\nCPU_WEIGHT=250\n"), 0644); err != nil {
+		t.Fatalf("Failed to write overrides file: %v", err)
+	}
+	if err := os.WriteFile(configFile, []byte("USER_OVERRIDES_FILE="+overridesFile+"\n"), 0644); err != nil {
+		t.Fatalf("Failed to write test config: %v", err)
+	}
+	cfg, err := LoadAndValidate(configFile)
+	if err != nil {
+		t.Fatalf("LoadAndValidate() error: %v", err)
+	}
+	if override, ok := cfg.GetUserOverride(1000, "alice"); !ok || override.CPUWeight != 250 {
+		t.Errorf("expected alice weight 250 from config, got %+v (ok=%v)", override, ok)
+	}
+}
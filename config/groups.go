@@ -0,0 +1,226 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+// config/groups.go
+package config
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// groupKeyPrefix e' il prefisso delle chiavi che dichiarano i gruppi utente.
+const groupKeyPrefix = "GROUP_"
+
+// UserGroup descrive un gruppo di utenti con quote proprie dentro il cgroup
+// condiviso "limited". Gli utenti del gruppo vengono annidati in
+// limited/<name>/user_<uid>.
+type UserGroup struct {
+	Name        string   // Nome del gruppo (anche nome della directory cgroup)
+	Patterns    []string // Regex sugli username (stesso formato di USER_INCLUDE_LIST)
+	CPUQuota    string   // cpu.max del gruppo ("quota period"), vuoto = nessun limite proprio
+	CPUWeight   int      // cpu.weight del gruppo rispetto agli altri gruppi (0 = default 100)
+	RAMQuota    string   // memory.max del gruppo (es. "8G"), vuoto = nessun limite proprio
+	IOReadBPS   string   // io.max rbps del gruppo (es. "200M"), vuoto = max
+	IOWriteBPS  string   // io.max wbps del gruppo, vuoto = max
+	IOReadIOPS  int      // io.max riops del gruppo, 0 = max
+	IOWriteIOPS int      // io.max wiops del gruppo, 0 = max
+}
+
+// HasIOLimits indica se il gruppo definisce almeno un limite IO.
+func (g UserGroup) HasIOLimits() bool {
+	return g.IOReadBPS != "" || g.IOWriteBPS != "" || g.IOReadIOPS > 0 || g.IOWriteIOPS > 0
+}
+
+var groupNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+// groupFieldHandlers gestisce le chiavi GROUP_<name>_<FIELD>.
+var groupFieldHandlers = map[string]func(*UserGroup, string) error{
+	"CPU_QUOTA": func(g *UserGroup, value string) error {
+		g.CPUQuota = value
+		return nil
+	},
+	"CPU_WEIGHT": func(g *UserGroup, value string) error {
+		weight, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", value)
+		}
+		g.CPUWeight = weight
+		return nil
+	},
+	"RAM_QUOTA": func(g *UserGroup, value string) error {
+		g.RAMQuota = value
+		return nil
+	},
+	"IO_READ_BPS": func(g *UserGroup, value string) error {
+		g.IOReadBPS = value
+		return nil
+	},
+	"IO_WRITE_BPS": func(g *UserGroup, value string) error {
+		g.IOWriteBPS = value
+		return nil
+	},
+	"IO_READ_IOPS": func(g *UserGroup, value string) error {
+		iops, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", value)
+		}
+		g.IOReadIOPS = iops
+		return nil
+	},
+	"IO_WRITE_IOPS": func(g *UserGroup, value string) error {
+		iops, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", value)
+		}
+		g.IOWriteIOPS = iops
+		return nil
+	},
+}
+
+// splitGroupKey separa "students_CPU_QUOTA" in ("students", "CPU_QUOTA").
+// Una chiave senza suffisso noto dichiara il gruppo stesso (field vuoto).
+func splitGroupKey(rest string) (name, field string) {
+	for suffix := range groupFieldHandlers {
+		if strings.HasSuffix(rest, "_"+suffix) {
+			candidate := strings.TrimSuffix(rest, "_"+suffix)
+			// Preferisci il suffisso piu' lungo (es. IO_READ_IOPS vs READ_IOPS)
+			if candidate != "" && len(suffix) > len(field) {
+				name, field = candidate, suffix
+			}
+		}
+	}
+	if field == "" {
+		return rest, ""
+	}
+	return name, field
+}
+
+// setGroupField gestisce GROUP_<name>=regex e GROUP_<name>_<FIELD>=value.
+func setGroupField(cfg *Config, key, value string) error {
+	name, field := splitGroupKey(strings.TrimPrefix(key, groupKeyPrefix))
+	if !groupNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid group name %q: use letters, digits, '-' or '_'", name)
+	}
+	if strings.HasPrefix(name, "user_") {
+		return fmt.Errorf("invalid group name %q: 'user_' prefix is reserved for user sub-cgroups", name)
+	}
+
+	group := cfg.userGroup(name)
+	if field == "" {
+		patterns, err := parseRegexList(value, " in "+key)
+		if err != nil {
+			return err
+		}
+		group.Patterns = patterns
+		return nil
+	}
+	if err := groupFieldHandlers[field](group, value); err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	return nil
+}
+
+// userGroup restituisce il gruppo con il nome indicato, creandolo se manca.
+// L'ordine di dichiarazione e' anche l'ordine di priorita' nel matching.
+func (c *Config) userGroup(name string) *UserGroup {
+	for _, group := range c.UserGroups {
+		if group.Name == name {
+			return group
+		}
+	}
+	group := &UserGroup{Name: name}
+	c.UserGroups = append(c.UserGroups, group)
+	return group
+}
+
+// loadGroupsFromEnvironment legge le variabili GROUP_* dall'ambiente.
+func loadGroupsFromEnvironment(cfg *Config) []string {
+	var warnings []string
+	for _, env := range os.Environ() {
+		if !strings.HasPrefix(env, groupKeyPrefix) {
+			continue
+		}
+		parts := strings.SplitN(env, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[1]) == "" {
+			continue
+		}
+		if err := setGroupField(cfg, parts[0], strings.TrimSpace(parts[1])); err != nil {
+			warnings = append(warnings, fmt.Sprintf("Ignoring %s: %v", parts[0], err))
+		}
+	}
+	return warnings
+}
+
+// validateGroups controlla la definizione dei gruppi utente.
+func validateGroups(cfg *Config) []string {
+	var errors []string
+	for _, group := range cfg.UserGroups {
+		key := groupKeyPrefix + group.Name
+		if len(group.Patterns) == 0 {
+			errors = append(errors, fmt.Sprintf("%s must define at least one username pattern", key))
+		}
+		if group.CPUQuota != "" && !isValidCPUQuota(group.CPUQuota) {
+			errors = append(errors, fmt.Sprintf("%s_CPU_QUOTA must be in format 'quota period' or 'max period'", key))
+		}
+		if group.CPUWeight < 0 || group.CPUWeight > 10000 {
+			errors = append(errors, fmt.Sprintf("%s_CPU_WEIGHT must be between 0 and 10000 (0 = default)", key))
+		}
+		if group.RAMQuota != "" && group.RAMQuota != "max" && !isValidByteQuota(group.RAMQuota) {
+			errors = append(errors, fmt.Sprintf("%s_RAM_QUOTA must be a valid size (e.g., 512M, 8G)", key))
+		}
+		if group.IOReadBPS != "" && group.IOReadBPS != "max" && !isValidByteQuota(group.IOReadBPS) {
+			errors = append(errors, fmt.Sprintf("%s_IO_READ_BPS must be a valid size (e.g., 100M)", key))
+		}
+		if group.IOWriteBPS != "" && group.IOWriteBPS != "max" && !isValidByteQuota(group.IOWriteBPS) {
+			errors = append(errors, fmt.Sprintf("%s_IO_WRITE_BPS must be a valid size (e.g., 100M)", key))
+		}
+		if group.IOReadIOPS < 0 || group.IOWriteIOPS < 0 {
+			errors = append(errors, fmt.Sprintf("%s_IO_READ_IOPS/%s_IO_WRITE_IOPS cannot be negative", key, key))
+		}
+	}
+	return errors
+}
+
+// GetUserGroups restituisce una copia dei gruppi configurati, in ordine di dichiarazione.
+func (c *Config) GetUserGroups() []UserGroup {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	groups := make([]UserGroup, 0, len(c.UserGroups))
+	for _, group := range c.UserGroups {
+		copied := *group
+		copied.Patterns = append([]string(nil), group.Patterns...)
+		groups = append(groups, copied)
+	}
+	return groups
+}
+
+// GetUserGroup restituisce il primo gruppo (in ordine di dichiarazione) il cui
+// pattern corrisponde allo username. ok=false se l'utente non appartiene a nessun gruppo.
+func (c *Config) GetUserGroup(username string) (UserGroup, bool) {
+	for _, group := range c.GetUserGroups() {
+		for _, pattern := range group.Patterns {
+			if c.matchPattern(pattern, username) {
+				return group, true
+			}
+		}
+	}
+	return UserGroup{}, false
+}
@@ -0,0 +1,189 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+// config/io_devices.go
+package config
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ioDeviceKeyPrefix e' il prefisso delle chiavi con i limiti IO per
+// dispositivo. Le chiavi fisse che condividono il prefisso (IO_DEVICE_FILTER,
+// IO_DEVICE_DISCOVERY, IO_DEVICE_EXCLUDE) sono gestite prima da configFieldHandlers.
+const ioDeviceKeyPrefix = "IO_DEVICE_"
+
+// IODeviceLimit sono i limiti io.max di un dispositivo a blocchi, che
+// sostituiscono IO_READ_BPS/IO_WRITE_BPS/IO_READ_IOPS/IO_WRITE_IOPS su quel
+// dispositivo. I campi vuoti (o 0) ereditano il limite globale.
+type IODeviceLimit struct {
+	Selector  string // Nome del dispositivo (es. nvme0n1, sda) o WWN (es. wwn-0x5000c500a1b2c3d4)
+	ReadBPS   string // rbps, "max" = nessun limite
+	WriteBPS  string // wbps, "max" = nessun limite
+	ReadIOPS  int    // riops, -1 = nessun limite (0 = non impostato)
+	WriteIOPS int    // wiops, -1 = nessun limite (0 = non impostato)
+}
+
+var ioDeviceSelectorPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._:-]*$`)
+
+// parseDeviceIOPS accetta un intero >= 0 oppure "max". Come per IO_READ_IOPS,
+// 0 e "max" tolgono il limite (-1, per distinguerlo dal campo non impostato).
+func parseDeviceIOPS(value string) (int, error) {
+	if value == "max" || value == "0" {
+		return -1, nil
+	}
+	iops, err := strconv.Atoi(value)
+	if err != nil || iops < 0 {
+		return 0, fmt.Errorf("invalid IOPS %q: use a non-negative integer or 'max'", value)
+	}
+	return iops, nil
+}
+
+// ioDeviceFieldHandlers gestisce le chiavi IO_DEVICE_<selector>_<FIELD>.
+var ioDeviceFieldHandlers = map[string]func(*IODeviceLimit, string) error{
+	"READ_BPS": func(d *IODeviceLimit, value string) error {
+		d.ReadBPS = value
+		return nil
+	},
+	"WRITE_BPS": func(d *IODeviceLimit, value string) error {
+		d.WriteBPS = value
+		return nil
+	},
+	"READ_IOPS": func(d *IODeviceLimit, value string) error {
+		iops, err := parseDeviceIOPS(value)
+		d.ReadIOPS = iops
+		return err
+	},
+	"WRITE_IOPS": func(d *IODeviceLimit, value string) error {
+		iops, err := parseDeviceIOPS(value)
+		d.WriteIOPS = iops
+		return err
+	},
+}
+
+// setIODeviceField gestisce IO_DEVICE_<selector>_<FIELD>=value.
+func setIODeviceField(cfg *Config, key, value string) error {
+	rest := strings.TrimPrefix(key, ioDeviceKeyPrefix)
+	var selector, field string
+	for suffix := range ioDeviceFieldHandlers {
+		if strings.HasSuffix(rest, "_"+suffix) {
+			selector, field = strings.TrimSuffix(rest, "_"+suffix), suffix
+			break
+		}
+	}
+	if field == "" {
+		return fmt.Errorf("unknown IO device setting %q: expected %s<device>_READ_BPS, _WRITE_BPS, _READ_IOPS or _WRITE_IOPS", key, ioDeviceKeyPrefix)
+	}
+	if !ioDeviceSelectorPattern.MatchString(selector) {
+		return fmt.Errorf("invalid IO device %q: use a device name (e.g. sda) or a WWN (e.g. wwn-0x5000c500a1b2c3d4)", selector)
+	}
+	if err := ioDeviceFieldHandlers[field](cfg.ioDeviceLimit(selector), value); err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	return nil
+}
+
+// ioDeviceLimit restituisce i limiti del dispositivo indicato, creandoli se mancano.
+func (c *Config) ioDeviceLimit(selector string) *IODeviceLimit {
+	for _, limit := range c.IODeviceLimits {
+		if limit.Selector == selector {
+			return limit
+		}
+	}
+	limit := &IODeviceLimit{Selector: selector}
+	c.IODeviceLimits = append(c.IODeviceLimits, limit)
+	return limit
+}
+
+// loadIODevicesFromEnvironment legge le variabili IO_DEVICE_<selector>_* dall'ambiente.
+func loadIODevicesFromEnvironment(cfg *Config) []string {
+	var warnings []string
+	for _, env := range os.Environ() {
+		if !strings.HasPrefix(env, ioDeviceKeyPrefix) {
+			continue
+		}
+		parts := strings.SplitN(env, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[1]) == "" {
+			continue
+		}
+		if _, fixed := configFieldHandlers[parts[0]]; fixed {
+			continue
+		}
+		if err := setIODeviceField(cfg, parts[0], strings.TrimSpace(parts[1])); err != nil {
+			warnings = append(warnings, fmt.Sprintf("Ignoring %s: %v", parts[0], err))
+		}
+	}
+	return warnings
+}
+
+// validateIODevices controlla i limiti per dispositivo.
+func validateIODevices(cfg *Config) []string {
+	var errors []string
+	if len(cfg.IODeviceLimits) > 0 && !cfg.IODeviceDiscovery {
+		errors = append(errors, "per-device IO limits (IO_DEVICE_<device>_*) require IO_DEVICE_DISCOVERY=true")
+	}
+	for _, limit := range cfg.IODeviceLimits {
+		key := ioDeviceKeyPrefix + limit.Selector
+		if limit.ReadBPS != "" && limit.ReadBPS != "max" && !isValidByteQuota(limit.ReadBPS) {
+			errors = append(errors, fmt.Sprintf("%s_READ_BPS must be a valid size (e.g., 100M) or 'max'", key))
+		}
+		if limit.WriteBPS != "" && limit.WriteBPS != "max" && !isValidByteQuota(limit.WriteBPS) {
+			errors = append(errors, fmt.Sprintf("%s_WRITE_BPS must be a valid size (e.g., 100M) or 'max'", key))
+		}
+	}
+	return errors
+}
+
+// GetIODeviceDiscovery indica se i limiti IO vanno scritti per ogni
+// dispositivo scoperto in /sys/block invece che con una sola riga.
+func (c *Config) GetIODeviceDiscovery() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.IODeviceDiscovery
+}
+
+// GetIODeviceExcludeList restituisce le regex dei dispositivi esclusi dalla discovery.
+func (c *Config) GetIODeviceExcludeList() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]string(nil), c.IODeviceExcludeList...)
+}
+
+// IsIODeviceExcluded indica se un dispositivo (per nome) e' escluso dalla discovery.
+func (c *Config) IsIODeviceExcluded(name string) bool {
+	for _, pattern := range c.GetIODeviceExcludeList() {
+		if c.matchPattern(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetIODeviceLimits restituisce una copia dei limiti per dispositivo, in ordine di dichiarazione.
+func (c *Config) GetIODeviceLimits() []IODeviceLimit {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	limits := make([]IODeviceLimit, 0, len(c.IODeviceLimits))
+	for _, limit := range c.IODeviceLimits {
+		limits = append(limits, *limit)
+	}
+	return limits
+}
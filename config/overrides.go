@@ -0,0 +1,358 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+// config/overrides.go
+package config
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// UserOverride contiene i limiti personali di un utente letti da USER_OVERRIDES_FILE.
+// I campi vuoti (o zero) non sovrascrivono i valori globali.
+type UserOverride struct {
+	CPUWeight   int    // cpu.weight del sottocgroup utente (0 = fair-share/default)
+	CPUMax      string // cpu.max del sottocgroup utente ("quota period")
+	RAMHigh     string // memory.high (es. "6G")
+	RAMMax      string // memory.max (es. "8G")
+	IOReadBPS   string // io.max rbps (es. "100M")
+	IOWriteBPS  string // io.max wbps
+	IOReadIOPS  int    // io.max riops
+	IOWriteIOPS int    // io.max wiops
+	SwapMax     string // memory.swap.max (byte, "max" o percentuale di memory.max)
+	SwapHigh    string // memory.swap.high
+	ZswapMax    string // memory.zswap.max
+}
+
+// HasIOLimits indica se l'override definisce almeno un limite IO.
+func (o UserOverride) HasIOLimits() bool {
+	return o.IOReadBPS != "" || o.IOWriteBPS != "" || o.IOReadIOPS > 0 || o.IOWriteIOPS > 0
+}
+
+// merge copia in o i campi valorizzati di other.
+func (o *UserOverride) merge(other UserOverride) {
+	if other.CPUWeight > 0 {
+		o.CPUWeight = other.CPUWeight
+	}
+	if other.CPUMax != "" {
+		o.CPUMax = other.CPUMax
+	}
+	if other.RAMHigh != "" {
+		o.RAMHigh = other.RAMHigh
+	}
+	if other.RAMMax != "" {
+		o.RAMMax = other.RAMMax
+	}
+	if other.IOReadBPS != "" {
+		o.IOReadBPS = other.IOReadBPS
+	}
+	if other.IOWriteBPS != "" {
+		o.IOWriteBPS = other.IOWriteBPS
+	}
+	if other.IOReadIOPS > 0 {
+		o.IOReadIOPS = other.IOReadIOPS
+	}
+	if other.IOWriteIOPS > 0 {
+		o.IOWriteIOPS = other.IOWriteIOPS
+	}
+	if other.SwapMax != "" {
+		o.SwapMax = other.SwapMax
+	}
+	if other.SwapHigh != "" {
+		o.SwapHigh = other.SwapHigh
+	}
+	if other.ZswapMax != "" {
+		o.ZswapMax = other.ZswapMax
+	}
+}
+
+// Tipi di selettore di una sezione del file di override.
+const (
+	overrideByUsername = "username"
+	overrideByUID      = "uid"
+	overrideByRegex    = "regex"
+)
+
+type userOverrideEntry struct {
+	kind     string
+	username string
+	uid      int
+	pattern  *regexp.Regexp
+	override UserOverride
+}
+
+// UserOverrides e' il contenuto di USER_OVERRIDES_FILE.
+//
+// Formato (una sezione per utente, chiave=valore come resman.conf):
+//
+//	[alice]                 # username
+//	CPU_WEIGHT=300
+//	RAM_MAX=16G
+//
+//	[uid:1005]              # UID
+//	CPU_MAX=200000 100000
+//
+//	[regex:^stud[0-9]+$]    # regex sullo username
+//	IO_WRITE_BPS=20M
+//
+// Se piu' sezioni corrispondono allo stesso utente i campi vengono combinati:
+// la prima regex che corrisponde, poi la sezione per UID, poi quella per username.
+type UserOverrides struct {
+	entries []userOverrideEntry
+}
+
+// overrideFieldHandlers gestisce le chiavi ammesse nelle sezioni del file di override.
+var overrideFieldHandlers = map[string]func(*UserOverride, string) error{
+	"CPU_WEIGHT": func(o *UserOverride, value string) error {
+		weight, err := strconv.Atoi(value)
+		if err != nil || weight < 1 || weight > 10000 {
+			return fmt.Errorf("must be an integer between 1 and 10000, got %q", value)
+		}
+		o.CPUWeight = weight
+		return nil
+	},
+	"CPU_MAX": func(o *UserOverride, value string) error {
+		if !isValidCPUQuota(value) {
+			return fmt.Errorf("must be in format 'quota period' or 'max period', got %q", value)
+		}
+		o.CPUMax = value
+		return nil
+	},
+	"RAM_HIGH": func(o *UserOverride, value string) error {
+		if value != "max" && !isValidByteQuota(value) {
+			return fmt.Errorf("must be a valid size (e.g., 512M, 8G), got %q", value)
+		}
+		o.RAMHigh = value
+		return nil
+	},
+	"RAM_MAX": func(o *UserOverride, value string) error {
+		if value != "max" && !isValidByteQuota(value) {
+			return fmt.Errorf("must be a valid size (e.g., 512M, 8G), got %q", value)
+		}
+		o.RAMMax = value
+		return nil
+	},
+	"IO_READ_BPS": func(o *UserOverride, value string) error {
+		if value != "max" && !isValidByteQuota(value) {
+			return fmt.Errorf("must be a valid size (e.g., 100M), got %q", value)
+		}
+		o.IOReadBPS = value
+		return nil
+	},
+	"IO_WRITE_BPS": func(o *UserOverride, value string) error {
+		if value != "max" && !isValidByteQuota(value) {
+			return fmt.Errorf("must be a valid size (e.g., 100M), got %q", value)
+		}
+		o.IOWriteBPS = value
+		return nil
+	},
+	"IO_READ_IOPS": func(o *UserOverride, value string) error {
+		iops, err := strconv.Atoi(value)
+		if err != nil || iops < 0 {
+			return fmt.Errorf("must be a non-negative integer, got %q", value)
+		}
+		o.IOReadIOPS = iops
+		return nil
+	},
+	"IO_WRITE_IOPS": func(o *UserOverride, value string) error {
+		iops, err := strconv.Atoi(value)
+		if err != nil || iops < 0 {
+			return fmt.Errorf("must be a non-negative integer, got %q", value)
+		}
+		o.IOWriteIOPS = iops
+		return nil
+	},
+	"SWAP_MAX": func(o *UserOverride, value string) error {
+		if !IsValidSwapLimit(value) {
+			return fmt.Errorf("must be a valid size, max or a percentage of memory.max (e.g., 1G, 50%%), got %q", value)
+		}
+		o.SwapMax = value
+		return nil
+	},
+	"SWAP_HIGH": func(o *UserOverride, value string) error {
+		if !IsValidSwapLimit(value) {
+			return fmt.Errorf("must be a valid size, max or a percentage of memory.max (e.g., 1G, 50%%), got %q", value)
+		}
+		o.SwapHigh = value
+		return nil
+	},
+	"ZSWAP_MAX": func(o *UserOverride, value string) error {
+		if !IsValidSwapLimit(value) {
+			return fmt.Errorf("must be a valid size, max or a percentage of memory.max (e.g., 1G, 50%%), got %q", value)
+		}
+		o.ZswapMax = value
+		return nil
+	},
+}
+
+// parseOverrideSelector interpreta l'intestazione di una sezione ("alice",
+// "uid:1005", "regex:^stud").
+func parseOverrideSelector(selector string) (userOverrideEntry, error) {
+	switch {
+	case strings.HasPrefix(selector, "uid:"):
+		uid, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(selector, "uid:")))
+		if err != nil || uid < 0 {
+			return userOverrideEntry{}, fmt.Errorf("invalid UID in section [%s]", selector)
+		}
+		return userOverrideEntry{kind: overrideByUID, uid: uid}, nil
+	case strings.HasPrefix(selector, "regex:"):
+		expr := strings.TrimSpace(strings.TrimPrefix(selector, "regex:"))
+		pattern, err := regexp.Compile(expr)
+		if err != nil || expr == "" {
+			return userOverrideEntry{}, fmt.Errorf("invalid regex in section [%s]", selector)
+		}
+		return userOverrideEntry{kind: overrideByRegex, pattern: pattern}, nil
+	case selector == "":
+		return userOverrideEntry{}, fmt.Errorf("empty section name")
+	default:
+		return userOverrideEntry{kind: overrideByUsername, username: selector}, nil
+	}
+}
+
+// LoadUserOverrides legge il file di override per-utente.
+// Un file non esistente non e' un errore: restituisce un insieme vuoto.
+func LoadUserOverrides(path string) (*UserOverrides, error) {
+	overrides := &UserOverrides{}
+	if path == "" {
+		return overrides, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return overrides, nil
+		}
+		return nil, err
+	}
+
+	var current *userOverrideEntry
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			end := strings.LastIndex(line, "]")
+			if end < 1 {
+				return nil, fmt.Errorf("malformed section header on line %d: %s", i+1, line)
+			}
+			entry, err := parseOverrideSelector(strings.TrimSpace(line[1:end]))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+			overrides.entries = append(overrides.entries, entry)
+			current = &overrides.entries[len(overrides.entries)-1]
+			continue
+		}
+
+		if current == nil {
+			return nil, fmt.Errorf("line %d: key outside of a [user] section: %s", i+1, line)
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("malformed line %d: %s", i+1, line)
+		}
+		key := strings.ToUpper(strings.TrimSpace(parts[0]))
+		value := parts[1]
+		if commentIdx := strings.Index(value, "#"); commentIdx != -1 {
+			value = value[:commentIdx]
+		}
+		value = strings.TrimSpace(strings.Trim(strings.TrimSpace(value), `"'`))
+
+		handler, ok := overrideFieldHandlers[key]
+		if !ok {
+			return nil, fmt.Errorf("line %d: unknown key %s", i+1, key)
+		}
+		if err := handler(&current.override, value); err != nil {
+			return nil, fmt.Errorf("line %d: %s %w", i+1, key, err)
+		}
+	}
+
+	return overrides, nil
+}
+
+// Len restituisce il numero di sezioni caricate.
+func (o *UserOverrides) Len() int {
+	if o == nil {
+		return 0
+	}
+	return len(o.entries)
+}
+
+// Lookup restituisce l'override combinato per l'utente. ok=false se nessuna
+// sezione corrisponde.
+func (o *UserOverrides) Lookup(uid int, username string) (UserOverride, bool) {
+	if o == nil {
+		return UserOverride{}, false
+	}
+
+	var result UserOverride
+	found := false
+
+	// Regex: vale la prima che corrisponde, come per i gruppi utente
+	if username != "" {
+		for _, entry := range o.entries {
+			if entry.kind == overrideByRegex && entry.pattern.MatchString(username) {
+				result.merge(entry.override)
+				found = true
+				break
+			}
+		}
+	}
+	for _, entry := range o.entries {
+		if entry.kind == overrideByUID && entry.uid == uid {
+			result.merge(entry.override)
+			found = true
+		}
+	}
+	if username != "" {
+		for _, entry := range o.entries {
+			if entry.kind == overrideByUsername && entry.username == username {
+				result.merge(entry.override)
+				found = true
+			}
+		}
+	}
+
+	return result, found
+}
+
+// GetUserOverridesFile restituisce il percorso del file di override per-utente.
+func (c *Config) GetUserOverridesFile() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.UserOverridesFile
+}
+
+// GetUserOverride restituisce i limiti personali dell'utente, se definiti.
+func (c *Config) GetUserOverride(uid int, username string) (UserOverride, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.userOverrides.Lookup(uid, username)
+}
+
+// GetUserOverrideCount restituisce il numero di sezioni caricate dal file di override.
+func (c *Config) GetUserOverrideCount() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.userOverrides.Len()
+}
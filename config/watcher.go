@@ -49,6 +49,11 @@ type Watcher struct {
 	stopChan     chan struct{}
 	lastModTime  time.Time
 	lastFileSize int64
+
+	// File di override per-utente (USER_OVERRIDES_FILE), ricaricato con la config
+	overridesPath     string
+	overridesModTime  time.Time
+	overridesFileSize int64
 }
 
 // HandleConfigChange forza il ricaricamento della configurazione.
@@ -92,10 +97,65 @@ func NewWatcher(configPath string, initialConfig *Config, onChange ConfigChangeH
 		return nil, fmt.Errorf("failed to add config file %s to watcher: %w", configPath, err)
 	}
 
-	logger.Info("Configuration watcher initialized", "file", configPath)
+	if initialConfig != nil {
+		watcher.watchOverridesFile(initialConfig.GetUserOverridesFile())
+	}
+
+	logger.Info("Configuration watcher initialized",
+		"file", configPath,
+		"overrides_file", watcher.overridesPath,
+	)
 	return watcher, nil
 }
 
+// watchOverridesFile aggiunge (o sostituisce) il file di override per-utente
+// tra i file monitorati. Se il file non esiste ancora viene rilevato dal
+// controllo periodico quando compare.
+func (w *Watcher) watchOverridesFile(path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if path == w.overridesPath {
+		return
+	}
+	if w.overridesPath != "" {
+		_ = w.watcher.Remove(w.overridesPath)
+	}
+
+	w.overridesPath = path
+	w.overridesModTime, w.overridesFileSize = statFile(path)
+	if path == "" {
+		return
+	}
+	if err := w.watcher.Add(path); err != nil && !os.IsNotExist(err) {
+		w.logger.Warn("Failed to watch user overrides file", "file", path, "error", err)
+	}
+}
+
+// statFile restituisce mtime e dimensione del file (zero se non esiste).
+func statFile(path string) (time.Time, int64) {
+	if path == "" {
+		return time.Time{}, 0
+	}
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, 0
+	}
+	return fileInfo.ModTime(), fileInfo.Size()
+}
+
+// overridesChanged indica se il file di override e' cambiato (creato,
+// modificato o rimosso) dall'ultimo caricamento.
+func (w *Watcher) overridesChanged() bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.overridesPath == "" {
+		return false
+	}
+	modTime, size := statFile(w.overridesPath)
+	return !modTime.Equal(w.overridesModTime) || size != w.overridesFileSize
+}
+
 // Start avvia il watcher.
 func (w *Watcher) Start() error {
 	w.mu.Lock()
@@ -165,8 +225,11 @@ func (w *Watcher) watchLoop() {
 				continue
 			}
 
-			// Verifica se è il nostro file di configurazione
-			if event.Name != w.configPath {
+			// Verifica se è il nostro file di configurazione (o il file di override)
+			w.mu.RLock()
+			overridesPath := w.overridesPath
+			w.mu.RUnlock()
+			if event.Name != w.configPath && (overridesPath == "" || event.Name != overridesPath) {
 				continue
 			}
 
@@ -209,7 +272,7 @@ func (w *Watcher) checkConfigChange() {
 	sameSize := fileInfo.Size() == w.lastFileSize
 	w.mu.RUnlock()
 
-	if !sameModTime || !sameSize {
+	if !sameModTime || !sameSize || w.overridesChanged() {
 		w.logger.Info("Config change detected via periodic check, reloading")
 		w.handleConfigChange()
 	}
@@ -236,7 +299,7 @@ func (w *Watcher) handleConfigChange() {
 	sameSize := fileInfo.Size() == w.lastFileSize
 	w.mu.RUnlock()
 
-	if sameModTime && sameSize {
+	if sameModTime && sameSize && !w.overridesChanged() {
 		w.logger.Debug("Config file not actually changed (same mod time and size)")
 		return
 	}
@@ -271,6 +334,13 @@ func (w *Watcher) handleConfigChange() {
 	w.lastFileSize = fileInfo.Size()
 	w.mu.Unlock()
 
+	// Il percorso degli override puo' essere cambiato con la nuova config;
+	// in ogni caso registra lo stato del file appena caricato
+	w.watchOverridesFile(newConfig.GetUserOverridesFile())
+	w.mu.Lock()
+	w.overridesModTime, w.overridesFileSize = statFile(w.overridesPath)
+	w.mu.Unlock()
+
 	w.logger.Info("New configuration applied successfully")
 }
 
@@ -0,0 +1,504 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+// database/accounting.go
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// accountingStateKey e' la riga di metrics_rollup_state con il punto fin
+// dove i campioni grezzi sono stati contabilizzati
+const accountingStateKey = "accounting"
+
+// accountingChunk e' la finestra di campioni grezzi letta per transazione
+const accountingChunk = 6 * time.Hour
+
+// DefaultAccountingMaxGap e' l'intervallo massimo tra due campioni entro il
+// quale CPU e memoria vengono integrate, se non impostato con SetAccountingMaxGap
+const DefaultAccountingMaxGap = 5 * time.Minute
+
+// accountingDayLayout e' il formato dei giorni di user_accounting_daily
+const accountingDayLayout = "2006-01-02"
+
+// Raggruppamenti dei report di contabilita'
+const (
+	AccountingByDay   = "day"   // una riga per utente e giorno
+	AccountingByUser  = "user"  // totali per utente nell'intervallo
+	AccountingByGroup = "group" // totali per gruppo (GROUP_<name>)
+)
+
+// AccountingNoGroup e' il gruppo degli utenti che non appartengono a nessun gruppo
+const AccountingNoGroup = "ungrouped"
+
+const bytesPerGB = 1 << 30 // GB come in memory.max (2^30 byte)
+
+// AccountingRecord e' il consumo di un utente (o gruppo) in un giorno o in
+// un intervallo. CPU in secondi di core, memoria in byte per secondo.
+type AccountingRecord struct {
+	Day               string  `json:"day,omitempty"` // YYYY-MM-DD, vuoto nei totali
+	UID               int     `json:"uid,omitempty"`
+	Username          string  `json:"username,omitempty"`
+	Group             string  `json:"group,omitempty"`
+	Users             int     `json:"users,omitempty"` // utenti sommati nei totali per gruppo
+	CPUSeconds        float64 `json:"cpu_seconds"`
+	CPUHours          float64 `json:"cpu_hours"`
+	MemoryByteSeconds float64 `json:"memory_byte_seconds"`
+	MemoryGBHours     float64 `json:"memory_gb_hours"`
+	IOReadBytes       int64   `json:"io_read_bytes"`
+	IOWriteBytes      int64   `json:"io_write_bytes"`
+	Samples           int64   `json:"samples"`
+	CoveredSeconds    float64 `json:"covered_seconds"` // tempo integrato tra campioni vicini
+	GapSeconds        float64 `json:"gap_seconds"`     // tempo tra campioni piu' distanti del massimo
+	CounterResets     int64   `json:"counter_resets"`
+}
+
+// AccountingFilter seleziona i giorni tra Start e End (date locali, estremi
+// inclusi), un utente (UID > 0) o un gruppo. Group richiede groupOf.
+type AccountingFilter struct {
+	UID   int
+	Group string
+	Start time.Time
+	End   time.Time
+}
+
+// accountingSample e' un campione grezzo, o l'ultimo gia' contabilizzato.
+// CPUUsageUsec e i byte IO sono i contatori monotoni del collector
+// (io_read_total, io_write_total), non le somme sui processi vivi.
+type accountingSample struct {
+	Timestamp    time.Time
+	Username     string
+	CPUPercent   float64
+	CPUUsageUsec int64
+	MemoryBytes  int64
+	IOReadBytes  int64
+	IOWriteBytes int64
+}
+
+type accountingKey struct {
+	day string
+	uid int
+}
+
+// SetAccountingMaxGap imposta oltre quale distanza tra due campioni CPU (se
+// misurata da /proc) e memoria non vengono integrate: il tempo conta come gap
+func (m *DatabaseManager) SetAccountingMaxGap(maxGap time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.accountingMaxGap = maxGap
+}
+
+// counterDelta restituisce l'incremento di un contatore cumulativo. I
+// contatori del collector scendono solo al riavvio del demone, quando
+// ripartono da zero: si conta il valore attuale.
+func counterDelta(prev, cur int64) (int64, bool) {
+	if cur >= prev {
+		return cur - prev, false
+	}
+	return cur, true
+}
+
+// splitByDay divide [start, end) nei giorni locali che attraversa
+func splitByDay(start, end time.Time, fn func(day string, seconds float64)) {
+	start, end = start.Local(), end.Local()
+	for start.Before(end) {
+		next := time.Date(start.Year(), start.Month(), start.Day()+1, 0, 0, 0, 0, time.Local)
+		if next.After(end) {
+			next = end
+		}
+		fn(start.Format(accountingDayLayout), next.Sub(start).Seconds())
+		start = next
+	}
+}
+
+// accountInterval contabilizza l'intervallo tra due campioni consecutivi di
+// un utente. I contatori (usage_usec, byte IO) valgono anche attraverso un
+// gap; CPU da /proc e memoria sono istantanee e vengono integrate (trapezi)
+// solo tra campioni entro maxGap.
+func accountInterval(acc map[accountingKey]*AccountingRecord, uid int, prev, cur accountingSample, maxGap time.Duration) {
+	elapsed := cur.Timestamp.Sub(prev.Timestamp)
+	if elapsed <= 0 {
+		return
+	}
+	gap := maxGap > 0 && elapsed > maxGap
+	seconds := elapsed.Seconds()
+
+	var cpuSeconds, memoryByteSeconds float64
+	var resets int64
+	if prev.CPUUsageUsec > 0 && cur.CPUUsageUsec > 0 {
+		delta, reset := counterDelta(prev.CPUUsageUsec, cur.CPUUsageUsec)
+		cpuSeconds = float64(delta) / 1e6
+		if reset {
+			resets++
+		}
+	} else if !gap {
+		cpuSeconds = (prev.CPUPercent + cur.CPUPercent) / 2 / 100 * seconds
+	}
+	if !gap {
+		memoryByteSeconds = float64(prev.MemoryBytes+cur.MemoryBytes) / 2 * seconds
+	}
+	ioRead, readReset := counterDelta(prev.IOReadBytes, cur.IOReadBytes)
+	ioWrite, writeReset := counterDelta(prev.IOWriteBytes, cur.IOWriteBytes)
+	if readReset || writeReset {
+		resets++
+	}
+
+	first := true
+	splitByDay(prev.Timestamp, cur.Timestamp, func(day string, daySeconds float64) {
+		record := accountingRow(acc, day, uid, cur.Username)
+		fraction := daySeconds / seconds
+		record.CPUSeconds += cpuSeconds * fraction
+		record.MemoryByteSeconds += memoryByteSeconds * fraction
+		record.IOReadBytes += int64(math.Round(float64(ioRead) * fraction))
+		record.IOWriteBytes += int64(math.Round(float64(ioWrite) * fraction))
+		if gap {
+			record.GapSeconds += daySeconds
+		} else {
+			record.CoveredSeconds += daySeconds
+		}
+		// I reset si contano una volta, nel giorno in cui l'intervallo inizia
+		if first {
+			record.CounterResets += resets
+			first = false
+		}
+	})
+}
+
+func accountingRow(acc map[accountingKey]*AccountingRecord, day string, uid int, username string) *AccountingRecord {
+	key := accountingKey{day, uid}
+	record, ok := acc[key]
+	if !ok {
+		record = &AccountingRecord{Day: day, UID: uid}
+		acc[key] = record
+	}
+	if username != "" {
+		record.Username = username
+	}
+	return record
+}
+
+// updateAccounting integra nei totali giornalieri i campioni grezzi non
+// ancora contabilizzati. Gira con i rollup, quindi prima che la retention
+// elimini i campioni.
+func (m *DatabaseManager) updateAccounting(now time.Time) error {
+	until := now.Add(-rollupGrace)
+
+	m.mu.RLock()
+	from, err := m.rolledUntil(accountingStateKey)
+	if err == nil && from.IsZero() {
+		// ORDER BY invece di MIN(): un aggregato perde il tipo DATETIME
+		err = m.db.QueryRow("SELECT timestamp FROM user_metrics ORDER BY timestamp LIMIT 1").Scan(&from)
+		if err == sql.ErrNoRows {
+			m.mu.RUnlock()
+			return nil
+		}
+	}
+	m.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("failed to read accounting state: %w", err)
+	}
+
+	for start := from; start.Before(until); start = start.Add(accountingChunk) {
+		end := start.Add(accountingChunk)
+		if end.After(until) {
+			end = until
+		}
+		m.mu.Lock()
+		err := m.accountChunk(start, end)
+		m.mu.Unlock()
+		if err != nil {
+			return fmt.Errorf("failed to account usage from %s to %s: %w", start.Format(time.RFC3339), end.Format(time.RFC3339), err)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if days := m.retention.DailyDays; days > 0 {
+		cutoff := now.AddDate(0, 0, -days).Local().Format(accountingDayLayout)
+		if _, err := m.db.Exec("DELETE FROM user_accounting_daily WHERE day < ?", cutoff); err != nil {
+			return fmt.Errorf("failed to delete accounting rows before %s: %w", cutoff, err)
+		}
+	}
+	return nil
+}
+
+// accountChunk contabilizza i campioni in [start, end) e avanza lo stato
+func (m *DatabaseManager) accountChunk(start, end time.Time) error {
+	last, err := m.accountingState()
+	if err != nil {
+		return err
+	}
+
+	rows, err := m.db.Query(`
+    SELECT uid, username, timestamp, cpu_usage_percent, cpu_usage_usec, memory_usage_bytes, io_read_total, io_write_total
+    FROM user_metrics
+    WHERE timestamp >= ? AND timestamp < ?
+    ORDER BY timestamp, id
+    `, start, end)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	maxGap := m.accountingMaxGap
+	if maxGap == 0 {
+		maxGap = DefaultAccountingMaxGap
+	}
+	acc := make(map[accountingKey]*AccountingRecord)
+	changed := make(map[int]bool)
+	for rows.Next() {
+		var uid int
+		var cur accountingSample
+		if err := rows.Scan(&uid, &cur.Username, &cur.Timestamp, &cur.CPUPercent, &cur.CPUUsageUsec,
+			&cur.MemoryBytes, &cur.IOReadBytes, &cur.IOWriteBytes); err != nil {
+			return err
+		}
+		if prev, ok := last[uid]; ok {
+			accountInterval(acc, uid, prev, cur, maxGap)
+		}
+		accountingRow(acc, cur.Timestamp.Local().Format(accountingDayLayout), uid, cur.Username).Samples++
+		last[uid] = cur
+		changed[uid] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if len(acc) > 0 {
+		stmt, err := tx.Prepare(`
+    INSERT INTO user_accounting_daily (day, uid, username, cpu_seconds, memory_byte_seconds, io_read_bytes, io_write_bytes,
+        samples, covered_seconds, gap_seconds, counter_resets)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+    ON CONFLICT (day, uid) DO UPDATE SET
+        username = CASE WHEN excluded.username != '' THEN excluded.username ELSE username END,
+        cpu_seconds = cpu_seconds + excluded.cpu_seconds,
+        memory_byte_seconds = memory_byte_seconds + excluded.memory_byte_seconds,
+        io_read_bytes = io_read_bytes + excluded.io_read_bytes,
+        io_write_bytes = io_write_bytes + excluded.io_write_bytes,
+        samples = samples + excluded.samples,
+        covered_seconds = covered_seconds + excluded.covered_seconds,
+        gap_seconds = gap_seconds + excluded.gap_seconds,
+        counter_resets = counter_resets + excluded.counter_resets
+    `)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, r := range acc {
+			if _, err := stmt.Exec(r.Day, r.UID, r.Username, r.CPUSeconds, r.MemoryByteSeconds, r.IOReadBytes, r.IOWriteBytes,
+				r.Samples, r.CoveredSeconds, r.GapSeconds, r.CounterResets); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Le colonne io_*_bytes dello stato conservano i contatori io_*_total
+	if len(changed) > 0 {
+		stmt, err := tx.Prepare(`
+    INSERT OR REPLACE INTO accounting_state (uid, timestamp, cpu_usage_percent, cpu_usage_usec, memory_usage_bytes, io_read_bytes, io_write_bytes)
+    VALUES (?, ?, ?, ?, ?, ?, ?)
+    `)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for uid := range changed {
+			s := last[uid]
+			if _, err := stmt.Exec(uid, s.Timestamp, s.CPUPercent, s.CPUUsageUsec, s.MemoryBytes, s.IOReadBytes, s.IOWriteBytes); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err := tx.Exec("INSERT OR REPLACE INTO metrics_rollup_state (resolution, rolled_until) VALUES (?, ?)", accountingStateKey, end); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// accountingState legge l'ultimo campione contabilizzato di ogni utente
+func (m *DatabaseManager) accountingState() (map[int]accountingSample, error) {
+	rows, err := m.db.Query(`
+    SELECT uid, timestamp, cpu_usage_percent, cpu_usage_usec, memory_usage_bytes, io_read_bytes, io_write_bytes
+    FROM accounting_state
+    `)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read accounting state: %w", err)
+	}
+	defer rows.Close()
+
+	last := make(map[int]accountingSample)
+	for rows.Next() {
+		var uid int
+		var s accountingSample
+		if err := rows.Scan(&uid, &s.Timestamp, &s.CPUPercent, &s.CPUUsageUsec, &s.MemoryBytes, &s.IOReadBytes, &s.IOWriteBytes); err != nil {
+			return nil, fmt.Errorf("failed to scan accounting state: %w", err)
+		}
+		last[uid] = s
+	}
+	return last, rows.Err()
+}
+
+// GetAccounting restituisce i totali giornalieri per utente selezionati dal
+// filtro (il gruppo e' ignorato), per giorno e UID. I dati arrivano fino
+// all'ultimo rollup, al piu' qualche minuto fa.
+func (m *DatabaseManager) GetAccounting(filter AccountingFilter) ([]AccountingRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	query := `
+    SELECT day, uid, username, cpu_seconds, memory_byte_seconds, io_read_bytes, io_write_bytes,
+        samples, covered_seconds, gap_seconds, counter_resets
+    FROM user_accounting_daily
+    WHERE 1 = 1`
+	var args []any
+	if filter.UID > 0 {
+		query += " AND uid = ?"
+		args = append(args, filter.UID)
+	}
+	if !filter.Start.IsZero() {
+		query += " AND day >= ?"
+		args = append(args, filter.Start.Local().Format(accountingDayLayout))
+	}
+	if !filter.End.IsZero() {
+		query += " AND day <= ?"
+		args = append(args, filter.End.Local().Format(accountingDayLayout))
+	}
+	query += " ORDER BY day, uid"
+
+	rows, err := m.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query usage accounting: %w", err)
+	}
+	defer rows.Close()
+
+	var records []AccountingRecord
+	for rows.Next() {
+		var r AccountingRecord
+		if err := rows.Scan(&r.Day, &r.UID, &r.Username, &r.CPUSeconds, &r.MemoryByteSeconds, &r.IOReadBytes, &r.IOWriteBytes,
+			&r.Samples, &r.CoveredSeconds, &r.GapSeconds, &r.CounterResets); err != nil {
+			return nil, fmt.Errorf("failed to scan usage accounting: %w", err)
+		}
+		r.setHours()
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+// GetAccountingReport restituisce il report di contabilita' raggruppato per
+// giorno, utente o gruppo. groupOf assegna un utente al suo gruppo (stringa
+// vuota se nessuno); serve per groupBy "group" e per filter.Group.
+func (m *DatabaseManager) GetAccountingReport(filter AccountingFilter, groupBy string, groupOf func(username string) string) ([]AccountingRecord, error) {
+	switch groupBy {
+	case "", AccountingByDay, AccountingByUser, AccountingByGroup:
+	default:
+		return nil, fmt.Errorf("invalid accounting grouping %q (expected day, user or group)", groupBy)
+	}
+	if groupOf == nil && (groupBy == AccountingByGroup || filter.Group != "") {
+		return nil, fmt.Errorf("grouping by group requires the user group configuration")
+	}
+
+	daily, err := m.GetAccounting(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]AccountingRecord, 0, len(daily))
+	for _, r := range daily {
+		if groupOf != nil {
+			r.Group = groupOf(r.Username)
+			if r.Group == "" {
+				r.Group = AccountingNoGroup
+			}
+		}
+		if filter.Group != "" && r.Group != filter.Group {
+			continue
+		}
+		records = append(records, r)
+	}
+	if groupBy == "" || groupBy == AccountingByDay {
+		return records, nil
+	}
+	return summarizeAccounting(records, groupBy), nil
+}
+
+// summarizeAccounting somma le righe giornaliere per utente o per gruppo,
+// dal consumo CPU maggiore
+func summarizeAccounting(records []AccountingRecord, groupBy string) []AccountingRecord {
+	totals := make(map[string]*AccountingRecord)
+	users := make(map[string]map[int]bool)
+	var keys []string
+	for _, r := range records {
+		key := r.Group
+		if groupBy == AccountingByUser {
+			key = fmt.Sprint(r.UID)
+		}
+		total, ok := totals[key]
+		if !ok {
+			total = &AccountingRecord{Group: r.Group}
+			if groupBy == AccountingByUser {
+				total.UID = r.UID
+			}
+			totals[key] = total
+			users[key] = make(map[int]bool)
+			keys = append(keys, key)
+		}
+		if groupBy == AccountingByUser {
+			total.Username = r.Username
+		}
+		users[key][r.UID] = true
+		total.CPUSeconds += r.CPUSeconds
+		total.MemoryByteSeconds += r.MemoryByteSeconds
+		total.IOReadBytes += r.IOReadBytes
+		total.IOWriteBytes += r.IOWriteBytes
+		total.Samples += r.Samples
+		total.CoveredSeconds += r.CoveredSeconds
+		total.GapSeconds += r.GapSeconds
+		total.CounterResets += r.CounterResets
+	}
+
+	result := make([]AccountingRecord, 0, len(keys))
+	for _, key := range keys {
+		total := totals[key]
+		if groupBy == AccountingByGroup {
+			total.Users = len(users[key])
+		}
+		total.setHours()
+		result = append(result, *total)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].CPUSeconds > result[j].CPUSeconds
+	})
+	return result
+}
+
+// setHours calcola CPU-ore e GB-ore dai secondi
+func (r *AccountingRecord) setHours() {
+	r.CPUHours = r.CPUSeconds / 3600
+	r.MemoryGBHours = r.MemoryByteSeconds / bytesPerGB / 3600
+}
@@ -0,0 +1,152 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+// database/accounting_test.go
+package database
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func approxEqual(got, want float64) bool {
+	return math.Abs(got-want) <= 1e-6*math.Max(1, math.Abs(want))
+}
+
+func TestAccountingCountersAndGaps(t *testing.T) {
+	manager, err := NewDatabaseManager(filepath.Join(t.TempDir(), "metrics.db"))
+	if err != nil {
+		t.Fatalf("Failed to create database manager: %v", err)
+	}
+	defer manager.Close()
+
+	now := time.Now()
+	y, m, d := now.AddDate(0, 0, -2).Date()
+	base := time.Date(y, m, d, 10, 0, 0, 0, time.Local)
+	write := func(record UserMetricsRecord) {
+		t.Helper()
+		if err := manager.WriteUserMetrics(&record); err != nil {
+			t.Fatalf("WriteUserMetrics() error: %v", err)
+		}
+	}
+
+	// alice: cgroup a meta' core, 1 GB, i contatori ripartono al minuto 5
+	// (demone riavviato) e dopo il minuto 10 un'ora senza campioni
+	usec, ioRead := int64(1_000_000_000), int64(0)
+	for k := 0; k <= 10; k++ {
+		if k == 5 {
+			usec = 30_000_000 // 30s contati dal riavvio
+			ioRead = 1000
+		} else if k > 0 {
+			usec += 30_000_000
+			ioRead += 1000
+		}
+		write(UserMetricsRecord{UID: 1000, Username: "alice", Timestamp: base.Add(time.Duration(k) * time.Minute),
+			CPUUsagePercent: 50, CPUUsageUsec: usec, MemoryUsageBytes: bytesPerGB, IOReadTotal: ioRead})
+	}
+	usec += 60_000_000 // un minuto di CPU durante il gap: il contatore lo vede
+	write(UserMetricsRecord{UID: 1000, Username: "alice", Timestamp: base.Add(70 * time.Minute),
+		CPUUsagePercent: 50, CPUUsageUsec: usec, MemoryUsageBytes: bytesPerGB, IOReadTotal: ioRead})
+
+	// bob: CPU solo da /proc, un core pieno per 5 minuti
+	for k := 0; k <= 5; k++ {
+		write(UserMetricsRecord{UID: 1001, Username: "bob", Timestamp: base.Add(time.Duration(k) * time.Minute),
+			CPUUsagePercent: 100, MemoryUsageBytes: 2 * bytesPerGB})
+	}
+
+	// Due esecuzioni: la seconda non deve contare nulla due volte
+	for i := 0; i < 2; i++ {
+		if err := manager.Rollup(now); err != nil {
+			t.Fatalf("Rollup() error: %v", err)
+		}
+	}
+
+	report, err := manager.GetAccountingReport(AccountingFilter{Start: base, End: base}, AccountingByDay, nil)
+	if err != nil {
+		t.Fatalf("GetAccountingReport() error: %v", err)
+	}
+	if len(report) != 2 {
+		t.Fatalf("report has %d rows, expected 2: %+v", len(report), report)
+	}
+	alice, bob := report[0], report[1]
+
+	// 10 intervalli da 30s + 60s nel gap
+	if !approxEqual(alice.CPUSeconds, 360) {
+		t.Errorf("alice cpu_seconds = %f, expected 360", alice.CPUSeconds)
+	}
+	if !approxEqual(alice.CPUHours, 0.1) {
+		t.Errorf("alice cpu_hours = %f, expected 0.1", alice.CPUHours)
+	}
+	// Memoria integrata solo sui 10 minuti coperti
+	if !approxEqual(alice.MemoryGBHours, 600.0/3600) {
+		t.Errorf("alice memory_gb_hours = %f, expected %f", alice.MemoryGBHours, 600.0/3600)
+	}
+	if alice.CoveredSeconds != 600 || alice.GapSeconds != 3600 {
+		t.Errorf("alice covered/gap = %.0f/%.0f, expected 600/3600", alice.CoveredSeconds, alice.GapSeconds)
+	}
+	// Al minuto 5 ripartono sia usage_usec sia i byte letti
+	if alice.IOReadBytes != 10000 || alice.CounterResets != 2 || alice.Samples != 12 {
+		t.Errorf("alice io/resets/samples = %d/%d/%d, expected 10000/2/12", alice.IOReadBytes, alice.CounterResets, alice.Samples)
+	}
+	if !approxEqual(bob.CPUSeconds, 300) || !approxEqual(bob.MemoryGBHours, 2*300.0/3600) {
+		t.Errorf("bob cpu_seconds/memory_gb_hours = %f/%f, expected 300/%f", bob.CPUSeconds, bob.MemoryGBHours, 2*300.0/3600)
+	}
+
+	// Totali per gruppo: alice in "physics", bob senza gruppo
+	groupOf := func(username string) string {
+		if username == "alice" {
+			return "physics"
+		}
+		return ""
+	}
+	groups, err := manager.GetAccountingReport(AccountingFilter{Start: base, End: now}, AccountingByGroup, groupOf)
+	if err != nil {
+		t.Fatalf("GetAccountingReport(group) error: %v", err)
+	}
+	if len(groups) != 2 || groups[0].Group != "physics" || groups[0].Users != 1 || groups[1].Group != AccountingNoGroup {
+		t.Errorf("group report = %+v, expected physics then %s", groups, AccountingNoGroup)
+	}
+	physics, err := manager.GetAccountingReport(AccountingFilter{Group: "physics"}, AccountingByUser, groupOf)
+	if err != nil {
+		t.Fatalf("GetAccountingReport(physics) error: %v", err)
+	}
+	if len(physics) != 1 || physics[0].Username != "alice" || !approxEqual(physics[0].CPUSeconds, 360) {
+		t.Errorf("physics users = %+v, expected only alice", physics)
+	}
+}
+
+func TestAccountIntervalSplitsDays(t *testing.T) {
+	midnight := time.Date(2026, 3, 10, 0, 0, 0, 0, time.Local)
+	prev := accountingSample{Timestamp: midnight.Add(-time.Minute), CPUPercent: 100, MemoryBytes: 100, IOWriteBytes: 0}
+	cur := accountingSample{Timestamp: midnight.Add(3 * time.Minute), CPUPercent: 100, MemoryBytes: 100, IOWriteBytes: 4000}
+
+	acc := make(map[accountingKey]*AccountingRecord)
+	accountInterval(acc, 1000, prev, cur, DefaultAccountingMaxGap)
+
+	before := acc[accountingKey{"2026-03-09", 1000}]
+	after := acc[accountingKey{"2026-03-10", 1000}]
+	if before == nil || after == nil {
+		t.Fatalf("interval across midnight produced %+v, expected one row per day", acc)
+	}
+	if !approxEqual(before.CPUSeconds, 60) || !approxEqual(after.CPUSeconds, 180) {
+		t.Errorf("cpu_seconds = %f/%f, expected 60/180", before.CPUSeconds, after.CPUSeconds)
+	}
+	if before.IOWriteBytes != 1000 || after.IOWriteBytes != 3000 {
+		t.Errorf("io_write_bytes = %d/%d, expected 1000/3000", before.IOWriteBytes, after.IOWriteBytes)
+	}
+}
@@ -0,0 +1,279 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+// database/limit_events.go
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Tipi di episodio registrati in limit_events
+const (
+	LimitEventLimit    = "limit"     // utente nel cgroup limitato
+	LimitEventPSIBoost = "psi_boost" // cpu.weight alzato per pressione PSI
+	LimitEventIOBoost  = "io_boost"  // limiti io.max moltiplicati per IO starvation
+	LimitEventPolicy   = "policy"    // quote di una policy per pattern in vigore
+)
+
+// LimitEventRecord e' un episodio del journal: si apre quando l'utente viene
+// limitato (o boostato) e si chiude al rilascio. Soglie e metriche sono quelle
+// del momento dell'apertura.
+type LimitEventRecord struct {
+	ID                  int64      `json:"id"`
+	UID                 int        `json:"uid"`
+	Username            string     `json:"username"`
+	EventType           string     `json:"event_type"`
+	StartedAt           time.Time  `json:"started_at"`
+	EndedAt             *time.Time `json:"ended_at,omitempty"` // nil se l'episodio e' in corso
+	DurationSeconds     float64    `json:"duration_seconds"`   // fino ad ora per gli episodi in corso
+	StartReason         string     `json:"start_reason,omitempty"`
+	EndReason           string     `json:"end_reason,omitempty"`
+	Detail              string     `json:"detail,omitempty"`
+	CPUThreshold        int        `json:"cpu_threshold,omitempty"`
+	CPUReleaseThreshold int        `json:"cpu_release_threshold,omitempty"`
+	CPUUsage            float64    `json:"cpu_usage"`
+	MemoryUsageBytes    int64      `json:"memory_usage_bytes"`
+	TotalCPUUsage       float64    `json:"total_cpu_usage"`
+	LimitedUsers        int        `json:"limited_users"`
+}
+
+// LimitEventFilter seleziona gli episodi. Start/End (se non zero) tengono
+// quelli che si sovrappongono all'intervallo.
+type LimitEventFilter struct {
+	UID       int    // <= 0 per tutti gli utenti
+	EventType string // vuoto per tutti i tipi
+	Start     time.Time
+	End       time.Time
+	OpenOnly  bool
+	Limit     int // <= 0 senza limite
+}
+
+// LimitEventSummary riassume gli episodi di un utente e tipo in un intervallo
+type LimitEventSummary struct {
+	UID            int     `json:"uid"`
+	Username       string  `json:"username"`
+	EventType      string  `json:"event_type"`
+	Episodes       int     `json:"episodes"`
+	Ongoing        int     `json:"ongoing"`
+	TotalSeconds   float64 `json:"total_seconds"`   // tempo nell'intervallo
+	LongestSeconds float64 `json:"longest_seconds"` // episodio piu' lungo, per intero
+	LastStartedAt  string  `json:"last_started_at"`
+	TotalDuration  string  `json:"total_duration"` // TotalSeconds leggibile, es. 3h12m5s
+}
+
+// OpenLimitEvent apre un episodio. Se per l'utente ne e' gia' aperto uno
+// dello stesso tipo non fa nulla: conta l'inizio del primo.
+func (m *DatabaseManager) OpenLimitEvent(record *LimitEventRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var id int64
+	err := m.db.QueryRow("SELECT id FROM limit_events WHERE uid = ? AND event_type = ? AND ended_at IS NULL LIMIT 1",
+		record.UID, record.EventType).Scan(&id)
+	if err == nil {
+		record.ID = id
+		return nil
+	}
+	if err != sql.ErrNoRows {
+		return fmt.Errorf("failed to look up open %s event for UID %d: %w", record.EventType, record.UID, err)
+	}
+
+	if record.StartedAt.IsZero() {
+		record.StartedAt = time.Now()
+	}
+	result, err := m.db.Exec(`
+    INSERT INTO limit_events (uid, username, event_type, started_at, start_reason, end_reason, detail,
+        cpu_threshold, cpu_release_threshold, cpu_usage, memory_usage_bytes, total_cpu_usage, limited_users)
+    VALUES (?, ?, ?, ?, ?, '', ?, ?, ?, ?, ?, ?, ?)
+    `,
+		record.UID, record.Username, record.EventType, record.StartedAt, record.StartReason, record.Detail,
+		record.CPUThreshold, record.CPUReleaseThreshold, record.CPUUsage, record.MemoryUsageBytes,
+		record.TotalCPUUsage, record.LimitedUsers,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to open %s event for UID %d: %w", record.EventType, record.UID, err)
+	}
+	if id, err := result.LastInsertId(); err == nil {
+		record.ID = id
+	}
+	return nil
+}
+
+// CloseLimitEvent chiude l'episodio aperto di un utente e tipo, calcolandone
+// la durata. Senza episodi aperti non fa nulla.
+func (m *DatabaseManager) CloseLimitEvent(uid int, eventType string, endedAt time.Time, reason string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var id int64
+	var startedAt time.Time
+	err := m.db.QueryRow("SELECT id, started_at FROM limit_events WHERE uid = ? AND event_type = ? AND ended_at IS NULL ORDER BY started_at DESC LIMIT 1",
+		uid, eventType).Scan(&id, &startedAt)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to look up open %s event for UID %d: %w", eventType, uid, err)
+	}
+
+	duration := endedAt.Sub(startedAt).Seconds()
+	if duration < 0 {
+		duration = 0
+	}
+	if _, err := m.db.Exec("UPDATE limit_events SET ended_at = ?, duration_seconds = ?, end_reason = ? WHERE id = ?",
+		endedAt, duration, reason, id); err != nil {
+		return fmt.Errorf("failed to close %s event %d for UID %d: %w", eventType, id, uid, err)
+	}
+	return nil
+}
+
+// GetLimitEvents restituisce gli episodi selezionati dal filtro, dal piu'
+// recente. La durata degli episodi in corso e' calcolata fino ad ora.
+func (m *DatabaseManager) GetLimitEvents(filter LimitEventFilter) ([]LimitEventRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	query := `
+    SELECT id, uid, username, event_type, started_at, ended_at, duration_seconds,
+        COALESCE(start_reason, ''), COALESCE(end_reason, ''), COALESCE(detail, ''),
+        cpu_threshold, cpu_release_threshold, cpu_usage, memory_usage_bytes, total_cpu_usage, limited_users
+    FROM limit_events
+    WHERE 1 = 1`
+	var args []any
+	if filter.UID > 0 {
+		query += " AND uid = ?"
+		args = append(args, filter.UID)
+	}
+	if filter.EventType != "" {
+		query += " AND event_type = ?"
+		args = append(args, filter.EventType)
+	}
+	if !filter.End.IsZero() {
+		query += " AND started_at <= ?"
+		args = append(args, filter.End)
+	}
+	if !filter.Start.IsZero() {
+		query += " AND (ended_at IS NULL OR ended_at >= ?)"
+		args = append(args, filter.Start)
+	}
+	if filter.OpenOnly {
+		query += " AND ended_at IS NULL"
+	}
+	query += " ORDER BY started_at DESC, id DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := m.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query limit events: %w", err)
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	var records []LimitEventRecord
+	for rows.Next() {
+		var r LimitEventRecord
+		var endedAt sql.NullTime
+		if err := rows.Scan(&r.ID, &r.UID, &r.Username, &r.EventType, &r.StartedAt, &endedAt, &r.DurationSeconds,
+			&r.StartReason, &r.EndReason, &r.Detail,
+			&r.CPUThreshold, &r.CPUReleaseThreshold, &r.CPUUsage, &r.MemoryUsageBytes, &r.TotalCPUUsage, &r.LimitedUsers); err != nil {
+			return nil, fmt.Errorf("failed to scan limit event: %w", err)
+		}
+		if endedAt.Valid {
+			ended := endedAt.Time
+			r.EndedAt = &ended
+		} else {
+			r.DurationSeconds = now.Sub(r.StartedAt).Seconds()
+		}
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+// GetLimitEventSummary conta gli episodi per utente e tipo e somma il tempo
+// trascorso dentro [filter.Start, filter.End] ("quante volte e per quanto
+// alice e' stata limitata la settimana scorsa"). Il limite del filtro e'
+// ignorato.
+func (m *DatabaseManager) GetLimitEventSummary(filter LimitEventFilter) ([]LimitEventSummary, error) {
+	filter.Limit = 0
+	events, err := m.GetLimitEvents(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	type key struct {
+		uid       int
+		eventType string
+	}
+	summaries := make(map[key]*LimitEventSummary)
+	for _, event := range events {
+		k := key{event.UID, event.EventType}
+		summary, ok := summaries[k]
+		if !ok {
+			// Gli eventi sono dal piu' recente: il primo da' nome e ultimo inizio
+			summary = &LimitEventSummary{
+				UID:           event.UID,
+				Username:      event.Username,
+				EventType:     event.EventType,
+				LastStartedAt: event.StartedAt.Format(time.RFC3339),
+			}
+			summaries[k] = summary
+		}
+
+		start, end := event.StartedAt, now
+		if event.EndedAt != nil {
+			end = *event.EndedAt
+		} else {
+			summary.Ongoing++
+		}
+		if !filter.Start.IsZero() && start.Before(filter.Start) {
+			start = filter.Start
+		}
+		if !filter.End.IsZero() && end.After(filter.End) {
+			end = filter.End
+		}
+		if end.After(start) {
+			summary.TotalSeconds += end.Sub(start).Seconds()
+		}
+		if event.DurationSeconds > summary.LongestSeconds {
+			summary.LongestSeconds = event.DurationSeconds
+		}
+		summary.Episodes++
+	}
+
+	result := make([]LimitEventSummary, 0, len(summaries))
+	for _, summary := range summaries {
+		summary.TotalDuration = time.Duration(summary.TotalSeconds * float64(time.Second)).Round(time.Second).String()
+		result = append(result, *summary)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].TotalSeconds != result[j].TotalSeconds {
+			return result[i].TotalSeconds > result[j].TotalSeconds
+		}
+		if result[i].UID != result[j].UID {
+			return result[i].UID < result[j].UID
+		}
+		return result[i].EventType < result[j].EventType
+	})
+	return result, nil
+}
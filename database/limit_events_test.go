@@ -0,0 +1,111 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+// database/limit_events_test.go
+package database
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLimitEventsJournal(t *testing.T) {
+	manager, err := NewDatabaseManager(filepath.Join(t.TempDir(), "metrics.db"))
+	if err != nil {
+		t.Fatalf("Failed to create database manager: %v", err)
+	}
+	defer manager.Close()
+
+	now := time.Now()
+	weekAgo := now.Add(-7 * 24 * time.Hour)
+
+	// Episodio iniziato prima dell'intervallo: conta solo la parte dentro
+	first := &LimitEventRecord{
+		UID: 1000, Username: "alice", EventType: LimitEventLimit,
+		StartedAt: weekAgo.Add(-time.Hour), StartReason: "total CPU 91.0% above CPU_THRESHOLD",
+		CPUThreshold: 75, CPUReleaseThreshold: 40, CPUUsage: 60,
+	}
+	if err := manager.OpenLimitEvent(first); err != nil {
+		t.Fatalf("OpenLimitEvent() error: %v", err)
+	}
+	if err := manager.CloseLimitEvent(1000, LimitEventLimit, weekAgo.Add(2*time.Hour), "idle"); err != nil {
+		t.Fatalf("CloseLimitEvent() error: %v", err)
+	}
+
+	// Secondo episodio ancora aperto: una seconda apertura non lo duplica
+	second := &LimitEventRecord{UID: 1000, Username: "alice", EventType: LimitEventLimit, StartedAt: now.Add(-30 * time.Minute)}
+	if err := manager.OpenLimitEvent(second); err != nil {
+		t.Fatalf("OpenLimitEvent() error: %v", err)
+	}
+	again := &LimitEventRecord{UID: 1000, Username: "alice", EventType: LimitEventLimit, StartedAt: now.Add(-10 * time.Minute)}
+	if err := manager.OpenLimitEvent(again); err != nil {
+		t.Fatalf("OpenLimitEvent() error: %v", err)
+	}
+	if again.ID != second.ID {
+		t.Errorf("reopening an open episode created id %d, want %d", again.ID, second.ID)
+	}
+
+	// Chiudere senza episodi aperti non e' un errore
+	if err := manager.CloseLimitEvent(1001, LimitEventPSIBoost, now, "boost_expired"); err != nil {
+		t.Fatalf("CloseLimitEvent() without open events error: %v", err)
+	}
+
+	events, err := manager.GetLimitEvents(LimitEventFilter{UID: 1000, Start: weekAgo, End: now})
+	if err != nil {
+		t.Fatalf("GetLimitEvents() error: %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("GetLimitEvents() returned %d events, want 2", len(events))
+	}
+	if events[0].EndedAt != nil || events[0].DurationSeconds < 29*60 {
+		t.Errorf("open episode = ended %v, duration %.0fs; want ongoing for ~30m", events[0].EndedAt, events[0].DurationSeconds)
+	}
+	if events[1].EndedAt == nil || events[1].EndReason != "idle" || events[1].DurationSeconds != 3*3600 {
+		t.Errorf("closed episode = %+v, want 3h closed by idle", events[1])
+	}
+	if events[1].CPUThreshold != 75 || events[1].CPUUsage != 60 {
+		t.Errorf("snapshot = threshold %d, cpu %.1f; want 75, 60", events[1].CPUThreshold, events[1].CPUUsage)
+	}
+
+	open, err := manager.GetLimitEvents(LimitEventFilter{OpenOnly: true})
+	if err != nil {
+		t.Fatalf("GetLimitEvents(OpenOnly) error: %v", err)
+	}
+	if len(open) != 1 || open[0].ID != second.ID {
+		t.Errorf("GetLimitEvents(OpenOnly) = %+v, want only episode %d", open, second.ID)
+	}
+
+	summary, err := manager.GetLimitEventSummary(LimitEventFilter{UID: 1000, EventType: LimitEventLimit, Start: weekAgo, End: now})
+	if err != nil {
+		t.Fatalf("GetLimitEventSummary() error: %v", err)
+	}
+	if len(summary) != 1 {
+		t.Fatalf("GetLimitEventSummary() returned %d rows, want 1", len(summary))
+	}
+	got := summary[0]
+	if got.Episodes != 2 || got.Ongoing != 1 {
+		t.Errorf("episodes = %d (ongoing %d), want 2 (1)", got.Episodes, got.Ongoing)
+	}
+	// 2h dentro l'intervallo del primo episodio + 30m del secondo
+	want := (2*time.Hour + 30*time.Minute).Seconds()
+	if got.TotalSeconds < want-5 || got.TotalSeconds > want+5 {
+		t.Errorf("TotalSeconds = %.0f, want ~%.0f", got.TotalSeconds, want)
+	}
+	if got.LongestSeconds != 3*3600 {
+		t.Errorf("LongestSeconds = %.0f, want %d", got.LongestSeconds, 3*3600)
+	}
+}
@@ -39,6 +39,36 @@ type UserMetricsRecord struct {
 	CPUQuota         string
 	IsLimited        bool
 	Timestamp        time.Time
+
+	// Colonne aggiunte dalla migrazione 2
+	IOReadBytes      int64   // byte letti (cumulativi) dai dispositivi a blocchi
+	IOWriteBytes     int64   // byte scritti (cumulativi)
+	IOPressure       float64 // io.pressure some avg10 del cgroup utente
+	MemoryPressure   float64 // memory.pressure some avg10 del cgroup utente
+	MemoryHighEvents int64   // contatore high di memory.events
+	MemoryMaxEvents  int64   // contatore max di memory.events
+	OOMKillEvents    int64   // contatore oom_kill di memory.events
+	CPUUsageEMA      float64 // media mobile esponenziale della CPU
+
+	// Colonna aggiunta dalla migrazione 5: usec di CPU addebitati dai cgroup
+	// dell'utente dall'avvio del demone, 0 se la CPU e' misurata da /proc
+	CPUUsageUsec int64
+
+	// Colonne aggiunte dalla migrazione 6: byte IO dall'avvio del demone.
+	// A differenza di IOReadBytes non scendono quando un processo termina.
+	IOReadTotal  int64
+	IOWriteTotal int64
+
+	// Storico da tabelle aggregate: i campi sopra sono le medie del bucket
+	Resolution     string  // ResolutionRaw o la risoluzione del bucket
+	Samples        int64   // campioni grezzi nel bucket
+	CPUMin         float64 // CPU minima nel bucket
+	CPUMax         float64 // CPU massima nel bucket
+	CPUP95         float64 // 95° percentile della CPU
+	MemoryMin      int64   // memoria minima nel bucket
+	MemoryMax      int64   // memoria massima nel bucket
+	MemoryP95      int64   // 95° percentile della memoria
+	LimitedPercent float64 // percentuale di campioni con l'utente limitato
 }
 
 // SystemMetricsRecord rappresenta un record delle metriche di sistema
@@ -49,6 +79,16 @@ type SystemMetricsRecord struct {
 	LimitsActive         bool
 	LimitedUsersCount    int
 	Timestamp            time.Time
+
+	// Storico da tabelle aggregate: i campi sopra sono le medie del bucket
+	Resolution          string
+	Samples             int64
+	CPUMin              float64
+	CPUMax              float64
+	CPUP95              float64
+	SystemLoadMax       float64
+	LimitedUsersMax     int
+	LimitsActivePercent float64
 }
 
 // UserSummary rappresenta le statistiche aggregate per utente
@@ -68,6 +108,7 @@ type UserSummary struct {
 	ProcessCountMax    float64 `json:"process_count_max"`
 	LimitedTimePercent float64 `json:"limited_time_percent"`
 	Samples            int     `json:"samples"`
+	Resolution         string  `json:"resolution"`
 }
 
 // DatabaseInfo rappresenta le informazioni sul database
@@ -85,13 +126,34 @@ type DatabaseInfo struct {
 
 // DatabaseManager gestisce il database SQLite delle metriche
 type DatabaseManager struct {
-	db     *sql.DB
-	mu     sync.RWMutex
-	dbPath string
+	db        *sql.DB
+	mu        sync.RWMutex
+	dbPath    string
+	retention RetentionPolicy
+
+	// Distanza massima tra campioni integrata dalla contabilita'
+	accountingMaxGap time.Duration
 }
 
-// NewDatabaseManager crea un nuovo DatabaseManager
+// NewDatabaseManager crea un nuovo DatabaseManager con lo schema aggiornato
 func NewDatabaseManager(dbPath string) (*DatabaseManager, error) {
+	manager, err := OpenDatabaseManager(dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Inizializza lo schema
+	if err := manager.InitSchema(); err != nil {
+		manager.db.Close()
+		return nil, fmt.Errorf("failed to initialize database schema at %s: %w", dbPath, err)
+	}
+
+	return manager, nil
+}
+
+// OpenDatabaseManager apre il database senza applicare le migrazioni, che
+// vanno eseguite con Migrate (o verificate con MigrationStatus)
+func OpenDatabaseManager(dbPath string) (*DatabaseManager, error) {
 	// Assicura che la directory esista
 	dir := filepath.Dir(dbPath)
 	if dir != ":" { // Skip per :memory:
@@ -100,7 +162,13 @@ func NewDatabaseManager(dbPath string) (*DatabaseManager, error) {
 		}
 	}
 
-	db, err := sql.Open("sqlite3", dbPath)
+	// WAL: le letture (MCP, report) non bloccano il writer e i commit non
+	// riscrivono il file principale
+	dsn := dbPath
+	if dbPath != ":memory:" {
+		dsn += "?_journal_mode=WAL&_synchronous=NORMAL&_busy_timeout=5000"
+	}
+	db, err := sql.Open("sqlite3", dsn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open SQLite database at %s: %w", dbPath, err)
 	}
@@ -110,74 +178,37 @@ func NewDatabaseManager(dbPath string) (*DatabaseManager, error) {
 	db.SetMaxIdleConns(1)
 	db.SetConnMaxLifetime(time.Hour)
 
-	manager := &DatabaseManager{
+	return &DatabaseManager{
 		db:     db,
 		dbPath: dbPath,
-	}
-
-	// Inizializza lo schema
-	if err := manager.InitSchema(); err != nil {
-		db.Close()
-		return nil, fmt.Errorf("failed to initialize database schema at %s: %w", dbPath, err)
-	}
-
-	return manager, nil
+	}, nil
 }
 
-// InitSchema crea le tabelle se non esistono
+// InitSchema porta lo schema all'ultima versione applicando le migrazioni
+// mancanti (vedi migrations.go)
 func (m *DatabaseManager) InitSchema() error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-
-	schema := `
-    -- Tabella per le metriche degli utenti
-    CREATE TABLE IF NOT EXISTS user_metrics (
-        id INTEGER PRIMARY KEY AUTOINCREMENT,
-        timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
-        uid INTEGER NOT NULL,
-        username TEXT NOT NULL,
-        cpu_usage_percent REAL NOT NULL,
-        memory_usage_bytes INTEGER NOT NULL,
-        process_count INTEGER NOT NULL,
-        cgroup_path TEXT,
-        cpu_quota TEXT,
-        is_limited BOOLEAN DEFAULT FALSE
-    );
-
-    -- Tabella per le metriche di sistema
-    CREATE TABLE IF NOT EXISTS system_metrics (
-        id INTEGER PRIMARY KEY AUTOINCREMENT,
-        timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
-        total_cpu_usage_percent REAL NOT NULL,
-        total_cores INTEGER NOT NULL,
-        system_load REAL,
-        limits_active BOOLEAN DEFAULT FALSE,
-        limited_users_count INTEGER
-    );
-
-    -- Indici per performance
-    CREATE INDEX IF NOT EXISTS idx_user_metrics_timestamp ON user_metrics(timestamp);
-    CREATE INDEX IF NOT EXISTS idx_user_metrics_uid ON user_metrics(uid);
-    CREATE INDEX IF NOT EXISTS idx_user_metrics_uid_timestamp ON user_metrics(uid, timestamp);
-    CREATE INDEX IF NOT EXISTS idx_system_metrics_timestamp ON system_metrics(timestamp);
-    `
-
-	_, err := m.db.Exec(schema)
+	_, _, err := m.Migrate()
 	return err
 }
 
-// WriteUserMetrics inserisce un record delle metriche utente
-func (m *DatabaseManager) WriteUserMetrics(record *UserMetricsRecord) error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-
-	query := `
+const insertUserMetricsQuery = `
     INSERT INTO user_metrics (timestamp, uid, username, cpu_usage_percent, memory_usage_bytes, 
-                              process_count, cgroup_path, cpu_quota, is_limited)
-    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+                              process_count, cgroup_path, cpu_quota, is_limited,
+                              io_read_bytes, io_write_bytes, io_pressure, memory_pressure,
+                              memory_high_events, memory_max_events, oom_kill_events, cpu_usage_ema,
+                              cpu_usage_usec, io_read_total, io_write_total)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+    `
+
+const insertSystemMetricsQuery = `
+    INSERT INTO system_metrics (timestamp, total_cpu_usage_percent, total_cores, 
+                                system_load, limits_active, limited_users_count)
+    VALUES (?, ?, ?, ?, ?, ?)
     `
 
-	_, err := m.db.Exec(query,
+// userMetricsArgs restituisce i valori di un record nell'ordine di insertUserMetricsQuery
+func userMetricsArgs(record *UserMetricsRecord) []any {
+	return []any{
 		record.Timestamp,
 		record.UID,
 		record.Username,
@@ -187,8 +218,38 @@ func (m *DatabaseManager) WriteUserMetrics(record *UserMetricsRecord) error {
 		record.CgroupPath,
 		record.CPUQuota,
 		record.IsLimited,
-	)
+		record.IOReadBytes,
+		record.IOWriteBytes,
+		record.IOPressure,
+		record.MemoryPressure,
+		record.MemoryHighEvents,
+		record.MemoryMaxEvents,
+		record.OOMKillEvents,
+		record.CPUUsageEMA,
+		record.CPUUsageUsec,
+		record.IOReadTotal,
+		record.IOWriteTotal,
+	}
+}
+
+// systemMetricsArgs restituisce i valori di un record nell'ordine di insertSystemMetricsQuery
+func systemMetricsArgs(record *SystemMetricsRecord) []any {
+	return []any{
+		record.Timestamp,
+		record.TotalCPUUsagePercent,
+		record.TotalCores,
+		record.SystemLoad,
+		record.LimitsActive,
+		record.LimitedUsersCount,
+	}
+}
+
+// WriteUserMetrics inserisce un record delle metriche utente
+func (m *DatabaseManager) WriteUserMetrics(record *UserMetricsRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
+	_, err := m.db.Exec(insertUserMetricsQuery, userMetricsArgs(record)...)
 	return err
 }
 
@@ -197,32 +258,87 @@ func (m *DatabaseManager) WriteSystemMetrics(record *SystemMetricsRecord) error
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	query := `
-    INSERT INTO system_metrics (timestamp, total_cpu_usage_percent, total_cores, 
-                                system_load, limits_active, limited_users_count)
-    VALUES (?, ?, ?, ?, ?, ?)
-    `
+	_, err := m.db.Exec(insertSystemMetricsQuery, systemMetricsArgs(record)...)
+	return err
+}
 
-	_, err := m.db.Exec(query,
-		record.Timestamp,
-		record.TotalCPUUsagePercent,
-		record.TotalCores,
-		record.SystemLoad,
-		record.LimitsActive,
-		record.LimitedUsersCount,
-	)
+// WriteMetricsBatch inserisce in una sola transazione i record di un ciclo,
+// con un'istruzione preparata per tabella. Se un inserimento fallisce non
+// viene scritto nulla.
+func (m *DatabaseManager) WriteMetricsBatch(systemRecords []*SystemMetricsRecord, userRecords []*UserMetricsRecord, memoryEvents []*UserMemoryEventsRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-	return err
+	tx, err := m.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start metrics batch: %w", err)
+	}
+	defer tx.Rollback()
+
+	if len(systemRecords) > 0 {
+		stmt, err := tx.Prepare(insertSystemMetricsQuery)
+		if err != nil {
+			return fmt.Errorf("failed to prepare system metrics insert: %w", err)
+		}
+		defer stmt.Close()
+		for _, record := range systemRecords {
+			if _, err := stmt.Exec(systemMetricsArgs(record)...); err != nil {
+				return fmt.Errorf("failed to write system metrics batch: %w", err)
+			}
+		}
+	}
+
+	if len(userRecords) > 0 {
+		stmt, err := tx.Prepare(insertUserMetricsQuery)
+		if err != nil {
+			return fmt.Errorf("failed to prepare user metrics insert: %w", err)
+		}
+		defer stmt.Close()
+		for _, record := range userRecords {
+			if _, err := stmt.Exec(userMetricsArgs(record)...); err != nil {
+				return fmt.Errorf("failed to write user metrics batch (UID %d): %w", record.UID, err)
+			}
+		}
+	}
+
+	if len(memoryEvents) > 0 {
+		stmt, err := tx.Prepare(insertMemoryEventsQuery)
+		if err != nil {
+			return fmt.Errorf("failed to prepare memory events insert: %w", err)
+		}
+		defer stmt.Close()
+		for _, record := range memoryEvents {
+			if _, err := stmt.Exec(memoryEventsArgs(record)...); err != nil {
+				return fmt.Errorf("failed to write memory events batch (UID %d): %w", record.UID, err)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit metrics batch: %w", err)
+	}
+	return nil
 }
 
-// GetUserHistory recupera lo storico delle metriche per un utente
+// GetUserHistory recupera lo storico delle metriche per un utente. Per
+// intervalli lunghi legge la tabella aggregata adatta (vedi historyResolution);
+// se questa non ha ancora dati usa i campioni grezzi.
 func (m *DatabaseManager) GetUserHistory(uid int, startTime, endTime time.Time, limit int) ([]UserMetricsRecord, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
+	if resolution := m.historyResolution(startTime, endTime, time.Now()); resolution != ResolutionRaw {
+		records, err := m.userRollupHistory(resolution, uid, startTime, endTime, limit)
+		if err != nil || len(records) > 0 {
+			return records, err
+		}
+	}
+
 	query := `
     SELECT timestamp, uid, username, cpu_usage_percent, memory_usage_bytes,
-           process_count, cgroup_path, cpu_quota, is_limited
+           process_count, cgroup_path, cpu_quota, is_limited,
+           io_read_bytes, io_write_bytes, io_pressure, memory_pressure,
+           memory_high_events, memory_max_events, oom_kill_events, cpu_usage_ema
     FROM user_metrics
     WHERE uid = ? AND timestamp BETWEEN ? AND ?
     ORDER BY timestamp DESC
@@ -240,21 +356,32 @@ func (m *DatabaseManager) GetUserHistory(uid int, startTime, endTime time.Time,
 		var r UserMetricsRecord
 		err := rows.Scan(&r.Timestamp, &r.UID, &r.Username, &r.CPUUsagePercent,
 			&r.MemoryUsageBytes, &r.ProcessCount, &r.CgroupPath,
-			&r.CPUQuota, &r.IsLimited)
+			&r.CPUQuota, &r.IsLimited,
+			&r.IOReadBytes, &r.IOWriteBytes, &r.IOPressure, &r.MemoryPressure,
+			&r.MemoryHighEvents, &r.MemoryMaxEvents, &r.OOMKillEvents, &r.CPUUsageEMA)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan user history record for UID %d: %w", uid, err)
 		}
+		r.Resolution = ResolutionRaw
 		records = append(records, r)
 	}
 
 	return records, rows.Err()
 }
 
-// GetSystemHistory recupera lo storico delle metriche di sistema
+// GetSystemHistory recupera lo storico delle metriche di sistema, con la
+// stessa scelta della risoluzione di GetUserHistory
 func (m *DatabaseManager) GetSystemHistory(startTime, endTime time.Time, limit int) ([]SystemMetricsRecord, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
+	if resolution := m.historyResolution(startTime, endTime, time.Now()); resolution != ResolutionRaw {
+		records, err := m.systemRollupHistory(resolution, startTime, endTime, limit)
+		if err != nil || len(records) > 0 {
+			return records, err
+		}
+	}
+
 	query := `
     SELECT timestamp, total_cpu_usage_percent, total_cores, system_load,
            limits_active, limited_users_count
@@ -278,17 +405,27 @@ func (m *DatabaseManager) GetSystemHistory(startTime, endTime time.Time, limit i
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan system history record: %w", err)
 		}
+		r.Resolution = ResolutionRaw
 		records = append(records, r)
 	}
 
 	return records, rows.Err()
 }
 
-// GetUserSummary recupera le statistiche aggregate per un utente
+// GetUserSummary recupera le statistiche aggregate per un utente. Come lo
+// storico, sugli intervalli lunghi legge le tabelle aggregate invece di
+// scorrere tutti i campioni grezzi.
 func (m *DatabaseManager) GetUserSummary(uid int, startTime, endTime time.Time) (*UserSummary, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
+	if resolution := m.historyResolution(startTime, endTime, time.Now()); resolution != ResolutionRaw {
+		summary, err := m.userRollupSummary(resolution, uid, startTime, endTime)
+		if err != nil || summary != nil {
+			return summary, err
+		}
+	}
+
 	query := `
     SELECT
         uid,
@@ -328,6 +465,7 @@ func (m *DatabaseManager) GetUserSummary(uid int, startTime, endTime time.Time)
 		return nil, fmt.Errorf("failed to query user summary for UID %d (time range %s to %s): %w", uid, startTime.Format(time.RFC3339), endTime.Format(time.RFC3339), err)
 	}
 
+	summary.Resolution = ResolutionRaw
 	return &summary, err
 }
 
@@ -386,8 +524,14 @@ func (m *DatabaseManager) GetDatabaseInfo(retentionDays int) (*DatabaseInfo, err
 	return info, nil
 }
 
-// CleanupOldData rimuove i dati più vecchi di retentionDays
+// CleanupOldData rimuove i dati più vecchi di retentionDays. Prima aggrega i
+// bucket completi, così i campioni grezzi eliminati restano nelle tabelle
+// aggregate fino alla loro retention.
 func (m *DatabaseManager) CleanupOldData(retentionDays int) (int64, error) {
+	if err := m.Rollup(time.Now()); err != nil {
+		return 0, err
+	}
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -410,6 +554,21 @@ func (m *DatabaseManager) CleanupOldData(retentionDays int) (int64, error) {
 		return userDeleted, fmt.Errorf("failed to delete system metrics older than %s (retention %d days): %w", cutoff.Format(time.RFC3339), retentionDays, err)
 	}
 
+	// Rimuovi campioni memory.events vecchi
+	_, err = m.db.Exec("DELETE FROM user_memory_events WHERE timestamp < ?", cutoff)
+	if err != nil {
+		return userDeleted, fmt.Errorf("failed to delete memory events older than %s (retention %d days): %w", cutoff.Format(time.RFC3339), retentionDays, err)
+	}
+
+	// Il journal degli episodi segue la retention piu' lunga, quella dei
+	// rollup giornalieri; gli episodi in corso non vengono mai rimossi
+	if days := m.retention.DailyDays; days > 0 {
+		journalCutoff := time.Now().AddDate(0, 0, -days)
+		if _, err := m.db.Exec("DELETE FROM limit_events WHERE ended_at IS NOT NULL AND ended_at < ?", journalCutoff); err != nil {
+			return userDeleted, fmt.Errorf("failed to delete limit events older than %s: %w", journalCutoff.Format(time.RFC3339), err)
+		}
+	}
+
 	// Vacuum per recuperare spazio
 	_, err = m.db.Exec("VACUUM")
 	if err != nil {
@@ -0,0 +1,104 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+// database/memory_events.go
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+// UserMemoryEventsRecord e' un campione di memory.events e memory.stat del
+// cgroup di un utente. I contatori sono cumulativi come nel kernel.
+type UserMemoryEventsRecord struct {
+	Timestamp    time.Time `json:"timestamp"`
+	UID          int       `json:"uid"`
+	Username     string    `json:"username"`
+	Low          int64     `json:"low"`
+	High         int64     `json:"high"`
+	Max          int64     `json:"max"`
+	OOM          int64     `json:"oom"`
+	OOMKill      int64     `json:"oom_kill"`
+	OOMGroupKill int64     `json:"oom_group_kill"`
+	AnonBytes    int64     `json:"anon_bytes"`
+	FileBytes    int64     `json:"file_bytes"`
+	ShmemBytes   int64     `json:"shmem_bytes"`
+	SwapBytes    int64     `json:"swap_bytes"`
+}
+
+const insertMemoryEventsQuery = `
+    INSERT INTO user_memory_events (timestamp, uid, username, low, high, max, oom, oom_kill,
+        oom_group_kill, anon_bytes, file_bytes, shmem_bytes, swap_bytes)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+    `
+
+// memoryEventsArgs restituisce i valori di un record nell'ordine di insertMemoryEventsQuery
+func memoryEventsArgs(record *UserMemoryEventsRecord) []any {
+	return []any{
+		record.Timestamp, record.UID, record.Username,
+		record.Low, record.High, record.Max, record.OOM, record.OOMKill, record.OOMGroupKill,
+		record.AnonBytes, record.FileBytes, record.ShmemBytes, record.SwapBytes,
+	}
+}
+
+// WriteUserMemoryEvents inserisce un campione di memory.events/memory.stat.
+// Il demone li accoda sul DBWriter, che li scrive con WriteMetricsBatch.
+func (m *DatabaseManager) WriteUserMemoryEvents(record *UserMemoryEventsRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, err := m.db.Exec(insertMemoryEventsQuery, memoryEventsArgs(record)...); err != nil {
+		return fmt.Errorf("failed to write memory events for UID %d: %w", record.UID, err)
+	}
+	return nil
+}
+
+// GetUserMemoryEvents recupera i campioni di memory.events/memory.stat di un
+// utente nell'intervallo, dal piu' recente
+func (m *DatabaseManager) GetUserMemoryEvents(uid int, startTime, endTime time.Time, limit int) ([]UserMemoryEventsRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if limit <= 0 {
+		limit = 1000
+	}
+
+	rows, err := m.db.Query(`
+    SELECT timestamp, uid, username, low, high, max, oom, oom_kill, oom_group_kill,
+        anon_bytes, file_bytes, shmem_bytes, swap_bytes
+    FROM user_memory_events
+    WHERE uid = ? AND timestamp BETWEEN ? AND ?
+    ORDER BY timestamp DESC
+    LIMIT ?
+    `, uid, startTime, endTime, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query memory events for UID %d: %w", uid, err)
+	}
+	defer rows.Close()
+
+	var records []UserMemoryEventsRecord
+	for rows.Next() {
+		var r UserMemoryEventsRecord
+		if err := rows.Scan(&r.Timestamp, &r.UID, &r.Username, &r.Low, &r.High, &r.Max, &r.OOM, &r.OOMKill,
+			&r.OOMGroupKill, &r.AnonBytes, &r.FileBytes, &r.ShmemBytes, &r.SwapBytes); err != nil {
+			return nil, fmt.Errorf("failed to scan memory events record: %w", err)
+		}
+		records = append(records, r)
+	}
+
+	return records, rows.Err()
+}
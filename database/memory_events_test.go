@@ -0,0 +1,64 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+// database/memory_events_test.go
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUserMemoryEventsRoundTrip(t *testing.T) {
+	manager, err := NewDatabaseManager(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create database manager: %v", err)
+	}
+	defer manager.Close()
+
+	now := time.Now()
+	for i, oomKill := range []int64{0, 2} {
+		record := &UserMemoryEventsRecord{
+			Timestamp: now.Add(time.Duration(i-1) * time.Minute),
+			UID:       1000,
+			Username:  "app",
+			High:      10 + int64(i),
+			OOM:       oomKill,
+			OOMKill:   oomKill,
+			AnonBytes: 512 << 20,
+			SwapBytes: 64 << 20,
+		}
+		if err := manager.WriteUserMemoryEvents(record); err != nil {
+			t.Fatalf("WriteUserMemoryEvents() error: %v", err)
+		}
+	}
+
+	records, err := manager.GetUserMemoryEvents(1000, now.Add(-time.Hour), now.Add(time.Hour), 10)
+	if err != nil {
+		t.Fatalf("GetUserMemoryEvents() error: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("GetUserMemoryEvents() returned %d records, expected 2", len(records))
+	}
+	// Dal piu' recente
+	if records[0].OOMKill != 2 || records[0].High != 11 || records[0].SwapBytes != 64<<20 {
+		t.Errorf("latest record = %+v", records[0])
+	}
+
+	if others, _ := manager.GetUserMemoryEvents(1001, now.Add(-time.Hour), now.Add(time.Hour), 10); len(others) != 0 {
+		t.Errorf("records of another user returned: %+v", others)
+	}
+}
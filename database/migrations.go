@@ -0,0 +1,514 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+// database/migrations.go
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// migration e' un passo di evoluzione dello schema. Le migrazioni vengono
+// applicate in ordine di versione, ognuna nella propria transazione, e
+// registrate in schema_version. Una migrazione gia' rilasciata non va mai
+// modificata: ogni cambiamento allo schema e' una nuova versione.
+type migration struct {
+	version     int
+	description string
+	destructive bool // elimina o riscrive dati: prima viene fatto un backup
+	statements  []string
+}
+
+// MigrationInfo descrive una migrazione applicata o da applicare.
+type MigrationInfo struct {
+	Version     int    `json:"version"`
+	Description string `json:"description"`
+	Destructive bool   `json:"destructive"`
+}
+
+// MigrationStatus e' lo stato dello schema rispetto alle migrazioni note.
+type MigrationStatus struct {
+	CurrentVersion int             `json:"current_version"`
+	LatestVersion  int             `json:"latest_version"`
+	Pending        []MigrationInfo `json:"pending,omitempty"`
+}
+
+const schemaVersionTable = `
+    CREATE TABLE IF NOT EXISTS schema_version (
+        version INTEGER PRIMARY KEY,
+        description TEXT NOT NULL,
+        applied_at DATETIME NOT NULL
+    );
+`
+
+// schemaMigrations sono le migrazioni dello schema, in ordine di versione.
+// La versione 1 e' lo schema creato dalle versioni precedenti al framework
+// (tutto IF NOT EXISTS): sui database esistenti non cambia nulla.
+var schemaMigrations = []migration{
+	{
+		version:     1,
+		description: "initial schema",
+		statements: []string{`
+    -- Tabella per le metriche degli utenti
+    CREATE TABLE IF NOT EXISTS user_metrics (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+        uid INTEGER NOT NULL,
+        username TEXT NOT NULL,
+        cpu_usage_percent REAL NOT NULL,
+        memory_usage_bytes INTEGER NOT NULL,
+        process_count INTEGER NOT NULL,
+        cgroup_path TEXT,
+        cpu_quota TEXT,
+        is_limited BOOLEAN DEFAULT FALSE
+    );
+
+    -- Tabella per le metriche di sistema
+    CREATE TABLE IF NOT EXISTS system_metrics (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+        total_cpu_usage_percent REAL NOT NULL,
+        total_cores INTEGER NOT NULL,
+        system_load REAL,
+        limits_active BOOLEAN DEFAULT FALSE,
+        limited_users_count INTEGER
+    );
+
+    -- Statistiche dei pattern di carico: CPU media per utente e ora della settimana
+    CREATE TABLE IF NOT EXISTS user_pattern_stats (
+        uid INTEGER NOT NULL,
+        hour_of_week INTEGER NOT NULL,
+        cpu_avg REAL NOT NULL,
+        samples INTEGER NOT NULL,
+        last_sample DATETIME NOT NULL,
+        PRIMARY KEY (uid, hour_of_week)
+    );
+
+    -- Storico delle policy per pattern applicate, ripristinate o impostate a mano
+    CREATE TABLE IF NOT EXISTS policy_audit (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        timestamp DATETIME NOT NULL,
+        uid INTEGER NOT NULL,
+        action TEXT NOT NULL,
+        pattern TEXT,
+        policy_window TEXT,
+        confidence REAL,
+        cpu_max TEXT,
+        memory_max TEXT,
+        previous_cpu_max TEXT,
+        previous_memory_max TEXT,
+        reason TEXT
+    );
+
+    -- Campioni di memory.events e memory.stat dei cgroup utente
+    CREATE TABLE IF NOT EXISTS user_memory_events (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        timestamp DATETIME NOT NULL,
+        uid INTEGER NOT NULL,
+        username TEXT NOT NULL,
+        low INTEGER NOT NULL DEFAULT 0,
+        high INTEGER NOT NULL DEFAULT 0,
+        max INTEGER NOT NULL DEFAULT 0,
+        oom INTEGER NOT NULL DEFAULT 0,
+        oom_kill INTEGER NOT NULL DEFAULT 0,
+        oom_group_kill INTEGER NOT NULL DEFAULT 0,
+        anon_bytes INTEGER NOT NULL DEFAULT 0,
+        file_bytes INTEGER NOT NULL DEFAULT 0,
+        shmem_bytes INTEGER NOT NULL DEFAULT 0,
+        swap_bytes INTEGER NOT NULL DEFAULT 0
+    );
+
+    -- Indici per performance
+    CREATE INDEX IF NOT EXISTS idx_user_metrics_timestamp ON user_metrics(timestamp);
+    CREATE INDEX IF NOT EXISTS idx_user_metrics_uid ON user_metrics(uid);
+    CREATE INDEX IF NOT EXISTS idx_user_metrics_uid_timestamp ON user_metrics(uid, timestamp);
+    CREATE INDEX IF NOT EXISTS idx_system_metrics_timestamp ON system_metrics(timestamp);
+    CREATE INDEX IF NOT EXISTS idx_policy_audit_uid_timestamp ON policy_audit(uid, timestamp);
+    CREATE INDEX IF NOT EXISTS idx_user_memory_events_uid_timestamp ON user_memory_events(uid, timestamp);
+    `},
+	},
+	{
+		version:     2,
+		description: "add IO, PSI, memory event and EMA columns to user_metrics",
+		statements: []string{
+			"ALTER TABLE user_metrics ADD COLUMN io_read_bytes INTEGER NOT NULL DEFAULT 0",
+			"ALTER TABLE user_metrics ADD COLUMN io_write_bytes INTEGER NOT NULL DEFAULT 0",
+			"ALTER TABLE user_metrics ADD COLUMN io_pressure REAL NOT NULL DEFAULT 0",
+			"ALTER TABLE user_metrics ADD COLUMN memory_pressure REAL NOT NULL DEFAULT 0",
+			"ALTER TABLE user_metrics ADD COLUMN memory_high_events INTEGER NOT NULL DEFAULT 0",
+			"ALTER TABLE user_metrics ADD COLUMN memory_max_events INTEGER NOT NULL DEFAULT 0",
+			"ALTER TABLE user_metrics ADD COLUMN oom_kill_events INTEGER NOT NULL DEFAULT 0",
+			"ALTER TABLE user_metrics ADD COLUMN cpu_usage_ema REAL NOT NULL DEFAULT 0",
+		},
+	},
+	{
+		version:     3,
+		description: "add 5-minute, hourly and daily rollup tables",
+		statements: []string{`
+    -- Fin dove ogni risoluzione e' stata aggregata (bucket completi)
+    CREATE TABLE IF NOT EXISTS metrics_rollup_state (
+        resolution TEXT PRIMARY KEY,
+        rolled_until DATETIME NOT NULL
+    );
+
+    CREATE TABLE IF NOT EXISTS user_metrics_5m (
+        bucket DATETIME NOT NULL,
+        uid INTEGER NOT NULL,
+        username TEXT NOT NULL,
+        samples INTEGER NOT NULL,
+        cpu_avg REAL NOT NULL,
+        cpu_min REAL NOT NULL,
+        cpu_max REAL NOT NULL,
+        cpu_p95 REAL NOT NULL,
+        memory_avg REAL NOT NULL,
+        memory_min INTEGER NOT NULL,
+        memory_max INTEGER NOT NULL,
+        memory_p95 INTEGER NOT NULL,
+        process_count_avg REAL NOT NULL,
+        process_count_min INTEGER NOT NULL,
+        process_count_max INTEGER NOT NULL,
+        io_read_bytes INTEGER NOT NULL,
+        io_write_bytes INTEGER NOT NULL,
+        io_pressure_avg REAL NOT NULL,
+        io_pressure_max REAL NOT NULL,
+        memory_pressure_avg REAL NOT NULL,
+        memory_pressure_max REAL NOT NULL,
+        memory_high_events INTEGER NOT NULL,
+        memory_max_events INTEGER NOT NULL,
+        oom_kill_events INTEGER NOT NULL,
+        limited_samples INTEGER NOT NULL,
+        PRIMARY KEY (uid, bucket)
+    );
+    CREATE INDEX IF NOT EXISTS idx_user_metrics_5m_bucket ON user_metrics_5m(bucket);
+
+    CREATE TABLE IF NOT EXISTS system_metrics_5m (
+        bucket DATETIME PRIMARY KEY,
+        samples INTEGER NOT NULL,
+        cpu_avg REAL NOT NULL,
+        cpu_min REAL NOT NULL,
+        cpu_max REAL NOT NULL,
+        cpu_p95 REAL NOT NULL,
+        total_cores INTEGER NOT NULL,
+        system_load_avg REAL NOT NULL,
+        system_load_max REAL NOT NULL,
+        limits_active_samples INTEGER NOT NULL,
+        limited_users_avg REAL NOT NULL,
+        limited_users_max INTEGER NOT NULL
+    );
+    
+    CREATE TABLE IF NOT EXISTS user_metrics_1h (
+        bucket DATETIME NOT NULL,
+        uid INTEGER NOT NULL,
+        username TEXT NOT NULL,
+        samples INTEGER NOT NULL,
+        cpu_avg REAL NOT NULL,
+        cpu_min REAL NOT NULL,
+        cpu_max REAL NOT NULL,
+        cpu_p95 REAL NOT NULL,
+        memory_avg REAL NOT NULL,
+        memory_min INTEGER NOT NULL,
+        memory_max INTEGER NOT NULL,
+        memory_p95 INTEGER NOT NULL,
+        process_count_avg REAL NOT NULL,
+        process_count_min INTEGER NOT NULL,
+        process_count_max INTEGER NOT NULL,
+        io_read_bytes INTEGER NOT NULL,
+        io_write_bytes INTEGER NOT NULL,
+        io_pressure_avg REAL NOT NULL,
+        io_pressure_max REAL NOT NULL,
+        memory_pressure_avg REAL NOT NULL,
+        memory_pressure_max REAL NOT NULL,
+        memory_high_events INTEGER NOT NULL,
+        memory_max_events INTEGER NOT NULL,
+        oom_kill_events INTEGER NOT NULL,
+        limited_samples INTEGER NOT NULL,
+        PRIMARY KEY (uid, bucket)
+    );
+    CREATE INDEX IF NOT EXISTS idx_user_metrics_1h_bucket ON user_metrics_1h(bucket);
+
+    CREATE TABLE IF NOT EXISTS system_metrics_1h (
+        bucket DATETIME PRIMARY KEY,
+        samples INTEGER NOT NULL,
+        cpu_avg REAL NOT NULL,
+        cpu_min REAL NOT NULL,
+        cpu_max REAL NOT NULL,
+        cpu_p95 REAL NOT NULL,
+        total_cores INTEGER NOT NULL,
+        system_load_avg REAL NOT NULL,
+        system_load_max REAL NOT NULL,
+        limits_active_samples INTEGER NOT NULL,
+        limited_users_avg REAL NOT NULL,
+        limited_users_max INTEGER NOT NULL
+    );
+    
+    CREATE TABLE IF NOT EXISTS user_metrics_1d (
+        bucket DATETIME NOT NULL,
+        uid INTEGER NOT NULL,
+        username TEXT NOT NULL,
+        samples INTEGER NOT NULL,
+        cpu_avg REAL NOT NULL,
+        cpu_min REAL NOT NULL,
+        cpu_max REAL NOT NULL,
+        cpu_p95 REAL NOT NULL,
+        memory_avg REAL NOT NULL,
+        memory_min INTEGER NOT NULL,
+        memory_max INTEGER NOT NULL,
+        memory_p95 INTEGER NOT NULL,
+        process_count_avg REAL NOT NULL,
+        process_count_min INTEGER NOT NULL,
+        process_count_max INTEGER NOT NULL,
+        io_read_bytes INTEGER NOT NULL,
+        io_write_bytes INTEGER NOT NULL,
+        io_pressure_avg REAL NOT NULL,
+        io_pressure_max REAL NOT NULL,
+        memory_pressure_avg REAL NOT NULL,
+        memory_pressure_max REAL NOT NULL,
+        memory_high_events INTEGER NOT NULL,
+        memory_max_events INTEGER NOT NULL,
+        oom_kill_events INTEGER NOT NULL,
+        limited_samples INTEGER NOT NULL,
+        PRIMARY KEY (uid, bucket)
+    );
+    CREATE INDEX IF NOT EXISTS idx_user_metrics_1d_bucket ON user_metrics_1d(bucket);
+
+    CREATE TABLE IF NOT EXISTS system_metrics_1d (
+        bucket DATETIME PRIMARY KEY,
+        samples INTEGER NOT NULL,
+        cpu_avg REAL NOT NULL,
+        cpu_min REAL NOT NULL,
+        cpu_max REAL NOT NULL,
+        cpu_p95 REAL NOT NULL,
+        total_cores INTEGER NOT NULL,
+        system_load_avg REAL NOT NULL,
+        system_load_max REAL NOT NULL,
+        limits_active_samples INTEGER NOT NULL,
+        limited_users_avg REAL NOT NULL,
+        limited_users_max INTEGER NOT NULL
+    );
+    `},
+	},
+	{
+		version:     4,
+		description: "add limit_events journal",
+		statements: []string{`
+    -- Un record per episodio: limite, boost PSI/IO o policy per pattern
+    CREATE TABLE IF NOT EXISTS limit_events (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        uid INTEGER NOT NULL,
+        username TEXT NOT NULL,
+        event_type TEXT NOT NULL,
+        started_at DATETIME NOT NULL,
+        ended_at DATETIME,
+        duration_seconds REAL NOT NULL DEFAULT 0,
+        start_reason TEXT,
+        end_reason TEXT,
+        detail TEXT,
+        cpu_threshold INTEGER NOT NULL DEFAULT 0,
+        cpu_release_threshold INTEGER NOT NULL DEFAULT 0,
+        cpu_usage REAL NOT NULL DEFAULT 0,
+        memory_usage_bytes INTEGER NOT NULL DEFAULT 0,
+        total_cpu_usage REAL NOT NULL DEFAULT 0,
+        limited_users INTEGER NOT NULL DEFAULT 0
+    );
+    CREATE INDEX IF NOT EXISTS idx_limit_events_uid_started ON limit_events(uid, started_at);
+    CREATE INDEX IF NOT EXISTS idx_limit_events_open ON limit_events(uid, event_type, ended_at);
+    `},
+	},
+	{
+		version:     5,
+		description: "add cpu_usage_usec to user_metrics and daily usage accounting",
+		statements: []string{
+			"ALTER TABLE user_metrics ADD COLUMN cpu_usage_usec INTEGER NOT NULL DEFAULT 0",
+			`
+    -- Consumi integrati per utente e giorno (locale), per gli addebiti
+    CREATE TABLE IF NOT EXISTS user_accounting_daily (
+        day TEXT NOT NULL,
+        uid INTEGER NOT NULL,
+        username TEXT NOT NULL,
+        cpu_seconds REAL NOT NULL DEFAULT 0,
+        memory_byte_seconds REAL NOT NULL DEFAULT 0,
+        io_read_bytes INTEGER NOT NULL DEFAULT 0,
+        io_write_bytes INTEGER NOT NULL DEFAULT 0,
+        samples INTEGER NOT NULL DEFAULT 0,
+        covered_seconds REAL NOT NULL DEFAULT 0,
+        gap_seconds REAL NOT NULL DEFAULT 0,
+        counter_resets INTEGER NOT NULL DEFAULT 0,
+        PRIMARY KEY (day, uid)
+    );
+
+    -- Ultimo campione contabilizzato per utente, da cui parte il delta successivo
+    CREATE TABLE IF NOT EXISTS accounting_state (
+        uid INTEGER PRIMARY KEY,
+        timestamp DATETIME NOT NULL,
+        cpu_usage_percent REAL NOT NULL,
+        cpu_usage_usec INTEGER NOT NULL,
+        memory_usage_bytes INTEGER NOT NULL,
+        io_read_bytes INTEGER NOT NULL,
+        io_write_bytes INTEGER NOT NULL
+    );
+    `},
+	},
+	{
+		version:     6,
+		description: "add monotonic IO counters to user_metrics for accounting",
+		statements: []string{
+			"ALTER TABLE user_metrics ADD COLUMN io_read_total INTEGER NOT NULL DEFAULT 0",
+			"ALTER TABLE user_metrics ADD COLUMN io_write_total INTEGER NOT NULL DEFAULT 0",
+		},
+	},
+}
+
+// LatestSchemaVersion restituisce la versione dello schema di questa build.
+func LatestSchemaVersion() int {
+	return schemaMigrations[len(schemaMigrations)-1].version
+}
+
+// Migrate applica le migrazioni mancanti. Se tra queste ce n'e' una
+// distruttiva, prima viene fatta una copia del database; restituisce le
+// migrazioni applicate e il percorso del backup (vuoto se non fatto).
+func (m *DatabaseManager) Migrate() ([]MigrationInfo, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.migrate(schemaMigrations)
+}
+
+// MigrationStatus restituisce la versione dello schema e le migrazioni da
+// applicare, senza modificare il database.
+func (m *DatabaseManager) MigrationStatus() (*MigrationStatus, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	current, err := m.currentSchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	status := &MigrationStatus{CurrentVersion: current, LatestVersion: LatestSchemaVersion()}
+	for _, mig := range pendingMigrations(schemaMigrations, current) {
+		status.Pending = append(status.Pending, mig.info())
+	}
+	return status, nil
+}
+
+func (mig migration) info() MigrationInfo {
+	return MigrationInfo{Version: mig.version, Description: mig.description, Destructive: mig.destructive}
+}
+
+// pendingMigrations restituisce le migrazioni successive alla versione corrente.
+func pendingMigrations(migrations []migration, current int) []migration {
+	var pending []migration
+	for _, mig := range migrations {
+		if mig.version > current {
+			pending = append(pending, mig)
+		}
+	}
+	return pending
+}
+
+// currentSchemaVersion legge la versione registrata in schema_version; 0 per
+// un database nuovo o creato prima del framework delle migrazioni.
+func (m *DatabaseManager) currentSchemaVersion() (int, error) {
+	var exists int
+	err := m.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_version'").Scan(&exists)
+	if err != nil {
+		return 0, fmt.Errorf("failed to look up schema_version table: %w", err)
+	}
+	if exists == 0 {
+		return 0, nil
+	}
+
+	var version sql.NullInt64
+	if err := m.db.QueryRow("SELECT MAX(version) FROM schema_version").Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return int(version.Int64), nil
+}
+
+func (m *DatabaseManager) migrate(migrations []migration) ([]MigrationInfo, string, error) {
+	current, err := m.currentSchemaVersion()
+	if err != nil {
+		return nil, "", err
+	}
+	latest := migrations[len(migrations)-1].version
+	if current > latest {
+		return nil, "", fmt.Errorf("database schema version %d is newer than the %d supported by this resman", current, latest)
+	}
+
+	pending := pendingMigrations(migrations, current)
+	if len(pending) == 0 {
+		return nil, "", nil
+	}
+
+	var backupPath string
+	for _, mig := range pending {
+		if mig.destructive {
+			if backupPath, err = m.backup(current); err != nil {
+				return nil, "", fmt.Errorf("backup before migration %d failed, database left at version %d: %w", mig.version, current, err)
+			}
+			break
+		}
+	}
+
+	if _, err := m.db.Exec(schemaVersionTable); err != nil {
+		return nil, backupPath, fmt.Errorf("failed to create schema_version table: %w", err)
+	}
+
+	var applied []MigrationInfo
+	for _, mig := range pending {
+		if err := m.applyMigration(mig); err != nil {
+			return applied, backupPath, err
+		}
+		applied = append(applied, mig.info())
+	}
+	return applied, backupPath, nil
+}
+
+// applyMigration esegue una migrazione e la registra in una sola transazione:
+// se un'istruzione fallisce lo schema resta alla versione precedente.
+func (m *DatabaseManager) applyMigration(mig migration) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start migration %d: %w", mig.version, err)
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range mig.statements {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", mig.version, mig.description, err)
+		}
+	}
+	if _, err := tx.Exec("INSERT INTO schema_version (version, description, applied_at) VALUES (?, ?, ?)",
+		mig.version, mig.description, time.Now()); err != nil {
+		return fmt.Errorf("failed to record migration %d: %w", mig.version, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit migration %d: %w", mig.version, err)
+	}
+	return nil
+}
+
+// backup copia il database accanto all'originale con VACUUM INTO.
+func (m *DatabaseManager) backup(version int) (string, error) {
+	if m.dbPath == ":memory:" {
+		return "", nil
+	}
+	backupPath := fmt.Sprintf("%s.v%d-%s.bak", m.dbPath, version, time.Now().Format("20060102-150405"))
+	if _, err := m.db.Exec("VACUUM INTO ?", backupPath); err != nil {
+		return "", fmt.Errorf("failed to back up %s to %s: %w", m.dbPath, backupPath, err)
+	}
+	return backupPath, nil
+}
@@ -0,0 +1,142 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+// database/migrations_test.go
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMigrateLegacyDatabase(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "metrics.db")
+
+	// Database creato da una versione senza migrazioni: user_metrics originale
+	legacy, err := OpenDatabaseManager(dbPath)
+	if err != nil {
+		t.Fatalf("OpenDatabaseManager() error: %v", err)
+	}
+	if _, err := legacy.db.Exec(`CREATE TABLE user_metrics (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
+        uid INTEGER NOT NULL,
+        username TEXT NOT NULL,
+        cpu_usage_percent REAL NOT NULL,
+        memory_usage_bytes INTEGER NOT NULL,
+        process_count INTEGER NOT NULL,
+        cgroup_path TEXT,
+        cpu_quota TEXT,
+        is_limited BOOLEAN DEFAULT FALSE
+    )`); err != nil {
+		t.Fatal(err)
+	}
+	status, err := legacy.MigrationStatus()
+	if err != nil {
+		t.Fatalf("MigrationStatus() error: %v", err)
+	}
+	if status.CurrentVersion != 0 || len(status.Pending) != len(schemaMigrations) {
+		t.Errorf("legacy status = %+v, expected version 0 with every migration pending", status)
+	}
+	legacy.Close()
+
+	manager, err := NewDatabaseManager(dbPath)
+	if err != nil {
+		t.Fatalf("NewDatabaseManager() error: %v", err)
+	}
+	defer manager.Close()
+
+	status, err = manager.MigrationStatus()
+	if err != nil {
+		t.Fatalf("MigrationStatus() error: %v", err)
+	}
+	if status.CurrentVersion != LatestSchemaVersion() || len(status.Pending) != 0 {
+		t.Errorf("status after migration = %+v, expected version %d with nothing pending", status, LatestSchemaVersion())
+	}
+
+	// Le colonne aggiunte dalla migrazione 2 sono scritte e rilette
+	now := time.Now()
+	record := &UserMetricsRecord{
+		UID: 1000, Username: "app", Timestamp: now,
+		IOWriteBytes: 4096, IOPressure: 12.5, MemoryHighEvents: 3, OOMKillEvents: 1, CPUUsageEMA: 42,
+	}
+	if err := manager.WriteUserMetrics(record); err != nil {
+		t.Fatalf("WriteUserMetrics() error: %v", err)
+	}
+	records, err := manager.GetUserHistory(1000, now.Add(-time.Minute), now.Add(time.Minute), 10)
+	if err != nil || len(records) != 1 {
+		t.Fatalf("GetUserHistory() = %v, %v", records, err)
+	}
+	got := records[0]
+	if got.IOWriteBytes != 4096 || got.IOPressure != 12.5 || got.MemoryHighEvents != 3 || got.OOMKillEvents != 1 || got.CPUUsageEMA != 42 {
+		t.Errorf("migrated columns not round-tripped: %+v", got)
+	}
+
+	// Riaprire un database aggiornato non applica nulla
+	if applied, _, err := manager.Migrate(); err != nil || len(applied) != 0 {
+		t.Errorf("Migrate() on an up-to-date schema = %v, %v", applied, err)
+	}
+}
+
+func TestMigrateDestructiveBackup(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "metrics.db")
+	manager, err := NewDatabaseManager(dbPath)
+	if err != nil {
+		t.Fatalf("NewDatabaseManager() error: %v", err)
+	}
+	defer manager.Close()
+
+	latest := LatestSchemaVersion()
+	migrations := append(append([]migration(nil), schemaMigrations...),
+		migration{
+			version:     latest + 1,
+			description: "drop system_metrics",
+			destructive: true,
+			statements:  []string{"DROP TABLE system_metrics"},
+		},
+		migration{
+			version:     latest + 2,
+			description: "broken",
+			statements:  []string{"ALTER TABLE missing ADD COLUMN x INTEGER"},
+		},
+	)
+
+	applied, backupPath, err := manager.migrate(migrations)
+	if err == nil {
+		t.Fatal("a failing migration should return an error")
+	}
+	if len(applied) != 1 || applied[0].Version != latest+1 {
+		t.Errorf("applied = %+v, expected only version %d", applied, latest+1)
+	}
+	if backupPath == "" {
+		t.Fatal("a destructive migration should back up the database first")
+	}
+	if _, err := os.Stat(backupPath); err != nil {
+		t.Errorf("backup %s not written: %v", backupPath, err)
+	}
+
+	// La migrazione fallita non viene registrata
+	if version, _ := manager.currentSchemaVersion(); version != latest+1 {
+		t.Errorf("schema version = %d, expected %d", version, latest+1)
+	}
+
+	// Uno schema piu' recente di quello supportato non viene toccato
+	if _, _, err := manager.Migrate(); err == nil {
+		t.Error("Migrate() should refuse a schema newer than the latest known version")
+	}
+}
@@ -0,0 +1,147 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+// database/pattern_stats.go
+package database
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// PatternStatsRecord e' la CPU media di un utente in un'ora della settimana
+type PatternStatsRecord struct {
+	UID        int
+	HourOfWeek int // giorno (0 = domenica) * 24 + ora, ora locale
+	CPUAvg     float64
+	Samples    int
+	LastSample time.Time
+}
+
+// SavePatternStats sostituisce le statistiche dei pattern salvate
+func (m *DatabaseManager) SavePatternStats(records []PatternStatsRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin pattern stats transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM user_pattern_stats"); err != nil {
+		return fmt.Errorf("failed to clear pattern stats: %w", err)
+	}
+
+	stmt, err := tx.Prepare(`
+    INSERT INTO user_pattern_stats (uid, hour_of_week, cpu_avg, samples, last_sample)
+    VALUES (?, ?, ?, ?, ?)
+    `)
+	if err != nil {
+		return fmt.Errorf("failed to prepare pattern stats insert: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, r := range records {
+		if _, err := stmt.Exec(r.UID, r.HourOfWeek, r.CPUAvg, r.Samples, r.LastSample); err != nil {
+			return fmt.Errorf("failed to save pattern stats for UID %d: %w", r.UID, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// LoadPatternStats restituisce le statistiche dei pattern salvate
+func (m *DatabaseManager) LoadPatternStats() ([]PatternStatsRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	rows, err := m.db.Query(`
+    SELECT uid, hour_of_week, cpu_avg, samples, last_sample
+    FROM user_pattern_stats
+    ORDER BY uid, hour_of_week
+    `)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query pattern stats: %w", err)
+	}
+	defer rows.Close()
+
+	var records []PatternStatsRecord
+	for rows.Next() {
+		var r PatternStatsRecord
+		if err := rows.Scan(&r.UID, &r.HourOfWeek, &r.CPUAvg, &r.Samples, &r.LastSample); err != nil {
+			return nil, fmt.Errorf("failed to scan pattern stats record: %w", err)
+		}
+		records = append(records, r)
+	}
+
+	return records, rows.Err()
+}
+
+// GetHourOfWeekCPU aggrega user_metrics successivi a since per utente e ora
+// della settimana (ora locale), per ricostruire le statistiche dei pattern
+func (m *DatabaseManager) GetHourOfWeekCPU(since time.Time) ([]PatternStatsRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	query := `
+    SELECT
+        uid,
+        CAST(strftime('%w', timestamp, 'localtime') AS INTEGER) * 24 +
+            CAST(strftime('%H', timestamp, 'localtime') AS INTEGER) AS hour_of_week,
+        AVG(cpu_usage_percent),
+        COUNT(*),
+        MAX(timestamp)
+    FROM user_metrics
+    WHERE timestamp > ?
+    GROUP BY uid, hour_of_week
+    ORDER BY uid, hour_of_week
+    `
+
+	rows, err := m.db.Query(query, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate user metrics since %s: %w", since.Format(time.RFC3339), err)
+	}
+	defer rows.Close()
+
+	var records []PatternStatsRecord
+	for rows.Next() {
+		var r PatternStatsRecord
+		var lastSample string
+		if err := rows.Scan(&r.UID, &r.HourOfWeek, &r.CPUAvg, &r.Samples, &lastSample); err != nil {
+			return nil, fmt.Errorf("failed to scan hour-of-week record: %w", err)
+		}
+		r.LastSample = parseSQLiteTime(lastSample)
+		records = append(records, r)
+	}
+
+	return records, rows.Err()
+}
+
+// parseSQLiteTime interpreta un timestamp restituito come testo da SQLite
+// (le funzioni aggregate come MAX perdono il tipo DATETIME della colonna)
+func parseSQLiteTime(value string) time.Time {
+	value = strings.TrimSuffix(value, "Z")
+	for _, format := range sqlite3.SQLiteTimestampFormats {
+		if t, err := time.ParseInLocation(format, value, time.UTC); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,103 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+// database/pattern_stats_test.go
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPatternStatsRoundTrip(t *testing.T) {
+	manager, err := NewDatabaseManager(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create database manager: %v", err)
+	}
+	defer manager.Close()
+
+	last := time.Date(2026, 10, 16, 10, 0, 0, 0, time.Local)
+	records := []PatternStatsRecord{
+		{UID: 1000, HourOfWeek: 5*24 + 10, CPUAvg: 12.5, Samples: 40, LastSample: last},
+		{UID: 1000, HourOfWeek: 6*24 + 2, CPUAvg: 55, Samples: 8, LastSample: last},
+	}
+	if err := manager.SavePatternStats(records); err != nil {
+		t.Fatalf("SavePatternStats() error: %v", err)
+	}
+	// Un nuovo salvataggio sostituisce il precedente
+	if err := manager.SavePatternStats(records[:1]); err != nil {
+		t.Fatalf("SavePatternStats() error: %v", err)
+	}
+
+	loaded, err := manager.LoadPatternStats()
+	if err != nil {
+		t.Fatalf("LoadPatternStats() error: %v", err)
+	}
+	if len(loaded) != 1 || loaded[0].HourOfWeek != records[0].HourOfWeek || loaded[0].Samples != 40 {
+		t.Fatalf("LoadPatternStats() = %+v, expected %+v", loaded, records[:1])
+	}
+	if !loaded[0].LastSample.Equal(last) {
+		t.Errorf("last sample = %v, expected %v", loaded[0].LastSample, last)
+	}
+}
+
+func TestGetHourOfWeekCPU(t *testing.T) {
+	manager, err := NewDatabaseManager(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create database manager: %v", err)
+	}
+	defer manager.Close()
+
+	// Sabato 17 ottobre 2026, 23:00 e 23:30 locali; lunedi' 19, 09:15
+	saturday := time.Date(2026, 10, 17, 23, 0, 0, 0, time.Local)
+	monday := time.Date(2026, 10, 19, 9, 15, 0, 0, time.Local)
+	for _, sample := range []struct {
+		at  time.Time
+		cpu float64
+	}{
+		{saturday.Add(-48 * time.Hour), 99}, // prima di since: escluso
+		{saturday, 40},
+		{saturday.Add(30 * time.Minute), 60},
+		{monday, 10},
+	} {
+		record := &UserMetricsRecord{UID: 1000, Username: "testuser", CPUUsagePercent: sample.cpu, Timestamp: sample.at}
+		if err := manager.WriteUserMetrics(record); err != nil {
+			t.Fatalf("WriteUserMetrics() error: %v", err)
+		}
+	}
+
+	records, err := manager.GetHourOfWeekCPU(saturday.Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("GetHourOfWeekCPU() error: %v", err)
+	}
+	expected := map[int]PatternStatsRecord{
+		6*24 + 23: {CPUAvg: 50, Samples: 2, LastSample: saturday.Add(30 * time.Minute)},
+		1*24 + 9:  {CPUAvg: 10, Samples: 1, LastSample: monday},
+	}
+	if len(records) != len(expected) {
+		t.Fatalf("GetHourOfWeekCPU() = %+v, expected %d buckets", records, len(expected))
+	}
+	for _, r := range records {
+		want, ok := expected[r.HourOfWeek]
+		if !ok {
+			t.Errorf("unexpected hour of week %d", r.HourOfWeek)
+			continue
+		}
+		if r.UID != 1000 || r.CPUAvg != want.CPUAvg || r.Samples != want.Samples || !r.LastSample.Equal(want.LastSample) {
+			t.Errorf("hour %d = %+v, expected %+v", r.HourOfWeek, r, want)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+// database/policy_audit.go
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+// PolicyAuditRecord e' un evento del ciclo di vita di una policy per pattern
+type PolicyAuditRecord struct {
+	ID                int64     `json:"id"`
+	Timestamp         time.Time `json:"timestamp"`
+	UID               int       `json:"uid"`
+	Action            string    `json:"action"` // apply, rollback, pin, override, revert, resume
+	Pattern           string    `json:"pattern,omitempty"`
+	Window            string    `json:"window,omitempty"`
+	Confidence        float64   `json:"confidence,omitempty"`
+	CPUMax            string    `json:"cpu_max,omitempty"`
+	MemoryMax         string    `json:"memory_max,omitempty"`
+	PreviousCPUMax    string    `json:"previous_cpu_max,omitempty"`
+	PreviousMemoryMax string    `json:"previous_memory_max,omitempty"`
+	Reason            string    `json:"reason,omitempty"`
+}
+
+// SavePolicyAudit inserisce un evento nello storico delle policy
+func (m *DatabaseManager) SavePolicyAudit(record *PolicyAuditRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	query := `
+    INSERT INTO policy_audit (timestamp, uid, action, pattern, policy_window, confidence,
+        cpu_max, memory_max, previous_cpu_max, previous_memory_max, reason)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+    `
+
+	result, err := m.db.Exec(query,
+		record.Timestamp, record.UID, record.Action, record.Pattern, record.Window, record.Confidence,
+		record.CPUMax, record.MemoryMax, record.PreviousCPUMax, record.PreviousMemoryMax, record.Reason,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save policy audit for UID %d: %w", record.UID, err)
+	}
+	if id, err := result.LastInsertId(); err == nil {
+		record.ID = id
+	}
+	return nil
+}
+
+// GetPolicyAudit restituisce gli ultimi limit eventi dello storico delle
+// policy, dal piu' recente. Con uid <= 0 restituisce quelli di tutti gli utenti
+func (m *DatabaseManager) GetPolicyAudit(uid int, limit int) ([]PolicyAuditRecord, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if limit <= 0 {
+		limit = 100
+	}
+
+	rows, err := m.db.Query(`
+    SELECT id, timestamp, uid, action, pattern, policy_window, confidence,
+        cpu_max, memory_max, previous_cpu_max, previous_memory_max, reason
+    FROM policy_audit
+    WHERE ? <= 0 OR uid = ?
+    ORDER BY timestamp DESC, id DESC
+    LIMIT ?
+    `, uid, uid, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query policy audit: %w", err)
+	}
+	defer rows.Close()
+
+	var records []PolicyAuditRecord
+	for rows.Next() {
+		var r PolicyAuditRecord
+		if err := rows.Scan(&r.ID, &r.Timestamp, &r.UID, &r.Action, &r.Pattern, &r.Window, &r.Confidence,
+			&r.CPUMax, &r.MemoryMax, &r.PreviousCPUMax, &r.PreviousMemoryMax, &r.Reason); err != nil {
+			return nil, fmt.Errorf("failed to scan policy audit record: %w", err)
+		}
+		records = append(records, r)
+	}
+
+	return records, rows.Err()
+}
@@ -0,0 +1,713 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+// database/rollup.go
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Nomi delle risoluzioni restituite nei record di storico
+const (
+	ResolutionRaw        = "raw"
+	ResolutionFiveMinute = "5m"
+	ResolutionHourly     = "1h"
+	ResolutionDaily      = "1d"
+)
+
+// rollupGrace evita di chiudere un bucket mentre il writer sta ancora
+// committando i campioni del suo ultimo ciclo
+const rollupGrace = time.Minute
+
+// rollupResolution descrive una tabella di aggregazione. Ogni livello viene
+// calcolato dal precedente: i 5 minuti dai campioni grezzi, le ore dai 5
+// minuti, i giorni dalle ore.
+type rollupResolution struct {
+	name     string
+	step     time.Duration
+	chunk    time.Duration // finestra letta dalla sorgente per transazione
+	maxRange time.Duration // intervallo di storico più lungo servito da questa risoluzione
+	source   string        // risoluzione sorgente, ResolutionRaw per i campioni
+}
+
+var rollupResolutions = []rollupResolution{
+	{name: ResolutionFiveMinute, step: 5 * time.Minute, chunk: 6 * time.Hour, maxRange: 7 * 24 * time.Hour, source: ResolutionRaw},
+	{name: ResolutionHourly, step: time.Hour, chunk: 7 * 24 * time.Hour, maxRange: 90 * 24 * time.Hour, source: ResolutionFiveMinute},
+	{name: ResolutionDaily, step: 24 * time.Hour, chunk: 30 * 24 * time.Hour, source: ResolutionHourly},
+}
+
+// rawHistoryMaxRange è l'intervallo più lungo servito dai campioni grezzi
+const rawHistoryMaxRange = 24 * time.Hour
+
+// RetentionPolicy indica per quanti giorni conservare i campioni grezzi e
+// ciascuna risoluzione aggregata; 0 conserva i dati senza limite.
+type RetentionPolicy struct {
+	RawDays        int
+	FiveMinuteDays int
+	HourlyDays     int
+	DailyDays      int
+}
+
+func (p RetentionPolicy) days(resolution string) int {
+	switch resolution {
+	case ResolutionRaw:
+		return p.RawDays
+	case ResolutionFiveMinute:
+		return p.FiveMinuteDays
+	case ResolutionHourly:
+		return p.HourlyDays
+	case ResolutionDaily:
+		return p.DailyDays
+	}
+	return 0
+}
+
+// userRollup è una riga di user_metrics_<risoluzione>. Le colonne IO e
+// memory.events sono contatori cumulativi: il bucket conserva l'ultimo valore.
+type userRollup struct {
+	Bucket            time.Time
+	UID               int
+	Username          string
+	Samples           int64
+	CPUAvg            float64
+	CPUMin            float64
+	CPUMax            float64
+	CPUP95            float64
+	MemoryAvg         float64
+	MemoryMin         int64
+	MemoryMax         int64
+	MemoryP95         int64
+	ProcessCountAvg   float64
+	ProcessCountMin   int
+	ProcessCountMax   int
+	IOReadBytes       int64
+	IOWriteBytes      int64
+	IOPressureAvg     float64
+	IOPressureMax     float64
+	MemoryPressureAvg float64
+	MemoryPressureMax float64
+	MemoryHighEvents  int64
+	MemoryMaxEvents   int64
+	OOMKillEvents     int64
+	LimitedSamples    int64
+}
+
+// systemRollup è una riga di system_metrics_<risoluzione>
+type systemRollup struct {
+	Bucket              time.Time
+	Samples             int64
+	CPUAvg              float64
+	CPUMin              float64
+	CPUMax              float64
+	CPUP95              float64
+	TotalCores          int
+	SystemLoadAvg       float64
+	SystemLoadMax       float64
+	LimitsActiveSamples int64
+	LimitedUsersAvg     float64
+	LimitedUsersMax     int
+}
+
+const userRollupColumns = `bucket, uid, username, samples, cpu_avg, cpu_min, cpu_max, cpu_p95,
+           memory_avg, memory_min, memory_max, memory_p95,
+           process_count_avg, process_count_min, process_count_max,
+           io_read_bytes, io_write_bytes, io_pressure_avg, io_pressure_max,
+           memory_pressure_avg, memory_pressure_max,
+           memory_high_events, memory_max_events, oom_kill_events, limited_samples`
+
+const systemRollupColumns = `bucket, samples, cpu_avg, cpu_min, cpu_max, cpu_p95, total_cores,
+           system_load_avg, system_load_max, limits_active_samples,
+           limited_users_avg, limited_users_max`
+
+func (r *userRollup) scanArgs() []any {
+	return []any{&r.Bucket, &r.UID, &r.Username, &r.Samples,
+		&r.CPUAvg, &r.CPUMin, &r.CPUMax, &r.CPUP95,
+		&r.MemoryAvg, &r.MemoryMin, &r.MemoryMax, &r.MemoryP95,
+		&r.ProcessCountAvg, &r.ProcessCountMin, &r.ProcessCountMax,
+		&r.IOReadBytes, &r.IOWriteBytes, &r.IOPressureAvg, &r.IOPressureMax,
+		&r.MemoryPressureAvg, &r.MemoryPressureMax,
+		&r.MemoryHighEvents, &r.MemoryMaxEvents, &r.OOMKillEvents, &r.LimitedSamples}
+}
+
+func (r *systemRollup) scanArgs() []any {
+	return []any{&r.Bucket, &r.Samples, &r.CPUAvg, &r.CPUMin, &r.CPUMax, &r.CPUP95,
+		&r.TotalCores, &r.SystemLoadAvg, &r.SystemLoadMax, &r.LimitsActiveSamples,
+		&r.LimitedUsersAvg, &r.LimitedUsersMax}
+}
+
+// record converte la riga aggregata nel formato dello storico: i valori
+// istantanei diventano le medie del bucket.
+func (r *userRollup) record(resolution string) UserMetricsRecord {
+	return UserMetricsRecord{
+		UID:              r.UID,
+		Username:         r.Username,
+		CPUUsagePercent:  r.CPUAvg,
+		MemoryUsageBytes: int64(r.MemoryAvg),
+		ProcessCount:     int(math.Round(r.ProcessCountAvg)),
+		IsLimited:        r.LimitedSamples > 0,
+		Timestamp:        r.Bucket,
+		IOReadBytes:      r.IOReadBytes,
+		IOWriteBytes:     r.IOWriteBytes,
+		IOPressure:       r.IOPressureAvg,
+		MemoryPressure:   r.MemoryPressureAvg,
+		MemoryHighEvents: r.MemoryHighEvents,
+		MemoryMaxEvents:  r.MemoryMaxEvents,
+		OOMKillEvents:    r.OOMKillEvents,
+		Resolution:       resolution,
+		Samples:          r.Samples,
+		CPUMin:           r.CPUMin,
+		CPUMax:           r.CPUMax,
+		CPUP95:           r.CPUP95,
+		MemoryMin:        r.MemoryMin,
+		MemoryMax:        r.MemoryMax,
+		MemoryP95:        r.MemoryP95,
+		LimitedPercent:   float64(r.LimitedSamples) / float64(r.Samples) * 100,
+	}
+}
+
+func (r *systemRollup) record(resolution string) SystemMetricsRecord {
+	return SystemMetricsRecord{
+		TotalCPUUsagePercent: r.CPUAvg,
+		TotalCores:           r.TotalCores,
+		SystemLoad:           r.SystemLoadAvg,
+		LimitsActive:         r.LimitsActiveSamples > 0,
+		LimitedUsersCount:    int(math.Round(r.LimitedUsersAvg)),
+		Timestamp:            r.Bucket,
+		Resolution:           resolution,
+		Samples:              r.Samples,
+		CPUMin:               r.CPUMin,
+		CPUMax:               r.CPUMax,
+		CPUP95:               r.CPUP95,
+		SystemLoadMax:        r.SystemLoadMax,
+		LimitedUsersMax:      r.LimitedUsersMax,
+		LimitsActivePercent:  float64(r.LimitsActiveSamples) / float64(r.Samples) * 100,
+	}
+}
+
+// SetRetentionPolicy imposta la retention usata da CleanupOldData, da Rollup
+// e dalla scelta della risoluzione dello storico.
+func (m *DatabaseManager) SetRetentionPolicy(policy RetentionPolicy) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.retention = policy
+}
+
+// Rollup aggrega i bucket completi non ancora elaborati di ogni risoluzione
+// ed elimina le righe aggregate oltre la propria retention. Ogni finestra
+// viene scritta in una transazione separata insieme al punto di
+// avanzamento, quindi un'interruzione non perde né duplica dati. Prima
+// aggiorna la contabilita' giornaliera dei consumi.
+func (m *DatabaseManager) Rollup(now time.Time) error {
+	if err := m.updateAccounting(now); err != nil {
+		return err
+	}
+	for _, res := range rollupResolutions {
+		if err := m.rollupResolution(res, now); err != nil {
+			return err
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, res := range rollupResolutions {
+		days := m.retention.days(res.name)
+		if days <= 0 {
+			continue
+		}
+		cutoff := now.AddDate(0, 0, -days)
+		for _, table := range []string{"user_metrics_" + res.name, "system_metrics_" + res.name} {
+			if _, err := m.db.Exec("DELETE FROM "+table+" WHERE bucket < ?", cutoff); err != nil {
+				return fmt.Errorf("failed to delete %s rows older than %s: %w", table, cutoff.Format(time.RFC3339), err)
+			}
+		}
+	}
+	return nil
+}
+
+func (m *DatabaseManager) rollupResolution(res rollupResolution, now time.Time) error {
+	m.mu.RLock()
+	from, until, err := m.rollupWindow(res, now)
+	m.mu.RUnlock()
+	if err != nil || from.IsZero() {
+		return err
+	}
+
+	for start := from; start.Before(until); start = start.Add(res.chunk) {
+		end := start.Add(res.chunk)
+		if end.After(until) {
+			end = until
+		}
+		m.mu.Lock()
+		err := m.rollupChunk(res, start, end)
+		m.mu.Unlock()
+		if err != nil {
+			return fmt.Errorf("failed to roll up %s metrics from %s to %s: %w", res.name, start.Format(time.RFC3339), end.Format(time.RFC3339), err)
+		}
+	}
+	return nil
+}
+
+// rollupWindow restituisce l'intervallo [from, until) di bucket completi da
+// aggregare; from è zero se non c'è nulla da fare.
+func (m *DatabaseManager) rollupWindow(res rollupResolution, now time.Time) (time.Time, time.Time, error) {
+	until := now.Add(-rollupGrace).Truncate(res.step)
+	if res.source != ResolutionRaw {
+		sourceDone, err := m.rolledUntil(res.source)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		if sourceDone.IsZero() {
+			return time.Time{}, time.Time{}, nil
+		}
+		if limit := sourceDone.Truncate(res.step); limit.Before(until) {
+			until = limit
+		}
+	}
+
+	from, err := m.rolledUntil(res.name)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if from.IsZero() {
+		table, column := "user_metrics", "timestamp"
+		if res.source != ResolutionRaw {
+			table, column = "user_metrics_"+res.source, "bucket"
+		}
+		// ORDER BY invece di MIN(): un aggregato perde il tipo DATETIME
+		var oldest time.Time
+		err := m.db.QueryRow("SELECT " + column + " FROM " + table + " ORDER BY " + column + " LIMIT 1").Scan(&oldest)
+		if err == sql.ErrNoRows {
+			return time.Time{}, time.Time{}, nil
+		}
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("failed to find oldest sample in %s: %w", table, err)
+		}
+		from = oldest.In(now.Location()).Truncate(res.step)
+	}
+
+	// Inutile aggregare bucket che la retention eliminerebbe subito
+	if days := m.retention.days(res.name); days > 0 {
+		if cutoff := now.AddDate(0, 0, -days).Truncate(res.step); from.Before(cutoff) {
+			from = cutoff
+		}
+	}
+
+	if !from.Before(until) {
+		return time.Time{}, time.Time{}, nil
+	}
+	return from, until, nil
+}
+
+func (m *DatabaseManager) rolledUntil(resolution string) (time.Time, error) {
+	var until time.Time
+	err := m.db.QueryRow("SELECT rolled_until FROM metrics_rollup_state WHERE resolution = ?", resolution).Scan(&until)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read %s rollup state: %w", resolution, err)
+	}
+	return until, nil
+}
+
+// rollupChunk aggrega i bucket in [start, end) e avanza lo stato
+func (m *DatabaseManager) rollupChunk(res rollupResolution, start, end time.Time) error {
+	users, err := m.aggregateUsers(res, start, end)
+	if err != nil {
+		return err
+	}
+	systems, err := m.aggregateSystem(res, start, end)
+	if err != nil {
+		return err
+	}
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if len(users) > 0 {
+		stmt, err := tx.Prepare("INSERT OR REPLACE INTO user_metrics_" + res.name + " (" + userRollupColumns + ") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, r := range users {
+			if _, err := stmt.Exec(r.Bucket, r.UID, r.Username, r.Samples,
+				r.CPUAvg, r.CPUMin, r.CPUMax, r.CPUP95,
+				r.MemoryAvg, r.MemoryMin, r.MemoryMax, r.MemoryP95,
+				r.ProcessCountAvg, r.ProcessCountMin, r.ProcessCountMax,
+				r.IOReadBytes, r.IOWriteBytes, r.IOPressureAvg, r.IOPressureMax,
+				r.MemoryPressureAvg, r.MemoryPressureMax,
+				r.MemoryHighEvents, r.MemoryMaxEvents, r.OOMKillEvents, r.LimitedSamples); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(systems) > 0 {
+		stmt, err := tx.Prepare("INSERT OR REPLACE INTO system_metrics_" + res.name + " (" + systemRollupColumns + ") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, r := range systems {
+			if _, err := stmt.Exec(r.Bucket, r.Samples, r.CPUAvg, r.CPUMin, r.CPUMax, r.CPUP95,
+				r.TotalCores, r.SystemLoadAvg, r.SystemLoadMax, r.LimitsActiveSamples,
+				r.LimitedUsersAvg, r.LimitedUsersMax); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err := tx.Exec("INSERT OR REPLACE INTO metrics_rollup_state (resolution, rolled_until) VALUES (?, ?)", res.name, end); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// userAccumulator raccoglie i campioni di un utente in un bucket
+type userAccumulator struct {
+	row        userRollup
+	cpuSum     float64
+	memorySum  float64
+	processSum float64
+	ioPSISum   float64
+	memPSISum  float64
+	cpuValues  []float64 // solo per i campioni grezzi, per il p95 esatto
+	memValues  []float64
+}
+
+// add unisce una riga (un campione grezzo ha Samples=1 e min=max=p95=valore)
+func (a *userAccumulator) add(r userRollup, raw bool) {
+	n := float64(r.Samples)
+	if a.row.Samples == 0 {
+		a.row.CPUMin, a.row.MemoryMin, a.row.ProcessCountMin = r.CPUMin, r.MemoryMin, r.ProcessCountMin
+	}
+	a.row.Username = r.Username
+	a.row.Samples += r.Samples
+	a.cpuSum += r.CPUAvg * n
+	a.memorySum += r.MemoryAvg * n
+	a.processSum += r.ProcessCountAvg * n
+	a.ioPSISum += r.IOPressureAvg * n
+	a.memPSISum += r.MemoryPressureAvg * n
+	a.row.CPUMin = math.Min(a.row.CPUMin, r.CPUMin)
+	a.row.CPUMax = math.Max(a.row.CPUMax, r.CPUMax)
+	a.row.MemoryMin = min(a.row.MemoryMin, r.MemoryMin)
+	a.row.MemoryMax = max(a.row.MemoryMax, r.MemoryMax)
+	a.row.ProcessCountMin = min(a.row.ProcessCountMin, r.ProcessCountMin)
+	a.row.ProcessCountMax = max(a.row.ProcessCountMax, r.ProcessCountMax)
+	a.row.IOReadBytes = max(a.row.IOReadBytes, r.IOReadBytes)
+	a.row.IOWriteBytes = max(a.row.IOWriteBytes, r.IOWriteBytes)
+	a.row.IOPressureMax = math.Max(a.row.IOPressureMax, r.IOPressureMax)
+	a.row.MemoryPressureMax = math.Max(a.row.MemoryPressureMax, r.MemoryPressureMax)
+	a.row.MemoryHighEvents = max(a.row.MemoryHighEvents, r.MemoryHighEvents)
+	a.row.MemoryMaxEvents = max(a.row.MemoryMaxEvents, r.MemoryMaxEvents)
+	a.row.OOMKillEvents = max(a.row.OOMKillEvents, r.OOMKillEvents)
+	a.row.LimitedSamples += r.LimitedSamples
+	if raw {
+		a.cpuValues = append(a.cpuValues, r.CPUAvg)
+		a.memValues = append(a.memValues, r.MemoryAvg)
+	} else {
+		// Il p95 di un livello superiore è il massimo dei p95 sorgente:
+		// una stima per eccesso, adatta alla pianificazione della capacità
+		a.row.CPUP95 = math.Max(a.row.CPUP95, r.CPUP95)
+		a.row.MemoryP95 = max(a.row.MemoryP95, r.MemoryP95)
+	}
+}
+
+func (a *userAccumulator) result() userRollup {
+	n := float64(a.row.Samples)
+	row := a.row
+	row.CPUAvg = a.cpuSum / n
+	row.MemoryAvg = a.memorySum / n
+	row.ProcessCountAvg = a.processSum / n
+	row.IOPressureAvg = a.ioPSISum / n
+	row.MemoryPressureAvg = a.memPSISum / n
+	if a.cpuValues != nil {
+		row.CPUP95 = percentile95(a.cpuValues)
+		row.MemoryP95 = int64(percentile95(a.memValues))
+	}
+	return row
+}
+
+// aggregateUsers legge la sorgente in [start, end) e la raggruppa per utente e bucket
+func (m *DatabaseManager) aggregateUsers(res rollupResolution, start, end time.Time) ([]userRollup, error) {
+	raw := res.source == ResolutionRaw
+	var query string
+	if raw {
+		query = `
+    SELECT timestamp, uid, username, 1, cpu_usage_percent, cpu_usage_percent, cpu_usage_percent, cpu_usage_percent,
+           memory_usage_bytes, memory_usage_bytes, memory_usage_bytes, memory_usage_bytes,
+           process_count, process_count, process_count,
+           io_read_bytes, io_write_bytes, io_pressure, io_pressure,
+           memory_pressure, memory_pressure,
+           memory_high_events, memory_max_events, oom_kill_events, CASE WHEN is_limited THEN 1 ELSE 0 END
+    FROM user_metrics
+    WHERE timestamp >= ? AND timestamp < ?
+    ORDER BY timestamp`
+	} else {
+		query = "SELECT " + userRollupColumns + " FROM user_metrics_" + res.source + " WHERE bucket >= ? AND bucket < ? ORDER BY bucket"
+	}
+
+	rows, err := m.db.Query(query, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type key struct {
+		uid    int
+		bucket int64
+	}
+	accumulators := make(map[key]*userAccumulator)
+	var order []key
+	for rows.Next() {
+		var r userRollup
+		if err := rows.Scan(r.scanArgs()...); err != nil {
+			return nil, err
+		}
+		bucket := r.Bucket.In(start.Location()).Truncate(res.step)
+		k := key{uid: r.UID, bucket: bucket.UnixNano()}
+		acc, ok := accumulators[k]
+		if !ok {
+			acc = &userAccumulator{row: userRollup{Bucket: bucket, UID: r.UID}}
+			accumulators[k] = acc
+			order = append(order, k)
+		}
+		acc.add(r, raw)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := make([]userRollup, 0, len(order))
+	for _, k := range order {
+		result = append(result, accumulators[k].result())
+	}
+	return result, nil
+}
+
+// aggregateSystem legge la sorgente in [start, end) e la raggruppa per bucket
+func (m *DatabaseManager) aggregateSystem(res rollupResolution, start, end time.Time) ([]systemRollup, error) {
+	raw := res.source == ResolutionRaw
+	var query string
+	if raw {
+		query = `
+    SELECT timestamp, 1, total_cpu_usage_percent, total_cpu_usage_percent, total_cpu_usage_percent, total_cpu_usage_percent,
+           total_cores, COALESCE(system_load, 0), COALESCE(system_load, 0),
+           CASE WHEN limits_active THEN 1 ELSE 0 END,
+           COALESCE(limited_users_count, 0), COALESCE(limited_users_count, 0)
+    FROM system_metrics
+    WHERE timestamp >= ? AND timestamp < ?
+    ORDER BY timestamp`
+	} else {
+		query = "SELECT " + systemRollupColumns + " FROM system_metrics_" + res.source + " WHERE bucket >= ? AND bucket < ? ORDER BY bucket"
+	}
+
+	rows, err := m.db.Query(query, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type accumulator struct {
+		row        systemRollup
+		cpuSum     float64
+		loadSum    float64
+		limitedSum float64
+		cpuValues  []float64
+	}
+	accumulators := make(map[int64]*accumulator)
+	var order []int64
+	for rows.Next() {
+		var r systemRollup
+		if err := rows.Scan(r.scanArgs()...); err != nil {
+			return nil, err
+		}
+		bucket := r.Bucket.In(start.Location()).Truncate(res.step)
+		acc, ok := accumulators[bucket.UnixNano()]
+		if !ok {
+			acc = &accumulator{row: systemRollup{Bucket: bucket, CPUMin: r.CPUMin}}
+			accumulators[bucket.UnixNano()] = acc
+			order = append(order, bucket.UnixNano())
+		}
+		n := float64(r.Samples)
+		acc.row.Samples += r.Samples
+		acc.cpuSum += r.CPUAvg * n
+		acc.loadSum += r.SystemLoadAvg * n
+		acc.limitedSum += r.LimitedUsersAvg * n
+		acc.row.CPUMin = math.Min(acc.row.CPUMin, r.CPUMin)
+		acc.row.CPUMax = math.Max(acc.row.CPUMax, r.CPUMax)
+		acc.row.TotalCores = max(acc.row.TotalCores, r.TotalCores)
+		acc.row.SystemLoadMax = math.Max(acc.row.SystemLoadMax, r.SystemLoadMax)
+		acc.row.LimitsActiveSamples += r.LimitsActiveSamples
+		acc.row.LimitedUsersMax = max(acc.row.LimitedUsersMax, r.LimitedUsersMax)
+		if raw {
+			acc.cpuValues = append(acc.cpuValues, r.CPUAvg)
+		} else {
+			acc.row.CPUP95 = math.Max(acc.row.CPUP95, r.CPUP95)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := make([]systemRollup, 0, len(order))
+	for _, k := range order {
+		acc := accumulators[k]
+		n := float64(acc.row.Samples)
+		row := acc.row
+		row.CPUAvg = acc.cpuSum / n
+		row.SystemLoadAvg = acc.loadSum / n
+		row.LimitedUsersAvg = acc.limitedSum / n
+		if acc.cpuValues != nil {
+			row.CPUP95 = percentile95(acc.cpuValues)
+		}
+		result = append(result, row)
+	}
+	return result, nil
+}
+
+// percentile95 restituisce il 95° percentile con il metodo nearest-rank
+func percentile95(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	rank := int(math.Ceil(0.95*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
+
+// historyResolution sceglie la risoluzione più fine che copre l'intervallo
+// richiesto e i cui dati non sono ancora stati eliminati dalla retention.
+func (m *DatabaseManager) historyResolution(startTime, endTime, now time.Time) string {
+	span := endTime.Sub(startTime)
+	covers := func(name string, maxRange time.Duration) bool {
+		if maxRange > 0 && span > maxRange {
+			return false
+		}
+		days := m.retention.days(name)
+		return days <= 0 || !startTime.Before(now.AddDate(0, 0, -days))
+	}
+
+	if covers(ResolutionRaw, rawHistoryMaxRange) {
+		return ResolutionRaw
+	}
+	for _, res := range rollupResolutions {
+		if covers(res.name, res.maxRange) {
+			return res.name
+		}
+	}
+	return ResolutionDaily
+}
+
+func (m *DatabaseManager) userRollupHistory(resolution string, uid int, startTime, endTime time.Time, limit int) ([]UserMetricsRecord, error) {
+	query := "SELECT " + userRollupColumns + " FROM user_metrics_" + resolution + `
+    WHERE uid = ? AND bucket BETWEEN ? AND ?
+    ORDER BY bucket DESC
+    LIMIT ?`
+
+	rows, err := m.db.Query(query, uid, startTime, endTime, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s user history for UID %d: %w", resolution, uid, err)
+	}
+	defer rows.Close()
+
+	var records []UserMetricsRecord
+	for rows.Next() {
+		var r userRollup
+		if err := rows.Scan(r.scanArgs()...); err != nil {
+			return nil, fmt.Errorf("failed to scan %s user history record for UID %d: %w", resolution, uid, err)
+		}
+		records = append(records, r.record(resolution))
+	}
+	return records, rows.Err()
+}
+
+func (m *DatabaseManager) systemRollupHistory(resolution string, startTime, endTime time.Time, limit int) ([]SystemMetricsRecord, error) {
+	query := "SELECT " + systemRollupColumns + " FROM system_metrics_" + resolution + `
+    WHERE bucket BETWEEN ? AND ?
+    ORDER BY bucket DESC
+    LIMIT ?`
+
+	rows, err := m.db.Query(query, startTime, endTime, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s system history: %w", resolution, err)
+	}
+	defer rows.Close()
+
+	var records []SystemMetricsRecord
+	for rows.Next() {
+		var r systemRollup
+		if err := rows.Scan(r.scanArgs()...); err != nil {
+			return nil, fmt.Errorf("failed to scan %s system history record: %w", resolution, err)
+		}
+		records = append(records, r.record(resolution))
+	}
+	return records, rows.Err()
+}
+
+func (m *DatabaseManager) userRollupSummary(resolution string, uid int, startTime, endTime time.Time) (*UserSummary, error) {
+	query := `
+    SELECT
+        uid,
+        MAX(username),
+        MIN(bucket),
+        MAX(bucket),
+        SUM(cpu_avg * samples) / SUM(samples),
+        MIN(cpu_min),
+        MAX(cpu_max),
+        SUM(memory_avg * samples) / SUM(samples),
+        MIN(memory_min),
+        MAX(memory_max),
+        SUM(process_count_avg * samples) / SUM(samples),
+        MIN(process_count_min),
+        MAX(process_count_max),
+        CAST(SUM(limited_samples) AS FLOAT) / SUM(samples) * 100,
+        SUM(samples)
+    FROM user_metrics_` + resolution + `
+    WHERE uid = ? AND bucket BETWEEN ? AND ?
+    GROUP BY uid
+    `
+
+	var summary UserSummary
+	err := m.db.QueryRow(query, uid, startTime, endTime).Scan(
+		&summary.UID, &summary.Username, &summary.PeriodStart, &summary.PeriodEnd,
+		&summary.CPUAvg, &summary.CPUMin, &summary.CPUMax,
+		&summary.MemoryAvg, &summary.MemoryMin, &summary.MemoryMax,
+		&summary.ProcessCountAvg, &summary.ProcessCountMin, &summary.ProcessCountMax,
+		&summary.LimitedTimePercent, &summary.Samples,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s user summary for UID %d: %w", resolution, uid, err)
+	}
+	summary.Resolution = resolution
+	return &summary, nil
+}
@@ -0,0 +1,144 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+// database/rollup_test.go
+package database
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRollupResolutions(t *testing.T) {
+	manager, err := NewDatabaseManager(filepath.Join(t.TempDir(), "metrics.db"))
+	if err != nil {
+		t.Fatalf("Failed to create database manager: %v", err)
+	}
+	defer manager.Close()
+
+	// Due ore di campioni al minuto, tre giorni fa: CPU = minuto, limitato nella seconda ora
+	now := time.Now()
+	base := now.Add(-3 * 24 * time.Hour).Truncate(24 * time.Hour)
+	for k := 0; k < 120; k++ {
+		ts := base.Add(time.Duration(k) * time.Minute)
+		if err := manager.WriteUserMetrics(&UserMetricsRecord{
+			UID: 1000, Username: "alice", Timestamp: ts,
+			CPUUsagePercent: float64(k), MemoryUsageBytes: int64(k) * 1024, ProcessCount: 2,
+			IsLimited: k >= 60, IOReadBytes: int64(k) * 100,
+		}); err != nil {
+			t.Fatalf("WriteUserMetrics() error: %v", err)
+		}
+		if err := manager.WriteSystemMetrics(&SystemMetricsRecord{
+			Timestamp: ts, TotalCPUUsagePercent: float64(k), TotalCores: 4,
+		}); err != nil {
+			t.Fatalf("WriteSystemMetrics() error: %v", err)
+		}
+	}
+
+	// Due esecuzioni: la seconda non deve duplicare nulla
+	for i := 0; i < 2; i++ {
+		if err := manager.Rollup(now); err != nil {
+			t.Fatalf("Rollup() error: %v", err)
+		}
+	}
+
+	from, to := base.Add(-time.Hour), base.Add(3*time.Hour)
+	fiveMin, err := manager.userRollupHistory(ResolutionFiveMinute, 1000, from, to, 1000)
+	if err != nil || len(fiveMin) != 24 {
+		t.Fatalf("5m rollups = %d, %v; expected 24", len(fiveMin), err)
+	}
+	// Il primo bucket (ordine decrescente: l'ultimo) contiene i minuti 0-4
+	first := fiveMin[len(fiveMin)-1]
+	if first.Samples != 5 || first.CPUUsagePercent != 2 || first.CPUMin != 0 || first.CPUMax != 4 || first.CPUP95 != 4 {
+		t.Errorf("first 5m bucket = %+v, expected samples 5, avg 2, min 0, max 4, p95 4", first)
+	}
+	if first.IOReadBytes != 400 {
+		t.Errorf("first 5m bucket IOReadBytes = %d, expected the last counter value 400", first.IOReadBytes)
+	}
+
+	hourly, err := manager.userRollupHistory(ResolutionHourly, 1000, from, to, 1000)
+	if err != nil || len(hourly) != 2 {
+		t.Fatalf("1h rollups = %d, %v; expected 2", len(hourly), err)
+	}
+	if h := hourly[1]; h.CPUUsagePercent != 29.5 || h.CPUP95 != 59 || h.LimitedPercent != 0 {
+		t.Errorf("first hour = avg %v p95 %v limited %v, expected 29.5/59/0", h.CPUUsagePercent, h.CPUP95, h.LimitedPercent)
+	}
+	if h := hourly[0]; h.LimitedPercent != 100 {
+		t.Errorf("second hour limited = %v%%, expected 100%%", h.LimitedPercent)
+	}
+
+	daily, err := manager.userRollupHistory(ResolutionDaily, 1000, from, to, 1000)
+	if err != nil || len(daily) != 1 {
+		t.Fatalf("1d rollups = %d, %v; expected 1", len(daily), err)
+	}
+	if d := daily[0]; d.Samples != 120 || d.CPUUsagePercent != 59.5 || d.CPUMax != 119 || d.LimitedPercent != 50 {
+		t.Errorf("daily rollup = %+v, expected 120 samples, avg 59.5, max 119, 50%% limited", d)
+	}
+
+	system, err := manager.systemRollupHistory(ResolutionDaily, from, to, 10)
+	if err != nil || len(system) != 1 || system[0].Samples != 120 || system[0].TotalCores != 4 {
+		t.Errorf("daily system rollup = %+v, %v", system, err)
+	}
+
+	// Scelta automatica della risoluzione in base all'intervallo
+	records, err := manager.GetUserHistory(1000, base, base.Add(2*time.Hour), 1000)
+	if err != nil || len(records) != 120 || records[0].Resolution != ResolutionRaw {
+		t.Errorf("2h history = %d records, %v; expected 120 raw samples", len(records), err)
+	}
+	records, err = manager.GetUserHistory(1000, base.Add(-24*time.Hour), base.Add(48*time.Hour), 1000)
+	if err != nil || len(records) != 24 || records[0].Resolution != ResolutionFiveMinute {
+		t.Errorf("3-day history = %d records, %v; expected 24 5m buckets", len(records), err)
+	}
+	records, err = manager.GetUserHistory(1000, base.Add(-20*24*time.Hour), now, 1000)
+	if err != nil || len(records) != 2 || records[0].Resolution != ResolutionHourly {
+		t.Errorf("20-day history = %d records, %v; expected 2 hourly buckets", len(records), err)
+	}
+
+	summary, err := manager.GetUserSummary(1000, base.Add(-24*time.Hour), base.Add(48*time.Hour))
+	if err != nil || summary == nil {
+		t.Fatalf("GetUserSummary() = %v, %v", summary, err)
+	}
+	if summary.Resolution != ResolutionFiveMinute || summary.Samples != 120 || summary.CPUMax != 119 || summary.LimitedTimePercent != 50 {
+		t.Errorf("summary = %+v, expected 120 samples from 5m rollups, max 119, 50%% limited", summary)
+	}
+
+	// Oltre la retention dei 5 minuti lo storico passa alle ore
+	manager.SetRetentionPolicy(RetentionPolicy{FiveMinuteDays: 1})
+	if err := manager.Rollup(now); err != nil {
+		t.Fatalf("Rollup() error: %v", err)
+	}
+	if fiveMin, _ := manager.userRollupHistory(ResolutionFiveMinute, 1000, from, to, 1000); len(fiveMin) != 0 {
+		t.Errorf("5m rollups after retention = %d, expected 0", len(fiveMin))
+	}
+	records, err = manager.GetUserHistory(1000, base.Add(-24*time.Hour), base.Add(48*time.Hour), 1000)
+	if err != nil || len(records) != 2 || records[0].Resolution != ResolutionHourly {
+		t.Errorf("3-day history past 5m retention = %d records, %v; expected 2 hourly buckets", len(records), err)
+	}
+}
+
+func TestPercentile95(t *testing.T) {
+	values := make([]float64, 0, 100)
+	for i := 100; i >= 1; i-- {
+		values = append(values, float64(i))
+	}
+	if p := percentile95(values); p != 95 {
+		t.Errorf("percentile95(1..100) = %v, expected 95", p)
+	}
+	if p := percentile95([]float64{7}); p != 7 {
+		t.Errorf("percentile95([7]) = %v, expected 7", p)
+	}
+}
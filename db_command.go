@@ -0,0 +1,127 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+// db_command.go
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/fdefilippo/resman/config"
+	"github.com/fdefilippo/resman/database"
+)
+
+// Codici di uscita di "resman db".
+const (
+	dbExitOK      = 0
+	dbExitPending = 1 // migrate --check: ci sono migrazioni da applicare
+	dbExitError   = 2
+)
+
+const dbUsage = `Usage: resman db migrate [--config FILE] [--check]
+
+  migrate          apply pending schema migrations to METRICS_DB_PATH
+  migrate --check  report the schema version and pending migrations without
+                   changing the database (exit status 1 if any are pending)
+`
+
+// runDBCommand esegue "resman db <subcommand>" e restituisce il codice di uscita.
+func runDBCommand(args []string) int {
+	if len(args) == 0 || args[0] != "migrate" {
+		fmt.Fprint(os.Stderr, dbUsage)
+		return dbExitError
+	}
+	return runDBMigrate(args[1:], os.Stdout)
+}
+
+func runDBMigrate(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("resman db migrate", flag.ContinueOnError)
+	configPath := flags.String("config", "/etc/resman.conf", "Path to configuration file")
+	check := flags.Bool("check", false, "Only report pending migrations")
+	if err := flags.Parse(args); err != nil {
+		return dbExitError
+	}
+
+	cfg, err := config.LoadAndValidate(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration from %s: %v\n", *configPath, err)
+		return dbExitError
+	}
+	dbPath := cfg.MetricsDBPath
+
+	if *check {
+		// Con --check il database non va creato se manca
+		if _, err := os.Stat(dbPath); errors.Is(err, os.ErrNotExist) {
+			fmt.Fprintf(out, "Database %s does not exist; it will be created at schema version %d\n",
+				dbPath, database.LatestSchemaVersion())
+			return dbExitPending
+		}
+	}
+
+	dbManager, err := database.OpenDatabaseManager(dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return dbExitError
+	}
+	defer dbManager.Close()
+
+	status, err := dbManager.MigrationStatus()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read schema version of %s: %v\n", dbPath, err)
+		return dbExitError
+	}
+	fmt.Fprintf(out, "Database %s: schema version %d, latest %d\n", dbPath, status.CurrentVersion, status.LatestVersion)
+	if status.CurrentVersion > status.LatestVersion {
+		fmt.Fprintf(os.Stderr, "Schema version %d is newer than this resman supports\n", status.CurrentVersion)
+		return dbExitError
+	}
+	if len(status.Pending) == 0 {
+		fmt.Fprintln(out, "Schema is up to date")
+		return dbExitOK
+	}
+
+	if *check {
+		for _, pending := range status.Pending {
+			fmt.Fprintf(out, "  pending %d: %s%s\n", pending.Version, pending.Description, destructiveNote(pending))
+		}
+		return dbExitPending
+	}
+
+	applied, backupPath, err := dbManager.Migrate()
+	if backupPath != "" {
+		fmt.Fprintf(out, "Backup written to %s\n", backupPath)
+	}
+	for _, migration := range applied {
+		fmt.Fprintf(out, "  applied %d: %s\n", migration.Version, migration.Description)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Migration failed: %v\n", err)
+		return dbExitError
+	}
+	fmt.Fprintf(out, "Schema migrated to version %d\n", status.LatestVersion)
+	return dbExitOK
+}
+
+func destructiveNote(migration database.MigrationInfo) string {
+	if migration.Destructive {
+		return " (destructive, a backup is made first)"
+	}
+	return ""
+}
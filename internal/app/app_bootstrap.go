@@ -71,7 +71,10 @@ func (a *App) WithDatabase() *App {
 		return a
 	}
 
-	dbManager, err := database.NewDatabaseManager(a.cfg.MetricsDBPath)
+	dbManager, err := database.OpenDatabaseManager(a.cfg.MetricsDBPath)
+	if err == nil {
+		err = a.migrateDatabase(dbManager)
+	}
 	if err != nil {
 		a.logger.Warn("Failed to initialize metrics database, disabling database writing",
 			"path", a.cfg.MetricsDBPath,
@@ -90,7 +93,7 @@ func (a *App) WithDatabase() *App {
 		return a
 	}
 
-	dbWriter := metrics.NewDBWriter(dbManager, a.cfg.MetricsDBWriteInterval)
+	dbWriter := metrics.NewDBWriter(dbManager, a.cfg.MetricsDBWriteInterval, a.cfg.MetricsDBQueueSize)
 	a.metricsCollector.SetDBWriter(dbWriter)
 	a.dbManager = dbManager
 
@@ -105,13 +108,43 @@ func (a *App) WithDatabase() *App {
 		"ttl_minutes", a.cfg.UsernameCacheTTL,
 	)
 
-	if deleted, err := dbManager.CleanupOldData(a.cfg.MetricsDBRetentionDays); err == nil && deleted > 0 {
+	dbManager.SetRetentionPolicy(database.RetentionPolicy{
+		RawDays:        a.cfg.MetricsDBRetentionDays,
+		FiveMinuteDays: a.cfg.MetricsDB5mRetentionDays,
+		HourlyDays:     a.cfg.MetricsDB1hRetentionDays,
+		DailyDays:      a.cfg.MetricsDB1dRetentionDays,
+	})
+	// Oltre tre intervalli di scrittura senza campioni l'utente non viene
+	// addebitato per CPU (da /proc) e memoria
+	dbManager.SetAccountingMaxGap(3 * time.Duration(a.cfg.MetricsDBWriteInterval) * time.Second)
+	if deleted, err := dbManager.CleanupOldData(a.cfg.MetricsDBRetentionDays); err != nil {
+		a.logger.Warn("Failed to roll up or clean up old metrics data", "error", err)
+	} else if deleted > 0 {
 		a.logger.Info("Cleaned up old metrics data", "records_deleted", deleted)
 	}
 
 	return a
 }
 
+// migrateDatabase porta lo schema del database metriche all'ultima versione.
+func (a *App) migrateDatabase(dbManager *database.DatabaseManager) error {
+	applied, backupPath, err := dbManager.Migrate()
+	for _, migration := range applied {
+		a.logger.Info("Metrics database migration applied",
+			"version", migration.Version,
+			"description", migration.Description,
+		)
+	}
+	if backupPath != "" {
+		a.logger.Info("Metrics database backed up before migration", "backup", backupPath)
+	}
+	if err != nil {
+		dbManager.Close()
+		return fmt.Errorf("schema migration failed: %w", err)
+	}
+	return nil
+}
+
 // WithPrometheus inizializza l'exporter Prometheus se abilitato.
 func (a *App) WithPrometheus() *App {
 	if a.err != nil {
@@ -158,6 +191,9 @@ func (a *App) WithPrometheus() *App {
 	}
 
 	a.prometheusExporter = prometheusExporter
+	if writer := a.metricsCollector.GetDBWriter(); writer != nil {
+		writer.SetObserver(prometheusExporter)
+	}
 	a.logger.Info("Prometheus exporter started",
 		"host", a.cfg.PrometheusMetricsBindHost,
 		"port", a.cfg.PrometheusMetricsBindPort,
@@ -178,6 +214,19 @@ func (a *App) WithStateManager() *App {
 		a.err = err
 		return a
 	}
+	// Le statistiche dei pattern e lo storico delle policy sopravvivono ai
+	// riavvii nel database metriche
+	if a.dbManager != nil {
+		stateManager.SetPatternStore(a.dbManager)
+		stateManager.SetPolicyAuditStore(a.dbManager)
+		stateManager.SetLimitEventStore(a.dbManager)
+	}
+	// I campioni di memory.events passano dalla coda del writer, come le metriche
+	if writer := a.metricsCollector.GetDBWriter(); writer != nil {
+		stateManager.SetMemoryEventsStore(writer)
+	}
+	// Con STATE_PERSIST_ENABLED riprende i limiti lasciati dall'istanza precedente
+	stateManager.RestoreState()
 	a.stateManager = stateManager
 	return a
 }
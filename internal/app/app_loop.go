@@ -15,6 +15,8 @@ func (a *App) Run() error {
 
 	a.startSignalHandler()
 	a.startPSIWatcher()
+	a.stateManager.StartProcessWatcher(a.ctx)
+	a.stateManager.StartPolicyScheduler(a.ctx)
 	return a.runControlLoop()
 }
 func (a *App) runControlLoop() error {
@@ -62,6 +62,13 @@ func (a *App) shutdown() {
 		}
 	}
 
+	// Il writer scrive gli ultimi record in coda: va chiuso prima del database
+	if a.metricsCollector != nil && a.metricsCollector.GetDBWriter() != nil {
+		if err := a.metricsCollector.GetDBWriter().Close(); err != nil {
+			a.logger.Error("Error closing metrics database writer", "error", err)
+		}
+	}
+
 	if a.dbManager != nil {
 		if err := a.dbManager.Close(); err != nil {
 			a.logger.Error("Error closing database manager", "error", err)
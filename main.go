@@ -33,6 +33,14 @@ import (
 var version = "1.24.0"
 
 func main() {
+	// Sottocomandi di manutenzione (resman db ...) e report dei consumi
+	if len(os.Args) > 1 && os.Args[1] == "db" {
+		os.Exit(runDBCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "report" {
+		os.Exit(runReportCommand(os.Args[2:]))
+	}
+
 	// Parsing dei flag
 	configPath := flag.String("config", "/etc/resman.conf", "Path to configuration file")
 	showVersion := flag.Bool("version", false, "Show version and exit")
@@ -155,6 +155,8 @@ func (s *Server) handleConfigResource(ctx context.Context, req *mcp.ReadResource
 		"cpu_threshold_duration": cfg.CPUThresholdDuration,
 		"polling_interval":       cfg.PollingInterval,
 		"min_system_cores":       cfg.MinSystemCores,
+		"cpuset_isolation":       cfg.CPUSetIsolation,
+		"cpuset_reserved_cpus":   cfg.CPUSetReservedCPUs,
 		"cpu_quota_normal":       cfg.CPUQuotaNormal,
 		"cpu_quota_limited":      cfg.CPUQuotaLimited,
 		"enable_prometheus":      cfg.EnablePrometheus,
@@ -169,6 +171,9 @@ func (s *Server) handleConfigResource(ctx context.Context, req *mcp.ReadResource
 		"ram_quota_limited":     cfg.RAMQuotaLimited,
 		"ram_quota_per_user":    cfg.RAMQuotaPerUser,
 		"disable_swap":          cfg.DisableSwap,
+		"ram_swap_max":          cfg.RAMSwapMax,
+		"ram_swap_high":         cfg.RAMSwapHigh,
+		"ram_zswap_max":         cfg.RAMZswapMax,
 		"ram_high_ratio":        cfg.RAMHighRatio,
 		// IO limits
 		"io_enabled":            cfg.IOEnabled,
@@ -180,6 +185,9 @@ func (s *Server) handleConfigResource(ctx context.Context, req *mcp.ReadResource
 		"io_read_iops":          cfg.IOReadIOPS,
 		"io_write_iops":         cfg.IOWriteIOPS,
 		"io_device_filter":      cfg.IODeviceFilter,
+		"io_device_discovery":   cfg.GetIODeviceDiscovery(),
+		"io_device_limits":      cfg.GetIODeviceLimits(),
+		"io_limit_mode":         cfg.GetIOLimitMode(),
 	}
 
 	return &mcp.ReadResourceResult{
@@ -223,6 +231,9 @@ func (s *Server) handleUserMetricsResource(ctx context.Context, req *mcp.ReadRes
 	if highEvents, err := s.cgroupManager.GetMemoryHighEvents(uid); err == nil {
 		result["memory_high_events"] = highEvents
 	}
+	if swapUsage, err := s.cgroupManager.GetSwapUsage(uid); err == nil {
+		result["swap_usage_bytes"] = swapUsage
+	}
 
 	// Add IO cgroup metrics
 	if ioRead, ioWrite, ioROps, ioWOps, err := s.cgroupManager.GetIOStats(uid); err == nil {
@@ -23,12 +23,15 @@ import (
 	"fmt"
 	"os"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/modelcontextprotocol/go-sdk/mcp"
 
 	"github.com/fdefilippo/resman/database"
+	"github.com/fdefilippo/resman/state"
 )
 
 // getHostname returns the current hostname
@@ -72,6 +75,7 @@ type UserMetric struct {
 	MemoryMaxBytes   uint64 `json:"memory_max_bytes,omitempty"`
 	MemoryHighBytes  uint64 `json:"memory_high_bytes,omitempty"`
 	MemoryHighEvents uint64 `json:"memory_high_events,omitempty"`
+	SwapUsageBytes   uint64 `json:"swap_usage_bytes,omitempty"`
 	// IO cgroup metrics
 	IOReadBytes  uint64 `json:"io_read_bytes,omitempty"`
 	IOWriteBytes uint64 `json:"io_write_bytes,omitempty"`
@@ -94,16 +98,37 @@ type GetLimitsStatusResult struct {
 	SharedCgroupActive    bool   `json:"shared_cgroup_active"`
 	SharedCgroupQuota     string `json:"shared_cgroup_quota"`
 	SharedCgroupUserCount int    `json:"shared_cgroup_user_count"`
+	// Fair-share CPU weights
+	FairShareEnabled bool           `json:"fair_share_enabled"`
+	UserCPUWeights   map[string]int `json:"user_cpu_weights"`
+	// Named user groups (limited users per group)
+	UserGroups map[string]int `json:"user_groups"`
+	// Independent per-resource state machines (cpu, ram, io)
+	CPULimitsActive bool                          `json:"cpu_limits_active"`
+	ResourceLimits  map[string]ResourceLimitState `json:"resource_limits"`
 	// RAM limits status
-	RAMLimitsActive bool   `json:"ram_limits_active"`
-	RAMQuotaPerUser string `json:"ram_quota_per_user"`
-	RAMHighRatio    string `json:"ram_high_ratio"`
+	RAMLimitsActive  bool   `json:"ram_limits_active"`
+	RAMLimitsEnabled bool   `json:"ram_limits_enabled"`
+	RAMQuotaPerUser  string `json:"ram_quota_per_user"`
+	RAMHighRatio     string `json:"ram_high_ratio"`
 	// IO limits status
-	IOLimitsActive bool   `json:"io_limits_active"`
-	IOReadBPS      string `json:"io_read_bps"`
-	IOWriteBPS     string `json:"io_write_bps"`
-	IOReadIOPS     int    `json:"io_read_iops"`
-	IOWriteIOPS    int    `json:"io_write_iops"`
+	IOLimitsActive  bool   `json:"io_limits_active"`
+	IOLimitsEnabled bool   `json:"io_limits_enabled"`
+	IOReadBPS       string `json:"io_read_bps"`
+	IOWriteBPS      string `json:"io_write_bps"`
+	IOReadIOPS      int    `json:"io_read_iops"`
+	IOWriteIOPS     int    `json:"io_write_iops"`
+}
+
+// ResourceLimitState is the activation state of a single resource (cpu, ram, io)
+type ResourceLimitState struct {
+	Active       bool    `json:"active"`
+	Enabled      bool    `json:"enabled"`
+	AppliedTime  string  `json:"applied_time"`
+	UsagePercent float64 `json:"usage_percent"`
+	StableCycles int     `json:"stable_cycles"`
+	LastDecision string  `json:"last_decision"`
+	LastReason   string  `json:"last_reason"`
 }
 
 type GetCgroupInfoArgs struct {
@@ -139,6 +164,8 @@ type GetHistoryResult struct {
 	Count     int              `json:"count"`
 	StartTime string           `json:"start_time"`
 	EndTime   string           `json:"end_time"`
+	// raw, 5m, 1h or 1d: chosen from the requested range
+	Resolution string `json:"resolution,omitempty"`
 }
 
 type GetUserSummaryResult struct {
@@ -155,6 +182,44 @@ type GetUserSummaryResult struct {
 	ProcessCountAvg    float64 `json:"process_count_avg"`
 	LimitedTimePercent float64 `json:"limited_time_percent"`
 	Samples            int     `json:"samples"`
+	Resolution         string  `json:"resolution"`
+}
+
+type GetLimitEventsArgs struct {
+	UID       *int   `json:"uid,omitempty"`
+	Username  string `json:"username,omitempty"`
+	EventType string `json:"event_type,omitempty"`
+	StartTime string `json:"startTime,omitempty"`
+	EndTime   string `json:"endTime,omitempty"`
+	Period    string `json:"period,omitempty"`
+	Hours     int    `json:"hours,omitempty"`
+	Limit     int    `json:"limit,omitempty"`
+}
+
+type GetLimitEventsResult struct {
+	Events    []database.LimitEventRecord  `json:"events"`
+	Count     int                          `json:"count"`
+	Summary   []database.LimitEventSummary `json:"summary"`
+	StartTime string                       `json:"start_time"`
+	EndTime   string                       `json:"end_time"`
+}
+
+type GetUsageReportArgs struct {
+	UID       *int   `json:"uid,omitempty"`
+	Username  string `json:"username,omitempty"`
+	Group     string `json:"group,omitempty"`
+	GroupBy   string `json:"group_by,omitempty"`
+	StartTime string `json:"startTime,omitempty"`
+	EndTime   string `json:"endTime,omitempty"`
+	Period    string `json:"period,omitempty"`
+}
+
+type GetUsageReportResult struct {
+	Records []database.AccountingRecord `json:"records"`
+	Count   int                         `json:"count"`
+	GroupBy string                      `json:"group_by"`
+	From    string                      `json:"from"`
+	To      string                      `json:"to"`
 }
 
 type GetMetricsDatabaseInfoResult struct {
@@ -197,12 +262,94 @@ type ControlHistoryEntry struct {
 	ActiveUsers   int     `json:"active_users"`
 	LimitsActive  bool    `json:"limits_active"`
 	DurationMs    int64   `json:"duration_ms"`
+	// Limited users and why they were selected (LIMIT_TARGET_MODE)
+	TargetedUsers []state.TargetedUser `json:"targeted_users,omitempty"`
+	// Cgroup writes planned but not executed (DRY_RUN)
+	DryRun        bool                 `json:"dry_run,omitempty"`
+	PlannedWrites []state.PlannedWrite `json:"planned_writes,omitempty"`
+	// Adaptive IO remediation steps taken in the cycle
+	IOBoostSteps []state.IOBoostStep `json:"io_boost_steps,omitempty"`
 }
 
 type GetControlHistoryResult struct {
 	Entries []ControlHistoryEntry `json:"entries"`
 }
 
+type GetDryRunWritesArgs struct {
+	Limit int `json:"limit"`
+	UID   int `json:"uid"`
+}
+
+type GetDryRunWritesResult struct {
+	DryRun bool                 `json:"dry_run"`
+	Count  int                  `json:"count"`
+	Writes []state.PlannedWrite `json:"writes"`
+}
+
+type GetPolicyScheduleArgs struct {
+	Hours int `json:"hours"`
+	UID   int `json:"uid"`
+}
+
+// PatternPolicy is the workload pattern policy currently in force for a user
+type PatternPolicy struct {
+	UID         int     `json:"uid"`
+	Username    string  `json:"username"`
+	Pattern     string  `json:"pattern,omitempty"`
+	Window      string  `json:"window,omitempty"`
+	Source      string  `json:"source"`
+	Confidence  float64 `json:"confidence,omitempty"`
+	Reason      string  `json:"reason,omitempty"`
+	CPUQuota    int     `json:"cpu_quota,omitempty"`
+	RAMQuota    string  `json:"ram_quota,omitempty"`
+	LastChanged string  `json:"last_changed"`
+}
+
+type GetPolicyScheduleResult struct {
+	Enabled     bool                     `json:"enabled"`
+	Hours       int                      `json:"hours"`
+	Current     []PatternPolicy          `json:"current"`
+	Transitions []state.PolicyTransition `json:"transitions"`
+}
+
+type GetPolicyAuditArgs struct {
+	UID   int `json:"uid"`
+	Limit int `json:"limit"`
+}
+
+type GetPolicyAuditResult struct {
+	Count   int                          `json:"count"`
+	Records []database.PolicyAuditRecord `json:"records"`
+}
+
+type PinPatternPolicyArgs struct {
+	UID     int    `json:"uid"`
+	Pattern string `json:"pattern,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+type OverridePatternPolicyArgs struct {
+	UID      int    `json:"uid"`
+	CPUQuota int    `json:"cpu_quota,omitempty"`
+	RAMQuota string `json:"ram_quota,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+type RevertPatternPolicyArgs struct {
+	UID    int    `json:"uid"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type ResumePatternPolicyArgs struct {
+	UID    int    `json:"uid"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type PatternPolicyActionResult struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
 type ActivateLimitsArgs struct {
 	Force bool `json:"force"`
 }
@@ -299,7 +446,7 @@ func (s *Server) registerTools() {
 	// get_limits_status - registered manually with explicit empty schema
 	s.mcpServer.AddTool(&mcp.Tool{
 		Name:        "get_limits_status",
-		Description: "Check if CPU limits are currently active and get details",
+		Description: "Check if CPU, RAM and IO limits are currently active and get details",
 		InputSchema: map[string]any{
 			"type":       "object",
 			"properties": map[string]any{},
@@ -320,6 +467,13 @@ func (s *Server) registerTools() {
 			"shared_cgroup_active":     getBool(status, "shared_cgroup_active", false),
 			"shared_cgroup_quota":      getString(status, "shared_cgroup_quota", ""),
 			"shared_cgroup_user_count": getInt(status, "shared_cgroup_user_count", 0),
+			"fair_share_enabled":       getBool(status, "fair_share_enabled", false),
+			"user_cpu_weights":         getUIDIntMap(status, "user_cpu_weights"),
+			"user_groups":              getStringIntMap(status, "user_groups"),
+			"cpu_limits_active":        getBool(status, "cpu_limits_active", false),
+			"ram_limits_active":        getBool(status, "ram_limits_active", false),
+			"io_limits_active":         getBool(status, "io_limits_active", false),
+			"resource_limits":          getResourceLimits(status, "resource_limits"),
 		}
 
 		return &mcp.CallToolResult{
@@ -618,6 +772,21 @@ Utenti limitati: %d su %d
 		Description: "Get recent control cycle history",
 	}, s.handleGetControlHistory)
 
+	mcp.AddTool(s.mcpServer, &mcp.Tool{
+		Name:        "get_dry_run_writes",
+		Description: "Get the cgroup writes planned but not executed in dry-run mode (DRY_RUN=true), optionally filtered by uid",
+	}, s.handleGetDryRunWrites)
+
+	mcp.AddTool(s.mcpServer, &mcp.Tool{
+		Name:        "get_policy_schedule",
+		Description: "List the workload pattern policies per user (detected, pinned, overridden or reverted) and the upcoming time-of-day quota transitions (default next 24 hours), optionally filtered by uid",
+	}, s.handleGetPolicySchedule)
+
+	mcp.AddTool(s.mcpServer, &mcp.Tool{
+		Name:        "get_policy_audit",
+		Description: "Get the audit trail of workload pattern policies (applied, rolled back, pinned, overridden, reverted, resumed) from the metrics database, most recent first, optionally filtered by uid",
+	}, s.handleGetPolicyAudit)
+
 	// Write operation tools (only if allowed)
 	if s.cfg.AllowWriteOps {
 		mcp.AddTool(s.mcpServer, &mcp.Tool{
@@ -655,6 +824,26 @@ Utenti limitati: %d su %d
 				},
 			}, nil
 		})
+
+		mcp.AddTool(s.mcpServer, &mcp.Tool{
+			Name:        "pin_pattern_policy",
+			Description: "Pin a user's workload pattern (batch_night, interactive_day, mixed, always_on, sporadic; empty pins the current one) so automatic reclassification no longer changes it",
+		}, s.handlePinPatternPolicy)
+
+		mcp.AddTool(s.mcpServer, &mcp.Tool{
+			Name:        "override_pattern_policy",
+			Description: "Override a user's pattern policy with a fixed CPU quota (microseconds per 100000) and/or RAM quota, whatever the pattern and time of day",
+		}, s.handleOverridePatternPolicy)
+
+		mcp.AddTool(s.mcpServer, &mcp.Tool{
+			Name:        "revert_pattern_policy",
+			Description: "Revert a user's pattern policy: restore the cgroup values in place before it and suspend automatic policies for the user until resumed",
+		}, s.handleRevertPatternPolicy)
+
+		mcp.AddTool(s.mcpServer, &mcp.Tool{
+			Name:        "resume_pattern_policy",
+			Description: "Remove a pin, override or revert so the user's policy follows the detected workload pattern again",
+		}, s.handleResumePatternPolicy)
 	}
 
 	// set_user_exclude_list - registered manually with explicit schema
@@ -969,13 +1158,13 @@ Utenti limitati: %d su %d
 	// get_user_history - Get historical metrics for a specific user
 	mcp.AddTool(s.mcpServer, &mcp.Tool{
 		Name:        "get_user_history",
-		Description: "Get historical CPU and memory metrics for a specific user. Supports time ranges via startTime/endTime, period (today, yesterday, last_24_hours, last_7_days, last_30_days), or hours parameter",
+		Description: "Get historical CPU and memory metrics for a specific user. Supports time ranges via startTime/endTime, period (today, yesterday, last_24_hours, last_7_days, last_30_days), or hours parameter. Ranges up to 24h return raw samples; longer ranges return 5m, 1h or 1d buckets with min/max/p95 (see resolution)",
 	}, s.handleGetUserHistory)
 
 	// get_system_history - Get historical system metrics
 	mcp.AddTool(s.mcpServer, &mcp.Tool{
 		Name:        "get_system_history",
-		Description: "Get historical system-wide CPU and memory metrics. Supports time ranges via startTime/endTime, period (today, yesterday, last_24_hours, last_7_days, last_30_days), or hours parameter",
+		Description: "Get historical system-wide CPU and memory metrics. Supports time ranges via startTime/endTime, period (today, yesterday, last_24_hours, last_7_days, last_30_days), or hours parameter. Ranges up to 24h return raw samples; longer ranges return 5m, 1h or 1d buckets (see resolution)",
 	}, s.handleGetSystemHistory)
 
 	// get_user_summary - Get aggregated statistics for a user
@@ -984,6 +1173,18 @@ Utenti limitati: %d su %d
 		Description: "Get aggregated statistics (avg, min, max) for a specific user over a time period",
 	}, s.handleGetUserSummary)
 
+	// get_limit_events - Get the journal of limit episodes
+	mcp.AddTool(s.mcpServer, &mcp.Tool{
+		Name:        "get_limit_events",
+		Description: "Get the journal of limit episodes (event_type limit, psi_boost, io_boost or policy) with start/end reason, thresholds, metrics snapshot and duration, plus a per-user summary of how many times and for how long each user was throttled. Filter by uid or username and time range (period, startTime/endTime or hours; default last_7_days)",
+	}, s.handleGetLimitEvents)
+
+	// get_usage_report - Get per-user usage accounting for chargeback
+	mcp.AddTool(s.mcpServer, &mcp.Tool{
+		Name:        "get_usage_report",
+		Description: "Get usage accounting for chargeback: CPU-hours, memory GB-hours and IO bytes integrated per user and day from cgroup counters or samples, with gap and counter reset totals. group_by: user (default), day or group (GROUP_<name> from the configuration); filter by uid, username or group and by period (default this_month) or startTime/endTime days",
+	}, s.handleGetUsageReport)
+
 	// get_metrics_database_info - Get information about the metrics database
 	mcp.AddTool(s.mcpServer, &mcp.Tool{
 		Name:        "get_metrics_database_info",
@@ -1054,6 +1255,9 @@ func (s *Server) handleGetUserMetrics(ctx context.Context, req *mcp.CallToolRequ
 		if highEvents, err := s.cgroupManager.GetMemoryHighEvents(uid); err == nil {
 			um.MemoryHighEvents = highEvents
 		}
+		if swapUsage, err := s.cgroupManager.GetSwapUsage(uid); err == nil {
+			um.SwapUsageBytes = swapUsage
+		}
 
 		// Fetch IO cgroup metrics
 		if ioRead, ioWrite, ioROps, ioWOps, err := s.cgroupManager.GetIOStats(uid); err == nil {
@@ -1083,16 +1287,26 @@ func (s *Server) handleGetLimitsStatus(ctx context.Context, req *mcp.CallToolReq
 		SharedCgroupActive:    getBool(status, "shared_cgroup_active", false),
 		SharedCgroupQuota:     getString(status, "shared_cgroup_quota", ""),
 		SharedCgroupUserCount: getInt(status, "shared_cgroup_user_count", 0),
+		// Fair-share CPU weights
+		FairShareEnabled: getBool(status, "fair_share_enabled", false),
+		UserCPUWeights:   getUIDIntMap(status, "user_cpu_weights"),
+		// Named user groups
+		UserGroups: getStringIntMap(status, "user_groups"),
+		// Independent per-resource state machines
+		CPULimitsActive: getBool(status, "cpu_limits_active", false),
+		ResourceLimits:  getResourceLimits(status, "resource_limits"),
 		// RAM limits status
-		RAMLimitsActive: cfg.RAMEnabled,
-		RAMQuotaPerUser: cfg.RAMQuotaPerUser,
-		RAMHighRatio:    fmt.Sprintf("%.1f", cfg.RAMHighRatio),
+		RAMLimitsActive:  getBool(status, "ram_limits_active", false),
+		RAMLimitsEnabled: cfg.RAMEnabled,
+		RAMQuotaPerUser:  cfg.RAMQuotaPerUser,
+		RAMHighRatio:     fmt.Sprintf("%.1f", cfg.RAMHighRatio),
 		// IO limits status
-		IOLimitsActive: cfg.IOEnabled,
-		IOReadBPS:      cfg.IOReadBPS,
-		IOWriteBPS:     cfg.IOWriteBPS,
-		IOReadIOPS:     cfg.IOReadIOPS,
-		IOWriteIOPS:    cfg.IOWriteIOPS,
+		IOLimitsActive:  getBool(status, "io_limits_active", false),
+		IOLimitsEnabled: cfg.IOEnabled,
+		IOReadBPS:       cfg.IOReadBPS,
+		IOWriteBPS:      cfg.IOWriteBPS,
+		IOReadIOPS:      cfg.IOReadIOPS,
+		IOWriteIOPS:     cfg.IOWriteIOPS,
 	}
 
 	return &mcp.CallToolResult{}, result, nil
@@ -1191,12 +1405,190 @@ func (s *Server) handleGetControlHistory(ctx context.Context, req *mcp.CallToolR
 			ActiveUsers:   entry.ActiveUsers,
 			LimitsActive:  entry.LimitsActive,
 			DurationMs:    entry.DurationMs,
+			TargetedUsers: entry.TargetedUsers,
+			DryRun:        entry.DryRun,
+			PlannedWrites: entry.PlannedWrites,
+			IOBoostSteps:  entry.IOBoostSteps,
 		})
 	}
 
 	return &mcp.CallToolResult{}, result, nil
 }
 
+// handleGetDryRunWrites handles get_dry_run_writes tool requests
+func (s *Server) handleGetDryRunWrites(ctx context.Context, req *mcp.CallToolRequest, args GetDryRunWritesArgs) (*mcp.CallToolResult, GetDryRunWritesResult, error) {
+	if args.Limit <= 0 {
+		args.Limit = 100
+	}
+
+	// Il filtro per uid si applica prima del limite
+	limit := args.Limit
+	if args.UID > 0 {
+		limit = 0
+	}
+
+	result := GetDryRunWritesResult{
+		DryRun: s.stateManager.IsDryRun(),
+		Writes: make([]state.PlannedWrite, 0),
+	}
+	for _, write := range s.stateManager.GetPlannedWrites(limit) {
+		if args.UID > 0 && write.UID != args.UID {
+			continue
+		}
+		result.Writes = append(result.Writes, write)
+	}
+	if len(result.Writes) > args.Limit {
+		result.Writes = result.Writes[len(result.Writes)-args.Limit:]
+	}
+	result.Count = len(result.Writes)
+
+	return &mcp.CallToolResult{}, result, nil
+}
+
+// handleGetPolicySchedule handles get_policy_schedule tool requests
+func (s *Server) handleGetPolicySchedule(ctx context.Context, req *mcp.CallToolRequest, args GetPolicyScheduleArgs) (*mcp.CallToolResult, GetPolicyScheduleResult, error) {
+	if args.Hours <= 0 {
+		args.Hours = 24
+	}
+	if args.Hours > 168 {
+		args.Hours = 168
+	}
+
+	result := GetPolicyScheduleResult{
+		Enabled:     s.stateManager.GetConfig().GetAutodetectPatterns(),
+		Hours:       args.Hours,
+		Current:     make([]PatternPolicy, 0),
+		Transitions: make([]state.PolicyTransition, 0),
+	}
+
+	policies := s.stateManager.GetPatternPolicies()
+	uids := make([]int, 0, len(policies))
+	for uid := range policies {
+		if args.UID > 0 && uid != args.UID {
+			continue
+		}
+		uids = append(uids, uid)
+	}
+	sort.Ints(uids)
+	for _, uid := range uids {
+		policy := policies[uid]
+		result.Current = append(result.Current, PatternPolicy{
+			UID:         uid,
+			Username:    s.metricsCollector.GetUsernameFromUID(uid),
+			Pattern:     string(policy.Pattern),
+			Window:      policy.Window,
+			Source:      policy.Source,
+			Confidence:  policy.Confidence,
+			Reason:      policy.Reason,
+			CPUQuota:    policy.CPUQuota,
+			RAMQuota:    policy.RAMQuota,
+			LastChanged: policy.LastChanged.Format("2006-01-02T15:04:05Z07:00"),
+		})
+	}
+
+	for _, transition := range s.stateManager.GetUpcomingPolicyTransitions(time.Duration(args.Hours) * time.Hour) {
+		if args.UID > 0 && transition.UID != args.UID {
+			continue
+		}
+		result.Transitions = append(result.Transitions, transition)
+	}
+
+	return &mcp.CallToolResult{}, result, nil
+}
+
+// handleGetPolicyAudit handles get_policy_audit tool requests
+func (s *Server) handleGetPolicyAudit(ctx context.Context, req *mcp.CallToolRequest, args GetPolicyAuditArgs) (*mcp.CallToolResult, GetPolicyAuditResult, error) {
+	if args.Limit <= 0 {
+		args.Limit = 50
+	}
+
+	records, err := s.stateManager.GetPolicyAudit(args.UID, args.Limit)
+	if err != nil {
+		return &mcp.CallToolResult{}, GetPolicyAuditResult{}, err
+	}
+	if records == nil {
+		records = make([]database.PolicyAuditRecord, 0)
+	}
+
+	return &mcp.CallToolResult{}, GetPolicyAuditResult{Count: len(records), Records: records}, nil
+}
+
+// patternPolicyReason restituisce il motivo registrato nello storico per
+// un'operazione manuale.
+func patternPolicyReason(reason string) string {
+	if reason == "" {
+		return "requested via MCP"
+	}
+	return reason
+}
+
+// handlePinPatternPolicy handles pin_pattern_policy tool requests
+func (s *Server) handlePinPatternPolicy(ctx context.Context, req *mcp.CallToolRequest, args PinPatternPolicyArgs) (*mcp.CallToolResult, PatternPolicyActionResult, error) {
+	if !s.cfg.AllowWriteOps {
+		return &mcp.CallToolResult{}, PatternPolicyActionResult{Success: false, Message: "write operations are not allowed"}, nil
+	}
+
+	pattern, err := s.stateManager.PinPatternPolicy(args.UID, state.WorkloadPattern(args.Pattern), patternPolicyReason(args.Reason))
+	if err != nil {
+		return &mcp.CallToolResult{}, PatternPolicyActionResult{Success: false, Message: "Failed to pin pattern policy: " + err.Error()}, nil
+	}
+
+	return &mcp.CallToolResult{}, PatternPolicyActionResult{
+		Success: true,
+		Message: fmt.Sprintf("Pattern %s pinned for UID %d", pattern, args.UID),
+	}, nil
+}
+
+// handleOverridePatternPolicy handles override_pattern_policy tool requests
+func (s *Server) handleOverridePatternPolicy(ctx context.Context, req *mcp.CallToolRequest, args OverridePatternPolicyArgs) (*mcp.CallToolResult, PatternPolicyActionResult, error) {
+	if !s.cfg.AllowWriteOps {
+		return &mcp.CallToolResult{}, PatternPolicyActionResult{Success: false, Message: "write operations are not allowed"}, nil
+	}
+
+	if err := s.stateManager.OverridePatternPolicy(args.UID, args.CPUQuota, args.RAMQuota, patternPolicyReason(args.Reason)); err != nil {
+		return &mcp.CallToolResult{}, PatternPolicyActionResult{Success: false, Message: "Failed to override pattern policy: " + err.Error()}, nil
+	}
+
+	return &mcp.CallToolResult{}, PatternPolicyActionResult{
+		Success: true,
+		Message: fmt.Sprintf("Pattern policy overridden for UID %d", args.UID),
+	}, nil
+}
+
+// handleRevertPatternPolicy handles revert_pattern_policy tool requests
+func (s *Server) handleRevertPatternPolicy(ctx context.Context, req *mcp.CallToolRequest, args RevertPatternPolicyArgs) (*mcp.CallToolResult, PatternPolicyActionResult, error) {
+	if !s.cfg.AllowWriteOps {
+		return &mcp.CallToolResult{}, PatternPolicyActionResult{Success: false, Message: "write operations are not allowed"}, nil
+	}
+
+	restored, err := s.stateManager.RevertPatternPolicy(args.UID, patternPolicyReason(args.Reason))
+	if err != nil {
+		return &mcp.CallToolResult{}, PatternPolicyActionResult{Success: false, Message: "Failed to revert pattern policy: " + err.Error()}, nil
+	}
+
+	message := fmt.Sprintf("Pattern policy reverted for UID %d, previous cgroup values restored", args.UID)
+	if !restored {
+		message = fmt.Sprintf("UID %d had no pattern policy applied; automatic policies suspended", args.UID)
+	}
+	return &mcp.CallToolResult{}, PatternPolicyActionResult{Success: true, Message: message}, nil
+}
+
+// handleResumePatternPolicy handles resume_pattern_policy tool requests
+func (s *Server) handleResumePatternPolicy(ctx context.Context, req *mcp.CallToolRequest, args ResumePatternPolicyArgs) (*mcp.CallToolResult, PatternPolicyActionResult, error) {
+	if !s.cfg.AllowWriteOps {
+		return &mcp.CallToolResult{}, PatternPolicyActionResult{Success: false, Message: "write operations are not allowed"}, nil
+	}
+
+	if err := s.stateManager.ResumePatternPolicy(args.UID, patternPolicyReason(args.Reason)); err != nil {
+		return &mcp.CallToolResult{}, PatternPolicyActionResult{Success: false, Message: "Failed to resume pattern policy: " + err.Error()}, nil
+	}
+
+	return &mcp.CallToolResult{}, PatternPolicyActionResult{
+		Success: true,
+		Message: fmt.Sprintf("UID %d follows the detected workload pattern again", args.UID),
+	}, nil
+}
+
 // handleActivateLimits handles activate_limits tool requests
 func (s *Server) handleActivateLimits(ctx context.Context, req *mcp.CallToolRequest, args ActivateLimitsArgs) (*mcp.CallToolResult, ActivateLimitsResult, error) {
 	if !s.cfg.AllowWriteOps {
@@ -1320,6 +1712,25 @@ func (s *Server) handleGetUserHistory(ctx context.Context, req *mcp.CallToolRequ
 			"cgroup_path":   r.CgroupPath,
 			"cpu_quota":     r.CPUQuota,
 			"is_limited":    r.IsLimited,
+			// Colonne aggiunte dalla migrazione 2 dello schema
+			"io_read_bytes":      r.IOReadBytes,
+			"io_write_bytes":     r.IOWriteBytes,
+			"io_pressure":        r.IOPressure,
+			"memory_pressure":    r.MemoryPressure,
+			"memory_high_events": r.MemoryHighEvents,
+			"memory_max_events":  r.MemoryMaxEvents,
+			"oom_kill_events":    r.OOMKillEvents,
+			"cpu_usage_ema":      r.CPUUsageEMA,
+		}
+		if r.Resolution != database.ResolutionRaw {
+			resultRecords[i]["samples"] = r.Samples
+			resultRecords[i]["cpu_min"] = r.CPUMin
+			resultRecords[i]["cpu_max"] = r.CPUMax
+			resultRecords[i]["cpu_p95"] = r.CPUP95
+			resultRecords[i]["memory_min"] = r.MemoryMin
+			resultRecords[i]["memory_max"] = r.MemoryMax
+			resultRecords[i]["memory_p95"] = r.MemoryP95
+			resultRecords[i]["limited_percent"] = r.LimitedPercent
 		}
 	}
 
@@ -1329,6 +1740,9 @@ func (s *Server) handleGetUserHistory(ctx context.Context, req *mcp.CallToolRequ
 		StartTime: startTime.Format(time.RFC3339),
 		EndTime:   endTime.Format(time.RFC3339),
 	}
+	if len(records) > 0 {
+		result.Resolution = records[0].Resolution
+	}
 
 	return &mcp.CallToolResult{
 		Content: []mcp.Content{
@@ -1391,6 +1805,15 @@ func (s *Server) handleGetSystemHistory(ctx context.Context, req *mcp.CallToolRe
 			"limits_active":   r.LimitsActive,
 			"limited_users":   r.LimitedUsersCount,
 		}
+		if r.Resolution != database.ResolutionRaw {
+			resultRecords[i]["samples"] = r.Samples
+			resultRecords[i]["cpu_min"] = r.CPUMin
+			resultRecords[i]["cpu_max"] = r.CPUMax
+			resultRecords[i]["cpu_p95"] = r.CPUP95
+			resultRecords[i]["system_load_max"] = r.SystemLoadMax
+			resultRecords[i]["limited_users_max"] = r.LimitedUsersMax
+			resultRecords[i]["limits_active_percent"] = r.LimitsActivePercent
+		}
 	}
 
 	result := GetHistoryResult{
@@ -1399,6 +1822,9 @@ func (s *Server) handleGetSystemHistory(ctx context.Context, req *mcp.CallToolRe
 		StartTime: startTime.Format(time.RFC3339),
 		EndTime:   endTime.Format(time.RFC3339),
 	}
+	if len(records) > 0 {
+		result.Resolution = records[0].Resolution
+	}
 
 	return &mcp.CallToolResult{
 		Content: []mcp.Content{
@@ -1472,6 +1898,176 @@ func (s *Server) handleGetUserSummary(ctx context.Context, req *mcp.CallToolRequ
 		ProcessCountAvg:    summary.ProcessCountAvg,
 		LimitedTimePercent: summary.LimitedTimePercent,
 		Samples:            summary.Samples,
+		Resolution:         summary.Resolution,
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			&mcp.TextContent{Text: toJSON(result)},
+		},
+		StructuredContent: result,
+	}, result, nil
+}
+
+// handleGetLimitEvents handles get_limit_events tool requests
+func (s *Server) handleGetLimitEvents(ctx context.Context, req *mcp.CallToolRequest, args GetLimitEventsArgs) (*mcp.CallToolResult, GetLimitEventsResult, error) {
+	if s.dbManager == nil {
+		return nil, GetLimitEventsResult{}, fmt.Errorf("metrics database is not enabled")
+	}
+
+	switch args.EventType {
+	case "", database.LimitEventLimit, database.LimitEventPSIBoost, database.LimitEventIOBoost, database.LimitEventPolicy:
+	default:
+		return nil, GetLimitEventsResult{}, fmt.Errorf("invalid event_type %q (expected limit, psi_boost, io_boost or policy)", args.EventType)
+	}
+
+	// Determine time range: the journal is sparse, default to the last week
+	now := time.Now()
+	period := args.Period
+	if period == "" {
+		period = "last_7_days"
+	}
+	startTime, endTime, err := database.ParseTimeRange(period, now)
+	if err != nil {
+		return nil, GetLimitEventsResult{}, err
+	}
+
+	// Override with explicit startTime/endTime if provided
+	if args.StartTime != "" {
+		if t, err := time.Parse(time.RFC3339, args.StartTime); err == nil {
+			startTime = t
+		}
+	}
+	if args.EndTime != "" {
+		if t, err := time.Parse(time.RFC3339, args.EndTime); err == nil {
+			endTime = t
+		}
+	}
+
+	// Handle hours parameter
+	if args.Hours > 0 {
+		startTime = now.Add(-time.Duration(args.Hours) * time.Hour)
+	}
+
+	// Default limit
+	limit := args.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+
+	// Without uid or username the journal covers all users
+	uid := -1
+	if args.UID != nil {
+		uid = *args.UID
+	} else if args.Username != "" {
+		uid = s.stateManager.GetUIDFromUsername(args.Username)
+		if uid == 0 {
+			return nil, GetLimitEventsResult{}, fmt.Errorf("user not found: %s", args.Username)
+		}
+	}
+
+	filter := database.LimitEventFilter{
+		UID:       uid,
+		EventType: args.EventType,
+		Start:     startTime,
+		End:       endTime,
+		Limit:     limit,
+	}
+	events, err := s.dbManager.GetLimitEvents(filter)
+	if err != nil {
+		return nil, GetLimitEventsResult{}, err
+	}
+	filter.Limit = 0
+	summary, err := s.dbManager.GetLimitEventSummary(filter)
+	if err != nil {
+		return nil, GetLimitEventsResult{}, err
+	}
+
+	result := GetLimitEventsResult{
+		Events:    events,
+		Count:     len(events),
+		Summary:   summary,
+		StartTime: startTime.Format(time.RFC3339),
+		EndTime:   endTime.Format(time.RFC3339),
+	}
+
+	return &mcp.CallToolResult{
+		Content: []mcp.Content{
+			&mcp.TextContent{Text: toJSON(result)},
+		},
+		StructuredContent: result,
+	}, result, nil
+}
+
+// handleGetUsageReport handles get_usage_report tool requests
+func (s *Server) handleGetUsageReport(ctx context.Context, req *mcp.CallToolRequest, args GetUsageReportArgs) (*mcp.CallToolResult, GetUsageReportResult, error) {
+	if s.dbManager == nil {
+		return nil, GetUsageReportResult{}, fmt.Errorf("metrics database is not enabled")
+	}
+
+	// Determine the days: accounting is kept per day, default to this month
+	now := time.Now()
+	period := args.Period
+	if period == "" {
+		period = "this_month"
+	}
+	startTime, endTime, err := database.ParseTimeRange(period, now)
+	if err != nil {
+		return nil, GetUsageReportResult{}, err
+	}
+
+	// Override with explicit startTime/endTime if provided
+	if args.StartTime != "" {
+		if t, err := time.Parse(time.RFC3339, args.StartTime); err == nil {
+			startTime = t
+		}
+	}
+	if args.EndTime != "" {
+		if t, err := time.Parse(time.RFC3339, args.EndTime); err == nil {
+			endTime = t
+		}
+	}
+
+	filter := database.AccountingFilter{
+		Group: args.Group,
+		Start: startTime,
+		End:   endTime,
+	}
+	if args.UID != nil {
+		filter.UID = *args.UID
+	} else if args.Username != "" {
+		filter.UID = s.stateManager.GetUIDFromUsername(args.Username)
+		if filter.UID == 0 {
+			return nil, GetUsageReportResult{}, fmt.Errorf("user not found: %s", args.Username)
+		}
+	}
+
+	groupBy := args.GroupBy
+	if groupBy == "" {
+		groupBy = database.AccountingByUser
+	}
+	groupOf := func(username string) string {
+		if s.parentCfg == nil {
+			return ""
+		}
+		group, _ := s.parentCfg.GetUserGroup(username)
+		return group.Name
+	}
+
+	records, err := s.dbManager.GetAccountingReport(filter, groupBy, groupOf)
+	if err != nil {
+		return nil, GetUsageReportResult{}, err
+	}
+	if records == nil {
+		records = []database.AccountingRecord{}
+	}
+
+	result := GetUsageReportResult{
+		Records: records,
+		Count:   len(records),
+		GroupBy: groupBy,
+		From:    startTime.Local().Format("2006-01-02"),
+		To:      endTime.Local().Format("2006-01-02"),
 	}
 
 	return &mcp.CallToolResult{
@@ -1599,6 +2195,50 @@ func getIntSlice(m map[string]any, key string, defaultVal []int) []int {
 	return defaultVal
 }
 
+// getUIDIntMap converts a uid -> int map into a JSON-friendly map keyed by uid string
+func getUIDIntMap(m map[string]any, key string) map[string]int {
+	result := make(map[string]int)
+	if val, ok := m[key]; ok {
+		if byUID, ok := val.(map[int]int); ok {
+			for uid, v := range byUID {
+				result[strconv.Itoa(uid)] = v
+			}
+		}
+	}
+	return result
+}
+
+// getResourceLimits safely gets the per-resource limit states from a map
+func getResourceLimits(m map[string]any, key string) map[string]ResourceLimitState {
+	result := make(map[string]ResourceLimitState)
+	if val, ok := m[key]; ok {
+		if byResource, ok := val.(map[string]map[string]interface{}); ok {
+			for resource, state := range byResource {
+				result[resource] = ResourceLimitState{
+					Active:       getBool(state, "active", false),
+					Enabled:      getBool(state, "enabled", false),
+					AppliedTime:  getString(state, "applied_time", ""),
+					UsagePercent: getFloatMetric(state, "usage_percent", 0),
+					StableCycles: getInt(state, "stable_cycles", 0),
+					LastDecision: getString(state, "last_decision", ""),
+					LastReason:   getString(state, "last_reason", ""),
+				}
+			}
+		}
+	}
+	return result
+}
+
+// getStringIntMap safely gets a string -> int map from a map
+func getStringIntMap(m map[string]any, key string) map[string]int {
+	if val, ok := m[key]; ok {
+		if byName, ok := val.(map[string]int); ok {
+			return byName
+		}
+	}
+	return map[string]int{}
+}
+
 // toJSON converts a value to JSON string
 func toJSON(v any) string {
 	b, err := json.MarshalIndent(v, "", "  ")
@@ -0,0 +1,268 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+// metrics/cgroup_cpu.go
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fdefilippo/resman/config"
+)
+
+// Sorgenti della misura CPU per utente (label "source" in Prometheus).
+const (
+	CPUSourceProc      = "proc"       // delta per-PID da /proc
+	CPUSourceCgroup    = "cgroup"     // cpu.stat dei cgroup resman dell'utente
+	CPUSourceUserSlice = "user_slice" // cpu.stat di user-<uid>.slice di systemd
+)
+
+// cgroupCPUSample e' l'ultima lettura di usage_usec di un cgroup.
+type cgroupCPUSample struct {
+	usageUsec uint64
+	at        time.Time
+}
+
+// cgroupCPUCache conserva le letture precedenti per cgroup, necessarie al
+// delta, e la CPU addebitata a ogni utente dall'avvio del demone.
+type cgroupCPUCache struct {
+	mu     sync.Mutex
+	prev   map[string]cgroupCPUSample // cgroup path -> ultima lettura
+	lastAt time.Time                  // ciclo precedente (zero al primo)
+	totals map[int]uint64             // uid -> usec addebitati
+}
+
+// cgroupCPUUsage e' l'uso CPU di un utente calcolato da cpu.stat.
+type cgroupCPUUsage struct {
+	percent   float64
+	source    string
+	usageUsec uint64 // usec addebitati dall'avvio, per la contabilita'
+}
+
+// readCPUStatUsage legge usage_usec da cpu.stat di un cgroup.
+func readCPUStatUsage(cgroupPath string) (uint64, error) {
+	file, err := os.Open(filepath.Join(cgroupPath, "cpu.stat"))
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "usage_usec" {
+			return strconv.ParseUint(fields[1], 10, 64)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("usage_usec not found in %s", cgroupPath)
+}
+
+// findUserCgroups trova i cgroup resman di ogni utente: user_<uid> sotto la
+// base, sotto limited e sotto limited/<group>. Un utente puo' averne piu' di
+// uno; i sottoalberi sono disgiunti, quindi i loro consumi si sommano.
+func findUserCgroups(basePath string) map[int][]string {
+	userCgroups := make(map[int][]string)
+	scan := func(dir string) []os.DirEntry {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() || !strings.HasPrefix(entry.Name(), "user_") {
+				continue
+			}
+			uid, err := strconv.Atoi(strings.TrimPrefix(entry.Name(), "user_"))
+			if err != nil {
+				continue
+			}
+			userCgroups[uid] = append(userCgroups[uid], filepath.Join(dir, entry.Name()))
+		}
+		return entries
+	}
+
+	scan(basePath)
+	sharedPath := filepath.Join(basePath, "limited")
+	for _, entry := range scan(sharedPath) {
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), "user_") {
+			scan(filepath.Join(sharedPath, entry.Name()))
+		}
+	}
+	return userCgroups
+}
+
+// userSlicePath restituisce il cgroup systemd di un utente.
+func userSlicePath(cfg *config.Config, uid int) string {
+	return filepath.Join(cfg.CgroupRoot, "user.slice", fmt.Sprintf("user-%d.slice", uid))
+}
+
+// accountingCgroups sono i cgroup da cui misurare ogni utente al posto della
+// scansione di /proc, con la sorgente (CPUSourceCgroup o CPUSourceUserSlice).
+type accountingCgroups struct {
+	paths   map[int][]string
+	sources map[int]string
+}
+
+// findAccountingCgroups trova i cgroup di ogni utente secondo
+// CPU_ACCOUNTING_CGROUP e CPU_ACCOUNTING_USER_SLICE. CPU, memoria e IO
+// vengono letti dallo stesso insieme.
+func (c *Collector) findAccountingCgroups() accountingCgroups {
+	cfg := c.getConfig()
+	useCgroup := cfg.GetCPUAccountingCgroup()
+	// In CGROUP_MODE=systemd-slice i cgroup resman dell'utente sono le slice
+	useSlice := cfg.GetCPUAccountingUserSlice() ||
+		(useCgroup && cfg.GetCgroupMode() == config.CgroupModeSystemdSlice)
+	if !useCgroup && !useSlice {
+		return accountingCgroups{}
+	}
+
+	userCgroups := make(map[int][]string)
+	if useCgroup {
+		userCgroups = findUserCgroups(filepath.Join(cfg.CgroupRoot, cfg.CgroupBase))
+	}
+	sources := make(map[int]string, len(userCgroups))
+	for uid := range userCgroups {
+		sources[uid] = CPUSourceCgroup
+	}
+	if useSlice {
+		entries, _ := os.ReadDir(filepath.Join(cfg.CgroupRoot, "user.slice"))
+		for _, entry := range entries {
+			var uid int
+			if _, err := fmt.Sscanf(entry.Name(), "user-%d.slice", &uid); err != nil || !entry.IsDir() {
+				continue
+			}
+			// I processi usciti dal cgroup resman restano nella slice: si sommano
+			userCgroups[uid] = append(userCgroups[uid], userSlicePath(cfg, uid))
+			if _, ok := sources[uid]; !ok {
+				sources[uid] = CPUSourceUserSlice
+			}
+		}
+	}
+	return accountingCgroups{paths: userCgroups, sources: sources}
+}
+
+// collectCgroupCPUUsage calcola l'uso CPU (percentuale per core, come la
+// scansione di /proc) dai delta di usage_usec. Include anche i processi
+// di breve durata che la scansione per-PID non vede. Gli utenti assenti dal
+// risultato, o al primo campione di un cgroup, vanno misurati da /proc.
+func (c *Collector) collectCgroupCPUUsage(cgroups accountingCgroups) map[int]cgroupCPUUsage {
+	if len(cgroups.paths) == 0 || c.cgroupCPU == nil {
+		return nil
+	}
+	userCgroups, sources := cgroups.paths, cgroups.sources
+	cores := c.GetTotalCores()
+
+	now := time.Now()
+	result := make(map[int]cgroupCPUUsage, len(userCgroups))
+
+	c.cgroupCPU.mu.Lock()
+	defer c.cgroupCPU.mu.Unlock()
+
+	// Un cgroup nato dopo il ciclo precedente non puo' aver consumato piu'
+	// di tutti i core per l'intervallo trascorso
+	var newCgroupMaxUsec uint64
+	if lastAt := c.cgroupCPU.lastAt; !lastAt.IsZero() && cores > 0 {
+		newCgroupMaxUsec = uint64(now.Sub(lastAt).Microseconds()) * uint64(cores)
+	}
+
+	seen := make(map[string]bool)
+	for uid, paths := range userCgroups {
+		// Anche i cgroup non letti (utente non monitorato, lettura fallita)
+		// tengono la lettura precedente: non vengono riaddebitati da capo
+		for _, path := range paths {
+			seen[path] = true
+		}
+		if !c.isMonitoredUserUID(uid) {
+			continue
+		}
+		ready := true
+		var total float64
+		for _, path := range paths {
+			usage, err := readCPUStatUsage(path)
+			if err != nil {
+				ready = false
+				continue
+			}
+			prev, ok := c.cgroupCPU.prev[path]
+			c.cgroupCPU.prev[path] = cgroupCPUSample{usageUsec: usage, at: now}
+			// Ogni cgroup addebita solo il proprio incremento: uno rimosso
+			// smette di contribuire invece di far scendere la somma. Uno
+			// ricreato conta da zero; uno senza lettura precedente solo se
+			// e' nato dopo il ciclo precedente, altrimenti fa da base.
+			switch {
+			case ok && usage >= prev.usageUsec:
+				c.cgroupCPU.totals[uid] += usage - prev.usageUsec
+			case ok || usage <= newCgroupMaxUsec:
+				c.cgroupCPU.totals[uid] += usage
+			}
+			elapsed := now.Sub(prev.at).Microseconds()
+			// Primo campione o cgroup ricreato (contatore ripartito da zero)
+			if !ok || usage < prev.usageUsec || elapsed <= 0 {
+				ready = false
+				continue
+			}
+			total += float64(usage-prev.usageUsec) / float64(elapsed) * cpuPercentMultiplier
+		}
+		if ready {
+			result[uid] = cgroupCPUUsage{percent: total, source: sources[uid], usageUsec: c.cgroupCPU.totals[uid]}
+		}
+	}
+	c.cgroupCPU.lastAt = now
+
+	// Solo i cgroup rimossi non servono piu' per il delta: uno assente dalla
+	// ricerca per un ciclo ma ancora presente tiene la lettura precedente
+	for path := range c.cgroupCPU.prev {
+		if seen[path] {
+			continue
+		}
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			delete(c.cgroupCPU.prev, path)
+		}
+	}
+
+	return result
+}
+
+// mergeCgroupCPUUsage sostituisce l'uso CPU da /proc con quello da cpu.stat
+// dove disponibile. Gli utenti con soli processi gia' terminati compaiono
+// comunque, perche' il loro consumo resta in usage_usec.
+func (c *Collector) mergeCgroupCPUUsage(tempData map[int]*userData, cgroupCPU map[int]cgroupCPUUsage) {
+	for uid, usage := range cgroupCPU {
+		if tempData[uid] == nil {
+			if usage.percent <= 0 {
+				continue
+			}
+			tempData[uid] = &userData{}
+		}
+		tempData[uid].cpuUsage = usage.percent
+		tempData[uid].cpuSource = usage.source
+		tempData[uid].cpuUsageUsec = usage.usageUsec
+	}
+	for _, data := range tempData {
+		if data.cpuSource == "" {
+			data.cpuSource = CPUSourceProc
+		}
+	}
+}
@@ -0,0 +1,217 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+package metrics
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fdefilippo/resman/config"
+)
+
+func writeCPUStat(t *testing.T, cgroupPath string, usageUsec uint64) {
+	t.Helper()
+	if err := os.MkdirAll(cgroupPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	content := fmt.Sprintf("usage_usec %d\nuser_usec %d\nsystem_usec 0\n", usageUsec, usageUsec)
+	if err := os.WriteFile(filepath.Join(cgroupPath, "cpu.stat"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCollectCgroupCPUUsage(t *testing.T) {
+	root := t.TempDir()
+	cfg := config.DefaultConfig()
+	cfg.CgroupRoot = root
+	cfg.CgroupBase = "resman"
+	cfg.CPUAccountingUserSlice = true
+
+	collector, err := NewCollector(cfg)
+	if err != nil {
+		t.Fatalf("NewCollector() error: %v", err)
+	}
+	defer collector.Stop()
+
+	limitedPath := filepath.Join(root, "resman", "limited", "user_1000")
+	groupPath := filepath.Join(root, "resman", "limited", "batch", "user_1001")
+	slicePath := filepath.Join(root, "user.slice", "user-1002.slice")
+	writeCPUStat(t, limitedPath, 1000000)
+	writeCPUStat(t, groupPath, 0)
+	writeCPUStat(t, slicePath, 0)
+
+	// Primo campione: nessun delta, gli utenti restano su /proc
+	if usage := collector.collectCgroupCPUUsage(collector.findAccountingCgroups()); len(usage) != 0 {
+		t.Fatalf("first sample should not produce usage, got %v", usage)
+	}
+
+	// Un secondo dopo: 0.5s di CPU per 1000, 2s per 1001, 0.25s per 1002
+	collector.cgroupCPU.mu.Lock()
+	for path, sample := range collector.cgroupCPU.prev {
+		sample.at = sample.at.Add(-time.Second)
+		collector.cgroupCPU.prev[path] = sample
+	}
+	collector.cgroupCPU.mu.Unlock()
+	writeCPUStat(t, limitedPath, 1500000)
+	writeCPUStat(t, groupPath, 2000000)
+	writeCPUStat(t, slicePath, 250000)
+
+	usage := collector.collectCgroupCPUUsage(collector.findAccountingCgroups())
+	expected := map[int]struct {
+		percent float64
+		source  string
+	}{
+		1000: {50, CPUSourceCgroup},
+		1001: {200, CPUSourceCgroup},
+		1002: {25, CPUSourceUserSlice},
+	}
+	for uid, want := range expected {
+		got, ok := usage[uid]
+		if !ok {
+			t.Errorf("uid %d missing from cgroup usage", uid)
+			continue
+		}
+		if got.source != want.source {
+			t.Errorf("uid %d source = %s, expected %s", uid, got.source, want.source)
+		}
+		if got.percent < want.percent*0.9 || got.percent > want.percent*1.1 {
+			t.Errorf("uid %d usage = %.1f%%, expected about %.1f%%", uid, got.percent, want.percent)
+		}
+	}
+
+	// Cgroup ricreato: il contatore riparte e l'utente torna su /proc per un ciclo
+	writeCPUStat(t, limitedPath, 10)
+	if _, ok := collector.collectCgroupCPUUsage(collector.findAccountingCgroups())[1000]; ok {
+		t.Error("a reset usage_usec counter should not produce a delta")
+	}
+}
+
+func TestCgroupCPUChargedAcrossRemoval(t *testing.T) {
+	root := t.TempDir()
+	cfg := config.DefaultConfig()
+	cfg.CgroupRoot = root
+	cfg.CgroupBase = "resman"
+	cfg.CPUAccountingUserSlice = true
+
+	collector, err := NewCollector(cfg)
+	if err != nil {
+		t.Fatalf("NewCollector() error: %v", err)
+	}
+	defer collector.Stop()
+
+	// Utente limitato: i processi sono nel cgroup resman, la slice ha
+	// gia' un'ora di CPU consumata prima dell'avvio del demone
+	limitedPath := filepath.Join(root, "resman", "limited", "user_1000")
+	slicePath := filepath.Join(root, "user.slice", "user-1000.slice")
+	writeCPUStat(t, limitedPath, 5_000_000)
+	writeCPUStat(t, slicePath, 3600_000_000)
+	sample := func() uint64 {
+		t.Helper()
+		usage, ok := collector.collectCgroupCPUUsage(collector.findAccountingCgroups())[1000]
+		if !ok {
+			t.Fatal("uid 1000 missing from cgroup usage")
+		}
+		return usage.usageUsec
+	}
+
+	// Il primo ciclo fa solo da base
+	collector.collectCgroupCPUUsage(collector.findAccountingCgroups())
+	writeCPUStat(t, limitedPath, 7_000_000)
+	writeCPUStat(t, slicePath, 3601_000_000)
+	if got := sample(); got != 3_000_000 {
+		t.Fatalf("charged usec = %d, expected 3000000", got)
+	}
+
+	// Limite rilasciato: il cgroup sparisce e i processi tornano nella
+	// slice. La CPU addebitata cresce solo dell'incremento della slice.
+	if err := os.RemoveAll(limitedPath); err != nil {
+		t.Fatal(err)
+	}
+	writeCPUStat(t, slicePath, 3601_500_000)
+	if got := sample(); got != 3_500_000 {
+		t.Errorf("charged usec after cgroup removal = %d, expected 3500000", got)
+	}
+
+	// Limite riapplicato un secondo dopo: il nuovo cgroup conta da zero
+	collector.cgroupCPU.mu.Lock()
+	collector.cgroupCPU.lastAt = collector.cgroupCPU.lastAt.Add(-time.Second)
+	collector.cgroupCPU.mu.Unlock()
+	writeCPUStat(t, limitedPath, 250_000)
+	writeCPUStat(t, slicePath, 3601_600_000)
+	collector.collectCgroupCPUUsage(collector.findAccountingCgroups())
+	writeCPUStat(t, limitedPath, 300_000)
+	if got := sample(); got != 3_500_000+100_000+300_000 {
+		t.Errorf("charged usec after cgroup creation = %d, expected %d", got, 3_500_000+100_000+300_000)
+	}
+}
+
+func TestCgroupCPUReadErrorNotRecharged(t *testing.T) {
+	root := t.TempDir()
+	cfg := config.DefaultConfig()
+	cfg.CgroupRoot = root
+	cfg.CgroupBase = "resman"
+	cfg.CPUAccountingUserSlice = true
+
+	collector, err := NewCollector(cfg)
+	if err != nil {
+		t.Fatalf("NewCollector() error: %v", err)
+	}
+	defer collector.Stop()
+
+	slicePath := filepath.Join(root, "user.slice", "user-1000.slice")
+	writeCPUStat(t, slicePath, 3600_000_000)
+	charged := func() uint64 {
+		t.Helper()
+		collector.collectCgroupCPUUsage(collector.findAccountingCgroups())
+		collector.cgroupCPU.mu.Lock()
+		defer collector.cgroupCPU.mu.Unlock()
+		return collector.cgroupCPU.totals[1000]
+	}
+
+	charged()
+	writeCPUStat(t, slicePath, 3601_000_000)
+	if got := charged(); got != 1_000_000 {
+		t.Fatalf("charged usec = %d, expected 1000000", got)
+	}
+
+	// cpu.stat illeggibile per un ciclo: alla ripresa si addebita solo
+	// l'incremento, non l'intero contatore
+	statFile := filepath.Join(slicePath, "cpu.stat")
+	if err := os.WriteFile(statFile, []byte("nr_periods 0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := charged(); got != 1_000_000 {
+		t.Errorf("charged usec with a failed read = %d, expected 1000000", got)
+	}
+	writeCPUStat(t, slicePath, 3601_500_000)
+	if got := charged(); got != 1_500_000 {
+		t.Errorf("charged usec after the read recovered = %d, expected 1500000", got)
+	}
+
+	// Slice assente dalla ricerca per un ciclo (user.slice illeggibile)
+	collector.collectCgroupCPUUsage(accountingCgroups{
+		paths:   map[int][]string{1001: {filepath.Join(root, "user.slice", "user-1001.slice")}},
+		sources: map[int]string{1001: CPUSourceUserSlice},
+	})
+	writeCPUStat(t, slicePath, 3602_000_000)
+	if got := charged(); got != 2_000_000 {
+		t.Errorf("charged usec after a missed listing = %d, expected 2000000", got)
+	}
+}
@@ -0,0 +1,172 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+// metrics/cgroup_memio.go
+package metrics
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// cgroupMemoryIO sono memoria e contatori IO di un utente letti dai suoi
+// cgroup. hasMemory/hasIO sono false se un cgroup non espone il file: in
+// quel caso la misura viene dalla scansione per-PID.
+type cgroupMemoryIO struct {
+	hasMemory    bool
+	memoryBytes  uint64 // working set: memory.current - inactive_file
+	hasIO        bool
+	ioReadBytes  uint64
+	ioWriteBytes uint64
+	ioReadOps    uint64
+	ioWriteOps   uint64
+}
+
+// readCgroupWorkingSet legge memory.current meno la page cache inattiva,
+// l'equivalente per cgroup dell'RSS sommato dalla scansione di /proc.
+func readCgroupWorkingSet(cgroupPath string) (uint64, error) {
+	data, err := os.ReadFile(filepath.Join(cgroupPath, "memory.current"))
+	if err != nil {
+		return 0, err
+	}
+	current, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	stat, err := os.ReadFile(filepath.Join(cgroupPath, "memory.stat"))
+	if err != nil {
+		return current, nil
+	}
+	for _, line := range strings.Split(string(stat), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || fields[0] != "inactive_file" {
+			continue
+		}
+		inactive, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil || inactive >= current {
+			return 0, nil
+		}
+		return current - inactive, nil
+	}
+	return current, nil
+}
+
+// readCgroupIOStat somma i contatori di io.stat su tutti i dispositivi. Il
+// file manca se il controller io non e' abilitato nel cgroup.
+func readCgroupIOStat(cgroupPath string) (readBytes, writeBytes, readOps, writeOps uint64, err error) {
+	data, err := os.ReadFile(filepath.Join(cgroupPath, "io.stat"))
+	if err != nil {
+		return 0, 0, 0, 0, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		for _, field := range fields[1:] {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				continue
+			}
+			n, parseErr := strconv.ParseUint(value, 10, 64)
+			if parseErr != nil {
+				continue
+			}
+			switch key {
+			case "rbytes":
+				readBytes += n
+			case "wbytes":
+				writeBytes += n
+			case "rios":
+				readOps += n
+			case "wios":
+				writeOps += n
+			}
+		}
+	}
+	return readBytes, writeBytes, readOps, writeOps, nil
+}
+
+// collectCgroupMemoryIO legge memoria e IO degli utenti dagli stessi cgroup
+// usati per la CPU, cosi' la scansione di /proc non deve aprire status e io
+// di ogni loro processo. Un utente con piu' cgroup (sottoalberi disgiunti)
+// ne somma i valori; se uno non e' leggibile si torna al per-PID. I byte di
+// ogni cgroup vanno in sweep per i contatori monotoni.
+func (c *Collector) collectCgroupMemoryIO(cgroups accountingCgroups, sweep ioSweep) map[int]cgroupMemoryIO {
+	if len(cgroups.paths) == 0 {
+		return nil
+	}
+
+	result := make(map[int]cgroupMemoryIO, len(cgroups.paths))
+	for uid, paths := range cgroups.paths {
+		if !c.isMonitoredUserUID(uid) {
+			continue
+		}
+		usage := cgroupMemoryIO{hasMemory: true, hasIO: true}
+		pathIO := make(map[string]ioCounterSample, len(paths))
+		for _, path := range paths {
+			if memory, err := readCgroupWorkingSet(path); err == nil {
+				usage.memoryBytes += memory
+			} else {
+				usage.hasMemory = false
+			}
+			if rB, wB, rO, wO, err := readCgroupIOStat(path); err == nil {
+				usage.ioReadBytes += rB
+				usage.ioWriteBytes += wB
+				usage.ioReadOps += rO
+				usage.ioWriteOps += wO
+				pathIO[path] = ioCounterSample{readBytes: rB, writeBytes: wB}
+			} else {
+				usage.hasIO = false
+			}
+		}
+		// Con una lettura fallita l'IO dell'utente viene da /proc per questo
+		// ciclo: i cgroup tengono la lettura precedente, senza addebiti
+		for _, path := range paths {
+			if sample, ok := pathIO[path]; ok && usage.hasIO {
+				sweep.addCgroup(uid, path, sample)
+			} else {
+				sweep.addStaleCgroup(uid, path)
+			}
+		}
+		if usage.hasMemory || usage.hasIO {
+			result[uid] = usage
+		}
+	}
+	return result
+}
+
+// mergeCgroupMemoryIO sostituisce memoria e IO per-PID con quelli dei cgroup
+func mergeCgroupMemoryIO(tempData map[int]*userData, cgroupMemIO map[int]cgroupMemoryIO) {
+	for uid, usage := range cgroupMemIO {
+		data := tempData[uid]
+		if data == nil {
+			// Nessun processo vivo: il cgroup conta solo consumi passati
+			continue
+		}
+		if usage.hasMemory {
+			data.memoryUsage = usage.memoryBytes
+		}
+		if usage.hasIO {
+			data.ioReadBytes = usage.ioReadBytes
+			data.ioWriteBytes = usage.ioWriteBytes
+			data.ioReadOps = usage.ioReadOps
+			data.ioWriteOps = usage.ioWriteOps
+		}
+	}
+}
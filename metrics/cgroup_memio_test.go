@@ -0,0 +1,87 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+package metrics
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fdefilippo/resman/config"
+)
+
+func TestCollectCgroupMemoryIO(t *testing.T) {
+	root := t.TempDir()
+	cfg := config.DefaultConfig()
+	cfg.CgroupRoot = root
+	cfg.CgroupBase = "resman"
+
+	collector, err := NewCollector(cfg)
+	if err != nil {
+		t.Fatalf("NewCollector() error: %v", err)
+	}
+	defer collector.Stop()
+
+	fullPath := filepath.Join(root, "resman", "limited", "user_1000")
+	noIOPath := filepath.Join(root, "resman", "limited", "user_1001")
+	writeCPUStat(t, fullPath, 0)
+	writeCPUStat(t, noIOPath, 0)
+	files := map[string]string{
+		filepath.Join(fullPath, "memory.current"): "1048576\n",
+		filepath.Join(fullPath, "memory.stat"):    "anon 524288\ninactive_file 262144\n",
+		filepath.Join(fullPath, "io.stat"): "8:0 rbytes=4096 wbytes=8192 rios=1 wios=2 dbytes=0 dios=0\n" +
+			"8:16 rbytes=1000 wbytes=0 rios=3 wios=0 dbytes=0 dios=0\n",
+		filepath.Join(noIOPath, "memory.current"): "2048\n",
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	usage := collector.collectCgroupMemoryIO(collector.findAccountingCgroups(), make(ioSweep))
+
+	full := usage[1000]
+	if !full.hasMemory || full.memoryBytes != 1048576-262144 {
+		t.Errorf("uid 1000 memory = %d (has %v), expected working set %d", full.memoryBytes, full.hasMemory, 1048576-262144)
+	}
+	if !full.hasIO || full.ioReadBytes != 5096 || full.ioWriteBytes != 8192 || full.ioReadOps != 4 || full.ioWriteOps != 2 {
+		t.Errorf("uid 1000 IO = %+v, expected io.stat summed over devices", full)
+	}
+
+	// Senza io.stat l'IO resta alla scansione per-PID, la memoria no
+	partial := usage[1001]
+	if !partial.hasMemory || partial.memoryBytes != 2048 {
+		t.Errorf("uid 1001 memory = %d (has %v), expected 2048", partial.memoryBytes, partial.hasMemory)
+	}
+	if partial.hasIO {
+		t.Error("uid 1001 has no io.stat and should fall back to /proc/<pid>/io")
+	}
+
+	// I valori del cgroup sostituiscono le somme per-PID
+	tempData := map[int]*userData{
+		1000: {memoryUsage: 1, ioReadBytes: 1},
+		1001: {memoryUsage: 1, ioReadBytes: 7},
+	}
+	mergeCgroupMemoryIO(tempData, usage)
+	if tempData[1000].memoryUsage != 786432 || tempData[1000].ioReadBytes != 5096 {
+		t.Errorf("uid 1000 merged = %+v", *tempData[1000])
+	}
+	if tempData[1001].memoryUsage != 2048 || tempData[1001].ioReadBytes != 7 {
+		t.Errorf("uid 1001 merged = %+v", *tempData[1001])
+	}
+}
@@ -30,6 +30,7 @@ import (
 	"time"
 
 	"github.com/fdefilippo/resman/config"
+	"github.com/fdefilippo/resman/database"
 	"github.com/fdefilippo/resman/logging"
 	"github.com/shirou/gopsutil/v3/cpu"
 	"github.com/shirou/gopsutil/v3/host"
@@ -55,6 +56,18 @@ type UserMetrics struct {
 	IOWriteBytes    uint64  // Total bytes written to block devices
 	IOReadOps       uint64  // Total read operations
 	IOWriteOps      uint64  // Total write operations
+	CPUSource       string  // Source of CPUUsage: proc, cgroup or user_slice
+	CPUUsageUsec    uint64  // CPU charged from the user's cgroups since daemon start, in usec (0 with source proc)
+	IOReadTotal     uint64  // Bytes read since daemon start; unlike IOReadBytes it never drops when processes exit
+	IOWriteTotal    uint64  // Bytes written since daemon start
+
+	// Dati del cgroup utente, aggiunti dallo state manager prima della
+	// scrittura nel database (zero se l'utente non ha un cgroup)
+	IOPressure       float64 // io.pressure some avg10
+	MemoryPressure   float64 // memory.pressure some avg10
+	MemoryHighEvents uint64  // contatore high di memory.events
+	MemoryMaxEvents  uint64  // contatore max di memory.events
+	OOMKillEvents    uint64  // contatore oom_kill di memory.events
 }
 
 // procCache holds CPU timing data for all PIDs.
@@ -75,6 +88,10 @@ type userData struct {
 	ioWriteBytes uint64
 	ioReadOps    uint64
 	ioWriteOps   uint64
+	cpuSource    string
+	cpuUsageUsec uint64
+	ioReadTotal  uint64
+	ioWriteTotal uint64
 }
 
 // emaCache stores EMA values per UID between cycles.
@@ -104,6 +121,12 @@ type Collector struct {
 	// EMA cache for CPU usage smoothing between cycles
 	emaCache *emaCache
 
+	// Letture precedenti di cpu.stat per gli utenti con un cgroup
+	cgroupCPU *cgroupCPUCache
+
+	// Contatori IO monotoni per utente, per la contabilita'
+	ioCounters *ioCounterCache
+
 	// Database writer (opzionale)
 	dbWriter *DBWriter
 
@@ -148,6 +171,13 @@ func NewCollector(cfg *config.Config) (*Collector, error) {
 		emaCache: &emaCache{
 			values: make(map[int]float64),
 		},
+		cgroupCPU: &cgroupCPUCache{
+			prev:   make(map[string]cgroupCPUSample),
+			totals: make(map[int]uint64),
+		},
+		ioCounters: &ioCounterCache{
+			totals: make(map[int]ioCounterSample),
+		},
 	}
 
 	go collector.periodicCleanup()
@@ -1052,8 +1082,12 @@ func (c *Collector) GetAllUserMetrics() map[int]*UserMetrics {
 	// Read system uptime once (needed for CPU average calculation)
 	systemUptimeSeconds := c.getSystemUptimeSeconds()
 
-	// Second pass for IO: collect for ALL visible PIDs (including system users like mysql)
-	ioData := make(map[int]*userData)
+	// Utenti con cgroup leggibili: CPU, memoria e IO vengono da cpu.stat,
+	// memory.current e io.stat invece che da letture per-PID
+	cgroups := c.findAccountingCgroups()
+	cgroupCPU := c.collectCgroupCPUUsage(cgroups)
+	ioSamples := make(ioSweep)
+	cgroupMemIO := c.collectCgroupMemoryIO(cgroups, ioSamples)
 
 	for _, p := range procs {
 		// Get process UID
@@ -1076,53 +1110,39 @@ func (c *Collector) GetAllUserMetrics() map[int]*UserMetrics {
 		tempData[uid].processCount++
 
 		// Read CPU usage using gopsutil proc.Times()
-		cpuUsage := c.getProcessCPUUsageSimpleWithHandle(p)
-		tempData[uid].cpuUsage += cpuUsage
+		if _, ok := cgroupCPU[uid]; !ok {
+			cpuUsage := c.getProcessCPUUsageSimpleWithHandle(p)
+			tempData[uid].cpuUsage += cpuUsage
+		}
 
 		// Read memory usage (RSS)
-		memInfo, err := p.MemoryInfo()
-		if err == nil && memInfo != nil {
-			tempData[uid].memoryUsage += memInfo.RSS
+		if !cgroupMemIO[uid].hasMemory {
+			memInfo, err := p.MemoryInfo()
+			if err == nil && memInfo != nil {
+				tempData[uid].memoryUsage += memInfo.RSS
+			}
 		}
 
 		// Calculate CPU average since process start
 		cpuAvg := c.getProcessCPUAverage(p, systemUptimeSeconds)
 		tempData[uid].cpuUsageAvg += cpuAvg
-	}
-
-	// Collect IO for ALL visible processes (including system users like mysql, root, etc.)
-	// IO is useful for all processes, not just non-system users
-	for _, p := range procs {
-		uids, err := p.Uids()
-		if err != nil || len(uids) == 0 {
-			continue
-		}
-		uid := int(uids[0])
 
-		if ioData[uid] == nil {
-			ioData[uid] = &userData{}
+		// Read IO counters from /proc/<pid>/io
+		if !cgroupMemIO[uid].hasIO {
+			rB, wB, rO, wO := c.getProcessIO(int(p.Pid))
+			tempData[uid].ioReadBytes += rB
+			tempData[uid].ioWriteBytes += wB
+			tempData[uid].ioReadOps += rO
+			tempData[uid].ioWriteOps += wO
+			if createTime, err := p.CreateTime(); err == nil {
+				ioSamples.addProc(uid, int(p.Pid), createTime, ioCounterSample{readBytes: rB, writeBytes: wB})
+			}
 		}
-
-		rB, wB, rO, wO := c.getProcessIO(int(p.Pid))
-		ioData[uid].ioReadBytes += rB
-		ioData[uid].ioWriteBytes += wB
-		ioData[uid].ioReadOps += rO
-		ioData[uid].ioWriteOps += wO
 	}
 
-	// Merge IO data into main tempData
-	for uid, ioD := range ioData {
-		if !c.isMonitoredUserUID(uid) {
-			continue
-		}
-		if tempData[uid] == nil {
-			tempData[uid] = &userData{}
-		}
-		tempData[uid].ioReadBytes += ioD.ioReadBytes
-		tempData[uid].ioWriteBytes += ioD.ioWriteBytes
-		tempData[uid].ioReadOps += ioD.ioReadOps
-		tempData[uid].ioWriteOps += ioD.ioWriteOps
-	}
+	c.mergeCgroupCPUUsage(tempData, cgroupCPU)
+	mergeCgroupMemoryIO(tempData, cgroupMemIO)
+	mergeIOTotals(tempData, c.ioCounters.commit(ioSamples))
 
 	// Convert to UserMetrics with username
 	for uid, data := range tempData {
@@ -1146,6 +1166,10 @@ func (c *Collector) GetAllUserMetrics() map[int]*UserMetrics {
 			IOWriteBytes:    data.ioWriteBytes,
 			IOReadOps:       data.ioReadOps,
 			IOWriteOps:      data.ioWriteOps,
+			CPUSource:       data.cpuSource,
+			CPUUsageUsec:    data.cpuUsageUsec,
+			IOReadTotal:     data.ioReadTotal,
+			IOWriteTotal:    data.ioWriteTotal,
 		}
 	}
 
@@ -1169,7 +1193,10 @@ func (c *Collector) getAllUserMetricsFallback() map[int]*UserMetrics {
 
 	estimatedUIDs := len(entries) / 50
 	tempData := make(map[int]*userData, estimatedUIDs)
-	ioData := make(map[int]*userData)
+	cgroups := c.findAccountingCgroups()
+	cgroupCPU := c.collectCgroupCPUUsage(cgroups)
+	ioSamples := make(ioSweep)
+	cgroupMemIO := c.collectCgroupMemoryIO(cgroups, ioSamples)
 
 	// Read system uptime once
 	systemUptimeSeconds := c.getSystemUptimeSeconds()
@@ -1195,39 +1222,38 @@ func (c *Collector) getAllUserMetricsFallback() map[int]*UserMetrics {
 		}
 
 		tempData[uid].processCount++
-		cpuUsage := c.getProcessCPUUsageSimple(pid)
-		tempData[uid].cpuUsage += cpuUsage
-		memoryUsage := c.getProcessMemoryUsage(pid)
-		tempData[uid].memoryUsage += memoryUsage
+		if _, ok := cgroupCPU[uid]; !ok {
+			tempData[uid].cpuUsage += c.getProcessCPUUsageSimple(pid)
+		}
+		if !cgroupMemIO[uid].hasMemory {
+			tempData[uid].memoryUsage += c.getProcessMemoryUsage(pid)
+		}
 
 		// CPU average
-		proc, err := process.NewProcess(int32(pid))
-		if err == nil {
+		proc, procErr := process.NewProcess(int32(pid))
+		if procErr == nil {
 			cpuAvg := c.getProcessCPUAverage(proc, systemUptimeSeconds)
 			tempData[uid].cpuUsageAvg += cpuAvg
 		}
 
 		// IO
-		if ioData[uid] == nil {
-			ioData[uid] = &userData{}
+		if !cgroupMemIO[uid].hasIO {
+			rB, wB, rO, wO := c.getProcessIO(pid)
+			tempData[uid].ioReadBytes += rB
+			tempData[uid].ioWriteBytes += wB
+			tempData[uid].ioReadOps += rO
+			tempData[uid].ioWriteOps += wO
+			if procErr == nil {
+				if createTime, err := proc.CreateTime(); err == nil {
+					ioSamples.addProc(uid, pid, createTime, ioCounterSample{readBytes: rB, writeBytes: wB})
+				}
+			}
 		}
-		rB, wB, rO, wO := c.getProcessIO(pid)
-		ioData[uid].ioReadBytes += rB
-		ioData[uid].ioWriteBytes += wB
-		ioData[uid].ioReadOps += rO
-		ioData[uid].ioWriteOps += wO
 	}
 
-	// Merge IO
-	for uid, ioD := range ioData {
-		if tempData[uid] == nil {
-			tempData[uid] = &userData{}
-		}
-		tempData[uid].ioReadBytes += ioD.ioReadBytes
-		tempData[uid].ioWriteBytes += ioD.ioWriteBytes
-		tempData[uid].ioReadOps += ioD.ioReadOps
-		tempData[uid].ioWriteOps += ioD.ioWriteOps
-	}
+	c.mergeCgroupCPUUsage(tempData, cgroupCPU)
+	mergeCgroupMemoryIO(tempData, cgroupMemIO)
+	mergeIOTotals(tempData, c.ioCounters.commit(ioSamples))
 
 	for uid, data := range tempData {
 		username := c.GetUsernameFromUID(uid)
@@ -1245,6 +1271,10 @@ func (c *Collector) getAllUserMetricsFallback() map[int]*UserMetrics {
 			IOWriteBytes:    data.ioWriteBytes,
 			IOReadOps:       data.ioReadOps,
 			IOWriteOps:      data.ioWriteOps,
+			CPUSource:       data.cpuSource,
+			CPUUsageUsec:    data.cpuUsageUsec,
+			IOReadTotal:     data.ioReadTotal,
+			IOWriteTotal:    data.ioWriteTotal,
 		}
 	}
 
@@ -1687,18 +1717,29 @@ func (c *Collector) WriteMetricsToDatabase(userMetrics map[int]*UserMetrics, tot
 
 	// Scrivi metriche per ogni utente
 	for uid, metrics := range userMetrics {
-		writer.WriteUserMetrics(
-			uid,
-			metrics.Username,
-			metrics.CPUUsage,
-			metrics.MemoryUsage,
-			metrics.ProcessCount,
-			false, // isLimited verrà impostato dallo state manager
-			"",    // cgroupPath
-			"",    // cpuQuota
-		)
-	}
-
+		writer.WriteUserMetricsRecord(&database.UserMetricsRecord{
+			UID:              uid,
+			Username:         metrics.Username,
+			CPUUsagePercent:  metrics.CPUUsage,
+			MemoryUsageBytes: int64(metrics.MemoryUsage),
+			ProcessCount:     metrics.ProcessCount,
+			IsLimited:        false, // isLimited verrà impostato dallo state manager
+			IOReadBytes:      int64(metrics.IOReadBytes),
+			IOWriteBytes:     int64(metrics.IOWriteBytes),
+			IOPressure:       metrics.IOPressure,
+			MemoryPressure:   metrics.MemoryPressure,
+			MemoryHighEvents: int64(metrics.MemoryHighEvents),
+			MemoryMaxEvents:  int64(metrics.MemoryMaxEvents),
+			OOMKillEvents:    int64(metrics.OOMKillEvents),
+			CPUUsageEMA:      metrics.CPUUsageEMA,
+			CPUUsageUsec:     int64(metrics.CPUUsageUsec),
+			IOReadTotal:      int64(metrics.IOReadTotal),
+			IOWriteTotal:     int64(metrics.IOWriteTotal),
+		})
+	}
+
+	// Il commit avviene nella goroutine del writer, in una sola transazione
+	writer.Flush()
 	writer.MarkWritten()
 }
 
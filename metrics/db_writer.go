@@ -25,7 +25,29 @@ import (
 	"github.com/fdefilippo/resman/logging"
 )
 
-// DBWriter gestisce la scrittura delle metriche nel database
+// DBWriteObserver riceve le statistiche del writer in background
+// (implementato da PrometheusExporter)
+type DBWriteObserver interface {
+	UpdateDBWriteQueueDepth(depth int)
+	RecordDBWriteDrops(count int)
+	RecordDBWriteCommit(duration time.Duration, records int)
+}
+
+// dbRollupInterval è ogni quanto la goroutine di scrittura aggiorna le
+// tabelle aggregate, dopo un commit
+const dbRollupInterval = 5 * time.Minute
+
+// dbWriteItem è un record in coda: esattamente uno dei campi è valorizzato
+type dbWriteItem struct {
+	user         *database.UserMetricsRecord
+	system       *database.SystemMetricsRecord
+	memoryEvents *database.UserMemoryEventsRecord
+}
+
+// DBWriter gestisce la scrittura delle metriche nel database.
+// I record vengono accodati su un canale limitato e una goroutine li scrive
+// in una sola transazione per ciclo: il ciclo di controllo non attende mai
+// SQLite. Se la coda è piena i record vengono scartati e contati.
 type DBWriter struct {
 	dbManager     *database.DatabaseManager
 	logger        *logging.Logger
@@ -33,34 +55,49 @@ type DBWriter struct {
 	mu            sync.RWMutex
 	lastWriteTime time.Time
 	enabled       bool
+
+	queue    chan dbWriteItem
+	flushReq chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+	observer DBWriteObserver
+	dropped  uint64
+	closed   bool
 }
 
-// NewDBWriter crea un nuovo DBWriter
-func NewDBWriter(dbManager *database.DatabaseManager, writeIntervalSeconds int) *DBWriter {
+// NewDBWriter crea un nuovo DBWriter e avvia la goroutine di scrittura.
+// queueSize è il numero massimo di record in attesa di commit.
+func NewDBWriter(dbManager *database.DatabaseManager, writeIntervalSeconds int, queueSize int) *DBWriter {
 	logger := logging.GetLogger()
 
-	return &DBWriter{
+	if queueSize < 1 {
+		queueSize = 1
+	}
+
+	w := &DBWriter{
 		dbManager:     dbManager,
 		logger:        logger,
 		writeInterval: time.Duration(writeIntervalSeconds) * time.Second,
 		enabled:       true,
+		queue:         make(chan dbWriteItem, queueSize),
+		flushReq:      make(chan struct{}, 1),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
 	}
+	go w.run()
+	return w
 }
 
-// WriteUserMetrics scrive le metriche utente nel database
-func (w *DBWriter) WriteUserMetrics(uid int, username string, cpuUsage float64, memoryUsage uint64, processCount int, isLimited bool, cgroupPath string, cpuQuota string) {
-	w.mu.RLock()
-	if !w.enabled {
-		w.mu.RUnlock()
-		return
-	}
-	w.mu.RUnlock()
-
-	if w.dbManager == nil {
-		return
-	}
+// SetObserver imposta chi riceve profondità della coda, scarti e latenza dei commit
+func (w *DBWriter) SetObserver(observer DBWriteObserver) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.observer = observer
+}
 
-	record := &database.UserMetricsRecord{
+// WriteUserMetrics accoda le metriche utente per il database
+func (w *DBWriter) WriteUserMetrics(uid int, username string, cpuUsage float64, memoryUsage uint64, processCount int, isLimited bool, cgroupPath string, cpuQuota string) {
+	w.WriteUserMetricsRecord(&database.UserMetricsRecord{
 		UID:              uid,
 		Username:         username,
 		CPUUsagePercent:  cpuUsage,
@@ -69,38 +106,152 @@ func (w *DBWriter) WriteUserMetrics(uid int, username string, cpuUsage float64,
 		CgroupPath:       cgroupPath,
 		CPUQuota:         cpuQuota,
 		IsLimited:        isLimited,
-		Timestamp:        time.Now(),
+	})
+}
+
+// WriteUserMetricsRecord accoda un record completo delle metriche utente,
+// incluse le colonne IO, PSI, memory.events ed EMA
+func (w *DBWriter) WriteUserMetricsRecord(record *database.UserMetricsRecord) {
+	if record.Timestamp.IsZero() {
+		record.Timestamp = time.Now()
 	}
+	w.enqueue(dbWriteItem{user: record})
+}
 
-	if err := w.dbManager.WriteUserMetrics(record); err != nil {
-		w.logger.Debug("Failed to write user metrics to database", "uid", uid, "username", username, "error", err)
+// WriteUserMemoryEvents accoda un campione di memory.events/memory.stat,
+// scritto nella stessa transazione delle metriche del ciclo. Non restituisce
+// mai errore: i record scartati per coda piena vengono contati.
+func (w *DBWriter) WriteUserMemoryEvents(record *database.UserMemoryEventsRecord) error {
+	if record.Timestamp.IsZero() {
+		record.Timestamp = time.Now()
 	}
+	w.enqueue(dbWriteItem{memoryEvents: record})
+	return nil
 }
 
-// WriteSystemMetrics scrive le metriche di sistema nel database
+// WriteSystemMetrics accoda le metriche di sistema per il database
 func (w *DBWriter) WriteSystemMetrics(totalCPUUsage float64, totalCores int, systemLoad float64, limitsActive bool, limitedUsersCount int) {
+	w.enqueue(dbWriteItem{system: &database.SystemMetricsRecord{
+		TotalCPUUsagePercent: totalCPUUsage,
+		TotalCores:           totalCores,
+		SystemLoad:           systemLoad,
+		LimitsActive:         limitsActive,
+		LimitedUsersCount:    limitedUsersCount,
+		Timestamp:            time.Now(),
+	}})
+}
+
+// enqueue inserisce un record in coda senza bloccare; se la coda è piena il
+// record viene scartato
+func (w *DBWriter) enqueue(item dbWriteItem) {
 	w.mu.RLock()
-	if !w.enabled {
+	if !w.enabled || w.closed || w.dbManager == nil {
 		w.mu.RUnlock()
 		return
 	}
+	observer := w.observer
+
+	select {
+	case w.queue <- item:
+		w.mu.RUnlock()
+	default:
+		w.mu.RUnlock()
+		w.mu.Lock()
+		w.dropped++
+		w.mu.Unlock()
+		if observer != nil {
+			observer.RecordDBWriteDrops(1)
+		}
+	}
+}
+
+// Flush chiede alla goroutine di scrivere i record accodati. Non attende il
+// commit: una richiesta già pendente copre anche questa.
+func (w *DBWriter) Flush() {
+	select {
+	case w.flushReq <- struct{}{}:
+	default:
+	}
+}
+
+// QueueDepth restituisce il numero di record in attesa di commit
+func (w *DBWriter) QueueDepth() int {
+	return len(w.queue)
+}
+
+// DroppedRecords restituisce il totale dei record scartati per coda piena
+func (w *DBWriter) DroppedRecords() uint64 {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.dropped
+}
+
+// run è il loop della goroutine di scrittura. Anche i rollup girano qui,
+// così non competono con i commit per il lock del database.
+func (w *DBWriter) run() {
+	defer close(w.done)
+	lastRollup := time.Now()
+	for {
+		select {
+		case <-w.flushReq:
+			w.commitPending()
+			if w.dbManager != nil && time.Since(lastRollup) >= dbRollupInterval {
+				lastRollup = time.Now()
+				if err := w.dbManager.Rollup(lastRollup); err != nil {
+					w.logger.Warn("Failed to roll up metrics database", "error", err)
+				}
+			}
+		case <-w.stop:
+			w.commitPending()
+			return
+		}
+	}
+}
+
+// commitPending scrive in una sola transazione i record presenti in coda
+func (w *DBWriter) commitPending() {
+	pending := len(w.queue)
+	var users []*database.UserMetricsRecord
+	var systems []*database.SystemMetricsRecord
+	var memoryEvents []*database.UserMemoryEventsRecord
+	for i := 0; i < pending; i++ {
+		item := <-w.queue
+		switch {
+		case item.user != nil:
+			users = append(users, item.user)
+		case item.system != nil:
+			systems = append(systems, item.system)
+		case item.memoryEvents != nil:
+			memoryEvents = append(memoryEvents, item.memoryEvents)
+		}
+	}
+
+	w.mu.RLock()
+	observer := w.observer
 	w.mu.RUnlock()
 
-	if w.dbManager == nil {
+	if pending == 0 {
+		if observer != nil {
+			observer.UpdateDBWriteQueueDepth(len(w.queue))
+		}
 		return
 	}
 
-	record := &database.SystemMetricsRecord{
-		TotalCPUUsagePercent: totalCPUUsage,
-		TotalCores:           totalCores,
-		SystemLoad:           systemLoad,
-		LimitsActive:         limitsActive,
-		LimitedUsersCount:    limitedUsersCount,
-		Timestamp:            time.Now(),
+	start := time.Now()
+	err := w.dbManager.WriteMetricsBatch(systems, users, memoryEvents)
+	duration := time.Since(start)
+	if err != nil {
+		w.logger.Warn("Failed to write metrics batch to database", "records", pending, "error", err)
+	} else {
+		w.logger.Debug("Metrics batch written to database", "users", len(users), "system", len(systems),
+			"memory_events", len(memoryEvents), "duration", duration)
 	}
 
-	if err := w.dbManager.WriteSystemMetrics(record); err != nil {
-		w.logger.Debug("Failed to write system metrics to database", "error", err)
+	if observer != nil {
+		if err == nil {
+			observer.RecordDBWriteCommit(duration, pending)
+		}
+		observer.UpdateDBWriteQueueDepth(len(w.queue))
 	}
 }
 
@@ -109,7 +260,7 @@ func (w *DBWriter) ShouldWrite() bool {
 	w.mu.RLock()
 	defer w.mu.RUnlock()
 
-	if !w.enabled {
+	if !w.enabled || w.closed {
 		return false
 	}
 
@@ -130,10 +281,19 @@ func (w *DBWriter) SetEnabled(enabled bool) {
 	w.enabled = enabled
 }
 
-// Close chiude il DBWriter
+// Close scrive i record ancora in coda e ferma la goroutine. Va chiamato
+// prima di chiudere il DatabaseManager.
 func (w *DBWriter) Close() error {
 	w.mu.Lock()
-	defer w.mu.Unlock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
 	w.enabled = false
+	w.mu.Unlock()
+
+	close(w.stop)
+	<-w.done
 	return nil
 }
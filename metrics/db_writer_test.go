@@ -0,0 +1,140 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+// metrics/db_writer_test.go
+package metrics
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fdefilippo/resman/database"
+)
+
+type fakeDBWriteObserver struct {
+	mu      sync.Mutex
+	drops   int
+	commits int
+	records int
+	done    chan struct{}
+}
+
+func (o *fakeDBWriteObserver) UpdateDBWriteQueueDepth(depth int) {}
+
+func (o *fakeDBWriteObserver) RecordDBWriteDrops(count int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.drops += count
+}
+
+func (o *fakeDBWriteObserver) RecordDBWriteCommit(duration time.Duration, records int) {
+	o.mu.Lock()
+	o.commits++
+	o.records += records
+	o.mu.Unlock()
+	if o.done != nil {
+		o.done <- struct{}{}
+	}
+}
+
+func newTestDBWriterManager(t *testing.T) *database.DatabaseManager {
+	t.Helper()
+	dbManager, err := database.NewDatabaseManager(filepath.Join(t.TempDir(), "metrics.db"))
+	if err != nil {
+		t.Fatalf("Failed to create database manager: %v", err)
+	}
+	t.Cleanup(func() { dbManager.Close() })
+	return dbManager
+}
+
+func TestDBWriterBatchesCycle(t *testing.T) {
+	dbManager := newTestDBWriterManager(t)
+	writer := NewDBWriter(dbManager, 60, 100)
+	defer writer.Close()
+
+	observer := &fakeDBWriteObserver{done: make(chan struct{}, 1)}
+	writer.SetObserver(observer)
+
+	writer.WriteSystemMetrics(50, 4, 0, true, 2)
+	writer.WriteUserMetrics(1000, "alice", 30, 1024, 3, true, "", "")
+	writer.WriteUserMetrics(1001, "bob", 20, 2048, 5, true, "", "")
+	writer.WriteUserMemoryEvents(&database.UserMemoryEventsRecord{UID: 1001, Username: "bob", OOMKill: 1})
+	writer.Flush()
+
+	select {
+	case <-observer.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("batch was not committed")
+	}
+
+	if observer.commits != 1 || observer.records != 4 {
+		t.Errorf("commits/records = %d/%d, expected 1/4", observer.commits, observer.records)
+	}
+	if depth := writer.QueueDepth(); depth != 0 {
+		t.Errorf("QueueDepth() = %d after commit, expected 0", depth)
+	}
+
+	from := time.Now().Add(-time.Hour)
+	to := time.Now().Add(time.Hour)
+	history, err := dbManager.GetUserHistory(1001, from, to, 10)
+	if err != nil || len(history) != 1 || history[0].ProcessCount != 5 {
+		t.Errorf("GetUserHistory(1001) = %+v, %v; expected one record with 5 processes", history, err)
+	}
+	system, err := dbManager.GetSystemHistory(from, to, 10)
+	if err != nil || len(system) != 1 {
+		t.Errorf("GetSystemHistory() = %d records, %v; expected 1", len(system), err)
+	}
+	events, err := dbManager.GetUserMemoryEvents(1001, from, to, 10)
+	if err != nil || len(events) != 1 || events[0].OOMKill != 1 {
+		t.Errorf("GetUserMemoryEvents(1001) = %+v, %v; expected one record with 1 oom_kill", events, err)
+	}
+}
+
+func TestDBWriterDropsOnOverflow(t *testing.T) {
+	dbManager := newTestDBWriterManager(t)
+	writer := NewDBWriter(dbManager, 60, 2)
+
+	observer := &fakeDBWriteObserver{}
+	writer.SetObserver(observer)
+
+	// Senza Flush la goroutine non svuota la coda: oltre la capacità si scarta
+	for uid := 1000; uid < 1005; uid++ {
+		writer.WriteUserMetrics(uid, "user", 10, 0, 1, false, "", "")
+	}
+
+	if dropped := writer.DroppedRecords(); dropped != 3 {
+		t.Errorf("DroppedRecords() = %d, expected 3", dropped)
+	}
+	if observer.drops != 3 {
+		t.Errorf("observer drops = %d, expected 3", observer.drops)
+	}
+
+	// Close scrive i record rimasti in coda
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+	if observer.records != 2 {
+		t.Errorf("records committed on Close = %d, expected 2", observer.records)
+	}
+
+	// Dopo Close i record vengono ignorati
+	writer.WriteUserMetrics(2000, "late", 10, 0, 1, false, "", "")
+	if depth := writer.QueueDepth(); depth != 0 {
+		t.Errorf("QueueDepth() after Close = %d, expected 0", depth)
+	}
+}
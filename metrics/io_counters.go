@@ -0,0 +1,150 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+// metrics/io_counters.go
+package metrics
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ioCounterSample sono i byte letti e scritti da un cgroup o da un processo.
+type ioCounterSample struct {
+	readBytes  uint64
+	writeBytes uint64
+}
+
+// ioSweepSample e' la lettura di una sorgente di IO in un ciclo.
+type ioSweepSample struct {
+	uid   int
+	proc  bool // /proc/<pid>/io invece di io.stat di un cgroup
+	stale bool // cgroup non letto in questo ciclo: tiene la lettura precedente
+	ioCounterSample
+}
+
+// ioSweep raccoglie le letture di un ciclo, chiave per sorgente: il path del
+// cgroup o pid/starttime del processo.
+type ioSweep map[string]ioSweepSample
+
+// addCgroup registra io.stat di un cgroup dell'utente.
+func (s ioSweep) addCgroup(uid int, path string, sample ioCounterSample) {
+	s[path] = ioSweepSample{uid: uid, ioCounterSample: sample}
+}
+
+// addStaleCgroup registra un cgroup dell'utente che esiste ma non e' stato
+// letto in questo ciclo: non addebita nulla e non perde la lettura precedente.
+func (s ioSweep) addStaleCgroup(uid int, path string) {
+	s[path] = ioSweepSample{uid: uid, stale: true}
+}
+
+// addProc registra /proc/<pid>/io di un processo. createTime distingue un
+// PID riusato dal processo precedente.
+func (s ioSweep) addProc(uid, pid int, createTime int64, sample ioCounterSample) {
+	s[fmt.Sprintf("pid/%d/%d", pid, createTime)] = ioSweepSample{uid: uid, proc: true, ioCounterSample: sample}
+}
+
+// ioCounterCache accumula per utente contatori IO monotoni dall'avvio del
+// demone. Le somme dei contatori su processi vivi o su piu' cgroup scendono
+// quando un processo termina o un cgroup viene rimosso; qui ogni sorgente
+// contribuisce solo il proprio incremento e una sorgente sparita smette
+// semplicemente di contribuire.
+type ioCounterCache struct {
+	mu         sync.Mutex
+	primed     bool
+	prev       map[string]ioCounterSample
+	cgroupUIDs map[int]bool // utenti con IO da io.stat nell'ultimo ciclo
+	totals     map[int]ioCounterSample
+}
+
+// counterIncrement e' l'incremento di un contatore della stessa sorgente. Un
+// contatore sceso appartiene a un cgroup ricreato: conta da zero.
+func counterIncrement(prev, cur uint64) uint64 {
+	if cur >= prev {
+		return cur - prev
+	}
+	return cur
+}
+
+// commit aggiunge ai totali gli incrementi del ciclo e restituisce una copia
+// dei totali per utente.
+//
+// Una sorgente mai vista conta tutto il suo valore se e' nata dopo il ciclo
+// precedente: un cgroup appena creato, o un processo di un utente il cui IO
+// era gia' letto da /proc. Al primo ciclo, e per i processi di un utente che
+// passa da io.stat a /proc, il valore e' storia gia' contata (o anteriore
+// all'avvio) e fa solo da base.
+func (c *ioCounterCache) commit(sweep ioSweep) map[int]ioCounterSample {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cgroupUIDs := make(map[int]bool)
+	next := make(map[string]ioCounterSample, len(sweep))
+	for key, sample := range sweep {
+		if sample.stale {
+			if prev, ok := c.prev[key]; ok {
+				next[key] = prev
+			}
+			continue
+		}
+		next[key] = sample.ioCounterSample
+		var delta ioCounterSample
+		if prev, ok := c.prev[key]; ok {
+			delta.readBytes = counterIncrement(prev.readBytes, sample.readBytes)
+			delta.writeBytes = counterIncrement(prev.writeBytes, sample.writeBytes)
+		} else if c.primed && (!sample.proc || !c.cgroupUIDs[sample.uid]) {
+			delta = sample.ioCounterSample
+		}
+		total := c.totals[sample.uid]
+		total.readBytes += delta.readBytes
+		total.writeBytes += delta.writeBytes
+		c.totals[sample.uid] = total
+		if !sample.proc {
+			cgroupUIDs[sample.uid] = true
+		}
+	}
+
+	// Un cgroup assente dalla ricerca per un ciclo ma ancora presente tiene
+	// la lettura precedente; i processi assenti sono terminati
+	for key, prev := range c.prev {
+		if _, ok := next[key]; ok || strings.HasPrefix(key, "pid/") {
+			continue
+		}
+		if _, err := os.Stat(key); err == nil {
+			next[key] = prev
+		}
+	}
+	c.prev = next
+	c.cgroupUIDs = cgroupUIDs
+	c.primed = true
+
+	totals := make(map[int]ioCounterSample, len(c.totals))
+	for uid, total := range c.totals {
+		totals[uid] = total
+	}
+	return totals
+}
+
+// mergeIOTotals copia i contatori monotoni negli utenti del ciclo
+func mergeIOTotals(tempData map[int]*userData, totals map[int]ioCounterSample) {
+	for uid, data := range tempData {
+		total := totals[uid]
+		data.ioReadTotal = total.readBytes
+		data.ioWriteTotal = total.writeBytes
+	}
+}
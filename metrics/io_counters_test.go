@@ -0,0 +1,102 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+package metrics
+
+import "testing"
+
+func TestIOCountersProcessExit(t *testing.T) {
+	counters := &ioCounterCache{totals: make(map[int]ioCounterSample)}
+
+	// Primo ciclo: l'IO gia' fatto prima dell'avvio non viene addebitato
+	sweep := make(ioSweep)
+	sweep.addProc(1000, 10, 100, ioCounterSample{readBytes: 1 << 20, writeBytes: 4096})
+	sweep.addProc(1000, 11, 100, ioCounterSample{readBytes: 1000})
+	if total := counters.commit(sweep)[1000]; total != (ioCounterSample{}) {
+		t.Fatalf("first sweep charged %+v, expected a baseline only", total)
+	}
+
+	// Il processo 10 termina: la somma sui vivi scende, il totale no. Il
+	// processo 12 e' nato nel frattempo e conta tutto il suo IO.
+	sweep = make(ioSweep)
+	sweep.addProc(1000, 11, 100, ioCounterSample{readBytes: 1500})
+	sweep.addProc(1000, 12, 200, ioCounterSample{readBytes: 300, writeBytes: 100})
+	total := counters.commit(sweep)[1000]
+	if total.readBytes != 800 || total.writeBytes != 100 {
+		t.Errorf("after process exit total = %+v, expected 800 read / 100 written", total)
+	}
+
+	// PID 11 riusato da un nuovo processo: e' una sorgente diversa
+	sweep = make(ioSweep)
+	sweep.addProc(1000, 11, 300, ioCounterSample{readBytes: 50})
+	sweep.addProc(1000, 12, 200, ioCounterSample{readBytes: 300, writeBytes: 100})
+	if total := counters.commit(sweep)[1000]; total.readBytes != 850 {
+		t.Errorf("after PID reuse read total = %d, expected 850", total.readBytes)
+	}
+}
+
+func TestIOCountersCgroupRemoval(t *testing.T) {
+	counters := &ioCounterCache{totals: make(map[int]ioCounterSample)}
+	limited := "/sys/fs/cgroup/resman/limited/user_1000"
+	slice := "/sys/fs/cgroup/user.slice/user-1000.slice"
+
+	sweep := make(ioSweep)
+	sweep.addCgroup(1000, limited, ioCounterSample{readBytes: 5000})
+	sweep.addCgroup(1000, slice, ioCounterSample{readBytes: 1 << 30})
+	counters.commit(sweep)
+
+	sweep = make(ioSweep)
+	sweep.addCgroup(1000, limited, ioCounterSample{readBytes: 6000})
+	sweep.addCgroup(1000, slice, ioCounterSample{readBytes: 1<<30 + 10})
+	if total := counters.commit(sweep)[1000]; total.readBytes != 1010 {
+		t.Fatalf("read total = %d, expected 1010", total.readBytes)
+	}
+
+	// Il cgroup limitato viene rimosso: la slice non viene riaddebitata
+	sweep = make(ioSweep)
+	sweep.addCgroup(1000, slice, ioCounterSample{readBytes: 1<<30 + 20})
+	if total := counters.commit(sweep)[1000]; total.readBytes != 1020 {
+		t.Errorf("read total after cgroup removal = %d, expected 1020", total.readBytes)
+	}
+
+	// Senza io.stat l'utente passa a /proc: i processi gia' vivi fanno da base
+	sweep = make(ioSweep)
+	sweep.addProc(1000, 42, 100, ioCounterSample{readBytes: 1 << 20})
+	if total := counters.commit(sweep)[1000]; total.readBytes != 1020 {
+		t.Errorf("read total after switching to /proc = %d, expected 1020", total.readBytes)
+	}
+}
+
+func TestIOCountersReadError(t *testing.T) {
+	counters := &ioCounterCache{totals: make(map[int]ioCounterSample)}
+	slice := "/sys/fs/cgroup/user.slice/user-1000.slice"
+
+	sweep := make(ioSweep)
+	sweep.addCgroup(1000, slice, ioCounterSample{readBytes: 1 << 30})
+	counters.commit(sweep)
+
+	// io.stat illeggibile per un ciclo: alla ripresa conta solo l'incremento
+	sweep = make(ioSweep)
+	sweep.addStaleCgroup(1000, slice)
+	if total := counters.commit(sweep)[1000]; total.readBytes != 0 {
+		t.Errorf("read total with a failed read = %d, expected 0", total.readBytes)
+	}
+	sweep = make(ioSweep)
+	sweep.addCgroup(1000, slice, ioCounterSample{readBytes: 1<<30 + 10})
+	if total := counters.commit(sweep)[1000]; total.readBytes != 10 {
+		t.Errorf("read total after the read recovered = %d, expected 10", total.readBytes)
+	}
+}
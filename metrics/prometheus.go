@@ -79,6 +79,14 @@ type PrometheusExporter struct {
 	totalCores   prometheus.Gauge
 	actionCores  prometheus.Gauge
 
+	// Stato di attivazione per risorsa (cpu, ram, io)
+	resourceLimitsActive     *prometheus.GaugeVec
+	resourceLimitTransitions *prometheus.CounterVec
+
+	// DRY_RUN: scritture cgroup pianificate ma non eseguite
+	dryRunEnabled       prometheus.Gauge
+	dryRunPlannedWrites *prometheus.CounterVec
+
 	// Metriche con label aggiuntive
 	userCPUUsage         *prometheus.GaugeVec
 	userCPUUsageAverage  *prometheus.GaugeVec
@@ -92,10 +100,40 @@ type PrometheusExporter struct {
 	userIOReadOps        *prometheus.CounterVec
 	userIOWriteOps       *prometheus.CounterVec
 	userWorkloadPattern  *prometheus.GaugeVec
+	userCPUWeight        *prometheus.GaugeVec
 	cgroupCPUQuota       *prometheus.GaugeVec
 	cgroupCPUPeriod      *prometheus.GaugeVec
 	cgroupMemoryUsage    *prometheus.GaugeVec
 
+	// Sorgente della misura CPU per utente (proc, cgroup, user_slice)
+	userCPUSource      *prometheus.GaugeVec
+	prevUserCPUSources map[string]string // "uid_username" -> previous source label
+
+	// Processi di utenti limitati nati fuori dal loro cgroup (result: moved, failed)
+	userProcessEscapes *prometheus.CounterVec
+
+	// Remediation IO adattiva: moltiplicatore corrente e passi del controller
+	userIOBoostMultiplier *prometheus.GaugeVec
+	userIOBoostSteps      *prometheus.CounterVec
+
+	// IO per disco (direction: read, write)
+	userIODeviceBytes *prometheus.CounterVec
+	userIODeviceOps   *prometheus.CounterVec
+	prevIODeviceStats map[string]ioStatsSnapshot // "uid_username_device" -> previous io.stat values
+
+	// RAM_DYNAMIC_HIGH: memory.high corrente e modifiche del controller
+	userMemoryHigh            *prometheus.GaugeVec
+	userMemoryHighAdjustments *prometheus.CounterVec
+
+	// memory.events (contatori) e memory.stat (byte per tipo) dei cgroup utente
+	userMemoryEvents     *prometheus.CounterVec
+	userMemoryStat       *prometheus.GaugeVec
+	prevUserMemoryEvents map[string]uint64 // "uid_username_event" -> previous memory.events value
+
+	// IO_LIMIT_MODE=weight: io.weight per utente ed esito della verifica
+	userIOWeight  *prometheus.GaugeVec
+	ioWeightCheck *prometheus.GaugeVec
+
 	// Track utenti attivi per cleanup metriche
 	activeUserMetrics    map[string]bool   // "uid_username" -> true
 	prevMemoryHighEvents map[string]uint64 // "uid_username" -> last known value
@@ -115,6 +153,11 @@ type PrometheusExporter struct {
 	controlCycleDuration      prometheus.Histogram
 	metricsCollectionDuration prometheus.Histogram
 
+	// Writer del database metriche: coda, scarti e latenza dei commit
+	dbWriteQueueDepth     prometheus.Gauge
+	dbWriteDroppedTotal   prometheus.Counter
+	dbWriteCommitDuration prometheus.Histogram
+
 	// Cache per evitare aggiornamenti troppo frequenti
 	lastUpdate     time.Time
 	updateInterval time.Duration
@@ -175,6 +218,10 @@ func NewPrometheusExporter(cfg *config.Config) (*PrometheusExporter, error) {
 		prevMemoryHighEvents: make(map[string]uint64),
 		prevIOStats:          make(map[string]ioStatsSnapshot),
 		prevUserPatterns:     make(map[string]string),
+		prevUserCPUSources:   make(map[string]string),
+		prevIODeviceStats:    make(map[string]ioStatsSnapshot),
+
+		prevUserMemoryEvents: make(map[string]uint64),
 	}
 
 	logger.Info("Prometheus exporter created",
@@ -371,6 +418,43 @@ func (exp *PrometheusExporter) registerMetrics() error {
 		ConstLabels: staticLabels,
 	})
 
+	exp.resourceLimitsActive = promauto.With(exp.registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "resource_limits_active",
+			Help:        "Whether limits for a resource (cpu, ram, io) are currently active (1) or not (0)",
+			ConstLabels: staticLabels,
+		},
+		[]string{"resource"},
+	)
+
+	exp.resourceLimitTransitions = promauto.With(exp.registry).NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "resource_limit_transitions_total",
+			Help:        "Total number of activations/deactivations of limits per resource",
+			ConstLabels: staticLabels,
+		},
+		[]string{"resource", "action"},
+	)
+
+	exp.dryRunEnabled = promauto.With(exp.registry).NewGauge(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Name:        "dry_run_enabled",
+		Help:        "Whether dry-run mode is enabled (1): cgroup writes are only recorded",
+		ConstLabels: staticLabels,
+	})
+
+	exp.dryRunPlannedWrites = promauto.With(exp.registry).NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "dry_run_planned_writes_total",
+			Help:        "Total number of cgroup writes planned but not executed in dry-run mode",
+			ConstLabels: staticLabels,
+		},
+		[]string{"operation"},
+	)
+
 	exp.systemLoad = promauto.With(exp.registry).NewGauge(prometheus.GaugeOpts{
 		Namespace:   namespace,
 		Name:        "system_load_average",
@@ -516,6 +600,136 @@ func (exp *PrometheusExporter) registerMetrics() error {
 		[]string{"uid", "username", "pattern"},
 	)
 
+	exp.userCPUSource = promauto.With(exp.registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "user_cpu_accounting_source",
+			Help:        "Source used for the user CPU usage (1 on the active source: proc, cgroup or user_slice)",
+			ConstLabels: staticLabels,
+		},
+		[]string{"uid", "username", "source"},
+	)
+
+	exp.userProcessEscapes = promauto.With(exp.registry).NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "user_process_escapes_total",
+			Help:        "Processes of a limited user found outside their cgroup (result: moved back or failed)",
+			ConstLabels: staticLabels,
+		},
+		[]string{"uid", "username", "result"},
+	)
+
+	exp.userIOBoostMultiplier = promauto.With(exp.registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "user_io_boost_multiplier",
+			Help:        "Current IO boost multiplier applied to a starved user (1 = no boost)",
+			ConstLabels: staticLabels,
+		},
+		[]string{"uid", "username"},
+	)
+
+	exp.userIOBoostSteps = promauto.With(exp.registry).NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "user_io_boost_steps_total",
+			Help:        "Steps taken by the adaptive IO remediation controller, by action and reason",
+			ConstLabels: staticLabels,
+		},
+		[]string{"uid", "username", "action", "reason"},
+	)
+
+	exp.userIODeviceBytes = promauto.With(exp.registry).NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "user_io_device_bytes_total",
+			Help:        "Bytes transferred by user per block device (direction: read, write)",
+			ConstLabels: staticLabels,
+		},
+		[]string{"uid", "username", "device", "direction"},
+	)
+
+	exp.userIODeviceOps = promauto.With(exp.registry).NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "user_io_device_ops_total",
+			Help:        "IO operations by user per block device (direction: read, write)",
+			ConstLabels: staticLabels,
+		},
+		[]string{"uid", "username", "device", "direction"},
+	)
+
+	exp.userCPUWeight = promauto.With(exp.registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "user_cpu_weight",
+			Help:        "cpu.weight assigned to a limited user (fair-share or flat 100)",
+			ConstLabels: staticLabels,
+		},
+		[]string{"uid", "username"},
+	)
+
+	exp.userMemoryHigh = promauto.With(exp.registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "user_memory_high_bytes",
+			Help:        "memory.high set by the dynamic memory controller (RAM_DYNAMIC_HIGH)",
+			ConstLabels: staticLabels,
+		},
+		[]string{"uid", "username"},
+	)
+
+	exp.userMemoryHighAdjustments = promauto.With(exp.registry).NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "user_memory_high_adjustments_total",
+			Help:        "memory.high changes by the dynamic memory controller (action: set, lower, raise)",
+			ConstLabels: staticLabels,
+		},
+		[]string{"uid", "username", "action", "reason"},
+	)
+
+	exp.userMemoryEvents = promauto.With(exp.registry).NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "user_memory_events_total",
+			Help:        "memory.events of the user cgroup (event: low, high, max, oom, oom_kill, oom_group_kill)",
+			ConstLabels: staticLabels,
+		},
+		[]string{"uid", "username", "event"},
+	)
+
+	exp.userMemoryStat = promauto.With(exp.registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "user_memory_stat_bytes",
+			Help:        "memory.stat of the user cgroup in bytes (type: anon, file, shmem, swap)",
+			ConstLabels: staticLabels,
+		},
+		[]string{"uid", "username", "type"},
+	)
+
+	exp.userIOWeight = promauto.With(exp.registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "user_io_weight",
+			Help:        "io.weight assigned to a limited user (IO_LIMIT_MODE=weight)",
+			ConstLabels: staticLabels,
+		},
+		[]string{"uid", "username"},
+	)
+
+	exp.ioWeightCheck = promauto.With(exp.registry).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "io_weight_check",
+			Help:        "Result of the last io.weight effectiveness check (1 on the current result: idle, effective, ineffective)",
+			ConstLabels: staticLabels,
+		},
+		[]string{"result"},
+	)
+
 	exp.cgroupCPUQuota = promauto.With(exp.registry).NewGaugeVec(
 		prometheus.GaugeOpts{
 			Namespace: namespace,
@@ -619,6 +833,30 @@ func (exp *PrometheusExporter) registerMetrics() error {
 		Buckets:   []float64{.001, .005, .01, .025, .05, .1, .25, .5},
 	})
 
+	// === Writer database metriche ===
+
+	exp.dbWriteQueueDepth = promauto.With(exp.registry).NewGauge(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Name:        "db_write_queue_depth",
+		Help:        "Number of metrics records waiting to be committed to the database",
+		ConstLabels: staticLabels,
+	})
+
+	exp.dbWriteDroppedTotal = promauto.With(exp.registry).NewCounter(prometheus.CounterOpts{
+		Namespace:   namespace,
+		Name:        "db_write_dropped_total",
+		Help:        "Total number of metrics records dropped because the database write queue was full",
+		ConstLabels: staticLabels,
+	})
+
+	exp.dbWriteCommitDuration = promauto.With(exp.registry).NewHistogram(prometheus.HistogramOpts{
+		Namespace:   namespace,
+		Name:        "db_commit_duration_seconds",
+		Help:        "Duration of metrics database batch commits in seconds",
+		Buckets:     []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+		ConstLabels: staticLabels,
+	})
+
 	return nil
 }
 
@@ -862,6 +1100,29 @@ func (exp *PrometheusExporter) CleanupUserMetrics(activeUids map[int]bool) {
 			delete(exp.prevMemoryHighEvents, memoryHighKey)
 			delete(exp.prevIOStats, memoryHighKey)
 			delete(exp.prevUserPatterns, userKey)
+			if prevSource, ok := exp.prevUserCPUSources[userKey]; ok {
+				exp.userCPUSource.DeleteLabelValues(uidStr, username, prevSource)
+				delete(exp.prevUserCPUSources, userKey)
+			}
+			exp.userProcessEscapes.DeletePartialMatch(prometheus.Labels{"uid": uidStr, "username": username})
+			exp.userIOBoostMultiplier.DeleteLabelValues(uidStr, username)
+			exp.userIOBoostSteps.DeletePartialMatch(prometheus.Labels{"uid": uidStr, "username": username})
+			exp.userIODeviceBytes.DeletePartialMatch(prometheus.Labels{"uid": uidStr, "username": username})
+			exp.userIODeviceOps.DeletePartialMatch(prometheus.Labels{"uid": uidStr, "username": username})
+			exp.userMemoryHigh.DeleteLabelValues(uidStr, username)
+			exp.userMemoryHighAdjustments.DeletePartialMatch(prometheus.Labels{"uid": uidStr, "username": username})
+			for key := range exp.prevIODeviceStats {
+				if strings.HasPrefix(key, userKey+"_") {
+					delete(exp.prevIODeviceStats, key)
+				}
+			}
+			exp.userMemoryEvents.DeletePartialMatch(prometheus.Labels{"uid": uidStr, "username": username})
+			exp.userMemoryStat.DeletePartialMatch(prometheus.Labels{"uid": uidStr, "username": username})
+			for key := range exp.prevUserMemoryEvents {
+				if strings.HasPrefix(key, userKey+"_") {
+					delete(exp.prevUserMemoryEvents, key)
+				}
+			}
 
 			// Rimuovi dal tracking
 			delete(exp.activeUserMetrics, userKey)
@@ -924,6 +1185,210 @@ func (exp *PrometheusExporter) UpdateUserWorkloadPattern(uid int, username strin
 	exp.prevUserPatterns[userKey] = pattern
 }
 
+// UpdateUserCPUSource pubblica la sorgente usata per misurare la CPU di un utente.
+func (exp *PrometheusExporter) UpdateUserCPUSource(uid int, username string, source string) {
+	if exp == nil || exp.registry == nil || exp.userCPUSource == nil || source == "" {
+		return
+	}
+
+	uidStr := strconv.Itoa(uid)
+	if username == "" || username == uidStr {
+		username = exp.getUsernameFromUID(uidStr)
+	}
+	userKey := fmt.Sprintf("%s_%s", uidStr, username)
+
+	exp.mu.Lock()
+	defer exp.mu.Unlock()
+
+	if prevSource, ok := exp.prevUserCPUSources[userKey]; ok && prevSource != source {
+		exp.userCPUSource.DeleteLabelValues(uidStr, username, prevSource)
+	}
+
+	exp.userCPUSource.WithLabelValues(uidStr, username, source).Set(1)
+	exp.prevUserCPUSources[userKey] = source
+}
+
+// RecordProcessEscapes conta i processi di un utente limitato trovati fuori
+// dal suo cgroup, riportati dentro o non spostabili.
+func (exp *PrometheusExporter) RecordProcessEscapes(uid int, username string, moved, failed int) {
+	if exp == nil || exp.registry == nil || exp.userProcessEscapes == nil {
+		return
+	}
+
+	uidStr := strconv.Itoa(uid)
+	if username == "" || username == uidStr {
+		username = exp.getUsernameFromUID(uidStr)
+	}
+	if moved > 0 {
+		exp.userProcessEscapes.WithLabelValues(uidStr, username, "moved").Add(float64(moved))
+	}
+	if failed > 0 {
+		exp.userProcessEscapes.WithLabelValues(uidStr, username, "failed").Add(float64(failed))
+	}
+}
+
+// RecordIOBoostStep registra un passo della remediation IO adattiva e il
+// moltiplicatore risultante. A fine boost il moltiplicatore torna a 1.
+func (exp *PrometheusExporter) RecordIOBoostStep(uid int, username string, action, reason string, multiplier float64) {
+	if exp == nil || exp.registry == nil || exp.userIOBoostSteps == nil || exp.userIOBoostMultiplier == nil {
+		return
+	}
+
+	uidStr := strconv.Itoa(uid)
+	if username == "" || username == uidStr {
+		username = exp.getUsernameFromUID(uidStr)
+	}
+	exp.userIOBoostSteps.WithLabelValues(uidStr, username, action, reason).Inc()
+	exp.userIOBoostMultiplier.WithLabelValues(uidStr, username).Set(multiplier)
+}
+
+// RecordMemoryHighStep registra una modifica di memory.high decisa dal
+// controller dinamico e il nuovo valore.
+func (exp *PrometheusExporter) RecordMemoryHighStep(uid int, username string, action, reason string, high uint64) {
+	if exp == nil || exp.registry == nil || exp.userMemoryHigh == nil || exp.userMemoryHighAdjustments == nil {
+		return
+	}
+
+	uidStr := strconv.Itoa(uid)
+	if username == "" || username == uidStr {
+		username = exp.getUsernameFromUID(uidStr)
+	}
+	exp.userMemoryHighAdjustments.WithLabelValues(uidStr, username, action, reason).Inc()
+	exp.userMemoryHigh.WithLabelValues(uidStr, username).Set(float64(high))
+}
+
+// UpdateUserMemoryEvents aggiorna i counter memory.events di un utente a
+// partire dai valori cumulativi del cgroup.
+func (exp *PrometheusExporter) UpdateUserMemoryEvents(uid int, username string, events map[string]uint64) {
+	if exp == nil || exp.registry == nil || exp.userMemoryEvents == nil {
+		return
+	}
+
+	uidStr := strconv.Itoa(uid)
+	if username == "" || username == uidStr {
+		username = exp.getUsernameFromUID(uidStr)
+	}
+
+	exp.mu.Lock()
+	defer exp.mu.Unlock()
+
+	for event, value := range events {
+		// Un contatore piu' basso del precedente indica un cgroup ricreato: si riparte da zero
+		key := fmt.Sprintf("%s_%s_%s", uidStr, username, event)
+		prev := exp.prevUserMemoryEvents[key]
+		if value < prev {
+			prev = 0
+		}
+		exp.userMemoryEvents.WithLabelValues(uidStr, username, event).Add(float64(value - prev))
+		exp.prevUserMemoryEvents[key] = value
+	}
+}
+
+// UpdateUserMemoryStat pubblica i byte di memory.stat di un utente per tipo.
+func (exp *PrometheusExporter) UpdateUserMemoryStat(uid int, username string, stat map[string]uint64) {
+	if exp == nil || exp.registry == nil || exp.userMemoryStat == nil {
+		return
+	}
+
+	uidStr := strconv.Itoa(uid)
+	if username == "" || username == uidStr {
+		username = exp.getUsernameFromUID(uidStr)
+	}
+	for statType, value := range stat {
+		exp.userMemoryStat.WithLabelValues(uidStr, username, statType).Set(float64(value))
+	}
+}
+
+// UpdateUserIODeviceStats aggiorna i counter IO di un utente su un disco a
+// partire dai valori cumulativi di io.stat.
+func (exp *PrometheusExporter) UpdateUserIODeviceStats(uid int, username string, device string, readBytes, writeBytes, readOps, writeOps uint64) {
+	if exp == nil || exp.registry == nil || exp.userIODeviceBytes == nil || exp.userIODeviceOps == nil {
+		return
+	}
+
+	uidStr := strconv.Itoa(uid)
+	if username == "" || username == uidStr {
+		username = exp.getUsernameFromUID(uidStr)
+	}
+
+	exp.mu.Lock()
+	defer exp.mu.Unlock()
+
+	// Un contatore piu' basso del precedente indica un cgroup ricreato: si riparte da zero
+	key := fmt.Sprintf("%s_%s_%s", uidStr, username, device)
+	prev := exp.prevIODeviceStats[key]
+	if readBytes >= prev.ReadBytes {
+		exp.userIODeviceBytes.WithLabelValues(uidStr, username, device, "read").Add(float64(readBytes - prev.ReadBytes))
+	}
+	if writeBytes >= prev.WriteBytes {
+		exp.userIODeviceBytes.WithLabelValues(uidStr, username, device, "write").Add(float64(writeBytes - prev.WriteBytes))
+	}
+	if readOps >= prev.ReadOps {
+		exp.userIODeviceOps.WithLabelValues(uidStr, username, device, "read").Add(float64(readOps - prev.ReadOps))
+	}
+	if writeOps >= prev.WriteOps {
+		exp.userIODeviceOps.WithLabelValues(uidStr, username, device, "write").Add(float64(writeOps - prev.WriteOps))
+	}
+	exp.prevIODeviceStats[key] = ioStatsSnapshot{
+		ReadBytes:  readBytes,
+		WriteBytes: writeBytes,
+		ReadOps:    readOps,
+		WriteOps:   writeOps,
+	}
+}
+
+// UpdateUserCPUWeights pubblica il cpu.weight corrente degli utenti limitati.
+// Il gauge viene azzerato a ogni chiamata, cosi' gli utenti rilasciati spariscono.
+func (exp *PrometheusExporter) UpdateUserCPUWeights(weights map[int]int) {
+	if exp == nil || exp.registry == nil || exp.userCPUWeight == nil {
+		return
+	}
+
+	exp.mu.Lock()
+	defer exp.mu.Unlock()
+
+	exp.userCPUWeight.Reset()
+	for uid, weight := range weights {
+		uidStr := strconv.Itoa(uid)
+		username := exp.getUsernameFromUID(uidStr)
+		exp.userCPUWeight.WithLabelValues(uidStr, username).Set(float64(weight))
+	}
+}
+
+// UpdateUserIOWeights pubblica l'io.weight corrente degli utenti limitati.
+// Il gauge viene azzerato a ogni chiamata, cosi' gli utenti rilasciati spariscono.
+func (exp *PrometheusExporter) UpdateUserIOWeights(weights map[int]int) {
+	if exp == nil || exp.registry == nil || exp.userIOWeight == nil {
+		return
+	}
+
+	exp.mu.Lock()
+	defer exp.mu.Unlock()
+
+	exp.userIOWeight.Reset()
+	for uid, weight := range weights {
+		uidStr := strconv.Itoa(uid)
+		username := exp.getUsernameFromUID(uidStr)
+		exp.userIOWeight.WithLabelValues(uidStr, username).Set(float64(weight))
+	}
+}
+
+// RecordIOWeightCheck esporta l'esito dell'ultima verifica dei pesi IO:
+// 1 sull'esito corrente, 0 sugli altri.
+func (exp *PrometheusExporter) RecordIOWeightCheck(result string) {
+	if exp == nil || exp.ioWeightCheck == nil {
+		return
+	}
+
+	for _, r := range []string{"idle", "effective", "ineffective"} {
+		value := 0.0
+		if r == result {
+			value = 1.0
+		}
+		exp.ioWeightCheck.WithLabelValues(r).Set(value)
+	}
+}
+
 // parseCPUQuota estrae quota e period da una stringa "quota period".
 func parseCPUQuota(quotaStr string) (quota int64, period int64) {
 	parts := strings.Fields(quotaStr)
@@ -1016,6 +1481,43 @@ func (exp *PrometheusExporter) IncrementLimitsDeactivated() {
 	exp.limitsDeactivatedTotal.Inc()
 }
 
+// RecordResourceLimitState registra l'attivazione o la disattivazione dei
+// limiti di una singola risorsa (cpu, ram, io).
+func (exp *PrometheusExporter) RecordResourceLimitState(resource string, active bool) {
+	if exp == nil || exp.resourceLimitsActive == nil {
+		return
+	}
+
+	action := "deactivated"
+	value := 0.0
+	if active {
+		action = "activated"
+		value = 1.0
+	}
+	exp.resourceLimitsActive.WithLabelValues(resource).Set(value)
+	exp.resourceLimitTransitions.WithLabelValues(resource, action).Inc()
+}
+
+// SetDryRunEnabled esporta se la modalita' DRY_RUN e' attiva.
+func (exp *PrometheusExporter) SetDryRunEnabled(enabled bool) {
+	if exp == nil || exp.dryRunEnabled == nil {
+		return
+	}
+	value := 0.0
+	if enabled {
+		value = 1.0
+	}
+	exp.dryRunEnabled.Set(value)
+}
+
+// RecordDryRunWrite conta una scrittura cgroup pianificata in modalita' DRY_RUN.
+func (exp *PrometheusExporter) RecordDryRunWrite(operation string) {
+	if exp == nil || exp.dryRunPlannedWrites == nil {
+		return
+	}
+	exp.dryRunPlannedWrites.WithLabelValues(operation).Inc()
+}
+
 // RecordControlCycleTrigger registra la causa che ha avviato un ciclo di controllo.
 func (exp *PrometheusExporter) RecordControlCycleTrigger(trigger string) {
 	if exp == nil || exp.controlCycleTriggers == nil {
@@ -1061,6 +1563,30 @@ func (exp *PrometheusExporter) RecordMetricsCollectionDuration(duration time.Dur
 	exp.metricsCollectionDuration.Observe(duration.Seconds())
 }
 
+// UpdateDBWriteQueueDepth aggiorna il numero di record in attesa di commit.
+func (exp *PrometheusExporter) UpdateDBWriteQueueDepth(depth int) {
+	if exp == nil || exp.dbWriteQueueDepth == nil {
+		return
+	}
+	exp.dbWriteQueueDepth.Set(float64(depth))
+}
+
+// RecordDBWriteDrops conta i record scartati per coda del writer piena.
+func (exp *PrometheusExporter) RecordDBWriteDrops(count int) {
+	if exp == nil || exp.dbWriteDroppedTotal == nil || count <= 0 {
+		return
+	}
+	exp.dbWriteDroppedTotal.Add(float64(count))
+}
+
+// RecordDBWriteCommit registra la durata di un commit batch del database metriche.
+func (exp *PrometheusExporter) RecordDBWriteCommit(duration time.Duration, records int) {
+	if exp == nil || exp.dbWriteCommitDuration == nil {
+		return
+	}
+	exp.dbWriteCommitDuration.Observe(duration.Seconds())
+}
+
 // RecordError incrementa il contatore errori per un componente specifico.
 func (exp *PrometheusExporter) RecordError(component, errorType string) {
 	if exp == nil {
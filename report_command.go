@@ -0,0 +1,227 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+// report_command.go
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"strconv"
+	"time"
+
+	"github.com/fdefilippo/resman/config"
+	"github.com/fdefilippo/resman/database"
+)
+
+const reportUsage = `Usage: resman report [--config FILE] [--period PERIOD | --from DAY --to DAY]
+                     [--user NAME|UID] [--group NAME] [--by day|user|group]
+                     [--format csv|json]
+
+  Print CPU-hours, memory GB-hours and IO bytes per user, integrated per day
+  from the metrics database (METRICS_DB_ENABLED=true).
+
+  --period   today, yesterday, this_week, this_month, last_7_days,
+             last_30_days, now-30d, ... (default this_month)
+  --from/--to  first and last day, YYYY-MM-DD (override --period)
+  --user     only this user
+  --group    only users of GROUP_<name> ("ungrouped" for the rest)
+  --by       one row per user and day, per user or per group (default user)
+  --format   csv or json (default csv)
+`
+
+// reportColumns sono le colonne dell'output CSV
+var reportColumns = []string{
+	"day", "uid", "username", "group", "users",
+	"cpu_seconds", "cpu_hours", "memory_byte_seconds", "memory_gb_hours",
+	"io_read_bytes", "io_write_bytes",
+	"samples", "covered_seconds", "gap_seconds", "counter_resets",
+}
+
+// runReportCommand esegue "resman report" e restituisce il codice di uscita.
+func runReportCommand(args []string) int {
+	return runReport(args, os.Stdout)
+}
+
+func runReport(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("resman report", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, reportUsage) }
+	configPath := flags.String("config", "/etc/resman.conf", "Path to configuration file")
+	period := flags.String("period", "this_month", "Time range")
+	from := flags.String("from", "", "First day (YYYY-MM-DD)")
+	to := flags.String("to", "", "Last day (YYYY-MM-DD)")
+	userName := flags.String("user", "", "Only this user (name or UID)")
+	group := flags.String("group", "", "Only users of this group")
+	groupBy := flags.String("by", database.AccountingByUser, "Rows per day, user or group")
+	format := flags.String("format", "csv", "Output format: csv or json")
+	if err := flags.Parse(args); err != nil {
+		return dbExitError
+	}
+	if *format != "csv" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "Invalid format %q: expected csv or json\n", *format)
+		return dbExitError
+	}
+
+	cfg, err := config.LoadAndValidate(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration from %s: %v\n", *configPath, err)
+		return dbExitError
+	}
+
+	filter, err := reportFilter(*period, *from, *to, *userName, time.Now())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return dbExitError
+	}
+	filter.Group = *group
+
+	// Il report non crea il database e non lo migra: lo fa il demone
+	dbPath := cfg.MetricsDBPath
+	if _, err := os.Stat(dbPath); errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "Database %s does not exist (is METRICS_DB_ENABLED=true?)\n", dbPath)
+		return dbExitError
+	}
+	dbManager, err := database.OpenDatabaseManager(dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return dbExitError
+	}
+	defer dbManager.Close()
+
+	status, err := dbManager.MigrationStatus()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read schema version of %s: %v\n", dbPath, err)
+		return dbExitError
+	}
+	if status.CurrentVersion < status.LatestVersion {
+		fmt.Fprintf(os.Stderr, "Database %s is at schema version %d, usage accounting needs %d: run resman db migrate\n",
+			dbPath, status.CurrentVersion, status.LatestVersion)
+		return dbExitError
+	}
+
+	groupOf := func(username string) string {
+		group, _ := cfg.GetUserGroup(username)
+		return group.Name
+	}
+	records, err := dbManager.GetAccountingReport(filter, *groupBy, groupOf)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return dbExitError
+	}
+
+	if *format == "json" {
+		err = writeReportJSON(out, filter, *groupBy, records)
+	} else {
+		err = writeReportCSV(out, records)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write report: %v\n", err)
+		return dbExitError
+	}
+	return dbExitOK
+}
+
+// reportFilter ricava giorni e utente del report dai flag
+func reportFilter(period, from, to, userName string, now time.Time) (database.AccountingFilter, error) {
+	var filter database.AccountingFilter
+	start, end, err := database.ParseTimeRange(period, now)
+	if err != nil {
+		return filter, err
+	}
+	if from != "" {
+		if start, err = time.ParseInLocation("2006-01-02", from, time.Local); err != nil {
+			return filter, fmt.Errorf("invalid --from day %q: expected YYYY-MM-DD", from)
+		}
+	}
+	if to != "" {
+		if end, err = time.ParseInLocation("2006-01-02", to, time.Local); err != nil {
+			return filter, fmt.Errorf("invalid --to day %q: expected YYYY-MM-DD", to)
+		}
+	}
+	if end.Before(start) {
+		return filter, fmt.Errorf("report range ends before it starts")
+	}
+	filter.Start, filter.End = start, end
+
+	if userName != "" {
+		if uid, err := strconv.Atoi(userName); err == nil {
+			filter.UID = uid
+		} else if u, err := user.Lookup(userName); err == nil {
+			filter.UID, _ = strconv.Atoi(u.Uid)
+		} else {
+			return filter, fmt.Errorf("user not found: %s", userName)
+		}
+	}
+	return filter, nil
+}
+
+func writeReportCSV(out io.Writer, records []database.AccountingRecord) error {
+	w := csv.NewWriter(out)
+	if err := w.Write(reportColumns); err != nil {
+		return err
+	}
+	for _, r := range records {
+		uid, users := "", ""
+		if r.Username != "" {
+			uid = strconv.Itoa(r.UID)
+		}
+		if r.Users > 0 {
+			users = strconv.Itoa(r.Users)
+		}
+		if err := w.Write([]string{
+			r.Day, uid, r.Username, r.Group, users,
+			formatReportFloat(r.CPUSeconds), formatReportFloat(r.CPUHours),
+			formatReportFloat(r.MemoryByteSeconds), formatReportFloat(r.MemoryGBHours),
+			strconv.FormatInt(r.IOReadBytes, 10), strconv.FormatInt(r.IOWriteBytes, 10),
+			strconv.FormatInt(r.Samples, 10), formatReportFloat(r.CoveredSeconds), formatReportFloat(r.GapSeconds),
+			strconv.FormatInt(r.CounterResets, 10),
+		}); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func formatReportFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', 4, 64)
+}
+
+func writeReportJSON(out io.Writer, filter database.AccountingFilter, groupBy string, records []database.AccountingRecord) error {
+	if records == nil {
+		records = []database.AccountingRecord{}
+	}
+	report := struct {
+		From    string                      `json:"from"`
+		To      string                      `json:"to"`
+		GroupBy string                      `json:"group_by"`
+		Records []database.AccountingRecord `json:"records"`
+	}{
+		From:    filter.Start.Format("2006-01-02"),
+		To:      filter.End.Format("2006-01-02"),
+		GroupBy: groupBy,
+		Records: records,
+	}
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
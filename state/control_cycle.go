@@ -7,6 +7,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/fdefilippo/resman/cgroup"
 	"github.com/fdefilippo/resman/config"
 	resmanmetrics "github.com/fdefilippo/resman/metrics"
 )
@@ -26,9 +27,11 @@ type controlCycleContext struct {
 	metrics            *SystemMetrics
 	decision           string
 	reason             string
+	resourceDecisions  []resourceDecision
 	duration           time.Duration
 	activeLimitedUsers int
 	stopWithoutError   bool
+	dryRunSeq          uint64 // ultima scrittura DRY_RUN prima dell'inizio del ciclo
 }
 
 type controlCycleStage func(*Manager, *controlCycleContext) error
@@ -36,14 +39,23 @@ type controlCycleStage func(*Manager, *controlCycleContext) error
 var defaultControlCyclePipeline = []controlCycleStage{
 	(*Manager).stageCheckBlackout,
 	(*Manager).stageCollectMetrics,
+	(*Manager).stageCollectMemoryEvents,
 	(*Manager).stageUpdatePrometheus,
 	(*Manager).stageWriteDatabase,
 	(*Manager).stageMakeDecision,
+	(*Manager).stageSelectTargets,
+	(*Manager).stageUpdateFairShare,
 	(*Manager).stageExecuteDecision,
+	(*Manager).stageUpdateCPUSet,
+	(*Manager).stageExportCPUWeights,
+	(*Manager).stageVerifyIOWeights,
+	(*Manager).stageAdjustMemoryHigh,
 	(*Manager).stageRecordHistory,
 	(*Manager).stageIORemediation,
 	(*Manager).stageWorkloadPatternDetection,
 	(*Manager).stageRevertPSIBoosts,
+	(*Manager).stageRecordPlannedWrites,
+	(*Manager).stagePersistState,
 	(*Manager).stageLogCompletion,
 }
 
@@ -98,6 +110,9 @@ func (m *Manager) RunControlCycleWithTrigger(ctx context.Context, trigger string
 		startTime: time.Now(),
 	}
 	run.cycleID = run.startTime.Unix()
+	if m.dryRun != nil {
+		run.dryRunSeq = m.dryRun.lastSeq()
+	}
 
 	if m.prometheusExporter != nil {
 		m.prometheusExporter.RecordControlCycleTrigger(trigger)
@@ -153,6 +168,12 @@ func (m *Manager) stageCollectMetrics(run *controlCycleContext) error {
 	return nil
 }
 
+func (m *Manager) stageCollectMemoryEvents(run *controlCycleContext) error {
+	// 1a. memory.events/memory.stat dei cgroup utente, hook sui nuovi oom_kill
+	m.collectMemoryEvents(run.cfg, run.metrics)
+	return nil
+}
+
 func (m *Manager) stageUpdatePrometheus(run *controlCycleContext) error {
 	// 2. Aggiorna le metriche Prometheus (se abilitato)
 	if m.prometheusExporter != nil {
@@ -169,13 +190,29 @@ func (m *Manager) stageWriteDatabase(run *controlCycleContext) error {
 
 func (m *Manager) stageMakeDecision(run *controlCycleContext) error {
 	// 4. Prendi decisione basata sulle metriche
-	run.decision, run.reason = m.makeDecision(run.metrics)
+	// Ogni risorsa (CPU, RAM, IO) ha la propria macchina a stati; decision e
+	// reason riassumono il ciclo per storico e log
+	run.resourceDecisions = m.makeResourceDecisions(run.metrics)
+	run.decision, run.reason = summarizeResourceDecisions(run.resourceDecisions)
+	return nil
+}
+
+func (m *Manager) stageSelectTargets(run *controlCycleContext) error {
+	// 4a. Seleziona gli utenti da limitare (LIMIT_TARGET_MODE)
+	m.updateLimitTargets(run.cfg, run.metrics)
+	return nil
+}
+
+func (m *Manager) stageUpdateFairShare(run *controlCycleContext) error {
+	// 4b. Ricalcola i pesi fair-share prima di applicare la decisione
+	m.updateFairShare(run.cfg, run.metrics)
+	m.updateIOFairShare(run.cfg, run.metrics)
 	return nil
 }
 
 func (m *Manager) stageExecuteDecision(run *controlCycleContext) error {
 	// 4. Esegui l'azione corrispondente
-	if err := m.executeDecision(run.decision, run.metrics); err != nil {
+	if err := m.executeResourceDecisions(run.resourceDecisions, run.metrics); err != nil {
 		m.logger.Error("Failed to execute decision",
 			"decision", run.decision,
 			"reason", run.reason,
@@ -188,6 +225,43 @@ func (m *Manager) stageExecuteDecision(run *controlCycleContext) error {
 	return nil
 }
 
+func (m *Manager) stageUpdateCPUSet(run *controlCycleContext) error {
+	// 4c. CPUSET_ISOLATION: confina limited fuori dai core riservati
+	m.updateLimitedCPUSet(run.cfg)
+	return nil
+}
+
+func (m *Manager) stageExportCPUWeights(run *controlCycleContext) error {
+	// 5. Esporta il cpu.weight degli utenti limitati
+	if m.prometheusExporter != nil {
+		m.prometheusExporter.UpdateUserCPUWeights(m.getUserCPUWeights())
+	}
+	return nil
+}
+
+func (m *Manager) stageVerifyIOWeights(run *controlCycleContext) error {
+	// 5a. Con IO_LIMIT_MODE=weight esporta io.weight e ne verifica l'effetto
+	if run.cfg.GetIOLimitMode() != config.IOLimitModeWeight {
+		return nil
+	}
+	if m.prometheusExporter != nil {
+		m.prometheusExporter.UpdateUserIOWeights(m.getUserIOWeights())
+	}
+	m.verifyIOWeights(run.cfg)
+	return nil
+}
+
+func (m *Manager) stageAdjustMemoryHigh(run *controlCycleContext) error {
+	// 5b. RAM_DYNAMIC_HIGH: regola memory.high con working set e memory.pressure
+	steps := m.adjustMemoryHigh(run.cfg, run.metrics)
+	if m.prometheusExporter != nil {
+		for _, step := range steps {
+			m.prometheusExporter.RecordMemoryHighStep(step.UID, m.getUsername(step.UID), step.Action, step.Reason, step.High)
+		}
+	}
+	return nil
+}
+
 func (m *Manager) stageRecordHistory(run *controlCycleContext) error {
 	// 6. Registra lo storico del ciclo
 	run.duration = time.Since(run.startTime)
@@ -199,7 +273,20 @@ func (m *Manager) stageIORemediation(run *controlCycleContext) error {
 	// 7. IO Starvation Auto-Remediation
 	if m.ioRemediation != nil {
 		limitedUsers := m.metricsCollector.GetLimitedUsers()
-		m.ioRemediation.CheckAndRemediate(m.cgroupManager, run.cfg, limitedUsers)
+		// Il boost moltiplica i limiti io.max: con io.weight non ci sono limiti da alzare
+		ioLimitsActive := m.isResourceActive(ResourceIO) && run.cfg.GetIOLimitMode() == config.IOLimitModeMax
+		steps := m.ioRemediation.CheckAndRemediate(m.cgroupManager, run.cfg, limitedUsers, ioLimitsActive, func(uid int) (ioLimitValues, bool) {
+			return m.ioBoostLimits(run.cfg, uid)
+		})
+		if len(steps) > 0 {
+			if m.prometheusExporter != nil {
+				for _, step := range steps {
+					m.prometheusExporter.RecordIOBoostStep(step.UID, m.getUsername(step.UID), step.Action, step.Reason, step.Multiplier)
+				}
+			}
+			m.attachIOBoostSteps(steps)
+			m.journalIOBoostSteps(steps)
+		}
 		// Cleanup periodico stati vecchi
 		m.ioRemediation.Cleanup(24 * time.Hour)
 	}
@@ -209,6 +296,9 @@ func (m *Manager) stageIORemediation(run *controlCycleContext) error {
 func (m *Manager) stageWorkloadPatternDetection(run *controlCycleContext) error {
 	// 8. Workload Pattern Detection
 	if run.cfg.GetAutodetectPatterns() && m.patternDetector != nil && m.policyEngine != nil {
+		// Statistiche salvate e storico di user_metrics, al primo ciclo
+		m.loadPatternStats(run.cfg.GetPatternHistoryHours())
+
 		// Aggiorna statistiche per tutti gli utenti
 		allMetrics := m.metricsCollector.GetAllUserMetrics()
 		for uid, um := range allMetrics {
@@ -222,42 +312,17 @@ func (m *Manager) stageWorkloadPatternDetection(run *controlCycleContext) error
 			for uid, result := range patterns {
 				if m.prometheusExporter != nil {
 					username := m.metricsCollector.GetUsernameFromUID(uid)
-					m.prometheusExporter.UpdateUserWorkloadPattern(uid, username, string(result.Pattern), result.Confidence)
-				}
-				if result.Pattern != PatternUnknown {
-					if m.policyEngine.ApplyPolicy(uid, result.Pattern, run.cfg) {
-						// Policy cambiata, applica limiti
-						policy, _ := m.policyEngine.GetPolicy(uid)
-						if policy != nil {
-							// Applica CPU quota
-							if policy.CPUQuota > 0 {
-								quotaStr := strconv.Itoa(policy.CPUQuota) + " 100000"
-								if err := m.cgroupManager.ApplyCPULimit(uid, quotaStr); err != nil {
-									m.logger.Warn("Failed to apply pattern-based CPU limit",
-										"uid", uid,
-										"pattern", result.Pattern,
-										"error", err,
-									)
-								}
-							}
-							// Applica RAM quota
-							if policy.RAMQuota != "" {
-								if err := m.cgroupManager.ApplyRAMLimit(uid, policy.RAMQuota); err != nil {
-									m.logger.Warn("Failed to apply pattern-based RAM limit",
-										"uid", uid,
-										"pattern", result.Pattern,
-										"ram_quota", policy.RAMQuota,
-										"error", err,
-									)
-								}
-							}
-						}
-					}
+					m.prometheusExporter.UpdateUserWorkloadPattern(uid, username, result.Label(), result.LabelConfidence())
 				}
+				// Le quote della schedule vengono scritte subito e poi ai
+				// confini delle fasce da StartPolicyScheduler
+				m.policyEngine.SetSchedule(uid, result)
 			}
+			m.applyScheduledPolicies(time.Now())
 			// Cleanup pattern detector
 			m.patternDetector.Cleanup(time.Duration(run.cfg.GetPatternHistoryHours()) * time.Hour)
-			m.policyEngine.Cleanup(24 * time.Hour)
+			m.expirePatternPolicies(24 * time.Hour)
+			m.savePatternStats()
 		}
 	}
 	return nil
@@ -271,6 +336,26 @@ func (m *Manager) stageRevertPSIBoosts(run *controlCycleContext) error {
 	return nil
 }
 
+func (m *Manager) stageRecordPlannedWrites(run *controlCycleContext) error {
+	// 9b. In dry-run allega allo storico le scritture cgroup pianificate nel ciclo
+	if !m.IsDryRun() {
+		return nil
+	}
+	writes := m.dryRun.plannedWrites(run.dryRunSeq, 0)
+	m.attachPlannedWrites(writes)
+	m.logger.Info("Dry run: control cycle planned cgroup writes",
+		"cycle_id", run.cycleID,
+		"planned_writes", len(writes),
+	)
+	return nil
+}
+
+func (m *Manager) stagePersistState(run *controlCycleContext) error {
+	// 9c. Salva lo stato dei limiti se e' cambiato (STATE_PERSIST_ENABLED)
+	m.persistState()
+	return nil
+}
+
 func (m *Manager) stageLogCompletion(run *controlCycleContext) error {
 	m.mu.RLock()
 	run.activeLimitedUsers = len(m.activeUsers)
@@ -320,6 +405,12 @@ type SystemMetrics struct {
 	UserCPUUsage    map[int]float64                    // UID -> percentuale
 	UserMetrics     map[int]*resmanmetrics.UserMetrics // Metriche dettagliate per utente
 	EligibleUsers   []int                              // Users passing USER_INCLUDE/USER_EXCLUDE filters
+
+	UserIOWriteRate map[int]float64 // UID -> bytes/s scritti dall'ultimo ciclo decisionale (utenti eleggibili)
+
+	// memory.events e memory.stat dei cgroup utente (solo ciclo di controllo)
+	UserMemoryEvents map[int]cgroup.MemoryEvents
+	UserMemoryStat   map[int]cgroup.MemoryStat
 }
 
 func (m *Manager) collectSystemMetrics() (*SystemMetrics, error) {
@@ -335,6 +426,11 @@ func (m *Manager) collectSystemMetricsWithIOState(updateIOState bool) (*SystemMe
 		Timestamp:    time.Now(),
 		UserCPUUsage: make(map[int]float64),
 		UserMetrics:  make(map[int]*resmanmetrics.UserMetrics),
+
+		UserIOWriteRate: make(map[int]float64),
+
+		UserMemoryEvents: make(map[int]cgroup.MemoryEvents),
+		UserMemoryStat:   make(map[int]cgroup.MemoryStat),
 	}
 
 	// Raccogli metriche di base
@@ -379,6 +475,10 @@ func (m *Manager) collectSystemMetricsWithIOState(updateIOState bool) (*SystemMe
 			IOWriteBytes:    um.IOWriteBytes,
 			IOReadOps:       um.IOReadOps,
 			IOWriteOps:      um.IOWriteOps,
+			CPUSource:       um.CPUSource,
+			CPUUsageUsec:    um.CPUUsageUsec,
+			IOReadTotal:     um.IOReadTotal,
+			IOWriteTotal:    um.IOWriteTotal,
 		}
 		metrics.UserMetrics[uid] = corrected
 
@@ -397,6 +497,7 @@ func (m *Manager) collectSystemMetricsWithIOState(updateIOState bool) (*SystemMe
 					if elapsed > 0 && ioDelta >= prev {
 						ioRate := float64(ioDelta-prev) / elapsed
 						metrics.LimitedUsersIOWriteBytes += uint64(ioRate)
+						metrics.UserIOWriteRate[uid] = ioRate
 					}
 				}
 				m.prevIOBytes[uid] = ioDelta
@@ -510,6 +611,23 @@ func (m *Manager) updatePrometheusMetrics(metrics *SystemMetrics) {
 			ioReadOps,
 			ioWriteOps,
 		)
+		m.prometheusExporter.UpdateUserCPUSource(uid, username, userMetrics.CPUSource)
+
+		if events, ok := metrics.UserMemoryEvents[uid]; ok {
+			m.prometheusExporter.UpdateUserMemoryEvents(uid, username, events.Counters())
+		}
+		if stat, ok := metrics.UserMemoryStat[uid]; ok {
+			m.prometheusExporter.UpdateUserMemoryStat(uid, username, stat.Bytes())
+		}
+
+		// IO per disco, solo per gli utenti con un cgroup da cui leggere io.stat
+		if cgroupPath != "" {
+			if devices, err := m.cgroupManager.GetIODeviceStats(uid); err == nil {
+				for _, device := range devices {
+					m.prometheusExporter.UpdateUserIODeviceStats(uid, username, device.Device, device.ReadBytes, device.WriteBytes, device.ReadOps, device.WriteOps)
+				}
+			}
+		}
 	}
 
 	// Pulisci metriche per utenti non più attivi
@@ -546,8 +664,9 @@ func (m *Manager) writeDatabaseMetrics(metrics *SystemMetrics) {
 	}
 
 	// Scrivi le metriche
+	m.writeMemoryEvents(metrics)
 	m.metricsCollector.WriteMetricsToDatabase(
-		metrics.UserMetrics,
+		m.userMetricsForDatabase(metrics),
 		metrics.TotalCPUUsage,
 		metrics.TotalCores,
 		0.0, // systemLoad non sempre disponibile
@@ -561,6 +680,34 @@ func (m *Manager) writeDatabaseMetrics(metrics *SystemMetrics) {
 	)
 }
 
+// userMetricsForDatabase aggiunge alle metriche utente PSI e memory.events dei
+// cgroup degli utenti limitati. Lavora su copie: le metriche del collector
+// sono condivise con la cache.
+func (m *Manager) userMetricsForDatabase(metrics *SystemMetrics) map[int]*resmanmetrics.UserMetrics {
+	result := make(map[int]*resmanmetrics.UserMetrics, len(metrics.UserMetrics))
+	for uid, userMetrics := range metrics.UserMetrics {
+		if userMetrics == nil {
+			continue
+		}
+		enriched := *userMetrics
+		if events, ok := metrics.UserMemoryEvents[uid]; ok {
+			enriched.MemoryHighEvents = events.High
+			enriched.MemoryMaxEvents = events.Max
+			enriched.OOMKillEvents = events.OOMKill
+		}
+		if m.cgroupManager != nil && m.isUserLimited(uid) {
+			if psi, err := m.cgroupManager.GetPSIStats(uid); err == nil {
+				enriched.IOPressure = psi.SomeAvg10
+			}
+			if psi, err := m.cgroupManager.GetMemoryPSIStats(uid); err == nil {
+				enriched.MemoryPressure = psi.SomeAvg10
+			}
+		}
+		result[uid] = &enriched
+	}
+	return result
+}
+
 type ControlCycleEntry struct {
 	Timestamp     time.Time `json:"timestamp"`
 	Decision      string    `json:"decision"`
@@ -570,6 +717,13 @@ type ControlCycleEntry struct {
 	ActiveUsers   int       `json:"active_users"`
 	LimitsActive  bool      `json:"limits_active"`
 	DurationMs    int64     `json:"duration_ms"`
+	// Utenti limitati con il motivo della selezione (LIMIT_TARGET_MODE)
+	TargetedUsers []TargetedUser `json:"targeted_users,omitempty"`
+	// DRY_RUN: scritture cgroup che il ciclo avrebbe eseguito
+	DryRun        bool           `json:"dry_run,omitempty"`
+	PlannedWrites []PlannedWrite `json:"planned_writes,omitempty"`
+	// Passi della remediation IO adattiva eseguiti nel ciclo
+	IOBoostSteps []IOBoostStep `json:"io_boost_steps,omitempty"`
 }
 
 // controlHistory stores recent control cycle entries
@@ -591,6 +745,30 @@ func (m *Manager) addControlHistoryEntry(entry ControlCycleEntry) {
 	}
 }
 
+// attachPlannedWrites associa le scritture pianificate all'ultimo ciclo registrato.
+func (m *Manager) attachPlannedWrites(writes []PlannedWrite) {
+	m.controlHist.mu.Lock()
+	defer m.controlHist.mu.Unlock()
+
+	if len(m.controlHist.entries) == 0 {
+		return
+	}
+	last := &m.controlHist.entries[len(m.controlHist.entries)-1]
+	last.PlannedWrites = append(last.PlannedWrites, writes...)
+}
+
+// attachIOBoostSteps associa i passi della remediation IO all'ultimo ciclo registrato.
+func (m *Manager) attachIOBoostSteps(steps []IOBoostStep) {
+	m.controlHist.mu.Lock()
+	defer m.controlHist.mu.Unlock()
+
+	if len(m.controlHist.entries) == 0 {
+		return
+	}
+	last := &m.controlHist.entries[len(m.controlHist.entries)-1]
+	last.IOBoostSteps = append(last.IOBoostSteps, steps...)
+}
+
 func (m *Manager) GetControlHistory(limit int) []ControlCycleEntry {
 	m.controlHist.mu.RLock()
 	defer m.controlHist.mu.RUnlock()
@@ -624,6 +802,8 @@ func (m *Manager) recordControlCycle(decision, reason string, metrics *SystemMet
 		ActiveUsers:   len(metrics.UserCPUUsage),
 		LimitsActive:  limitsActive,
 		DurationMs:    duration.Milliseconds(),
+		TargetedUsers: m.getTargetedUsers(),
+		DryRun:        m.IsDryRun(),
 	}
 
 	m.addControlHistoryEntry(entry)
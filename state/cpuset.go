@@ -0,0 +1,87 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+// state/cpuset.go
+package state
+
+import (
+	"github.com/fdefilippo/resman/config"
+)
+
+// updateLimitedCPUSet applica al cgroup condiviso il cpuset che esclude i
+// core riservati al sistema. Viene ricalcolato a ogni ciclo e riscritto solo
+// quando cambia: dopo un hotplug le CPU aggiunte entrano nel cpuset e quelle
+// riservate andate offline vengono sostituite. Con CPUSET_ISOLATION
+// disattivato a caldo il confinamento viene tolto.
+func (m *Manager) updateLimitedCPUSet(cfg *config.Config) {
+	m.mu.RLock()
+	sharedPath := m.sharedCgroupPath
+	applied, appliedPath := m.cpusetApplied, m.cpusetAppliedPath
+	m.mu.RUnlock()
+
+	if sharedPath == "" {
+		m.setCPUSetApplied("", "")
+		return
+	}
+	// Un cgroup condiviso appena creato o adottato va sempre allineato
+	fresh := sharedPath != appliedPath
+
+	var allowed, reserved string
+	if cfg.GetCPUSetIsolation() {
+		var err error
+		allowed, reserved, err = m.cgroupManager.LimitedCPUSet()
+		if err != nil {
+			m.logger.Warn("Failed to compute cpuset for limited users, keeping current one",
+				"current", applied,
+				"error", err,
+			)
+			return
+		}
+	}
+	if allowed == applied && !fresh {
+		return
+	}
+
+	if err := m.cgroupManager.ApplySharedCPUSet(sharedPath, allowed); err != nil {
+		m.logger.Warn("Failed to apply cpuset for limited users",
+			"path", sharedPath,
+			"cpus", allowed,
+			"error", err,
+		)
+		return
+	}
+	m.setCPUSetApplied(allowed, sharedPath)
+
+	if allowed == "" {
+		if applied != "" && !fresh {
+			m.logger.Info("Core isolation removed from limited users", "path", sharedPath)
+		}
+		return
+	}
+	m.logger.Info("Limited users pinned to non-reserved cores",
+		"path", sharedPath,
+		"cpus", allowed,
+		"reserved", reserved,
+		"previous", applied,
+	)
+}
+
+func (m *Manager) setCPUSetApplied(cpus, path string) {
+	m.mu.Lock()
+	m.cpusetApplied = cpus
+	m.cpusetAppliedPath = path
+	m.mu.Unlock()
+}
@@ -0,0 +1,80 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+package state
+
+import (
+	"testing"
+
+	"github.com/fdefilippo/resman/config"
+)
+
+// cpusetCgroupManager simula le CPU online e registra i cpuset applicati.
+type cpusetCgroupManager struct {
+	mockCgroupManager
+	allowed string
+	applied []string
+}
+
+func (c *cpusetCgroupManager) LimitedCPUSet() (string, string, error) {
+	return c.allowed, "0", nil
+}
+
+func (c *cpusetCgroupManager) ApplySharedCPUSet(sharedPath string, cpus string) error {
+	c.applied = append(c.applied, cpus)
+	return nil
+}
+
+func TestUpdateLimitedCPUSet(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.CPUSetIsolation = true
+	cgroups := &cpusetCgroupManager{allowed: "1-3"}
+	manager, err := NewManager(cfg, &mockMetricsCollector{}, cgroups, &mockPrometheusExporter{})
+	if err != nil {
+		t.Fatalf("NewManager() error: %v", err)
+	}
+
+	// Nessun cgroup condiviso: niente da confinare
+	manager.updateLimitedCPUSet(cfg)
+	if len(cgroups.applied) != 0 {
+		t.Fatalf("cpuset applied without a shared cgroup: %v", cgroups.applied)
+	}
+
+	manager.mu.Lock()
+	manager.sharedCgroupPath = "/sys/fs/cgroup/resman/limited"
+	manager.mu.Unlock()
+	manager.updateLimitedCPUSet(cfg)
+	manager.updateLimitedCPUSet(cfg)
+
+	// Hotplug: CPU 4-5 aggiunte
+	cgroups.allowed = "1-5"
+	manager.updateLimitedCPUSet(cfg)
+
+	// Isolamento disattivato a caldo
+	cfg.CPUSetIsolation = false
+	manager.updateLimitedCPUSet(cfg)
+
+	expected := []string{"1-3", "1-5", ""}
+	if len(cgroups.applied) != len(expected) {
+		t.Fatalf("applied cpusets = %q, expected %q", cgroups.applied, expected)
+	}
+	for i := range expected {
+		if cgroups.applied[i] != expected[i] {
+			t.Errorf("applied cpusets = %q, expected %q", cgroups.applied, expected)
+			break
+		}
+	}
+}
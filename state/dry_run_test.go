@@ -0,0 +1,145 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fdefilippo/resman/config"
+)
+
+// countingCgroupManager conta le scritture che arrivano al CgroupManager reale.
+type countingCgroupManager struct {
+	mockCgroupManager
+	writes int
+}
+
+func (c *countingCgroupManager) ApplyCPUWeight(uid int, weight int) error {
+	c.writes++
+	return nil
+}
+
+func (c *countingCgroupManager) CreateSharedCgroup() (string, error) {
+	c.writes++
+	return "/real/limited", nil
+}
+
+// removingCgroupManager rimuove davvero il cgroup condiviso, come il manager reale.
+type removingCgroupManager struct {
+	mockCgroupManager
+}
+
+func (r *removingCgroupManager) RemoveSharedCgroup(sharedPath string) error {
+	return os.RemoveAll(sharedPath)
+}
+
+func TestDryRunKeepsSharedCgroupOnDeactivate(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.DryRun = true
+	cfg.CgroupRoot = t.TempDir()
+	cfg.CgroupBase = "resman"
+
+	manager, err := NewManager(cfg, &mockMetricsCollector{}, &removingCgroupManager{}, &mockPrometheusExporter{})
+	if err != nil {
+		t.Fatalf("NewManager() error: %v", err)
+	}
+
+	// Gerarchia lasciata da un'istanza precedente
+	sharedPath := filepath.Join(cfg.CgroupRoot, "resman", "limited")
+	if err := os.MkdirAll(filepath.Join(sharedPath, "user_1000"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	manager.mu.Lock()
+	manager.limitsActive = true
+	manager.activeUsers[1000] = true
+	manager.sharedCgroupPath = sharedPath
+	manager.mu.Unlock()
+
+	if err := manager.deactivateLimits(); err != nil {
+		t.Fatalf("deactivateLimits() error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(sharedPath, "user_1000")); err != nil {
+		t.Errorf("shared cgroup removed in dry run: %v", err)
+	}
+
+	recorded := false
+	for _, write := range manager.GetPlannedWrites(0) {
+		if write.Operation == "remove_shared_cgroup" && write.Target == sharedPath {
+			recorded = true
+		}
+	}
+	if !recorded {
+		t.Error("shared cgroup removal was not recorded as a planned write")
+	}
+}
+
+func TestDryRunRecordsWrites(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.DryRun = true
+	cfg.CgroupRoot = "/sys/fs/cgroup"
+	cfg.CgroupBase = "resman"
+
+	inner := &countingCgroupManager{}
+	manager, err := NewManager(cfg, &mockMetricsCollector{}, inner, &mockPrometheusExporter{})
+	if err != nil {
+		t.Fatalf("NewManager() error: %v", err)
+	}
+
+	if err := manager.cgroupManager.ApplyCPUWeight(1000, 50); err != nil {
+		t.Fatalf("ApplyCPUWeight() error: %v", err)
+	}
+	sharedPath, err := manager.cgroupManager.CreateSharedCgroup()
+	if err != nil {
+		t.Fatalf("CreateSharedCgroup() error: %v", err)
+	}
+	if expected := filepath.Join("/sys/fs/cgroup", "resman", "limited"); sharedPath != expected {
+		t.Errorf("CreateSharedCgroup() = %s, expected planned path %s", sharedPath, expected)
+	}
+	if inner.writes != 0 {
+		t.Errorf("dry run reached the real cgroup manager %d times", inner.writes)
+	}
+
+	writes := manager.GetPlannedWrites(0)
+	if len(writes) != 2 {
+		t.Fatalf("got %d planned writes, expected 2: %v", len(writes), writes)
+	}
+	if writes[0].Operation != "cpu_weight" || writes[0].UID != 1000 || writes[0].Value != "50" {
+		t.Errorf("unexpected planned write: %+v", writes[0])
+	}
+	if got := manager.GetPlannedWrites(1); len(got) != 1 || got[0].Seq != writes[1].Seq {
+		t.Errorf("GetPlannedWrites(1) = %v, expected only the latest write", got)
+	}
+
+	// Disattivare DRY_RUN a caldo fa tornare le scritture al manager reale
+	newCfg := config.DefaultConfig()
+	newCfg.DryRun = false
+	manager.UpdateConfig(newCfg)
+	if manager.IsDryRun() {
+		t.Fatal("dry run should be disabled after config reload")
+	}
+	if err := manager.cgroupManager.ApplyCPUWeight(1000, 50); err != nil {
+		t.Fatalf("ApplyCPUWeight() error: %v", err)
+	}
+	if inner.writes != 1 {
+		t.Errorf("real cgroup manager got %d writes, expected 1", inner.writes)
+	}
+	if len(manager.GetPlannedWrites(0)) != 2 {
+		t.Error("writes outside dry run should not be recorded")
+	}
+}
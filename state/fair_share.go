@@ -0,0 +1,262 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+// state/fair_share.go
+package state
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/fdefilippo/resman/config"
+)
+
+// defaultCPUWeight e' il cpu.weight usato quando il fair-share e' disabilitato
+// o non c'e' ancora storico per l'utente.
+const defaultCPUWeight = 100
+
+// fairShareEpsilon evita divisioni per zero e smorza i pesi quando lo storico
+// e' quasi vuoto (unita': CPU% * secondi).
+const fairShareEpsilon = 1.0
+
+// FairShareTracker accumula il consumo CPU recente di ogni utente con
+// decadimento esponenziale e ne deriva un cpu.weight: chi ha consumato di piu'
+// ottiene un peso minore, chi ha consumato poco uno maggiore.
+type FairShareTracker struct {
+	mu         sync.RWMutex
+	usage      map[int]float64 // uid -> CPU% * secondi, con decadimento
+	weights    map[int]int     // uid -> ultimo peso calcolato
+	lastUpdate time.Time
+}
+
+// NewFairShareTracker crea un tracker vuoto.
+func NewFairShareTracker() *FairShareTracker {
+	return &FairShareTracker{
+		usage:   make(map[int]float64),
+		weights: make(map[int]int),
+	}
+}
+
+// Update applica il decadimento allo storico e somma il campione corrente,
+// pesato per il tempo trascorso dall'ultimo aggiornamento.
+func (t *FairShareTracker) Update(userCPU map[int]float64, halfLife time.Duration, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.lastUpdate.IsZero() || halfLife <= 0 {
+		t.lastUpdate = now
+		return
+	}
+
+	elapsed := now.Sub(t.lastUpdate)
+	if elapsed <= 0 {
+		return
+	}
+	t.lastUpdate = now
+
+	decay := math.Pow(0.5, elapsed.Seconds()/halfLife.Seconds())
+	for uid, value := range t.usage {
+		value *= decay
+		if _, present := userCPU[uid]; !present && value < fairShareEpsilon/100 {
+			delete(t.usage, uid)
+			continue
+		}
+		t.usage[uid] = value
+	}
+
+	for uid, cpu := range userCPU {
+		if cpu > 0 {
+			t.usage[uid] += cpu * elapsed.Seconds()
+		}
+	}
+}
+
+// ComputeWeights calcola il peso per ciascuno degli UID indicati.
+// Un utente con consumo pari alla media del gruppo riceve 100; il peso scala
+// in modo inversamente proporzionale al consumo entro [minWeight, maxWeight].
+func (t *FairShareTracker) ComputeWeights(uids []int, minWeight, maxWeight int) map[int]int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	weights := make(map[int]int, len(uids))
+	if len(uids) == 0 {
+		t.weights = weights
+		return copyWeights(weights)
+	}
+
+	var total float64
+	for _, uid := range uids {
+		total += t.usage[uid]
+	}
+	mean := total / float64(len(uids))
+
+	for _, uid := range uids {
+		raw := float64(defaultCPUWeight) * (mean + fairShareEpsilon) / (t.usage[uid] + fairShareEpsilon)
+		weight := int(math.Round(raw))
+		if weight < minWeight {
+			weight = minWeight
+		}
+		if weight > maxWeight {
+			weight = maxWeight
+		}
+		weights[uid] = weight
+	}
+
+	t.weights = weights
+	return copyWeights(weights)
+}
+
+// Weight restituisce l'ultimo peso calcolato per l'utente.
+func (t *FairShareTracker) Weight(uid int) (int, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	weight, ok := t.weights[uid]
+	return weight, ok
+}
+
+// Weights restituisce una copia degli ultimi pesi calcolati.
+func (t *FairShareTracker) Weights() map[int]int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return copyWeights(t.weights)
+}
+
+// Usage restituisce il consumo decaduto accumulato per l'utente.
+func (t *FairShareTracker) Usage(uid int) float64 {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.usage[uid]
+}
+
+// Reset dimentica i pesi calcolati (lo storico dei consumi viene mantenuto).
+func (t *FairShareTracker) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.weights = make(map[int]int)
+}
+
+func copyWeights(src map[int]int) map[int]int {
+	dst := make(map[int]int, len(src))
+	for uid, weight := range src {
+		dst[uid] = weight
+	}
+	return dst
+}
+
+// cpuWeightFor restituisce il cpu.weight da applicare a un utente limitato.
+// Un override in USER_OVERRIDES_FILE ha la precedenza sul fair-share.
+func (m *Manager) cpuWeightFor(uid int) int {
+	cfg := m.GetConfig()
+	if override, ok := m.userOverrideFor(cfg, uid); ok && override.CPUWeight > 0 {
+		return override.CPUWeight
+	}
+	if cfg == nil || !cfg.GetFairShareEnabled() || m.fairShare == nil {
+		return defaultCPUWeight
+	}
+	if weight, ok := m.fairShare.Weight(uid); ok {
+		return weight
+	}
+	return defaultCPUWeight
+}
+
+// updateFairShare aggiorna lo storico dei consumi, ricalcola i pesi per gli
+// utenti eleggibili e riapplica quelli cambiati agli utenti gia' limitati.
+func (m *Manager) updateFairShare(cfg *config.Config, metrics *SystemMetrics) {
+	if m.fairShare == nil || metrics == nil {
+		return
+	}
+
+	if !cfg.GetFairShareEnabled() {
+		previous := m.fairShare.Weights()
+		if len(previous) == 0 {
+			return
+		}
+		// Fair-share disabilitato a caldo: riporta tutti al peso di default
+		m.fairShare.Reset()
+		for _, uid := range m.limitedUsersWithoutBoost() {
+			if m.hasCPUWeightOverride(cfg, uid) {
+				continue
+			}
+			if weight, ok := previous[uid]; ok && weight != defaultCPUWeight {
+				m.applyFairShareWeight(uid, defaultCPUWeight)
+			}
+		}
+		return
+	}
+
+	halfLife := time.Duration(cfg.GetFairShareHalfLife()) * time.Second
+	m.fairShare.Update(metrics.UserCPUUsage, halfLife, time.Now())
+
+	previous := m.fairShare.Weights()
+	weights := m.fairShare.ComputeWeights(metrics.EligibleUsers, cfg.GetFairShareMinWeight(), cfg.GetFairShareMaxWeight())
+
+	// I pesi vengono scritti solo mentre i limiti CPU sono attivi: altrimenti
+	// verranno applicati all'attivazione tramite cpuWeightFor
+	if !m.isResourceActive(ResourceCPU) {
+		return
+	}
+
+	for _, uid := range m.limitedUsersWithoutBoost() {
+		weight, ok := weights[uid]
+		if !ok || m.hasCPUWeightOverride(cfg, uid) {
+			continue
+		}
+		old, known := previous[uid]
+		if !known {
+			old = defaultCPUWeight
+		}
+		if weight != old {
+			m.applyFairShareWeight(uid, weight)
+		}
+	}
+}
+
+// limitedUsersWithoutBoost restituisce gli utenti limitati che non hanno
+// un boost PSI in corso (il boost ha la precedenza sul fair-share).
+func (m *Manager) limitedUsersWithoutBoost() []int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	users := make([]int, 0, len(m.activeUsers))
+	for uid := range m.activeUsers {
+		if _, boosted := m.psiBoostedAt[uid]; boosted {
+			continue
+		}
+		users = append(users, uid)
+	}
+	return users
+}
+
+func (m *Manager) applyFairShareWeight(uid, weight int) {
+	if err := m.cgroupManager.ApplyCPUWeight(uid, weight); err != nil {
+		m.logger.Warn("Failed to apply fair-share CPU weight",
+			"uid", uid, "weight", weight, "error", err)
+		return
+	}
+	m.logger.Debug("Fair-share CPU weight updated",
+		"uid", uid, "weight", weight)
+}
+
+// getUserCPUWeights restituisce il cpu.weight corrente di ogni utente limitato.
+func (m *Manager) getUserCPUWeights() map[int]int {
+	users := m.getActiveUsersList()
+	weights := make(map[int]int, len(users))
+	for _, uid := range users {
+		weights[uid] = m.cpuWeightFor(uid)
+	}
+	return weights
+}
@@ -0,0 +1,108 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fdefilippo/resman/config"
+)
+
+func TestFairShareWeights(t *testing.T) {
+	tracker := NewFairShareTracker()
+	start := time.Now()
+	halfLife := time.Hour
+
+	// Primo campione: inizializza solo il timestamp
+	tracker.Update(map[int]float64{1001: 90, 1002: 10}, halfLife, start)
+	weights := tracker.ComputeWeights([]int{1001, 1002}, 25, 400)
+	if weights[1001] != 100 || weights[1002] != 100 {
+		t.Fatalf("expected flat weights without history, got %v", weights)
+	}
+
+	tracker.Update(map[int]float64{1001: 90, 1002: 10}, halfLife, start.Add(10*time.Minute))
+	weights = tracker.ComputeWeights([]int{1001, 1002}, 25, 400)
+	if weights[1001] >= 100 {
+		t.Errorf("heavy user should get weight < 100, got %d", weights[1001])
+	}
+	if weights[1002] <= 100 {
+		t.Errorf("light user should get weight > 100, got %d", weights[1002])
+	}
+
+	// Un utente nuovo senza storico riceve il peso massimo
+	weights = tracker.ComputeWeights([]int{1001, 1002, 1003}, 25, 400)
+	if weights[1003] != 400 {
+		t.Errorf("new user should get max weight 400, got %d", weights[1003])
+	}
+	if weights[1001] < 25 {
+		t.Errorf("weight below configured minimum: %d", weights[1001])
+	}
+}
+
+func TestFairShareDecay(t *testing.T) {
+	tracker := NewFairShareTracker()
+	start := time.Now()
+	halfLife := time.Hour
+
+	tracker.Update(map[int]float64{1001: 100}, halfLife, start)
+	tracker.Update(map[int]float64{1001: 100}, halfLife, start.Add(time.Minute))
+	before := tracker.Usage(1001)
+	if before <= 0 {
+		t.Fatalf("expected accumulated usage, got %f", before)
+	}
+
+	tracker.Update(map[int]float64{1001: 0}, halfLife, start.Add(time.Minute+time.Hour))
+	after := tracker.Usage(1001)
+	if after < before*0.49 || after > before*0.51 {
+		t.Errorf("usage should halve after one half-life: before=%f after=%f", before, after)
+	}
+}
+
+func TestCPUWeightOverride(t *testing.T) {
+	tmpDir := t.TempDir()
+	overridesFile := filepath.Join(tmpDir, "users.conf")
+	configFile := filepath.Join(tmpDir, "resman.conf")
+
+	// Il mock restituisce "user<uid>" come username
+	if err := os.WriteFile(overridesFile, []byte("[user1001]\nCPU_WEIGHT=700\n"), 0644); err != nil {
+		t.Fatalf("Failed to write overrides file: %v", err)
+	}
+	content := "FAIR_SHARE_ENABLED=true\nUSER_OVERRIDES_FILE=" + overridesFile + "\n"
+	if err := os.WriteFile(configFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	cfg, err := config.LoadAndValidate(configFile)
+	if err != nil {
+		t.Fatalf("LoadAndValidate() error: %v", err)
+	}
+
+	manager, err := NewManager(cfg, &mockMetricsCollector{}, &mockCgroupManager{}, &mockPrometheusExporter{})
+	if err != nil {
+		t.Fatalf("NewManager() error: %v", err)
+	}
+
+	manager.fairShare.ComputeWeights([]int{1001, 1002}, 25, 400)
+	if weight := manager.cpuWeightFor(1001); weight != 700 {
+		t.Errorf("override weight should win over fair-share, got %d", weight)
+	}
+	if weight := manager.cpuWeightFor(1002); weight != defaultCPUWeight {
+		t.Errorf("user without override should keep fair-share weight, got %d", weight)
+	}
+}
@@ -0,0 +1,159 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+// state/groups.go
+package state
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/fdefilippo/resman/cgroup"
+	"github.com/fdefilippo/resman/config"
+)
+
+// cgroupParentFor restituisce il cgroup in cui creare user_<uid>: il cgroup del
+// gruppo a cui appartiene l'utente (limited/<group>) oppure direttamente il
+// cgroup condiviso se l'utente non appartiene a nessun gruppo.
+func (m *Manager) cgroupParentFor(cfg *config.Config, uid int, sharedPath string) string {
+	// Le slice systemd non si possono annidare in un cgroup di gruppo
+	if m.cgroupManager.UsesSystemdSlices() {
+		return sharedPath
+	}
+
+	username := m.getUsername(uid)
+	group, ok := cfg.GetUserGroup(username)
+	if !ok {
+		return sharedPath
+	}
+
+	groupPath, err := m.cgroupManager.CreateGroupCgroup(sharedPath, group)
+	if err != nil {
+		m.logger.Warn("Failed to create group cgroup, using shared cgroup",
+			"uid", uid,
+			"username", username,
+			"group", group.Name,
+			"error", err,
+		)
+		return sharedPath
+	}
+
+	m.mu.Lock()
+	if m.groupCgroupPaths == nil {
+		m.groupCgroupPaths = make(map[string]string)
+	}
+	m.groupCgroupPaths[group.Name] = groupPath
+	m.mu.Unlock()
+
+	return groupPath
+}
+
+// userCgroupPath restituisce il cgroup di limite dell'utente sotto parentPath:
+// user_<uid> nell'albero di resman, user-<uid>.slice con le slice systemd.
+func (m *Manager) userCgroupPath(parentPath string, uid int) string {
+	if m.cgroupManager.UsesSystemdSlices() {
+		return filepath.Join(parentPath, cgroup.UserSliceName(uid))
+	}
+	return filepath.Join(parentPath, fmt.Sprintf("user_%d", uid))
+}
+
+// setUserCgroupParent registra il parent del sottocgroup dell'utente.
+func (m *Manager) setUserCgroupParent(uid int, parentPath string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.userCgroupParent == nil {
+		m.userCgroupParent = make(map[int]string)
+	}
+	m.userCgroupParent[uid] = parentPath
+}
+
+// takeUserCgroupParentLocked restituisce e dimentica il parent registrato per l'utente
+// (il cgroup condiviso se non registrato). Chiamare con m.mu gia' acquisito.
+func (m *Manager) takeUserCgroupParentLocked(uid int, sharedPath string) string {
+	parentPath, ok := m.userCgroupParent[uid]
+	delete(m.userCgroupParent, uid)
+	if !ok || parentPath == "" {
+		return sharedPath
+	}
+	return parentPath
+}
+
+// removeGroupCgroups rimuove i cgroup di gruppo creati sotto il cgroup condiviso.
+func (m *Manager) removeGroupCgroups() {
+	m.mu.Lock()
+	groupPaths := m.groupCgroupPaths
+	m.groupCgroupPaths = make(map[string]string)
+	m.userCgroupParent = make(map[int]string)
+	m.mu.Unlock()
+
+	for name, groupPath := range groupPaths {
+		if err := m.cgroupManager.RemoveGroupCgroup(groupPath); err != nil {
+			m.logger.Warn("Failed to remove group cgroup",
+				"group", name,
+				"path", groupPath,
+				"error", err,
+			)
+		}
+	}
+}
+
+// refreshGroupCgroups riallinea le quote dei gruppi gia' creati alla
+// configurazione corrente (usato dopo un hot reload).
+func (m *Manager) refreshGroupCgroups(cfg *config.Config) {
+	m.mu.RLock()
+	sharedPath := m.sharedCgroupPath
+	existing := make(map[string]bool, len(m.groupCgroupPaths))
+	for name := range m.groupCgroupPaths {
+		existing[name] = true
+	}
+	m.mu.RUnlock()
+
+	if sharedPath == "" || len(existing) == 0 {
+		return
+	}
+
+	for _, group := range cfg.GetUserGroups() {
+		if !existing[group.Name] {
+			continue
+		}
+		if _, err := m.cgroupManager.CreateGroupCgroup(sharedPath, group); err != nil {
+			m.logger.Warn("Failed to refresh group cgroup limits",
+				"group", group.Name,
+				"error", err,
+			)
+		}
+	}
+}
+
+// getGroupUserCountsLocked restituisce, per ogni gruppo attivo, il numero di utenti limitati.
+// Chiamare con m.mu gia' acquisito.
+func (m *Manager) getGroupUserCountsLocked() map[string]int {
+	counts := make(map[string]int, len(m.groupCgroupPaths))
+	for name := range m.groupCgroupPaths {
+		counts[name] = 0
+	}
+	for uid, parentPath := range m.userCgroupParent {
+		if !m.activeUsers[uid] {
+			continue
+		}
+		for name, groupPath := range m.groupCgroupPaths {
+			if filepath.Clean(parentPath) == filepath.Clean(groupPath) {
+				counts[name]++
+			}
+		}
+	}
+	return counts
+}
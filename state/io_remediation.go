@@ -18,6 +18,8 @@
 package state
 
 import (
+	"fmt"
+	"math"
 	"sync"
 	"time"
 
@@ -26,20 +28,67 @@ import (
 	"github.com/fdefilippo/resman/logging"
 )
 
+// Azioni del controller adattivo del boost IO.
+const (
+	IOBoostActionStart    = "start"     // inizio episodio di starvation
+	IOBoostActionStepUp   = "step_up"   // PSI non in calo: boost aumentato
+	IOBoostActionHold     = "hold"      // PSI in calo o boost gia' minimo sufficiente
+	IOBoostActionStepDown = "step_down" // starvation risolta: si prova un boost minore
+	IOBoostActionEnd      = "end"       // limiti normali ripristinati
+)
+
+// Motivi dei passi del controller (label "reason" in Prometheus).
+const (
+	IOBoostReasonStarvation         = "starvation"          // starvation oltre IO_STARVATION_THRESHOLD
+	IOBoostReasonPSINotDropping     = "psi_not_dropping"    // PSI non cala con il boost corrente
+	IOBoostReasonStarvationReturned = "starvation_returned" // un boost minore non basta
+	IOBoostReasonPSIDropping        = "psi_dropping"        // il boost corrente sta funzionando
+	IOBoostReasonMaxMultiplier      = "max_multiplier"      // IO_BOOST_MAX_MULTIPLIER raggiunto
+	IOBoostReasonConverged          = "converged"           // boost minimo efficace trovato
+	IOBoostReasonCleared            = "cleared"             // starvation risolta
+	IOBoostReasonDuration           = "duration"            // IO_BOOST_DURATION raggiunta
+	IOBoostReasonLimitsReleased     = "limits_released"     // limiti IO non piu' attivi
+)
+
+// ioPSIDropRatio e' il calo relativo di PSI tra due controlli oltre il quale
+// il boost corrente viene considerato efficace.
+const ioPSIDropRatio = 0.9
+
 // IOBoostState tiene traccia dello stato di boost per un utente.
 type IOBoostState struct {
-	IsActive          bool
-	StartTime         time.Time
-	OriginalReadBPS   string
-	OriginalWriteBPS  string
-	OriginalReadIOPS  int
-	OriginalWriteIOPS int
-	BoostCount        int       // Numero di boost nell'ultima ora
-	LastBoostTime     time.Time // Ultimo boost applicato
-	StarvationStart   time.Time // Quando e' iniziata la starvation
+	IsActive        bool
+	StartTime       time.Time
+	BoostCount      int       // Numero di boost nell'ultima ora
+	LastBoostTime   time.Time // Ultimo boost applicato
+	StarvationStart time.Time // Quando e' iniziata la starvation
+
+	Multiplier float64 // moltiplicatore applicato nell'episodio corrente
+	LastPSI    float64 // PSI some avg10 all'ultimo controllo
+	// Moltiplicatore piu' basso che ha risolto la starvation nell'episodio
+	ClearingMultiplier float64
+	// Converged indica che un boost minore di ClearingMultiplier ha fatto
+	// tornare la starvation: il controller lo mantiene fino a fine episodio
+	Converged bool
+	// LearnedMultiplier e' il punto di partenza del prossimo episodio
+	LearnedMultiplier float64
+}
+
+// IOBoostStep e' una decisione del controller per un utente, esportata in
+// Prometheus e nello storico del ciclo di controllo.
+type IOBoostStep struct {
+	UID                int     `json:"uid"`
+	Action             string  `json:"action"`
+	Multiplier         float64 `json:"multiplier"`
+	PreviousMultiplier float64 `json:"previous_multiplier,omitempty"`
+	PSIAvg10           float64 `json:"psi_avg10"`
+	Reason             string  `json:"reason"`
+	Detail             string  `json:"detail,omitempty"`
 }
 
-// IORemediation gestisce il rilevamento e la remediation della IO starvation.
+// IORemediation gestisce il rilevamento e la remediation della IO starvation
+// con un controller a ciclo chiuso: a ogni controllo il moltiplicatore dei
+// limiti IO sale se PSI non cala e scende quando la starvation e' risolta,
+// fino al boost minimo che la risolve.
 type IORemediation struct {
 	mu          sync.RWMutex
 	logger      *logging.Logger
@@ -63,11 +112,50 @@ type IORemediationDeps interface {
 	RemoveIOLimit(uid int) error
 }
 
-// CheckAndRemediate verifica la IO starvation per tutti gli utenti e applica remediation se necessario.
-// Deve essere chiamato periodicamente dal control cycle.
-func (r *IORemediation) CheckAndRemediate(deps IORemediationDeps, cfg *config.Config, limitedUsers []int) {
+// ioBoostParams sono i parametri del controller letti dalla configurazione.
+type ioBoostParams struct {
+	starvationThreshold time.Duration
+	psiThreshold        float64
+	initialMultiplier   float64
+	minMultiplier       float64
+	maxMultiplier       float64
+	step                float64
+	duration            time.Duration
+	maxPerHour          int
+	revertOnNormal      bool
+	ioLimitsActive      bool
+}
+
+func newIOBoostParams(cfg *config.Config, ioLimitsActive bool) ioBoostParams {
+	minMultiplier, maxMultiplier := cfg.GetIOBoostMultiplierRange()
+	return ioBoostParams{
+		starvationThreshold: time.Duration(cfg.GetIOStarvationThreshold()) * time.Second,
+		psiThreshold:        cfg.GetIOPSIThreshold(),
+		initialMultiplier:   cfg.GetIOBoostMultiplier(),
+		minMultiplier:       minMultiplier,
+		maxMultiplier:       maxMultiplier,
+		step:                cfg.GetIOBoostStep(),
+		duration:            time.Duration(cfg.GetIOBoostDuration()) * time.Second,
+		maxPerHour:          cfg.GetIOBoostMaxPerHour(),
+		revertOnNormal:      cfg.GetIORevertOnNormal(),
+		ioLimitsActive:      ioLimitsActive,
+	}
+}
+
+// clamp limita un moltiplicatore all'intervallo configurato.
+func (p ioBoostParams) clamp(multiplier float64) float64 {
+	return math.Min(math.Max(multiplier, p.minMultiplier), p.maxMultiplier)
+}
+
+// CheckAndRemediate verifica la IO starvation per tutti gli utenti e regola il
+// boost dei limiti IO. Restituisce le decisioni prese. Deve essere chiamato
+// periodicamente dal control cycle; ioLimitsActive indica se i limiti IO sono
+// applicati (senza limiti non c'e' nulla da allentare). limitsFor restituisce
+// i limiti normali di un utente, override compresi: il boost li moltiplica e
+// a fine episodio vengono riscritti; ok=false se l'utente non ne ha.
+func (r *IORemediation) CheckAndRemediate(deps IORemediationDeps, cfg *config.Config, limitedUsers []int, ioLimitsActive bool, limitsFor func(uid int) (ioLimitValues, bool)) []IOBoostStep {
 	if !cfg.GetIORemediationEnabled() {
-		return
+		return nil
 	}
 
 	now := time.Now()
@@ -75,21 +163,16 @@ func (r *IORemediation) CheckAndRemediate(deps IORemediationDeps, cfg *config.Co
 
 	// Rispetta il check interval
 	if now.Sub(r.lastCheck) < checkInterval {
-		return
+		return nil
 	}
 	r.lastCheck = now
 
-	starvationThreshold := cfg.GetIOStarvationThreshold()
-	psiThreshold := cfg.GetIOPSIThreshold()
-	boostMultiplier := cfg.GetIOBoostMultiplier()
-	boostDuration := time.Duration(cfg.GetIOBoostDuration()) * time.Second
-	boostMaxPerHour := cfg.GetIOBoostMaxPerHour()
-	revertOnNormal := cfg.GetIORevertOnNormal()
-	deviceFilter := cfg.GetIODeviceFilter()
+	params := newIOBoostParams(cfg, ioLimitsActive)
 
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
+	var steps []IOBoostStep
 	for _, uid := range limitedUsers {
 		state, exists := r.boostStates[uid]
 		if !exists {
@@ -104,99 +187,214 @@ func (r *IORemediation) CheckAndRemediate(deps IORemediationDeps, cfg *config.Co
 			continue
 		}
 
-		// Controlla se PSI supera la soglia
-		isStarved := psiStats.SomeAvg10 >= psiThreshold
+		// Un utente senza limiti IO propri non ha nulla da allentare
+		userParams := params
+		limits, hasLimits := limitsFor(uid)
+		userParams.ioLimitsActive = params.ioLimitsActive && hasLimits
 
-		if isStarved {
-			// Inizia o continua il tracking della starvation
-			if state.StarvationStart.IsZero() {
-				state.StarvationStart = now
-			}
+		step, ok := r.control(uid, state, psiStats.SomeAvg10, userParams, now)
+		state.LastPSI = psiStats.SomeAvg10
+		if !ok {
+			continue
+		}
+		if r.applyStep(deps, state, &step, limits, userParams, now) {
+			steps = append(steps, step)
+		}
+	}
+	return steps
+}
 
-			starvationDuration := now.Sub(state.StarvationStart)
-
-			// Se la starvation dura abbastanza e non siamo gia' in boost
-			if starvationDuration >= time.Duration(starvationThreshold)*time.Second && !state.IsActive {
-				// Controlla se abbiamo superato il max boost per ora
-				if state.BoostCount >= boostMaxPerHour {
-					r.logger.Warn("IO starvation detected but max boosts per hour reached, skipping",
-						"uid", uid,
-						"boosts_this_hour", state.BoostCount,
-						"psi_avg10", psiStats.SomeAvg10,
-					)
-					continue
-				}
-
-				// Applica boost temporaneo
-				r.applyBoost(deps, uid, state, boostMultiplier, boostDuration, deviceFilter, now)
-			} else if state.IsActive {
-				// Siamo gia' in boost, controlla se e' scaduto
-				if now.Sub(state.StartTime) >= boostDuration {
-					r.revertBoost(deps, uid, state, deviceFilter)
-				}
-			}
-		} else {
+// control decide il passo del controller per un utente. Restituisce false se
+// non c'e' nulla da fare.
+func (r *IORemediation) control(uid int, state *IOBoostState, psi float64, p ioBoostParams, now time.Time) (IOBoostStep, bool) {
+	isStarved := psi >= p.psiThreshold
+	step := IOBoostStep{UID: uid, PSIAvg10: psi, PreviousMultiplier: state.Multiplier}
+
+	if !state.IsActive {
+		if !isStarved || !p.ioLimitsActive {
 			// PSI sotto soglia, reset starvation timer
 			state.StarvationStart = time.Time{}
+			return step, false
+		}
+		// Inizia o continua il tracking della starvation
+		if state.StarvationStart.IsZero() {
+			state.StarvationStart = now
+		}
+		if now.Sub(state.StarvationStart) < p.starvationThreshold {
+			return step, false
+		}
+		// Controlla se abbiamo superato il max boost per ora
+		if state.BoostCount >= p.maxPerHour {
+			r.logger.Warn("IO starvation detected but max boosts per hour reached, skipping",
+				"uid", uid,
+				"boosts_this_hour", state.BoostCount,
+				"psi_avg10", psi,
+			)
+			return step, false
+		}
 
-			// Se siamo in boost e revertOnNormal e' true, revert subito
-			if state.IsActive && revertOnNormal {
-				r.revertBoost(deps, uid, state, deviceFilter)
-			}
+		step.Action = IOBoostActionStart
+		step.PreviousMultiplier = 0
+		step.Reason = IOBoostReasonStarvation
+		starved := now.Sub(state.StarvationStart).Round(time.Second)
+		if state.LearnedMultiplier > 0 {
+			step.Multiplier = p.clamp(state.LearnedMultiplier)
+			step.Detail = fmt.Sprintf("starved for %s, starting from learned multiplier", starved)
+		} else {
+			step.Multiplier = p.clamp(p.initialMultiplier)
+			step.Detail = fmt.Sprintf("starved for %s, starting from IO_BOOST_MULTIPLIER", starved)
 		}
+		return step, true
+	}
+
+	// Boost attivo
+	switch {
+	case !p.ioLimitsActive:
+		step.Action = IOBoostActionEnd
+		step.Reason = IOBoostReasonLimitsReleased
+	case now.Sub(state.StartTime) >= p.duration:
+		step.Action = IOBoostActionEnd
+		step.Reason = IOBoostReasonDuration
+	case isStarved && state.ClearingMultiplier > 0 && state.Multiplier < state.ClearingMultiplier:
+		// Il boost minore non basta: si torna al piu' basso che ha funzionato
+		step.Action = IOBoostActionStepUp
+		step.Multiplier = state.ClearingMultiplier
+		step.Reason = IOBoostReasonStarvationReturned
+		step.Detail = "back to the smallest multiplier that cleared the starvation"
+		state.Converged = true
+	case isStarved && psi < state.LastPSI*ioPSIDropRatio:
+		step.Action = IOBoostActionHold
+		step.Reason = IOBoostReasonPSIDropping
+		step.Detail = fmt.Sprintf("PSI %.1f%% -> %.1f%%", state.LastPSI, psi)
+	case isStarved && state.Multiplier >= p.maxMultiplier:
+		step.Action = IOBoostActionHold
+		step.Reason = IOBoostReasonMaxMultiplier
+	case isStarved:
+		step.Action = IOBoostActionStepUp
+		step.Multiplier = p.clamp(state.Multiplier * p.step)
+		step.Reason = IOBoostReasonPSINotDropping
+		step.Detail = fmt.Sprintf("PSI %.1f%% -> %.1f%%", state.LastPSI, psi)
+	default:
+		// Starvation risolta con il moltiplicatore corrente
+		if state.ClearingMultiplier == 0 || state.Multiplier < state.ClearingMultiplier {
+			state.ClearingMultiplier = state.Multiplier
+		}
+		next := state.Multiplier / p.step
+		step.Reason = IOBoostReasonCleared
+		switch {
+		case state.Converged:
+			step.Action = IOBoostActionHold
+			step.Reason = IOBoostReasonConverged
+		case next >= p.minMultiplier:
+			step.Action = IOBoostActionStepDown
+			step.Multiplier = next
+			step.Detail = "trying a smaller boost"
+		case p.revertOnNormal:
+			step.Action = IOBoostActionEnd
+			step.Detail = "cleared at IO_BOOST_MIN_MULTIPLIER"
+		default:
+			step.Action = IOBoostActionHold
+			step.Detail = "holding until IO_BOOST_DURATION"
+		}
+	}
+
+	if step.Action == IOBoostActionHold {
+		step.Multiplier = state.Multiplier
 	}
+	return step, true
+}
+
+// ioLimitValues sono i limiti IO di un utente a cui si applica il boost.
+type ioLimitValues struct {
+	readBPS, writeBPS   string
+	readIOPS, writeIOPS int
+	deviceFilter        string
 }
 
-// applyBoost applica un boost temporaneo dei limiti IO per un utente.
-func (r *IORemediation) applyBoost(deps IORemediationDeps, uid int, state *IOBoostState, multiplier float64, duration time.Duration, deviceFilter string, now time.Time) {
-	// Salva limiti originali (per ora usiamo RemoveIOLimit come fallback)
-	// In una implementazione completa, leggeremmo io.max corrente
+// applyStep scrive nel cgroup il passo deciso dal controller e aggiorna lo
+// stato. Restituisce false se la scrittura fallisce.
+func (r *IORemediation) applyStep(deps IORemediationDeps, state *IOBoostState, step *IOBoostStep, limits ioLimitValues, p ioBoostParams, now time.Time) bool {
+	uid := step.UID
+
+	switch step.Action {
+	case IOBoostActionHold:
+		r.logger.Debug("IO boost held",
+			"uid", uid,
+			"multiplier", step.Multiplier,
+			"psi_avg10", step.PSIAvg10,
+			"reason", step.Reason,
+		)
+		return true
+
+	case IOBoostActionEnd:
+		// Il prossimo episodio parte dal boost piu' basso che ha funzionato,
+		// o da quello raggiunto se nessuno e' bastato
+		state.LearnedMultiplier = state.Multiplier
+		if state.ClearingMultiplier > 0 {
+			state.LearnedMultiplier = state.ClearingMultiplier
+		}
+		if p.ioLimitsActive {
+			if err := deps.ApplyTemporaryIOLimit(uid, limits.readBPS, limits.writeBPS, limits.readIOPS, limits.writeIOPS, limits.deviceFilter, 1.0); err != nil {
+				r.logger.Warn("Failed to restore IO limits after boost", "uid", uid, "error", err)
+			}
+		}
+		state.IsActive = false
+		state.StarvationStart = time.Time{}
+		state.Multiplier = 0
+		state.ClearingMultiplier = 0
+		state.Converged = false
+		step.Multiplier = 1.0
+
+		r.logger.Info("IO starvation remediation: boost ended",
+			"uid", uid,
+			"reason", step.Reason,
+			"learned_multiplier", state.LearnedMultiplier,
+		)
+		return true
+	}
 
-	// Applica limiti boostati (usando RemoveIOLimit come "boost" temporaneo)
-	// In pratica, rimuoviamo i limiti per permettere all'utente di recuperare
-	if err := deps.RemoveIOLimit(uid); err != nil {
+	if err := deps.ApplyTemporaryIOLimit(uid, limits.readBPS, limits.writeBPS, limits.readIOPS, limits.writeIOPS, limits.deviceFilter, step.Multiplier); err != nil {
 		r.logger.Warn("Failed to apply IO boost for user",
 			"uid", uid,
+			"multiplier", step.Multiplier,
 			"error", err,
 		)
-		return
+		return false
 	}
 
-	state.IsActive = true
-	state.StartTime = now
-	state.BoostCount++
-	state.LastBoostTime = now
+	if step.Action == IOBoostActionStart {
+		state.IsActive = true
+		state.StartTime = now
+		state.BoostCount++
+		state.LastBoostTime = now
+		state.ClearingMultiplier = 0
+		state.Converged = false
+	}
+	state.Multiplier = step.Multiplier
 
-	r.logger.Info("IO starvation remediation: applied temporary boost",
+	r.logger.Info("IO starvation remediation: boost adjusted",
 		"uid", uid,
-		"multiplier", multiplier,
-		"duration", duration,
+		"action", step.Action,
+		"multiplier", step.Multiplier,
+		"previous_multiplier", step.PreviousMultiplier,
+		"psi_avg10", step.PSIAvg10,
+		"reason", step.Reason,
+		"detail", step.Detail,
 		"boosts_this_hour", state.BoostCount,
 	)
+	return true
 }
 
-// revertBoost ripristina i limiti IO originali dopo un boost.
-func (r *IORemediation) revertBoost(deps IORemediationDeps, uid int, state *IOBoostState, deviceFilter string) {
-	// In una implementazione completa, ripristineremmo i limiti originali salvati
-	// Rimuove il boost; i limiti correnti verranno riconciliati dal control cycle.
-
-	state.IsActive = false
-	state.StarvationStart = time.Time{}
-
-	r.logger.Info("IO starvation remediation: reverted boost",
-		"uid", uid,
-	)
-}
-
-// Cleanup rimuove stati di boost scaduti o non piu' attivi.
+// Cleanup rimuove stati di boost scaduti o non piu' attivi. Il moltiplicatore
+// appreso si perde con lo stato dopo maxAge senza boost.
 func (r *IORemediation) Cleanup(maxAge time.Duration) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
 	now := time.Now()
 	for uid, state := range r.boostStates {
-		// Rimuovi stati vecchi
-		if !state.IsActive && now.Sub(state.LastBoostTime) > maxAge {
+		// Rimuovi stati vecchi, ma non quelli di una starvation in corso
+		if !state.IsActive && state.StarvationStart.IsZero() && now.Sub(state.LastBoostTime) > maxAge {
 			delete(r.boostStates, uid)
 		}
 		// Reset boost count se e' passata un'ora dall'ultimo boost
@@ -0,0 +1,172 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+package state
+
+import (
+	"testing"
+
+	"github.com/fdefilippo/resman/cgroup"
+	"github.com/fdefilippo/resman/config"
+	"github.com/fdefilippo/resman/logging"
+)
+
+// psiSequenceDeps restituisce un PSI impostato dal test e registra i
+// moltiplicatori scritti nel cgroup.
+type psiSequenceDeps struct {
+	psi         float64
+	multipliers []float64
+	readBPS     map[int][]string // uid -> IO_READ_BPS scritti
+}
+
+func (d *psiSequenceDeps) GetPSIStats(uid int) (cgroup.PSIStats, error) {
+	return cgroup.PSIStats{SomeAvg10: d.psi}, nil
+}
+
+func (d *psiSequenceDeps) GetIOStats(uid int) (uint64, uint64, uint64, uint64, error) {
+	return 0, 0, 0, 0, nil
+}
+
+func (d *psiSequenceDeps) ApplyTemporaryIOLimit(uid int, readBPS, writeBPS string, readIOPS, writeIOPS int, deviceFilter string, multiplier float64) error {
+	d.multipliers = append(d.multipliers, multiplier)
+	if d.readBPS != nil {
+		d.readBPS[uid] = append(d.readBPS[uid], readBPS)
+	}
+	return nil
+}
+
+func (d *psiSequenceDeps) RemoveIOLimit(uid int) error {
+	return nil
+}
+
+// globalIOLimits restituisce gli stessi limiti per tutti gli utenti.
+func globalIOLimits(uid int) (ioLimitValues, bool) {
+	return ioLimitValues{readBPS: "100M", writeBPS: "50M"}, true
+}
+
+func TestIORemediationAdaptiveBoost(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.IORemediationEnabled = true
+	cfg.IOStarvationThreshold = 0
+	cfg.IOStarvationCheckInterval = 0
+	cfg.IOPSIThreshold = 20
+	cfg.IOBoostMultiplier = 2
+	cfg.IOBoostMinMultiplier = 1.25
+	cfg.IOBoostMaxMultiplier = 8
+	cfg.IOBoostStep = 1.5
+	cfg.IOBoostDuration = 3600
+	cfg.IOBoostMaxPerHour = 10
+
+	remediation := NewIORemediation(logging.GetLogger())
+	deps := &psiSequenceDeps{}
+
+	sequence := []struct {
+		psi        float64
+		action     string
+		reason     string
+		multiplier float64
+	}{
+		{50, IOBoostActionStart, IOBoostReasonStarvation, 2},
+		{50, IOBoostActionStepUp, IOBoostReasonPSINotDropping, 3},
+		{30, IOBoostActionHold, IOBoostReasonPSIDropping, 3},
+		{5, IOBoostActionStepDown, IOBoostReasonCleared, 2},
+		{40, IOBoostActionStepUp, IOBoostReasonStarvationReturned, 3},
+		{5, IOBoostActionHold, IOBoostReasonConverged, 3},
+	}
+	for i, want := range sequence {
+		deps.psi = want.psi
+		steps := remediation.CheckAndRemediate(deps, cfg, []int{1000}, true, globalIOLimits)
+		if len(steps) != 1 {
+			t.Fatalf("check %d: got %d steps, expected 1", i, len(steps))
+		}
+		got := steps[0]
+		if got.Action != want.action || got.Reason != want.reason || got.Multiplier != want.multiplier {
+			t.Errorf("check %d (psi %.0f): got %s/%s x%.2f, expected %s/%s x%.2f",
+				i, want.psi, got.Action, got.Reason, got.Multiplier, want.action, want.reason, want.multiplier)
+		}
+	}
+
+	// Limiti IO rilasciati: il boost finisce e il moltiplicatore appreso e'
+	// il piu' basso che ha risolto la starvation
+	steps := remediation.CheckAndRemediate(deps, cfg, []int{1000}, false, globalIOLimits)
+	if len(steps) != 1 || steps[0].Action != IOBoostActionEnd || steps[0].Multiplier != 1 {
+		t.Fatalf("releasing IO limits should end the boost, got %+v", steps)
+	}
+	if learned := remediation.boostStates[1000].LearnedMultiplier; learned != 3 {
+		t.Errorf("learned multiplier = %.2f, expected 3", learned)
+	}
+
+	// Nuovo episodio: si parte dal moltiplicatore appreso
+	deps.psi = 50
+	steps = remediation.CheckAndRemediate(deps, cfg, []int{1000}, true, globalIOLimits)
+	if len(steps) != 1 || steps[0].Action != IOBoostActionStart || steps[0].Multiplier != 3 {
+		t.Errorf("new episode should start from the learned multiplier, got %+v", steps)
+	}
+	if last := deps.multipliers[len(deps.multipliers)-1]; last != 3 {
+		t.Errorf("last multiplier written = %.2f, expected 3", last)
+	}
+}
+
+func TestIORemediationUsesUserLimits(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.IORemediationEnabled = true
+	cfg.IOStarvationThreshold = 0
+	cfg.IOStarvationCheckInterval = 0
+	cfg.IOPSIThreshold = 20
+	cfg.IOBoostMultiplier = 2
+	cfg.IOBoostDuration = 3600
+	cfg.IOBoostMaxPerHour = 10
+
+	// 1000 ha un override IO, 1001 i limiti globali, 1002 nessun limite IO
+	limitsFor := func(uid int) (ioLimitValues, bool) {
+		switch uid {
+		case 1000:
+			return ioLimitValues{readBPS: "500M"}, true
+		case 1001:
+			return ioLimitValues{readBPS: "100M"}, true
+		default:
+			return ioLimitValues{}, false
+		}
+	}
+
+	remediation := NewIORemediation(logging.GetLogger())
+	deps := &psiSequenceDeps{psi: 50, readBPS: make(map[int][]string)}
+	steps := remediation.CheckAndRemediate(deps, cfg, []int{1000, 1001, 1002}, true, limitsFor)
+	if len(steps) != 2 {
+		t.Fatalf("got %d steps, expected a boost for the two users with IO limits: %+v", len(steps), steps)
+	}
+
+	// Fine episodio: ogni utente torna ai propri limiti, non a quelli globali
+	deps.psi = 0
+	cfg.IOBoostMinMultiplier = 2
+	cfg.IORevertOnNormal = true
+	remediation.CheckAndRemediate(deps, cfg, []int{1000, 1001, 1002}, true, limitsFor)
+	for uid, want := range map[int]string{1000: "500M", 1001: "100M"} {
+		written := deps.readBPS[uid]
+		if len(written) != 2 {
+			t.Errorf("uid %d: %d writes, expected boost and restore", uid, len(written))
+			continue
+		}
+		for _, got := range written {
+			if got != want {
+				t.Errorf("uid %d: boost applied to IO_READ_BPS %s, expected its own limit %s", uid, got, want)
+			}
+		}
+	}
+	if written := deps.readBPS[1002]; len(written) != 0 {
+		t.Errorf("user without IO limits was boosted: %v", written)
+	}
+}
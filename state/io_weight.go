@@ -0,0 +1,328 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+// state/io_weight.go
+package state
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fdefilippo/resman/config"
+)
+
+// defaultIOWeight e' l'io.weight di default del kernel, usato quando il
+// fair-share e' disabilitato o non c'e' ancora storico per l'utente.
+const defaultIOWeight = 100
+
+// ioWeightContentionPSI e' la pressione IO (some avg10, %) oltre la quale un
+// utente e' considerato in attesa del disco: solo allora i pesi contano.
+const ioWeightContentionPSI = 10.0
+
+// ioWeightOvershareFactor e' quanto un utente puo' superare la propria quota
+// di banda (peso / somma dei pesi) mentre un altro e' in attesa, prima di
+// considerare i pesi non applicati dallo scheduler IO.
+const ioWeightOvershareFactor = 1.5
+
+// Esito della verifica dei pesi IO (label "result" in Prometheus).
+const (
+	IOWeightCheckIdle        = "idle"        // nessuna contesa tra utenti limitati, niente da verificare
+	IOWeightCheckEffective   = "effective"   // sotto contesa la banda segue i pesi
+	IOWeightCheckIneffective = "ineffective" // sotto contesa un utente supera la sua quota
+)
+
+// ioWeightVerifier confronta la banda di ogni utente (delta di io.stat) con
+// la quota attesa dal suo io.weight. Senza BFQ o io.cost il kernel ignora
+// io.weight e la verifica lo rileva.
+type ioWeightVerifier struct {
+	mu     sync.RWMutex
+	prev   map[int]uint64 // uid -> byte letti+scritti all'ultima verifica
+	result string
+}
+
+func newIOWeightVerifier() *ioWeightVerifier {
+	return &ioWeightVerifier{
+		prev:   make(map[int]uint64),
+		result: IOWeightCheckIdle,
+	}
+}
+
+// Result restituisce l'esito dell'ultima verifica.
+func (v *ioWeightVerifier) Result() string {
+	if v == nil {
+		return IOWeightCheckIdle
+	}
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.result
+}
+
+// evaluateIOWeights verifica che, sotto contesa, la banda degli utenti
+// attivi segua i pesi. Restituisce l'esito e gli utenti oltre la loro quota.
+func evaluateIOWeights(bytes map[int]uint64, weights map[int]int, pressure map[int]float64) (string, []int) {
+	var totalBytes uint64
+	var totalWeight int
+	contended := false
+	for uid, delta := range bytes {
+		if delta == 0 {
+			continue
+		}
+		totalBytes += delta
+		totalWeight += weights[uid]
+		if pressure[uid] >= ioWeightContentionPSI {
+			contended = true
+		}
+	}
+	// Con un solo utente misurato non c'e' nessuno con cui dividere la banda
+	if !contended || len(bytes) < 2 || totalBytes == 0 || totalWeight == 0 {
+		return IOWeightCheckIdle, nil
+	}
+
+	share := func(uid int) (actual, expected float64) {
+		return float64(bytes[uid]) / float64(totalBytes), float64(weights[uid]) / float64(totalWeight)
+	}
+
+	var overshare []int
+	for uid, delta := range bytes {
+		if delta == 0 {
+			continue
+		}
+		actual, expected := share(uid)
+		if actual <= expected*ioWeightOvershareFactor {
+			continue
+		}
+		// Conta solo se qualcun altro e' in attesa con meno della sua quota
+		for other, otherDelta := range bytes {
+			if other == uid || pressure[other] < ioWeightContentionPSI {
+				continue
+			}
+			if otherActual, otherExpected := share(other); otherDelta == 0 || otherActual < otherExpected {
+				overshare = append(overshare, uid)
+				break
+			}
+		}
+	}
+	if len(overshare) == 0 {
+		return IOWeightCheckEffective, nil
+	}
+	sort.Ints(overshare)
+	return IOWeightCheckIneffective, overshare
+}
+
+// ioWeightFor restituisce l'io.weight da applicare a un utente limitato.
+func (m *Manager) ioWeightFor(uid int) int {
+	cfg := m.GetConfig()
+	if cfg == nil || !cfg.GetFairShareEnabled() || m.ioFairShare == nil {
+		return defaultIOWeight
+	}
+	if weight, ok := m.ioFairShare.Weight(uid); ok {
+		return weight
+	}
+	return defaultIOWeight
+}
+
+// ioWeightedUsers restituisce gli utenti limitati soggetti ai limiti IO.
+func (m *Manager) ioWeightedUsers(cfg *config.Config) []int {
+	var users []int
+	for _, uid := range m.getActiveUsersList() {
+		override, _ := m.userOverrideFor(cfg, uid)
+		if _, _, _, _, ok := m.userIOLimits(cfg, uid, override); ok {
+			users = append(users, uid)
+		}
+	}
+	return users
+}
+
+// updateIOFairShare aggiorna lo storico della scrittura su disco e, con
+// IO_LIMIT_MODE=weight, riapplica gli io.weight cambiati: chi ha scritto di
+// piu' di recente riceve un peso minore. Usa FAIR_SHARE_HALF_LIFE.
+func (m *Manager) updateIOFairShare(cfg *config.Config, metrics *SystemMetrics) {
+	if m.ioFairShare == nil || metrics == nil || cfg.GetIOLimitMode() != config.IOLimitModeWeight {
+		return
+	}
+
+	if !cfg.GetFairShareEnabled() {
+		previous := m.ioFairShare.Weights()
+		if len(previous) == 0 {
+			return
+		}
+		m.ioFairShare.Reset()
+		if !m.isResourceActive(ResourceIO) {
+			return
+		}
+		for _, uid := range m.ioWeightedUsers(cfg) {
+			if weight, ok := previous[uid]; ok && weight != defaultIOWeight {
+				m.applyUserIOWeight(cfg, uid, defaultIOWeight)
+			}
+		}
+		return
+	}
+
+	// MB/s: stessa scala di grandezza dei punti percentuali CPU del fair-share
+	writeMBps := make(map[int]float64, len(metrics.UserIOWriteRate))
+	for uid, rate := range metrics.UserIOWriteRate {
+		writeMBps[uid] = rate / (1024 * 1024)
+	}
+	halfLife := time.Duration(cfg.GetFairShareHalfLife()) * time.Second
+	m.ioFairShare.Update(writeMBps, halfLife, time.Now())
+
+	previous := m.ioFairShare.Weights()
+	minWeight, maxWeight := cfg.GetIOWeightRange()
+	weights := m.ioFairShare.ComputeWeights(metrics.EligibleUsers, minWeight, maxWeight)
+
+	// Come per la CPU, i pesi vengono scritti solo mentre i limiti IO sono attivi
+	if !m.isResourceActive(ResourceIO) {
+		return
+	}
+
+	for _, uid := range m.ioWeightedUsers(cfg) {
+		weight, ok := weights[uid]
+		if !ok {
+			continue
+		}
+		old, known := previous[uid]
+		if !known {
+			old = defaultIOWeight
+		}
+		if weight != old {
+			m.applyUserIOWeight(cfg, uid, weight)
+		}
+	}
+}
+
+// applyUserIOWeight scrive io.weight e, se configurato, il target io.latency
+// del cgroup di limite dell'utente.
+func (m *Manager) applyUserIOWeight(cfg *config.Config, uid, weight int) {
+	if err := m.cgroupManager.ApplyIOWeight(uid, weight); err != nil {
+		m.logger.Warn("Failed to apply IO weight for user",
+			"uid", uid, "weight", weight, "error", err)
+		return
+	}
+	if target := cfg.GetIOLatencyTargetUsec(); target > 0 {
+		if err := m.cgroupManager.ApplyIOLatency(uid, target, cfg.GetIODeviceFilter()); err != nil {
+			m.logger.Warn("Failed to apply IO latency target for user",
+				"uid", uid, "target_us", target, "error", err)
+		}
+	}
+	m.logger.Debug("IO weight applied for user",
+		"uid", uid, "weight", weight)
+}
+
+// applySharedIOWeight scrive l'io.weight del cgroup condiviso, che compete
+// con i cgroup fratelli (utenti non limitati, resto del sistema). Con le
+// slice systemd il cgroup condiviso e' user.slice, che contiene anche gli
+// utenti non limitati: restano solo i pesi delle singole slice.
+func (m *Manager) applySharedIOWeight(weight int) {
+	m.mu.RLock()
+	sharedPath := m.sharedCgroupPath
+	m.mu.RUnlock()
+	if sharedPath == "" || m.cgroupManager.UsesSystemdSlices() {
+		return
+	}
+	if err := m.cgroupManager.ApplySharedIOWeight(sharedPath, weight); err != nil {
+		m.logger.Warn("Failed to apply shared IO weight",
+			"path", sharedPath, "weight", weight, "error", err)
+	}
+}
+
+// removeUserIOWeight riporta io.weight al default e rimuove il target io.latency.
+func (m *Manager) removeUserIOWeight(cfg *config.Config, uid int) {
+	if err := m.cgroupManager.ApplyIOWeight(uid, defaultIOWeight); err != nil {
+		m.logger.Warn("Failed to reset IO weight for user",
+			"uid", uid, "error", err)
+	}
+	if cfg.GetIOLatencyTargetUsec() > 0 {
+		if err := m.cgroupManager.ApplyIOLatency(uid, 0, cfg.GetIODeviceFilter()); err != nil {
+			m.logger.Warn("Failed to remove IO latency target for user",
+				"uid", uid, "error", err)
+		}
+	}
+}
+
+// getUserIOWeights restituisce l'io.weight corrente di ogni utente limitato
+// soggetto ai limiti IO (vuoto se i limiti IO non sono attivi).
+func (m *Manager) getUserIOWeights() map[int]int {
+	weights := make(map[int]int)
+	cfg := m.GetConfig()
+	if cfg == nil || !m.isResourceActive(ResourceIO) {
+		return weights
+	}
+	for _, uid := range m.ioWeightedUsers(cfg) {
+		weights[uid] = m.ioWeightFor(uid)
+	}
+	return weights
+}
+
+// verifyIOWeights misura la banda di ogni utente da io.stat e la pressione
+// IO da PSI e controlla che sotto contesa la banda segua gli io.weight.
+func (m *Manager) verifyIOWeights(cfg *config.Config) {
+	v := m.ioWeightCheck
+	if v == nil || m.cgroupManager == nil {
+		return
+	}
+
+	bytes := make(map[int]uint64)
+	pressure := make(map[int]float64)
+	weights := make(map[int]int)
+	if m.isResourceActive(ResourceIO) {
+		for _, uid := range m.ioWeightedUsers(cfg) {
+			readBytes, writeBytes, _, _, err := m.cgroupManager.GetIOStats(uid)
+			if err != nil {
+				continue
+			}
+			weights[uid] = m.ioWeightFor(uid)
+			total := readBytes + writeBytes
+			v.mu.Lock()
+			prev, ok := v.prev[uid]
+			v.prev[uid] = total
+			v.mu.Unlock()
+			// Primo campione o cgroup ricreato: nessun delta
+			if !ok || total < prev {
+				continue
+			}
+			bytes[uid] = total - prev
+			if psi, err := m.cgroupManager.GetPSIStats(uid); err == nil {
+				pressure[uid] = psi.SomeAvg10
+			}
+		}
+	}
+
+	result, overshare := evaluateIOWeights(bytes, weights, pressure)
+
+	v.mu.Lock()
+	previous := v.result
+	v.result = result
+	for uid := range v.prev {
+		if _, ok := weights[uid]; !ok {
+			delete(v.prev, uid)
+		}
+	}
+	v.mu.Unlock()
+
+	if m.prometheusExporter != nil {
+		m.prometheusExporter.RecordIOWeightCheck(result)
+	}
+
+	if result == IOWeightCheckIneffective && previous != IOWeightCheckIneffective {
+		m.logger.Warn("IO weights are not enforced: users exceed their share while others stall",
+			"users", overshare,
+			"hint", "io.weight needs the BFQ scheduler or io.cost on the device; consider IO_LIMIT_MODE=max",
+		)
+	} else if result != previous {
+		m.logger.Info("IO weight check changed", "result", result, "previous", previous)
+	}
+}
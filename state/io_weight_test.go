@@ -0,0 +1,144 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+package state
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fdefilippo/resman/config"
+)
+
+// ioWeightCgroupManager registra le scritture io.weight e io.max.
+type ioWeightCgroupManager struct {
+	mockCgroupManager
+	ioWeights map[int]int
+	ioMax     []int
+}
+
+func (c *ioWeightCgroupManager) ApplyIOWeight(uid int, weight int) error {
+	c.ioWeights[uid] = weight
+	return nil
+}
+
+func (c *ioWeightCgroupManager) ApplyIOLimit(uid int, readBPS, writeBPS string, readIOPS, writeIOPS int, deviceFilter string) error {
+	c.ioMax = append(c.ioMax, uid)
+	return nil
+}
+
+func TestEvaluateIOWeights(t *testing.T) {
+	weights := map[int]int{1000: 100, 1001: 100}
+	tests := []struct {
+		name      string
+		bytes     map[int]uint64
+		pressure  map[int]float64
+		result    string
+		overshare []int
+	}{
+		{
+			name:     "single writer is not contention",
+			bytes:    map[int]uint64{1000: 100 << 20},
+			pressure: map[int]float64{1000: 50},
+			result:   IOWeightCheckIdle,
+		},
+		{
+			name:     "no pressure",
+			bytes:    map[int]uint64{1000: 90 << 20, 1001: 10 << 20},
+			pressure: map[int]float64{},
+			result:   IOWeightCheckIdle,
+		},
+		{
+			name:     "bandwidth follows weights",
+			bytes:    map[int]uint64{1000: 55 << 20, 1001: 45 << 20},
+			pressure: map[int]float64{1000: 20, 1001: 30},
+			result:   IOWeightCheckEffective,
+		},
+		{
+			name:      "one user takes the disk while the other stalls",
+			bytes:     map[int]uint64{1000: 95 << 20, 1001: 5 << 20},
+			pressure:  map[int]float64{1001: 60},
+			result:    IOWeightCheckIneffective,
+			overshare: []int{1000},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, overshare := evaluateIOWeights(tt.bytes, weights, tt.pressure)
+			if result != tt.result {
+				t.Errorf("result = %s, expected %s", result, tt.result)
+			}
+			if !reflect.DeepEqual(overshare, tt.overshare) {
+				t.Errorf("overshare = %v, expected %v", overshare, tt.overshare)
+			}
+		})
+	}
+}
+
+func TestIOWeightMode(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.IOEnabled = true
+	cfg.IOLimitMode = config.IOLimitModeWeight
+	cfg.FairShareEnabled = true
+
+	cgroups := &ioWeightCgroupManager{ioWeights: make(map[int]int)}
+	manager, err := NewManager(cfg, &mockMetricsCollector{}, cgroups, &mockPrometheusExporter{})
+	if err != nil {
+		t.Fatalf("NewManager() error: %v", err)
+	}
+	manager.setResourceActive(ResourceIO, true)
+	manager.mu.Lock()
+	manager.activeUsers[1000] = true
+	manager.activeUsers[1001] = true
+	manager.mu.Unlock()
+
+	// In modalita' weight i limiti IO dell'utente diventano io.weight
+	manager.applyUserIOLimits(cfg, 1000, "10M", "10M", 0, 0)
+	if len(cgroups.ioMax) != 0 {
+		t.Errorf("io.max should not be written in weight mode, got writes for %v", cgroups.ioMax)
+	}
+	if got := cgroups.ioWeights[1000]; got != defaultIOWeight {
+		t.Errorf("io.weight without history = %d, expected %d", got, defaultIOWeight)
+	}
+
+	// Chi scrive di piu' riceve un peso minore
+	now := time.Now()
+	manager.ioFairShare.Update(nil, time.Hour, now.Add(-10*time.Minute))
+	manager.updateIOFairShare(cfg, &SystemMetrics{
+		EligibleUsers:   []int{1000, 1001},
+		UserIOWriteRate: map[int]float64{1000: 200 << 20, 1001: 1 << 20},
+	})
+	if cgroups.ioWeights[1000] >= defaultIOWeight || cgroups.ioWeights[1001] <= defaultIOWeight {
+		t.Errorf("heavy writer should get a lower io.weight than the light one, got %v", cgroups.ioWeights)
+	}
+	minWeight, maxWeight := cfg.GetIOWeightRange()
+	for uid, weight := range cgroups.ioWeights {
+		if weight < minWeight || weight > maxWeight {
+			t.Errorf("io.weight for uid %d = %d outside [%d, %d]", uid, weight, minWeight, maxWeight)
+		}
+	}
+	if got := manager.getUserIOWeights(); got[1000] != cgroups.ioWeights[1000] {
+		t.Errorf("exported io.weight = %v, applied %v", got, cgroups.ioWeights)
+	}
+
+	// Il rilascio della risorsa riporta il peso di default
+	manager.removeResourceLimits(cfg, ResourceIO, []int{1000})
+	if got := cgroups.ioWeights[1000]; got != defaultIOWeight {
+		t.Errorf("io.weight after release = %d, expected %d", got, defaultIOWeight)
+	}
+}
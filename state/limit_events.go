@@ -0,0 +1,231 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+// state/limit_events.go
+package state
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/fdefilippo/resman/config"
+	"github.com/fdefilippo/resman/database"
+)
+
+// Motivi di chiusura degli episodi registrati nel journal.
+const (
+	LimitEventReasonIdle         = "idle"               // CPU sotto la soglia di inattivita'
+	LimitEventReasonGone         = "no_processes"       // l'utente non ha piu' processi
+	LimitEventReasonDeselected   = "deselected"         // non piu' tra gli utenti scelti da LIMIT_TARGET_MODE
+	LimitEventReasonDeactivated  = "limits_deactivated" // limiti disattivati per tutti
+	LimitEventReasonBoostExpired = "boost_expired"      // PSI_BOOST_DURATION trascorsa
+	LimitEventReasonRestarted    = "daemon_restarted"   // episodio non ripreso dopo il riavvio
+	LimitEventReasonReplaced     = "replaced"           // nuova finestra della policy
+)
+
+// LimitEventStore conserva il journal degli episodi di limitazione
+// (implementato da database.DatabaseManager).
+type LimitEventStore interface {
+	OpenLimitEvent(record *database.LimitEventRecord) error
+	CloseLimitEvent(uid int, eventType string, endedAt time.Time, reason string) error
+	GetLimitEvents(filter database.LimitEventFilter) ([]database.LimitEventRecord, error)
+}
+
+// SetLimitEventStore collega il database delle metriche al journal degli
+// episodi di limitazione.
+func (m *Manager) SetLimitEventStore(store LimitEventStore) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.limitEventStore = store
+}
+
+// journalStore restituisce lo store del journal, nil se assente o in
+// dry-run (nessun limite viene davvero scritto).
+func (m *Manager) journalStore() LimitEventStore {
+	m.mu.RLock()
+	store := m.limitEventStore
+	m.mu.RUnlock()
+	if store == nil || m.IsDryRun() {
+		return nil
+	}
+	return store
+}
+
+// openLimitEvent apre un episodio nel journal.
+func (m *Manager) openLimitEvent(record database.LimitEventRecord) {
+	store := m.journalStore()
+	if store == nil {
+		return
+	}
+	if record.Username == "" && m.metricsCollector != nil {
+		record.Username = m.metricsCollector.GetUsernameFromUID(record.UID)
+	}
+	if err := store.OpenLimitEvent(&record); err != nil {
+		m.logger.Warn("Failed to journal limit event",
+			"uid", record.UID,
+			"event_type", record.EventType,
+			"error", err,
+		)
+	}
+}
+
+// closeLimitEvent chiude l'episodio aperto di un utente, se c'e'.
+func (m *Manager) closeLimitEvent(uid int, eventType, reason string) {
+	store := m.journalStore()
+	if store == nil {
+		return
+	}
+	if err := store.CloseLimitEvent(uid, eventType, time.Now(), reason); err != nil {
+		m.logger.Warn("Failed to close limit event",
+			"uid", uid,
+			"event_type", eventType,
+			"error", err,
+		)
+	}
+}
+
+// journalUserLimited apre l'episodio di un utente appena spostato sotto
+// limite, con soglie e metriche del ciclo.
+func (m *Manager) journalUserLimited(cfg *config.Config, uid int, username string, metrics *SystemMetrics) {
+	m.mu.RLock()
+	reason := m.limitTargets[uid].reason
+	m.mu.RUnlock()
+	if reason == "" {
+		reason = fmt.Sprintf("total CPU %.1f%% above CPU_THRESHOLD", metrics.TotalCPUUsage)
+	}
+
+	record := database.LimitEventRecord{
+		UID:                 uid,
+		Username:            username,
+		EventType:           database.LimitEventLimit,
+		StartReason:         reason,
+		CPUThreshold:        cfg.CPUThreshold,
+		CPUReleaseThreshold: cfg.CPUReleaseThreshold,
+		CPUUsage:            metrics.UserCPUUsage[uid],
+		TotalCPUUsage:       metrics.TotalCPUUsage,
+		LimitedUsers:        metrics.LimitedUsersCount,
+	}
+	if userMetrics := metrics.UserMetrics[uid]; userMetrics != nil {
+		record.MemoryUsageBytes = int64(userMetrics.MemoryUsage)
+	}
+	m.openLimitEvent(record)
+}
+
+// journalUserReleased chiude l'episodio di limite di un utente e l'eventuale
+// boost PSI, che senza limite non ha piu' effetto.
+func (m *Manager) journalUserReleased(uid int, reason string) {
+	m.closeLimitEvent(uid, database.LimitEventLimit, reason)
+	m.closeLimitEvent(uid, database.LimitEventPSIBoost, reason)
+}
+
+// journalIOBoostSteps registra inizio e fine degli episodi di IO boost.
+func (m *Manager) journalIOBoostSteps(steps []IOBoostStep) {
+	for _, step := range steps {
+		switch step.Action {
+		case IOBoostActionStart:
+			m.openLimitEvent(database.LimitEventRecord{
+				UID:         step.UID,
+				EventType:   database.LimitEventIOBoost,
+				StartReason: step.Reason,
+				Detail:      fmt.Sprintf("multiplier %.2f, io.pressure %.1f%%", step.Multiplier, step.PSIAvg10),
+			})
+		case IOBoostActionEnd:
+			m.closeLimitEvent(step.UID, database.LimitEventIOBoost, step.Reason)
+		}
+	}
+}
+
+// journalPolicy riflette nel journal un evento dello storico delle policy:
+// ogni finestra applicata e' un episodio, chiuso dal rollback o dal revert.
+func (m *Manager) journalPolicy(record database.PolicyAuditRecord) {
+	switch record.Action {
+	case PolicyActionApply, PolicyActionPin, PolicyActionOverride:
+		m.closeLimitEvent(record.UID, database.LimitEventPolicy, LimitEventReasonReplaced)
+		detail := record.Action
+		if record.Pattern != "" {
+			detail += " " + record.Pattern
+		}
+		if record.Window != "" {
+			detail += " " + record.Window
+		}
+		if record.CPUMax != "" {
+			detail += ", cpu.max " + record.CPUMax
+		}
+		if record.MemoryMax != "" {
+			detail += ", memory.max " + record.MemoryMax
+		}
+		m.openLimitEvent(database.LimitEventRecord{
+			UID:         record.UID,
+			EventType:   database.LimitEventPolicy,
+			StartedAt:   record.Timestamp,
+			StartReason: record.Reason,
+			Detail:      detail,
+		})
+	case PolicyActionRollback, PolicyActionRevert:
+		reason := record.Action
+		if record.Reason != "" {
+			reason += ": " + record.Reason
+		}
+		m.closeLimitEvent(record.UID, database.LimitEventPolicy, reason)
+	}
+}
+
+// reconcileLimitEvents chiude gli episodi rimasti aperti da un'istanza
+// precedente che questa non ha ripreso: limiti e boost PSI degli utenti non
+// adottati da RestoreState, policy senza pin/override ripristinato e tutti
+// gli IO boost, il cui stato non e' salvato.
+func (m *Manager) reconcileLimitEvents() {
+	store := m.journalStore()
+	if store == nil {
+		return
+	}
+	open, err := store.GetLimitEvents(database.LimitEventFilter{OpenOnly: true})
+	if err != nil {
+		m.logger.Warn("Failed to read open limit events", "error", err)
+		return
+	}
+
+	m.mu.RLock()
+	active := make(map[int]bool, len(m.activeUsers))
+	for uid := range m.activeUsers {
+		active[uid] = true
+	}
+	boosted := make(map[int]bool, len(m.psiBoostedAt))
+	for uid := range m.psiBoostedAt {
+		boosted[uid] = true
+	}
+	m.mu.RUnlock()
+
+	for _, event := range open {
+		switch event.EventType {
+		case database.LimitEventLimit:
+			if active[event.UID] {
+				continue
+			}
+		case database.LimitEventPSIBoost:
+			if boosted[event.UID] {
+				continue
+			}
+		case database.LimitEventPolicy:
+			// Pin e override vengono ripristinati da RestoreState; le finestre
+			// della schedule riaprono un nuovo episodio quando vengono riapplicate
+			if m.policyEngine != nil && m.policyEngine.HasManualPolicy(event.UID) {
+				continue
+			}
+		}
+		m.closeLimitEvent(event.UID, event.EventType, LimitEventReasonRestarted)
+	}
+}
@@ -0,0 +1,118 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+// state/limit_events_test.go
+package state
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fdefilippo/resman/config"
+	"github.com/fdefilippo/resman/database"
+)
+
+func TestLimitEventJournal(t *testing.T) {
+	cfg := config.DefaultConfig()
+	manager, err := NewManager(cfg, &mockMetricsCollector{}, &mockCgroupManager{}, &mockPrometheusExporter{})
+	if err != nil {
+		t.Fatalf("NewManager() error: %v", err)
+	}
+	db, err := database.NewDatabaseManager(":memory:")
+	if err != nil {
+		t.Fatalf("NewDatabaseManager() error: %v", err)
+	}
+	defer db.Close()
+	manager.SetLimitEventStore(db)
+
+	metrics := &SystemMetrics{
+		TotalCPUUsage: 92,
+		UserCPUUsage:  map[int]float64{1000: 55, 1001: 30},
+	}
+	manager.journalUserLimited(cfg, 1000, "alice", metrics)
+	manager.journalUserLimited(cfg, 1001, "bob", metrics)
+	manager.openLimitEvent(database.LimitEventRecord{UID: 1000, EventType: database.LimitEventPSIBoost})
+
+	// Il rilascio chiude sia il limite sia il boost PSI
+	manager.journalUserReleased(1000, LimitEventReasonIdle)
+	events, err := db.GetLimitEvents(database.LimitEventFilter{UID: 1000})
+	if err != nil {
+		t.Fatalf("GetLimitEvents() error: %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("journal for uid 1000 has %d events, expected 2", len(events))
+	}
+	for _, event := range events {
+		if event.EndedAt == nil || event.EndReason != LimitEventReasonIdle {
+			t.Errorf("%s episode = ended %v, reason %q; expected closed by %s",
+				event.EventType, event.EndedAt, event.EndReason, LimitEventReasonIdle)
+		}
+		if event.EventType == database.LimitEventLimit &&
+			(event.CPUThreshold != cfg.CPUThreshold || event.CPUUsage != 55 || event.TotalCPUUsage != 92) {
+			t.Errorf("limit snapshot = %+v, expected thresholds and usage of the cycle", event)
+		}
+	}
+
+	// Le finestre di policy si chiudono col rollback
+	manager.journalPolicy(database.PolicyAuditRecord{UID: 1002, Action: PolicyActionApply, Pattern: "batch", Timestamp: time.Now()})
+	manager.journalPolicy(database.PolicyAuditRecord{UID: 1002, Action: PolicyActionRollback, Reason: "expired", Timestamp: time.Now()})
+	policy, err := db.GetLimitEvents(database.LimitEventFilter{UID: 1002, EventType: database.LimitEventPolicy})
+	if err != nil {
+		t.Fatalf("GetLimitEvents(policy) error: %v", err)
+	}
+	if len(policy) != 1 || policy[0].EndedAt == nil || policy[0].EndReason != "rollback: expired" {
+		t.Errorf("policy episodes = %+v, expected one closed by rollback", policy)
+	}
+
+	// Dopo un riavvio restano aperti solo gli episodi ripresi
+	manager.mu.Lock()
+	manager.activeUsers[1001] = true
+	manager.mu.Unlock()
+	manager.openLimitEvent(database.LimitEventRecord{UID: 1003, EventType: database.LimitEventLimit})
+	// Finestra della schedule interrotta dal riavvio e override ripristinato
+	manager.openLimitEvent(database.LimitEventRecord{UID: 1004, EventType: database.LimitEventPolicy})
+	manager.openLimitEvent(database.LimitEventRecord{UID: 1005, EventType: database.LimitEventPolicy})
+	manager.policyEngine.RestoreOverrides(map[int]persistedPolicyOverride{
+		1005: {Source: PolicySourceOverride, CPUQuota: 50, SetAt: time.Now()},
+	})
+	manager.reconcileLimitEvents()
+
+	open, err := db.GetLimitEvents(database.LimitEventFilter{OpenOnly: true})
+	if err != nil {
+		t.Fatalf("GetLimitEvents(OpenOnly) error: %v", err)
+	}
+	openUIDs := make(map[int]bool)
+	for _, event := range open {
+		openUIDs[event.UID] = true
+	}
+	if len(open) != 2 || !openUIDs[1001] || !openUIDs[1005] {
+		t.Errorf("open episodes after reconcile = %+v, expected uid 1001 and 1005", open)
+	}
+	closed, err := db.GetLimitEvents(database.LimitEventFilter{UID: 1003})
+	if err != nil {
+		t.Fatalf("GetLimitEvents(1003) error: %v", err)
+	}
+	if len(closed) != 1 || closed[0].EndReason != LimitEventReasonRestarted {
+		t.Errorf("uid 1003 episodes = %+v, expected closed by %s", closed, LimitEventReasonRestarted)
+	}
+	closed, err = db.GetLimitEvents(database.LimitEventFilter{UID: 1004})
+	if err != nil {
+		t.Fatalf("GetLimitEvents(1004) error: %v", err)
+	}
+	if len(closed) != 1 || closed[0].EndReason != LimitEventReasonRestarted {
+		t.Errorf("uid 1004 policy episodes = %+v, expected closed by %s", closed, LimitEventReasonRestarted)
+	}
+}
@@ -14,7 +14,14 @@ import (
 	"github.com/fdefilippo/resman/config"
 )
 
+// Tipi di evento notificati dal limit hook (campo "event").
+const (
+	LimitHookEventLimited = "limited"  // utente spostato sotto limite
+	LimitHookEventOOMKill = "oom_kill" // processo dell'utente ucciso dall'OOM killer
+)
+
 type limitHookEvent struct {
+	Event           string    `json:"event"`
 	UID             int       `json:"uid"`
 	Username        string    `json:"username"`
 	CPUUsage        float64   `json:"cpu_usage"`
@@ -23,18 +30,33 @@ type limitHookEvent struct {
 	Timestamp       time.Time `json:"timestamp"`
 	ServerRole      string    `json:"server_role,omitempty"`
 	LimitHookSource string    `json:"source"`
+	// Motivo della selezione dell'utente (LIMIT_TARGET_MODE)
+	Reason string `json:"reason,omitempty"`
+
+	// Evento oom_kill: nuovi kill in memory.events e processo ucciso, se
+	// trovato nel log del kernel
+	OOMKills      uint64 `json:"oom_kills,omitempty"`
+	VictimPID     int    `json:"victim_pid,omitempty"`
+	VictimCommand string `json:"victim_command,omitempty"`
 }
 
 func (m *Manager) notifyUserLimited(cfg *config.Config, uid int, username string, metrics *SystemMetrics) {
 	if cfg == nil || !cfg.LimitHookEnabled {
 		return
 	}
+	// In dry-run l'utente non e' davvero limitato: niente notifiche esterne
+	if cfg.GetDryRun() {
+		m.logger.Debug("Dry run: limit hook not run", "uid", uid, "username", username)
+		return
+	}
 
 	m.mu.RLock()
 	sharedCgroup := m.sharedCgroupPath
+	reason := m.limitTargets[uid].reason
 	m.mu.RUnlock()
 
 	event := limitHookEvent{
+		Event:           LimitHookEventLimited,
 		UID:             uid,
 		Username:        username,
 		CPUUsage:        metrics.UserCPUUsage[uid],
@@ -43,6 +65,7 @@ func (m *Manager) notifyUserLimited(cfg *config.Config, uid int, username string
 		Timestamp:       time.Now().UTC(),
 		ServerRole:      cfg.ServerRole,
 		LimitHookSource: "resman",
+		Reason:          reason,
 	}
 
 	go m.runLimitHook(cfg, event)
@@ -86,7 +109,16 @@ func runLimitHookScript(ctx context.Context, script string, event limitHookEvent
 		"RESMAN_LIMIT_SHARED_CGROUP="+event.SharedCgroup,
 		"RESMAN_LIMIT_TIMESTAMP="+event.Timestamp.Format(time.RFC3339),
 		"RESMAN_LIMIT_SERVER_ROLE="+event.ServerRole,
+		"RESMAN_LIMIT_REASON="+event.Reason,
+		"RESMAN_LIMIT_EVENT="+event.Event,
 	)
+	if event.Event == LimitHookEventOOMKill {
+		cmd.Env = append(cmd.Env,
+			"RESMAN_LIMIT_OOM_KILLS="+strconv.FormatUint(event.OOMKills, 10),
+			"RESMAN_LIMIT_VICTIM_PID="+strconv.Itoa(event.VictimPID),
+			"RESMAN_LIMIT_VICTIM_COMMAND="+event.VictimCommand,
+		)
+	}
 
 	output, err := cmd.CombinedOutput()
 	if err != nil {
@@ -2,9 +2,7 @@ package state
 
 import (
 	"fmt"
-	"os"
 	"path/filepath"
-	"strconv"
 	"time"
 
 	"github.com/fdefilippo/resman/config"
@@ -17,16 +15,29 @@ func (m *Manager) releaseIdleUsers(metrics *SystemMetrics) error {
 	// Soglia per considerare un utente "inattivo" (0.1% CPU)
 	const idleThreshold = 0.1
 
+	cfg := m.GetConfig()
+	targeting := cfg.GetLimitTargetMode() != config.LimitTargetAll
+	targets := m.targetedUsers(cfg, metrics)
+
 	m.mu.Lock()
 	sharedPath := m.sharedCgroupPath
 	usersToRelease := make([]int, 0)
 	usersToAdd := make([]int, 0) // utenti da riaggiungere (erano stati rilasciati ma sono tornati attivi)
+	releaseReasons := make(map[int]string) // motivo del rilascio, per il journal
 
 	for uid := range m.activeUsers {
 		// Controlla se l'utente è ancora attivo (ha processi in esecuzione)
 		// O(1) lookup instead of O(N*M) linear search
 		if _, userStillActive := metrics.UserCPUUsage[uid]; !userStillActive {
 			usersToRelease = append(usersToRelease, uid)
+			releaseReasons[uid] = LimitEventReasonGone
+			continue
+		}
+
+		// Utente non piu' tra quelli che generano il carico (LIMIT_TARGET_MODE)
+		if _, selected := m.limitTargets[uid]; targeting && !selected {
+			usersToRelease = append(usersToRelease, uid)
+			releaseReasons[uid] = LimitEventReasonDeselected
 			continue
 		}
 
@@ -35,14 +46,16 @@ func (m *Manager) releaseIdleUsers(metrics *SystemMetrics) error {
 			if cpuUsage < idleThreshold {
 				// Utente inattivo (CPU < 0.1%)
 				usersToRelease = append(usersToRelease, uid)
+				releaseReasons[uid] = LimitEventReasonIdle
 			}
 		}
 	}
 
 	// Controlla se ci sono utenti eligible (passano i filtri config) che sono
 	// sopra la soglia di idle ma non sono in activeUsers (erano stati rilasciati
-	// in precedenza da releaseIdleUsers). Devono essere riaggiunti.
-	for _, uid := range metrics.EligibleUsers {
+	// in precedenza da releaseIdleUsers o sono appena stati selezionati da
+	// LIMIT_TARGET_MODE). Devono essere riaggiunti.
+	for _, uid := range targets {
 		if _, active := m.activeUsers[uid]; active {
 			continue
 		}
@@ -59,26 +72,31 @@ func (m *Manager) releaseIdleUsers(metrics *SystemMetrics) error {
 			m.psiWatcher.RemoveMonitor(uid, "cpu")
 			m.psiWatcher.RemoveMonitor(uid, "io")
 		}
+		parentPath := m.takeUserCgroupParentLocked(uid, sharedPath)
 		// Sposta l'utente fuori dal cgroup condiviso e rimuovi il sottocgroup.
 		m.wg.Add(1)
-		go func(uid int, sharedPath string) {
+		go func(uid int, parentPath string) {
 			defer m.wg.Done()
-			if sharedPath == "" {
+			if parentPath == "" {
 				return
 			}
-			if err := m.cgroupManager.ReleaseUserFromSharedCgroup(uid, sharedPath); err != nil {
+			if err := m.cgroupManager.ReleaseUserFromSharedCgroup(uid, parentPath); err != nil {
 				m.logger.Warn("Failed to release idle user from shared cgroup",
-					"uid", uid, "shared_path", sharedPath, "error", err)
+					"uid", uid, "shared_path", parentPath, "error", err)
 			}
-		}(uid, sharedPath)
+		}(uid, parentPath)
 	}
 
 	remainingLimited := len(m.activeUsers)
 	m.mu.Unlock()
 
+	for _, uid := range usersToRelease {
+		m.journalUserReleased(uid, releaseReasons[uid])
+	}
+
 	// Log rilascio
 	if len(usersToRelease) > 0 {
-		m.logger.Info("Releasing idle users from CPU limits",
+		m.logger.Info("Releasing idle or deselected users from limits",
 			"users_released", len(usersToRelease),
 			"users_still_limited", remainingLimited,
 			"idle_threshold", idleThreshold,
@@ -94,28 +112,35 @@ func (m *Manager) releaseIdleUsers(metrics *SystemMetrics) error {
 			m.logger.Info("Re-adding user to shared cgroup (CPU usage recovered)",
 				"uid", uid, "username", username,
 				"cpu", metrics.UserCPUUsage[uid],
+				"reason", m.limitTargetReason(uid),
 			)
 
-			userCgroupPath, err := m.cgroupManager.CreateUserSubCgroup(uid, sharedPath)
+			parentPath := m.cgroupParentFor(cfg, uid, sharedPath)
+			userCgroupPath, err := m.cgroupManager.CreateUserSubCgroup(uid, parentPath)
 			if err != nil {
 				m.logger.Warn("Failed to re-create user sub-cgroup",
 					"uid", uid, "error", err)
 				continue
 			}
+			m.setUserCgroupParent(uid, parentPath)
+			m.notifyUserLimited(cfg, uid, username, metrics)
+			m.journalUserLimited(cfg, uid, username, metrics)
 
-			m.wg.Add(1)
-			go func(uid int, sharedPath string) {
-				defer m.wg.Done()
-				time.Sleep(300 * time.Millisecond)
-				if err := m.cgroupManager.MoveAllUserProcessesToSharedCgroup(uid, sharedPath); err != nil {
-					m.logger.Warn("Failed to move processes for re-added user",
-						"uid", uid, "error", err)
-				}
-				if err := m.cgroupManager.ApplyCPUWeight(uid, 100); err != nil {
-					m.logger.Warn("Failed to set CPU weight for re-added user",
-						"uid", uid, "weight", 100, "error", err)
-				}
-			}(uid, sharedPath)
+			if m.cgroupManager.UsesSystemdSlices() {
+				// Nessuno spostamento: i processi sono gia' nella slice
+				m.applyUserLimits(cfg, uid)
+			} else {
+				m.wg.Add(1)
+				go func(uid int, sharedPath string) {
+					defer m.wg.Done()
+					time.Sleep(300 * time.Millisecond)
+					if err := m.cgroupManager.MoveAllUserProcessesToSharedCgroup(uid, sharedPath); err != nil {
+						m.logger.Warn("Failed to move processes for re-added user",
+							"uid", uid, "error", err)
+					}
+					m.applyUserLimits(m.GetConfig(), uid)
+				}(uid, parentPath)
+			}
 
 			if m.psiWatcher != nil {
 				cpuPressurePath := filepath.Join(userCgroupPath, "cpu.pressure")
@@ -143,13 +168,16 @@ func (m *Manager) releaseIdleUsers(metrics *SystemMetrics) error {
 		return nil
 	}
 
+	// Con le slice systemd la quota condivisa va ridivisa tra gli utenti rimasti
+	m.applySliceCPUShares(cfg, metrics)
+
 	return nil
 }
 
 
 func (m *Manager) activateLimits(metrics *SystemMetrics) error {
 	cfg := m.GetConfig()
-	m.logger.Info("Activating CPU limits with proportional weights")
+	m.logger.Info("Activating limits for users in the shared cgroup")
 
 	// Incrementa il contatore di attivazioni
 	if m.prometheusExporter != nil {
@@ -183,6 +211,7 @@ func (m *Manager) activateLimits(metrics *SystemMetrics) error {
 			m.mu.Unlock()
 
 			removedCount++
+			m.journalUserReleased(uid, LimitEventReasonGone)
 			m.logger.Debug("User removed from active tracking", "uid", uid)
 		}
 	}
@@ -203,18 +232,19 @@ func (m *Manager) activateLimits(metrics *SystemMetrics) error {
 		m.mu.Unlock()
 
 		// Calcola la quota TOTALE per tutti gli utenti
-		availableCores := metrics.TotalCores - cfg.GetMinSystemCores()
-		if availableCores < 1 {
-			availableCores = 1
-		}
+		sharedQuota, availableCores := sharedCPUQuota(cfg, metrics)
 
-		// Converti in quota cgroup
-		totalQuota := availableCores * 100000
-		sharedQuota := fmt.Sprintf("%d 100000", totalQuota)
-
-		// Applica la quota al cgroup condiviso
-		if err := m.cgroupManager.ApplySharedCPULimit(sharedPath, sharedQuota); err != nil {
-			return fmt.Errorf("failed to apply shared CPU limit %s to %s: %w", sharedQuota, sharedPath, err)
+		// Applica la quota al cgroup condiviso solo se i limiti CPU sono attivi
+		// (il cgroup condiviso puo' servire solo per RAM o IO)
+		if !m.isResourceActive(ResourceCPU) {
+			sharedQuota = "max 100000"
+		}
+		// Con le slice systemd la quota e' divisa tra le user-<uid>.slice
+		// (applySliceCPUShares): user.slice contiene anche gli utenti non limitati
+		if !m.cgroupManager.UsesSystemdSlices() {
+			if err := m.cgroupManager.ApplySharedCPULimit(sharedPath, sharedQuota); err != nil {
+				return fmt.Errorf("failed to apply shared CPU limit %s to %s: %w", sharedQuota, sharedPath, err)
+			}
 		}
 
 		m.logger.Info("Shared cgroup configured",
@@ -231,7 +261,8 @@ func (m *Manager) activateLimits(metrics *SystemMetrics) error {
 	// Filter chain: EligibleUsers = USER_INCLUDE_LIST + USER_EXCLUDE_LIST (gatekeeper)
 	//   → shouldApplyRAMLimits = RAM_USER_INCLUDE_LIST + RAM_USER_EXCLUDE_LIST (sub-filter)
 	//   → shouldApplyIOLimits  = IO_USER_INCLUDE_LIST  + IO_USER_EXCLUDE_LIST  (sub-filter)
-	for _, uid := range metrics.EligibleUsers {
+	//   → targetedUsers        = LIMIT_TARGET_MODE (solo gli utenti che generano il carico)
+	for _, uid := range m.targetedUsers(cfg, metrics) {
 		username := m.metricsCollector.GetUsernameFromUID(uid)
 		userStr := fmt.Sprintf("%s(%d)", username, uid)
 		// Verifica se l'utente è già limitato
@@ -241,14 +272,16 @@ func (m *Manager) activateLimits(metrics *SystemMetrics) error {
 
 		if !alreadyLimited {
 			// Crea il sottocgroup per l'utente dentro il cgroup condiviso
+			// (o dentro limited/<group> se l'utente appartiene a un gruppo)
 			m.mu.RLock()
 			sharedPath := m.sharedCgroupPath
 			m.mu.RUnlock()
-			userCgroupPath, err := m.cgroupManager.CreateUserSubCgroup(uid, sharedPath)
+			parentPath := m.cgroupParentFor(cfg, uid, sharedPath)
+			userCgroupPath, err := m.cgroupManager.CreateUserSubCgroup(uid, parentPath)
 			if err != nil {
 				m.logger.Error("Failed to create user sub-cgroup",
 					"user", userStr,
-					"shared_cgroup", sharedPath,
+					"shared_cgroup", parentPath,
 					"error", err,
 				)
 				if firstError == nil {
@@ -256,6 +289,7 @@ func (m *Manager) activateLimits(metrics *SystemMetrics) error {
 				}
 				continue
 			}
+			m.setUserCgroupParent(uid, parentPath)
 
 			// Avvia monitoraggio PSI per questo utente (adaptive boosting)
 			if m.psiWatcher != nil {
@@ -271,94 +305,30 @@ func (m *Manager) activateLimits(metrics *SystemMetrics) error {
 				}
 			}
 
-			// Imposta il peso per l'utente (uguale per tutti)
-			// I pesi sono relativi: se tutti hanno peso 100, ottengono parti uguali
-			// Se un utente non usa CPU, gli altri possono usare più della loro parte
-			weight := 100 // Peso uguale per tutti
-
-			// Sposta i processi dell'utente nel cgroup condiviso
-			m.wg.Add(1)
-			go func(uid int, weight int, username string, sharedPath string) {
-				defer m.wg.Done()
-				time.Sleep(300 * time.Millisecond)
-				if err := m.cgroupManager.MoveAllUserProcessesToSharedCgroup(uid, sharedPath); err != nil {
-					m.logger.Warn("Failed to move some processes to shared cgroup",
-						"uid", uid,
-						"username", username,
-						"shared_cgroup", sharedPath,
-						"error", err,
-					)
-				}
-
-				// Imposta il peso dopo aver spostato i processi
-				if err := m.cgroupManager.ApplyCPUWeight(uid, weight); err != nil {
-					m.logger.Warn("Failed to set CPU weight for user, using default",
-						"uid", uid,
-						"username", username,
-						"weight", weight,
-						"error", err,
-					)
-				}
-
-				// Applica limite RAM se abilitato e l'utente è soggetto a RAM limits
-				if m.shouldApplyRAMLimits(uid) {
-					quotaBytes, err := config.ParseRAMQuota(cfg.RAMQuotaPerUser)
-					if err != nil || quotaBytes == 0 {
-						m.logger.Debug("RAM quota per user is 0 or invalid, skipping",
-							"uid", uid,
-							"quota", cfg.RAMQuotaPerUser,
-						)
-					} else {
-						// Calcola memory.high come percentuale di memory.max
-						highBytes := uint64(float64(quotaBytes) * cfg.GetRAMHighRatio())
-						highStr := strconv.FormatUint(highBytes, 10)
-						maxStr := cfg.RAMQuotaPerUser
-
-						if cfg.DisableSwap {
-							if err := m.cgroupManager.ApplyRAMLimitWithHighAndSwapDisabled(uid, maxStr, highStr); err != nil {
-								m.logger.Warn("Failed to apply RAM high+max limits with swap disabled for user",
-									"uid", uid,
-									"high", highStr,
-									"max", maxStr,
-									"error", err,
-								)
-							}
-						} else {
-							if err := m.cgroupManager.ApplyRAMLimitWithHigh(uid, maxStr, highStr); err != nil {
-								m.logger.Warn("Failed to apply RAM high+max limits for user",
-									"uid", uid,
-									"high", highStr,
-									"max", maxStr,
-									"error", err,
-								)
-							}
-						}
-					}
-				}
-				// Applica limiti IO se abilitati
-				if m.shouldApplyIOLimits(uid) {
-					readBPS := cfg.GetIOReadBPS()
-					writeBPS := cfg.GetIOWriteBPS()
-					readIOPS := cfg.GetIOReadIOPS()
-					writeIOPS := cfg.GetIOWriteIOPS()
-					deviceFilter := cfg.GetIODeviceFilter()
-
-					if err := m.cgroupManager.ApplyIOLimit(uid, readBPS, writeBPS, readIOPS, writeIOPS, deviceFilter); err != nil {
-						m.logger.Warn("Failed to apply IO limit for user",
+			// Con le slice systemd i processi (anche quelli nati dopo) sono gia'
+			// nel cgroup dell'utente: i limiti si applicano subito
+			if m.cgroupManager.UsesSystemdSlices() {
+				m.applyUserLimits(cfg, uid)
+			} else {
+				// Sposta i processi dell'utente nel cgroup condiviso
+				m.wg.Add(1)
+				go func(uid int, username string, sharedPath string) {
+					defer m.wg.Done()
+					time.Sleep(300 * time.Millisecond)
+					if err := m.cgroupManager.MoveAllUserProcessesToSharedCgroup(uid, sharedPath); err != nil {
+						m.logger.Warn("Failed to move some processes to shared cgroup",
 							"uid", uid,
-							"readBPS", readBPS,
-							"writeBPS", writeBPS,
+							"username", username,
+							"shared_cgroup", sharedPath,
 							"error", err,
 						)
-					} else {
-						m.logger.Debug("IO limit applied for user",
-							"uid", uid,
-							"readBPS", readBPS,
-							"writeBPS", writeBPS,
-						)
 					}
-				}
-			}(uid, weight, username, sharedPath)
+
+					// Dopo aver spostato i processi applica i file delle risorse attive:
+					// cpu.weight e cpu.max, limiti RAM e IO (globali o da USER_OVERRIDES_FILE)
+					m.applyUserLimits(cfg, uid)
+				}(uid, username, parentPath)
+			}
 
 			// Segna l'utente come limitato
 			m.mu.Lock()
@@ -367,22 +337,24 @@ func (m *Manager) activateLimits(metrics *SystemMetrics) error {
 
 			limitedCount++
 			m.notifyUserLimited(cfg, uid, username, metrics)
+			m.journalUserLimited(cfg, uid, username, metrics)
 
 			m.logger.Debug("User configured in shared cgroup",
 				"uid", uid,
-				"weight", weight,
 				"shared_path", m.sharedCgroupPath,
 			)
 		}
 	}
 
 	if limitedCount > 0 || removedCount > 0 {
+		m.applySliceCPUShares(cfg, metrics)
+
 		m.mu.Lock()
 		m.limitsActive = true
 		m.limitsAppliedTime = time.Now()
 		m.mu.Unlock()
 
-		m.logger.Info("CPU limits activated with proportional sharing",
+		m.logger.Info("Limits activated with proportional sharing",
 			"users_limited", limitedCount,
 			"users_freed", removedCount,
 			"total_active_users", len(metrics.UserCPUUsage),
@@ -398,10 +370,15 @@ func (m *Manager) activateLimits(metrics *SystemMetrics) error {
 
 func (m *Manager) deactivateLimits() error {
 	cfg := m.GetConfig()
-	m.logger.Info("Deactivating CPU limits")
+	m.logger.Info("Deactivating limits")
 
 	m.wg.Wait()
 
+	// Tutte le risorse tornano inattive: gli utenti escono dal cgroup condiviso
+	for _, res := range Resources {
+		m.setResourceActive(res, false)
+	}
+
 	// Incrementa il contatore di disattivazioni
 	if m.prometheusExporter != nil {
 		m.prometheusExporter.IncrementLimitsDeactivated()
@@ -426,6 +403,7 @@ func (m *Manager) deactivateLimits() error {
 	// Pulisci il percorso del cgroup condiviso
 	sharedPath := m.sharedCgroupPath
 	m.sharedCgroupPath = ""
+	m.cpusetApplied, m.cpusetAppliedPath = "", ""
 	m.mu.Unlock()
 
 	// Rimuovi monitoraggi PSI per questi utenti
@@ -450,6 +428,10 @@ func (m *Manager) deactivateLimits() error {
 	}
 	m.mu.Unlock()
 
+	for _, uid := range usersToCleanup {
+		m.journalUserReleased(uid, LimitEventReasonDeactivated)
+	}
+
 	var firstError error
 	deactivatedCount := 0
 
@@ -505,9 +487,32 @@ func (m *Manager) deactivateLimits() error {
 		}
 	}
 
+	// Le slice systemd restano: si tolgono i limiti residui (cpu.max con la
+	// parte della quota condivisa, cpu.weight, override). user.slice non viene
+	// toccata: contiene anche gli utenti mai limitati
+	if m.cgroupManager.UsesSystemdSlices() {
+		for _, uid := range usersToCleanup {
+			if err := m.cgroupManager.ReleaseUserFromSharedCgroup(uid, sharedPath); err != nil {
+				m.logger.Warn("Failed to reset user slice",
+					"uid", uid,
+					"error", err,
+				)
+			}
+		}
+		m.logger.Info("Limits deactivated",
+			"users_freed", deactivatedCount,
+			"attempted", userCount,
+			"user_slice", sharedPath,
+		)
+		return firstError
+	}
+
+	// Rimuovi prima i cgroup dei gruppi (limited/<group>), poi quello condiviso
+	m.removeGroupCgroups()
+
 	// Rimuovi il cgroup condiviso se esiste
 	if sharedPath != "" {
-		if err := os.RemoveAll(sharedPath); err != nil {
+		if err := m.cgroupManager.RemoveSharedCgroup(sharedPath); err != nil {
 			m.logger.Warn("Failed to remove shared cgroup",
 				"path", sharedPath,
 				"error", err,
@@ -517,7 +522,7 @@ func (m *Manager) deactivateLimits() error {
 		}
 	}
 
-	m.logger.Info("CPU limits deactivated",
+	m.logger.Info("Limits deactivated",
 		"users_freed", deactivatedCount,
 		"attempted", userCount,
 		"shared_cgroup_removed", sharedPath != "",
@@ -556,7 +561,11 @@ func (m *Manager) ForceActivateLimits() error {
 	if err != nil {
 		return err
 	}
-	return m.activateLimits(metrics)
+	cfg := m.GetConfig()
+	m.updateLimitTargets(cfg, metrics)
+	err = m.activateResources(enabledResources(cfg), metrics)
+	m.persistState()
+	return err
 }
 
 
@@ -572,6 +581,7 @@ func (m *Manager) ForceDeactivateLimits() error {
 	m.stabilityTracker.mu.Lock()
 	m.stabilityTracker.underThreshold = make(map[int]int)
 	m.stabilityTracker.mu.Unlock()
+	m.persistState()
 	return err
 }
 
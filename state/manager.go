@@ -29,6 +29,7 @@ import (
 
 	"github.com/fdefilippo/resman/cgroup"
 	"github.com/fdefilippo/resman/config"
+	"github.com/fdefilippo/resman/database"
 	"github.com/fdefilippo/resman/logging"
 	resmanmetrics "github.com/fdefilippo/resman/metrics"
 )
@@ -41,6 +42,10 @@ type Manager struct {
 	opMu   sync.Mutex
 	wg     sync.WaitGroup
 
+	// Sweep dei processi sfuggiti: attivo fino alla fine del contesto, quindi
+	// non puo' stare in wg (atteso anche da deactivateLimits)
+	watcherWg sync.WaitGroup
+
 	// Stato interno
 	limitsActive      bool
 	limitsAppliedTime time.Time
@@ -52,6 +57,7 @@ type Manager struct {
 	ioThresholdTracker  *ThresholdTracker
 	stabilityTracker    *UserStabilityTracker
 	lastPatternAnalysis time.Time
+	patternStatsLoaded  bool
 
 	// Dipendenze (saranno iniettate)
 	metricsCollector   MetricsCollector
@@ -60,6 +66,10 @@ type Manager struct {
 	ioRemediation      *IORemediation
 	patternDetector    *PatternDetector
 	policyEngine       *PolicyEngine
+	patternStore       PatternStore
+	policyAuditStore   PolicyAuditStore
+	memoryEventsStore  MemoryEventsStore
+	limitEventStore    LimitEventStore
 
 	// Cache per le metriche (per performance)
 	metricsCache     map[string]interface{}
@@ -76,6 +86,41 @@ type Manager struct {
 	// PSI watcher for per-user adaptive CPU weight boosting
 	psiWatcher   *cgroup.PSIWatcher
 	psiBoostedAt map[int]time.Time // uid -> when last boosted
+
+	// Fair-share: cpu.weight derivato dal consumo CPU recente
+	fairShare *FairShareTracker
+
+	// IO_LIMIT_MODE=weight: io.weight derivato dalla scrittura recente e
+	// verifica del suo effetto con io.stat e PSI
+	ioFairShare   *FairShareTracker
+	ioWeightCheck *ioWeightVerifier
+
+	// RAM_DYNAMIC_HIGH: memory.high regolato da working set e memory.pressure
+	memoryHigh *MemoryHighController
+
+	// oom_kill di memory.events al ciclo precedente, per notificare i nuovi kill
+	prevOOMKills map[int]uint64
+
+	// CPUSET_ISOLATION: cpuset.cpus applicato al cgroup condiviso, ricalcolato
+	// quando cambiano le CPU online
+	cpusetApplied     string
+	cpusetAppliedPath string
+
+	// Gruppi utente: limited/<group>/user_<uid>
+	groupCgroupPaths map[string]string // group name -> cgroup path
+	userCgroupParent map[int]string    // uid -> parent di user_<uid> (gruppo o shared)
+
+	// Macchine a stati indipendenti per CPU, RAM e IO (protette da mu)
+	limiters map[Resource]*resourceLimiter
+
+	// Utenti selezionati da LIMIT_TARGET_MODE con il motivo (protetti da mu)
+	limitTargets map[int]limitTarget
+
+	// DRY_RUN: avvolge cgroupManager e registra le scritture invece di eseguirle
+	dryRun *dryRunCgroupManager
+
+	// Ultimo stato salvato in STATE_FILE (senza saved_at), per scrivere solo i cambiamenti
+	lastSavedState []byte
 }
 
 // ThresholdTracker monitora il superamento della soglia CPU nel tempo
@@ -110,6 +155,7 @@ type MetricsCollector interface {
 type CgroupManager interface {
 	CreateUserCgroup(uid int) error
 	ApplyCPULimit(uid int, quota string) error
+	ApplyPolicyCPULimit(uid int, quota string) error
 	ApplyCPUWeight(uid int, weight int) error
 	RemoveCPULimit(uid int) error
 	ApplyRAMLimit(uid int, limit string) error
@@ -119,24 +165,45 @@ type CgroupManager interface {
 	ApplyRAMLimitWithHighAndSwapDisabled(uid int, maxLimit string, highLimit string) error
 	RemoveRAMLimit(uid int) error
 	RemoveRAMHigh(uid int) error
+	ApplySwapLimits(uid int, swapMax, swapHigh, zswapMax string) error
+	RemoveSwapLimits(uid int) error
 	GetCgroupRAMUsage(uid int) (uint64, error)
 	GetMemoryHighEvents(uid int) (uint64, error)
+	GetMemoryWorkingSet(uid int) (uint64, error)
+	GetMemoryPSIStats(uid int) (cgroup.PSIStats, error)
+	GetMemoryEvents(uid int) (cgroup.MemoryEvents, error)
+	GetMemoryStat(uid int) (cgroup.MemoryStat, error)
+	ReadOOMKills() ([]cgroup.OOMKill, error)
 	ApplyIOLimit(uid int, readBPS, writeBPS string, readIOPS, writeIOPS int, deviceFilter string) error
 	RemoveIOLimit(uid int) error
+	ApplyIOWeight(uid int, weight int) error
+	ApplyIOLatency(uid int, targetUsec int, deviceFilter string) error
 	GetIOStats(uid int) (readBytes, writeBytes uint64, readOps, writeOps uint64, err error)
+	GetIODeviceStats(uid int) ([]cgroup.IODeviceStats, error)
 	GetUserCgroupMetrics(uid int) (cgroupPath, cpuQuota string, memoryHighEvents uint64, ioReadBytes, ioWriteBytes, ioReadOps, ioWriteOps uint64, err error)
 	GetPSIStats(uid int) (cgroup.PSIStats, error)
 	ApplyTemporaryIOLimit(uid int, readBPS, writeBPS string, readIOPS, writeIOPS int, deviceFilter string, multiplier float64) error
 	CleanupUserCgroup(uid int) error
 	MoveProcessToCgroup(pid int, uid int) error
 	MoveAllUserProcessesToSharedCgroup(uid int, sharedPath string) error
+	MoveProcessToSharedCgroup(pid int, sharedPath string, uid int) error
+	FindEscapedProcesses(targets map[int]string) map[int][]int
 	ReleaseUserFromSharedCgroup(uid int, sharedPath string) error
 	CreateSharedCgroup() (string, error)
+	RemoveSharedCgroup(sharedPath string) error
+	AdoptSharedCgroup() (string, map[int]string, error)
 	ApplySharedCPULimit(sharedPath string, quota string) error
+	ApplySharedIOWeight(sharedPath string, weight int) error
+	ApplySharedCPUSet(sharedPath string, cpus string) error
+	LimitedCPUSet() (allowed string, reserved string, err error)
 	CreateUserSubCgroup(uid int, sharedPath string) (string, error)
+	ApplyUserSubCgroupCPULimit(uid int, quota string) error
+	CreateGroupCgroup(sharedPath string, group config.UserGroup) (string, error)
+	RemoveGroupCgroup(groupPath string) error
 	CleanupAll() error
 	GetCgroupInfo(uid int) (map[string]string, error)
 	GetCreatedCgroups() []int
+	UsesSystemdSlices() bool
 }
 
 // PrometheusExporter è l'interfaccia per esportare metriche Prometheus.
@@ -145,12 +212,25 @@ type PrometheusExporter interface {
 	UpdateUserMetrics(uid int, username string, cpuUsage float64, cpuUsageAverage float64, cpuUsageEMA float64, memoryUsage uint64, processCount int, isLimited bool, cgroupPath, cpuQuota string, memoryHighEvents uint64, ioReadBytes, ioWriteBytes, ioReadOps, ioWriteOps uint64)
 	UpdateSystemMetrics(totalCores int, actionCores int, systemLoad float64)
 	UpdateUserWorkloadPattern(uid int, username string, pattern string, confidence float64)
+	UpdateUserCPUSource(uid int, username string, source string)
+	UpdateUserCPUWeights(weights map[int]int)
+	UpdateUserIOWeights(weights map[int]int)
+	RecordIOWeightCheck(result string)
+	RecordMemoryHighStep(uid int, username string, action, reason string, high uint64)
+	UpdateUserMemoryEvents(uid int, username string, events map[string]uint64)
+	UpdateUserMemoryStat(uid int, username string, stat map[string]uint64)
 	RecordControlCycleTrigger(trigger string)
 	Start(ctx context.Context) error
 	Stop() error
 	CleanupUserMetrics(activeUids map[int]bool)
 	IncrementLimitsActivated()
 	IncrementLimitsDeactivated()
+	RecordResourceLimitState(resource string, active bool)
+	SetDryRunEnabled(enabled bool)
+	RecordDryRunWrite(operation string)
+	RecordProcessEscapes(uid int, username string, moved, failed int)
+	RecordIOBoostStep(uid int, username string, action, reason string, multiplier float64)
+	UpdateUserIODeviceStats(uid int, username string, device string, readBytes, writeBytes, readOps, writeOps uint64)
 }
 
 // NewManager crea un nuovo Manager con le dipendenze configurate.
@@ -191,6 +271,30 @@ func NewManager(
 		},
 		prevIOBytes:  make(map[int]uint64),
 		psiBoostedAt: make(map[int]time.Time),
+		fairShare:    NewFairShareTracker(),
+
+		ioFairShare:   NewFairShareTracker(),
+		ioWeightCheck: newIOWeightVerifier(),
+
+		memoryHigh: NewMemoryHighController(logger),
+
+		prevOOMKills: make(map[int]uint64),
+
+		groupCgroupPaths: make(map[string]string),
+		userCgroupParent: make(map[int]string),
+	}
+	for _, res := range Resources {
+		mgr.limiterLocked(res)
+	}
+
+	// Tutte le scritture passano dal recorder DRY_RUN, trasparente se disattivo
+	if cgroups != nil {
+		mgr.dryRun = newDryRunCgroupManager(cgroups, mgr.GetConfig, mgr.onPlannedWrite)
+		mgr.dryRun.SetEnabled(cfg.GetDryRun())
+		mgr.cgroupManager = mgr.dryRun
+	}
+	if prometheus != nil {
+		prometheus.SetDryRunEnabled(cfg.GetDryRun())
 	}
 
 	logger.Info("State manager initialized",
@@ -199,6 +303,7 @@ func NewManager(
 		"cpu_release_threshold", cfg.CPUReleaseThreshold,
 		"cpu_threshold_duration", cfg.CPUThresholdDuration,
 		"ignore_system_load", cfg.IgnoreSystemLoad,
+		"dry_run", cfg.GetDryRun(),
 	)
 	return mgr, nil
 }
@@ -207,9 +312,7 @@ func NewManager(
 // SystemMetrics contiene tutte le metriche raccolte in un ciclo.
 // collectSystemMetrics raccoglie tutte le metriche di sistema necessarie.
 // makeDecision prende la decisione se attivare, mantenere o disattivare i limiti.
-// buildActivateReason costruisce la ragione di attivazione basata sulle risorse che superano la soglia.
-// buildDeactivateReason costruisce la ragione di disattivazione.
-// executeDecision esegue l'azione corrispondente alla decisione presa.
+// executeResourceDecisions esegue le transizioni decise per ogni risorsa.
 // e riaggiunge utenti che hanno superato la soglia di idle dopo essere stati rilasciati.
 // activateLimits attiva i limiti di CPU per gli utenti attivi usando pesi proporzionali.
 // deactivateLimits rimuove i limiti di CPU da tutti gli utenti.
@@ -280,6 +383,12 @@ func boolToFloat(b bool) float64 {
 
 // GetStatus restituisce lo stato corrente del manager.
 func (m *Manager) GetStatus() map[string]interface{} {
+	userWeights := m.getUserCPUWeights()
+	ioLimitMode := m.GetConfig().GetIOLimitMode()
+	userIOWeights := m.getUserIOWeights()
+	fairShareEnabled := m.GetConfig().GetFairShareEnabled()
+	resourceStates := m.getResourceStates()
+
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
@@ -290,6 +399,21 @@ func (m *Manager) GetStatus() map[string]interface{} {
 		"active_users":         m.getActiveUsersList(),
 		"shared_cgroup_path":   m.sharedCgroupPath,
 		"shared_cgroup_active": m.sharedCgroupPath != "",
+		"fair_share_enabled":   fairShareEnabled,
+		"user_cpu_weights":     userWeights,
+		"user_groups":          m.getGroupUserCountsLocked(),
+		"cpu_limits_active":    resourceStates[string(ResourceCPU)]["active"],
+		"ram_limits_active":    resourceStates[string(ResourceRAM)]["active"],
+		"io_limits_active":     resourceStates[string(ResourceIO)]["active"],
+		"resource_limits":      resourceStates,
+		"dry_run":              m.IsDryRun(),
+		"cgroup_mode":          m.GetConfig().GetCgroupMode(),
+		"io_limit_mode":        ioLimitMode,
+	}
+
+	if ioLimitMode == config.IOLimitModeWeight {
+		status["user_io_weights"] = userIOWeights
+		status["io_weight_check"] = m.ioWeightCheck.Result()
 	}
 
 	// Aggiungi info sul cgroup condiviso se attivo
@@ -300,12 +424,26 @@ func (m *Manager) GetStatus() map[string]interface{} {
 			status["shared_cgroup_quota"] = strings.TrimSpace(string(data))
 		}
 
-		// Conta i sottocgroup (utenti)
-		if entries, err := os.ReadDir(m.sharedCgroupPath); err == nil {
+		// Conta i sottocgroup (utenti), inclusi quelli dentro i gruppi.
+		// user.slice contiene anche utenti non limitati: contano solo quelli attivi
+		if m.cgroupManager != nil && m.cgroupManager.UsesSystemdSlices() {
+			status["shared_cgroup_user_count"] = len(m.activeUsers)
+		} else if entries, err := os.ReadDir(m.sharedCgroupPath); err == nil {
 			userCount := 0
 			for _, entry := range entries {
-				if entry.IsDir() && strings.HasPrefix(entry.Name(), "user_") {
+				if !entry.IsDir() {
+					continue
+				}
+				if strings.HasPrefix(entry.Name(), "user_") {
 					userCount++
+					continue
+				}
+				if groupEntries, err := os.ReadDir(filepath.Join(m.sharedCgroupPath, entry.Name())); err == nil {
+					for _, groupEntry := range groupEntries {
+						if groupEntry.IsDir() && strings.HasPrefix(groupEntry.Name(), "user_") {
+							userCount++
+						}
+					}
 				}
 			}
 			status["shared_cgroup_user_count"] = userCount
@@ -333,6 +471,23 @@ func (m *Manager) Cleanup() error {
 
 	// Wait for any pending goroutines
 	m.wg.Wait()
+	m.watcherWg.Wait()
+
+	m.savePatternStats()
+
+	// Con STATE_PERSIST_ENABLED la gerarchia resta in piedi: la prossima istanza
+	// la adotta senza liberare i processi limitati
+	if m.GetConfig().GetStatePersistEnabled() && !m.IsDryRun() && (m.limitsActive || m.anyResourceActive()) {
+		m.persistState()
+		if m.prometheusExporter != nil {
+			m.prometheusExporter.Stop()
+		}
+		m.logger.Info("State manager cleanup completed, limits left in place for the next instance",
+			"state_file", m.GetConfig().GetStateFile(),
+			"active_users", len(m.activeUsers),
+		)
+		return nil
+	}
 
 	// Rimuovi tutti i limiti attivi
 	if m.limitsActive {
@@ -367,9 +522,19 @@ func (m *Manager) UpdateConfig(newConfig *config.Config) {
 		return
 	}
 	m.mu.Lock()
+	oldConfig := m.cfg
 	m.cfg = newConfig
 	m.mu.Unlock()
 
+	// Riallinea le quote dei gruppi gia' attivi
+	m.refreshGroupCgroups(newConfig)
+
+	// Riapplica gli override per-utente cambiati agli utenti gia' limitati
+	m.reapplyUserOverrides(oldConfig, newConfig)
+
+	// Cambio di DRY_RUN a caldo
+	m.setDryRun(newConfig.GetDryRun())
+
 	m.logger.Info("State manager configuration updated",
 		"polling_interval", newConfig.PollingInterval,
 		"cpu_threshold", newConfig.CPUThreshold,
@@ -379,8 +544,34 @@ func (m *Manager) UpdateConfig(newConfig *config.Config) {
 }
 
 // RegisterPSIWatcher sets the PSI watcher for per-user cgroup monitoring.
+// Users already limited (adopted by RestoreState) are monitored right away.
 func (m *Manager) RegisterPSIWatcher(w *cgroup.PSIWatcher) {
 	m.psiWatcher = w
+	if w == nil {
+		return
+	}
+
+	m.mu.RLock()
+	userPaths := make(map[int]string, len(m.activeUsers))
+	for uid := range m.activeUsers {
+		parentPath := m.userCgroupParent[uid]
+		if parentPath == "" {
+			parentPath = m.sharedCgroupPath
+		}
+		if parentPath != "" {
+			userPaths[uid] = m.userCgroupPath(parentPath, uid)
+		}
+	}
+	m.mu.RUnlock()
+
+	for uid, userPath := range userPaths {
+		if err := w.AddMonitor(uid, "cpu", filepath.Join(userPath, "cpu.pressure")); err != nil {
+			m.logger.Warn("Failed to monitor user cpu.pressure", "uid", uid, "path", userPath, "error", err)
+		}
+		if err := w.AddMonitor(uid, "io", filepath.Join(userPath, "io.pressure")); err != nil {
+			m.logger.Warn("Failed to monitor user io.pressure", "uid", uid, "path", userPath, "error", err)
+		}
+	}
 }
 
 // OnUserPSIEvent handles a per-user PSI pressure event by boosting CPU weight.
@@ -402,6 +593,12 @@ func (m *Manager) OnUserPSIEvent(event cgroup.PSIEvent) {
 			"uid", event.UID, "type", event.Type)
 		return
 	}
+	// Il boost agisce su cpu.weight: ha senso solo con i limiti CPU attivi
+	if !m.isResourceActive(ResourceCPU) {
+		m.logger.Debug("Ignoring PSI event while CPU limits are inactive",
+			"uid", event.UID, "type", event.Type)
+		return
+	}
 
 	if err := m.cgroupManager.ApplyCPUWeight(event.UID, boostWeight); err != nil {
 		m.logger.Warn("Failed to boost CPU weight on PSI event",
@@ -411,8 +608,19 @@ func (m *Manager) OnUserPSIEvent(event cgroup.PSIEvent) {
 	}
 
 	m.mu.Lock()
+	_, alreadyBoosted := m.psiBoostedAt[event.UID]
 	m.psiBoostedAt[event.UID] = time.Now()
 	m.mu.Unlock()
+	m.persistState()
+
+	if !alreadyBoosted {
+		m.openLimitEvent(database.LimitEventRecord{
+			UID:         event.UID,
+			EventType:   database.LimitEventPSIBoost,
+			StartReason: event.Type + " pressure",
+			Detail:      fmt.Sprintf("cpu.weight %d, %s some avg10 %.1f%%", boostWeight, event.Type, event.SomeAvg10),
+		})
+	}
 
 	m.logger.Info("CPU weight boosted for user due to PSI pressure",
 		"uid", event.UID, "type", event.Type,
@@ -440,13 +648,15 @@ func (m *Manager) revertPSIBoosts() {
 	}
 
 	for _, uid := range expired {
-		if err := m.cgroupManager.ApplyCPUWeight(uid, 100); err != nil {
+		weight := m.cpuWeightFor(uid)
+		if err := m.cgroupManager.ApplyCPUWeight(uid, weight); err != nil {
 			m.logger.Warn("Failed to revert CPU weight after PSI boost",
-				"uid", uid, "error", err)
+				"uid", uid, "weight", weight, "error", err)
 			continue
 		}
 		m.logger.Debug("CPU weight reverted to normal after PSI boost expired",
-			"uid", uid, "boost_duration_s", cfg.GetPSIBoostDuration())
+			"uid", uid, "weight", weight, "boost_duration_s", cfg.GetPSIBoostDuration())
+		m.closeLimitEvent(uid, database.LimitEventPSIBoost, LimitEventReasonBoostExpired)
 	}
 
 	// Clean up expired entries
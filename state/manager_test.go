@@ -19,6 +19,7 @@ package state
 import (
 	"context"
 	"fmt"
+	"os"
 	"testing"
 
 	"github.com/fdefilippo/resman/cgroup"
@@ -56,6 +57,7 @@ type mockCgroupManager struct{}
 
 func (m *mockCgroupManager) CreateUserCgroup(uid int) error                            { return nil }
 func (m *mockCgroupManager) ApplyCPULimit(uid int, quota string) error                 { return nil }
+func (m *mockCgroupManager) ApplyPolicyCPULimit(uid int, quota string) error           { return nil }
 func (m *mockCgroupManager) ApplyCPUWeight(uid int, weight int) error                  { return nil }
 func (m *mockCgroupManager) RemoveCPULimit(uid int) error                              { return nil }
 func (m *mockCgroupManager) ApplyRAMLimit(uid int, limit string) error                 { return nil }
@@ -75,6 +77,25 @@ func (m *mockCgroupManager) GetCgroupRAMUsage(uid int) (uint64, error) {
 func (m *mockCgroupManager) GetMemoryHighEvents(uid int) (uint64, error) {
 	return 0, nil
 }
+func (m *mockCgroupManager) GetMemoryWorkingSet(uid int) (uint64, error) {
+	return 0, nil
+}
+func (m *mockCgroupManager) GetMemoryPSIStats(uid int) (cgroup.PSIStats, error) {
+	return cgroup.PSIStats{}, nil
+}
+func (m *mockCgroupManager) ApplySwapLimits(uid int, swapMax, swapHigh, zswapMax string) error {
+	return nil
+}
+func (m *mockCgroupManager) RemoveSwapLimits(uid int) error { return nil }
+func (m *mockCgroupManager) GetMemoryEvents(uid int) (cgroup.MemoryEvents, error) {
+	return cgroup.MemoryEvents{}, nil
+}
+func (m *mockCgroupManager) GetMemoryStat(uid int) (cgroup.MemoryStat, error) {
+	return cgroup.MemoryStat{}, nil
+}
+func (m *mockCgroupManager) ReadOOMKills() ([]cgroup.OOMKill, error) {
+	return nil, nil
+}
 func (m *mockCgroupManager) ApplyIOLimit(uid int, readBPS, writeBPS string, readIOPS, writeIOPS int, deviceFilter string) error {
 	return nil
 }
@@ -82,6 +103,16 @@ func (m *mockCgroupManager) RemoveIOLimit(uid int) error { return nil }
 func (m *mockCgroupManager) GetIOStats(uid int) (uint64, uint64, uint64, uint64, error) {
 	return 0, 0, 0, 0, nil
 }
+func (m *mockCgroupManager) GetIODeviceStats(uid int) ([]cgroup.IODeviceStats, error) {
+	return nil, nil
+}
+func (m *mockCgroupManager) ApplyIOWeight(uid int, weight int) error { return nil }
+func (m *mockCgroupManager) ApplyIOLatency(uid int, targetUsec int, deviceFilter string) error {
+	return nil
+}
+func (m *mockCgroupManager) ApplySharedIOWeight(sharedPath string, weight int) error { return nil }
+func (m *mockCgroupManager) ApplySharedCPUSet(sharedPath string, cpus string) error  { return nil }
+func (m *mockCgroupManager) LimitedCPUSet() (string, string, error)                  { return "", "", nil }
 func (m *mockCgroupManager) GetUserCgroupMetrics(uid int) (string, string, uint64, uint64, uint64, uint64, uint64, error) {
 	return "", "", 0, 0, 0, 0, 0, nil
 }
@@ -100,9 +131,29 @@ func (m *mockCgroupManager) ReleaseUserFromSharedCgroup(uid int, path string) er
 func (m *mockCgroupManager) CreateSharedCgroup() (string, error)                      { return "", nil }
 func (m *mockCgroupManager) ApplySharedCPULimit(path string, quota string) error      { return nil }
 func (m *mockCgroupManager) CreateUserSubCgroup(uid int, path string) (string, error) { return "", nil }
+func (m *mockCgroupManager) RemoveGroupCgroup(groupPath string) error                 { return nil }
+func (m *mockCgroupManager) RemoveSharedCgroup(sharedPath string) error               { return nil }
 func (m *mockCgroupManager) CleanupAll() error                                        { return nil }
 func (m *mockCgroupManager) GetCgroupInfo(uid int) (map[string]string, error)         { return nil, nil }
 func (m *mockCgroupManager) GetCreatedCgroups() []int                                 { return nil }
+func (m *mockCgroupManager) CreateGroupCgroup(sharedPath string, group config.UserGroup) (string, error) {
+	return sharedPath + "/" + group.Name, nil
+}
+func (m *mockCgroupManager) ApplyUserSubCgroupCPULimit(uid int, quota string) error {
+	return nil
+}
+func (m *mockCgroupManager) AdoptSharedCgroup() (string, map[int]string, error) {
+	return "", nil, os.ErrNotExist
+}
+func (m *mockCgroupManager) UsesSystemdSlices() bool {
+	return false
+}
+func (m *mockCgroupManager) MoveProcessToSharedCgroup(pid int, sharedPath string, uid int) error {
+	return nil
+}
+func (m *mockCgroupManager) FindEscapedProcesses(targets map[int]string) map[int][]int {
+	return nil
+}
 
 type mockPrometheusExporter struct{}
 
@@ -112,12 +163,35 @@ func (m *mockPrometheusExporter) UpdateUserMetrics(uid int, user string, cpu flo
 func (m *mockPrometheusExporter) UpdateSystemMetrics(cores int, actionCores int, load float64) {}
 func (m *mockPrometheusExporter) UpdateUserWorkloadPattern(uid int, username string, pattern string, confidence float64) {
 }
+func (m *mockPrometheusExporter) UpdateUserCPUWeights(weights map[int]int)   {}
 func (m *mockPrometheusExporter) RecordControlCycleTrigger(trigger string)   {}
 func (m *mockPrometheusExporter) Start(ctx context.Context) error            { return nil }
 func (m *mockPrometheusExporter) Stop() error                                { return nil }
 func (m *mockPrometheusExporter) CleanupUserMetrics(activeUids map[int]bool) {}
 func (m *mockPrometheusExporter) IncrementLimitsActivated()                  {}
 func (m *mockPrometheusExporter) IncrementLimitsDeactivated()                {}
+func (m *mockPrometheusExporter) RecordResourceLimitState(resource string, active bool) {
+}
+func (m *mockPrometheusExporter) SetDryRunEnabled(enabled bool) {
+}
+func (m *mockPrometheusExporter) RecordDryRunWrite(operation string) {
+}
+func (m *mockPrometheusExporter) UpdateUserCPUSource(uid int, username string, source string) {
+}
+func (m *mockPrometheusExporter) RecordProcessEscapes(uid int, username string, moved, failed int) {
+}
+func (m *mockPrometheusExporter) RecordIOBoostStep(uid int, username string, action, reason string, multiplier float64) {
+}
+func (m *mockPrometheusExporter) UpdateUserIODeviceStats(uid int, username string, device string, readBytes, writeBytes, readOps, writeOps uint64) {
+}
+func (m *mockPrometheusExporter) UpdateUserIOWeights(weights map[int]int) {}
+func (m *mockPrometheusExporter) RecordIOWeightCheck(result string)       {}
+func (m *mockPrometheusExporter) RecordMemoryHighStep(uid int, username string, action, reason string, high uint64) {
+}
+func (m *mockPrometheusExporter) UpdateUserMemoryEvents(uid int, username string, events map[string]uint64) {
+}
+func (m *mockPrometheusExporter) UpdateUserMemoryStat(uid int, username string, stat map[string]uint64) {
+}
 
 func TestNewManager(t *testing.T) {
 	cfg := config.DefaultConfig()
@@ -184,6 +258,9 @@ func TestMakeDecisionDeactivate(t *testing.T) {
 		limitsActive:       true,
 		thresholdTracker:   &ThresholdTracker{},
 		ioThresholdTracker: &ThresholdTracker{},
+		limiters: map[Resource]*resourceLimiter{
+			ResourceCPU: {resource: ResourceCPU, active: true},
+		},
 	}
 
 	metrics := &SystemMetrics{
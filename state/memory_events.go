@@ -0,0 +1,189 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+// state/memory_events.go
+package state
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fdefilippo/resman/cgroup"
+	"github.com/fdefilippo/resman/config"
+	"github.com/fdefilippo/resman/database"
+)
+
+// MemoryEventsStore conserva i campioni di memory.events e memory.stat
+// (implementato da metrics.DBWriter, che li scrive nel batch del ciclo).
+type MemoryEventsStore interface {
+	WriteUserMemoryEvents(record *database.UserMemoryEventsRecord) error
+}
+
+// SetMemoryEventsStore collega il database delle metriche ai campioni di
+// memory.events e memory.stat.
+func (m *Manager) SetMemoryEventsStore(store MemoryEventsStore) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.memoryEventsStore = store
+}
+
+// collectMemoryEvents legge memory.events e memory.stat dei cgroup utente e
+// notifica con il limit hook ogni nuovo oom_kill. Il primo campione di un
+// utente fa solo da riferimento.
+func (m *Manager) collectMemoryEvents(cfg *config.Config, metrics *SystemMetrics) {
+	if m.cgroupManager == nil {
+		return
+	}
+
+	// Il log del kernel va svuotato a ogni ciclo, anche senza nuovi kill
+	kills, err := m.cgroupManager.ReadOOMKills()
+	if err != nil {
+		m.logger.Debug("Kernel log unavailable, OOM victims will not be reported", "error", err)
+	}
+
+	newKills := make(map[int]uint64)
+	for uid := range metrics.UserMetrics {
+		events, err := m.cgroupManager.GetMemoryEvents(uid)
+		if err != nil {
+			// Utente senza cgroup
+			continue
+		}
+		metrics.UserMemoryEvents[uid] = events
+		if stat, err := m.cgroupManager.GetMemoryStat(uid); err == nil {
+			metrics.UserMemoryStat[uid] = stat
+		}
+
+		prev, ok := m.prevOOMKills[uid]
+		if ok && events.OOMKill > prev {
+			newKills[uid] = events.OOMKill - prev
+		}
+		m.prevOOMKills[uid] = events.OOMKill
+	}
+	for uid := range m.prevOOMKills {
+		if _, ok := metrics.UserMemoryEvents[uid]; !ok {
+			delete(m.prevOOMKills, uid)
+		}
+	}
+
+	for uid, count := range newKills {
+		username := metrics.UserMetrics[uid].Username
+		if username == "" {
+			username = m.getUsername(uid)
+		}
+		victims := oomVictimsOf(uid, kills)
+		m.logger.Warn("User process killed by the OOM killer",
+			"uid", uid,
+			"username", username,
+			"oom_kills", count,
+			"victims", len(victims),
+		)
+		if len(victims) == 0 {
+			m.notifyOOMKill(cfg, uid, username, count, cgroup.OOMKill{})
+			continue
+		}
+		for _, victim := range victims {
+			m.notifyOOMKill(cfg, uid, username, count, victim)
+		}
+	}
+}
+
+// oomVictimsOf restituisce i processi dell'utente tra quelli uccisi, cercati
+// per uid del processo o per cgroup dell'utente.
+func oomVictimsOf(uid int, kills []cgroup.OOMKill) []cgroup.OOMKill {
+	var victims []cgroup.OOMKill
+	userCgroup := fmt.Sprintf("/user_%d", uid)
+	for _, kill := range kills {
+		if kill.UID == uid ||
+			strings.HasSuffix(kill.Cgroup, userCgroup) ||
+			strings.HasSuffix(kill.Cgroup, "/"+cgroup.UserSliceName(uid)) {
+			victims = append(victims, kill)
+		}
+	}
+	return victims
+}
+
+// notifyOOMKill esegue il limit hook per un processo dell'utente ucciso
+// dall'OOM killer. victim e' vuoto se il log del kernel non lo riporta.
+func (m *Manager) notifyOOMKill(cfg *config.Config, uid int, username string, count uint64, victim cgroup.OOMKill) {
+	if cfg == nil || !cfg.LimitHookEnabled {
+		return
+	}
+	if cfg.GetDryRun() {
+		m.logger.Debug("Dry run: OOM kill hook not run", "uid", uid, "username", username)
+		return
+	}
+
+	m.mu.RLock()
+	sharedCgroup := m.sharedCgroupPath
+	m.mu.RUnlock()
+
+	event := limitHookEvent{
+		Event:           LimitHookEventOOMKill,
+		UID:             uid,
+		Username:        username,
+		SharedCgroup:    sharedCgroup,
+		Timestamp:       time.Now().UTC(),
+		ServerRole:      cfg.ServerRole,
+		LimitHookSource: "resman",
+		Reason:          "memory limit reached, process killed by the OOM killer",
+		OOMKills:        count,
+		VictimPID:       victim.PID,
+		VictimCommand:   victim.Command,
+	}
+
+	go m.runLimitHook(cfg, event)
+}
+
+// writeMemoryEvents accoda i campioni di memory.events e memory.stat
+// raccolti nel ciclo: vanno nella stessa transazione delle metriche.
+func (m *Manager) writeMemoryEvents(metrics *SystemMetrics) {
+	m.mu.RLock()
+	store := m.memoryEventsStore
+	m.mu.RUnlock()
+	if store == nil {
+		return
+	}
+
+	for uid, events := range metrics.UserMemoryEvents {
+		username := ""
+		if um := metrics.UserMetrics[uid]; um != nil {
+			username = um.Username
+		}
+		if username == "" {
+			username = m.getUsername(uid)
+		}
+		stat := metrics.UserMemoryStat[uid]
+		record := &database.UserMemoryEventsRecord{
+			Timestamp:    metrics.Timestamp,
+			UID:          uid,
+			Username:     username,
+			Low:          int64(events.Low),
+			High:         int64(events.High),
+			Max:          int64(events.Max),
+			OOM:          int64(events.OOM),
+			OOMKill:      int64(events.OOMKill),
+			OOMGroupKill: int64(events.OOMGroupKill),
+			AnonBytes:    int64(stat.Anon),
+			FileBytes:    int64(stat.File),
+			ShmemBytes:   int64(stat.Shmem),
+			SwapBytes:    int64(stat.Swap),
+		}
+		if err := store.WriteUserMemoryEvents(record); err != nil {
+			m.logger.Debug("Failed to write memory events to database", "uid", uid, "error", err)
+		}
+	}
+}
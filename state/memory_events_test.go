@@ -0,0 +1,134 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+package state
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fdefilippo/resman/cgroup"
+	"github.com/fdefilippo/resman/config"
+	"github.com/fdefilippo/resman/database"
+	"github.com/fdefilippo/resman/metrics"
+)
+
+// oomCgroupManager simula memory.events dei cgroup utente e il log del kernel.
+type oomCgroupManager struct {
+	mockCgroupManager
+	events map[int]cgroup.MemoryEvents
+	kills  []cgroup.OOMKill
+}
+
+func (o *oomCgroupManager) GetMemoryEvents(uid int) (cgroup.MemoryEvents, error) {
+	events, ok := o.events[uid]
+	if !ok {
+		return cgroup.MemoryEvents{}, fmt.Errorf("cgroup for UID %d not found", uid)
+	}
+	return events, nil
+}
+
+func (o *oomCgroupManager) GetMemoryStat(uid int) (cgroup.MemoryStat, error) {
+	return cgroup.MemoryStat{Anon: 256 << 20}, nil
+}
+
+func (o *oomCgroupManager) ReadOOMKills() ([]cgroup.OOMKill, error) {
+	kills := o.kills
+	o.kills = nil
+	return kills, nil
+}
+
+// memoryEventsRecorder raccoglie i campioni che andrebbero nel database.
+type memoryEventsRecorder struct {
+	records []database.UserMemoryEventsRecord
+}
+
+func (r *memoryEventsRecorder) WriteUserMemoryEvents(record *database.UserMemoryEventsRecord) error {
+	r.records = append(r.records, *record)
+	return nil
+}
+
+func TestCollectMemoryEventsOOMKillHook(t *testing.T) {
+	tmpDir := t.TempDir()
+	outputPath := filepath.Join(tmpDir, "hook.out")
+	scriptPath := filepath.Join(tmpDir, "hook.sh")
+	script := "#!/bin/sh\nprintf '%s:%s:%s:%s' \"$RESMAN_LIMIT_EVENT\" \"$RESMAN_LIMIT_UID\" \"$RESMAN_LIMIT_VICTIM_PID\" \"$RESMAN_LIMIT_VICTIM_COMMAND\" > \"" + outputPath + "\"\n"
+	if err := os.WriteFile(scriptPath, []byte(script), 0755); err != nil {
+		t.Fatalf("write hook script: %v", err)
+	}
+
+	cfg := config.DefaultConfig()
+	cfg.LimitHookEnabled = true
+	cfg.LimitHookScript = scriptPath
+	cgroups := &oomCgroupManager{events: map[int]cgroup.MemoryEvents{1000: {High: 3, OOMKill: 1}}}
+	manager, err := NewManager(cfg, &mockMetricsCollector{}, cgroups, &mockPrometheusExporter{})
+	if err != nil {
+		t.Fatalf("NewManager() error: %v", err)
+	}
+	store := &memoryEventsRecorder{}
+	manager.SetMemoryEventsStore(store)
+
+	sample := func() *SystemMetrics {
+		m := &SystemMetrics{
+			Timestamp: time.Now(),
+			UserMetrics: map[int]*metrics.UserMetrics{
+				1000: {UID: 1000, Username: "app"},
+				1001: {UID: 1001, Username: "nocgroup"},
+			},
+			UserMemoryEvents: make(map[int]cgroup.MemoryEvents),
+			UserMemoryStat:   make(map[int]cgroup.MemoryStat),
+		}
+		manager.collectMemoryEvents(cfg, m)
+		return m
+	}
+
+	// Il primo campione fa da riferimento: il kill gia' contato non e' nuovo
+	first := sample()
+	if _, ok := first.UserMemoryEvents[1001]; ok {
+		t.Error("users without a cgroup should have no memory events")
+	}
+	manager.writeMemoryEvents(first)
+	if len(store.records) != 1 || store.records[0].High != 3 || store.records[0].AnonBytes != 256<<20 {
+		t.Fatalf("stored memory events = %+v", store.records)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err := os.Stat(outputPath); err == nil {
+		t.Fatal("the first sample should not run the OOM kill hook")
+	}
+
+	cgroups.events[1000] = cgroup.MemoryEvents{High: 3, OOMKill: 2}
+	cgroups.kills = []cgroup.OOMKill{
+		{PID: 999, UID: 1001, Command: "other"},
+		{PID: 4321, UID: 1000, Command: "stress", Cgroup: "/resman/limited/user_1000"},
+	}
+	sample()
+
+	expected := "oom_kill:1000:4321:stress"
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		output, err := os.ReadFile(outputPath)
+		if err == nil && string(output) == expected {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("hook output = %q (%v), expected %q", string(output), err, expected)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
@@ -0,0 +1,292 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+// state/memory_high.go
+package state
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/fdefilippo/resman/cgroup"
+	"github.com/fdefilippo/resman/config"
+	"github.com/fdefilippo/resman/logging"
+)
+
+// Azioni del controller memory.high (label "action" in Prometheus).
+const (
+	MemoryHighActionSet   = "set"   // primo valore, dalla quota del budget
+	MemoryHighActionLower = "lower" // l'utente cresce mentre altri sono sotto pressione
+	MemoryHighActionRaise = "raise" // la pressione e' rientrata, si torna verso la quota
+)
+
+// Motivi dei passi del controller (label "reason" in Prometheus).
+const (
+	MemoryHighReasonBudget          = "budget"           // quota iniziale del budget RAM_THRESHOLD
+	MemoryHighReasonOthersPressured = "others_pressured" // altri utenti in stallo su memory.pressure
+	MemoryHighReasonPressureCleared = "pressure_cleared" // nessun altro utente in stallo
+	MemoryHighReasonThrottled       = "throttled"        // eventi high in crescita senza pressione altrui
+)
+
+// MemoryHighStep e' una modifica di memory.high decisa dal controller.
+type MemoryHighStep struct {
+	UID          int
+	Action       string
+	Reason       string
+	High         uint64
+	PreviousHigh uint64
+	WorkingSet   uint64
+	PSIAvg10     float64
+}
+
+// MemoryHighDeps contiene le operazioni cgroup usate dal controller.
+type MemoryHighDeps interface {
+	GetMemoryWorkingSet(uid int) (uint64, error)
+	GetMemoryPSIStats(uid int) (cgroup.PSIStats, error)
+	GetMemoryHighEvents(uid int) (uint64, error)
+	ApplyRAMHigh(uid int, limit string) error
+}
+
+// MemoryHighUser e' un utente gestito dal controller con il suo memory.max
+// (0 = nessun memory.max), tetto di memory.high.
+type MemoryHighUser struct {
+	UID      int
+	MaxBytes uint64
+}
+
+// memoryHighParams sono i parametri del controller letti dalla configurazione.
+type memoryHighParams struct {
+	budget       uint64 // RAM a disposizione degli utenti limitati (RAM_THRESHOLD)
+	minHigh      uint64
+	step         float64
+	psiThreshold float64
+}
+
+func newMemoryHighParams(cfg *config.Config, totalMemoryMB float64) memoryHighParams {
+	return memoryHighParams{
+		budget:       uint64(totalMemoryMB * 1024 * 1024 * float64(cfg.RAMThreshold) / 100),
+		minHigh:      cfg.GetRAMHighMinBytes(),
+		step:         cfg.GetRAMHighStep(),
+		psiThreshold: cfg.GetRAMPSIThreshold(),
+	}
+}
+
+// memoryHighState e' l'ultimo stato osservato di un utente.
+type memoryHighState struct {
+	high       uint64
+	workingSet uint64
+	highEvents uint64
+}
+
+// MemoryHighController regola memory.high degli utenti limitati a ogni ciclo:
+// divide il budget per working set, abbassa memory.high a chi cresce mentre
+// altri sono in stallo sulla memoria e lo rialza quando la pressione rientra.
+type MemoryHighController struct {
+	mu     sync.Mutex
+	logger *logging.Logger
+	states map[int]*memoryHighState // uid -> stato
+}
+
+// NewMemoryHighController crea un controller senza stato.
+func NewMemoryHighController(logger *logging.Logger) *MemoryHighController {
+	return &MemoryHighController{
+		logger: logger,
+		states: make(map[int]*memoryHighState),
+	}
+}
+
+// High restituisce l'ultimo memory.high scritto per l'utente.
+func (c *MemoryHighController) High(uid int) (uint64, bool) {
+	if c == nil {
+		return 0, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st, ok := c.states[uid]
+	if !ok {
+		return 0, false
+	}
+	return st.high, true
+}
+
+// Reset dimentica lo stato di tutti gli utenti (limiti RAM rilasciati).
+func (c *MemoryHighController) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.states = make(map[int]*memoryHighState)
+}
+
+// memoryHighSample e' la lettura di un ciclo per un utente.
+type memoryHighSample struct {
+	user       MemoryHighUser
+	workingSet uint64
+	psi        float64
+	highEvents uint64
+}
+
+// Adjust esegue un passo del controller per gli utenti indicati e
+// restituisce le modifiche scritte. Gli utenti non piu' presenti vengono dimenticati.
+func (c *MemoryHighController) Adjust(deps MemoryHighDeps, params memoryHighParams, users []MemoryHighUser) []MemoryHighStep {
+	samples := make([]memoryHighSample, 0, len(users))
+	var totalWS uint64
+	stalled := 0
+	for _, user := range users {
+		ws, err := deps.GetMemoryWorkingSet(user.UID)
+		if err != nil {
+			continue
+		}
+		sample := memoryHighSample{user: user, workingSet: ws}
+		if psi, err := deps.GetMemoryPSIStats(user.UID); err == nil {
+			sample.psi = psi.SomeAvg10
+		}
+		if events, err := deps.GetMemoryHighEvents(user.UID); err == nil {
+			sample.highEvents = events
+		}
+		if sample.psi >= params.psiThreshold {
+			stalled++
+		}
+		totalWS += ws
+		samples = append(samples, sample)
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i].user.UID < samples[j].user.UID })
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	seen := make(map[int]bool, len(samples))
+	var steps []MemoryHighStep
+	for _, sample := range samples {
+		uid := sample.user.UID
+		seen[uid] = true
+		target := params.share(sample, totalWS, len(samples))
+
+		selfStalled := sample.psi >= params.psiThreshold
+		othersStalled := stalled > 0 && !(stalled == 1 && selfStalled)
+
+		st, known := c.states[uid]
+		step := MemoryHighStep{UID: uid, WorkingSet: sample.workingSet, PSIAvg10: sample.psi}
+		switch {
+		case !known:
+			st = &memoryHighState{}
+			c.states[uid] = st
+			step.Action, step.Reason, step.High = MemoryHighActionSet, MemoryHighReasonBudget, target
+		case othersStalled && sample.workingSet > st.workingSet:
+			// Reclaim: sotto il working set corrente il kernel rallenta e recupera
+			base := min(st.high, sample.workingSet)
+			step.Action, step.Reason = MemoryHighActionLower, MemoryHighReasonOthersPressured
+			step.High = params.clamp(uint64(float64(base)*(1-params.step)), sample.user.MaxBytes)
+		case !othersStalled && st.high < target:
+			step.Action, step.Reason = MemoryHighActionRaise, MemoryHighReasonPressureCleared
+			if sample.highEvents > st.highEvents {
+				step.Reason = MemoryHighReasonThrottled
+			}
+			step.High = min(uint64(float64(st.high)*(1+params.step)), target)
+		}
+		step.PreviousHigh = st.high
+		st.workingSet = sample.workingSet
+		st.highEvents = sample.highEvents
+
+		if step.Action == "" || step.High == st.high {
+			continue
+		}
+		if err := deps.ApplyRAMHigh(uid, strconv.FormatUint(step.High, 10)); err != nil {
+			c.logger.Warn("Failed to adjust memory.high",
+				"uid", uid, "high", step.High, "error", err)
+			if !known {
+				delete(c.states, uid)
+			}
+			continue
+		}
+		st.high = step.High
+		steps = append(steps, step)
+	}
+
+	for uid := range c.states {
+		if !seen[uid] {
+			delete(c.states, uid)
+		}
+	}
+	return steps
+}
+
+// share restituisce la quota del budget spettante all'utente, proporzionale
+// al suo working set, entro [RAM_HIGH_MIN, memory.max].
+func (p memoryHighParams) share(sample memoryHighSample, totalWS uint64, users int) uint64 {
+	target := p.budget / uint64(max(users, 1))
+	if totalWS > 0 {
+		target = uint64(float64(p.budget) * float64(sample.workingSet) / float64(totalWS))
+	}
+	return p.clamp(target, sample.user.MaxBytes)
+}
+
+// clamp limita memory.high a [RAM_HIGH_MIN, memory.max].
+func (p memoryHighParams) clamp(high, maxBytes uint64) uint64 {
+	high = max(high, p.minHigh)
+	if maxBytes > 0 {
+		high = min(high, maxBytes)
+	}
+	return high
+}
+
+// memoryHighUsers restituisce gli utenti limitati con limiti RAM il cui
+// memory.high non e' fissato da un override.
+func (m *Manager) memoryHighUsers(cfg *config.Config) []MemoryHighUser {
+	var users []MemoryHighUser
+	for _, uid := range m.getActiveUsersList() {
+		override, _ := m.userOverrideFor(cfg, uid)
+		if override.RAMHigh != "" {
+			continue
+		}
+		maxStr, _, ok := m.userRAMLimits(cfg, uid, override)
+		if !ok {
+			continue
+		}
+		user := MemoryHighUser{UID: uid}
+		if maxBytes, err := config.ParseRAMQuota(maxStr); err == nil && maxStr != "max" {
+			user.MaxBytes = maxBytes
+		}
+		users = append(users, user)
+	}
+	return users
+}
+
+// adjustMemoryHigh esegue un passo del controller memory.high mentre i
+// limiti RAM sono attivi con RAM_DYNAMIC_HIGH.
+func (m *Manager) adjustMemoryHigh(cfg *config.Config, metrics *SystemMetrics) []MemoryHighStep {
+	if m.memoryHigh == nil || metrics == nil {
+		return nil
+	}
+	if !cfg.RAMEnabled || !cfg.GetRAMDynamicHigh() || !m.isResourceActive(ResourceRAM) {
+		m.memoryHigh.Reset()
+		return nil
+	}
+
+	params := newMemoryHighParams(cfg, metrics.TotalMemoryMB)
+	steps := m.memoryHigh.Adjust(m.cgroupManager, params, m.memoryHighUsers(cfg))
+	for _, step := range steps {
+		m.logger.Debug("memory.high adjusted",
+			"uid", step.UID,
+			"action", step.Action,
+			"reason", step.Reason,
+			"high", step.High,
+			"previous_high", step.PreviousHigh,
+			"working_set", step.WorkingSet,
+			"psi_avg10", step.PSIAvg10,
+		)
+	}
+	return steps
+}
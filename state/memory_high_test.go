@@ -0,0 +1,127 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+package state
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/fdefilippo/resman/cgroup"
+	"github.com/fdefilippo/resman/logging"
+)
+
+const mib = 1024 * 1024
+
+// memoryHighDeps simula working set, memory.pressure e memory.events degli utenti.
+type memoryHighDeps struct {
+	workingSet map[int]uint64
+	psi        map[int]float64
+	highEvents map[int]uint64
+	high       map[int]uint64
+}
+
+func (d *memoryHighDeps) GetMemoryWorkingSet(uid int) (uint64, error) {
+	ws, ok := d.workingSet[uid]
+	if !ok {
+		return 0, fmt.Errorf("cgroup for UID %d not found", uid)
+	}
+	return ws, nil
+}
+
+func (d *memoryHighDeps) GetMemoryPSIStats(uid int) (cgroup.PSIStats, error) {
+	return cgroup.PSIStats{SomeAvg10: d.psi[uid]}, nil
+}
+
+func (d *memoryHighDeps) GetMemoryHighEvents(uid int) (uint64, error) {
+	return d.highEvents[uid], nil
+}
+
+func (d *memoryHighDeps) ApplyRAMHigh(uid int, limit string) error {
+	high, err := strconv.ParseUint(limit, 10, 64)
+	if err != nil {
+		return err
+	}
+	d.high[uid] = high
+	return nil
+}
+
+func TestMemoryHighController(t *testing.T) {
+	deps := &memoryHighDeps{
+		workingSet: map[int]uint64{1000: 300 * mib, 1001: 100 * mib},
+		psi:        map[int]float64{},
+		highEvents: map[int]uint64{},
+		high:       make(map[int]uint64),
+	}
+	params := memoryHighParams{budget: 500 * mib, minHigh: 128 * mib, step: 0.1, psiThreshold: 10}
+	users := []MemoryHighUser{{UID: 1000}, {UID: 1001, MaxBytes: 1024 * mib}}
+	controller := NewMemoryHighController(logging.GetLogger())
+
+	// Primo ciclo: il budget e' diviso per working set, con il minimo RAM_HIGH_MIN
+	steps := controller.Adjust(deps, params, users)
+	if len(steps) != 2 || steps[0].Action != MemoryHighActionSet {
+		t.Fatalf("expected two initial set steps, got %+v", steps)
+	}
+	if deps.high[1000] != 375*mib {
+		t.Errorf("memory.high for 1000 = %d MiB, expected 375 MiB (3/4 of the budget)", deps.high[1000]/mib)
+	}
+	if deps.high[1001] != 128*mib {
+		t.Errorf("memory.high for 1001 = %d MiB, expected RAM_HIGH_MIN 128 MiB", deps.high[1001]/mib)
+	}
+
+	// 1000 cresce mentre 1001 e' in stallo: memory.high scende sotto il working set
+	deps.workingSet[1000] = 350 * mib
+	deps.psi[1001] = 40
+	steps = controller.Adjust(deps, params, users)
+	if len(steps) != 1 || steps[0].UID != 1000 || steps[0].Action != MemoryHighActionLower ||
+		steps[0].Reason != MemoryHighReasonOthersPressured {
+		t.Fatalf("expected memory.high of 1000 lowered for pressure on others, got %+v", steps)
+	}
+	if want := uint64(float64(350*mib) * 0.9); deps.high[1000] != want {
+		t.Errorf("lowered memory.high = %d, expected %d", deps.high[1000], want)
+	}
+
+	// Senza crescita non si abbassa ulteriormente
+	if steps = controller.Adjust(deps, params, users); len(steps) != 0 {
+		t.Errorf("a user that stopped growing should be held, got %+v", steps)
+	}
+
+	// Pressione rientrata: memory.high risale a passi verso la quota
+	deps.psi[1001] = 0
+	deps.highEvents[1000] = 5
+	lowered := deps.high[1000]
+	steps = controller.Adjust(deps, params, users)
+	if len(steps) != 1 || steps[0].Action != MemoryHighActionRaise || steps[0].Reason != MemoryHighReasonThrottled {
+		t.Fatalf("expected memory.high of 1000 raised, got %+v", steps)
+	}
+	if want := uint64(float64(lowered) * 1.1); deps.high[1000] != want {
+		t.Errorf("raised memory.high = %d, expected %d", deps.high[1000], want)
+	}
+	target := params.share(memoryHighSample{user: users[0], workingSet: 350 * mib}, 450*mib, 2)
+	for range 10 {
+		controller.Adjust(deps, params, users)
+	}
+	if deps.high[1000] != target {
+		t.Errorf("memory.high should converge to the budget share %d, got %d", target, deps.high[1000])
+	}
+
+	// Un utente rilasciato viene dimenticato
+	controller.Adjust(deps, params, users[:1])
+	if _, ok := controller.High(1001); ok {
+		t.Error("state of a released user should be dropped")
+	}
+}
@@ -0,0 +1,344 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+// state/overrides.go
+package state
+
+import (
+	"strconv"
+
+	"github.com/fdefilippo/resman/config"
+)
+
+// userOverrideFor restituisce i limiti personali dell'utente (USER_OVERRIDES_FILE).
+func (m *Manager) userOverrideFor(cfg *config.Config, uid int) (config.UserOverride, bool) {
+	if cfg == nil {
+		return config.UserOverride{}, false
+	}
+	return cfg.GetUserOverride(uid, m.getUsername(uid))
+}
+
+// hasCPUWeightOverride indica se il cpu.weight dell'utente e' fissato da un override
+// (in quel caso il fair-share non lo modifica).
+func (m *Manager) hasCPUWeightOverride(cfg *config.Config, uid int) bool {
+	override, ok := m.userOverrideFor(cfg, uid)
+	return ok && override.CPUWeight > 0
+}
+
+// userRAMLimits calcola memory.max e memory.high per l'utente: i valori
+// dell'override hanno la precedenza su RAM_QUOTA_PER_USER e RAM_HIGH_RATIO.
+// ok=false se all'utente non si applica alcun limite RAM.
+func (m *Manager) userRAMLimits(cfg *config.Config, uid int, override config.UserOverride) (maxStr, highStr string, ok bool) {
+	maxStr = override.RAMMax
+	if maxStr == "" && m.shouldApplyRAMLimits(uid) {
+		if quotaBytes, err := config.ParseRAMQuota(cfg.RAMQuotaPerUser); err == nil && quotaBytes > 0 {
+			maxStr = cfg.RAMQuotaPerUser
+		}
+	}
+
+	// memory.high come percentuale di memory.max, se non indicato esplicitamente
+	highStr = override.RAMHigh
+	if highStr == "" && maxStr != "" && maxStr != "max" {
+		if quotaBytes, err := config.ParseRAMQuota(maxStr); err == nil && quotaBytes > 0 {
+			highStr = strconv.FormatUint(uint64(float64(quotaBytes)*cfg.GetRAMHighRatio()), 10)
+		}
+	}
+	// Con RAM_DYNAMIC_HIGH vale l'ultimo valore deciso dal controller
+	if override.RAMHigh == "" && maxStr != "" && cfg.GetRAMDynamicHigh() {
+		if high, ok := m.memoryHigh.High(uid); ok {
+			highStr = strconv.FormatUint(high, 10)
+		}
+	}
+
+	if maxStr == "" && highStr == "" {
+		return "", "", false
+	}
+	if maxStr == "" {
+		maxStr = "max"
+	}
+	if highStr == "" {
+		highStr = "max"
+	}
+	return maxStr, highStr, true
+}
+
+// userSwapLimits calcola memory.swap.max, memory.swap.high e memory.zswap.max
+// per l'utente: i valori dell'override hanno la precedenza su RAM_SWAP_*, le
+// percentuali sono calcolate su maxStr (memory.max). Con DISABLE_SWAP
+// memory.swap.max e' gia' scritto a 0 insieme ai limiti RAM.
+// ok=false se non c'e' nulla da scrivere.
+func userSwapLimits(cfg *config.Config, override config.UserOverride, maxStr string) (swapMax, swapHigh, zswapMax string, ok bool) {
+	swapMax, swapHigh, zswapMax = cfg.GetRAMSwapLimits()
+	if cfg.DisableSwap {
+		swapMax = ""
+	}
+	if override.SwapMax != "" {
+		swapMax = override.SwapMax
+	}
+	if override.SwapHigh != "" {
+		swapHigh = override.SwapHigh
+	}
+	if override.ZswapMax != "" {
+		zswapMax = override.ZswapMax
+	}
+
+	swapMax, _ = config.ResolveSwapLimit(swapMax, maxStr)
+	swapHigh, _ = config.ResolveSwapLimit(swapHigh, maxStr)
+	zswapMax, _ = config.ResolveSwapLimit(zswapMax, maxStr)
+	return swapMax, swapHigh, zswapMax, swapMax != "" || swapHigh != "" || zswapMax != ""
+}
+
+// hasSwapLimits indica se all'utente vengono scritti limiti di swap, da
+// ripristinare quando i limiti RAM vengono rimossi.
+func hasSwapLimits(cfg *config.Config, override config.UserOverride) bool {
+	swapMax, swapHigh, zswapMax := cfg.GetRAMSwapLimits()
+	return cfg.DisableSwap || swapMax != "" || swapHigh != "" || zswapMax != "" ||
+		override.SwapMax != "" || override.SwapHigh != "" || override.ZswapMax != ""
+}
+
+// userIOLimits calcola i limiti io.max per l'utente: i campi dell'override
+// sostituiscono quelli globali (IO_READ_BPS, IO_WRITE_BPS, ...).
+// ok=false se all'utente non si applica alcun limite IO.
+func (m *Manager) userIOLimits(cfg *config.Config, uid int, override config.UserOverride) (readBPS, writeBPS string, readIOPS, writeIOPS int, ok bool) {
+	if m.shouldApplyIOLimits(uid) {
+		readBPS = cfg.GetIOReadBPS()
+		writeBPS = cfg.GetIOWriteBPS()
+		readIOPS = cfg.GetIOReadIOPS()
+		writeIOPS = cfg.GetIOWriteIOPS()
+		ok = true
+	}
+	if override.IOReadBPS != "" {
+		readBPS = override.IOReadBPS
+	}
+	if override.IOWriteBPS != "" {
+		writeBPS = override.IOWriteBPS
+	}
+	if override.IOReadIOPS > 0 {
+		readIOPS = override.IOReadIOPS
+	}
+	if override.IOWriteIOPS > 0 {
+		writeIOPS = override.IOWriteIOPS
+	}
+	return readBPS, writeBPS, readIOPS, writeIOPS, ok || override.HasIOLimits()
+}
+
+// ioBoostLimits restituisce i limiti io.max normali dell'utente, a cui il
+// boost della IO remediation applica il moltiplicatore.
+func (m *Manager) ioBoostLimits(cfg *config.Config, uid int) (ioLimitValues, bool) {
+	override, _ := m.userOverrideFor(cfg, uid)
+	readBPS, writeBPS, readIOPS, writeIOPS, ok := m.userIOLimits(cfg, uid, override)
+	return ioLimitValues{
+		readBPS:      readBPS,
+		writeBPS:     writeBPS,
+		readIOPS:     readIOPS,
+		writeIOPS:    writeIOPS,
+		deviceFilter: cfg.GetIODeviceFilter(),
+	}, ok
+}
+
+// applyUserLimits applica a un utente appena limitato i file di controllo delle
+// sole risorse attive: cpu.weight e cpu.max personale, limiti RAM e limiti IO
+// (globali o da override).
+func (m *Manager) applyUserLimits(cfg *config.Config, uid int) {
+	override, _ := m.userOverrideFor(cfg, uid)
+
+	if m.isResourceActive(ResourceCPU) {
+		m.applyUserCPULimits(cfg, uid, override)
+	}
+
+	if m.isResourceActive(ResourceRAM) {
+		if maxStr, highStr, ok := m.userRAMLimits(cfg, uid, override); ok {
+			m.applyUserRAMLimits(cfg, uid, maxStr, highStr)
+		}
+	}
+
+	if m.isResourceActive(ResourceIO) {
+		if readBPS, writeBPS, readIOPS, writeIOPS, ok := m.userIOLimits(cfg, uid, override); ok {
+			m.applyUserIOLimits(cfg, uid, readBPS, writeBPS, readIOPS, writeIOPS)
+		}
+	}
+}
+
+// applyUserCPULimits imposta il peso dell'utente (100 per tutti, fair-share se
+// abilitato, o il valore fissato in USER_OVERRIDES_FILE) e il cpu.max personale.
+// I pesi sono relativi: se un utente non usa CPU, gli altri possono usare piu'
+// della loro parte.
+func (m *Manager) applyUserCPULimits(cfg *config.Config, uid int, override config.UserOverride) {
+	weight := m.cpuWeightFor(uid)
+	if err := m.cgroupManager.ApplyCPUWeight(uid, weight); err != nil {
+		m.logger.Warn("Failed to set CPU weight for user, using default",
+			"uid", uid,
+			"weight", weight,
+			"error", err,
+		)
+	}
+
+	if override.CPUMax != "" {
+		m.applyUserCPUMax(uid, override.CPUMax)
+	}
+}
+
+func (m *Manager) applyUserCPUMax(uid int, quota string) {
+	if err := m.cgroupManager.ApplyUserSubCgroupCPULimit(uid, quota); err != nil {
+		m.logger.Warn("Failed to apply per-user CPU limit",
+			"uid", uid,
+			"quota", quota,
+			"error", err,
+		)
+	}
+}
+
+func (m *Manager) applyUserRAMLimits(cfg *config.Config, uid int, maxStr, highStr string) {
+	if cfg.DisableSwap {
+		if err := m.cgroupManager.ApplyRAMLimitWithHighAndSwapDisabled(uid, maxStr, highStr); err != nil {
+			m.logger.Warn("Failed to apply RAM high+max limits with swap disabled for user",
+				"uid", uid,
+				"high", highStr,
+				"max", maxStr,
+				"error", err,
+			)
+		}
+	} else if err := m.cgroupManager.ApplyRAMLimitWithHigh(uid, maxStr, highStr); err != nil {
+		m.logger.Warn("Failed to apply RAM high+max limits for user",
+			"uid", uid,
+			"high", highStr,
+			"max", maxStr,
+			"error", err,
+		)
+	}
+
+	override, _ := m.userOverrideFor(cfg, uid)
+	if swapMax, swapHigh, zswapMax, ok := userSwapLimits(cfg, override, maxStr); ok {
+		if err := m.cgroupManager.ApplySwapLimits(uid, swapMax, swapHigh, zswapMax); err != nil {
+			m.logger.Warn("Failed to apply swap limits for user",
+				"uid", uid,
+				"swap_max", swapMax,
+				"swap_high", swapHigh,
+				"zswap_max", zswapMax,
+				"error", err,
+			)
+		}
+	}
+}
+
+// applyUserIOLimits scrive i limiti io.max dell'utente; con
+// IO_LIMIT_MODE=weight scrive invece il suo io.weight (e io.latency).
+func (m *Manager) applyUserIOLimits(cfg *config.Config, uid int, readBPS, writeBPS string, readIOPS, writeIOPS int) {
+	if cfg.GetIOLimitMode() == config.IOLimitModeWeight {
+		m.applyUserIOWeight(cfg, uid, m.ioWeightFor(uid))
+		return
+	}
+	if err := m.cgroupManager.ApplyIOLimit(uid, readBPS, writeBPS, readIOPS, writeIOPS, cfg.GetIODeviceFilter()); err != nil {
+		m.logger.Warn("Failed to apply IO limit for user",
+			"uid", uid,
+			"readBPS", readBPS,
+			"writeBPS", writeBPS,
+			"error", err,
+		)
+		return
+	}
+	m.logger.Debug("IO limit applied for user",
+		"uid", uid,
+		"readBPS", readBPS,
+		"writeBPS", writeBPS,
+	)
+}
+
+// reapplyUserOverrides riapplica agli utenti gia' limitati gli override
+// cambiati con un hot reload, senza attendere un ciclo deactivate/activate.
+// Un override rimosso riporta l'utente ai valori globali.
+func (m *Manager) reapplyUserOverrides(oldCfg, newCfg *config.Config) {
+	if oldCfg == nil || newCfg == nil {
+		return
+	}
+	if oldCfg.GetUserOverrideCount() == 0 && newCfg.GetUserOverrideCount() == 0 {
+		return
+	}
+
+	m.mu.RLock()
+	users := make([]int, 0, len(m.activeUsers))
+	boosted := make(map[int]bool, len(m.psiBoostedAt))
+	for uid := range m.activeUsers {
+		users = append(users, uid)
+	}
+	for uid := range m.psiBoostedAt {
+		boosted[uid] = true
+	}
+	m.mu.RUnlock()
+
+	// Solo i file delle risorse attive: le altre verranno applicate all'attivazione
+	cpuActive := m.isResourceActive(ResourceCPU)
+	ramActive := m.isResourceActive(ResourceRAM)
+	ioActive := m.isResourceActive(ResourceIO)
+
+	for _, uid := range users {
+		oldOverride, _ := m.userOverrideFor(oldCfg, uid)
+		newOverride, _ := m.userOverrideFor(newCfg, uid)
+		if oldOverride == newOverride {
+			continue
+		}
+
+		// Il boost PSI in corso ha la precedenza: il peso verra' ripristinato
+		// da revertPSIBoosts tramite cpuWeightFor
+		if cpuActive && oldOverride.CPUWeight != newOverride.CPUWeight && !boosted[uid] {
+			weight := m.cpuWeightFor(uid)
+			if err := m.cgroupManager.ApplyCPUWeight(uid, weight); err != nil {
+				m.logger.Warn("Failed to re-apply CPU weight override",
+					"uid", uid, "weight", weight, "error", err)
+			}
+		}
+
+		if cpuActive && oldOverride.CPUMax != newOverride.CPUMax {
+			quota := newOverride.CPUMax
+			if quota == "" {
+				quota = "max 100000"
+			}
+			m.applyUserCPUMax(uid, quota)
+		}
+
+		swapChanged := oldOverride.SwapMax != newOverride.SwapMax || oldOverride.SwapHigh != newOverride.SwapHigh ||
+			oldOverride.ZswapMax != newOverride.ZswapMax
+		if ramActive && (oldOverride.RAMMax != newOverride.RAMMax || oldOverride.RAMHigh != newOverride.RAMHigh || swapChanged) {
+			maxStr, highStr, ok := m.userRAMLimits(newCfg, uid, newOverride)
+			if !ok {
+				maxStr, highStr = "max", "max"
+			}
+			// I limiti di swap ripartono da "max": un campo rimosso dall'override
+			// torna al valore globale o senza limite
+			if swapChanged {
+				if err := m.cgroupManager.RemoveSwapLimits(uid); err != nil {
+					m.logger.Warn("Failed to remove swap limits override",
+						"uid", uid, "error", err)
+				}
+			}
+			m.applyUserRAMLimits(newCfg, uid, maxStr, highStr)
+		}
+
+		if ioActive && (oldOverride.IOReadBPS != newOverride.IOReadBPS || oldOverride.IOWriteBPS != newOverride.IOWriteBPS ||
+			oldOverride.IOReadIOPS != newOverride.IOReadIOPS || oldOverride.IOWriteIOPS != newOverride.IOWriteIOPS) {
+			readBPS, writeBPS, readIOPS, writeIOPS, ok := m.userIOLimits(newCfg, uid, newOverride)
+			if !ok {
+				readBPS, writeBPS, readIOPS, writeIOPS = "max", "max", 0, 0
+			}
+			m.applyUserIOLimits(newCfg, uid, readBPS, writeBPS, readIOPS, writeIOPS)
+		}
+
+		m.logger.Info("User overrides re-applied after configuration reload",
+			"uid", uid,
+			"username", m.getUsername(uid),
+		)
+	}
+}
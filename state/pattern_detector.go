@@ -23,6 +23,7 @@ import (
 	"time"
 
 	"github.com/fdefilippo/resman/config"
+	"github.com/fdefilippo/resman/database"
 	"github.com/fdefilippo/resman/logging"
 )
 
@@ -38,6 +39,16 @@ const (
 	PatternSporadic       WorkloadPattern = "sporadic"
 )
 
+// hoursPerWeek e' il numero di celle della matrice ora-della-settimana.
+const hoursPerWeek = 7 * 24
+
+// Giorni feriali e fine settimana, classificati separatamente.
+var (
+	weekdays    = []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+	weekendDays = []time.Weekday{time.Saturday, time.Sunday}
+	allWeekDays = []time.Weekday{time.Sunday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday}
+)
+
 // UserHourlyStats contiene le statistiche aggregate per fascia oraria.
 type UserHourlyStats struct {
 	HourlyCPU    [24]float64 // Media CPU per ora (0-23)
@@ -47,17 +58,108 @@ type UserHourlyStats struct {
 	LastSample   time.Time
 }
 
+// UserWeeklyStats contiene le statistiche per ora della settimana (7x24).
+type UserWeeklyStats struct {
+	CPU          [hoursPerWeek]float64 // Media CPU per giorno*24+ora (0 = domenica)
+	Count        [hoursPerWeek]int     // Numero di campioni per cella
+	TotalSamples int
+	FirstSample  time.Time
+	LastSample   time.Time
+}
+
+// hourOfWeek restituisce la cella della matrice per l'istante t (ora locale).
+func hourOfWeek(t time.Time) int {
+	return int(t.Weekday())*24 + t.Hour()
+}
+
+// isWeekend indica se t cade di sabato o domenica.
+func isWeekend(t time.Time) bool {
+	return t.Weekday() == time.Saturday || t.Weekday() == time.Sunday
+}
+
+// add aggiunge count campioni con media cpu alla cella idx.
+func (s *UserWeeklyStats) add(idx int, cpu float64, count int) {
+	if idx < 0 || idx >= hoursPerWeek || count <= 0 {
+		return
+	}
+	total := s.Count[idx] + count
+	s.CPU[idx] = (s.CPU[idx]*float64(s.Count[idx]) + cpu*float64(count)) / float64(total)
+	s.Count[idx] = total
+	s.TotalSamples += count
+}
+
+// hourly riduce la matrice ai 24 bucket orari dei giorni indicati.
+func (s *UserWeeklyStats) hourly(days []time.Weekday) *UserHourlyStats {
+	hourly := &UserHourlyStats{FirstSample: s.FirstSample, LastSample: s.LastSample}
+	for _, day := range days {
+		for hour := 0; hour < 24; hour++ {
+			idx := int(day)*24 + hour
+			count := s.Count[idx]
+			if count == 0 {
+				continue
+			}
+			total := hourly.HourlyCount[hour] + count
+			hourly.HourlyCPU[hour] = (hourly.HourlyCPU[hour]*float64(hourly.HourlyCount[hour]) + s.CPU[idx]*float64(count)) / float64(total)
+			hourly.HourlyCount[hour] = total
+			hourly.TotalSamples += count
+		}
+	}
+	return hourly
+}
+
 // PatternResult contiene il risultato della classificazione.
 type PatternResult struct {
-	Pattern    WorkloadPattern
+	Pattern    WorkloadPattern // Su tutta la settimana
 	Confidence float64
+
+	// Classificazione separata di giorni feriali e fine settimana
+	Weekday           WorkloadPattern
+	WeekdayConfidence float64
+	Weekend           WorkloadPattern
+	WeekendConfidence float64
+}
+
+// At restituisce il pattern da applicare all'istante t: quello del tipo di
+// giorno se riconosciuto, altrimenti quello dell'intera settimana.
+func (r PatternResult) At(t time.Time) (WorkloadPattern, float64) {
+	if isWeekend(t) {
+		if r.Weekend != "" && r.Weekend != PatternUnknown {
+			return r.Weekend, r.WeekendConfidence
+		}
+	} else if r.Weekday != "" && r.Weekday != PatternUnknown {
+		return r.Weekday, r.WeekdayConfidence
+	}
+	return r.Pattern, r.Confidence
+}
+
+// Split indica se feriali e fine settimana hanno pattern diversi, entrambi riconosciuti.
+func (r PatternResult) Split() bool {
+	known := func(p WorkloadPattern) bool { return p != "" && p != PatternUnknown }
+	return known(r.Weekday) && known(r.Weekend) && r.Weekday != r.Weekend
+}
+
+// Label restituisce il nome del pattern per metriche e log, ad esempio
+// "weekday_interactive_day+weekend_batch_night" per un pattern diviso.
+func (r PatternResult) Label() string {
+	if r.Split() {
+		return "weekday_" + string(r.Weekday) + "+weekend_" + string(r.Weekend)
+	}
+	return string(r.Pattern)
+}
+
+// LabelConfidence restituisce la confidenza associata a Label.
+func (r PatternResult) LabelConfidence() float64 {
+	if r.Split() {
+		return math.Min(r.WeekdayConfidence, r.WeekendConfidence)
+	}
+	return r.Confidence
 }
 
 // PatternDetector rileva i pattern di utilizzo per ogni utente.
 type PatternDetector struct {
 	mu           sync.RWMutex
 	logger       *logging.Logger
-	userStats    map[int]*UserHourlyStats // uid -> statistiche orarie
+	userStats    map[int]*UserWeeklyStats // uid -> statistiche per ora della settimana
 	lastAnalysis time.Time
 }
 
@@ -65,32 +167,34 @@ type PatternDetector struct {
 func NewPatternDetector(logger *logging.Logger) *PatternDetector {
 	return &PatternDetector{
 		logger:    logger,
-		userStats: make(map[int]*UserHourlyStats),
+		userStats: make(map[int]*UserWeeklyStats),
 	}
 }
 
 // Update aggiorna le statistiche per un utente con un nuovo campione.
 func (pd *PatternDetector) Update(uid int, cpuUsage float64) {
+	pd.updateAt(uid, cpuUsage, time.Now())
+}
+
+func (pd *PatternDetector) updateAt(uid int, cpuUsage float64, now time.Time) {
 	pd.mu.Lock()
 	defer pd.mu.Unlock()
 
 	stats, exists := pd.userStats[uid]
 	if !exists {
-		stats = &UserHourlyStats{
-			FirstSample: time.Now(),
+		stats = &UserWeeklyStats{
+			FirstSample: now,
 		}
 		pd.userStats[uid] = stats
 	}
 
-	now := time.Now()
-	hour := now.Hour()
-	stats.HourlyCPU[hour] = (stats.HourlyCPU[hour]*float64(stats.HourlyCount[hour]) + cpuUsage) / float64(stats.HourlyCount[hour]+1)
-	stats.HourlyCount[hour]++
-	stats.TotalSamples++
+	stats.add(hourOfWeek(now), cpuUsage, 1)
 	stats.LastSample = now
 }
 
 // Analyze analizza i pattern per tutti gli utenti e restituisce i risultati.
+// Feriali e fine settimana sono classificati anche separatamente, quando
+// ciascuno ha abbastanza campioni.
 func (pd *PatternDetector) Analyze(cfg *config.Config) map[int]PatternResult {
 	pd.mu.RLock()
 	defer pd.mu.RUnlock()
@@ -99,13 +203,19 @@ func (pd *PatternDetector) Analyze(cfg *config.Config) map[int]PatternResult {
 	minSamples := cfg.GetPatternMinSamples()
 	confidenceThreshold := cfg.GetPatternConfidenceThreshold()
 
-	for uid, stats := range pd.userStats {
-		if stats.TotalSamples < minSamples {
-			results[uid] = PatternResult{Pattern: PatternUnknown, Confidence: 0}
-			continue
+	classify := func(hourly *UserHourlyStats) (WorkloadPattern, float64) {
+		if hourly.TotalSamples < minSamples {
+			return PatternUnknown, 0
 		}
+		result := classifyPattern(hourly, confidenceThreshold)
+		return result.Pattern, result.Confidence
+	}
 
-		result := classifyPattern(stats, confidenceThreshold)
+	for uid, stats := range pd.userStats {
+		var result PatternResult
+		result.Pattern, result.Confidence = classify(stats.hourly(allWeekDays))
+		result.Weekday, result.WeekdayConfidence = classify(stats.hourly(weekdays))
+		result.Weekend, result.WeekendConfidence = classify(stats.hourly(weekendDays))
 		results[uid] = result
 	}
 
@@ -237,3 +347,47 @@ func (pd *PatternDetector) Cleanup(maxAge time.Duration) {
 		}
 	}
 }
+
+// Records esporta le statistiche come righe per utente e ora della settimana.
+func (pd *PatternDetector) Records() []database.PatternStatsRecord {
+	pd.mu.RLock()
+	defer pd.mu.RUnlock()
+
+	var records []database.PatternStatsRecord
+	for uid, stats := range pd.userStats {
+		for idx := 0; idx < hoursPerWeek; idx++ {
+			if stats.Count[idx] == 0 {
+				continue
+			}
+			records = append(records, database.PatternStatsRecord{
+				UID:        uid,
+				HourOfWeek: idx,
+				CPUAvg:     stats.CPU[idx],
+				Samples:    stats.Count[idx],
+				LastSample: stats.LastSample,
+			})
+		}
+	}
+	return records
+}
+
+// Merge aggiunge alle statistiche correnti righe salvate o ricostruite dallo storico.
+func (pd *PatternDetector) Merge(records []database.PatternStatsRecord) {
+	pd.mu.Lock()
+	defer pd.mu.Unlock()
+
+	for _, r := range records {
+		stats, exists := pd.userStats[r.UID]
+		if !exists {
+			stats = &UserWeeklyStats{FirstSample: r.LastSample}
+			pd.userStats[r.UID] = stats
+		}
+		stats.add(r.HourOfWeek, r.CPUAvg, r.Samples)
+		if r.LastSample.After(stats.LastSample) {
+			stats.LastSample = r.LastSample
+		}
+		if !r.LastSample.IsZero() && r.LastSample.Before(stats.FirstSample) {
+			stats.FirstSample = r.LastSample
+		}
+	}
+}
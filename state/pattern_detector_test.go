@@ -0,0 +1,86 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+package state
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fdefilippo/resman/config"
+	"github.com/fdefilippo/resman/logging"
+)
+
+// interactiveCPU e batchCPU sono profili orari tipici dei due pattern.
+func interactiveCPU(hour int) float64 {
+	switch {
+	case hour >= 8 && hour <= 17:
+		return 12
+	case hour >= 22 || hour <= 6:
+		return 4
+	default:
+		return 8
+	}
+}
+
+func batchCPU(hour int) float64 {
+	if hour >= 22 || hour <= 6 {
+		return 40
+	}
+	return 5
+}
+
+func TestPatternDetectorWeekdayWeekend(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.PatternConfidenceThreshold = 0.6
+	detector := NewPatternDetector(logging.GetLogger())
+
+	// Due settimane: interattivo nei feriali, batch notturno nel fine settimana
+	start := time.Date(2026, 10, 5, 0, 0, 0, 0, time.Local) // lunedi'
+	for ts := start; ts.Before(start.AddDate(0, 0, 14)); ts = ts.Add(time.Hour) {
+		cpu := interactiveCPU(ts.Hour())
+		if isWeekend(ts) {
+			cpu = batchCPU(ts.Hour())
+		}
+		detector.updateAt(1000, cpu, ts)
+	}
+
+	result := detector.Analyze(cfg)[1000]
+	if result.Weekday != PatternInteractiveDay {
+		t.Errorf("weekday pattern = %s, expected %s", result.Weekday, PatternInteractiveDay)
+	}
+	if result.Weekend != PatternBatchNight {
+		t.Errorf("weekend pattern = %s, expected %s", result.Weekend, PatternBatchNight)
+	}
+	if !result.Split() || result.Label() != "weekday_interactive_day+weekend_batch_night" {
+		t.Errorf("label = %s, expected a split weekday/weekend pattern", result.Label())
+	}
+
+	saturday := time.Date(2026, 10, 17, 12, 0, 0, 0, time.Local)
+	if pattern, _ := result.At(saturday); pattern != PatternBatchNight {
+		t.Errorf("pattern on Saturday = %s, expected %s", pattern, PatternBatchNight)
+	}
+	if pattern, _ := result.At(saturday.AddDate(0, 0, 2)); pattern != PatternInteractiveDay {
+		t.Errorf("pattern on Monday = %s, expected %s", pattern, PatternInteractiveDay)
+	}
+
+	// Le statistiche esportate e ricaricate danno la stessa classificazione
+	restored := NewPatternDetector(logging.GetLogger())
+	restored.Merge(detector.Records())
+	if got := restored.Analyze(cfg)[1000]; got.Label() != result.Label() {
+		t.Errorf("restored label = %s, expected %s", got.Label(), result.Label())
+	}
+}
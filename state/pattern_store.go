@@ -0,0 +1,100 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+// state/pattern_store.go
+package state
+
+import (
+	"time"
+
+	"github.com/fdefilippo/resman/database"
+)
+
+// PatternStore persiste le statistiche del PatternDetector tra riavvii
+// (implementato da database.DatabaseManager).
+type PatternStore interface {
+	LoadPatternStats() ([]database.PatternStatsRecord, error)
+	SavePatternStats(records []database.PatternStatsRecord) error
+	GetHourOfWeekCPU(since time.Time) ([]database.PatternStatsRecord, error)
+}
+
+// SetPatternStore collega il database delle metriche al rilevamento dei
+// pattern. Le statistiche vengono caricate al primo ciclo con
+// AUTODETECT_PATTERNS attivo.
+func (m *Manager) SetPatternStore(store PatternStore) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.patternStore = store
+	m.patternStatsLoaded = false
+}
+
+// loadPatternStats ricarica le statistiche salvate e vi aggiunge i campioni di
+// user_metrics successivi all'ultimo salvataggio (o dell'intera finestra
+// PATTERN_HISTORY_HOURS se non c'e' nulla di salvato).
+func (m *Manager) loadPatternStats(historyHours int) {
+	m.mu.Lock()
+	store := m.patternStore
+	loaded := m.patternStatsLoaded
+	m.patternStatsLoaded = true
+	m.mu.Unlock()
+	if store == nil || loaded || m.patternDetector == nil {
+		return
+	}
+
+	saved, err := store.LoadPatternStats()
+	if err != nil {
+		m.logger.Warn("Failed to load workload pattern statistics", "error", err)
+	}
+	since := time.Now().Add(-time.Duration(historyHours) * time.Hour)
+	for _, r := range saved {
+		if r.LastSample.After(since) {
+			since = r.LastSample
+		}
+	}
+
+	history, err := store.GetHourOfWeekCPU(since)
+	if err != nil {
+		m.logger.Warn("Failed to rebuild workload pattern statistics from history", "error", err)
+	}
+
+	m.patternDetector.Merge(saved)
+	m.patternDetector.Merge(history)
+
+	m.logger.Info("Workload pattern statistics loaded",
+		"saved_buckets", len(saved),
+		"history_buckets", len(history),
+		"history_since", since.Format(time.RFC3339),
+	)
+}
+
+// savePatternStats salva le statistiche correnti del PatternDetector.
+func (m *Manager) savePatternStats() {
+	m.mu.RLock()
+	store := m.patternStore
+	loaded := m.patternStatsLoaded
+	m.mu.RUnlock()
+	// Senza un caricamento precedente si sovrascriverebbe lo storico salvato
+	if store == nil || !loaded || m.patternDetector == nil || m.IsDryRun() {
+		return
+	}
+
+	records := m.patternDetector.Records()
+	if err := store.SavePatternStats(records); err != nil {
+		m.logger.Warn("Failed to save workload pattern statistics", "error", err)
+		return
+	}
+	m.logger.Debug("Workload pattern statistics saved", "buckets", len(records))
+}
@@ -0,0 +1,337 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+// state/persistence.go
+package state
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// persistedStateVersion e' la versione del formato di STATE_FILE.
+const persistedStateVersion = 1
+
+// persistedState e' lo stato dei limiti salvato in STATE_FILE, sufficiente a
+// riprendere la gerarchia "limited" e i timer dopo un riavvio del demone.
+type persistedState struct {
+	Version           int                            `json:"version"`
+	SavedAt           time.Time                      `json:"saved_at"`
+	LimitsActive      bool                           `json:"limits_active"`
+	LimitsAppliedTime time.Time                      `json:"limits_applied_time"`
+	SharedCgroupPath  string                         `json:"shared_cgroup_path,omitempty"`
+	ActiveUsers       []int                          `json:"active_users,omitempty"`
+	Resources         map[Resource]persistedResource `json:"resources"`
+	StableSamples     map[int]int                    `json:"stable_samples,omitempty"` // uid -> campioni consecutivi sotto soglia
+	PSIBoostedAt      map[int]time.Time              `json:"psi_boosted_at,omitempty"`
+
+	// Pin, override e revert delle policy per pattern: non scadono e devono
+	// sopravvivere al riavvio
+	PolicyOverrides map[int]persistedPolicyOverride `json:"policy_overrides,omitempty"`
+}
+
+// persistedResource e' lo stato della macchina a stati di una risorsa.
+type persistedResource struct {
+	Active              bool      `json:"active"`
+	AppliedTime         time.Time `json:"applied_time"`
+	StableCycles        int       `json:"stable_cycles"`
+	FirstOverThreshold  time.Time `json:"first_over_threshold"`
+	OverThresholdCycles int       `json:"over_threshold_cycles"`
+}
+
+// persistedPolicyOverride e' un intervento manuale sulla policy di un utente.
+type persistedPolicyOverride struct {
+	Source   string          `json:"source"`
+	Pattern  WorkloadPattern `json:"pattern,omitempty"`
+	CPUQuota int             `json:"cpu_quota,omitempty"`
+	RAMQuota string          `json:"ram_quota,omitempty"`
+	Reason   string          `json:"reason,omitempty"`
+	SetAt    time.Time       `json:"set_at"`
+}
+
+// snapshotState fotografa lo stato corrente dei limiti.
+func (m *Manager) snapshotState() persistedState {
+	snapshot := persistedState{
+		Version:      persistedStateVersion,
+		Resources:    make(map[Resource]persistedResource, len(Resources)),
+		PSIBoostedAt: make(map[int]time.Time),
+	}
+
+	m.mu.Lock()
+	snapshot.LimitsActive = m.limitsActive
+	snapshot.LimitsAppliedTime = m.limitsAppliedTime
+	snapshot.SharedCgroupPath = m.sharedCgroupPath
+	for uid := range m.activeUsers {
+		snapshot.ActiveUsers = append(snapshot.ActiveUsers, uid)
+	}
+	for uid, boostedAt := range m.psiBoostedAt {
+		snapshot.PSIBoostedAt[uid] = boostedAt
+	}
+	for _, res := range Resources {
+		l := m.limiterLocked(res)
+		l.tracker.mu.RLock()
+		snapshot.Resources[res] = persistedResource{
+			Active:              l.active,
+			AppliedTime:         l.appliedTime,
+			StableCycles:        l.stableCycles,
+			FirstOverThreshold:  l.tracker.firstOverThresholdTime,
+			OverThresholdCycles: l.tracker.overThresholdCycles,
+		}
+		l.tracker.mu.RUnlock()
+	}
+	m.mu.Unlock()
+	sort.Ints(snapshot.ActiveUsers)
+
+	if m.stabilityTracker != nil {
+		m.stabilityTracker.mu.RLock()
+		snapshot.StableSamples = make(map[int]int, len(m.stabilityTracker.underThreshold))
+		for uid, samples := range m.stabilityTracker.underThreshold {
+			snapshot.StableSamples[uid] = samples
+		}
+		m.stabilityTracker.mu.RUnlock()
+	}
+
+	if m.policyEngine != nil {
+		snapshot.PolicyOverrides = m.policyEngine.Overrides()
+	}
+
+	return snapshot
+}
+
+// persistState salva lo stato in STATE_FILE se e' cambiato dall'ultimo
+// salvataggio. In dry-run lo stato e' simulato e non viene salvato.
+func (m *Manager) persistState() {
+	cfg := m.GetConfig()
+	if !cfg.GetStatePersistEnabled() || m.IsDryRun() {
+		return
+	}
+
+	snapshot := m.snapshotState()
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		m.logger.Warn("Failed to encode limiter state", "error", err)
+		return
+	}
+	if bytes.Equal(data, m.lastSavedState) {
+		return
+	}
+
+	snapshot.SavedAt = time.Now()
+	stamped, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		m.logger.Warn("Failed to encode limiter state", "error", err)
+		return
+	}
+	if err := writeFileAtomic(cfg.GetStateFile(), stamped); err != nil {
+		m.logger.Warn("Failed to save limiter state",
+			"file", cfg.GetStateFile(),
+			"error", err,
+		)
+		return
+	}
+	m.lastSavedState = data
+
+	m.logger.Debug("Limiter state saved",
+		"file", cfg.GetStateFile(),
+		"limits_active", snapshot.LimitsActive,
+		"active_users", len(snapshot.ActiveUsers),
+	)
+}
+
+// writeFileAtomic scrive il file tramite un file temporaneo e un rename, cosi'
+// un crash durante la scrittura non lascia uno stato troncato.
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// loadPersistedState legge STATE_FILE. Restituisce nil senza errore se il file
+// non esiste.
+func loadPersistedState(path string) (*persistedState, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var saved persistedState
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("invalid state file %s: %w", path, err)
+	}
+	if saved.Version != persistedStateVersion {
+		return nil, fmt.Errorf("unsupported state file version %d in %s", saved.Version, path)
+	}
+	return &saved, nil
+}
+
+// RestoreState riprende lo stato dei limiti lasciato da un'istanza precedente:
+// adotta la gerarchia "limited" esistente con i suoi membri invece di
+// ricrearla e ripristina tempo minimo di attivazione e contatori di stabilita'
+// da STATE_FILE. Va chiamato prima del primo ciclo di controllo.
+func (m *Manager) RestoreState() {
+	// Gli episodi non ripresi vanno chiusi anche senza STATE_PERSIST_ENABLED
+	defer m.reconcileLimitEvents()
+
+	cfg := m.GetConfig()
+	if !cfg.GetStatePersistEnabled() || m.IsDryRun() || m.cgroupManager == nil {
+		return
+	}
+
+	saved, err := loadPersistedState(cfg.GetStateFile())
+	if err != nil {
+		// Uno stato illeggibile non impedisce di adottare la gerarchia
+		m.logger.Warn("Ignoring unreadable limiter state", "file", cfg.GetStateFile(), "error", err)
+		saved = nil
+	}
+	if saved != nil && len(saved.PolicyOverrides) > 0 && m.policyEngine != nil {
+		m.policyEngine.RestoreOverrides(saved.PolicyOverrides)
+		m.logger.Info("Pattern policy overrides restored", "users", len(saved.PolicyOverrides))
+	}
+
+	sharedPath, members, err := m.cgroupManager.AdoptSharedCgroup()
+	if err != nil {
+		m.logger.Info("No shared cgroup to adopt, starting without active limits", "reason", err)
+		return
+	}
+	if len(members) == 0 {
+		m.logger.Info("Shared cgroup has no users, starting without active limits", "path", sharedPath)
+		return
+	}
+
+	// Con le slice systemd ogni utente con una sessione ha una slice: sono
+	// limitati solo quelli salvati in STATE_FILE
+	if m.cgroupManager.UsesSystemdSlices() {
+		limited := make(map[int]bool)
+		if saved != nil {
+			for _, uid := range saved.ActiveUsers {
+				limited[uid] = true
+			}
+		}
+		for uid := range members {
+			if !limited[uid] {
+				delete(members, uid)
+			}
+		}
+		if len(members) == 0 {
+			m.logger.Info("No limited user slices to adopt, starting without active limits", "path", sharedPath)
+			return
+		}
+	}
+
+	now := time.Now()
+	restored := make([]Resource, 0, len(Resources))
+	enabled := make(map[Resource]bool, len(Resources))
+	for _, res := range enabledResources(cfg) {
+		enabled[res] = true
+	}
+
+	m.mu.Lock()
+	m.sharedCgroupPath = sharedPath
+	m.limitsActive = true
+	m.limitsAppliedTime = now
+	if saved != nil && !saved.LimitsAppliedTime.IsZero() {
+		m.limitsAppliedTime = saved.LimitsAppliedTime
+	}
+	for uid, parentPath := range members {
+		m.activeUsers[uid] = true
+		m.userCgroupParent[uid] = parentPath
+		if parentPath != sharedPath {
+			m.groupCgroupPaths[filepath.Base(parentPath)] = parentPath
+		}
+		if saved != nil {
+			if boostedAt, ok := saved.PSIBoostedAt[uid]; ok {
+				m.psiBoostedAt[uid] = boostedAt
+			}
+		}
+	}
+
+	for _, res := range Resources {
+		l := m.limiterLocked(res)
+		state, ok := persistedResource{}, false
+		if saved != nil {
+			state, ok = saved.Resources[res]
+		}
+		if !ok {
+			// Stato non salvato: le risorse abilitate ripartono attive da ora
+			state = persistedResource{Active: enabled[res], AppliedTime: now}
+		}
+
+		l.active = state.Active
+		l.stableCycles = state.StableCycles
+		l.appliedTime = time.Time{}
+		if l.active {
+			l.appliedTime = state.AppliedTime
+			if l.appliedTime.IsZero() {
+				l.appliedTime = now
+			}
+			restored = append(restored, res)
+		}
+		l.tracker.mu.Lock()
+		l.tracker.firstOverThresholdTime = state.FirstOverThreshold
+		l.tracker.overThresholdCycles = state.OverThresholdCycles
+		l.tracker.mu.Unlock()
+	}
+	// Utenti nel cgroup condiviso senza risorse attive non verrebbero mai
+	// rilasciati: le risorse abilitate ripartono attive da ora
+	if len(restored) == 0 {
+		for _, res := range enabledResources(cfg) {
+			l := m.limiterLocked(res)
+			l.active = true
+			l.appliedTime = now
+			restored = append(restored, res)
+		}
+	}
+	m.mu.Unlock()
+
+	if saved != nil && m.stabilityTracker != nil {
+		m.stabilityTracker.mu.Lock()
+		for uid, samples := range saved.StableSamples {
+			if _, ok := members[uid]; ok {
+				m.stabilityTracker.underThreshold[uid] = samples
+			}
+		}
+		m.stabilityTracker.mu.Unlock()
+	}
+
+	if m.prometheusExporter != nil {
+		for _, res := range restored {
+			m.prometheusExporter.RecordResourceLimitState(string(res), true)
+		}
+	}
+
+	m.logger.Info("Limiter state restored from previous instance",
+		"shared_cgroup", sharedPath,
+		"adopted_users", len(members),
+		"active_resources", restored,
+		"state_file_found", saved != nil,
+	)
+
+	m.persistState()
+}
@@ -0,0 +1,123 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+package state
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fdefilippo/resman/config"
+)
+
+// adoptingCgroupManager simula una gerarchia "limited" lasciata da un'istanza precedente.
+type adoptingCgroupManager struct {
+	mockCgroupManager
+	sharedPath string
+	members    map[int]string
+}
+
+func (a *adoptingCgroupManager) AdoptSharedCgroup() (string, map[int]string, error) {
+	return a.sharedPath, a.members, nil
+}
+
+func TestPersistAndRestoreState(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.StatePersistEnabled = true
+	cfg.StateFile = filepath.Join(t.TempDir(), "state.json")
+
+	// Prima istanza: CPU attiva da un'ora, utente 1000 quasi stabile
+	first, err := NewManager(cfg, &mockMetricsCollector{}, &mockCgroupManager{}, &mockPrometheusExporter{})
+	if err != nil {
+		t.Fatalf("NewManager() error: %v", err)
+	}
+	appliedTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	first.setResourceActive(ResourceCPU, true)
+	first.mu.Lock()
+	first.limiters[ResourceCPU].appliedTime = appliedTime
+	first.limiters[ResourceCPU].stableCycles = 2
+	first.limitsActive = true
+	first.activeUsers[1000] = true
+	first.sharedCgroupPath = "/sys/fs/cgroup/resman/limited"
+	first.mu.Unlock()
+	first.stabilityTracker.underThreshold[1000] = 2
+	first.persistState()
+
+	// Seconda istanza: adotta la gerarchia esistente e riprende i timer
+	adopting := &adoptingCgroupManager{
+		sharedPath: "/sys/fs/cgroup/resman/limited",
+		members: map[int]string{
+			1000: "/sys/fs/cgroup/resman/limited",
+			1001: "/sys/fs/cgroup/resman/limited/batch",
+		},
+	}
+	second, err := NewManager(cfg, &mockMetricsCollector{}, adopting, &mockPrometheusExporter{})
+	if err != nil {
+		t.Fatalf("NewManager() error: %v", err)
+	}
+	second.RestoreState()
+
+	if !second.isUserLimited(1000) || !second.isUserLimited(1001) {
+		t.Error("adopted users should be limited")
+	}
+	if second.sharedCgroupPath != adopting.sharedPath {
+		t.Errorf("sharedCgroupPath = %s, expected %s", second.sharedCgroupPath, adopting.sharedPath)
+	}
+	if got := second.groupCgroupPaths["batch"]; got != adopting.members[1001] {
+		t.Errorf("group cgroup path = %s, expected %s", got, adopting.members[1001])
+	}
+	if !second.isResourceActive(ResourceCPU) {
+		t.Fatal("CPU limits should be restored as active")
+	}
+	if second.isResourceActive(ResourceRAM) {
+		t.Error("RAM limits were not active and should stay inactive")
+	}
+	cpu := second.limiters[ResourceCPU]
+	if !cpu.appliedTime.Equal(appliedTime) {
+		t.Errorf("CPU applied time = %v, expected %v (minimum active time must not restart)", cpu.appliedTime, appliedTime)
+	}
+	if cpu.stableCycles != 2 {
+		t.Errorf("CPU stable cycles = %d, expected 2", cpu.stableCycles)
+	}
+	if got := second.stabilityTracker.underThreshold[1000]; got != 2 {
+		t.Errorf("stability samples for uid 1000 = %d, expected 2", got)
+	}
+}
+
+func TestRestoreStateWithoutStateFile(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.StatePersistEnabled = true
+	cfg.StateFile = filepath.Join(t.TempDir(), "missing.json")
+
+	adopting := &adoptingCgroupManager{
+		sharedPath: "/sys/fs/cgroup/resman/limited",
+		members:    map[int]string{1000: "/sys/fs/cgroup/resman/limited"},
+	}
+	manager, err := NewManager(cfg, &mockMetricsCollector{}, adopting, &mockPrometheusExporter{})
+	if err != nil {
+		t.Fatalf("NewManager() error: %v", err)
+	}
+	manager.RestoreState()
+
+	// Senza stato salvato le risorse abilitate ripartono attive da ora
+	if !manager.isUserLimited(1000) || !manager.isResourceActive(ResourceCPU) {
+		t.Error("members of an existing hierarchy should be adopted with CPU limits active")
+	}
+	if since := time.Since(manager.limiters[ResourceCPU].appliedTime); since > time.Minute {
+		t.Errorf("CPU applied time should restart from now, got %v ago", since)
+	}
+}
@@ -0,0 +1,271 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+// state/policy_audit.go
+package state
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/fdefilippo/resman/database"
+)
+
+// Azioni registrate nello storico delle policy.
+const (
+	PolicyActionApply    = "apply"    // quote scritte dalla schedule
+	PolicyActionRollback = "rollback" // policy scaduta, valori precedenti ripristinati
+	PolicyActionPin      = "pin"
+	PolicyActionOverride = "override"
+	PolicyActionRevert   = "revert"
+	PolicyActionResume   = "resume"
+)
+
+// PolicyAuditStore conserva lo storico delle policy per pattern
+// (implementato da database.DatabaseManager).
+type PolicyAuditStore interface {
+	SavePolicyAudit(record *database.PolicyAuditRecord) error
+	GetPolicyAudit(uid int, limit int) ([]database.PolicyAuditRecord, error)
+}
+
+// SetPolicyAuditStore collega il database delle metriche allo storico delle
+// policy per pattern.
+func (m *Manager) SetPolicyAuditStore(store PolicyAuditStore) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.policyAuditStore = store
+}
+
+// GetPolicyAudit restituisce gli ultimi eventi dello storico delle policy di
+// un utente (uid <= 0 per tutti).
+func (m *Manager) GetPolicyAudit(uid int, limit int) ([]database.PolicyAuditRecord, error) {
+	m.mu.RLock()
+	store := m.policyAuditStore
+	m.mu.RUnlock()
+	if store == nil {
+		return nil, fmt.Errorf("policy audit requires the metrics database (METRICS_DB_ENABLED=true)")
+	}
+	return store.GetPolicyAudit(uid, limit)
+}
+
+// auditPolicy salva un evento nello storico. In dry-run nessuna quota viene
+// scritta e lo storico non viene aggiornato.
+func (m *Manager) auditPolicy(record database.PolicyAuditRecord) {
+	if record.Timestamp.IsZero() {
+		record.Timestamp = time.Now()
+	}
+	m.journalPolicy(record)
+
+	m.mu.RLock()
+	store := m.policyAuditStore
+	m.mu.RUnlock()
+	if store == nil || m.IsDryRun() {
+		return
+	}
+
+	if err := store.SavePolicyAudit(&record); err != nil {
+		m.logger.Warn("Failed to save policy audit record",
+			"uid", record.UID,
+			"action", record.Action,
+			"error", err,
+		)
+	}
+}
+
+// cpuMaxValue restituisce il valore di cpu.max di una quota in microsecondi.
+func cpuMaxValue(quota int) string {
+	if quota <= 0 {
+		return ""
+	}
+	return strconv.Itoa(quota) + " 100000"
+}
+
+// policyRestoreValues restituisce cpu.max e memory.max che il limiter darebbe
+// ora al cgroup dell'utente senza policy. Per un utente limitato il cgroup e'
+// il suo sottocgroup in "limited" (limitedCPUMax e, con limiti RAM attivi, il
+// suo memory.max); altrimenti CPU_QUOTA_NORMAL, la quota che il limiter scrive
+// quando non limita. Letti al momento del rollback, non prima della policy,
+// non ripristinano un limite ormai scaduto ne' cancellano uno attivo.
+func (m *Manager) policyRestoreValues(uid int) (string, string) {
+	cfg := m.GetConfig()
+	cpuMax, ramMax := cfg.CPUQuotaNormal, "max"
+	if cpuMax == "" {
+		cpuMax = "max 100000"
+	}
+	if m.isUserLimited(uid) {
+		cpuMax = m.limitedCPUMax(cfg, uid)
+	}
+	if m.isUserLimited(uid) && m.isResourceActive(ResourceRAM) {
+		override, _ := m.userOverrideFor(cfg, uid)
+		if maxStr, _, ok := m.userRAMLimits(cfg, uid, override); ok {
+			ramMax = maxStr
+		}
+	}
+	return cpuMax, ramMax
+}
+
+// rollbackPolicy toglie la policy dal cgroup dell'utente e registra l'evento.
+func (m *Manager) rollbackPolicy(rollback PolicyRollback, action, reason string) {
+	uid := rollback.UID
+	cpuMax, ramMax := m.policyRestoreValues(uid)
+
+	if rollback.CPUQuota > 0 {
+		if err := m.cgroupManager.ApplyPolicyCPULimit(uid, cpuMax); err != nil {
+			m.logger.Warn("Failed to restore CPU limit after pattern policy",
+				"uid", uid,
+				"cpu_max", cpuMax,
+				"error", err,
+			)
+		}
+	}
+	if rollback.RAMQuota != "" {
+		if err := m.cgroupManager.ApplyRAMLimit(uid, ramMax); err != nil {
+			m.logger.Warn("Failed to restore RAM limit after pattern policy",
+				"uid", uid,
+				"memory_max", ramMax,
+				"error", err,
+			)
+		}
+	}
+
+	m.logger.Info("Workload pattern policy rolled back",
+		"uid", uid,
+		"action", action,
+		"pattern", rollback.Pattern,
+		"cpu_max", cpuMax,
+		"memory_max", ramMax,
+		"reason", reason,
+	)
+	m.auditPolicy(database.PolicyAuditRecord{
+		UID:               uid,
+		Action:            action,
+		Pattern:           string(rollback.Pattern),
+		Window:            rollback.Window,
+		CPUMax:            cpuMax,
+		MemoryMax:         ramMax,
+		PreviousCPUMax:    cpuMaxValue(rollback.CPUQuota),
+		PreviousMemoryMax: rollback.RAMQuota,
+		Reason:            reason,
+	})
+}
+
+// expirePatternPolicies ripristina i valori precedenti per gli utenti il cui
+// pattern non viene piu' aggiornato. Il chiamante deve possedere opMu.
+func (m *Manager) expirePatternPolicies(maxAge time.Duration) {
+	for _, rollback := range m.policyEngine.Cleanup(maxAge) {
+		m.rollbackPolicy(rollback, PolicyActionRollback,
+			fmt.Sprintf("pattern not updated for %s", maxAge))
+	}
+}
+
+// PinPatternPolicy fissa il pattern di un utente (vuoto: quello in vigore):
+// le sue quote seguono la schedule di quel pattern anche se la
+// classificazione automatica cambia.
+func (m *Manager) PinPatternPolicy(uid int, pattern WorkloadPattern, reason string) (WorkloadPattern, error) {
+	if m.policyEngine == nil {
+		return "", fmt.Errorf("policy engine not available")
+	}
+	if !m.GetConfig().GetAutodetectPatterns() {
+		return "", fmt.Errorf("pattern policies require AUTODETECT_PATTERNS=true")
+	}
+	m.opMu.Lock()
+	defer m.opMu.Unlock()
+
+	pinned, err := m.policyEngine.Pin(uid, pattern, reason)
+	if err != nil {
+		return "", err
+	}
+	m.auditPolicy(database.PolicyAuditRecord{
+		UID:     uid,
+		Action:  PolicyActionPin,
+		Pattern: string(pinned),
+		Reason:  reason,
+	})
+	m.persistState()
+	m.applyScheduledPolicies(time.Now())
+	return pinned, nil
+}
+
+// OverridePatternPolicy fissa le quote di un utente indipendentemente da
+// pattern e fascia oraria.
+func (m *Manager) OverridePatternPolicy(uid int, cpuQuota int, ramQuota string, reason string) error {
+	if m.policyEngine == nil {
+		return fmt.Errorf("policy engine not available")
+	}
+	if !m.GetConfig().GetAutodetectPatterns() {
+		return fmt.Errorf("pattern policies require AUTODETECT_PATTERNS=true")
+	}
+	m.opMu.Lock()
+	defer m.opMu.Unlock()
+
+	if err := m.policyEngine.Override(uid, cpuQuota, ramQuota, reason); err != nil {
+		return err
+	}
+	m.auditPolicy(database.PolicyAuditRecord{
+		UID:       uid,
+		Action:    PolicyActionOverride,
+		CPUMax:    cpuMaxValue(cpuQuota),
+		MemoryMax: ramQuota,
+		Reason:    reason,
+	})
+	m.persistState()
+	m.applyScheduledPolicies(time.Now())
+	return nil
+}
+
+// RevertPatternPolicy annulla la policy di un utente ripristinando i valori
+// precedenti e sospende le policy automatiche fino a ResumePatternPolicy.
+// Restituisce false se l'utente non aveva una policy applicata.
+func (m *Manager) RevertPatternPolicy(uid int, reason string) (bool, error) {
+	if m.policyEngine == nil {
+		return false, fmt.Errorf("policy engine not available")
+	}
+	m.opMu.Lock()
+	defer m.opMu.Unlock()
+
+	rollback := m.policyEngine.Revert(uid, reason)
+	m.persistState()
+	if rollback == nil {
+		m.auditPolicy(database.PolicyAuditRecord{UID: uid, Action: PolicyActionRevert, Reason: reason})
+		return false, nil
+	}
+	m.rollbackPolicy(*rollback, PolicyActionRevert, reason)
+	return true, nil
+}
+
+// ResumePatternPolicy rimuove pin, override o revert di un utente, che torna
+// alle policy automatiche.
+func (m *Manager) ResumePatternPolicy(uid int, reason string) error {
+	if m.policyEngine == nil {
+		return fmt.Errorf("policy engine not available")
+	}
+	m.opMu.Lock()
+	defer m.opMu.Unlock()
+
+	rollback, ok := m.policyEngine.Resume(uid)
+	if !ok {
+		return fmt.Errorf("UID %d has no pinned, overridden or reverted pattern policy", uid)
+	}
+	m.persistState()
+	if rollback != nil {
+		m.rollbackPolicy(*rollback, PolicyActionResume, reason)
+	} else {
+		m.auditPolicy(database.PolicyAuditRecord{UID: uid, Action: PolicyActionResume, Reason: reason})
+	}
+	m.applyScheduledPolicies(time.Now())
+	return nil
+}
@@ -18,6 +18,8 @@
 package state
 
 import (
+	"fmt"
+	"sort"
 	"sync"
 	"time"
 
@@ -25,8 +27,28 @@ import (
 	"github.com/fdefilippo/resman/logging"
 )
 
+// Fasce orarie delle schedule (PATTERN_NIGHT_*_HOUR, PATTERN_BUSINESS_*_HOUR).
+const (
+	PolicyWindowNight    = "night"    // fascia notturna, anche nel fine settimana
+	PolicyWindowBusiness = "business" // orario lavorativo, lunedi'-venerdi'
+	PolicyWindowDefault  = "default"  // resto della giornata
+)
+
+// Origine delle quote di una policy.
+const (
+	PolicySourceAuto     = "auto"     // pattern rilevato
+	PolicySourcePinned   = "pinned"   // pattern fissato dall'amministratore
+	PolicySourceOverride = "override" // quote fissate dall'amministratore
+	PolicySourceReverted = "reverted" // policy annullata, nessuna quota automatica
+)
+
 // UserPolicy contiene le policy applicate a un utente.
 type UserPolicy struct {
+	Pattern          WorkloadPattern // pattern in vigore nella fascia corrente
+	Window           string          // fascia oraria della schedule
+	Source           string          // PolicySourceAuto, PolicySourcePinned o PolicySourceOverride
+	Confidence       float64         // confidenza del pattern rilevato
+	Reason           string
 	CPUQuota         int    // CPU quota in microseconds
 	RAMQuota         string // RAM quota string (e.g., "1G")
 	AppliedAt        time.Time
@@ -35,66 +57,279 @@ type UserPolicy struct {
 	PreviousRAMQuota string
 }
 
-// PolicyEngine applica policy basate sui pattern rilevati.
+// PolicyTransition e' un cambio di quote della schedule di un utente, gia'
+// applicato o previsto a un confine di fascia oraria.
+type PolicyTransition struct {
+	UID              int             `json:"uid"`
+	At               time.Time       `json:"at"`
+	Pattern          WorkloadPattern `json:"pattern,omitempty"`
+	Window           string          `json:"window"`
+	Source           string          `json:"source"`
+	Confidence       float64         `json:"confidence,omitempty"`
+	Reason           string          `json:"reason,omitempty"`
+	CPUQuota         int             `json:"cpu_quota"`
+	RAMQuota         string          `json:"ram_quota,omitempty"`
+	PreviousCPUQuota int             `json:"previous_cpu_quota,omitempty"`
+	PreviousRAMQuota string          `json:"previous_ram_quota,omitempty"`
+}
+
+// PolicyRollback toglie la policy di un utente: il cgroup torna ai valori
+// che il limiter gli darebbe senza policy.
+type PolicyRollback struct {
+	UID      int
+	Pattern  WorkloadPattern
+	Window   string
+	CPUQuota int
+	RAMQuota string
+}
+
+// policySchedule e' l'ultimo pattern rilevato per un utente: le quote di ogni
+// momento derivano da questo e dalla fascia oraria.
+type policySchedule struct {
+	result    PatternResult
+	updatedAt time.Time
+}
+
+// policyOverride e' un intervento manuale sulla policy di un utente: prevale
+// sul pattern rilevato e non scade.
+type policyOverride struct {
+	source   string          // PolicySourcePinned, PolicySourceOverride o PolicySourceReverted
+	pattern  WorkloadPattern // pattern fissato (pinned)
+	cpuQuota int             // quote fissate (override)
+	ramQuota string
+	reason   string
+	setAt    time.Time
+}
+
+// policyTarget sono le quote previste per un utente in un istante.
+type policyTarget struct {
+	pattern    WorkloadPattern
+	window     string
+	source     string
+	confidence float64
+	reason     string
+	cpuQuota   int
+	ramQuota   string
+}
+
+// PolicyEngine trasforma i pattern rilevati in schedule a fasce orarie: un
+// utente batch ha CPU generosa di notte e ridotta in orario lavorativo.
 type PolicyEngine struct {
 	mu           sync.RWMutex
 	logger       *logging.Logger
-	userPolicies map[int]*UserPolicy // uid -> policy corrente
+	schedules    map[int]*policySchedule // uid -> pattern da cui deriva la schedule
+	overrides    map[int]*policyOverride // uid -> intervento manuale
+	userPolicies map[int]*UserPolicy     // uid -> policy corrente
 }
 
 // NewPolicyEngine crea un nuovo PolicyEngine.
 func NewPolicyEngine(logger *logging.Logger) *PolicyEngine {
 	return &PolicyEngine{
 		logger:       logger,
+		schedules:    make(map[int]*policySchedule),
+		overrides:    make(map[int]*policyOverride),
 		userPolicies: make(map[int]*UserPolicy),
 	}
 }
 
-// ApplyPolicy applica una policy a un utente basata sul pattern rilevato.
-// Restituisce true se la policy e' stata effettivamente cambiata.
-func (pe *PolicyEngine) ApplyPolicy(uid int, pattern WorkloadPattern, cfg *config.Config) bool {
+// SetSchedule registra il pattern rilevato per un utente. Le quote vengono
+// applicate da ApplyDue. Un risultato senza alcun pattern riconosciuto lascia
+// invariata la schedule esistente.
+func (pe *PolicyEngine) SetSchedule(uid int, result PatternResult) {
+	if !knownPattern(result.Pattern) && !knownPattern(result.Weekday) && !knownPattern(result.Weekend) {
+		return
+	}
+
+	pe.mu.Lock()
+	defer pe.mu.Unlock()
+	pe.schedules[uid] = &policySchedule{result: result, updatedAt: time.Now()}
+}
+
+// ApplyDue confronta la policy corrente di ogni utente con quella prevista
+// in now e restituisce i cambi da scrivere nei cgroup.
+func (pe *PolicyEngine) ApplyDue(now time.Time, cfg *config.Config) []PolicyTransition {
 	pe.mu.Lock()
 	defer pe.mu.Unlock()
 
-	// Determina la policy target basata sul pattern
-	targetCPUQuota, targetRAMQuota := pe.getQuotasForPattern(pattern, cfg)
+	var transitions []PolicyTransition
+	for _, uid := range pe.managedUIDsLocked() {
+		target, ok := pe.targetAtLocked(uid, now, cfg)
+		if !ok {
+			continue
+		}
+
+		existing, exists := pe.userPolicies[uid]
+		if exists && existing.CPUQuota == target.cpuQuota && existing.RAMQuota == target.ramQuota {
+			// Quote invariate, aggiorna solo la fascia corrente
+			existing.Pattern = target.pattern
+			existing.Window = target.window
+			existing.Source = target.source
+			existing.Confidence = target.confidence
+			existing.Reason = target.reason
+			continue
+		}
+
+		transition := PolicyTransition{
+			UID:        uid,
+			At:         now,
+			Pattern:    target.pattern,
+			Window:     target.window,
+			Source:     target.source,
+			Confidence: target.confidence,
+			Reason:     target.reason,
+			CPUQuota:   target.cpuQuota,
+			RAMQuota:   target.ramQuota,
+		}
+		if exists {
+			transition.PreviousCPUQuota = existing.CPUQuota
+			transition.PreviousRAMQuota = existing.RAMQuota
+			existing.PreviousCPUQuota = existing.CPUQuota
+			existing.PreviousRAMQuota = existing.RAMQuota
+			existing.LastChanged = now
+		} else {
+			existing = &UserPolicy{AppliedAt: now, LastChanged: now}
+			pe.userPolicies[uid] = existing
+		}
+		existing.Pattern = target.pattern
+		existing.Window = target.window
+		existing.Source = target.source
+		existing.Confidence = target.confidence
+		existing.Reason = target.reason
+		existing.CPUQuota = target.cpuQuota
+		existing.RAMQuota = target.ramQuota
+		transitions = append(transitions, transition)
 
-	// Se non c'e' pattern riconosciuto, non applicare nulla
-	if pattern == PatternUnknown {
-		return false
+		pe.logger.Info("Workload pattern policy applied",
+			"uid", uid,
+			"pattern", target.pattern,
+			"window", target.window,
+			"source", target.source,
+			"cpu_quota", target.cpuQuota,
+			"ram_quota", target.ramQuota,
+		)
 	}
 
-	existing, exists := pe.userPolicies[uid]
-	if exists && existing.CPUQuota == targetCPUQuota && existing.RAMQuota == targetRAMQuota {
-		// Policy gia' applicata, nessun cambiamento
-		return false
+	return transitions
+}
+
+// UpcomingTransitions restituisce i cambi di quote previsti tra now e
+// now+horizon, in ordine di tempo. I confini delle fasce cadono sempre allo
+// scoccare di un'ora locale.
+func (pe *PolicyEngine) UpcomingTransitions(now time.Time, horizon time.Duration, cfg *config.Config) []PolicyTransition {
+	pe.mu.RLock()
+	defer pe.mu.RUnlock()
+
+	end := now.Add(horizon)
+	var transitions []PolicyTransition
+	for _, uid := range pe.managedUIDsLocked() {
+		current, ok := pe.targetAtLocked(uid, now, cfg)
+		if !ok {
+			continue
+		}
+		cpuQuota, ramQuota := current.cpuQuota, current.ramQuota
+		if policy, ok := pe.userPolicies[uid]; ok {
+			cpuQuota, ramQuota = policy.CPUQuota, policy.RAMQuota
+		}
+
+		for t := nextHour(now); !t.After(end); t = nextHour(t) {
+			target, ok := pe.targetAtLocked(uid, t, cfg)
+			if !ok || (target.cpuQuota == cpuQuota && target.ramQuota == ramQuota) {
+				continue
+			}
+			transitions = append(transitions, PolicyTransition{
+				UID:              uid,
+				At:               t,
+				Pattern:          target.pattern,
+				Window:           target.window,
+				Source:           target.source,
+				Confidence:       target.confidence,
+				Reason:           target.reason,
+				CPUQuota:         target.cpuQuota,
+				RAMQuota:         target.ramQuota,
+				PreviousCPUQuota: cpuQuota,
+				PreviousRAMQuota: ramQuota,
+			})
+			cpuQuota, ramQuota = target.cpuQuota, target.ramQuota
+		}
 	}
 
-	// Applica nuova policy
-	now := time.Now()
-	if exists {
-		existing.PreviousCPUQuota = existing.CPUQuota
-		existing.PreviousRAMQuota = existing.RAMQuota
-		existing.CPUQuota = targetCPUQuota
-		existing.RAMQuota = targetRAMQuota
-		existing.LastChanged = now
-	} else {
-		pe.userPolicies[uid] = &UserPolicy{
-			CPUQuota:    targetCPUQuota,
-			RAMQuota:    targetRAMQuota,
-			AppliedAt:   now,
-			LastChanged: now,
+	sort.SliceStable(transitions, func(i, j int) bool {
+		return transitions[i].At.Before(transitions[j].At)
+	})
+	return transitions
+}
+
+// nextHour restituisce l'inizio dell'ora locale successiva a t. time.Truncate
+// non va bene con fusi orari non allineati all'ora.
+func nextHour(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+}
+
+// managedUIDsLocked restituisce in ordine gli utenti con una schedule o un
+// intervento manuale.
+func (pe *PolicyEngine) managedUIDsLocked() []int {
+	uids := make([]int, 0, len(pe.schedules)+len(pe.overrides))
+	for uid := range pe.schedules {
+		uids = append(uids, uid)
+	}
+	for uid := range pe.overrides {
+		if _, ok := pe.schedules[uid]; !ok {
+			uids = append(uids, uid)
 		}
 	}
+	sort.Ints(uids)
+	return uids
+}
 
-	pe.logger.Info("Workload pattern policy applied",
-		"uid", uid,
-		"pattern", pattern,
-		"cpu_quota", targetCPUQuota,
-		"ram_quota", targetRAMQuota,
-	)
+// targetAtLocked restituisce le quote previste per un utente in t: un
+// intervento manuale prevale sul pattern rilevato.
+func (pe *PolicyEngine) targetAtLocked(uid int, t time.Time, cfg *config.Config) (policyTarget, bool) {
+	window := policyWindowAt(t, cfg)
+
+	if override, ok := pe.overrides[uid]; ok {
+		switch override.source {
+		case PolicySourceOverride:
+			return policyTarget{
+				pattern:  override.pattern,
+				window:   window,
+				source:   PolicySourceOverride,
+				reason:   override.reason,
+				cpuQuota: override.cpuQuota,
+				ramQuota: override.ramQuota,
+			}, true
+		case PolicySourcePinned:
+			cpuQuota, ramQuota := pe.getQuotasForPattern(override.pattern, window, cfg)
+			return policyTarget{
+				pattern:  override.pattern,
+				window:   window,
+				source:   PolicySourcePinned,
+				reason:   override.reason,
+				cpuQuota: cpuQuota,
+				ramQuota: ramQuota,
+			}, true
+		default:
+			return policyTarget{}, false
+		}
+	}
 
-	return true
+	schedule, ok := pe.schedules[uid]
+	if !ok {
+		return policyTarget{}, false
+	}
+	pattern, confidence := schedule.result.At(t)
+	if !knownPattern(pattern) {
+		return policyTarget{}, false
+	}
+	cpuQuota, ramQuota := pe.getQuotasForPattern(pattern, window, cfg)
+	return policyTarget{
+		pattern:    pattern,
+		window:     window,
+		source:     PolicySourceAuto,
+		confidence: confidence,
+		reason:     fmt.Sprintf("detected %s pattern (confidence %.2f), %s window", pattern, confidence, window),
+		cpuQuota:   cpuQuota,
+		ramQuota:   ramQuota,
+	}, true
 }
 
 // GetPolicy restituisce la policy corrente per un utente.
@@ -105,11 +340,229 @@ func (pe *PolicyEngine) GetPolicy(uid int) (*UserPolicy, bool) {
 	return policy, exists
 }
 
-// getQuotasForPattern restituisce le quote CPU/RAM per un pattern.
-func (pe *PolicyEngine) getQuotasForPattern(pattern WorkloadPattern, cfg *config.Config) (int, string) {
+// GetPolicies restituisce una copia delle policy correnti di tutti gli
+// utenti. Gli utenti con policy annullata compaiono con Source
+// PolicySourceReverted e senza quote.
+func (pe *PolicyEngine) GetPolicies() map[int]UserPolicy {
+	pe.mu.RLock()
+	defer pe.mu.RUnlock()
+	policies := make(map[int]UserPolicy, len(pe.userPolicies))
+	for uid, policy := range pe.userPolicies {
+		policies[uid] = *policy
+	}
+	for uid, override := range pe.overrides {
+		if override.source == PolicySourceReverted {
+			policies[uid] = UserPolicy{Source: PolicySourceReverted, Reason: override.reason, LastChanged: override.setAt}
+		}
+	}
+	return policies
+}
+
+// Overrides restituisce una copia degli interventi manuali, da salvare in
+// STATE_FILE.
+func (pe *PolicyEngine) Overrides() map[int]persistedPolicyOverride {
+	pe.mu.RLock()
+	defer pe.mu.RUnlock()
+	overrides := make(map[int]persistedPolicyOverride, len(pe.overrides))
+	for uid, override := range pe.overrides {
+		overrides[uid] = persistedPolicyOverride{
+			Source:   override.source,
+			Pattern:  override.pattern,
+			CPUQuota: override.cpuQuota,
+			RAMQuota: override.ramQuota,
+			Reason:   override.reason,
+			SetAt:    override.setAt,
+		}
+	}
+	return overrides
+}
+
+// RestoreOverrides riprende gli interventi manuali salvati da un'istanza
+// precedente. Le quote di pin e override vengono riscritte dal primo
+// ApplyDue; gli utenti con revert restano senza policy automatiche.
+func (pe *PolicyEngine) RestoreOverrides(overrides map[int]persistedPolicyOverride) {
+	pe.mu.Lock()
+	defer pe.mu.Unlock()
+	for uid, saved := range overrides {
+		switch saved.Source {
+		case PolicySourcePinned, PolicySourceOverride, PolicySourceReverted:
+		default:
+			continue
+		}
+		pe.overrides[uid] = &policyOverride{
+			source:   saved.Source,
+			pattern:  saved.Pattern,
+			cpuQuota: saved.CPUQuota,
+			ramQuota: saved.RAMQuota,
+			reason:   saved.Reason,
+			setAt:    saved.SetAt,
+		}
+	}
+}
+
+// HasManualPolicy indica se un utente ha un pin o un override, le cui quote
+// vengono applicate anche senza un pattern rilevato.
+func (pe *PolicyEngine) HasManualPolicy(uid int) bool {
+	pe.mu.RLock()
+	defer pe.mu.RUnlock()
+	override, ok := pe.overrides[uid]
+	return ok && override.source != PolicySourceReverted
+}
+
+// Pin fissa il pattern di un utente: la schedule non segue piu' il pattern
+// rilevato. Con pattern vuoto viene fissato quello in vigore.
+func (pe *PolicyEngine) Pin(uid int, pattern WorkloadPattern, reason string) (WorkloadPattern, error) {
+	pe.mu.Lock()
+	defer pe.mu.Unlock()
+
+	if pattern == "" {
+		policy, ok := pe.userPolicies[uid]
+		if !ok || !knownPattern(policy.Pattern) {
+			return "", fmt.Errorf("no detected pattern to pin for UID %d", uid)
+		}
+		pattern = policy.Pattern
+	}
+	if !validPolicyPattern(pattern) {
+		return "", fmt.Errorf("invalid pattern %q: expected one of %v", pattern, policyPatterns)
+	}
+
+	pe.overrides[uid] = &policyOverride{
+		source:  PolicySourcePinned,
+		pattern: pattern,
+		reason:  reason,
+		setAt:   time.Now(),
+	}
+	return pattern, nil
+}
+
+// Override fissa le quote di un utente indipendentemente da pattern e fascia.
+func (pe *PolicyEngine) Override(uid int, cpuQuota int, ramQuota string, reason string) error {
+	if cpuQuota < 0 {
+		return fmt.Errorf("invalid CPU quota %d for UID %d", cpuQuota, uid)
+	}
+	if cpuQuota == 0 && ramQuota == "" {
+		return fmt.Errorf("override for UID %d needs a CPU or RAM quota", uid)
+	}
+
+	pe.mu.Lock()
+	defer pe.mu.Unlock()
+
+	var pattern WorkloadPattern
+	if policy, ok := pe.userPolicies[uid]; ok {
+		pattern = policy.Pattern
+	}
+	pe.overrides[uid] = &policyOverride{
+		source:   PolicySourceOverride,
+		pattern:  pattern,
+		cpuQuota: cpuQuota,
+		ramQuota: ramQuota,
+		reason:   reason,
+		setAt:    time.Now(),
+	}
+	return nil
+}
+
+// Revert annulla la policy di un utente e sospende quelle automatiche fino a
+// Resume. Restituisce il rollback da applicare, nil se non c'era una policy.
+func (pe *PolicyEngine) Revert(uid int, reason string) *PolicyRollback {
+	pe.mu.Lock()
+	defer pe.mu.Unlock()
+
+	pe.overrides[uid] = &policyOverride{
+		source: PolicySourceReverted,
+		reason: reason,
+		setAt:  time.Now(),
+	}
+	return pe.dropPolicyLocked(uid)
+}
+
+// Resume rimuove pin, override o revert di un utente, che torna alle policy
+// automatiche. Senza un pattern rilevato la policy manuale viene annullata e
+// restituito il rollback da applicare.
+func (pe *PolicyEngine) Resume(uid int) (*PolicyRollback, bool) {
+	pe.mu.Lock()
+	defer pe.mu.Unlock()
+
+	if _, ok := pe.overrides[uid]; !ok {
+		return nil, false
+	}
+	delete(pe.overrides, uid)
+	if _, ok := pe.schedules[uid]; ok {
+		return nil, true
+	}
+	return pe.dropPolicyLocked(uid), true
+}
+
+// dropPolicyLocked rimuove la policy corrente di un utente e restituisce il
+// rollback da applicare.
+func (pe *PolicyEngine) dropPolicyLocked(uid int) *PolicyRollback {
+	policy, ok := pe.userPolicies[uid]
+	if !ok {
+		return nil
+	}
+	delete(pe.userPolicies, uid)
+	return &PolicyRollback{
+		UID:      uid,
+		Pattern:  policy.Pattern,
+		Window:   policy.Window,
+		CPUQuota: policy.CPUQuota,
+		RAMQuota: policy.RAMQuota,
+	}
+}
+
+// policyPatterns sono i pattern che si possono fissare a mano.
+var policyPatterns = []WorkloadPattern{PatternBatchNight, PatternInteractiveDay, PatternMixed, PatternAlwaysOn, PatternSporadic}
+
+func validPolicyPattern(pattern WorkloadPattern) bool {
+	for _, p := range policyPatterns {
+		if p == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// knownPattern indica se un pattern e' stato riconosciuto.
+func knownPattern(pattern WorkloadPattern) bool {
+	return pattern != "" && pattern != PatternUnknown
+}
+
+// policyWindowAt restituisce la fascia oraria di t. La notte ha la precedenza
+// se le due fasce si sovrappongono; nel fine settimana non c'e' orario
+// lavorativo.
+func policyWindowAt(t time.Time, cfg *config.Config) string {
+	hour := t.Hour()
+	nightStart, nightEnd := cfg.GetPatternNightHours()
+	if nightStart < nightEnd {
+		if hour >= nightStart && hour < nightEnd {
+			return PolicyWindowNight
+		}
+	} else if hour >= nightStart || hour < nightEnd {
+		return PolicyWindowNight
+	}
+
+	businessStart, businessEnd := cfg.GetPatternBusinessHours()
+	if !isWeekend(t) && hour >= businessStart && hour < businessEnd {
+		return PolicyWindowBusiness
+	}
+	return PolicyWindowDefault
+}
+
+// getQuotasForPattern restituisce le quote CPU/RAM per un pattern in una
+// fascia oraria. Solo i batch notturni cambiano quote durante la giornata.
+func (pe *PolicyEngine) getQuotasForPattern(pattern WorkloadPattern, window string, cfg *config.Config) (int, string) {
 	switch pattern {
 	case PatternBatchNight:
-		return cfg.GetBatchNightCPUQuota(), cfg.GetBatchNightRAMQuota()
+		// La RAM resta la stessa tutto il giorno: ridurre memory.max a un job
+		// ancora in esecuzione lo farebbe uccidere dall'OOM killer
+		switch window {
+		case PolicyWindowNight:
+			return cfg.GetBatchNightCPUQuota(), cfg.GetBatchNightRAMQuota()
+		case PolicyWindowBusiness:
+			return cfg.GetBatchDayCPUQuota(), cfg.GetBatchNightRAMQuota()
+		default:
+			return cfg.GetInteractiveCPUQuota(), cfg.GetBatchNightRAMQuota()
+		}
 	case PatternInteractiveDay:
 		return cfg.GetInteractiveCPUQuota(), cfg.GetInteractiveRAMQuota()
 	case PatternMixed:
@@ -128,15 +581,28 @@ func (pe *PolicyEngine) getQuotasForPattern(pattern WorkloadPattern, cfg *config
 	}
 }
 
-// Cleanup rimuove policy non piu' attive.
-func (pe *PolicyEngine) Cleanup(maxAge time.Duration) {
+// Cleanup rimuove le schedule degli utenti il cui pattern non viene
+// aggiornato da piu' di maxAge e restituisce i rollback delle loro policy
+// automatiche. Pin e override non scadono.
+func (pe *PolicyEngine) Cleanup(maxAge time.Duration) []PolicyRollback {
 	pe.mu.Lock()
 	defer pe.mu.Unlock()
 
 	now := time.Now()
-	for uid, policy := range pe.userPolicies {
-		if now.Sub(policy.LastChanged) > maxAge {
-			delete(pe.userPolicies, uid)
+	var rollbacks []PolicyRollback
+	for uid, schedule := range pe.schedules {
+		if now.Sub(schedule.updatedAt) <= maxAge {
+			continue
+		}
+		delete(pe.schedules, uid)
+		if _, ok := pe.overrides[uid]; ok {
+			continue
+		}
+		if rollback := pe.dropPolicyLocked(uid); rollback != nil {
+			rollbacks = append(rollbacks, *rollback)
 		}
 	}
+
+	sort.Slice(rollbacks, func(i, j int) bool { return rollbacks[i].UID < rollbacks[j].UID })
+	return rollbacks
 }
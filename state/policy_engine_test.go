@@ -0,0 +1,281 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+package state
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fdefilippo/resman/config"
+	"github.com/fdefilippo/resman/database"
+	"github.com/fdefilippo/resman/logging"
+)
+
+// policyCgroupManager registra i valori di cpu.max e memory.max scritti dalle
+// policy e quante volte ApplyCPULimit ha spostato i processi dell'utente.
+type policyCgroupManager struct {
+	mockCgroupManager
+	cpuMax     map[int]string
+	ramMax     map[int]string
+	migrations int
+}
+
+func (p *policyCgroupManager) ApplyCPULimit(uid int, quota string) error {
+	p.cpuMax[uid] = quota
+	p.migrations++
+	return nil
+}
+
+func (p *policyCgroupManager) ApplyPolicyCPULimit(uid int, quota string) error {
+	p.cpuMax[uid] = quota
+	return nil
+}
+
+func (p *policyCgroupManager) ApplyRAMLimit(uid int, limit string) error {
+	if p.ramMax != nil {
+		p.ramMax[uid] = limit
+	}
+	return nil
+}
+
+func TestPolicyEngineBatchNightSchedule(t *testing.T) {
+	cfg := config.DefaultConfig()
+	engine := NewPolicyEngine(logging.GetLogger())
+	engine.SetSchedule(1000, PatternResult{Pattern: PatternBatchNight, Confidence: 0.9})
+	engine.SetSchedule(1001, PatternResult{Pattern: PatternUnknown})
+
+	// Mercoledi' 21:30: fuori da notte e orario lavorativo
+	wednesday := time.Date(2026, 10, 14, 21, 30, 0, 0, time.Local)
+	applied := engine.ApplyDue(wednesday, cfg)
+	if len(applied) != 1 || applied[0].UID != 1000 {
+		t.Fatalf("ApplyDue() = %+v, expected a single policy for uid 1000", applied)
+	}
+	if applied[0].Window != PolicyWindowDefault || applied[0].CPUQuota != cfg.InteractiveCPUQuota {
+		t.Errorf("policy at 21:30 = %s/%d, expected %s/%d",
+			applied[0].Window, applied[0].CPUQuota, PolicyWindowDefault, cfg.InteractiveCPUQuota)
+	}
+	if again := engine.ApplyDue(wednesday.Add(10*time.Minute), cfg); len(again) != 0 {
+		t.Errorf("unchanged quotas should not be applied again, got %+v", again)
+	}
+
+	expected := []struct {
+		hour     int
+		window   string
+		cpuQuota int
+	}{
+		{22, PolicyWindowNight, cfg.BatchNightCPUQuota},
+		{6, PolicyWindowDefault, cfg.InteractiveCPUQuota},
+		{8, PolicyWindowBusiness, cfg.BatchDayCPUQuota},
+		{18, PolicyWindowDefault, cfg.InteractiveCPUQuota},
+	}
+	transitions := engine.UpcomingTransitions(wednesday, 24*time.Hour, cfg)
+	if len(transitions) != len(expected) {
+		t.Fatalf("UpcomingTransitions() returned %d transitions, expected %d: %+v", len(transitions), len(expected), transitions)
+	}
+	for i, want := range expected {
+		got := transitions[i]
+		if got.At.Hour() != want.hour || got.At.Minute() != 0 || got.Window != want.window || got.CPUQuota != want.cpuQuota {
+			t.Errorf("transition %d = %s %s/%d, expected %02d:00 %s/%d",
+				i, got.At.Format("15:04"), got.Window, got.CPUQuota, want.hour, want.window, want.cpuQuota)
+		}
+		if got.RAMQuota != cfg.BatchNightRAMQuota {
+			t.Errorf("transition %d RAM quota = %s, batch users keep %s all day", i, got.RAMQuota, cfg.BatchNightRAMQuota)
+		}
+	}
+
+	// Alle 22 lo scheduler passa alla quota notturna
+	applied = engine.ApplyDue(time.Date(2026, 10, 14, 22, 0, 0, 0, time.Local), cfg)
+	if len(applied) != 1 || applied[0].CPUQuota != cfg.BatchNightCPUQuota || applied[0].PreviousCPUQuota != cfg.InteractiveCPUQuota {
+		t.Errorf("ApplyDue() at 22:00 = %+v, expected the night quota", applied)
+	}
+
+	// Sabato non c'e' orario lavorativo
+	saturday := time.Date(2026, 10, 17, 10, 0, 0, 0, time.Local)
+	if window := policyWindowAt(saturday, cfg); window != PolicyWindowDefault {
+		t.Errorf("window on Saturday 10:00 = %s, expected %s", window, PolicyWindowDefault)
+	}
+}
+
+func TestPatternPolicyLifecycle(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.AutodetectPatterns = true
+	cgroups := &policyCgroupManager{cpuMax: make(map[int]string)}
+	manager, err := NewManager(cfg, &mockMetricsCollector{}, cgroups, &mockPrometheusExporter{})
+	if err != nil {
+		t.Fatalf("NewManager() error: %v", err)
+	}
+	db, err := database.NewDatabaseManager(":memory:")
+	if err != nil {
+		t.Fatalf("NewDatabaseManager() error: %v", err)
+	}
+	defer db.Close()
+	manager.SetPolicyAuditStore(db)
+
+	expectCPU := func(step, want string) {
+		t.Helper()
+		if got := cgroups.cpuMax[1000]; got != want {
+			t.Errorf("%s: cpu.max = %q, expected %q", step, got, want)
+		}
+	}
+
+	manager.policyEngine.SetSchedule(1000, PatternResult{Pattern: PatternInteractiveDay, Confidence: 0.8})
+	manager.applyScheduledPolicies(time.Now())
+	expectCPU("automatic policy", "50000 100000")
+
+	if err := manager.OverridePatternPolicy(1000, 150000, "", "misclassified"); err != nil {
+		t.Fatalf("OverridePatternPolicy() error: %v", err)
+	}
+	expectCPU("override", "150000 100000")
+
+	// Revert riporta la quota del limiter (CPU_QUOTA_NORMAL) e sospende le policy automatiche
+	if restored, err := manager.RevertPatternPolicy(1000, "undo"); err != nil || !restored {
+		t.Fatalf("RevertPatternPolicy() = %v, %v", restored, err)
+	}
+	expectCPU("revert", "max 100000")
+	manager.applyScheduledPolicies(time.Now())
+	expectCPU("automatic policy after revert", "max 100000")
+
+	if err := manager.ResumePatternPolicy(1000, "reclassified"); err != nil {
+		t.Fatalf("ResumePatternPolicy() error: %v", err)
+	}
+	expectCPU("resume", "50000 100000")
+
+	// Un pattern non piu' aggiornato scade con rollback
+	manager.policyEngine.schedules[1000].updatedAt = time.Now().Add(-48 * time.Hour)
+	manager.expirePatternPolicies(24 * time.Hour)
+	expectCPU("expiry", "max 100000")
+	if _, ok := manager.policyEngine.GetPolicy(1000); ok {
+		t.Error("expired policy should be removed")
+	}
+
+	records, err := manager.GetPolicyAudit(1000, 0)
+	if err != nil {
+		t.Fatalf("GetPolicyAudit() error: %v", err)
+	}
+	actions := make(map[string]int)
+	for _, record := range records {
+		actions[record.Action]++
+	}
+	for action, count := range map[string]int{
+		PolicyActionApply:    3,
+		PolicyActionOverride: 1,
+		PolicyActionRevert:   1,
+		PolicyActionResume:   1,
+		PolicyActionRollback: 1,
+	} {
+		if actions[action] != count {
+			t.Errorf("audit has %d %q records, expected %d", actions[action], action, count)
+		}
+	}
+	if len(records) > 0 && records[0].Action != PolicyActionRollback {
+		t.Errorf("most recent audit record = %q, expected %q", records[0].Action, PolicyActionRollback)
+	}
+	// Policy e rollback non spostano i processi fuori dal sottocgroup di limite
+	if cgroups.migrations != 0 {
+		t.Errorf("policies moved the user's processes %d times, expected none", cgroups.migrations)
+	}
+}
+
+func TestPolicyRollbackUsesCurrentLimits(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.AutodetectPatterns = true
+	cfg.RAMEnabled = true
+	cfg.RAMQuotaPerUser = "2G"
+	cfg.CPUQuotaNormal = "400000 100000"
+	cgroups := &policyCgroupManager{cpuMax: make(map[int]string), ramMax: make(map[int]string)}
+	manager, err := NewManager(cfg, &mockMetricsCollector{}, cgroups, &mockPrometheusExporter{})
+	if err != nil {
+		t.Fatalf("NewManager() error: %v", err)
+	}
+
+	for _, uid := range []int{1000, 1001} {
+		manager.policyEngine.SetSchedule(uid, PatternResult{Pattern: PatternInteractiveDay, Confidence: 0.8})
+	}
+	manager.applyScheduledPolicies(time.Now())
+
+	// Il limiter ha limitato 1000 dopo l'applicazione della policy
+	manager.mu.Lock()
+	manager.activeUsers[1000] = true
+	manager.mu.Unlock()
+	manager.setResourceActive(ResourceRAM, true)
+
+	for _, uid := range []int{1000, 1001} {
+		if _, err := manager.RevertPatternPolicy(uid, "undo"); err != nil {
+			t.Fatalf("RevertPatternPolicy(%d) error: %v", uid, err)
+		}
+	}
+	// L'utente limitato riprende il suo limite RAM attivo, l'altro nessun limite
+	if got := cgroups.ramMax[1000]; got != "2G" {
+		t.Errorf("memory.max of limited user after revert = %q, expected the active limit 2G", got)
+	}
+	if got := cgroups.ramMax[1001]; got != "max" {
+		t.Errorf("memory.max of unlimited user after revert = %q, expected max", got)
+	}
+	// cpu.max torna al valore del cgroup su cui e' scritto: nessun limite
+	// proprio sul sottocgroup in "limited", CPU_QUOTA_NORMAL per gli altri
+	if got := cgroups.cpuMax[1000]; got != "max 100000" {
+		t.Errorf("cpu.max of limited user after revert = %q, expected max 100000", got)
+	}
+	if got := cgroups.cpuMax[1001]; got != cfg.CPUQuotaNormal {
+		t.Errorf("cpu.max of unlimited user after revert = %q, expected %s", got, cfg.CPUQuotaNormal)
+	}
+	if cgroups.migrations != 0 {
+		t.Errorf("rollback moved the user's processes %d times, expected none", cgroups.migrations)
+	}
+}
+
+func TestPolicyOverridesSurviveRestart(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.AutodetectPatterns = true
+	cfg.StatePersistEnabled = true
+	cfg.StateFile = filepath.Join(t.TempDir(), "state.json")
+
+	first, err := NewManager(cfg, &mockMetricsCollector{}, &policyCgroupManager{cpuMax: make(map[int]string)}, &mockPrometheusExporter{})
+	if err != nil {
+		t.Fatalf("NewManager() error: %v", err)
+	}
+	if _, err := first.PinPatternPolicy(1000, PatternBatchNight, "nightly jobs"); err != nil {
+		t.Fatalf("PinPatternPolicy() error: %v", err)
+	}
+	if _, err := first.RevertPatternPolicy(1001, "leave alone"); err != nil {
+		t.Fatalf("RevertPatternPolicy() error: %v", err)
+	}
+
+	cgroups := &policyCgroupManager{cpuMax: make(map[int]string)}
+	second, err := NewManager(cfg, &mockMetricsCollector{}, cgroups, &mockPrometheusExporter{})
+	if err != nil {
+		t.Fatalf("NewManager() error: %v", err)
+	}
+	second.RestoreState()
+
+	if !second.policyEngine.HasManualPolicy(1000) {
+		t.Fatal("pin of uid 1000 should be restored")
+	}
+	// Il revert resta in vigore: un pattern rilevato non viene applicato
+	second.policyEngine.SetSchedule(1001, PatternResult{Pattern: PatternInteractiveDay, Confidence: 0.8})
+	second.applyScheduledPolicies(time.Now())
+	if _, ok := cgroups.cpuMax[1000]; !ok {
+		t.Error("pinned policy should be applied again after restart")
+	}
+	if got, ok := cgroups.cpuMax[1001]; ok {
+		t.Errorf("reverted user got cpu.max %q after restart, expected no automatic policy", got)
+	}
+	if policy := second.GetPatternPolicies()[1001]; policy.Source != PolicySourceReverted {
+		t.Errorf("uid 1001 policy source = %q, expected %q", policy.Source, PolicySourceReverted)
+	}
+}
@@ -0,0 +1,132 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+// state/policy_scheduler.go
+package state
+
+import (
+	"context"
+	"time"
+
+	"github.com/fdefilippo/resman/database"
+)
+
+// StartPolicyScheduler avvia lo scheduler delle policy per pattern: allo
+// scoccare di ogni ora confronta le quote di ogni utente con quelle previste
+// dalla sua schedule e scrive nei cgroup quelle cambiate (ad esempio un
+// utente batch che passa dalla fascia notturna all'orario lavorativo).
+// Si ferma alla cancellazione di ctx.
+func (m *Manager) StartPolicyScheduler(ctx context.Context) {
+	if m.cgroupManager == nil || m.policyEngine == nil {
+		return
+	}
+
+	m.watcherWg.Add(1)
+	go func() {
+		defer m.watcherWg.Done()
+
+		for {
+			// I confini delle fasce cadono sempre allo scoccare di un'ora locale
+			timer := time.NewTimer(time.Until(nextHour(time.Now())))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case now := <-timer.C:
+				m.opMu.Lock()
+				m.applyScheduledPolicies(now)
+				m.opMu.Unlock()
+			}
+		}
+	}()
+}
+
+// applyScheduledPolicies applica le quote previste in now dalle schedule.
+// Il chiamante deve possedere opMu.
+func (m *Manager) applyScheduledPolicies(now time.Time) {
+	cfg := m.GetConfig()
+	if !cfg.GetAutodetectPatterns() || m.policyEngine == nil {
+		return
+	}
+
+	for _, transition := range m.policyEngine.ApplyDue(now, cfg) {
+		m.applyPolicyTransition(transition)
+	}
+}
+
+// applyPolicyTransition scrive nel cgroup dell'utente le quote di una
+// transizione e la registra nello storico. La RAM viene riscritta solo se
+// cambia.
+func (m *Manager) applyPolicyTransition(transition PolicyTransition) {
+	uid := transition.UID
+
+	if transition.CPUQuota > 0 {
+		if err := m.cgroupManager.ApplyPolicyCPULimit(uid, cpuMaxValue(transition.CPUQuota)); err != nil {
+			m.logger.Warn("Failed to apply pattern-based CPU limit",
+				"uid", uid,
+				"pattern", transition.Pattern,
+				"window", transition.Window,
+				"error", err,
+			)
+		}
+	}
+	if transition.RAMQuota != "" && transition.RAMQuota != transition.PreviousRAMQuota {
+		if err := m.cgroupManager.ApplyRAMLimit(uid, transition.RAMQuota); err != nil {
+			m.logger.Warn("Failed to apply pattern-based RAM limit",
+				"uid", uid,
+				"pattern", transition.Pattern,
+				"window", transition.Window,
+				"ram_quota", transition.RAMQuota,
+				"error", err,
+			)
+		}
+	}
+
+	reason := transition.Source
+	if transition.Reason != "" {
+		reason += ": " + transition.Reason
+	}
+	m.auditPolicy(database.PolicyAuditRecord{
+		Timestamp:         transition.At,
+		UID:               uid,
+		Action:            PolicyActionApply,
+		Pattern:           string(transition.Pattern),
+		Window:            transition.Window,
+		Confidence:        transition.Confidence,
+		CPUMax:            cpuMaxValue(transition.CPUQuota),
+		MemoryMax:         transition.RAMQuota,
+		PreviousCPUMax:    cpuMaxValue(transition.PreviousCPUQuota),
+		PreviousMemoryMax: transition.PreviousRAMQuota,
+		Reason:            reason,
+	})
+}
+
+// GetUpcomingPolicyTransitions restituisce i cambi di quote previsti dalle
+// schedule delle policy per pattern nelle prossime horizon.
+func (m *Manager) GetUpcomingPolicyTransitions(horizon time.Duration) []PolicyTransition {
+	if m.policyEngine == nil {
+		return nil
+	}
+	return m.policyEngine.UpcomingTransitions(time.Now(), horizon, m.GetConfig())
+}
+
+// GetPatternPolicies restituisce le policy per pattern in vigore per utente.
+func (m *Manager) GetPatternPolicies() map[int]UserPolicy {
+	if m.policyEngine == nil {
+		return nil
+	}
+	return m.policyEngine.GetPolicies()
+}
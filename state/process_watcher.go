@@ -0,0 +1,150 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+// state/process_watcher.go
+package state
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// StartProcessWatcher avvia lo sweep periodico dei processi sfuggiti: i
+// processi che un utente limitato avvia dopo l'attivazione da un padre fuori
+// dal suo cgroup (nuove sessioni SSH, cron) vengono riportati nel suo
+// sottocgroup. Si ferma alla cancellazione di ctx.
+func (m *Manager) StartProcessWatcher(ctx context.Context) {
+	if m.cgroupManager == nil {
+		return
+	}
+
+	m.watcherWg.Add(1)
+	go func() {
+		defer m.watcherWg.Done()
+
+		interval := m.processWatcherInterval()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				m.sweepEscapedProcesses()
+				// L'intervallo puo' cambiare con un hot reload
+				if next := m.processWatcherInterval(); next != interval {
+					interval = next
+					ticker.Reset(interval)
+				}
+			}
+		}
+	}()
+}
+
+func (m *Manager) processWatcherInterval() time.Duration {
+	interval := time.Duration(m.GetConfig().GetProcessWatcherIntervalMs()) * time.Millisecond
+	if interval <= 0 {
+		return 3 * time.Second
+	}
+	return interval
+}
+
+// sweepEscapedProcesses sposta nei sottocgroup degli utenti limitati i loro
+// processi nati altrove dall'ultimo sweep. Con le slice systemd non serve:
+// ogni processo dell'utente nasce gia' nella sua slice.
+func (m *Manager) sweepEscapedProcesses() {
+	if !m.GetConfig().GetProcessWatcherEnabled() || m.cgroupManager.UsesSystemdSlices() {
+		return
+	}
+
+	m.mu.RLock()
+	parents := make(map[int]string, len(m.activeUsers))
+	targets := make(map[int]string, len(m.activeUsers))
+	for uid := range m.activeUsers {
+		parentPath := m.userCgroupParent[uid]
+		if parentPath == "" {
+			parentPath = m.sharedCgroupPath
+		}
+		if parentPath == "" {
+			continue
+		}
+		parents[uid] = parentPath
+		targets[uid] = m.userCgroupPath(parentPath, uid)
+	}
+	m.mu.RUnlock()
+
+	// Il sottocgroup viene creato poco dopo l'attivazione: fino ad allora
+	// i processi li sposta MoveAllUserProcessesToSharedCgroup
+	if !m.IsDryRun() {
+		for uid, userPath := range targets {
+			if _, err := os.Stat(userPath); err != nil {
+				delete(targets, uid)
+			}
+		}
+	}
+	if len(targets) == 0 {
+		return
+	}
+
+	// La scansione di /proc avviene senza opMu, per non fermare il ciclo di
+	// controllo; gli spostamenti sono serializzati con attivazione e rilascio,
+	// che creano e rimuovono i sottocgroup
+	escaped := m.cgroupManager.FindEscapedProcesses(targets)
+	if len(escaped) == 0 {
+		return
+	}
+	m.opMu.Lock()
+	defer m.opMu.Unlock()
+
+	for uid, pids := range escaped {
+		// Utente rilasciato o spostato durante la scansione: lo spostamento
+		// ricreerebbe il sottocgroup
+		m.mu.RLock()
+		parentPath := m.userCgroupParent[uid]
+		if parentPath == "" {
+			parentPath = m.sharedCgroupPath
+		}
+		stillLimited := m.activeUsers[uid] && parentPath == parents[uid]
+		m.mu.RUnlock()
+		if !stillLimited {
+			continue
+		}
+
+		moved, failed := 0, 0
+		for _, pid := range pids {
+			if err := m.cgroupManager.MoveProcessToSharedCgroup(pid, parents[uid], uid); err != nil {
+				// Il processo puo' essere gia' terminato
+				m.logger.Debug("Failed to move escaped process back", "uid", uid, "pid", pid, "error", err)
+				failed++
+				continue
+			}
+			moved++
+		}
+
+		m.logger.Info("Escaped processes moved back to user cgroup",
+			"uid", uid,
+			"username", m.getUsername(uid),
+			"moved", moved,
+			"failed", failed,
+			"pids", pids,
+		)
+		if m.prometheusExporter != nil {
+			m.prometheusExporter.RecordProcessEscapes(uid, m.getUsername(uid), moved, failed)
+		}
+	}
+}
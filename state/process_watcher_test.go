@@ -0,0 +1,110 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fdefilippo/resman/config"
+)
+
+// escapingCgroupManager simula processi di utenti limitati nati fuori dal loro cgroup.
+type escapingCgroupManager struct {
+	mockCgroupManager
+	escaped map[int][]int
+	targets map[int]string
+	moved   map[int]string // pid -> parent path
+	during  func()         // eseguita durante la scansione di /proc
+}
+
+func (e *escapingCgroupManager) FindEscapedProcesses(targets map[int]string) map[int][]int {
+	e.targets = targets
+	if e.during != nil {
+		e.during()
+	}
+	return e.escaped
+}
+
+func (e *escapingCgroupManager) MoveProcessToSharedCgroup(pid int, sharedPath string, uid int) error {
+	e.moved[pid] = sharedPath
+	return nil
+}
+
+func TestSweepEscapedProcesses(t *testing.T) {
+	cfg := config.DefaultConfig()
+	sharedPath := t.TempDir()
+	groupPath := filepath.Join(sharedPath, "batch")
+	for _, dir := range []string{filepath.Join(sharedPath, "user_1000"), filepath.Join(groupPath, "user_1001")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cgroups := &escapingCgroupManager{
+		escaped: map[int][]int{1000: {4242}, 1001: {4343}},
+		moved:   make(map[int]string),
+	}
+	manager, err := NewManager(cfg, &mockMetricsCollector{}, cgroups, &mockPrometheusExporter{})
+	if err != nil {
+		t.Fatalf("NewManager() error: %v", err)
+	}
+	manager.mu.Lock()
+	manager.sharedCgroupPath = sharedPath
+	manager.activeUsers[1000] = true
+	manager.activeUsers[1001] = true
+	manager.activeUsers[1002] = true // sottocgroup non ancora creato
+	manager.userCgroupParent[1001] = groupPath
+	manager.mu.Unlock()
+
+	manager.sweepEscapedProcesses()
+
+	if len(cgroups.targets) != 2 || cgroups.targets[1001] != filepath.Join(groupPath, "user_1001") {
+		t.Errorf("sweep targets = %v, expected users 1000 and 1001 only", cgroups.targets)
+	}
+	if cgroups.moved[4242] != sharedPath || cgroups.moved[4343] != groupPath {
+		t.Errorf("escaped processes moved to %v, expected their parent cgroups", cgroups.moved)
+	}
+
+	// La scansione non tiene opMu; un utente rilasciato nel frattempo non
+	// viene riportato nel cgroup condiviso
+	cgroups.moved = make(map[int]string)
+	cgroups.during = func() {
+		if !manager.opMu.TryLock() {
+			t.Error("/proc scan should not hold opMu")
+		} else {
+			manager.opMu.Unlock()
+		}
+		manager.mu.Lock()
+		delete(manager.activeUsers, 1001)
+		manager.mu.Unlock()
+	}
+	manager.sweepEscapedProcesses()
+	if _, ok := cgroups.moved[4343]; ok || cgroups.moved[4242] != sharedPath {
+		t.Errorf("moves after a release during the scan = %v, expected only pid 4242", cgroups.moved)
+	}
+	cgroups.during = nil
+
+	// Watcher disabilitato: nessuno sweep
+	cfg.ProcessWatcherEnabled = false
+	cgroups.targets = nil
+	manager.sweepEscapedProcesses()
+	if cgroups.targets != nil {
+		t.Error("disabled process watcher should not sweep /proc")
+	}
+}
@@ -0,0 +1,704 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+// state/resource_limits.go
+package state
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fdefilippo/resman/config"
+	resmanmetrics "github.com/fdefilippo/resman/metrics"
+)
+
+// Resource identifica una risorsa con una propria macchina a stati di attivazione.
+type Resource string
+
+const (
+	ResourceCPU Resource = "cpu"
+	ResourceRAM Resource = "ram"
+	ResourceIO  Resource = "io"
+)
+
+// Resources elenca le risorse nell'ordine in cui vengono valutate e riportate.
+var Resources = []Resource{ResourceCPU, ResourceRAM, ResourceIO}
+
+// Decisioni possibili, globali e per risorsa
+const (
+	decisionActivate   = "ACTIVATE_LIMITS"
+	decisionMaintain   = "MAINTAIN_CURRENT_STATE"
+	decisionDeactivate = "DEACTIVATE_LIMITS"
+)
+
+// resourceStableCycles e' il numero di campioni consecutivi sotto la soglia di
+// rilascio richiesti prima di rilasciare RAM o IO (~90 secondi con il polling
+// di default). La CPU usa la stabilita' per utente (UserStabilityTracker).
+const resourceStableCycles = 3
+
+// reasonResourceDisabled e' la ragione riportata per una risorsa non abilitata:
+// non viene inclusa nella ragione globale del ciclo.
+const reasonResourceDisabled = "disabled"
+
+// resourceLimiter e' la macchina a stati di una risorsa:
+// inattiva -> (soglia superata per la durata richiesta) -> attiva ->
+// (tempo minimo trascorso e stabile sotto la soglia di rilascio) -> inattiva.
+// Ogni risorsa scrive e ripristina solo i propri file di controllo.
+type resourceLimiter struct {
+	resource     Resource
+	active       bool
+	appliedTime  time.Time
+	tracker      *ThresholdTracker
+	stableCycles int
+	enabled      bool    // ultima valutazione: risorsa abilitata e misurabile
+	usage        float64 // ultimo utilizzo percentuale misurato
+	lastDecision string
+	lastReason   string
+}
+
+// resourceDecision e' la decisione presa per una risorsa in un ciclo.
+type resourceDecision struct {
+	resource Resource
+	decision string
+	reason   string
+}
+
+// limiterLocked restituisce la macchina a stati della risorsa, creandola se
+// manca. Chiamare con m.mu acquisito in scrittura.
+func (m *Manager) limiterLocked(res Resource) *resourceLimiter {
+	if m.limiters == nil {
+		m.limiters = make(map[Resource]*resourceLimiter)
+	}
+	l, ok := m.limiters[res]
+	if !ok {
+		l = &resourceLimiter{resource: res}
+		m.limiters[res] = l
+	}
+	if l.tracker == nil {
+		// CPU e IO riusano i tracker storici del manager
+		switch res {
+		case ResourceCPU:
+			if m.thresholdTracker == nil {
+				m.thresholdTracker = &ThresholdTracker{}
+			}
+			l.tracker = m.thresholdTracker
+		case ResourceIO:
+			if m.ioThresholdTracker == nil {
+				m.ioThresholdTracker = &ThresholdTracker{}
+			}
+			l.tracker = m.ioThresholdTracker
+		default:
+			l.tracker = &ThresholdTracker{}
+		}
+	}
+	return l
+}
+
+// isResourceActive indica se i limiti della risorsa sono attivi.
+func (m *Manager) isResourceActive(res Resource) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	l, ok := m.limiters[res]
+	return ok && l.active
+}
+
+// anyResourceActive indica se almeno una risorsa ha i limiti attivi: finche'
+// e' vero gli utenti restano nel cgroup condiviso.
+func (m *Manager) anyResourceActive() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, l := range m.limiters {
+		if l.active {
+			return true
+		}
+	}
+	return false
+}
+
+// setResourceActive cambia lo stato della risorsa e registra la transizione.
+func (m *Manager) setResourceActive(res Resource, active bool) {
+	m.mu.Lock()
+	l := m.limiterLocked(res)
+	changed := l.active != active
+	if changed {
+		l.active = active
+		l.stableCycles = 0
+		if active {
+			l.appliedTime = time.Now()
+		} else {
+			l.appliedTime = time.Time{}
+		}
+	}
+	m.mu.Unlock()
+
+	if !changed {
+		return
+	}
+	if m.prometheusExporter != nil {
+		m.prometheusExporter.RecordResourceLimitState(string(res), active)
+	}
+	if m.logger != nil {
+		m.logger.Info("Resource limit state changed",
+			"resource", res,
+			"active", active,
+		)
+	}
+}
+
+// resourceUsage restituisce l'utilizzo percentuale della risorsa da parte
+// degli utenti limitabili. ok=false se la risorsa e' disabilitata o non misurabile.
+func resourceUsage(cfg *config.Config, res Resource, metrics *SystemMetrics) (float64, bool) {
+	switch res {
+	case ResourceRAM:
+		if !cfg.RAMEnabled || cfg.RAMThreshold <= 0 || metrics.TotalMemoryMB <= 0 {
+			return 0, false
+		}
+		limitedRAMMB := float64(metrics.LimitedUsersRAMUsageBytes) / (1024 * 1024)
+		return (limitedRAMMB / metrics.TotalMemoryMB) * 100, true
+	case ResourceIO:
+		if !cfg.IOEnabled || cfg.IOThreshold <= 0 || cfg.IOWriteBPS == "" || cfg.IOWriteBPS == "max" {
+			return 0, false
+		}
+		writeLimit, err := config.ParseRAMQuota(cfg.IOWriteBPS)
+		if err != nil || writeLimit == 0 {
+			return 0, false
+		}
+		totalWriteLimit := writeLimit * uint64(metrics.LimitedUsersCount)
+		if totalWriteLimit == 0 {
+			return 0, true
+		}
+		return float64(metrics.LimitedUsersIOWriteBytes) / float64(totalWriteLimit) * 100, true
+	default:
+		return metrics.LimitedUsersCPUUsage, true
+	}
+}
+
+// resourceThresholds restituisce soglia di attivazione, soglia di rilascio,
+// durata minima sopra soglia e tempo minimo di attivazione della risorsa.
+func resourceThresholds(cfg *config.Config, res Resource) (threshold, release int, duration, minActive time.Duration) {
+	switch res {
+	case ResourceRAM:
+		return cfg.RAMThreshold, cfg.RAMReleaseThreshold,
+			time.Duration(cfg.GetRAMThresholdDuration()) * time.Second,
+			time.Duration(cfg.GetRAMMinActiveTime()) * time.Second
+	case ResourceIO:
+		return cfg.IOThreshold, cfg.IOReleaseThreshold,
+			time.Duration(cfg.GetIOThresholdDuration()) * time.Second,
+			time.Duration(cfg.GetIOMinActiveTime()) * time.Second
+	default:
+		return cfg.GetCPUThreshold(), cfg.GetCPUReleaseThreshold(),
+			time.Duration(cfg.GetCPUThresholdDuration()) * time.Second,
+			time.Duration(cfg.GetMinActiveTime()) * time.Second
+	}
+}
+
+// makeResourceDecisions valuta separatamente la macchina a stati di ogni risorsa.
+func (m *Manager) makeResourceDecisions(metrics *SystemMetrics) []resourceDecision {
+	cfg := m.GetConfig()
+	decisions := make([]resourceDecision, 0, len(Resources))
+	for _, res := range Resources {
+		decisions = append(decisions, m.decideResource(cfg, res, metrics))
+	}
+	return decisions
+}
+
+func (m *Manager) decideResource(cfg *config.Config, res Resource, metrics *SystemMetrics) resourceDecision {
+	usage, enabled := resourceUsage(cfg, res, metrics)
+
+	m.mu.Lock()
+	l := m.limiterLocked(res)
+	l.enabled = enabled
+	l.usage = usage
+	active := l.active
+	appliedTime := l.appliedTime
+	tracker := l.tracker
+	m.mu.Unlock()
+
+	var decision, reason string
+	if active {
+		decision, reason = m.decideActiveResource(cfg, res, metrics, usage, enabled, appliedTime, tracker)
+	} else {
+		decision, reason = m.decideInactiveResource(cfg, res, metrics, usage, enabled, tracker)
+	}
+
+	m.mu.Lock()
+	l.lastDecision = decision
+	l.lastReason = reason
+	m.mu.Unlock()
+
+	return resourceDecision{resource: res, decision: decision, reason: reason}
+}
+
+// decideInactiveResource decide se attivare i limiti di una risorsa inattiva.
+func (m *Manager) decideInactiveResource(cfg *config.Config, res Resource, metrics *SystemMetrics, usage float64, enabled bool, tracker *ThresholdTracker) (string, string) {
+	threshold, _, duration, _ := resourceThresholds(cfg, res)
+
+	if !enabled {
+		tracker.Reset()
+		return decisionMaintain, reasonResourceDisabled
+	}
+	if usage < float64(threshold) {
+		tracker.Reset()
+		return decisionMaintain, "within normal range"
+	}
+
+	// Verifica che ci siano abbastanza core per il sistema
+	if minSystemCores := cfg.GetMinSystemCores(); metrics.TotalCores <= minSystemCores {
+		tracker.Reset()
+		return decisionMaintain, fmt.Sprintf(
+			"threshold exceeded but insufficient cores (%d <= %d)",
+			metrics.TotalCores, minSystemCores,
+		)
+	}
+
+	// Verifica se dobbiamo ignorare il load average
+	if !cfg.GetIgnoreSystemLoad() && metrics.SystemUnderLoad {
+		tracker.Reset()
+		return decisionMaintain, "threshold exceeded but system already under load from other factors"
+	}
+
+	// Verifica time window della risorsa, se configurata
+	if duration > 0 && !tracker.ShouldActivateLimits(usage, float64(threshold), duration) {
+		remaining := duration - tracker.GetElapsed()
+		return decisionMaintain, fmt.Sprintf(
+			"threshold exceeded, waiting %s before activating limits (%.1f%% >= %d%%)",
+			remaining.Round(time.Second), usage, threshold,
+		)
+	}
+
+	return decisionActivate, fmt.Sprintf("threshold exceeded (%.1f%% >= %d%%)", usage, threshold)
+}
+
+// decideActiveResource decide se rilasciare i limiti di una risorsa attiva.
+func (m *Manager) decideActiveResource(cfg *config.Config, res Resource, metrics *SystemMetrics, usage float64, enabled bool, appliedTime time.Time, tracker *ThresholdTracker) (string, string) {
+	_, release, _, minActive := resourceThresholds(cfg, res)
+
+	// Risorsa disabilitata a caldo: rilascia subito i suoi limiti
+	if !enabled {
+		tracker.Reset()
+		return decisionDeactivate, "disabled in configuration"
+	}
+
+	// Verifica il tempo minimo di attivazione
+	if time.Since(appliedTime) < minActive {
+		return decisionMaintain, "limits active, waiting for minimum activation time"
+	}
+
+	if usage >= float64(release) {
+		m.resetResourceStability(res)
+		return decisionMaintain, fmt.Sprintf("limits active, still above release threshold (%.1f%% >= %d%%)", usage, release)
+	}
+
+	if !m.resourceStable(res, release) {
+		return decisionMaintain, "below release threshold but waiting for stability (cool-down period)"
+	}
+	if metrics.SystemUnderLoad {
+		return decisionMaintain, "below release threshold but system still under load"
+	}
+
+	tracker.Reset()
+	return decisionDeactivate, fmt.Sprintf("below release threshold (%.1f%% < %d%%)", usage, release)
+}
+
+// resourceStable verifica che la risorsa sia rimasta sotto la soglia di
+// rilascio abbastanza a lungo (evita rilasci nervosi per singoli campioni).
+func (m *Manager) resourceStable(res Resource, release int) bool {
+	if res == ResourceCPU {
+		return m.cpuUsersStable(release)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l := m.limiterLocked(res)
+	l.stableCycles++
+	return l.stableCycles >= resourceStableCycles
+}
+
+func (m *Manager) resetResourceStability(res Resource) {
+	m.mu.Lock()
+	m.limiterLocked(res).stableCycles = 0
+	m.mu.Unlock()
+}
+
+// cpuUsersStable richiede che tutti gli utenti limitati abbiano la EMA della CPU
+// sotto la soglia di rilascio per almeno 3 campionamenti consecutivi.
+func (m *Manager) cpuUsersStable(cpuReleaseThreshold int) bool {
+	if m.stabilityTracker == nil {
+		m.stabilityTracker = &UserStabilityTracker{underThreshold: make(map[int]int)}
+	}
+
+	m.stabilityTracker.mu.Lock()
+	defer m.stabilityTracker.mu.Unlock()
+
+	var limitedUsers []int
+	allUserMetrics := make(map[int]*resmanmetrics.UserMetrics)
+	if m.metricsCollector != nil {
+		limitedUsers = m.metricsCollector.GetLimitedUsers()
+		allUserMetrics = m.metricsCollector.GetAllUserMetrics()
+	}
+
+	for _, uid := range limitedUsers {
+		if um, ok := allUserMetrics[uid]; ok {
+			if um.CPUUsageEMA < float64(cpuReleaseThreshold) {
+				m.stabilityTracker.underThreshold[uid]++
+			} else {
+				m.stabilityTracker.underThreshold[uid] = 0
+			}
+		}
+	}
+
+	for _, uid := range limitedUsers {
+		if m.stabilityTracker.underThreshold[uid] < 3 {
+			return false
+		}
+	}
+	return true
+}
+
+// summarizeResourceDecisions riduce le decisioni per risorsa alla decisione
+// globale del ciclo (storico, log): ACTIVATE se almeno una risorsa si attiva,
+// DEACTIVATE se almeno una si rilascia, altrimenti MAINTAIN.
+func summarizeResourceDecisions(decisions []resourceDecision) (string, string) {
+	decision := decisionMaintain
+	reasons := make([]string, 0, len(decisions))
+	for _, d := range decisions {
+		switch d.decision {
+		case decisionActivate:
+			decision = decisionActivate
+		case decisionDeactivate:
+			if decision == decisionMaintain {
+				decision = decisionDeactivate
+			}
+		}
+		if d.reason == reasonResourceDisabled {
+			continue
+		}
+		reasons = append(reasons, fmt.Sprintf("%s: %s", strings.ToUpper(string(d.resource)), d.reason))
+	}
+	return decision, strings.Join(reasons, "; ")
+}
+
+// executeResourceDecisions applica le transizioni di ogni risorsa. Gli utenti
+// restano nel cgroup condiviso finche' almeno una risorsa e' attiva; ogni
+// risorsa scrive o ripristina solo i propri file di controllo.
+func (m *Manager) executeResourceDecisions(decisions []resourceDecision, metrics *SystemMetrics) error {
+	var toActivate, toDeactivate []Resource
+	for _, d := range decisions {
+		switch d.decision {
+		case decisionActivate:
+			toActivate = append(toActivate, d.resource)
+		case decisionDeactivate:
+			toDeactivate = append(toDeactivate, d.resource)
+		case decisionMaintain:
+		default:
+			return fmt.Errorf("unknown decision '%s' for %s: expected ACTIVATE_LIMITS, DEACTIVATE_LIMITS, or MAINTAIN_CURRENT_STATE", d.decision, d.resource)
+		}
+	}
+
+	if len(toActivate) == 0 && len(toDeactivate) == 0 {
+		// Risorse attive ma nessun utente nel cgroup condiviso (non c'erano
+		// utenti eleggibili all'attivazione): colloca quelli comparsi nel frattempo
+		m.mu.RLock()
+		placed := m.limitsActive
+		m.mu.RUnlock()
+		if !placed && len(m.targetedUsers(m.GetConfig(), metrics)) > 0 && m.anyResourceActive() {
+			return m.activateLimits(metrics)
+		}
+		// Controlla se ci sono utenti inattivi da rilasciare
+		return m.releaseIdleUsers(metrics)
+	}
+
+	// Prima le attivazioni: se una risorsa si attiva mentre un'altra si rilascia
+	// gli utenti restano nel cgroup condiviso senza essere spostati due volte
+	var firstError error
+	if len(toActivate) > 0 {
+		firstError = m.activateResources(toActivate, metrics)
+	}
+	if len(toDeactivate) > 0 {
+		if err := m.deactivateResources(toDeactivate); err != nil && firstError == nil {
+			firstError = err
+		}
+	}
+	return firstError
+}
+
+// activateResources attiva i limiti delle risorse indicate: gli utenti non
+// ancora limitati vengono spostati nel cgroup condiviso (e ricevono i file di
+// tutte le risorse attive), quelli gia' limitati ricevono solo i file delle
+// risorse appena attivate.
+func (m *Manager) activateResources(resources []Resource, metrics *SystemMetrics) error {
+	cfg := m.GetConfig()
+	alreadyLimited := m.getActiveUsersList()
+
+	for _, res := range resources {
+		m.setResourceActive(res, true)
+	}
+
+	err := m.activateLimits(metrics)
+
+	for _, res := range resources {
+		m.applyResourceLimits(cfg, res, metrics, alreadyLimited)
+	}
+	return err
+}
+
+// deactivateResources rilascia i limiti delle risorse indicate. Se nessuna
+// risorsa resta attiva gli utenti escono dal cgroup condiviso.
+func (m *Manager) deactivateResources(resources []Resource) error {
+	for _, res := range resources {
+		m.setResourceActive(res, false)
+	}
+
+	if !m.anyResourceActive() {
+		return m.deactivateLimits()
+	}
+
+	cfg := m.GetConfig()
+	users := m.getActiveUsersList()
+	for _, res := range resources {
+		m.removeResourceLimits(cfg, res, users)
+	}
+	return nil
+}
+
+// sharedCPUQuota calcola la quota cpu.max del cgroup condiviso
+// (tutti i core tranne MIN_SYSTEM_CORES).
+func sharedCPUQuota(cfg *config.Config, metrics *SystemMetrics) (string, int) {
+	availableCores := metrics.TotalCores - cfg.GetMinSystemCores()
+	if availableCores < 1 {
+		availableCores = 1
+	}
+	return fmt.Sprintf("%d 100000", availableCores*100000), availableCores
+}
+
+// minSliceCPUShare e' la quota minima (us per periodo di 100ms) di una slice:
+// il kernel rifiuta valori di cpu.max sotto 1000.
+const minSliceCPUShare = 1000
+
+// applySliceCPUShares divide la quota condivisa tra le slice degli utenti
+// limitati. Con CGROUP_MODE=systemd-slice il cgroup condiviso e' user.slice,
+// che contiene anche root, gli utenti esclusi e quelli mai limitati: la quota
+// va quindi sulle singole user-<uid>.slice. Gli utenti con cpu_max in
+// USER_OVERRIDES_FILE tengono il proprio valore.
+func (m *Manager) applySliceCPUShares(cfg *config.Config, metrics *SystemMetrics) {
+	if !m.cgroupManager.UsesSystemdSlices() || !m.isResourceActive(ResourceCPU) {
+		return
+	}
+	users := m.getActiveUsersList()
+	if len(users) == 0 {
+		return
+	}
+
+	quota := sliceCPUShare(cfg, metrics.TotalCores, len(users))
+	for _, uid := range users {
+		if override, _ := m.userOverrideFor(cfg, uid); override.CPUMax != "" {
+			continue
+		}
+		m.applyUserCPUMax(uid, quota)
+	}
+	m.logger.Debug("Shared CPU quota split between user slices",
+		"users", len(users), "quota", quota)
+}
+
+// sliceCPUShare e' il cpu.max della slice di ognuno degli users utenti limitati.
+func sliceCPUShare(cfg *config.Config, totalCores, users int) string {
+	_, availableCores := sharedCPUQuota(cfg, &SystemMetrics{TotalCores: totalCores})
+	if users < 1 {
+		users = 1
+	}
+	share := availableCores * 100000 / users
+	if share < minSliceCPUShare {
+		share = minSliceCPUShare
+	}
+	return fmt.Sprintf("%d 100000", share)
+}
+
+// limitedCPUMax e' il cpu.max che il limiter tiene sul cgroup di un utente
+// limitato: il CPU_MAX di USER_OVERRIDES_FILE, con le slice systemd la sua
+// parte della quota condivisa, altrimenti nessun limite (vale quello di
+// "limited").
+func (m *Manager) limitedCPUMax(cfg *config.Config, uid int) string {
+	if !m.isResourceActive(ResourceCPU) {
+		return "max 100000"
+	}
+	if override, _ := m.userOverrideFor(cfg, uid); override.CPUMax != "" {
+		return override.CPUMax
+	}
+	if m.cgroupManager.UsesSystemdSlices() {
+		m.mu.RLock()
+		users := len(m.activeUsers)
+		m.mu.RUnlock()
+		return sliceCPUShare(cfg, m.metricsCollector.GetTotalCores(), users)
+	}
+	return "max 100000"
+}
+
+// applyResourceLimits scrive i file di controllo della risorsa per gli utenti
+// indicati che sono ancora nel cgroup condiviso.
+func (m *Manager) applyResourceLimits(cfg *config.Config, res Resource, metrics *SystemMetrics, users []int) {
+	if res == ResourceCPU {
+		m.mu.RLock()
+		sharedPath := m.sharedCgroupPath
+		m.mu.RUnlock()
+		if sharedPath != "" && !m.cgroupManager.UsesSystemdSlices() {
+			quota, _ := sharedCPUQuota(cfg, metrics)
+			if err := m.cgroupManager.ApplySharedCPULimit(sharedPath, quota); err != nil {
+				m.logger.Warn("Failed to apply shared CPU limit",
+					"path", sharedPath, "quota", quota, "error", err)
+			}
+		}
+	}
+	if res == ResourceIO && cfg.GetIOLimitMode() == config.IOLimitModeWeight {
+		m.applySharedIOWeight(cfg.GetIOSharedWeight())
+	}
+
+	for _, uid := range users {
+		if !m.isUserLimited(uid) {
+			continue
+		}
+		override, _ := m.userOverrideFor(cfg, uid)
+		switch res {
+		case ResourceCPU:
+			m.applyUserCPULimits(cfg, uid, override)
+		case ResourceRAM:
+			if maxStr, highStr, ok := m.userRAMLimits(cfg, uid, override); ok {
+				m.applyUserRAMLimits(cfg, uid, maxStr, highStr)
+			}
+		case ResourceIO:
+			if readBPS, writeBPS, readIOPS, writeIOPS, ok := m.userIOLimits(cfg, uid, override); ok {
+				m.applyUserIOLimits(cfg, uid, readBPS, writeBPS, readIOPS, writeIOPS)
+			}
+		}
+	}
+	if res == ResourceCPU {
+		m.applySliceCPUShares(cfg, metrics)
+	}
+}
+
+// removeResourceLimits ripristina i file di controllo della risorsa lasciando
+// gli utenti nel cgroup condiviso (altre risorse sono ancora attive).
+func (m *Manager) removeResourceLimits(cfg *config.Config, res Resource, users []int) {
+	if res == ResourceCPU {
+		m.mu.RLock()
+		sharedPath := m.sharedCgroupPath
+		m.mu.RUnlock()
+		if sharedPath != "" && !m.cgroupManager.UsesSystemdSlices() {
+			if err := m.cgroupManager.ApplySharedCPULimit(sharedPath, "max 100000"); err != nil {
+				m.logger.Warn("Failed to remove shared CPU limit",
+					"path", sharedPath, "error", err)
+			}
+		}
+	}
+	ioWeightMode := cfg.GetIOLimitMode() == config.IOLimitModeWeight
+	if res == ResourceIO && ioWeightMode {
+		m.applySharedIOWeight(defaultIOWeight)
+	}
+
+	for _, uid := range users {
+		override, _ := m.userOverrideFor(cfg, uid)
+		switch res {
+		case ResourceCPU:
+			if err := m.cgroupManager.ApplyCPUWeight(uid, defaultCPUWeight); err != nil {
+				m.logger.Warn("Failed to reset CPU weight for user",
+					"uid", uid, "error", err)
+			}
+			// Con le slice systemd cpu.max porta anche la parte della quota condivisa
+			if override.CPUMax != "" || m.cgroupManager.UsesSystemdSlices() {
+				m.applyUserCPUMax(uid, "max 100000")
+			}
+		case ResourceRAM:
+			if _, _, ok := m.userRAMLimits(cfg, uid, override); !ok {
+				continue
+			}
+			if err := m.cgroupManager.RemoveRAMHigh(uid); err != nil {
+				m.logger.Warn("Failed to remove RAM high limit for user",
+					"uid", uid, "error", err)
+			}
+			if err := m.cgroupManager.RemoveRAMLimit(uid); err != nil {
+				m.logger.Warn("Failed to remove RAM limit for user",
+					"uid", uid, "error", err)
+			}
+			if hasSwapLimits(cfg, override) {
+				if err := m.cgroupManager.RemoveSwapLimits(uid); err != nil {
+					m.logger.Warn("Failed to remove swap limits for user",
+						"uid", uid, "error", err)
+				}
+			}
+		case ResourceIO:
+			if _, _, _, _, ok := m.userIOLimits(cfg, uid, override); !ok {
+				continue
+			}
+			if ioWeightMode {
+				m.removeUserIOWeight(cfg, uid)
+				continue
+			}
+			if err := m.cgroupManager.RemoveIOLimit(uid); err != nil {
+				m.logger.Warn("Failed to remove IO limit for user",
+					"uid", uid, "error", err)
+			}
+		}
+	}
+
+	m.logger.Info("Resource limits removed, users stay in shared cgroup",
+		"resource", res,
+		"users", len(users),
+	)
+}
+
+// enabledResources restituisce le risorse abilitate in configurazione.
+func enabledResources(cfg *config.Config) []Resource {
+	resources := []Resource{ResourceCPU}
+	if cfg.RAMEnabled {
+		resources = append(resources, ResourceRAM)
+	}
+	if cfg.IOEnabled {
+		resources = append(resources, ResourceIO)
+	}
+	return resources
+}
+
+// getResourceStates restituisce lo stato della macchina a stati di ogni risorsa.
+func (m *Manager) getResourceStates() map[string]map[string]interface{} {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	states := make(map[string]map[string]interface{}, len(Resources))
+	for _, res := range Resources {
+		state := map[string]interface{}{
+			"active":        false,
+			"enabled":       false,
+			"applied_time":  "",
+			"usage_percent": 0.0,
+			"stable_cycles": 0,
+			"last_decision": "",
+			"last_reason":   "",
+		}
+		if l, ok := m.limiters[res]; ok {
+			state["active"] = l.active
+			state["enabled"] = l.enabled
+			if !l.appliedTime.IsZero() {
+				state["applied_time"] = l.appliedTime.Format(time.RFC3339)
+			}
+			state["usage_percent"] = l.usage
+			state["stable_cycles"] = l.stableCycles
+			state["last_decision"] = l.lastDecision
+			state["last_reason"] = l.lastReason
+		}
+		states[string(res)] = state
+	}
+	return states
+}
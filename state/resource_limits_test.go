@@ -0,0 +1,231 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+package state
+
+import (
+	"testing"
+
+	"github.com/fdefilippo/resman/config"
+)
+
+func decisionFor(decisions []resourceDecision, res Resource) string {
+	for _, d := range decisions {
+		if d.resource == res {
+			return d.decision
+		}
+	}
+	return ""
+}
+
+func TestResourceDecisionsIndependent(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.CPUThreshold = 75
+	cfg.CPUReleaseThreshold = 40
+	cfg.CPUThresholdDuration = 0
+	cfg.IOEnabled = true
+	cfg.IOThreshold = 80
+	cfg.IOReleaseThreshold = 50
+	cfg.IOWriteBPS = "10M"
+
+	manager := &Manager{
+		cfg: cfg,
+		limiters: map[Resource]*resourceLimiter{
+			ResourceIO: {resource: ResourceIO, active: true},
+		},
+	}
+
+	// CPU sopra soglia, IO attivo ma sotto la soglia di rilascio
+	metrics := &SystemMetrics{
+		LimitedUsersCPUUsage:     80.0,
+		LimitedUsersCount:        1,
+		LimitedUsersIOWriteBytes: 1024 * 1024, // 10% di 10M
+		TotalCores:               4,
+	}
+
+	for cycle := 1; cycle < resourceStableCycles; cycle++ {
+		decisions := manager.makeResourceDecisions(metrics)
+		if got := decisionFor(decisions, ResourceCPU); got != decisionActivate {
+			t.Errorf("cycle %d: CPU decision = %s, expected %s", cycle, got, decisionActivate)
+		}
+		if got := decisionFor(decisions, ResourceIO); got != decisionMaintain {
+			t.Errorf("cycle %d: IO decision = %s, expected %s (cool-down)", cycle, got, decisionMaintain)
+		}
+		if got := decisionFor(decisions, ResourceRAM); got != decisionMaintain {
+			t.Errorf("cycle %d: RAM decision = %s, expected %s (disabled)", cycle, got, decisionMaintain)
+		}
+	}
+
+	decisions := manager.makeResourceDecisions(metrics)
+	if got := decisionFor(decisions, ResourceIO); got != decisionDeactivate {
+		t.Errorf("IO decision after %d stable cycles = %s, expected %s", resourceStableCycles, got, decisionDeactivate)
+	}
+
+	// La decisione globale riporta l'attivazione CPU
+	decision, reason := summarizeResourceDecisions(decisions)
+	if decision != decisionActivate {
+		t.Errorf("summary decision = %s, expected %s", decision, decisionActivate)
+	}
+	if reason == "" {
+		t.Error("summary should include per-resource reasons")
+	}
+}
+
+func TestDeactivateSingleResource(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.RAMEnabled = true
+
+	manager, err := NewManager(cfg, &mockMetricsCollector{}, &mockCgroupManager{}, &mockPrometheusExporter{})
+	if err != nil {
+		t.Fatalf("NewManager() error: %v", err)
+	}
+	manager.setResourceActive(ResourceCPU, true)
+	manager.setResourceActive(ResourceRAM, true)
+	manager.limitsActive = true
+	manager.activeUsers[1000] = true
+
+	// Rilasciare la RAM lascia attiva la CPU e gli utenti nel cgroup condiviso
+	if err := manager.deactivateResources([]Resource{ResourceRAM}); err != nil {
+		t.Fatalf("deactivateResources(ram) error: %v", err)
+	}
+	if manager.isResourceActive(ResourceRAM) {
+		t.Error("RAM should be inactive")
+	}
+	if !manager.isResourceActive(ResourceCPU) {
+		t.Error("CPU should still be active")
+	}
+	if !manager.isUserLimited(1000) {
+		t.Error("user should stay in the shared cgroup while CPU limits are active")
+	}
+
+	// Rilasciare l'ultima risorsa attiva libera gli utenti
+	if err := manager.deactivateResources([]Resource{ResourceCPU}); err != nil {
+		t.Fatalf("deactivateResources(cpu) error: %v", err)
+	}
+	if manager.anyResourceActive() {
+		t.Error("no resource should be active")
+	}
+	if manager.isUserLimited(1000) {
+		t.Error("user should be released when no resource is active")
+	}
+
+	status := manager.GetStatus()
+	if active, ok := status["cpu_limits_active"].(bool); !ok || active {
+		t.Errorf("GetStatus() cpu_limits_active = %v, expected false", status["cpu_limits_active"])
+	}
+	if _, ok := status["resource_limits"]; !ok {
+		t.Error("GetStatus() should include resource_limits")
+	}
+}
+
+func TestUserSwapLimits(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.RAMSwapMax = "50%"
+	cfg.RAMSwapHigh = "25%"
+
+	swapMax, swapHigh, zswapMax, ok := userSwapLimits(cfg, config.UserOverride{}, "4G")
+	if !ok || swapMax != "2147483648" || swapHigh != "1073741824" || zswapMax != "" {
+		t.Errorf("userSwapLimits() = %q, %q, %q, %v", swapMax, swapHigh, zswapMax, ok)
+	}
+
+	// Percentuali senza memory.max finito: nessun limite
+	if _, _, _, ok := userSwapLimits(cfg, config.UserOverride{}, "max"); ok {
+		t.Error("percent swap limits should be skipped without a finite memory.max")
+	}
+
+	// DISABLE_SWAP scrive gia' swap.max=0; l'override dell'utente ha la precedenza
+	cfg.DisableSwap = true
+	swapMax, _, _, _ = userSwapLimits(cfg, config.UserOverride{}, "4G")
+	if swapMax != "" {
+		t.Errorf("swap.max with DISABLE_SWAP = %q, expected it left to the RAM limits", swapMax)
+	}
+	swapMax, _, zswapMax, _ = userSwapLimits(cfg, config.UserOverride{SwapMax: "1G", ZswapMax: "256M"}, "4G")
+	if swapMax != "1G" || zswapMax != "256M" {
+		t.Errorf("override swap limits = %q, %q; expected 1G, 256M", swapMax, zswapMax)
+	}
+	if !hasSwapLimits(cfg, config.UserOverride{}) {
+		t.Error("DISABLE_SWAP should count as a swap limit to reset")
+	}
+}
+
+// sliceCgroupManager simula CGROUP_MODE=systemd-slice e registra i cpu.max scritti.
+type sliceCgroupManager struct {
+	mockCgroupManager
+	sharedWrites int
+	cpuMax       map[int]string
+}
+
+func (s *sliceCgroupManager) UsesSystemdSlices() bool { return true }
+
+func (s *sliceCgroupManager) ApplySharedCPULimit(path string, quota string) error {
+	s.sharedWrites++
+	return nil
+}
+
+func (s *sliceCgroupManager) ApplySharedIOWeight(sharedPath string, weight int) error {
+	s.sharedWrites++
+	return nil
+}
+
+func (s *sliceCgroupManager) ApplyUserSubCgroupCPULimit(uid int, quota string) error {
+	s.cpuMax[uid] = quota
+	return nil
+}
+
+func TestSliceModeSplitsSharedCPUQuota(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.MinSystemCores = 1
+	cfg.IOEnabled = true
+	cfg.IOLimitMode = config.IOLimitModeWeight
+
+	slices := &sliceCgroupManager{cpuMax: make(map[int]string)}
+	manager, err := NewManager(cfg, &mockMetricsCollector{}, slices, &mockPrometheusExporter{})
+	if err != nil {
+		t.Fatalf("NewManager() error: %v", err)
+	}
+	manager.setResourceActive(ResourceCPU, true)
+	manager.setResourceActive(ResourceIO, true)
+	manager.limitsActive = true
+	manager.sharedCgroupPath = "/sys/fs/cgroup/user.slice"
+	manager.activeUsers[1000] = true
+	manager.activeUsers[1001] = true
+
+	// 8 core disponibili divisi tra due slice; user.slice non viene toccata
+	metrics := &SystemMetrics{TotalCores: 9}
+	manager.applyResourceLimits(cfg, ResourceCPU, metrics, []int{1000, 1001})
+	manager.applyResourceLimits(cfg, ResourceIO, metrics, []int{1000, 1001})
+	if slices.sharedWrites != 0 {
+		t.Errorf("user.slice written %d times, expected none", slices.sharedWrites)
+	}
+	for _, uid := range []int{1000, 1001} {
+		if got := slices.cpuMax[uid]; got != "400000 100000" {
+			t.Errorf("cpu.max of user-%d.slice = %q, expected 400000 100000", uid, got)
+		}
+	}
+
+	// Un utente rilasciato lascia la sua parte agli altri
+	delete(manager.activeUsers, 1001)
+	manager.applySliceCPUShares(cfg, metrics)
+	if got := slices.cpuMax[1000]; got != "800000 100000" {
+		t.Errorf("cpu.max of user-1000.slice = %q after release, expected 800000 100000", got)
+	}
+
+	manager.removeResourceLimits(cfg, ResourceCPU, []int{1000})
+	if slices.sharedWrites != 0 || slices.cpuMax[1000] != "max 100000" {
+		t.Errorf("after CPU release: user.slice writes %d, cpu.max %q; expected 0, max 100000",
+			slices.sharedWrites, slices.cpuMax[1000])
+	}
+}
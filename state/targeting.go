@@ -0,0 +1,231 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+// state/targeting.go
+package state
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/fdefilippo/resman/config"
+)
+
+// limitTarget e' un utente selezionato per i limiti con il motivo della selezione.
+type limitTarget struct {
+	share  float64 // % della CPU aggregata degli utenti eleggibili
+	reason string
+}
+
+// TargetedUser e' un utente limitato, con il motivo della selezione, registrato
+// nello storico dei cicli di controllo.
+type TargetedUser struct {
+	UID      int     `json:"uid"`
+	Username string  `json:"username"`
+	CPUShare float64 `json:"cpu_share"`
+	Reason   string  `json:"reason"`
+}
+
+// cpuShares restituisce la quota di ogni utente eleggibile sulla CPU aggregata
+// degli utenti eleggibili e gli UID ordinati per consumo decrescente.
+func cpuShares(metrics *SystemMetrics) (map[int]float64, []int) {
+	shares := make(map[int]float64, len(metrics.EligibleUsers))
+	ranked := make([]int, 0, len(metrics.EligibleUsers))
+
+	var total float64
+	for _, uid := range metrics.EligibleUsers {
+		total += metrics.UserCPUUsage[uid]
+	}
+	for _, uid := range metrics.EligibleUsers {
+		share := 0.0
+		if total > 0 {
+			share = metrics.UserCPUUsage[uid] / total * 100
+		}
+		shares[uid] = share
+		ranked = append(ranked, uid)
+	}
+
+	sort.Slice(ranked, func(i, j int) bool {
+		if shares[ranked[i]] != shares[ranked[j]] {
+			return shares[ranked[i]] > shares[ranked[j]]
+		}
+		return ranked[i] < ranked[j]
+	})
+	return shares, ranked
+}
+
+// selectLimitTargets calcola gli utenti da limitare nel ciclo corrente.
+//
+// Con LIMIT_TARGET_MODE=all sono selezionati tutti gli utenti eleggibili.
+// Negli altri modi un utente entra quando la sua quota raggiunge la soglia di
+// ingresso (LIMIT_TARGET_MIN_SHARE in above_share, la quota dell'N-esimo
+// utente in top_n) ed esce solo quando scende di LIMIT_TARGET_HYSTERESIS punti
+// sotto di essa: gli utenti entrano ed escono singolarmente senza oscillare.
+// Gli utenti a 0% non vengono mai selezionati.
+func selectLimitTargets(cfg *config.Config, metrics *SystemMetrics, previous map[int]limitTarget) map[int]limitTarget {
+	shares, ranked := cpuShares(metrics)
+	targets := make(map[int]limitTarget, len(ranked))
+
+	mode := cfg.GetLimitTargetMode()
+	if mode == config.LimitTargetAll {
+		for _, uid := range ranked {
+			targets[uid] = limitTarget{
+				share:  shares[uid],
+				reason: fmt.Sprintf("eligible user (%.1f%% of limited-user CPU)", shares[uid]),
+			}
+		}
+		return targets
+	}
+
+	hysteresis := float64(cfg.GetLimitTargetHysteresis())
+	topN := cfg.GetLimitTargetTopN()
+
+	// Soglia di ingresso
+	entryBar := float64(cfg.GetLimitTargetMinShare())
+	if mode == config.LimitTargetTopN {
+		entryBar = 0
+		if len(ranked) > topN {
+			entryBar = shares[ranked[topN-1]]
+		}
+	}
+	exitBar := entryBar - hysteresis
+
+	for rank, uid := range ranked {
+		share := shares[uid]
+		if share <= 0 {
+			break
+		}
+
+		var reason string
+		switch {
+		case mode == config.LimitTargetTopN && rank < topN:
+			reason = fmt.Sprintf("top %d CPU user (rank %d, %.1f%% of limited-user CPU)", topN, rank+1, share)
+		case mode == config.LimitTargetAboveShare && share >= entryBar:
+			reason = fmt.Sprintf("%.1f%% of limited-user CPU >= %.0f%%", share, entryBar)
+		default:
+			if _, wasTarget := previous[uid]; wasTarget && share >= exitBar {
+				reason = fmt.Sprintf("kept by hysteresis (%.1f%% of limited-user CPU, released below %.1f%%)", share, exitBar)
+			}
+		}
+
+		if reason != "" {
+			targets[uid] = limitTarget{share: share, reason: reason}
+		}
+	}
+	return targets
+}
+
+// updateLimitTargets ricalcola la selezione degli utenti da limitare.
+func (m *Manager) updateLimitTargets(cfg *config.Config, metrics *SystemMetrics) {
+	if metrics == nil {
+		return
+	}
+
+	m.mu.RLock()
+	previous := m.limitTargets
+	m.mu.RUnlock()
+
+	targets := selectLimitTargets(cfg, metrics, previous)
+
+	m.mu.Lock()
+	m.limitTargets = targets
+	m.mu.Unlock()
+
+	if cfg.GetLimitTargetMode() == config.LimitTargetAll || m.logger == nil {
+		return
+	}
+	for uid, target := range targets {
+		if _, ok := previous[uid]; !ok {
+			m.logger.Info("User selected for limiting",
+				"uid", uid,
+				"username", m.getUsername(uid),
+				"reason", target.reason,
+			)
+		}
+	}
+	for uid := range previous {
+		if _, ok := targets[uid]; !ok {
+			m.logger.Info("User no longer selected for limiting",
+				"uid", uid,
+				"username", m.getUsername(uid),
+				"mode", cfg.GetLimitTargetMode(),
+			)
+		}
+	}
+}
+
+// targetedUsers restituisce gli utenti da collocare nel cgroup condiviso,
+// nell'ordine di metrics.EligibleUsers.
+func (m *Manager) targetedUsers(cfg *config.Config, metrics *SystemMetrics) []int {
+	if cfg.GetLimitTargetMode() == config.LimitTargetAll {
+		return metrics.EligibleUsers
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	users := make([]int, 0, len(m.limitTargets))
+	for _, uid := range metrics.EligibleUsers {
+		if _, ok := m.limitTargets[uid]; ok {
+			users = append(users, uid)
+		}
+	}
+	return users
+}
+
+// isLimitTarget indica se l'utente e' selezionato per i limiti.
+// Con LIMIT_TARGET_MODE=all ogni utente limitato resta selezionato.
+func (m *Manager) isLimitTarget(cfg *config.Config, uid int) bool {
+	if cfg.GetLimitTargetMode() == config.LimitTargetAll {
+		return true
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.limitTargets[uid]
+	return ok
+}
+
+// limitTargetReason restituisce il motivo per cui l'utente e' stato selezionato.
+func (m *Manager) limitTargetReason(uid int) string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.limitTargets[uid].reason
+}
+
+// getTargetedUsers restituisce gli utenti limitati con il motivo della selezione,
+// ordinati per quota di CPU decrescente.
+func (m *Manager) getTargetedUsers() []TargetedUser {
+	m.mu.RLock()
+	users := make([]TargetedUser, 0, len(m.activeUsers))
+	for uid := range m.activeUsers {
+		target, ok := m.limitTargets[uid]
+		if !ok {
+			continue
+		}
+		users = append(users, TargetedUser{UID: uid, CPUShare: target.share, Reason: target.reason})
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].CPUShare != users[j].CPUShare {
+			return users[i].CPUShare > users[j].CPUShare
+		}
+		return users[i].UID < users[j].UID
+	})
+	for i := range users {
+		users[i].Username = m.getUsername(users[i].UID)
+	}
+	return users
+}
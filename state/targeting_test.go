@@ -0,0 +1,106 @@
+/*
+ * Copyright (C) 2026 Francesco Defilippo
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program. If not, see <https://www.gnu.org/licenses/>.
+ */
+package state
+
+import (
+	"testing"
+
+	"github.com/fdefilippo/resman/config"
+)
+
+func targetMetrics(usage map[int]float64) *SystemMetrics {
+	metrics := &SystemMetrics{UserCPUUsage: usage}
+	for uid := range usage {
+		metrics.EligibleUsers = append(metrics.EligibleUsers, uid)
+	}
+	return metrics
+}
+
+func TestSelectLimitTargetsTopN(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.LimitTargetMode = config.LimitTargetTopN
+	cfg.LimitTargetTopN = 2
+	cfg.LimitTargetHysteresis = 5
+
+	// 1001 e 1002 sono i primi due, 1004 e' a 0% e non va mai limitato
+	targets := selectLimitTargets(cfg, targetMetrics(map[int]float64{
+		1001: 50, 1002: 30, 1003: 20, 1004: 0,
+	}), nil)
+	if len(targets) != 2 {
+		t.Fatalf("got %d targets, expected 2: %v", len(targets), targets)
+	}
+	for _, uid := range []int{1001, 1002} {
+		if targets[uid].reason == "" {
+			t.Errorf("uid %d should be selected with a reason", uid)
+		}
+	}
+
+	// 1003 supera 1002 di poco: 1002 resta selezionato per isteresi
+	targets = selectLimitTargets(cfg, targetMetrics(map[int]float64{
+		1001: 50, 1002: 23, 1003: 27,
+	}), targets)
+	if _, ok := targets[1002]; !ok {
+		t.Error("uid 1002 should be kept by hysteresis")
+	}
+	if _, ok := targets[1003]; !ok {
+		t.Error("uid 1003 should enter the top 2")
+	}
+
+	// 1002 scende oltre la banda di isteresi: esce
+	targets = selectLimitTargets(cfg, targetMetrics(map[int]float64{
+		1001: 50, 1002: 10, 1003: 40,
+	}), targets)
+	if _, ok := targets[1002]; ok {
+		t.Error("uid 1002 should leave the selection below the hysteresis band")
+	}
+}
+
+func TestSelectLimitTargetsAboveShare(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.LimitTargetMode = config.LimitTargetAboveShare
+	cfg.LimitTargetMinShare = 30
+	cfg.LimitTargetHysteresis = 10
+
+	targets := selectLimitTargets(cfg, targetMetrics(map[int]float64{
+		1001: 60, 1002: 25, 1003: 15,
+	}), nil)
+	if _, ok := targets[1001]; !ok || len(targets) != 1 {
+		t.Fatalf("only uid 1001 should be selected, got %v", targets)
+	}
+
+	// 1001 scende al 25%: sopra la soglia di uscita (30-10), resta selezionato
+	targets = selectLimitTargets(cfg, targetMetrics(map[int]float64{
+		1001: 25, 1002: 40, 1003: 35,
+	}), targets)
+	if _, ok := targets[1001]; !ok {
+		t.Error("uid 1001 should be kept by hysteresis")
+	}
+	if _, ok := targets[1002]; !ok {
+		t.Error("uid 1002 should be selected above the share threshold")
+	}
+}
+
+func TestSelectLimitTargetsAll(t *testing.T) {
+	cfg := config.DefaultConfig()
+
+	targets := selectLimitTargets(cfg, targetMetrics(map[int]float64{
+		1001: 10, 1002: 0,
+	}), nil)
+	if len(targets) != 2 {
+		t.Errorf("LIMIT_TARGET_MODE=all should select every eligible user, got %v", targets)
+	}
+}